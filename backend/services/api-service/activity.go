@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Activity event types recorded in workspaces/{id}/activity.
+const (
+	activityReadOnlyChanged = "workspace_read_only_changed"
+	activitySyncCommitted   = "workspace_sync_committed"
+	activityImportCommitted = "workspace_import_committed"
+	activityFilesDeleted    = "workspace_files_deleted"
+	activityDraftPromoted   = "workspace_draft_promoted"
+	activityFolderCreated   = "workspace_folder_created"
+	activityFolderDeleted   = "workspace_folder_deleted"
+	activityFolderRenamed   = "workspace_folder_renamed"
+	activityDestructiveSync = "workspace_destructive_sync"
+	activityJobPinned       = "job_pinned"
+	activityJobUnpinned     = "job_unpinned"
+	activityJobFailed       = "job_failed"
+	activityJobShared       = "job_shared"
+	activityJobUnshared     = "job_share_revoked"
+	activityMemberAdded     = "member_added"
+	activityMemberUpdated   = "member_updated"
+	activityMemberExpired   = "member_expired"
+	activityMemberLeft      = "member_left"
+
+	activityOwnershipTransferred = "workspace_ownership_transferred"
+
+	activityWorkspaceDeleted  = "workspace_deleted"
+	activityWorkspaceRestored = "workspace_restored"
+	activityWorkspaceCloned   = "workspace_cloned"
+	activityWorkspaceRenamed  = "workspace_renamed"
+
+	activityShareLinkCreated = "workspace_share_link_created"
+	activityShareLinkRevoked = "workspace_share_link_revoked"
+
+	activityDestructiveSyncSettingsChanged = "workspace_destructive_sync_settings_changed"
+	activityConcurrencySettingsChanged     = "workspace_concurrency_settings_changed"
+	activitySettingsChanged                = "workspace_settings_changed"
+)
+
+// activityCollection returns the activity log subcollection for a workspace.
+func (ac *ApiController) activityCollection(workspaceID string) *docstore.CollectionRef {
+	return ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/activity", workspaceID))
+}
+
+// newActivityEvent builds an activity event stamped with the current time.
+func newActivityEvent(workspaceID, actorID, eventType string, details map[string]interface{}) ActivityEvent {
+	return ActivityEvent{
+		EventID:     uuid.New().String(),
+		WorkspaceID: workspaceID,
+		ActorID:     actorID,
+		Type:        eventType,
+		Details:     details,
+		CreatedAt:   NowISO8601(),
+	}
+}
+
+// recordActivity appends an event to the workspace activity log. Failures are logged and
+// swallowed so that activity tracking never breaks the operation being recorded.
+func (ac *ApiController) recordActivity(ctx context.Context, workspaceID, actorID, eventType string, details map[string]interface{}) {
+	event := newActivityEvent(workspaceID, actorID, eventType, details)
+	if _, err := ac.activityCollection(workspaceID).Doc(event.EventID).Set(ctx, event); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"workspace_id": workspaceID,
+			"event_type":   eventType,
+		}).Warn("Failed to record workspace activity event")
+	}
+}
+
+// recordActivityOnce is recordActivity for events that can be reported more than once, e.g.
+// by a retried callback: the event is stored as eventID and later copies are dropped.
+func (ac *ApiController) recordActivityOnce(ctx context.Context, workspaceID, eventID, actorID, eventType string, details map[string]interface{}) {
+	event := newActivityEvent(workspaceID, actorID, eventType, details)
+	event.EventID = eventID
+	if _, err := ac.activityCollection(workspaceID).Doc(eventID).Create(ctx, event); err != nil && status.Code(err) != codes.AlreadyExists {
+		log.WithError(err).WithFields(log.Fields{
+			"workspace_id": workspaceID,
+			"event_type":   eventType,
+		}).Warn("Failed to record workspace activity event")
+	}
+}
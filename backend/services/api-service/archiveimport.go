@@ -0,0 +1,251 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+)
+
+// importMaxFiles keeps an import's metadata commit (one write per file and folder plus the
+// workspace update) under Firestore's 500-write transaction limit.
+const importMaxFiles = 400
+
+// maxImportPathLength bounds imported paths so their document IDs are not truncated.
+const maxImportPathLength = 300
+
+// defaultImportBlockedExtensions are never imported unless IMPORT_BLOCKED_EXTENSIONS overrides them.
+var defaultImportBlockedExtensions = []string{".exe", ".dll", ".so", ".dylib", ".bin", ".pyc", ".class", ".jar"}
+
+var (
+	errUnknownArchiveFormat = errors.New("file is not a zip or gzipped tar archive")
+	errNothingToImport      = errors.New("archive contains no importable files")
+)
+
+// Archive formats recognised by their leading bytes.
+const (
+	archiveFormatZip   = "zip"
+	archiveFormatTarGz = "tar.gz"
+)
+
+// importPolicy is what the pipeline enforces on every archive entry.
+type importPolicy struct {
+	TargetPath        string // Already cleaned; "" is the workspace root
+	MaxFileBytes      int64
+	MaxTotalBytes     int64
+	BlockedExtensions []string
+}
+
+// importEntry is one file accepted for import.
+type importEntry struct {
+	Path string
+	Data []byte
+}
+
+// importArchiveResult is the outcome of reading an archive.
+type importArchiveResult struct {
+	Entries []importEntry
+	Skipped []ImportSkip
+}
+
+// detectArchiveFormat identifies an archive by its magic bytes.
+func detectArchiveFormat(header []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return archiveFormatZip, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return archiveFormatTarGz, nil
+	}
+	return "", errUnknownArchiveFormat
+}
+
+// cleanImportPath normalises an archive entry name to a workspace-relative path, rejecting
+// absolute paths, drive letters and anything that escapes the workspace.
+func cleanImportPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", fmt.Errorf("absolute paths are not allowed")
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("path escapes the workspace")
+		}
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if len(cleaned) > maxImportPathLength {
+		return "", fmt.Errorf("path is longer than %d characters", maxImportPathLength)
+	}
+	return cleaned, nil
+}
+
+// readImportArchive reads every entry of an archive and applies the import policy.
+// Directories are implied by the files they contain and are not reported.
+func readImportArchive(r io.ReaderAt, size int64, policy importPolicy) (*importArchiveResult, error) {
+	header := make([]byte, 4)
+	n, _ := r.ReadAt(header, 0)
+	format, err := detectArchiveFormat(header[:n])
+	if err != nil {
+		return nil, err
+	}
+
+	collector := &importCollector{policy: policy, seen: make(map[string]bool), result: &importArchiveResult{}}
+	switch format {
+	case archiveFormatZip:
+		err = readZipImport(r, size, collector)
+	case archiveFormatTarGz:
+		err = readTarGzImport(io.NewSectionReader(r, 0, size), collector)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(collector.result.Entries) == 0 {
+		return collector.result, errNothingToImport
+	}
+	return collector.result, nil
+}
+
+func readZipImport(r io.ReaderAt, size int64, collector *importCollector) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %w", err)
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			continue
+		case !mode.IsRegular():
+			collector.skip(f.Name, "not a regular file")
+			continue
+		}
+		collector.add(f.Name, int64(f.UncompressedSize64), func() (io.ReadCloser, error) { return f.Open() })
+	}
+	return nil
+}
+
+func readTarGzImport(r io.Reader, collector *importCollector) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			collector.skip(hdr.Name, "not a regular file")
+			continue
+		}
+		collector.add(hdr.Name, hdr.Size, func() (io.ReadCloser, error) { return io.NopCloser(tr), nil })
+	}
+}
+
+// importCollector applies the import policy to entries as the archive is walked.
+type importCollector struct {
+	policy     importPolicy
+	seen       map[string]bool
+	totalBytes int64
+	result     *importArchiveResult
+}
+
+func (ic *importCollector) skip(name, reason string) {
+	ic.result.Skipped = append(ic.result.Skipped, ImportSkip{Path: name, Reason: reason})
+}
+
+func (ic *importCollector) add(name string, size int64, open func() (io.ReadCloser, error)) {
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if strings.HasPrefix(name, "__MACOSX/") || base == ".DS_Store" {
+		ic.skip(name, "macOS metadata")
+		return
+	}
+
+	cleaned, err := cleanImportPath(name)
+	if err != nil {
+		ic.skip(name, err.Error())
+		return
+	}
+	if ic.policy.TargetPath != "" {
+		cleaned = ic.policy.TargetPath + "/" + cleaned
+	}
+
+	switch {
+	case slices.Contains(ic.policy.BlockedExtensions, strings.ToLower(path.Ext(cleaned))):
+		ic.skip(name, fmt.Sprintf("%s files are not allowed", strings.ToLower(path.Ext(cleaned))))
+		return
+	case ic.seen[cleaned]:
+		ic.skip(name, "duplicate entry")
+		return
+	case size > ic.policy.MaxFileBytes:
+		ic.skip(name, fmt.Sprintf("larger than the %d byte per-file limit", ic.policy.MaxFileBytes))
+		return
+	case len(ic.result.Entries) >= importMaxFiles:
+		ic.skip(name, fmt.Sprintf("import is limited to %d files", importMaxFiles))
+		return
+	case ic.totalBytes+size > ic.policy.MaxTotalBytes:
+		ic.skip(name, "import size limit reached")
+		return
+	}
+
+	rc, err := open()
+	if err != nil {
+		ic.skip(name, "unreadable entry")
+		return
+	}
+	defer rc.Close()
+	// Headers can lie about sizes, so the limit is enforced on the bytes actually read.
+	data, err := io.ReadAll(io.LimitReader(rc, ic.policy.MaxFileBytes+1))
+	if err != nil {
+		ic.skip(name, "unreadable entry")
+		return
+	}
+	if int64(len(data)) > ic.policy.MaxFileBytes {
+		ic.skip(name, fmt.Sprintf("larger than the %d byte per-file limit", ic.policy.MaxFileBytes))
+		return
+	}
+
+	ic.seen[cleaned] = true
+	ic.totalBytes += int64(len(data))
+	ic.result.Entries = append(ic.result.Entries, importEntry{Path: cleaned, Data: data})
+}
+
+// importFolders returns the parent folders of the imported files, shallowest first.
+func importFolders(entries []importEntry) []string {
+	seen := make(map[string]bool)
+	var folders []string
+	for _, e := range entries {
+		for dir := path.Dir(e.Path); dir != "."; dir = path.Dir(dir) {
+			if seen[dir] {
+				break
+			}
+			seen[dir] = true
+			folders = append(folders, dir)
+		}
+	}
+	slices.SortFunc(folders, func(a, b string) int {
+		if d := strings.Count(a, "/") - strings.Count(b, "/"); d != 0 {
+			return d
+		}
+		return strings.Compare(a, b)
+	})
+	return folders
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testImportPolicy = importPolicy{
+	MaxFileBytes:      16,
+	MaxTotalBytes:     64,
+	BlockedExtensions: defaultImportBlockedExtensions,
+}
+
+func buildZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(body))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, body := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(body))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "link", Linkname: "main.py", Typeflag: tar.TypeSymlink}))
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func skipReasons(skipped []ImportSkip) map[string]string {
+	reasons := make(map[string]string, len(skipped))
+	for _, s := range skipped {
+		reasons[s.Path] = s.Reason
+	}
+	return reasons
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	format, err := detectArchiveFormat([]byte("PK\x03\x04rest"))
+	assert.NoError(t, err)
+	assert.Equal(t, archiveFormatZip, format)
+
+	format, err = detectArchiveFormat([]byte{0x1f, 0x8b, 0x08, 0x00})
+	assert.NoError(t, err)
+	assert.Equal(t, archiveFormatTarGz, format)
+
+	_, err = detectArchiveFormat([]byte("%PDF"))
+	assert.ErrorIs(t, err, errUnknownArchiveFormat)
+}
+
+func TestCleanImportPath(t *testing.T) {
+	cases := map[string]string{
+		"src/main.py":     "src/main.py",
+		"./src//main.py":  "src/main.py",
+		"src\\util\\a.py": "src/util/a.py",
+	}
+	for in, want := range cases {
+		got, err := cleanImportPath(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got)
+	}
+
+	for _, bad := range []string{"/etc/passwd", "C:/x.py", "../x.py", "a/../../x.py", ".", strings.Repeat("a", maxImportPathLength+1)} {
+		_, err := cleanImportPath(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestReadImportArchive_Zip(t *testing.T) {
+	data := buildZip(t, map[string]string{
+		"main.py":            "print(1)",
+		"lib/util.py":        "x = 1",
+		"../evil.py":         "x",
+		"tool.exe":           "MZ",
+		"big.txt":            strings.Repeat("b", 17),
+		"__MACOSX/._main.py": "meta",
+		"lib/.DS_Store":      "meta",
+	})
+
+	policy := testImportPolicy
+	policy.TargetPath = "imported"
+	result, err := readImportArchive(bytes.NewReader(data), int64(len(data)), policy)
+	assert.NoError(t, err)
+
+	var paths []string
+	for _, e := range result.Entries {
+		paths = append(paths, e.Path)
+	}
+	assert.ElementsMatch(t, []string{"imported/main.py", "imported/lib/util.py"}, paths)
+
+	reasons := skipReasons(result.Skipped)
+	assert.Equal(t, "path escapes the workspace", reasons["../evil.py"])
+	assert.Equal(t, ".exe files are not allowed", reasons["tool.exe"])
+	assert.Contains(t, reasons["big.txt"], "per-file limit")
+	assert.Equal(t, "macOS metadata", reasons["__MACOSX/._main.py"])
+	assert.Equal(t, "macOS metadata", reasons["lib/.DS_Store"])
+}
+
+func TestReadImportArchive_TarGz(t *testing.T) {
+	data := buildTarGz(t, map[string]string{
+		"a.py": strings.Repeat("a", 16),
+		"b.py": strings.Repeat("b", 16),
+		"c.py": strings.Repeat("c", 16),
+		"d.py": strings.Repeat("d", 16),
+		"e.py": strings.Repeat("e", 16),
+	})
+
+	result, err := readImportArchive(bytes.NewReader(data), int64(len(data)), testImportPolicy)
+	assert.NoError(t, err)
+	assert.Len(t, result.Entries, 4)
+
+	reasons := skipReasons(result.Skipped)
+	assert.Equal(t, "not a regular file", reasons["link"])
+	assert.Len(t, result.Skipped, 2)
+}
+
+func TestReadImportArchive_RejectsUnknownAndEmpty(t *testing.T) {
+	_, err := readImportArchive(bytes.NewReader([]byte("plain text")), 10, testImportPolicy)
+	assert.ErrorIs(t, err, errUnknownArchiveFormat)
+
+	data := buildZip(t, map[string]string{"tool.exe": "MZ"})
+	result, err := readImportArchive(bytes.NewReader(data), int64(len(data)), testImportPolicy)
+	assert.ErrorIs(t, err, errNothingToImport)
+	assert.Len(t, result.Skipped, 1)
+}
+
+func TestImportFolders(t *testing.T) {
+	folders := importFolders([]importEntry{
+		{Path: "src/app/main.py"},
+		{Path: "src/util.py"},
+		{Path: "docs/readme.md"},
+		{Path: "top.txt"},
+	})
+	assert.Equal(t, []string{"docs", "src", "src/app"}, folders)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// auditLogCollection holds one document per administrative action.
+const auditLogCollection = "audit_log"
+
+// Audit actions recorded in audit_log.
+const (
+	auditJobForceFailed      = "job_force_failed"
+	auditJobsRequeued        = "jobs_requeued"
+	auditJobExpiryBackfilled = "job_expiry_backfilled"
+
+	auditStorageCredentialsReloaded = "storage_credentials_reloaded"
+
+	auditWorkspacePurged            = "workspace_purged"
+	auditWorkspaceStorageBackfilled = "workspace_storage_backfilled"
+)
+
+// recordAudit appends an entry to the audit log. It runs after the action has taken effect,
+// so a failed write cannot undo it; it is logged at error level to keep the gap visible.
+func (ac *ApiController) recordAudit(ctx context.Context, actorID, action, targetType, targetID string, details map[string]interface{}) {
+	entry := AuditEntry{
+		EntryID:    uuid.New().String(),
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+		CreatedAt:  NowISO8601(),
+	}
+	if _, err := ac.FirestoreClient.Collection(auditLogCollection).Doc(entry.EntryID).Set(ctx, entry); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"actor_id": actorID,
+			"action":   action,
+			"target":   targetID,
+		}).Error("Failed to write audit log entry")
+	}
+}
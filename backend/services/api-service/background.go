@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrBackgroundQueueFull is returned by Submit when the queue has no free slots.
+	ErrBackgroundQueueFull = errors.New("background task queue is full")
+	// ErrBackgroundRunnerClosed is returned by Submit once shutdown has started.
+	ErrBackgroundRunnerClosed = errors.New("background task runner is shut down")
+)
+
+// BackgroundTaskFunc is a unit of fire-after-response work. The context carries the per-task timeout.
+type BackgroundTaskFunc func(ctx context.Context) error
+
+type backgroundTask struct {
+	name    string
+	fn      BackgroundTaskFunc
+	timeout time.Duration // Zero means the runner's default
+}
+
+// BackgroundRunnerStats is a point-in-time snapshot of the runner's counters.
+type BackgroundRunnerStats struct {
+	Queued    int64 `json:"queued"`
+	Active    int64 `json:"active"`
+	Dropped   int64 `json:"dropped"`
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+}
+
+// BackgroundRunner executes work that handlers schedule after responding, on a bounded
+// queue drained by a fixed-size worker pool. Submissions never block: when the queue is
+// full the task is dropped and counted.
+type BackgroundRunner struct {
+	tasks       chan backgroundTask
+	taskTimeout time.Duration
+
+	mu     sync.RWMutex // guards closed and sends on tasks
+	closed bool
+	wg     sync.WaitGroup
+
+	queued    atomic.Int64
+	active    atomic.Int64
+	dropped   atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+}
+
+// NewBackgroundRunner creates a runner and starts its workers.
+func NewBackgroundRunner(workers, queueSize int, taskTimeout time.Duration) *BackgroundRunner {
+	br := &BackgroundRunner{
+		tasks:       make(chan backgroundTask, queueSize),
+		taskTimeout: taskTimeout,
+	}
+	for i := 0; i < workers; i++ {
+		br.wg.Add(1)
+		go br.worker()
+	}
+	log.WithFields(log.Fields{
+		"workers":         workers,
+		"queue_size":      queueSize,
+		"task_timeout_ms": taskTimeout.Milliseconds(),
+	}).Info("Background task runner started.")
+	return br
+}
+
+// Submit enqueues a task without blocking. It returns ErrBackgroundQueueFull when the
+// queue is saturated and ErrBackgroundRunnerClosed after Shutdown has been called.
+func (br *BackgroundRunner) Submit(name string, fn BackgroundTaskFunc) error {
+	return br.SubmitWithTimeout(name, 0, fn)
+}
+
+// SubmitWithTimeout is Submit for tasks that need a longer (or shorter) deadline than the
+// runner's default, such as downloads. A zero timeout uses the default.
+func (br *BackgroundRunner) SubmitWithTimeout(name string, timeout time.Duration, fn BackgroundTaskFunc) error {
+	br.mu.RLock()
+	defer br.mu.RUnlock()
+
+	if br.closed {
+		br.dropped.Add(1)
+		log.WithField("task", name).Error("Background task rejected: runner is shut down.")
+		return ErrBackgroundRunnerClosed
+	}
+
+	select {
+	case br.tasks <- backgroundTask{name: name, fn: fn, timeout: timeout}:
+		br.queued.Add(1)
+		return nil
+	default:
+		br.dropped.Add(1)
+		log.WithFields(log.Fields{
+			"task":  name,
+			"stats": br.Stats(),
+		}).Error("Background task rejected: queue is full.")
+		return ErrBackgroundQueueFull
+	}
+}
+
+// Stats returns the current queue and execution counters.
+func (br *BackgroundRunner) Stats() BackgroundRunnerStats {
+	return BackgroundRunnerStats{
+		Queued:    br.queued.Load(),
+		Active:    br.active.Load(),
+		Dropped:   br.dropped.Load(),
+		Completed: br.completed.Load(),
+		Failed:    br.failed.Load(),
+	}
+}
+
+// Shutdown stops accepting new tasks and waits for queued and running tasks to drain,
+// or for ctx to be done, whichever comes first.
+func (br *BackgroundRunner) Shutdown(ctx context.Context) error {
+	br.mu.Lock()
+	if !br.closed {
+		br.closed = true
+		close(br.tasks)
+	}
+	br.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		br.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.WithField("stats", br.Stats()).Info("Background task runner drained.")
+		return nil
+	case <-ctx.Done():
+		log.WithField("stats", br.Stats()).Warn("Background task runner shutdown timed out before draining.")
+		return fmt.Errorf("background runner shutdown: %w", ctx.Err())
+	}
+}
+
+func (br *BackgroundRunner) worker() {
+	defer br.wg.Done()
+	for task := range br.tasks {
+		br.queued.Add(-1)
+		br.run(task)
+	}
+}
+
+func (br *BackgroundRunner) run(task backgroundTask) {
+	br.active.Add(1)
+	defer br.active.Add(-1)
+
+	logCtx := log.WithField("task", task.name)
+	start := time.Now()
+
+	timeout := br.taskTimeout
+	if task.timeout > 0 {
+		timeout = task.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			br.failed.Add(1)
+			logCtx.WithField("panic", r).Error("Background task panicked.")
+		}
+	}()
+
+	if err := task.fn(ctx); err != nil {
+		br.failed.Add(1)
+		logCtx.WithError(err).WithField("duration_ms", time.Since(start).Milliseconds()).Error("Background task failed.")
+		return
+	}
+	br.completed.Add(1)
+	logCtx.WithField("duration_ms", time.Since(start).Milliseconds()).Debug("Background task completed.")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackgroundRunner_DropsWhenQueueFull(t *testing.T) {
+	br := NewBackgroundRunner(1, 1, time.Second)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	assert.NoError(t, br.Submit("blocker", func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}))
+	<-started
+
+	assert.NoError(t, br.Submit("queued", func(ctx context.Context) error { return nil }))
+	err := br.Submit("overflow", func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrBackgroundQueueFull)
+
+	stats := br.Stats()
+	assert.Equal(t, int64(1), stats.Active)
+	assert.Equal(t, int64(1), stats.Queued)
+	assert.Equal(t, int64(1), stats.Dropped)
+
+	close(release)
+	assert.NoError(t, br.Shutdown(context.Background()))
+	assert.Equal(t, int64(2), br.Stats().Completed)
+}
+
+func TestBackgroundRunner_RecoversPanicsAndCountsFailures(t *testing.T) {
+	br := NewBackgroundRunner(2, 4, time.Second)
+
+	assert.NoError(t, br.Submit("panics", func(ctx context.Context) error { panic("boom") }))
+	assert.NoError(t, br.Submit("errors", func(ctx context.Context) error { return errors.New("nope") }))
+	assert.NoError(t, br.Submit("ok", func(ctx context.Context) error { return nil }))
+
+	assert.NoError(t, br.Shutdown(context.Background()))
+	stats := br.Stats()
+	assert.Equal(t, int64(2), stats.Failed)
+	assert.Equal(t, int64(1), stats.Completed)
+	assert.Equal(t, int64(0), stats.Active)
+}
+
+func TestBackgroundRunner_AppliesTaskTimeout(t *testing.T) {
+	br := NewBackgroundRunner(1, 1, 20*time.Millisecond)
+
+	assert.NoError(t, br.Submit("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+
+	assert.NoError(t, br.Shutdown(context.Background()))
+	assert.Equal(t, int64(1), br.Stats().Failed)
+}
+
+func TestBackgroundRunner_SubmitWithTimeoutOverridesDefault(t *testing.T) {
+	br := NewBackgroundRunner(1, 1, time.Hour)
+
+	var remaining time.Duration
+	assert.NoError(t, br.SubmitWithTimeout("short", 50*time.Millisecond, func(ctx context.Context) error {
+		deadline, _ := ctx.Deadline()
+		remaining = time.Until(deadline)
+		return nil
+	}))
+
+	assert.NoError(t, br.Shutdown(context.Background()))
+	assert.LessOrEqual(t, remaining, 50*time.Millisecond)
+	assert.Equal(t, int64(1), br.Stats().Completed)
+}
+
+func TestBackgroundRunner_RejectsAfterShutdown(t *testing.T) {
+	br := NewBackgroundRunner(1, 1, time.Second)
+	assert.NoError(t, br.Shutdown(context.Background()))
+
+	err := br.Submit("late", func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrBackgroundRunnerClosed)
+	assert.Equal(t, int64(1), br.Stats().Dropped)
+}
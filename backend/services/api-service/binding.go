@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes one invalid field in a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+var registerTagNamesOnce sync.Once
+
+// registerJSONTagNames makes the validator report fields by their JSON names
+// (e.g. "workspaceVersion") instead of Go field names.
+func registerJSONTagNames() {
+	registerTagNamesOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		v.RegisterTagNameFunc(func(fld reflect.StructField) string {
+			name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return fld.Name
+			}
+			return name
+		})
+	})
+}
+
+// bindJSON decodes and validates the request body into obj. On failure it writes a 400 with
+// a stable list of field errors and returns the underlying error for the caller to log.
+// When StrictJSONBinding is enabled, unknown fields are rejected rather than ignored.
+func (ac *ApiController) bindJSON(c *gin.Context, obj interface{}) error {
+	registerJSONTagNames()
+
+	var err error
+	if ac.AppConfig.StrictJSONBinding {
+		err = decodeStrictJSON(c.Request, obj)
+	} else {
+		err = c.ShouldBindJSON(obj)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": bindingErrorDetails(err),
+		})
+		return err
+	}
+	return nil
+}
+
+// decodeStrictJSON decodes with DisallowUnknownFields and then runs gin's struct validation.
+func decodeStrictJSON(req *http.Request, obj interface{}) error {
+	if req.Body == nil {
+		return errors.New("request body is empty")
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	return decodeJSONDocument(body, obj, true)
+}
+
+// decodeJSON decodes and validates a JSON document already read, such as one element of a
+// batch request, as bindJSON would a request body.
+func (ac *ApiController) decodeJSON(body []byte, obj interface{}) error {
+	registerJSONTagNames()
+	return decodeJSONDocument(body, obj, ac.AppConfig.StrictJSONBinding)
+}
+
+// decodeJSONDocument decodes body into obj, rejecting unknown fields when strict, and runs
+// gin's struct validation.
+func decodeJSONDocument(body []byte, obj interface{}, strict bool) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(obj); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// bindingErrorDetails converts decoding and validation errors into FieldErrors.
+func bindingErrorDetails(err error) []FieldError {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		details := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			details = append(details, FieldError{
+				Field:   fieldPath(fe),
+				Rule:    fe.Tag(),
+				Message: ruleMessage(fe),
+			})
+		}
+		return details
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: fmt.Sprintf("must be of type %s", typeErr.Type.String()),
+		}}
+	}
+
+	// encoding/json reports unknown fields as: json: unknown field "name"
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		field := strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`)
+		return []FieldError{{Field: field, Rule: "unknown_field", Message: "is not a recognized field"}}
+	}
+
+	return []FieldError{{Field: "", Rule: "json", Message: "request body is not valid JSON: " + err.Error()}}
+}
+
+// fieldPath returns the dotted JSON path of the field, without the top-level struct name.
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return fe.Field()
+}
+
+// ruleMessage renders a human-readable message for common validation rules.
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", fe.Param())
+	case "min":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "len":
+		return fmt.Sprintf("must have length %s", fe.Param())
+	default:
+		return fmt.Sprintf("failed the '%s' rule", fe.Tag())
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type bindingErrorBody struct {
+	Error   string       `json:"error"`
+	Details []FieldError `json:"details"`
+}
+
+func bindTestRequest(t *testing.T, strict bool, body string, obj interface{}) (*httptest.ResponseRecorder, error) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	ac := &ApiController{AppConfig: &AppConfig{StrictJSONBinding: strict}}
+	return w, ac.bindJSON(c, obj)
+}
+
+func TestBindJSON_ReportsFieldsByJSONName(t *testing.T) {
+	var req SyncRequest
+	w, err := bindTestRequest(t, false, `{"files": [{"filePath": "a.py", "type": "file"}]}`, &req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var body bindingErrorBody
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Contains(t, body.Details, FieldError{Field: "workspaceVersion", Rule: "required", Message: "is required"})
+	assert.Contains(t, body.Details, FieldError{Field: "files[0].action", Rule: "required", Message: "is required"})
+}
+
+func TestBindJSON_UnknownFieldsOnlyRejectedWhenStrict(t *testing.T) {
+	payload := `{"workspaceVerion": "3", "workspaceVersion": "3", "files": []}`
+
+	var lenient SyncRequest
+	_, err := bindTestRequest(t, false, payload, &lenient)
+	assert.NoError(t, err)
+
+	var strict SyncRequest
+	w, err := bindTestRequest(t, true, payload, &strict)
+	assert.Error(t, err)
+
+	var body bindingErrorBody
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, []FieldError{{Field: "workspaceVerion", Rule: "unknown_field", Message: "is not a recognized field"}}, body.Details)
+}
+
+func TestBindJSON_TypeAndSyntaxErrors(t *testing.T) {
+	var req RequestBody
+	w, err := bindTestRequest(t, false, `{"code": 5, "language": "python"}`, &req)
+	assert.Error(t, err)
+	var body bindingErrorBody
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "code", body.Details[0].Field)
+	assert.Equal(t, "type", body.Details[0].Rule)
+
+	w, err = bindTestRequest(t, true, `{"code": `, &req)
+	assert.Error(t, err)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "json", body.Details[0].Rule)
+}
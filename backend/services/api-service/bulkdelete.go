@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// bulkDeleteBatchSize is how many entries one commit deletes, leaving room in Firestore's
+	// 500-write transaction limit for the workspace update and change log entry.
+	bulkDeleteBatchSize = 400
+	// maxBulkDeleteEntries bounds the files and folders one request may delete.
+	maxBulkDeleteEntries = 5000
+)
+
+// Per-path outcomes reported by BulkDeleteFiles.
+const (
+	bulkDeleteDeleted  = "deleted"
+	bulkDeleteNotFound = "not_found"
+	bulkDeleteFailed   = "failed" // A commit failed before all of the path's entries were deleted
+)
+
+var errBulkDeleteTooLarge = fmt.Errorf("paths cover more than %d files and folders", maxBulkDeleteEntries)
+
+// descendantRange returns the file_path bounds [start, end) of everything inside folder:
+// '0' is the character after '/'.
+func descendantRange(folder string) (string, string) {
+	return folder + "/", folder + "0"
+}
+
+// bulkDeleteResults reports each requested path's outcome. resolved maps a path to the
+// entry paths it covered; deleted holds the entry paths that were committed.
+func bulkDeleteResults(paths []string, resolved map[string][]string, deleted map[string]bool) []BulkDeleteResult {
+	results := make([]BulkDeleteResult, 0, len(paths))
+	for _, p := range paths {
+		entries := resolved[p]
+		result := BulkDeleteResult{Path: p, Status: bulkDeleteDeleted}
+		if len(entries) == 0 {
+			result.Status = bulkDeleteNotFound
+		}
+		for _, entry := range entries {
+			if deleted[entry] {
+				result.Deleted++
+			} else {
+				result.Status = bulkDeleteFailed
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// resolveBulkDelete finds the metadata covered by each path: the entry itself and, for
+// folders, everything inside. Entries covered by several paths are returned once.
+func (ac *ApiController) resolveBulkDelete(ctx context.Context, workspaceID string, paths []string) ([]FileMetadata, map[string][]string, error) {
+	filesCollection := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID))
+	refs := make([]*docstore.DocumentRef, len(paths))
+	for i, p := range paths {
+		refs[i] = filesCollection.Doc(SanitizePathToDocID(p))
+	}
+	snaps, err := ac.FirestoreClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read files: %w", err)
+	}
+
+	var entries []FileMetadata
+	seen := make(map[string]bool)
+	resolved := make(map[string][]string, len(paths))
+	add := func(p string, meta FileMetadata) {
+		resolved[p] = append(resolved[p], meta.FilePath)
+		if !seen[meta.FilePath] {
+			seen[meta.FilePath] = true
+			entries = append(entries, meta)
+		}
+	}
+
+	for i, p := range paths {
+		if snaps[i].Exists() {
+			var meta FileMetadata
+			if err := snaps[i].DataTo(&meta); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse file %s: %w", p, err)
+			}
+			add(p, meta)
+			if meta.Type == "file" {
+				continue
+			}
+		}
+		// A folder, or a prefix whose folder entry is missing: take everything inside it.
+		start, end := descendantRange(p)
+		docs, err := filesCollection.Where("file_path", ">=", start).Where("file_path", "<", end).
+			Limit(maxBulkDeleteEntries + 1).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list files under %s: %w", p, err)
+		}
+		for _, doc := range docs {
+			var meta FileMetadata
+			if err := doc.DataTo(&meta); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse file %s: %w", doc.Ref.ID, err)
+			}
+			add(p, meta)
+		}
+		if len(entries) > maxBulkDeleteEntries {
+			return nil, nil, errBulkDeleteTooLarge
+		}
+	}
+	return entries, resolved, nil
+}
+
+// bulkDeleteCommit is what one committed batch removed.
+type bulkDeleteCommit struct {
+	WorkspaceVersion string
+	Deleted          []string // Entry paths, including ones already gone when the batch ran
+	R2Keys           []string
+	FilesDeleted     int64
+	BytesRemoved     int64
+	StorageBytes     int64 // Workspace storage after the commit
+}
+
+// commitBulkDeleteBatch deletes one batch of entries in a transaction that bumps the workspace
+// version, updates its storage and records the change log, as ConfirmSync does.
+func (ac *ApiController) commitBulkDeleteBatch(ctx context.Context, workspaceID string, batch []FileMetadata) (bulkDeleteCommit, error) {
+	filesCollection := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID))
+	wsDocRef := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+	var commit bulkDeleteCommit
+
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		commit = bulkDeleteCommit{}
+
+		workspace, version, err := beginWorkspaceCommit(tx, wsDocRef)
+		if err != nil {
+			return err
+		}
+
+		refs := make([]*docstore.DocumentRef, len(batch))
+		for i, meta := range batch {
+			refs[i] = filesCollection.Doc(SanitizePathToDocID(meta.FilePath))
+		}
+		snaps, err := tx.GetAll(refs)
+		if err != nil {
+			return fmt.Errorf("failed to read files: %w", err)
+		}
+
+		// Re-read so sizes and keys match what is deleted, even if a sync changed them.
+		var changes []WorkspaceChange
+		for i, snap := range snaps {
+			commit.Deleted = append(commit.Deleted, batch[i].FilePath)
+			if !snap.Exists() {
+				continue
+			}
+			var meta FileMetadata
+			if err := snap.DataTo(&meta); err != nil {
+				return fmt.Errorf("failed to parse file %s: %w", batch[i].FilePath, err)
+			}
+			if meta.Type == "file" {
+				commit.FilesDeleted++
+				commit.BytesRemoved += meta.Size
+				if meta.R2ObjectKey != "" {
+					commit.R2Keys = append(commit.R2Keys, meta.R2ObjectKey)
+				}
+			}
+			changes = append(changes, WorkspaceChange{FilePath: meta.FilePath, Type: meta.Type, Action: "delete"})
+		}
+		if len(changes) == 0 {
+			return nil // Everything was already gone; no new version
+		}
+
+		commit.WorkspaceVersion = version
+		commit.StorageBytes = workspace.StorageBytes - commit.BytesRemoved
+		if err := tx.Update(wsDocRef, []docstore.Update{
+			{Path: "workspace_version", Value: commit.WorkspaceVersion},
+			{Path: "updated_at", Value: NowISO8601()},
+			{Path: "storage_bytes", Value: docstore.Increment(-commit.BytesRemoved)},
+		}); err != nil {
+			return fmt.Errorf("failed to increment workspace version: %w", err)
+		}
+		for i, snap := range snaps {
+			if snap.Exists() {
+				if err := tx.Delete(refs[i]); err != nil {
+					return fmt.Errorf("failed to delete file metadata: %w", err)
+				}
+			}
+		}
+		return ac.recordWorkspaceChanges(tx, workspaceID, commit.WorkspaceVersion, changes)
+	})
+	return commit, err
+}
+
+// deleteEntries deletes entries in batches of bulkDeleteBatchSize, each committed by
+// commitBulkDeleteBatch, and removes their R2 objects through the durable deletion queue. It
+// stops at the first failed commit and returns the entry paths deleted so far, the combined
+// totals (WorkspaceVersion and StorageBytes are those of the last commit) and that error.
+func (ac *ApiController) deleteEntries(ctx context.Context, workspaceID string, entries []FileMetadata) (map[string]bool, bulkDeleteCommit, error) {
+	deleted := make(map[string]bool, len(entries))
+	var total bulkDeleteCommit
+	for start := 0; start < len(entries); start += bulkDeleteBatchSize {
+		batch := entries[start:min(start+bulkDeleteBatchSize, len(entries))]
+		commit, err := ac.commitBulkDeleteBatch(ctx, workspaceID, batch)
+		if err != nil {
+			return deleted, total, err
+		}
+		for _, p := range commit.Deleted {
+			deleted[p] = true
+		}
+		if commit.WorkspaceVersion != "" {
+			total.WorkspaceVersion = commit.WorkspaceVersion
+			total.StorageBytes = commit.StorageBytes
+		}
+		total.FilesDeleted += commit.FilesDeleted
+		total.BytesRemoved += commit.BytesRemoved
+
+		if len(commit.R2Keys) > 0 {
+			if failures := ac.deleteR2Objects(ctx, commit.R2Keys); len(failures) > 0 {
+				log.WithField("workspace_id", workspaceID).Errorf("Failed to delete %d of %d R2 objects; queueing them for retry.", len(failures), len(commit.R2Keys))
+				ac.recordFailedR2Deletions(ctx, workspaceID, failures)
+			}
+		}
+	}
+	return deleted, total, nil
+}
+
+// BulkDeleteFiles deletes files and folders outside the sync flow. Each path names a file or
+// a folder; a folder is deleted with everything inside it, and a path with no folder entry
+// still deletes whatever lies under it. Deletions are committed in batches, each bumping the
+// workspace version, and their R2 objects are removed through the durable deletion queue.
+// Editors and owners only; read-only workspaces are rejected.
+func (ac *ApiController) BulkDeleteFiles(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "BulkDeleteFiles"})
+
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleEditor, logCtx) == nil {
+		return
+	}
+
+	var req BulkDeleteFilesRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	paths := make([]string, 0, len(req.Paths))
+	for _, p := range req.Paths {
+		p = strings.TrimSuffix(p, "/")
+		if p == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "paths must not be empty or the workspace root"})
+			return
+		}
+		paths = append(paths, p)
+	}
+	paths = uniqueStrings(paths)
+
+	entries, resolved, err := ac.resolveBulkDelete(ctx, workspaceID, paths)
+	if errors.Is(err, errBulkDeleteTooLarge) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to resolve files for bulk delete.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load files"})
+		return
+	}
+
+	deleted, total, commitErr := ac.deleteEntries(ctx, workspaceID, entries)
+	if commitErr != nil && len(deleted) == 0 {
+		if errors.Is(commitErr, errWorkspaceReadOnly) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Workspace is read-only. Ask an owner to lift the freeze."})
+			return
+		}
+		logCtx.WithError(commitErr).Error("Bulk delete failed.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete files"})
+		return
+	}
+	if commitErr != nil {
+		logCtx.WithError(commitErr).WithField("entries_deleted", len(deleted)).Error("Bulk delete stopped after a partial commit.")
+	}
+
+	if total.WorkspaceVersion != "" {
+		logCtx.WithFields(log.Fields{
+			"workspace_version": total.WorkspaceVersion,
+			"entries_deleted":   len(deleted),
+			"files_deleted":     total.FilesDeleted,
+			"bytes_removed":     total.BytesRemoved,
+		}).Info("Bulk delete committed.")
+		ac.recordActivity(ctx, workspaceID, userID, activityFilesDeleted, map[string]interface{}{
+			"workspace_version": total.WorkspaceVersion,
+			"files_deleted":     total.FilesDeleted,
+			"bytes_removed":     total.BytesRemoved,
+		})
+		ac.recordWorkspaceUsage(ctx, workspaceID, map[string]interface{}{
+			"storage_bytes": total.StorageBytes,
+		})
+	}
+
+	c.JSON(http.StatusOK, BulkDeleteFilesResponse{
+		WorkspaceVersion: total.WorkspaceVersion,
+		Results:          bulkDeleteResults(paths, resolved, deleted),
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescendantRange(t *testing.T) {
+	start, end := descendantRange("src/lib")
+	for _, inside := range []string{"src/lib/a.py", "src/lib/deep/b.py", "src/lib/~"} {
+		assert.True(t, inside >= start && inside < end, inside)
+	}
+	for _, outside := range []string{"src/lib", "src/lib0", "src/library/a.py", "src/lib.py", "src/li"} {
+		assert.False(t, outside >= start && outside < end, outside)
+	}
+}
+
+func TestBulkDeleteResults(t *testing.T) {
+	resolved := map[string][]string{
+		"a.py": {"a.py"},
+		"src":  {"src", "src/b.py", "src/c.py"},
+		"docs": {"docs", "docs/d.md"},
+	}
+	deleted := map[string]bool{"a.py": true, "src": true, "src/b.py": true, "src/c.py": true, "docs": true}
+
+	results := bulkDeleteResults([]string{"a.py", "src", "missing", "docs"}, resolved, deleted)
+	assert.Equal(t, []BulkDeleteResult{
+		{Path: "a.py", Status: bulkDeleteDeleted, Deleted: 1},
+		{Path: "src", Status: bulkDeleteDeleted, Deleted: 3},
+		{Path: "missing", Status: bulkDeleteNotFound},
+		{Path: "docs", Status: bulkDeleteFailed, Deleted: 1}, // Its second batch did not commit
+	}, results)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIPKey is the gin context key holding the caller's IP resolved by ClientIPMiddleware.
+const clientIPKey = "clientIP"
+
+// forwardedClientIP picks the caller's address from an X-Forwarded-For chain that passed
+// through hops trusted proxies. Each proxy appends the address it received the request from,
+// so the hops-th entry from the right was written by the outermost trusted proxy and cannot
+// be forged by the client; anything to its left can. A chain shorter than hops was written by
+// proxies alone, so its first entry is used. It returns false when no valid address is found.
+func forwardedClientIP(chain string, hops int) (string, bool) {
+	var entries []string
+	for _, entry := range strings.Split(chain, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	if hops <= 0 || len(entries) == 0 {
+		return "", false
+	}
+	candidate := entries[max(len(entries)-hops, 0)]
+	ip := net.ParseIP(candidate)
+	if ip == nil {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+// ClientIPMiddleware resolves the caller's IP once per request for RealClientIP. With
+// hops > 0 it is read from X-Forwarded-For (see forwardedClientIP), for deployments where
+// the number of proxies in front of the service is fixed but their addresses are not, such
+// as Cloud Run. Otherwise gin's ClientIP is used, which only honors forwarded headers from
+// the proxies passed to SetTrustedProxies.
+func ClientIPMiddleware(hops int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip, ok := forwardedClientIP(strings.Join(c.Request.Header.Values("X-Forwarded-For"), ","), hops)
+		if !ok {
+			ip = c.ClientIP()
+		}
+		c.Set(clientIPKey, ip)
+		c.Next()
+	}
+}
+
+// RealClientIP returns the caller's IP as resolved by ClientIPMiddleware. Rate limiting,
+// request logs and security events must use it instead of c.ClientIP.
+func RealClientIP(c *gin.Context) string {
+	if ip := c.GetString(clientIPKey); ip != "" {
+		return ip
+	}
+	return c.ClientIP()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// clientIPTestRouter echoes the IP each component sees and rate limits by IP (1/min).
+func clientIPTestRouter(t *testing.T, trustedProxies []string, hops int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	assert.NoError(t, r.SetTrustedProxies(trustedProxies))
+	r.Use(ClientIPMiddleware(hops))
+	r.GET("/ip", func(c *gin.Context) {
+		c.String(http.StatusOK, RealClientIP(c))
+	})
+	r.POST("/limited", RateLimit(newMemoryRateLimiter(), "test", 1, time.Minute), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return r
+}
+
+func requestFrom(r *gin.Engine, method, path, remoteAddr string, forwardedFor ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = remoteAddr
+	for _, v := range forwardedFor {
+		req.Header.Add("X-Forwarded-For", v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestForwardedClientIP(t *testing.T) {
+	ip, ok := forwardedClientIP("203.0.113.7", 1)
+	assert.True(t, ok)
+	assert.Equal(t, "203.0.113.7", ip)
+
+	// A client-supplied prefix is ignored; the proxy's entry wins.
+	ip, ok = forwardedClientIP("6.6.6.6, 203.0.113.7", 1)
+	assert.True(t, ok)
+	assert.Equal(t, "203.0.113.7", ip)
+
+	ip, ok = forwardedClientIP("6.6.6.6, 203.0.113.7, 10.0.0.2", 2)
+	assert.True(t, ok)
+	assert.Equal(t, "203.0.113.7", ip)
+
+	// Fewer entries than hops: every entry came from a proxy.
+	ip, ok = forwardedClientIP("203.0.113.7", 2)
+	assert.True(t, ok)
+	assert.Equal(t, "203.0.113.7", ip)
+
+	_, ok = forwardedClientIP("", 1)
+	assert.False(t, ok)
+	_, ok = forwardedClientIP("203.0.113.7", 0)
+	assert.False(t, ok)
+	_, ok = forwardedClientIP("not-an-ip", 1)
+	assert.False(t, ok)
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies(" 10.0.0.0/8, 192.0.2.1 ,,")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.1"}, proxies)
+
+	proxies, err = parseTrustedProxies("")
+	assert.NoError(t, err)
+	assert.Nil(t, proxies)
+
+	_, err = parseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = parseTrustedProxies("proxy.internal")
+	assert.Error(t, err)
+}
+
+func TestRealClientIPIgnoresForgedHeadersWithoutTrustedProxies(t *testing.T) {
+	r := clientIPTestRouter(t, nil, 0)
+	w := requestFrom(r, http.MethodGet, "/ip", "198.51.100.2:1234", "6.6.6.6")
+	assert.Equal(t, "198.51.100.2", w.Body.String())
+}
+
+func TestRealClientIPWithTrustedProxies(t *testing.T) {
+	r := clientIPTestRouter(t, []string{"10.0.0.0/8"}, 0)
+
+	// Legitimate: the load balancer forwards the client's address.
+	w := requestFrom(r, http.MethodGet, "/ip", "10.0.0.1:1234", "203.0.113.7")
+	assert.Equal(t, "203.0.113.7", w.Body.String())
+
+	// Forged: the client prepends an address; the load balancer still appends the real one.
+	w = requestFrom(r, http.MethodGet, "/ip", "10.0.0.1:1234", "6.6.6.6, 203.0.113.7")
+	assert.Equal(t, "203.0.113.7", w.Body.String())
+
+	// Forged: a client reaching the service directly cannot claim another address.
+	w = requestFrom(r, http.MethodGet, "/ip", "198.51.100.2:1234", "203.0.113.7")
+	assert.Equal(t, "198.51.100.2", w.Body.String())
+}
+
+func TestRealClientIPWithProxyHops(t *testing.T) {
+	r := clientIPTestRouter(t, nil, 1)
+
+	w := requestFrom(r, http.MethodGet, "/ip", "169.254.1.1:1234", "203.0.113.7")
+	assert.Equal(t, "203.0.113.7", w.Body.String())
+
+	// Forged entries to the left of the proxy's, in one header or split across several.
+	w = requestFrom(r, http.MethodGet, "/ip", "169.254.1.1:1234", "6.6.6.6, 203.0.113.7")
+	assert.Equal(t, "203.0.113.7", w.Body.String())
+	w = requestFrom(r, http.MethodGet, "/ip", "169.254.1.1:1234", "6.6.6.6", "203.0.113.7")
+	assert.Equal(t, "203.0.113.7", w.Body.String())
+
+	// No forwarded header at all: fall back to the peer address.
+	w = requestFrom(r, http.MethodGet, "/ip", "169.254.1.1:1234")
+	assert.Equal(t, "169.254.1.1", w.Body.String())
+}
+
+func TestRateLimitKeysOnRealClientIP(t *testing.T) {
+	r := clientIPTestRouter(t, nil, 1)
+
+	// Rotating a forged prefix does not buy a fresh bucket.
+	assert.Equal(t, http.StatusOK, requestFrom(r, http.MethodPost, "/limited", "169.254.1.1:1234", "1.1.1.1, 203.0.113.7").Code)
+	assert.Equal(t, http.StatusTooManyRequests, requestFrom(r, http.MethodPost, "/limited", "169.254.1.1:1234", "2.2.2.2, 203.0.113.7").Code)
+
+	// A different real client behind the same proxy has its own bucket.
+	assert.Equal(t, http.StatusOK, requestFrom(r, http.MethodPost, "/limited", "169.254.1.1:1234", "203.0.113.8").Code)
+}
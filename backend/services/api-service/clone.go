@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// cloneJobsCollection holds asynchronous workspace clones, keyed by clone job ID.
+	cloneJobsCollection = "clone_jobs"
+	// cloneCheckpointFiles is how many files a clone copies between progress checkpoints.
+	cloneCheckpointFiles = 50
+)
+
+// Clone job statuses.
+const (
+	cloneStatusQueued    = "queued"
+	cloneStatusCopying   = "copying"
+	cloneStatusCompleted = "completed"
+	cloneStatusFailed    = "failed"
+)
+
+// errCloneNotResumable is returned when a clone job is not in a state that can be resumed.
+var errCloneNotResumable = errors.New("clone job is not resumable")
+
+// cloneWorkspaceName returns the clone's name: the requested one, or "Copy of <source>".
+func cloneWorkspaceName(sourceName, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	return "Copy of " + sourceName
+}
+
+// cloneFileID returns the clone's ID for a source file. It is derived from the clone job, so
+// a resumed clone writes the same entries and objects instead of duplicating them.
+func cloneFileID(cloneJobID, sourceFileID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(cloneJobID+"/"+sourceFileID)).String()
+}
+
+// clonedFile returns the clone's copy of a source entry with a fresh ID and, for files, an
+// object key under the clone's prefix. Folders have no object.
+func clonedFile(src FileMetadata, cloneJobID, workspaceID, now string) FileMetadata {
+	fileID := cloneFileID(cloneJobID, src.FileID)
+	dst := FileMetadata{
+		FileID:    fileID,
+		FilePath:  src.FilePath,
+		Type:      src.Type,
+		Size:      src.Size,
+		Hash:      src.Hash,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   "1",
+	}
+	if src.Type != "folder" && src.R2ObjectKey != "" {
+		dst.R2ObjectKey = fmt.Sprintf("workspaces/%s/files/%s/%s", workspaceID, fileID, path.Base(src.R2ObjectKey))
+	}
+	return dst
+}
+
+// CloneWorkspace starts copying a workspace into a new one owned by the caller, who may be
+// any member of the source. It answers 202 with a clone job to poll; the clone exists at
+// once but stays read-only until every file has been copied.
+func (ac *ApiController) CloneWorkspace(c *gin.Context) {
+	sourceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": sourceID, "user_id": userID, "handler": "CloneWorkspace"})
+
+	if ac.requireWorkspaceRole(c, sourceID, userID, roleViewer, logCtx) == nil {
+		return
+	}
+	var req CloneWorkspaceRequest
+	if c.Request.ContentLength > 0 {
+		if err := ac.bindJSON(c, &req); err != nil {
+			return
+		}
+	}
+	source, err := ac.loadWorkspace(ctx, sourceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load workspace to clone.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace"})
+		return
+	}
+	if source.DeletedAt != "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return
+	}
+
+	now := NowISO8601()
+	clone := Workspace{
+		WorkspaceID:      uuid.New().String(),
+		Name:             cloneWorkspaceName(source.Name, req.Name),
+		CreatedBy:        userID,
+		OwnedBy:          userID,
+		CreatedAt:        now,
+		WorkspaceVersion: "1",
+		ReadOnly:         true,
+		ClonedFrom:       sourceID,
+	}
+	clone.NameLower = normalizeWorkspaceName(clone.Name)
+	// The clone starts empty, so it would hold what the source holds now. Each checkpoint
+	// checks again in case the source grows while it is copied.
+	var quotaErr *storageQuotaError
+	if err := ac.checkStorageQuota(clone, source.StorageBytes); errors.As(err, &quotaErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":        fmt.Sprintf("The clone would hold %d bytes, over the %d byte workspace quota", quotaErr.quota.ProjectedBytes, quotaErr.quota.LimitBytes),
+			"code":         syncQuotaExceeded,
+			"storageQuota": quotaErr.quota,
+		})
+		return
+	}
+	membership := WorkspaceMembership{
+		MembershipID: uuid.New().String(),
+		WorkspaceID:  clone.WorkspaceID,
+		UserID:       userID,
+		UserEmail:    c.GetString("userEmail"),
+		Role:         roleOwner,
+		JoinedAt:     now,
+	}
+	job := CloneJob{
+		CloneJobID:        uuid.New().String(),
+		SourceWorkspaceID: sourceID,
+		WorkspaceID:       clone.WorkspaceID,
+		UserID:            userID,
+		Status:            cloneStatusQueued,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		DeleteAfter:       deleteAfter(ac.AppConfig.Retention.Jobs),
+	}
+	jobRef := ac.FirestoreClient.Collection(cloneJobsCollection).Doc(job.CloneJobID)
+	err = ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		if err := tx.Create(ac.FirestoreClient.Collection("workspaces").Doc(clone.WorkspaceID), clone); err != nil {
+			return err
+		}
+		if err := tx.Create(ac.FirestoreClient.Collection("workspace_memberships").Doc(membership.MembershipID), membership); err != nil {
+			return err
+		}
+		return tx.Create(jobRef, job)
+	})
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to create workspace clone.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create clone"})
+		return
+	}
+
+	logCtx = logCtx.WithFields(log.Fields{"clone_job_id": job.CloneJobID, "clone_workspace_id": clone.WorkspaceID})
+	if err := ac.submitClone(jobRef); err != nil {
+		logCtx.WithError(err).Error("Failed to schedule workspace clone.")
+		ac.failClone(ctx, jobRef, "Clone could not be scheduled; resume it later")
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":       "Clone could not be scheduled; resume it later",
+			"cloneJobId":  job.CloneJobID,
+			"workspaceId": clone.WorkspaceID,
+		})
+		return
+	}
+	logCtx.Info("Workspace clone queued.")
+	c.JSON(http.StatusAccepted, gin.H{
+		"cloneJobId":  job.CloneJobID,
+		"workspaceId": clone.WorkspaceID,
+		"name":        clone.Name,
+		"status":      job.Status,
+	})
+}
+
+// loadCloneJob loads a clone job of the workspace, writing a 403/404/500 and returning nil
+// unless the caller's role in the clone is at least required.
+func (ac *ApiController) loadCloneJob(c *gin.Context, required string, logCtx *log.Entry) (*docstore.DocumentRef, *CloneJob) {
+	workspaceID := c.Param("workspaceId")
+	if ac.requireWorkspaceRole(c, workspaceID, c.GetString("userID"), required, logCtx) == nil {
+		return nil, nil
+	}
+	jobRef := ac.FirestoreClient.Collection(cloneJobsCollection).Doc(c.Param("cloneJobId"))
+	snap, err := jobRef.Get(c.Request.Context())
+	if err != nil && !isNotFound(err) {
+		logCtx.WithError(err).Error("Failed to load clone job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load clone job"})
+		return nil, nil
+	}
+	var job CloneJob
+	if err != nil || snap.DataTo(&job) != nil || job.WorkspaceID != workspaceID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Clone job not found"})
+		return nil, nil
+	}
+	return jobRef, &job
+}
+
+// GetCloneJob returns a clone's progress. :workspaceId is the clone, not its source.
+func (ac *ApiController) GetCloneJob(c *gin.Context) {
+	logCtx := log.WithFields(log.Fields{"workspace_id": c.Param("workspaceId"), "clone_job_id": c.Param("cloneJobId"), "handler": "GetCloneJob"})
+	if _, job := ac.loadCloneJob(c, roleViewer, logCtx); job != nil {
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// ResumeCloneJob restarts a failed clone where it stopped (owners of the clone only).
+// Files already copied are not copied again.
+func (ac *ApiController) ResumeCloneJob(c *gin.Context) {
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": c.Param("workspaceId"), "clone_job_id": c.Param("cloneJobId"), "handler": "ResumeCloneJob"})
+	jobRef, _ := ac.loadCloneJob(c, roleOwner, logCtx)
+	if jobRef == nil {
+		return
+	}
+
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		snap, err := tx.Get(jobRef)
+		if err != nil {
+			return err
+		}
+		var job CloneJob
+		if err := snap.DataTo(&job); err != nil {
+			return err
+		}
+		if job.Status != cloneStatusFailed {
+			return errCloneNotResumable
+		}
+		return tx.Update(jobRef, []docstore.Update{
+			{Path: "status", Value: cloneStatusQueued},
+			{Path: "error", Value: docstore.Delete},
+			{Path: "completed_at", Value: docstore.Delete},
+			{Path: "updated_at", Value: NowISO8601()},
+		})
+	})
+	if errors.Is(err, errCloneNotResumable) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only failed clones can be resumed"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to resume clone job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resume clone"})
+		return
+	}
+	if err := ac.submitClone(jobRef); err != nil {
+		logCtx.WithError(err).Error("Failed to schedule resumed clone.")
+		ac.failClone(ctx, jobRef, "Clone could not be scheduled; resume it later")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Clone could not be scheduled; try again later"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"cloneJobId": jobRef.ID, "status": cloneStatusQueued})
+}
+
+// submitClone runs a queued clone job in the background.
+func (ac *ApiController) submitClone(jobRef *docstore.DocumentRef) error {
+	return ac.Background.SubmitWithTimeout("workspace_clone", ac.AppConfig.CloneTimeout, func(ctx context.Context) error {
+		return ac.runClone(ctx, jobRef)
+	})
+}
+
+// claimClone moves a queued clone job to copying and returns it, or nil when another run
+// already claimed it.
+func (ac *ApiController) claimClone(ctx context.Context, jobRef *docstore.DocumentRef) (*CloneJob, error) {
+	var job CloneJob
+	claimed := false
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		snap, err := tx.Get(jobRef)
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&job); err != nil {
+			return err
+		}
+		if claimed = job.Status == cloneStatusQueued; !claimed {
+			return nil
+		}
+		job.Status = cloneStatusCopying
+		return tx.Update(jobRef, []docstore.Update{
+			{Path: "status", Value: cloneStatusCopying},
+			{Path: "updated_at", Value: NowISO8601()},
+		})
+	})
+	if err != nil || !claimed {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// runClone copies the source's files after the job's cursor into the clone. Objects are
+// copied server-side before their entry is written, and progress is checkpointed every
+// cloneCheckpointFiles files, so a failed run resumes without losing or duplicating files.
+func (ac *ApiController) runClone(ctx context.Context, jobRef *docstore.DocumentRef) error {
+	job, err := ac.claimClone(ctx, jobRef)
+	if err != nil {
+		ac.failClone(ctx, jobRef, "Failed to start the clone")
+		return fmt.Errorf("failed to claim clone job: %w", err)
+	}
+	if job == nil {
+		return nil
+	}
+	logCtx := log.WithFields(log.Fields{"clone_job_id": job.CloneJobID, "workspace_id": job.WorkspaceID, "source_workspace_id": job.SourceWorkspaceID})
+
+	cloneFiles := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", job.WorkspaceID))
+	q := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", job.SourceWorkspaceID)).OrderBy(docstore.DocumentID, docstore.Asc)
+	if job.Cursor != "" {
+		q = q.StartAfter(job.Cursor)
+	}
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+
+	var pendingFiles int
+	var pendingBytes int64
+	cursor := job.Cursor
+	checkpoint := func() error {
+		if pendingFiles == 0 {
+			return nil
+		}
+		// The clone's storage and the job's counters move with the cursor, so files copied
+		// after the last checkpoint are counted exactly once when a resumed run repeats them.
+		wsRef := ac.FirestoreClient.Collection("workspaces").Doc(job.WorkspaceID)
+		err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+			wsSnap, err := tx.Get(wsRef)
+			if err != nil {
+				return err
+			}
+			var clone Workspace
+			if err := wsSnap.DataTo(&clone); err != nil {
+				return err
+			}
+			if err := ac.checkStorageQuota(clone, pendingBytes); err != nil {
+				return err
+			}
+			if err := tx.Update(jobRef, []docstore.Update{
+				{Path: "cursor", Value: cursor},
+				{Path: "files_copied", Value: docstore.Increment(pendingFiles)},
+				{Path: "bytes_copied", Value: docstore.Increment(pendingBytes)},
+				{Path: "updated_at", Value: NowISO8601()},
+			}); err != nil {
+				return err
+			}
+			return tx.Update(wsRef, []docstore.Update{
+				{Path: "storage_bytes", Value: docstore.Increment(pendingBytes)},
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to checkpoint clone: %w", err)
+		}
+		job.FilesCopied += pendingFiles
+		job.BytesCopied += pendingBytes
+		pendingFiles, pendingBytes = 0, 0
+		return nil
+	}
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			ac.failClone(ctx, jobRef, "Failed to read the source workspace")
+			return fmt.Errorf("failed to list source files: %w", err)
+		}
+		var src FileMetadata
+		if err := doc.DataTo(&src); err != nil {
+			logCtx.WithError(err).WithField("file_doc_id", doc.Ref.ID).Warn("Skipping unparseable file entry.")
+			continue
+		}
+		dst := clonedFile(src, job.CloneJobID, job.WorkspaceID, NowISO8601())
+		if dst.R2ObjectKey != "" {
+			if _, err := ac.r2().S3.CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:     aws.String(ac.R2BucketName),
+				CopySource: aws.String(ac.R2BucketName + "/" + url.PathEscape(src.R2ObjectKey)),
+				Key:        aws.String(dst.R2ObjectKey),
+			}); err != nil {
+				ac.failClone(ctx, jobRef, "Failed to copy "+src.FilePath)
+				return fmt.Errorf("failed to copy object %s: %w", src.R2ObjectKey, err)
+			}
+		}
+		// An entry left by a run that stopped before its checkpoint is kept as written.
+		if _, err := cloneFiles.Doc(doc.Ref.ID).Create(ctx, dst); err != nil && status.Code(err) != codes.AlreadyExists {
+			ac.failClone(ctx, jobRef, "Failed to save "+src.FilePath)
+			return fmt.Errorf("failed to write cloned entry %s: %w", src.FilePath, err)
+		}
+		cursor = doc.Ref.ID
+		pendingFiles++
+		pendingBytes += dst.Size
+		if pendingFiles >= cloneCheckpointFiles {
+			if err := checkpoint(); err != nil {
+				ac.failClone(ctx, jobRef, cloneCheckpointFailure(err))
+				return err
+			}
+		}
+	}
+	if err := checkpoint(); err != nil {
+		ac.failClone(ctx, jobRef, cloneCheckpointFailure(err))
+		return err
+	}
+
+	if _, err := ac.FirestoreClient.Collection("workspaces").Doc(job.WorkspaceID).Update(ctx, []docstore.Update{
+		{Path: "read_only", Value: false},
+		{Path: "updated_at", Value: NowISO8601()},
+	}); err != nil {
+		ac.failClone(ctx, jobRef, "Failed to unlock the clone")
+		return fmt.Errorf("failed to unlock cloned workspace: %w", err)
+	}
+	now := NowISO8601()
+	if _, err := jobRef.Update(ctx, []docstore.Update{
+		{Path: "status", Value: cloneStatusCompleted},
+		{Path: "updated_at", Value: now},
+		{Path: "completed_at", Value: now},
+	}); err != nil {
+		logCtx.WithError(err).Warn("Clone completed but the job status could not be updated.")
+	}
+
+	ac.recordActivity(ctx, job.WorkspaceID, job.UserID, activityWorkspaceCloned, map[string]interface{}{
+		"clone_job_id":        job.CloneJobID,
+		"source_workspace_id": job.SourceWorkspaceID,
+		"files_copied":        job.FilesCopied,
+		"bytes_copied":        job.BytesCopied,
+	})
+	logCtx.WithFields(log.Fields{"files_copied": job.FilesCopied, "bytes_copied": job.BytesCopied}).Info("Workspace clone completed.")
+	return nil
+}
+
+// cloneCheckpointFailure is the job error for a checkpoint that failed with err.
+func cloneCheckpointFailure(err error) string {
+	var quotaErr *storageQuotaError
+	if errors.As(err, &quotaErr) {
+		return fmt.Sprintf("The clone would grow to %d bytes, over the %d byte workspace quota", quotaErr.quota.ProjectedBytes, quotaErr.quota.LimitBytes)
+	}
+	return "Failed to save clone progress"
+}
+
+// failClone marks a clone job failed; its cursor is kept for ResumeCloneJob. It uses a fresh
+// deadline because the run's own context may already have expired.
+func (ac *ApiController) failClone(ctx context.Context, jobRef *docstore.DocumentRef, message string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	now := NowISO8601()
+	if _, err := jobRef.Update(ctx, []docstore.Update{
+		{Path: "status", Value: cloneStatusFailed},
+		{Path: "error", Value: message},
+		{Path: "updated_at", Value: now},
+		{Path: "completed_at", Value: now},
+	}); err != nil {
+		log.WithError(err).WithField("clone_job_id", jobRef.ID).Error("Failed to mark clone job as failed.")
+	}
+}
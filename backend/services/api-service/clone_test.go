@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloneWorkspaceName(t *testing.T) {
+	assert.Equal(t, "Copy of Examples", cloneWorkspaceName("Examples", ""))
+	assert.Equal(t, "Sandbox", cloneWorkspaceName("Examples", "Sandbox"))
+}
+
+func TestClonedFile(t *testing.T) {
+	src := FileMetadata{FileID: "f1", FilePath: "src/main.py", Type: "file", R2ObjectKey: "workspaces/ws-a/files/f1/main.py", Size: 12, Hash: "h", Version: "7"}
+	dst := clonedFile(src, "job-1", "ws-b", "2025-01-01T00:00:00.000Z")
+	assert.NotEqual(t, src.FileID, dst.FileID)
+	assert.Equal(t, dst.FileID, clonedFile(src, "job-1", "ws-b", "").FileID, "a resumed clone writes the same IDs")
+	assert.NotEqual(t, dst.FileID, clonedFile(src, "job-2", "ws-b", "").FileID)
+	assert.NoError(t, validateObjectKey("ws-b", dst.Type, dst.FileID, dst.R2ObjectKey))
+	assert.Equal(t, "1", dst.Version)
+	assert.Equal(t, int64(12), dst.Size)
+
+	folder := clonedFile(FileMetadata{FileID: "d1", FilePath: "src", Type: "folder"}, "job-1", "ws-b", "")
+	assert.Empty(t, folder.R2ObjectKey)
+	assert.Equal(t, "folder", folder.Type)
+}
+
+// waitForClone polls the clone job until it leaves the queued and copying states.
+func waitForClone(h *handlerHarness, userID, workspaceID, cloneJobID string) CloneJob {
+	h.t.Helper()
+	var job CloneJob
+	assert.Eventually(h.t, func() bool {
+		h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/clones/"+cloneJobID, userID, nil, &job)
+		return job.Status == cloneStatusCompleted || job.Status == cloneStatusFailed
+	}, 5*time.Second, 20*time.Millisecond)
+	return job
+}
+
+func TestHandlers_CloneWorkspace(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, viewer := "owner-"+uuid.New().String(), "viewer-"+uuid.New().String()
+	sourceID := h.createWorkspace(owner)
+	h.seedMembership(sourceID, viewer, "viewer", "")
+	mainFile := h.seedFile(sourceID, "src/main.py", "print('hi')")
+	h.seedFile(sourceID, "README.md", "# hi")
+	w := h.do(http.MethodPost, "/api/workspaces/"+sourceID+"/folders", owner, CreateFolderRequest{Path: "src"}, nil)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	path := "/api/workspaces/" + sourceID + "/clone"
+
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, path, "stranger-"+uuid.New().String(), nil, nil).Code)
+
+	var started struct {
+		CloneJobID  string `json:"cloneJobId"`
+		WorkspaceID string `json:"workspaceId"`
+		Name        string `json:"name"`
+	}
+	w = h.do(http.MethodPost, path, viewer, nil, &started)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.True(t, strings.HasPrefix(started.Name, "Copy of "))
+
+	job := waitForClone(h, viewer, started.WorkspaceID, started.CloneJobID)
+	assert.Equal(t, cloneStatusCompleted, job.Status, job.Error)
+	assert.Equal(t, 3, job.FilesCopied)
+	assert.Equal(t, int64(len("print('hi')")+len("# hi")), job.BytesCopied)
+
+	var manifest WorkspaceManifestResponse
+	w = h.do(http.MethodGet, "/api/workspaces/"+started.WorkspaceID+"/manifest", viewer, nil, &manifest)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "1", manifest.WorkspaceVersion)
+	byPath := map[string]FileMetadata{}
+	for _, f := range manifest.Manifest {
+		byPath[f.FilePath] = f
+	}
+	assert.Len(t, byPath, 3)
+	cloned := byPath["src/main.py"]
+	assert.NotEqual(t, mainFile.FileID, cloned.FileID)
+	assert.True(t, strings.HasPrefix(cloned.R2ObjectKey, "workspaces/"+started.WorkspaceID+"/files/"+cloned.FileID+"/"))
+	assert.True(t, h.objects.has(cloned.R2ObjectKey))
+	assert.True(t, h.objects.has(mainFile.R2ObjectKey), "the source is untouched")
+
+	ws, err := h.ac.loadWorkspace(context.Background(), started.WorkspaceID)
+	assert.NoError(t, err)
+	assert.False(t, ws.ReadOnly, "unlocked once the copy completed")
+	assert.Equal(t, sourceID, ws.ClonedFrom)
+	assert.Equal(t, viewer, workspaceOwner(ws))
+	assert.Equal(t, job.BytesCopied, ws.StorageBytes)
+
+	// Another member of the source cannot read the clone's job.
+	w = h.do(http.MethodGet, "/api/workspaces/"+started.WorkspaceID+"/clones/"+started.CloneJobID, owner, nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestHandlers_ResumeCloneWorkspace(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	sourceID := h.createWorkspace(owner)
+	for _, name := range []string{"a.py", "b.py", "c.py"} {
+		h.seedFile(sourceID, name, "print('"+name+"')")
+	}
+
+	h.objects.failOn("CopyObject", errors.New("r2 unavailable"))
+	var started struct {
+		CloneJobID  string `json:"cloneJobId"`
+		WorkspaceID string `json:"workspaceId"`
+	}
+	w := h.do(http.MethodPost, "/api/workspaces/"+sourceID+"/clone", owner, gin.H{"name": "Sandbox"}, &started)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	job := waitForClone(h, owner, started.WorkspaceID, started.CloneJobID)
+	assert.Equal(t, cloneStatusFailed, job.Status)
+
+	ws, err := h.ac.loadWorkspace(context.Background(), started.WorkspaceID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sandbox", ws.Name)
+	assert.True(t, ws.ReadOnly, "a partial clone stays read-only")
+
+	resume := "/api/workspaces/" + started.WorkspaceID + "/clones/" + started.CloneJobID + "/resume"
+	h.objects.failOn("CopyObject", nil)
+	w = h.do(http.MethodPost, resume, owner, nil, nil)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	job = waitForClone(h, owner, started.WorkspaceID, started.CloneJobID)
+	assert.Equal(t, cloneStatusCompleted, job.Status, job.Error)
+	assert.Equal(t, 3, job.FilesCopied)
+
+	assert.Equal(t, http.StatusConflict, h.do(http.MethodPost, resume, owner, nil, nil).Code, "completed clones are not resumed")
+}
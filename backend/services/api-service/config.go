@@ -1,19 +1,88 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/1liale/api-service/streaming"
 	"github.com/joho/godotenv"
 	log "github.com/sirupsen/logrus"
 )
 
 // ServiceConfig represents configuration for a single service
 type ServiceConfig struct {
-	QueueID        string `json:"queue_id"`
-	ServiceURL     string `json:"service_url"`
-	ServiceAccount string `json:"service_account"`
+	QueueID        string           `json:"queue_id"`
+	BatchQueueID   string           `json:"batch_queue_id,omitempty"` // Batch-priority executions; execution workers only. Unset uses QueueID
+	ServiceURL     string           `json:"service_url"`
+	ServiceAccount string           `json:"service_account"`
+	Limits         *ExecutionLimits `json:"limits,omitempty"` // Execution workers only
+	// File names that declare a project's dependencies; execution workers only. Unset uses the
+	// language's default list and an empty list turns detection off.
+	DependencyManifests []string `json:"dependency_manifests,omitempty"`
+}
+
+// ExecutionLimits holds the per-language defaults and hard caps for a single run.
+type ExecutionLimits struct {
+	DefaultTimeoutSeconds int      `json:"defaultTimeoutSeconds"`
+	MaxTimeoutSeconds     int      `json:"maxTimeoutSeconds"`
+	DefaultMemoryMb       int      `json:"defaultMemoryMb"`
+	MaxMemoryMb           int      `json:"maxMemoryMb"`
+	MaxCodeBytes          int      `json:"maxCodeBytes"`
+	EntrypointExtensions  []string `json:"entrypointExtensions"`
+}
+
+// defaultPythonLimits applies when python_worker has no limits block; the timeout and
+// memory defaults match the worker's own fallbacks.
+var defaultPythonLimits = ExecutionLimits{
+	DefaultTimeoutSeconds: 30,
+	MaxTimeoutSeconds:     60,
+	DefaultMemoryMb:       256,
+	MaxMemoryMb:           1024,
+	MaxCodeBytes:          64 * 1024,
+	EntrypointExtensions:  []string{".py"},
+}
+
+// defaultPythonDependencyManifests applies when python_worker sets no dependency_manifests.
+var defaultPythonDependencyManifests = []string{"requirements.txt", "pyproject.toml"}
+
+// validateDependencyManifests checks that every entry is a plain file name.
+func validateDependencyManifests(names []string) error {
+	for _, name := range names {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+			return fmt.Errorf("invalid dependency manifest %q: must be a file name like \"requirements.txt\"", name)
+		}
+	}
+	return nil
+}
+
+// validate checks that every limit is positive, defaults do not exceed maxima and
+// at least one entrypoint extension is allowed.
+func (l *ExecutionLimits) validate() error {
+	if l.DefaultTimeoutSeconds < 1 || l.MaxTimeoutSeconds < 1 || l.DefaultMemoryMb < 1 || l.MaxMemoryMb < 1 || l.MaxCodeBytes < 1 {
+		return fmt.Errorf("timeouts, memory and maxCodeBytes must be positive")
+	}
+	if l.DefaultTimeoutSeconds > l.MaxTimeoutSeconds {
+		return fmt.Errorf("defaultTimeoutSeconds (%d) exceeds maxTimeoutSeconds (%d)", l.DefaultTimeoutSeconds, l.MaxTimeoutSeconds)
+	}
+	if l.DefaultMemoryMb > l.MaxMemoryMb {
+		return fmt.Errorf("defaultMemoryMb (%d) exceeds maxMemoryMb (%d)", l.DefaultMemoryMb, l.MaxMemoryMb)
+	}
+	if len(l.EntrypointExtensions) == 0 {
+		return fmt.Errorf("entrypointExtensions must not be empty")
+	}
+	for _, ext := range l.EntrypointExtensions {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 {
+			return fmt.Errorf("invalid entrypoint extension %q: must look like \".py\"", ext)
+		}
+	}
+	return nil
 }
 
 // ServicesConfig represents the complete services configuration
@@ -21,6 +90,29 @@ type ServicesConfig struct {
 	PythonWorker  ServiceConfig `json:"python_worker"`
 	RagIndexing   ServiceConfig `json:"rag_indexing"`
 	RagQuery      ServiceConfig `json:"rag_query"`
+	EmailSender   ServiceConfig `json:"email_sender"`  // Optional; invitation emails are disabled without it
+	Notifications ServiceConfig `json:"notifications"` // Optional; job completion notifications are disabled without it
+}
+
+// Enabled reports whether the service has been configured.
+func (s ServiceConfig) Enabled() bool {
+	return s.QueueID != "" && s.ServiceURL != ""
+}
+
+// supportedLanguages lists the languages that have an execution worker, in display order.
+var supportedLanguages = []string{"python"}
+
+// inputFileLanguages lists the languages whose worker can feed a workspace file to stdin.
+var inputFileLanguages = map[string]bool{"python": true}
+
+// WorkerFor returns the execution worker service that handles a language.
+func (s ServicesConfig) WorkerFor(language string) (ServiceConfig, bool) {
+	switch language {
+	case "python":
+		return s.PythonWorker, true
+	default:
+		return ServiceConfig{}, false
+	}
 }
 
 // AppConfig holds all configuration for the application.
@@ -35,6 +127,220 @@ type AppConfig struct {
 	R2BucketName            string
 	LogLevel                string
 	Port                    string
+
+	// Optional second R2 key pair, switched to at runtime during credential rotation
+	R2SecondaryAccessKeyID     string
+	R2SecondarySecretAccessKey string
+
+	// Background task runner settings for fire-after-response work
+	BackgroundWorkers     int
+	BackgroundQueueSize   int
+	BackgroundTaskTimeout time.Duration
+
+	// Retention drives the delete_after TTL marker on ephemeral documents
+	Retention RetentionConfig
+
+	// LanguageCostWeights multiplies execution_ms into cost units per language (default 1.0)
+	LanguageCostWeights map[string]float64
+
+	// WarmInterval is the minimum gap between warm-up pings for one workspace and language
+	WarmInterval time.Duration
+
+	// StrictJSONBinding rejects request bodies containing unknown fields
+	StrictJSONBinding bool
+
+	// R2 deletion retry settings for the pending_r2_deletions maintenance task
+	R2DeletionBatchSize   int
+	R2DeletionMaxAttempts int
+
+	// FirestoreReadWarnThreshold flags requests reading more documents than this (0 disables)
+	FirestoreReadWarnThreshold int
+
+	// Request logging: optional fields to log, per-path levels for successful requests and
+	// the latency above which a request is logged as a warning (0 disables); see RequestLogger.
+	RequestLogFields     []string
+	RequestLogPathLevels map[string]log.Level
+	SlowRequestThreshold time.Duration
+
+	// AppBaseURL is the frontend origin used in emailed links; APIBaseURL is this service's
+	// public URL, used for callback URLs and as the audience of service-to-service ID tokens.
+	AppBaseURL string
+	APIBaseURL string
+
+	// Invitation and digest email settings (only used when services.email_sender is configured)
+	InvitationTemplateID          string
+	InvitationDeliveryMaxAttempts int
+	DigestTemplateID              string
+
+	// Invitations expire InvitationTTL after they are sent or resent, can be resent at most once
+	// per InvitationResendCooldown, and unaccepted ones are purged InvitationPurgeAfter after expiry.
+	InvitationTTL            time.Duration
+	InvitationResendCooldown time.Duration
+	InvitationPurgeAfter     time.Duration
+
+	// A user's default workspace (POST /workspaces/default) is seeded from
+	// DefaultWorkspaceTemplateID; without that template it starts empty.
+	DefaultWorkspaceTemplateID string
+
+	// Deleted workspaces can be restored for WorkspaceDeletionGrace before they are purged.
+	WorkspaceDeletionGrace time.Duration
+
+	// Expired time-boxed memberships are deleted MembershipPurgeAfter after they lapse.
+	MembershipPurgeAfter time.Duration
+
+	// Running jobs whose worker has not been heard from for JobHeartbeatTimeout are failed
+	// with worker_lost by the stale-jobs maintenance task.
+	JobHeartbeatTimeout time.Duration
+
+	// Execution tasks retried JobDeadLetterRetryCount times fail their job when they reach
+	// POST /internal/jobs/:jobId/dead-letter; set it to the queues' max attempts minus one.
+	// Jobs still queued JobQueuedTTL after they became due are failed by the stuck-jobs task.
+	JobDeadLetterRetryCount int
+	JobQueuedTTL            time.Duration
+
+	// A workspace may have at most MaxConcurrentJobs jobs queued or running; workspaces can
+	// override it and 0 disables the limit. A workspace at the limit is counted again after
+	// JobConcurrencyGrace, so a job whose finish is being written does not refuse the next run.
+	MaxConcurrentJobs   int
+	JobConcurrencyGrace time.Duration
+
+	// With ArchiveJobs, the expired-job sweep writes jobs to R2 under JobArchivePrefix as
+	// newline-delimited JSON before deleting them. Jobs removed by a Firestore TTL policy
+	// bypass the sweep and are not archived.
+	ArchiveJobs      bool
+	JobArchivePrefix string
+
+	// With DedupePublicExecutions, a public execution of the same code, language and input
+	// as one that completed within JobDedupeWindow returns that job instead of running again.
+	DedupePublicExecutions bool
+	JobDedupeWindow        time.Duration
+
+	// Execute requests may carry a deadline at least JobDeadlineMin away; the job retention
+	// window is the upper bound.
+	JobDeadlineMin time.Duration
+
+	// Editor autosave drafts: per-user, at most DraftMaxBytes each and DraftMaxPerUser per
+	// workspace, expiring DraftTTL after their last save.
+	DraftMaxBytes   int
+	DraftMaxPerUser int
+	DraftTTL        time.Duration
+
+	// Files of at least LargeFileURLThresholdBytes may be given download URLs valid for
+	// LargeFileURLTTL instead of the usual 15 minutes, so interrupted downloads can resume.
+	LargeFileURLThresholdBytes int64
+	LargeFileURLTTL            time.Duration
+
+	// A sync that deletes more than DestructiveSyncMaxDeletePercent of a workspace's files
+	// and more than DestructiveSyncMaxDeleteCount files needs explicit confirmation.
+	// Workspaces can override either; see DestructiveSyncSettings.
+	DestructiveSyncMaxDeletePercent int
+	DestructiveSyncMaxDeleteCount   int
+
+	// Forwarded headers are honored only from TrustedProxies (IPs or CIDRs), or, with
+	// TrustedProxyHops > 0, from that many proxies of any address; see ClientIPMiddleware.
+	TrustedProxies   []string
+	TrustedProxyHops int
+
+	// Requests to user-supplied URLs (webhooks, imports) never reach private, loopback or
+	// link-local addresses unless listed in OutboundAllowedCIDRs; OutboundDeniedCIDRs adds ranges.
+	OutboundAllowedCIDRs []*net.IPNet
+	OutboundDeniedCIDRs  []*net.IPNet
+	WebhookTimeout       time.Duration
+
+	// WebhookSigningSecret signs job callbacks that were requested without their own secret.
+	// When empty, every callbackUrl needs a callbackSecret.
+	WebhookSigningSecret string
+
+	// JobShareSigningKey signs shareable job result links; sharing is off without it. Links
+	// last JobShareTTL unless the sharer asks for less.
+	JobShareSigningKey string
+	JobShareTTL        time.Duration
+
+	// Workspace share links last WorkspaceShareLinkTTL unless the owner asks for less.
+	WorkspaceShareLinkTTL time.Duration
+
+	// InvitationSigningKey signs invitation accept tokens with the invitee's address and the
+	// invitation's expiry. Without it tokens are opaque and only checked against the stored
+	// invitation; tokens issued either way keep working when it is set later.
+	InvitationSigningKey string
+
+	// ListCursorSigningKey signs the pagination cursors of GET /workspaces. When unset, a
+	// random key is made at startup and cursors only work on the instance that issued them.
+	ListCursorSigningKey string
+
+	// SchedulerServiceAccount is the Cloud Scheduler identity allowed to call the
+	// /internal/jobs/cleanup sweeper; the route is not served without it.
+	SchedulerServiceAccount string
+
+	// Archive import limits and extension policy
+	ImportMaxArchiveBytes   int64
+	ImportMaxFileBytes      int64
+	ImportTimeout           time.Duration
+	ImportBlockedExtensions []string
+
+	// WorkspaceStorageQuotaBytes caps the file bytes one workspace may hold; 0 disables it.
+	WorkspaceStorageQuotaBytes int64
+
+	// MaxWorkspacesPerOwner caps how many workspaces one user may own; 0 disables it. Owners
+	// already over it keep their workspaces but cannot create more.
+	MaxWorkspacesPerOwner int
+
+	// CloneTimeout bounds one run of a workspace clone; an unfinished clone can be resumed.
+	CloneTimeout time.Duration
+
+	// Zip exports made by POST /workspaces/:workspaceId/export can be downloaded for
+	// WorkspaceExportTTL; the "workspace-export-expiry" maintenance task then deletes them.
+	WorkspaceExportTTL time.Duration
+
+	// Authenticated execution payloads larger than this pass their manifest by reference
+	TaskPayloadMaxBytes int
+
+	// Job output longer than JobOutputInlineBytes is stored in R2; the job keeps only its start.
+	JobOutputInlineBytes int
+
+	// Workspace executions may be scheduled up to ScheduleMaxHorizon ahead.
+	ScheduleMaxHorizon time.Duration
+
+	// Limits for streamed responses (exports, SSE)
+	Streaming streaming.Config
+
+	// Job event streams close after JobEventsMaxDuration, in place of Streaming.MaxDuration,
+	// so a job that never finishes does not hold an instance.
+	JobEventsMaxDuration time.Duration
+
+	// JobStore is "firestore" (default) or "embedded", an in-process store for single-instance
+	// deployments without Firestore that snapshots jobs to JobStorePath (memory only when
+	// empty). The embedded store requires WorkspacesEnabled to be off; see validateJobStore.
+	JobStore     string
+	JobStorePath string
+
+	// WorkspacesEnabled serves the workspace, sync and authenticated execution endpoints,
+	// which need Firestore and Firebase Auth. Without it only public execution is served.
+	WorkspacesEnabled bool
+
+	// Rate limiting: RateLimiter is "memory" (per instance) or "firestore" (shared)
+	RateLimiter               string
+	RateLimitShards           int
+	ExecuteRateLimitPerMinute int // 0 disables the execute limit
+	// Public shared workspace manifests are limited per share link; 0 disables the limit.
+	SharedManifestRateLimitPerMinute int
+
+	// Anonymous public executions are limited to AnonymousDailyQuota per client IP and UTC
+	// day, counted on up to AnonymousQuotaShards Firestore documents; 0 disables the quota.
+	AnonymousDailyQuota  int
+	AnonymousQuotaShards int
+
+	// Executions are refused with 429 while at least this many jobs are queued, as counted
+	// every QueueDepthRefresh. Authenticated executions have their own, higher limit; 0
+	// disables a limit.
+	QueueBackpressurePublicLimit int
+	QueueBackpressureAuthLimit   int
+	QueueDepthRefresh            time.Duration
+
+	// After RagQueueFailureThreshold consecutive enqueue failures, RAG indexing is attempted
+	// about once per RagQueueDrip and pending files are parked on the workspace's rag status
+	RagQueueFailureThreshold int
+	RagQueueDrip             time.Duration
 }
 
 // GetQueuePath returns the full Cloud Tasks queue path for a given queue ID
@@ -42,6 +348,52 @@ func (cfg *AppConfig) GetQueuePath(queueID string) string {
 	return fmt.Sprintf("projects/%s/locations/%s/queues/%s", cfg.GCPProjectID, cfg.GCPRegion, queueID)
 }
 
+// getEnvInt reads an integer environment variable, returning def when it is unset.
+func getEnvInt(name string, def int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer for %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// parseCIDRList parses a comma-separated list of CIDRs; an empty string yields no ranges.
+func parseCIDRList(raw string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", part, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR ranges.
+func parseTrustedProxies(raw string) ([]string, error) {
+	var proxies []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		if net.ParseIP(part) == nil {
+			if _, _, err := net.ParseCIDR(part); err != nil {
+				return nil, fmt.Errorf("invalid IP or CIDR %q", part)
+			}
+		}
+		proxies = append(proxies, part)
+	}
+	return proxies, nil
+}
+
 // LoadConfig loads configuration from environment variables.
 func LoadConfig() (*AppConfig, error) {
 	if err := godotenv.Load(); err != nil {
@@ -77,22 +429,37 @@ func LoadConfig() (*AppConfig, error) {
 		Value string
 	}
 
+	cfg.JobStore = os.Getenv("JOB_STORE")
+	if cfg.JobStore == "" {
+		cfg.JobStore = jobStoreFirestore
+	}
+	cfg.JobStorePath = os.Getenv("JOB_STORE_PATH")
+
 	criticalVars := []criticalEnvVar{
 		{"GCP_PROJECT_ID", cfg.GCPProjectID},
 		{"GCP_REGION", cfg.GCPRegion},
-		{"FIRESTORE_JOBS_COLLECTION", cfg.FirestoreJobsCollection},
 		{"R2_ACCOUNT_ID", cfg.R2AccountID},
 		{"R2_ACCESS_KEY_ID", cfg.R2AccessKeyID},
 		{"R2_SECRET_ACCESS_KEY", cfg.R2SecretAccessKey},
 		{"R2_BUCKET_NAME", cfg.R2BucketName},
 	}
 
+	if cfg.JobStore == jobStoreFirestore {
+		criticalVars = append(criticalVars, criticalEnvVar{"FIRESTORE_JOBS_COLLECTION", cfg.FirestoreJobsCollection})
+	}
+
 	for _, v := range criticalVars {
 		if v.Value == "" {
 			return nil, fmt.Errorf("missing critical environment variable: %s", v.Name)
 		}
 	}
 
+	cfg.R2SecondaryAccessKeyID = os.Getenv("R2_SECONDARY_ACCESS_KEY_ID")
+	cfg.R2SecondarySecretAccessKey = os.Getenv("R2_SECONDARY_SECRET_ACCESS_KEY")
+	if (cfg.R2SecondaryAccessKeyID == "") != (cfg.R2SecondarySecretAccessKey == "") {
+		return nil, fmt.Errorf("R2_SECONDARY_ACCESS_KEY_ID and R2_SECONDARY_SECRET_ACCESS_KEY must be set together")
+	}
+
 	// Validate services configuration
 	if cfg.Services.PythonWorker.QueueID == "" || cfg.Services.PythonWorker.ServiceURL == "" {
 		return nil, fmt.Errorf("incomplete python_worker configuration in SERVICES_CONFIG")
@@ -103,6 +470,19 @@ func LoadConfig() (*AppConfig, error) {
 	if cfg.Services.RagQuery.QueueID == "" || cfg.Services.RagQuery.ServiceURL == "" {
 		return nil, fmt.Errorf("incomplete rag_query configuration in SERVICES_CONFIG")
 	}
+	if cfg.Services.PythonWorker.Limits == nil {
+		limits := defaultPythonLimits
+		cfg.Services.PythonWorker.Limits = &limits
+	}
+	if err := cfg.Services.PythonWorker.Limits.validate(); err != nil {
+		return nil, fmt.Errorf("invalid python_worker limits in SERVICES_CONFIG: %w", err)
+	}
+	if cfg.Services.PythonWorker.DependencyManifests == nil {
+		cfg.Services.PythonWorker.DependencyManifests = defaultPythonDependencyManifests
+	}
+	if err := validateDependencyManifests(cfg.Services.PythonWorker.DependencyManifests); err != nil {
+		return nil, fmt.Errorf("invalid python_worker dependency_manifests in SERVICES_CONFIG: %w", err)
+	}
 
 	// Set defaults for non-critical fields
 	if cfg.LogLevel == "" {
@@ -113,5 +493,472 @@ func LoadConfig() (*AppConfig, error) {
 		cfg.Port = "8080" // Default port
 	}
 
+	var err error
+	if cfg.BackgroundWorkers, err = getEnvInt("BACKGROUND_WORKERS", 4); err != nil {
+		return nil, err
+	}
+	if cfg.BackgroundQueueSize, err = getEnvInt("BACKGROUND_QUEUE_SIZE", 100); err != nil {
+		return nil, err
+	}
+	timeoutSeconds, err := getEnvInt("BACKGROUND_TASK_TIMEOUT_SECONDS", 30)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.BackgroundWorkers < 1 || cfg.BackgroundQueueSize < 1 || timeoutSeconds < 1 {
+		return nil, fmt.Errorf("BACKGROUND_WORKERS, BACKGROUND_QUEUE_SIZE and BACKGROUND_TASK_TIMEOUT_SECONDS must be positive")
+	}
+	cfg.BackgroundTaskTimeout = time.Duration(timeoutSeconds) * time.Second
+
+	if cfg.Retention, err = loadRetentionConfig(); err != nil {
+		return nil, err
+	}
+
+	cfg.StrictJSONBinding = os.Getenv("STRICT_JSON_BINDING") == "true"
+
+	if cfg.WarmInterval, err = loadWarmInterval(); err != nil {
+		return nil, err
+	}
+
+	if cfg.R2DeletionBatchSize, err = getEnvInt("R2_DELETION_BATCH_SIZE", 500); err != nil {
+		return nil, err
+	}
+	if cfg.R2DeletionMaxAttempts, err = getEnvInt("R2_DELETION_MAX_ATTEMPTS", 5); err != nil {
+		return nil, err
+	}
+	if cfg.R2DeletionBatchSize < 1 || cfg.R2DeletionBatchSize > r2DeleteObjectsLimit || cfg.R2DeletionMaxAttempts < 1 {
+		return nil, fmt.Errorf("R2_DELETION_BATCH_SIZE must be between 1 and %d and R2_DELETION_MAX_ATTEMPTS must be positive", r2DeleteObjectsLimit)
+	}
+
+	cfg.DefaultWorkspaceTemplateID = os.Getenv("DEFAULT_WORKSPACE_TEMPLATE_ID")
+	if cfg.DefaultWorkspaceTemplateID == "" {
+		cfg.DefaultWorkspaceTemplateID = "starter"
+	}
+
+	cfg.AppBaseURL = strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+	cfg.APIBaseURL = strings.TrimRight(os.Getenv("API_BASE_URL"), "/")
+	cfg.InvitationTemplateID = os.Getenv("INVITATION_EMAIL_TEMPLATE_ID")
+	if cfg.InvitationTemplateID == "" {
+		cfg.InvitationTemplateID = "workspace_invitation"
+	}
+	cfg.DigestTemplateID = os.Getenv("DIGEST_EMAIL_TEMPLATE_ID")
+	if cfg.DigestTemplateID == "" {
+		cfg.DigestTemplateID = "workspace_digest"
+	}
+	if cfg.InvitationDeliveryMaxAttempts, err = getEnvInt("INVITATION_DELIVERY_MAX_ATTEMPTS", 3); err != nil {
+		return nil, err
+	}
+	if cfg.Services.EmailSender.Enabled() && (cfg.AppBaseURL == "" || cfg.APIBaseURL == "") {
+		return nil, fmt.Errorf("APP_BASE_URL and API_BASE_URL are required when email_sender is configured")
+	}
+	if cfg.Services.Notifications.Enabled() && cfg.AppBaseURL == "" {
+		return nil, fmt.Errorf("APP_BASE_URL is required when notifications is configured")
+	}
+	invitationTTLDays, err := getEnvInt("INVITATION_TTL_DAYS", 14)
+	if err != nil {
+		return nil, err
+	}
+	invitationResendCooldownMinutes, err := getEnvInt("INVITATION_RESEND_COOLDOWN_MINUTES", 10)
+	if err != nil {
+		return nil, err
+	}
+	invitationPurgeAfterDays, err := getEnvInt("INVITATION_PURGE_AFTER_DAYS", 30)
+	if err != nil {
+		return nil, err
+	}
+	if invitationTTLDays < 1 || invitationResendCooldownMinutes < 0 || invitationPurgeAfterDays < 0 {
+		return nil, fmt.Errorf("INVITATION_TTL_DAYS must be positive and INVITATION_RESEND_COOLDOWN_MINUTES and INVITATION_PURGE_AFTER_DAYS must not be negative")
+	}
+	cfg.InvitationTTL = time.Duration(invitationTTLDays) * 24 * time.Hour
+	cfg.InvitationResendCooldown = time.Duration(invitationResendCooldownMinutes) * time.Minute
+	cfg.InvitationPurgeAfter = time.Duration(invitationPurgeAfterDays) * 24 * time.Hour
+	workspaceDeletionGraceDays, err := getEnvInt("WORKSPACE_DELETION_GRACE_DAYS", 7)
+	if err != nil {
+		return nil, err
+	}
+	if workspaceDeletionGraceDays < 0 {
+		return nil, fmt.Errorf("WORKSPACE_DELETION_GRACE_DAYS must not be negative")
+	}
+	cfg.WorkspaceDeletionGrace = time.Duration(workspaceDeletionGraceDays) * 24 * time.Hour
+	membershipPurgeAfterDays, err := getEnvInt("MEMBERSHIP_PURGE_AFTER_DAYS", 30)
+	if err != nil {
+		return nil, err
+	}
+	if membershipPurgeAfterDays < 0 {
+		return nil, fmt.Errorf("MEMBERSHIP_PURGE_AFTER_DAYS must not be negative")
+	}
+	cfg.MembershipPurgeAfter = time.Duration(membershipPurgeAfterDays) * 24 * time.Hour
+	jobHeartbeatTimeoutSeconds, err := getEnvInt("JOB_HEARTBEAT_TIMEOUT_SECONDS", 120)
+	if err != nil {
+		return nil, err
+	}
+	if jobHeartbeatTimeoutSeconds < 1 {
+		return nil, fmt.Errorf("JOB_HEARTBEAT_TIMEOUT_SECONDS must be positive")
+	}
+	cfg.JobHeartbeatTimeout = time.Duration(jobHeartbeatTimeoutSeconds) * time.Second
+	if cfg.MaxConcurrentJobs, err = getEnvInt("MAX_CONCURRENT_JOBS", 10); err != nil {
+		return nil, err
+	}
+	jobConcurrencyGraceMs, err := getEnvInt("JOB_CONCURRENCY_GRACE_MS", 1500)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConcurrentJobs < 0 || jobConcurrencyGraceMs < 0 {
+		return nil, fmt.Errorf("MAX_CONCURRENT_JOBS and JOB_CONCURRENCY_GRACE_MS must not be negative")
+	}
+	cfg.JobConcurrencyGrace = time.Duration(jobConcurrencyGraceMs) * time.Millisecond
+	if cfg.JobDeadLetterRetryCount, err = getEnvInt("JOB_DEAD_LETTER_RETRY_COUNT", 4); err != nil {
+		return nil, err
+	}
+	if cfg.JobDeadLetterRetryCount < 1 {
+		return nil, fmt.Errorf("JOB_DEAD_LETTER_RETRY_COUNT must be positive")
+	}
+	jobQueuedTTLMinutes, err := getEnvInt("JOB_QUEUED_TTL_MINUTES", 60)
+	if err != nil {
+		return nil, err
+	}
+	if jobQueuedTTLMinutes < 1 {
+		return nil, fmt.Errorf("JOB_QUEUED_TTL_MINUTES must be positive")
+	}
+	cfg.JobQueuedTTL = time.Duration(jobQueuedTTLMinutes) * time.Minute
+	cfg.ArchiveJobs = os.Getenv("ARCHIVE_JOBS") == "true"
+	cfg.JobArchivePrefix = strings.Trim(os.Getenv("JOB_ARCHIVE_PREFIX"), "/")
+	if cfg.JobArchivePrefix == "" {
+		cfg.JobArchivePrefix = "archives/jobs"
+	}
+	cfg.DedupePublicExecutions = os.Getenv("DEDUPE_PUBLIC_EXECUTIONS") == "true"
+	jobDedupeWindowMinutes, err := getEnvInt("JOB_DEDUPE_WINDOW_MINUTES", 10)
+	if err != nil {
+		return nil, err
+	}
+	if jobDedupeWindowMinutes < 1 {
+		return nil, fmt.Errorf("JOB_DEDUPE_WINDOW_MINUTES must be positive")
+	}
+	cfg.JobDedupeWindow = time.Duration(jobDedupeWindowMinutes) * time.Minute
+	jobDeadlineMinSeconds, err := getEnvInt("JOB_DEADLINE_MIN_SECONDS", 10)
+	if err != nil {
+		return nil, err
+	}
+	if jobDeadlineMinSeconds < 1 {
+		return nil, fmt.Errorf("JOB_DEADLINE_MIN_SECONDS must be positive")
+	}
+	cfg.JobDeadlineMin = time.Duration(jobDeadlineMinSeconds) * time.Second
+	draftMaxKB, err := getEnvInt("DRAFT_MAX_KB", 512)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DraftMaxPerUser, err = getEnvInt("DRAFT_MAX_PER_USER", 20); err != nil {
+		return nil, err
+	}
+	draftTTLDays, err := getEnvInt("DRAFT_TTL_DAYS", 3)
+	if err != nil {
+		return nil, err
+	}
+	if draftMaxKB < 1 || cfg.DraftMaxPerUser < 1 || draftTTLDays < 1 {
+		return nil, fmt.Errorf("DRAFT_MAX_KB, DRAFT_MAX_PER_USER and DRAFT_TTL_DAYS must be positive")
+	}
+	cfg.DraftMaxBytes = draftMaxKB << 10
+	cfg.DraftTTL = time.Duration(draftTTLDays) * 24 * time.Hour
+	largeFileThresholdMB, err := getEnvInt("LARGE_FILE_URL_THRESHOLD_MB", 100)
+	if err != nil {
+		return nil, err
+	}
+	// SigV4 presigned URLs cannot be valid for longer than seven days.
+	largeFileURLTTLHours, err := getEnvInt("LARGE_FILE_URL_TTL_HOURS", 12)
+	if err != nil {
+		return nil, err
+	}
+	if largeFileThresholdMB < 1 || largeFileURLTTLHours < 1 || largeFileURLTTLHours > 7*24 {
+		return nil, fmt.Errorf("LARGE_FILE_URL_THRESHOLD_MB must be positive and LARGE_FILE_URL_TTL_HOURS between 1 and 168")
+	}
+	cfg.LargeFileURLThresholdBytes = int64(largeFileThresholdMB) << 20
+	cfg.LargeFileURLTTL = time.Duration(largeFileURLTTLHours) * time.Hour
+	if cfg.DestructiveSyncMaxDeletePercent, err = getEnvInt("DESTRUCTIVE_SYNC_MAX_DELETE_PERCENT", 50); err != nil {
+		return nil, err
+	}
+	if cfg.DestructiveSyncMaxDeleteCount, err = getEnvInt("DESTRUCTIVE_SYNC_MAX_DELETE_COUNT", 100); err != nil {
+		return nil, err
+	}
+	if cfg.DestructiveSyncMaxDeletePercent < 1 || cfg.DestructiveSyncMaxDeletePercent > 100 || cfg.DestructiveSyncMaxDeleteCount < 0 {
+		return nil, fmt.Errorf("DESTRUCTIVE_SYNC_MAX_DELETE_PERCENT must be between 1 and 100 and DESTRUCTIVE_SYNC_MAX_DELETE_COUNT must not be negative")
+	}
+
+	if cfg.TrustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	if cfg.TrustedProxyHops, err = getEnvInt("TRUSTED_PROXY_HOPS", 0); err != nil {
+		return nil, err
+	}
+	if cfg.TrustedProxyHops < 0 {
+		return nil, fmt.Errorf("TRUSTED_PROXY_HOPS must not be negative")
+	}
+	if cfg.OutboundAllowedCIDRs, err = parseCIDRList(os.Getenv("OUTBOUND_ALLOWED_CIDRS")); err != nil {
+		return nil, fmt.Errorf("OUTBOUND_ALLOWED_CIDRS: %w", err)
+	}
+	if cfg.OutboundDeniedCIDRs, err = parseCIDRList(os.Getenv("OUTBOUND_DENIED_CIDRS")); err != nil {
+		return nil, fmt.Errorf("OUTBOUND_DENIED_CIDRS: %w", err)
+	}
+	webhookTimeoutSeconds, err := getEnvInt("WEBHOOK_TIMEOUT_SECONDS", 10)
+	if err != nil {
+		return nil, err
+	}
+	if webhookTimeoutSeconds < 1 {
+		return nil, fmt.Errorf("WEBHOOK_TIMEOUT_SECONDS must be positive")
+	}
+	cfg.WebhookTimeout = time.Duration(webhookTimeoutSeconds) * time.Second
+	cfg.WebhookSigningSecret = os.Getenv("WEBHOOK_SIGNING_SECRET")
+	cfg.JobShareSigningKey = os.Getenv("JOB_SHARE_SIGNING_KEY")
+	jobShareTTLHours, err := getEnvInt("JOB_SHARE_TTL_HOURS", 168)
+	if err != nil {
+		return nil, err
+	}
+	if jobShareTTLHours < 1 || jobShareTTLHours > 720 {
+		return nil, fmt.Errorf("JOB_SHARE_TTL_HOURS must be between 1 and 720")
+	}
+	cfg.JobShareTTL = time.Duration(jobShareTTLHours) * time.Hour
+	shareLinkTTLHours, err := getEnvInt("WORKSPACE_SHARE_LINK_TTL_HOURS", 720)
+	if err != nil {
+		return nil, err
+	}
+	if shareLinkTTLHours < 1 || shareLinkTTLHours > 8760 {
+		return nil, fmt.Errorf("WORKSPACE_SHARE_LINK_TTL_HOURS must be between 1 and 8760")
+	}
+	cfg.WorkspaceShareLinkTTL = time.Duration(shareLinkTTLHours) * time.Hour
+	cfg.InvitationSigningKey = os.Getenv("INVITATION_SIGNING_KEY")
+	cfg.ListCursorSigningKey = os.Getenv("LIST_CURSOR_SIGNING_KEY")
+	if cfg.ListCursorSigningKey == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate list cursor signing key: %w", err)
+		}
+		cfg.ListCursorSigningKey = hex.EncodeToString(key)
+		log.Warn("LIST_CURSOR_SIGNING_KEY is not set; workspace list cursors will not survive across instances.")
+	}
+	cfg.SchedulerServiceAccount = os.Getenv("SCHEDULER_SERVICE_ACCOUNT")
+
+	maxArchiveMB, err := getEnvInt("IMPORT_MAX_ARCHIVE_MB", 50)
+	if err != nil {
+		return nil, err
+	}
+	maxFileMB, err := getEnvInt("IMPORT_MAX_FILE_MB", 5)
+	if err != nil {
+		return nil, err
+	}
+	importTimeoutSeconds, err := getEnvInt("IMPORT_TIMEOUT_SECONDS", 300)
+	if err != nil {
+		return nil, err
+	}
+	if maxArchiveMB < 1 || maxFileMB < 1 || importTimeoutSeconds < 1 {
+		return nil, fmt.Errorf("IMPORT_MAX_ARCHIVE_MB, IMPORT_MAX_FILE_MB and IMPORT_TIMEOUT_SECONDS must be positive")
+	}
+	cfg.ImportMaxArchiveBytes = int64(maxArchiveMB) << 20
+	cfg.ImportMaxFileBytes = int64(maxFileMB) << 20
+	cfg.ImportTimeout = time.Duration(importTimeoutSeconds) * time.Second
+	cfg.ImportBlockedExtensions = defaultImportBlockedExtensions
+	if raw, ok := os.LookupEnv("IMPORT_BLOCKED_EXTENSIONS"); ok {
+		cfg.ImportBlockedExtensions = nil
+		for _, ext := range strings.Split(raw, ",") {
+			if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+				cfg.ImportBlockedExtensions = append(cfg.ImportBlockedExtensions, ext)
+			}
+		}
+	}
+
+	storageQuotaMB, err := getEnvInt("WORKSPACE_STORAGE_QUOTA_MB", 1024)
+	if err != nil {
+		return nil, err
+	}
+	if storageQuotaMB < 0 {
+		return nil, fmt.Errorf("WORKSPACE_STORAGE_QUOTA_MB must not be negative")
+	}
+	cfg.WorkspaceStorageQuotaBytes = int64(storageQuotaMB) << 20
+	if cfg.MaxWorkspacesPerOwner, err = getEnvInt("MAX_WORKSPACES_PER_OWNER", 25); err != nil {
+		return nil, err
+	}
+	if cfg.MaxWorkspacesPerOwner < 0 {
+		return nil, fmt.Errorf("MAX_WORKSPACES_PER_OWNER must not be negative")
+	}
+
+	cloneTimeoutSeconds, err := getEnvInt("CLONE_TIMEOUT_SECONDS", 600)
+	if err != nil {
+		return nil, err
+	}
+	if cloneTimeoutSeconds < 1 {
+		return nil, fmt.Errorf("CLONE_TIMEOUT_SECONDS must be positive")
+	}
+	cfg.CloneTimeout = time.Duration(cloneTimeoutSeconds) * time.Second
+	exportTTLDays, err := getEnvInt("WORKSPACE_EXPORT_TTL_DAYS", 7)
+	if err != nil {
+		return nil, err
+	}
+	if exportTTLDays < 1 {
+		return nil, fmt.Errorf("WORKSPACE_EXPORT_TTL_DAYS must be positive")
+	}
+	cfg.WorkspaceExportTTL = time.Duration(exportTTLDays) * 24 * time.Hour
+
+	// Cloud Tasks rejects tasks over 1 MB; the default leaves room for headers and the OIDC token.
+	taskPayloadMaxKB, err := getEnvInt("TASK_PAYLOAD_MAX_KB", 900)
+	if err != nil {
+		return nil, err
+	}
+	if taskPayloadMaxKB < 1 || taskPayloadMaxKB > 1024 {
+		return nil, fmt.Errorf("TASK_PAYLOAD_MAX_KB must be between 1 and 1024")
+	}
+	cfg.TaskPayloadMaxBytes = taskPayloadMaxKB << 10
+
+	// Firestore documents are capped at 1 MiB, which the rest of the job must also fit in.
+	jobOutputInlineKB, err := getEnvInt("JOB_OUTPUT_INLINE_KB", 256)
+	if err != nil {
+		return nil, err
+	}
+	if jobOutputInlineKB < 1 || jobOutputInlineKB > 768 {
+		return nil, fmt.Errorf("JOB_OUTPUT_INLINE_KB must be between 1 and 768")
+	}
+	cfg.JobOutputInlineBytes = jobOutputInlineKB << 10
+
+	// Cloud Tasks refuses a ScheduleTime more than 30 days ahead.
+	scheduleMaxDays, err := getEnvInt("SCHEDULE_MAX_HORIZON_DAYS", 30)
+	if err != nil {
+		return nil, err
+	}
+	if scheduleMaxDays < 1 || scheduleMaxDays > 30 {
+		return nil, fmt.Errorf("SCHEDULE_MAX_HORIZON_DAYS must be between 1 and 30")
+	}
+	cfg.ScheduleMaxHorizon = time.Duration(scheduleMaxDays) * 24 * time.Hour
+
+	streamMaxSeconds, err := getEnvInt("STREAM_MAX_DURATION_SECONDS", 900)
+	if err != nil {
+		return nil, err
+	}
+	streamWriteSeconds, err := getEnvInt("STREAM_WRITE_TIMEOUT_SECONDS", 30)
+	if err != nil {
+		return nil, err
+	}
+	if streamMaxSeconds < 1 || streamWriteSeconds < 1 {
+		return nil, fmt.Errorf("STREAM_MAX_DURATION_SECONDS and STREAM_WRITE_TIMEOUT_SECONDS must be positive")
+	}
+	cfg.Streaming = streaming.Config{
+		MaxDuration:       time.Duration(streamMaxSeconds) * time.Second,
+		WriteTimeout:      time.Duration(streamWriteSeconds) * time.Second,
+		HeartbeatInterval: streaming.DefaultHeartbeatInterval,
+	}
+	jobEventsMaxSeconds, err := getEnvInt("JOB_EVENTS_MAX_DURATION_SECONDS", 600)
+	if err != nil {
+		return nil, err
+	}
+	if jobEventsMaxSeconds < 1 {
+		return nil, fmt.Errorf("JOB_EVENTS_MAX_DURATION_SECONDS must be positive")
+	}
+	cfg.JobEventsMaxDuration = time.Duration(jobEventsMaxSeconds) * time.Second
+
+	cfg.RateLimiter = os.Getenv("RATE_LIMITER")
+	if cfg.RateLimiter == "" {
+		cfg.RateLimiter = "memory"
+	}
+	if cfg.RateLimiter != "memory" && cfg.RateLimiter != "firestore" {
+		return nil, fmt.Errorf("RATE_LIMITER must be \"memory\" or \"firestore\", got %q", cfg.RateLimiter)
+	}
+	if cfg.RateLimitShards, err = getEnvInt("RATE_LIMIT_SHARDS", 4); err != nil {
+		return nil, err
+	}
+	if cfg.ExecuteRateLimitPerMinute, err = getEnvInt("EXECUTE_RATE_LIMIT_PER_MINUTE", 30); err != nil {
+		return nil, err
+	}
+	if cfg.RateLimitShards < 1 || cfg.ExecuteRateLimitPerMinute < 0 {
+		return nil, fmt.Errorf("RATE_LIMIT_SHARDS must be positive and EXECUTE_RATE_LIMIT_PER_MINUTE must not be negative")
+	}
+	if cfg.SharedManifestRateLimitPerMinute, err = getEnvInt("SHARED_MANIFEST_RATE_LIMIT_PER_MINUTE", 60); err != nil {
+		return nil, err
+	}
+	if cfg.SharedManifestRateLimitPerMinute < 0 {
+		return nil, fmt.Errorf("SHARED_MANIFEST_RATE_LIMIT_PER_MINUTE must not be negative")
+	}
+	if cfg.QueueBackpressurePublicLimit, err = getEnvInt("QUEUE_BACKPRESSURE_PUBLIC_LIMIT", 0); err != nil {
+		return nil, err
+	}
+	if cfg.QueueBackpressureAuthLimit, err = getEnvInt("QUEUE_BACKPRESSURE_AUTH_LIMIT", 2*cfg.QueueBackpressurePublicLimit); err != nil {
+		return nil, err
+	}
+	if cfg.QueueBackpressurePublicLimit < 0 || cfg.QueueBackpressureAuthLimit < 0 {
+		return nil, fmt.Errorf("QUEUE_BACKPRESSURE_PUBLIC_LIMIT and QUEUE_BACKPRESSURE_AUTH_LIMIT must not be negative")
+	}
+	if cfg.QueueBackpressurePublicLimit > 0 && cfg.QueueBackpressureAuthLimit > 0 && cfg.QueueBackpressureAuthLimit < cfg.QueueBackpressurePublicLimit {
+		return nil, fmt.Errorf("QUEUE_BACKPRESSURE_AUTH_LIMIT must not be lower than QUEUE_BACKPRESSURE_PUBLIC_LIMIT")
+	}
+	if cfg.AnonymousDailyQuota, err = getEnvInt("ANONYMOUS_DAILY_QUOTA", 0); err != nil {
+		return nil, err
+	}
+	if cfg.AnonymousQuotaShards, err = getEnvInt("ANONYMOUS_QUOTA_SHARDS", 4); err != nil {
+		return nil, err
+	}
+	if cfg.AnonymousDailyQuota < 0 || cfg.AnonymousQuotaShards < 1 {
+		return nil, fmt.Errorf("ANONYMOUS_DAILY_QUOTA must not be negative and ANONYMOUS_QUOTA_SHARDS must be positive")
+	}
+	queueDepthRefreshSeconds, err := getEnvInt("QUEUE_DEPTH_REFRESH_SECONDS", 15)
+	if err != nil {
+		return nil, err
+	}
+	if queueDepthRefreshSeconds < 1 {
+		return nil, fmt.Errorf("QUEUE_DEPTH_REFRESH_SECONDS must be positive")
+	}
+	cfg.QueueDepthRefresh = time.Duration(queueDepthRefreshSeconds) * time.Second
+
+	cfg.WorkspacesEnabled = os.Getenv("WORKSPACES_ENABLED") != "false"
+	if err := cfg.validateJobStore(); err != nil {
+		return nil, err
+	}
+
+	if cfg.RagQueueFailureThreshold, err = getEnvInt("RAG_QUEUE_FAILURE_THRESHOLD", 3); err != nil {
+		return nil, err
+	}
+	ragQueueDripSeconds, err := getEnvInt("RAG_QUEUE_DRIP_SECONDS", 60)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RagQueueFailureThreshold < 1 || ragQueueDripSeconds < 1 {
+		return nil, fmt.Errorf("RAG_QUEUE_FAILURE_THRESHOLD and RAG_QUEUE_DRIP_SECONDS must be positive")
+	}
+	cfg.RagQueueDrip = time.Duration(ragQueueDripSeconds) * time.Second
+
+	if cfg.FirestoreReadWarnThreshold, err = getEnvInt("FIRESTORE_READ_WARN_THRESHOLD", 200); err != nil {
+		return nil, err
+	}
+	if cfg.FirestoreReadWarnThreshold < 0 {
+		return nil, fmt.Errorf("FIRESTORE_READ_WARN_THRESHOLD must not be negative")
+	}
+	requestLogFields, ok := os.LookupEnv("LOG_REQUEST_FIELDS")
+	if !ok {
+		requestLogFields = "user_id,request_id,costs"
+	}
+	if cfg.RequestLogFields, err = parseRequestLogFields(requestLogFields); err != nil {
+		return nil, fmt.Errorf("LOG_REQUEST_FIELDS: %w", err)
+	}
+	pathLogLevels, ok := os.LookupEnv("LOG_PATH_LEVELS")
+	if !ok {
+		pathLogLevels = "/healthz=debug"
+	}
+	if cfg.RequestLogPathLevels, err = parsePathLogLevels(pathLogLevels); err != nil {
+		return nil, fmt.Errorf("LOG_PATH_LEVELS: %w", err)
+	}
+	slowRequestMs, err := getEnvInt("SLOW_REQUEST_THRESHOLD_MS", 2000)
+	if err != nil {
+		return nil, err
+	}
+	if slowRequestMs < 0 {
+		return nil, fmt.Errorf("SLOW_REQUEST_THRESHOLD_MS must not be negative")
+	}
+	cfg.SlowRequestThreshold = time.Duration(slowRequestMs) * time.Millisecond
+
+	cfg.LanguageCostWeights = map[string]float64{}
+	if weightsJSON := os.Getenv("LANGUAGE_COST_WEIGHTS"); weightsJSON != "" {
+		if err := json.Unmarshal([]byte(weightsJSON), &cfg.LanguageCostWeights); err != nil {
+			return nil, fmt.Errorf("failed to parse LANGUAGE_COST_WEIGHTS JSON: %w", err)
+		}
+		for lang, w := range cfg.LanguageCostWeights {
+			if w < 0 {
+				return nil, fmt.Errorf("LANGUAGE_COST_WEIGHTS: weight for %s must not be negative", lang)
+			}
+		}
+	}
+
 	return cfg, nil
 } 
\ No newline at end of file
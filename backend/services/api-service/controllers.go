@@ -3,26 +3,30 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	cloudtaskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
-	"cloud.google.com/go/firestore"
+	"github.com/1liale/api-service/docstore"
+	"github.com/1liale/api-service/streaming"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	gax "github.com/googleapis/gax-go/v2"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/api/iterator"
 )
 
 // checkWorkspaceMembership queries Firestore to see if a user is a member of a workspace.
-func checkWorkspaceMembership(ctx context.Context, fsClient *firestore.Client, userID string, workspaceID string) (bool, error) {
+func checkWorkspaceMembership(ctx context.Context, fsClient *docstore.Client, userID string, workspaceID string) (bool, error) {
 	logCtx := log.WithFields(log.Fields{
 		"user_id":      userID,
 		"workspace_id": workspaceID,
@@ -37,7 +41,7 @@ func checkWorkspaceMembership(ctx context.Context, fsClient *firestore.Client, u
 	iter := query.Documents(ctx)
 	defer iter.Stop()
 
-	_, err := iter.Next()
+	doc, err := iter.Next()
 	if err == iterator.Done {
 		logCtx.Info("User is not a member of the workspace.")
 		return false, nil // No document found, so user is not a member
@@ -47,34 +51,126 @@ func checkWorkspaceMembership(ctx context.Context, fsClient *firestore.Client, u
 		return false, fmt.Errorf("failed to query workspace membership: %w", err)
 	}
 
+	var membership WorkspaceMembership
+	if err := doc.DataTo(&membership); err != nil {
+		return false, fmt.Errorf("failed to parse workspace membership: %w", err)
+	}
+	if membershipExpired(membership, time.Now()) {
+		logCtx.WithField("expires_at", membership.ExpiresAt).Info("User's workspace membership has expired.")
+		return false, nil // Expired members are treated as non-members
+	}
+
 	logCtx.Info("User is a member of the workspace.")
 	return true, nil // Document found, user is a member
 }
 
+// getWorkspaceMembership returns the caller's membership in a workspace, or nil if they are not a
+// member or their membership has expired.
+func getWorkspaceMembership(ctx context.Context, fsClient *docstore.Client, userID string, workspaceID string) (*WorkspaceMembership, error) {
+	query := fsClient.Collection("workspace_memberships").
+		Where("user_id", "==", userID).
+		Where("workspace_id", "==", workspaceID).
+		Limit(1)
+
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	doc, err := iter.Next()
+	if err == iterator.Done {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workspace membership: %w", err)
+	}
+
+	var membership WorkspaceMembership
+	if err := doc.DataTo(&membership); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace membership: %w", err)
+	}
+	if membershipExpired(membership, time.Now()) {
+		return nil, nil
+	}
+	return &membership, nil
+}
+
+// errWorkspaceReadOnly is returned from sync transactions when the workspace has been frozen.
+var errWorkspaceReadOnly = errors.New("workspace is read-only")
+
+// TaskEnqueuer creates and deletes Cloud Tasks. *cloudtasks.Client implements it; handler
+// tests substitute a fake that records requests.
+type TaskEnqueuer interface {
+	CreateTask(ctx context.Context, req *cloudtaskspb.CreateTaskRequest, opts ...gax.CallOption) (*cloudtaskspb.Task, error)
+	DeleteTask(ctx context.Context, req *cloudtaskspb.DeleteTaskRequest, opts ...gax.CallOption) error
+}
+
 // ApiController holds dependencies for HTTP handlers.
 type ApiController struct {
-	FirestoreClient         *firestore.Client
-	TasksClient             *cloudtasks.Client
-	R2PresignClient         *s3.PresignClient
-	R2S3Client              *s3.Client
+	FirestoreClient         *docstore.Client
+	TasksClient             TaskEnqueuer
 	R2BucketName            string
 	Services                ServicesConfig
 	AppConfig               *AppConfig
 	FirestoreJobsCollection string
+	Background              *BackgroundRunner
+	Notifier                Notifier // nil when no notification channel is configured
+	Jobs                    JobStore // The Firestore jobs collection unless JOB_STORE=embedded
+
+	// verifyIDToken returns the user ID of a Firebase ID token, for connections that
+	// authenticate in-band rather than through AuthMiddleware.
+	verifyIDToken func(ctx context.Context, idToken string) (string, error)
+	// lookupUserEmail returns the email address of a user, for job completion notifications.
+	lookupUserEmail func(ctx context.Context, userID string) (string, error)
+	// getAll reads documents in one batch; FirestoreClient.GetAll unless a test counts reads.
+	getAll func(ctx context.Context, refs []*docstore.DocumentRef) ([]*docstore.DocumentSnapshot, error)
+
+	warm        *warmTracker
+	r2Deletions *r2DeletionCounters
+	outbound    *outboundGuard
+	webhooks    *webhookSender
+	streams     *streaming.Metrics
+	queues      *queueHealth
+	queueDepth  *queueDepthCache
+	deadlines   *deadlineMisses
+	presigns    *presignPool
+	jobCleanups *jobCleanupCounters
+	touches     *workspaceToucher
+
+	// objectStore is swapped whole when storage credentials are reloaded; see r2.
+	objectStore     atomic.Pointer[ObjectStore]
+	storageReloadMu sync.Mutex
 }
 
 // NewApiController creates a new ApiController.
-func NewApiController(fs *firestore.Client, tasksClient *cloudtasks.Client, presignClient *s3.PresignClient, r2S3Client *s3.Client, r2BucketName string, appConfig *AppConfig, firestoreJobsCollection string) *ApiController {
-	return &ApiController{
+func NewApiController(fs *docstore.Client, tasksClient TaskEnqueuer, objectStore *ObjectStore, r2BucketName string, appConfig *AppConfig, firestoreJobsCollection string, background *BackgroundRunner) *ApiController {
+	ac := &ApiController{
 		FirestoreClient:         fs,
 		TasksClient:             tasksClient,
-		R2PresignClient:         presignClient,
-		R2S3Client:              r2S3Client,
 		R2BucketName:            r2BucketName,
 		Services:                appConfig.Services,
 		AppConfig:               appConfig,
 		FirestoreJobsCollection: firestoreJobsCollection,
-	}
+		Background:              background,
+		Jobs:                    newFirestoreJobStore(fs, firestoreJobsCollection),
+		warm:                    newWarmTracker(appConfig.WarmInterval),
+		r2Deletions:             &r2DeletionCounters{},
+		outbound:                newOutboundGuard(appConfig),
+		streams:                 streaming.NewMetrics(),
+		queues:                  newQueueHealth(appConfig.RagQueueFailureThreshold, appConfig.RagQueueDrip),
+		queueDepth:              &queueDepthCache{},
+		deadlines:               newDeadlineMisses(),
+		presigns:                newPresignPool(presignPoolSize),
+		jobCleanups:             &jobCleanupCounters{},
+		touches:                 newWorkspaceToucher(workspaceTouchInterval),
+		verifyIDToken:           verifyFirebaseIDToken,
+		lookupUserEmail:         firebaseUserEmail,
+		getAll:                  fs.GetAll,
+	}
+	ac.objectStore.Store(objectStore)
+	ac.webhooks = newWebhookSender(ac.outbound, appConfig.WebhookTimeout)
+	if appConfig.Services.EmailSender.Enabled() {
+		ac.Notifier = newCloudTasksNotifier(ac, appConfig.Services.EmailSender)
+	}
+	return ac
 }
 
 // HandleSync processes a batch of client file states, compares with Firestore, 
@@ -95,29 +191,29 @@ func (ac *ApiController) HandleSync(c *gin.Context) {
 		"handler":      "HandleSync",
 	})
 
-	// Authorization check
-	isMember, err := checkWorkspaceMembership(c.Request.Context(), ac.FirestoreClient, userID, workspaceID)
-	if err != nil {
-		logCtx.WithError(err).Error("Workspace membership check failed.")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
-		return
-	}
-	if !isMember {
-		logCtx.Warn("User does not have access to this workspace.")
-		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+	// Authorization check: viewers cannot change files.
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleEditor, logCtx) == nil {
 		return
 	}
 	logCtx.Info("User authorized for workspace access.") // Log successful authorization
 
 	var req SyncRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := ac.bindJSON(c, &req); err != nil {
 		logCtx.WithError(err).Warn("Invalid request body")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
 		return
 	}
 
+	// A dry run classifies files exactly like a real sync but mints no URLs or IDs and
+	// returns no tentative version, so its response cannot be fed into ConfirmSync.
+	dryRun := c.Query("dryRun") == "true"
+	logCtx = logCtx.WithField("dry_run", dryRun)
+
 	if len(req.Files) == 0 {
 		logCtx.Info("Request received with no files to sync.")
+		if dryRun {
+			c.JSON(http.StatusOK, SyncResponse{Status: "dry_run", Actions: []SyncResponseFileAction{}, Summary: &SyncPlanSummary{}})
+			return
+		}
 		c.JSON(http.StatusOK, SyncResponse{Actions: []SyncResponseFileAction{}})
 		return
 	}
@@ -138,18 +234,48 @@ func (ac *ApiController) HandleSync(c *gin.Context) {
 		return
 	}
 
-	if req.WorkspaceVersion != currentServerWorkspace.WorkspaceVersion {
-		logCtx.Warnf("Workspace version conflict. Client: %s, Server: %s", req.WorkspaceVersion, currentServerWorkspace.WorkspaceVersion)
+	if currentServerWorkspace.ReadOnly {
+		logCtx.Warn("Sync rejected: workspace is read-only.")
 		c.JSON(http.StatusConflict, SyncResponse{
-			Status:              "workspace_conflict",
-			Actions:             []SyncResponseFileAction{},
-			NewWorkspaceVersion: currentServerWorkspace.WorkspaceVersion,
-			ErrorMessage:        "Workspace version conflict. Please refresh.",
+			Status:       "workspace_read_only",
+			Actions:      []SyncResponseFileAction{},
+			ErrorMessage: "Workspace is read-only. Ask an owner to lift the freeze.",
 		})
 		return
 	}
 
+	// A client that is behind may still sync if the commits it missed touched none of the
+	// paths it changed: the plan is made against the current version instead.
+	var serverChanges []WorkspaceChange
+	autoRebased := false
+	if req.WorkspaceVersion != currentServerWorkspace.WorkspaceVersion {
+		changes, complete, err := ac.workspaceChangesSince(ctx, workspaceID, req.WorkspaceVersion, currentServerWorkspace.WorkspaceVersion)
+		if err != nil {
+			logCtx.WithError(err).Warn("Failed to load workspace changes for auto-rebase.")
+		}
+		var conflicts []string
+		if complete {
+			conflicts = rebaseConflicts(clientChangedPaths(req.Files), changes)
+		}
+		if !complete || len(conflicts) > 0 {
+			logCtx.WithField("conflicting_paths", len(conflicts)).Warnf("Workspace version conflict. Client: %s, Server: %s", req.WorkspaceVersion, currentServerWorkspace.WorkspaceVersion)
+			c.JSON(http.StatusConflict, SyncResponse{
+				Status:              "workspace_conflict",
+				Actions:             []SyncResponseFileAction{},
+				NewWorkspaceVersion: currentServerWorkspace.WorkspaceVersion,
+				ErrorMessage:        "Workspace version conflict. Please refresh.",
+				ConflictingPaths:    conflicts,
+			})
+			return
+		}
+		logCtx.WithField("server_changes", len(changes)).Infof("Auto-rebasing sync from version %s onto %s.", req.WorkspaceVersion, currentServerWorkspace.WorkspaceVersion)
+		serverChanges = latestChanges(changes)
+		autoRebased = true
+	}
+
 	responseActions := make([]SyncResponseFileAction, 0, len(req.Files))
+	var summary SyncPlanSummary
+	var storageDelta int64 // Projected change in workspace storage, from client-reported sizes
 	presignDuration := 15 * time.Minute
 	filesCollectionPath := fmt.Sprintf("workspaces/%s/files", workspaceID)
 
@@ -159,6 +285,7 @@ func (ac *ApiController) HandleSync(c *gin.Context) {
 			Type:     clientFile.Type,
 		}
 		itemLogCtx := logCtx.WithField("filePath", clientFile.FilePath)
+		var actionBytes int64
 
 		switch clientFile.Action {
 		case "new", "modified":
@@ -185,10 +312,11 @@ func (ac *ApiController) HandleSync(c *gin.Context) {
 
 			// For folders, we only care if they are new. "modified" doesn't apply.
 			if clientFile.Type == "folder" {
+				// Folders have no R2 object, so their actions carry no key.
 				if clientFile.Action == "new" && !foundServerMeta {
-					fileID = uuid.New().String()
-					// Generate R2ObjectKey for folders (even though we don't store anything in R2)
-					r2ObjectKey = fmt.Sprintf("workspaces/%s/folders/%s", workspaceID, fileID)
+					if !dryRun {
+						fileID = uuid.New().String()
+					}
 					currentAction.ActionRequired = "upload" // This signals the client to include it in the confirm step
 					itemLogCtx.Info("New folder identified. Flagging for metadata creation.")
 				} else {
@@ -196,17 +324,29 @@ func (ac *ApiController) HandleSync(c *gin.Context) {
 					// For existing folders, get the existing metadata
 					if foundServerMeta {
 						fileID = serverMeta.FileID
-						r2ObjectKey = serverMeta.R2ObjectKey
 					}
 				}
 				currentAction.FileID = fileID
-				currentAction.R2ObjectKey = r2ObjectKey
 				responseActions = append(responseActions, currentAction)
+				summary.add(currentAction.ActionRequired, 0)
 				continue // Go to next file
 			}
 
 			// --- File-specific logic from here ---
 			needsUpload := clientFile.Action == "new" || !foundServerMeta || (clientFile.Action == "modified" && clientFile.ClientHash != serverHash)
+			if needsUpload {
+				storageDelta += clientFile.Size
+				if foundServerMeta {
+					storageDelta -= serverMeta.Size
+				}
+			}
+
+			if needsUpload && dryRun {
+				currentAction.ActionRequired = "upload"
+				currentAction.FileID = fileID
+				actionBytes = clientFile.Size
+				break
+			}
 
 			if needsUpload {
 				if fileID == "" {
@@ -217,7 +357,7 @@ func (ac *ApiController) HandleSync(c *gin.Context) {
 				fileNameOnly := filepath.Base(clientFile.FilePath)
 				r2ObjectKey = fmt.Sprintf("workspaces/%s/files/%s/%s", workspaceID, fileID, fileNameOnly)
 
-				presignedPutURL, presignErr := ac.R2PresignClient.PresignPutObject(ctx, &s3.PutObjectInput{
+				presignedPutURL, presignErr := ac.r2().Presign.PresignPutObject(ctx, &s3.PutObjectInput{
 					Bucket: aws.String(ac.R2BucketName),
 					Key:    aws.String(r2ObjectKey),
 				}, func(po *s3.PresignOptions) {
@@ -252,6 +392,8 @@ func (ac *ApiController) HandleSync(c *gin.Context) {
 					currentAction.FileID = serverMeta.FileID
 					currentAction.R2ObjectKey = serverMeta.R2ObjectKey
 					currentAction.ActionRequired = "delete"
+					actionBytes = serverMeta.Size
+					storageDelta -= serverMeta.Size
 					itemLogCtx.Info("Marked for deletion. Server will delete on confirm.")
 				} else {
 					itemLogCtx.WithError(err).Error("Error unmarshalling Firestore data for file to delete.")
@@ -279,6 +421,38 @@ func (ac *ApiController) HandleSync(c *gin.Context) {
 			currentAction.Message = "Unknown action specified"
 		}
 		responseActions = append(responseActions, currentAction)
+		summary.add(currentAction.ActionRequired, actionBytes)
+	}
+
+	if limit := ac.workspaceStorageQuota(currentServerWorkspace); exceedsStorageQuota(currentServerWorkspace.StorageBytes, storageDelta, limit) {
+		quota := StorageQuota{ProjectedBytes: currentServerWorkspace.StorageBytes + storageDelta, LimitBytes: limit}
+		logCtx.WithFields(log.Fields{"projected_bytes": quota.ProjectedBytes, "limit_bytes": quota.LimitBytes}).Warn("Sync rejected: workspace storage quota exceeded.")
+		resp := SyncResponse{
+			Status:       syncQuotaExceeded,
+			Actions:      []SyncResponseFileAction{},
+			ErrorMessage: fmt.Sprintf("This sync would grow the workspace to %d bytes, over its %d byte quota.", quota.ProjectedBytes, quota.LimitBytes),
+			StorageQuota: &quota,
+		}
+		if dryRun {
+			resp.Summary = &summary
+		}
+		c.JSON(http.StatusRequestEntityTooLarge, resp)
+		return
+	}
+
+	if dryRun {
+		logCtx.WithFields(log.Fields{
+			"uploads": summary.Uploads,
+			"deletes": summary.Deletes,
+		}).Info("HandleSync dry run processed.")
+		c.JSON(http.StatusOK, SyncResponse{
+			Status:        "dry_run",
+			Actions:       responseActions,
+			Summary:       &summary,
+			AutoRebased:   autoRebased,
+			ServerChanges: serverChanges,
+		})
+		return
 	}
 
 	var newTentativeVersion string
@@ -330,6 +504,8 @@ func (ac *ApiController) HandleSync(c *gin.Context) {
 			Status:              "no_changes",
 			Actions:             responseActions, // Return the actions, even if they are all 'none'
 			NewWorkspaceVersion: currentServerWorkspace.WorkspaceVersion, // No version change if no effective file changes
+			AutoRebased:         autoRebased,
+			ServerChanges:       serverChanges,
 		})
 		return
 	}
@@ -339,6 +515,8 @@ func (ac *ApiController) HandleSync(c *gin.Context) {
 		Status:              "pending_confirmation",
 		Actions:             responseActions,
 		NewWorkspaceVersion: newTentativeVersion,
+		AutoRebased:         autoRebased,
+		ServerChanges:       serverChanges,
 	})
 }
 
@@ -354,42 +532,58 @@ func (ac *ApiController) ConfirmSync(c *gin.Context) {
 		"handler":      "ConfirmSync",
 	})
 
-	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
-		return
-	}
-	if !isMember {
-		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleEditor, logCtx) == nil {
 		return
 	}
 
 	var req ConfirmSyncRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := ac.bindJSON(c, &req); err != nil {
 		logCtx.WithError(err).Warn("Failed to bind JSON for ConfirmSync.")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if action, err := validateSyncObjectKeys(workspaceID, req.SyncActions); err != nil {
+		ac.recordSecurityEvent(ctx, securityInvalidObjectKey, userID, workspaceID, map[string]interface{}{
+			"source":        "confirm_sync",
+			"client_ip":     RealClientIP(c),
+			"file_path":     action.FilePath,
+			"file_id":       action.FileID,
+			"type":          action.Type,
+			"r2_object_key": action.R2ObjectKey,
+		})
+		c.JSON(http.StatusBadRequest, ConfirmSyncResponse{
+			Status:       securityInvalidObjectKey,
+			ErrorMessage: fmt.Sprintf("Sync action for %s: %s", action.FilePath, err.Error()),
+		})
 		return
 	}
 
+	// Count uploads at the size actually stored, not the size the client claims.
+	ac.reconcileUploadSizes(ctx, req.SyncActions, logCtx)
+
 	var r2KeysToDelete []string
+	var totals SyncTotals
+	var storageBytes int64 // Workspace storage after this commit
+	var destructive *DestructiveChange
 
-	err = ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
 		// --- READ PHASE ---
 		// 1. Read workspace document for version check.
+		// Read-only is checked inside the transaction so a sync in flight when the freeze
+		// lands cannot commit.
 		wsDocRef := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
-		wsDocSnap, err := tx.Get(wsDocRef)
+		workspaceData, nextVersion, err := beginWorkspaceCommit(tx, wsDocRef)
 		if err != nil {
-			return fmt.Errorf("failed to get workspace for version check: %w", err)
+			return err
 		}
-
-		var workspaceData Workspace
-		if err := wsDocSnap.DataTo(&workspaceData); err != nil {
-			return fmt.Errorf("failed to parse workspace data: %w", err)
+		// A deletion that landed after the request passed RejectDeletedWorkspaces.
+		if workspaceData.DeletedAt != "" {
+			return errWorkspaceDeleting
 		}
 
 		// 2. Read all file documents that will be modified or deleted.
 		filesCollectionRef := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID))
-		existingFileDocs := make(map[string]*firestore.DocumentSnapshot)
+		existingFileDocs := make(map[string]*docstore.DocumentSnapshot)
+		existingFiles := make(map[string]*FileMetadata)
 		for _, clientFile := range req.SyncActions {
 			fileDocRef := filesCollectionRef.Doc(SanitizePathToDocID(clientFile.FilePath))
 			docSnap, err := tx.Get(fileDocRef)
@@ -403,28 +597,47 @@ func (ac *ApiController) ConfirmSync(c *gin.Context) {
 				return fmt.Errorf("failed to get file doc '%s': %w", clientFile.FilePath, err)
 			}
 			existingFileDocs[clientFile.FilePath] = docSnap
+
+			var existingMeta FileMetadata
+			if err := docSnap.DataTo(&existingMeta); err == nil {
+				existingFiles[clientFile.FilePath] = &existingMeta
+			}
 		}
 		
 		// --- VALIDATION PHASE ---
-		baseVersionInt, err := strconv.Atoi(workspaceData.WorkspaceVersion)
-		if err != nil {
-			return fmt.Errorf("server workspace version '%s' is invalid", workspaceData.WorkspaceVersion)
-		}
 		clientVersionInt, err := strconv.Atoi(req.WorkspaceVersion)
 		if err != nil {
 			return fmt.Errorf("client workspace version '%s' is invalid", req.WorkspaceVersion)
 		}
+		if strconv.Itoa(clientVersionInt) != nextVersion {
+			return fmt.Errorf("workspace version mismatch: server is at %s, but client commit is for %d", workspaceData.WorkspaceVersion, clientVersionInt-1)
+		}
 
-		if clientVersionInt != baseVersionInt+1 {
-			return fmt.Errorf("workspace version mismatch: server is at %d, but client commit is for %d", baseVersionInt, clientVersionInt-1)
+		// Totals are computed from the same reads as the writes below, and the storage
+		// aggregate is updated in this transaction, so the two cannot drift apart.
+		totals = computeSyncTotals(req.SyncActions, existingFiles)
+		storageBytes = workspaceData.StorageBytes + totals.BytesAdded - totals.BytesRemoved
+		if err := ac.checkStorageQuota(workspaceData, totals.BytesAdded-totals.BytesRemoved); err != nil {
+			return err
+		}
+
+		// Guard against clients that delete most of the workspace by mistake.
+		destructive, err = ac.checkDestructiveSync(ctx, tx, workspaceID, workspaceData, totals.FilesDeleted)
+		if err != nil {
+			return err
+		}
+		if destructive != nil && !req.ConfirmDestructive {
+			return &destructiveSyncError{change: *destructive}
 		}
 
 		// --- WRITE PHASE ---
-		// 1. Update workspace version and timestamp. This is the first write.
+		// 1. Update workspace version, timestamp and storage aggregate. This is the first write.
 		// Update workspace with new version and standardized ISO 8601 timestamp
-		err = tx.Update(wsDocRef, []firestore.Update{
+		err = tx.Update(wsDocRef, []docstore.Update{
 			{Path: "workspace_version", Value: req.WorkspaceVersion},
 			{Path: "updated_at", Value: NowISO8601()},
+			{Path: "last_activity_at", Value: NowISO8601()},
+			{Path: "storage_bytes", Value: docstore.Increment(totals.BytesAdded - totals.BytesRemoved)},
 		})
 		if err != nil {
 			return fmt.Errorf("failed to increment workspace version: %w", err)
@@ -444,11 +657,14 @@ func (ac *ApiController) ConfirmSync(c *gin.Context) {
 					Type:        clientFile.Type,
 					R2ObjectKey: clientFile.R2ObjectKey,
 					UpdatedAt:   NowISO8601(), // Exact JavaScript toISOString() format
+					Version:     req.WorkspaceVersion,
 				}
 
 				if clientFile.Type == "file" {
 					newMeta.Hash = clientFile.ClientHash
-					newMeta.Size = clientFile.Size
+					newMeta.Size = upsertSize(clientFile, existingFiles[clientFile.FilePath])
+				} else {
+					newMeta.R2ObjectKey = "" // Older clients echo a placeholder key for folders
 				}
 
 				docSnap := existingFileDocs[clientFile.FilePath]
@@ -473,7 +689,7 @@ func (ac *ApiController) ConfirmSync(c *gin.Context) {
 				if docSnap != nil && docSnap.Exists() {
 					var fileMeta FileMetadata
 					if err := docSnap.DataTo(&fileMeta); err == nil {
-						if fileMeta.R2ObjectKey != "" {
+						if fileMeta.Type == "file" && fileMeta.R2ObjectKey != "" {
 							r2KeysToDelete = append(r2KeysToDelete, fileMeta.R2ObjectKey)
 						}
 					}
@@ -487,9 +703,53 @@ func (ac *ApiController) ConfirmSync(c *gin.Context) {
 				}
 			}
 		}
-		return nil
+
+		// 3. Record which paths this version changed, for auto-rebasing stale syncs.
+		return ac.recordWorkspaceChanges(tx, workspaceID, req.WorkspaceVersion, syncActionsToChanges(req.SyncActions))
 	})
 
+	var destructiveErr *destructiveSyncError
+	if errors.As(err, &destructiveErr) {
+		change := destructiveErr.change
+		logCtx.WithFields(log.Fields{
+			"files_deleted":   change.FilesDeleted,
+			"workspace_files": change.WorkspaceFiles,
+		}).Warn("ConfirmSync rejected: destructive change needs confirmation.")
+		ac.recordDestructiveSync(c, workspaceID, userID, req.WorkspaceVersion, change, false)
+		c.JSON(http.StatusConflict, ConfirmSyncResponse{
+			Status:            syncDestructiveConfirmationRequired,
+			ErrorMessage:      fmt.Sprintf("This sync would delete %d of the workspace's %d files. Resend it with confirmDestructive to apply it.", change.FilesDeleted, change.WorkspaceFiles),
+			DestructiveChange: &change,
+		})
+		return
+	}
+	var quotaErr *storageQuotaError
+	if errors.As(err, &quotaErr) {
+		quota := quotaErr.quota
+		logCtx.WithFields(log.Fields{"projected_bytes": quota.ProjectedBytes, "limit_bytes": quota.LimitBytes}).Warn("ConfirmSync rejected: workspace storage quota exceeded.")
+		c.JSON(http.StatusRequestEntityTooLarge, ConfirmSyncResponse{
+			Status:       syncQuotaExceeded,
+			ErrorMessage: fmt.Sprintf("This sync would grow the workspace to %d bytes, over its %d byte quota.", quota.ProjectedBytes, quota.LimitBytes),
+			StorageQuota: &quota,
+		})
+		return
+	}
+	if errors.Is(err, errWorkspaceDeleting) {
+		logCtx.Warn("ConfirmSync rejected: workspace is being deleted.")
+		c.JSON(http.StatusConflict, ConfirmSyncResponse{
+			Status:       "workspace_deleting",
+			ErrorMessage: "Workspace is being deleted.",
+		})
+		return
+	}
+	if errors.Is(err, errWorkspaceReadOnly) {
+		logCtx.Warn("ConfirmSync rejected: workspace is read-only.")
+		c.JSON(http.StatusConflict, ConfirmSyncResponse{
+			Status:       "workspace_read_only",
+			ErrorMessage: "Workspace is read-only. Ask an owner to lift the freeze.",
+		})
+		return
+	}
 	if err != nil {
 		logCtx.WithError(err).Error("Transaction failed in ConfirmSync.")
 		c.JSON(http.StatusConflict, ConfirmSyncResponse{
@@ -499,55 +759,78 @@ func (ac *ApiController) ConfirmSync(c *gin.Context) {
 		return
 	}
 
-	// After transaction succeeds, delete the R2 objects
+	// After transaction succeeds, delete the R2 objects. Failures are queued in
+	// pending_r2_deletions and retried by the maintenance task instead of leaking.
 	if len(r2KeysToDelete) > 0 {
 		logCtx.Infof("Starting deletion of %d R2 objects post-transaction.", len(r2KeysToDelete))
-		for _, key := range r2KeysToDelete {
-			_, err := ac.R2S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
-				Bucket: aws.String(ac.R2BucketName),
-				Key:    aws.String(key),
-			})
-			if err != nil {
-				logCtx.WithError(err).Errorf("Failed to delete object '%s' from R2.", key)
-			} else {
-				logCtx.Infof("Successfully deleted object '%s' from R2.", key)
-			}
+		failures := ac.deleteR2Objects(ctx, r2KeysToDelete)
+		if len(failures) > 0 {
+			logCtx.Errorf("Failed to delete %d of %d R2 objects; queueing them for retry.", len(failures), len(r2KeysToDelete))
+			ac.recordFailedR2Deletions(ctx, workspaceID, failures)
+		} else {
+			logCtx.Infof("Successfully deleted %d R2 objects.", len(r2KeysToDelete))
 		}
 	}
 
+	logCtx.WithFields(log.Fields{
+		"files_upserted": totals.FilesUpserted,
+		"files_deleted":  totals.FilesDeleted,
+		"bytes_added":    totals.BytesAdded,
+		"bytes_removed":  totals.BytesRemoved,
+	}).Info("Sync committed.")
+	ac.recordActivity(ctx, workspaceID, userID, activitySyncCommitted, map[string]interface{}{
+		"workspace_version": req.WorkspaceVersion,
+		"files_upserted":    totals.FilesUpserted,
+		"files_deleted":     totals.FilesDeleted,
+		"bytes_added":       totals.BytesAdded,
+		"bytes_removed":     totals.BytesRemoved,
+	})
+	ac.recordWorkspaceUsage(ctx, workspaceID, map[string]interface{}{
+		"sync_commit_count": docstore.Increment(1),
+		"storage_bytes":     storageBytes,
+	})
+	if destructive != nil {
+		ac.recordDestructiveSync(c, workspaceID, userID, req.WorkspaceVersion, *destructive, true)
+	}
+
 	c.JSON(http.StatusOK, ConfirmSyncResponse{
 		Status:                "success",
 		FinalWorkspaceVersion: req.WorkspaceVersion,
+		Totals:                &totals,
+		DestructiveChange:     destructive,
 	})
 
-	// Trigger RAG indexing for modified files (fire and forget)
-	go func() {
-		modifiedFiles := make([]WorkerFile, 0)
-		for _, action := range req.SyncActions {
-			if action.Action == "upsert" && action.Type == "file" {
-				logCtx.WithFields(log.Fields{
-					"file_path": action.FilePath,
-					"r2_object_key": action.R2ObjectKey,
-					"action": action.Action,
-					"type": action.Type,
-				}).Info("Adding file for RAG indexing")
-				
-				modifiedFiles = append(modifiedFiles, WorkerFile{
-					R2ObjectKey: action.R2ObjectKey,
-					FilePath:    action.FilePath,
-				})
-			}
+	// Trigger RAG indexing for modified files on the background runner
+	modifiedFiles := make([]WorkerFile, 0)
+	for _, action := range req.SyncActions {
+		if action.Action == "upsert" && action.Type == "file" {
+			logCtx.WithFields(log.Fields{
+				"file_path":     action.FilePath,
+				"r2_object_key": action.R2ObjectKey,
+				"action":        action.Action,
+				"type":          action.Type,
+			}).Info("Adding file for RAG indexing")
+
+			modifiedFiles = append(modifiedFiles, WorkerFile{
+				R2ObjectKey: action.R2ObjectKey,
+				FilePath:    action.FilePath,
+			})
 		}
+	}
 
-		if len(modifiedFiles) > 0 {
-			indexingJobID := uuid.New().String()
-			if err := ac.enqueueRagIndexing(indexingJobID, workspaceID, modifiedFiles); err != nil {
-				logCtx.WithError(err).WithField("indexing_job_id", indexingJobID).Error("Failed to enqueue RAG indexing task")
-			} else {
-				logCtx.WithField("indexing_job_id", indexingJobID).WithField("file_count", len(modifiedFiles)).Info("RAG indexing task enqueued successfully")
+	if len(modifiedFiles) > 0 {
+		indexingJobID := uuid.New().String()
+		submitErr := ac.Background.Submit("rag_indexing", func(ctx context.Context) error {
+			if err := ac.requestRagIndexing(ctx, indexingJobID, workspaceID, req.WorkspaceVersion, modifiedFiles); err != nil {
+				return fmt.Errorf("failed to request RAG indexing task %s: %w", indexingJobID, err)
 			}
+			logCtx.WithField("indexing_job_id", indexingJobID).WithField("file_count", len(modifiedFiles)).Info("RAG indexing requested")
+			return nil
+		})
+		if submitErr != nil {
+			logCtx.WithError(submitErr).WithField("indexing_job_id", indexingJobID).Error("Failed to schedule RAG indexing task")
 		}
-	}()
+	}
 }
 
 // SanitizePathToDocID converts a file path to a Firestore-safe document ID.
@@ -607,13 +890,37 @@ func (ac *ApiController) GetWorkspaceManifest(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse workspace data"})
 		return
 	}
+	// Also rejected by RejectDeletedWorkspaces; a trashed workspace's files stay hidden even
+	// where the middleware is not in front of this handler.
+	if workspaceData.DeletedAt != "" {
+		writeWorkspaceDeleted(c)
+		return
+	}
 
+	files, err := ac.workspaceManifestFiles(ctx, workspaceID, logCtx)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to iterate over file documents in Firestore")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file list"})
+		return
+	}
+
+	logCtx.WithField("file_count", len(files)).Info("Successfully retrieved workspace manifest with content URLs")
+	c.JSON(http.StatusOK, WorkspaceManifestResponse{
+		Manifest:         files,
+		WorkspaceVersion: workspaceData.WorkspaceVersion,
+	})
+}
+
+// workspaceManifestFiles lists a workspace's files and folders, giving each file a
+// presigned GET URL. Files whose URL could not be signed are listed without one.
+func (ac *ApiController) workspaceManifestFiles(ctx context.Context, workspaceID string, logCtx *log.Entry) ([]FileMetadata, error) {
 	filesCollectionPath := fmt.Sprintf("workspaces/%s/files", workspaceID)
 	iter := ac.FirestoreClient.Collection(filesCollectionPath).Documents(ctx)
 	defer iter.Stop()
 
 	var files []FileMetadata
-	presignDuration := 15 * time.Minute
+	var keys []string
+	var signed []int // Index into files of each key
 
 	for {
 		doc, err := iter.Next()
@@ -621,9 +928,7 @@ func (ac *ApiController) GetWorkspaceManifest(c *gin.Context) {
 			break
 		}
 		if err != nil {
-			logCtx.WithError(err).Error("Failed to iterate over file documents in Firestore")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file list"})
-			return
+			return nil, err
 		}
 
 		var fileMeta FileMetadata
@@ -634,35 +939,27 @@ func (ac *ApiController) GetWorkspaceManifest(c *gin.Context) {
 
 		// For files, generate a presigned URL. For folders, don't.
 		if fileMeta.Type == "file" && fileMeta.R2ObjectKey != "" {
-			presignedURLRequest, presignErr := ac.R2PresignClient.PresignGetObject(ctx, &s3.GetObjectInput{
-				Bucket: aws.String(ac.R2BucketName),
-				Key:    aws.String(fileMeta.R2ObjectKey),
-			}, func(po *s3.PresignOptions) {
-				po.Expires = presignDuration
-			})
-			if presignErr != nil {
-				logCtx.WithError(presignErr).WithFields(log.Fields{
-					"r2_object_key": fileMeta.R2ObjectKey,
-				}).Warn("Failed to generate R2 pre-signed GET URL for file")
-				fileMeta.ContentURL = ""
-			} else {
-				fileMeta.ContentURL = presignedURLRequest.URL
-			}
-		} else {
-			fileMeta.ContentURL = ""
+			keys = append(keys, fileMeta.R2ObjectKey)
+			signed = append(signed, len(files))
 		}
+		fileMeta.ContentURL = ""
 		files = append(files, fileMeta)
 	}
 
+	for i, url := range ac.presignGetURLs(ctx, keys) {
+		if url.Err != nil {
+			logCtx.WithError(url.Err).WithFields(log.Fields{
+				"r2_object_key": keys[i],
+			}).Warn("Failed to generate R2 pre-signed GET URL for file")
+			continue
+		}
+		files[signed[i]].ContentURL = url.URL
+	}
+
 	if files == nil {
 		files = make([]FileMetadata, 0)
 	}
-
-	logCtx.WithField("file_count", len(files)).Info("Successfully retrieved workspace manifest with content URLs")
-	c.JSON(http.StatusOK, WorkspaceManifestResponse{
-		Manifest:         files,
-		WorkspaceVersion: workspaceData.WorkspaceVersion,
-	})
+	return files, nil
 }
 
 // CreateWorkspace handles requests to create a new workspace.
@@ -680,9 +977,8 @@ func (ac *ApiController) CreateWorkspace(c *gin.Context) {
 	})
 
 	var req CreateWorkspaceRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := ac.bindJSON(c, &req); err != nil {
 		logCtx.WithError(err).Warn("Invalid request body for CreateWorkspace")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
 		return
 	}
 
@@ -693,19 +989,61 @@ func (ac *ApiController) CreateWorkspace(c *gin.Context) {
 	}
 
 	ctx := c.Request.Context()
+	// Checked before a template is copied, and again when the workspace is written.
+	if err := ac.checkWorkspaceLimit(ctx, userID); err != nil {
+		if writeWorkspaceLimitReached(c, err) {
+			logCtx.Warn("Workspace limit reached")
+			return
+		}
+		logCtx.WithError(err).Error("Failed to check workspace limit")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workspace"})
+		return
+	}
+
 	// Use standardized ISO 8601 timestamps for consistent time formatting
 	now := NowISO8601() // Exact JavaScript toISOString() format
 	newWorkspaceID := uuid.New().String()
 	initialVersion := "1"
 
+	// A template's objects are copied before anything is written, so an unknown template or a
+	// failed copy leaves no workspace behind. Its files make up version 2.
+	var seeded []FileMetadata
+	var seededBytes int64
+	if req.TemplateID != "" {
+		logCtx = logCtx.WithField("template_id", req.TemplateID)
+		tmpl, err := ac.loadWorkspaceTemplate(ctx, req.TemplateID)
+		if errors.Is(err, errTemplateNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown workspace template"})
+			return
+		}
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to load workspace template")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace template"})
+			return
+		}
+		initialVersion = "2"
+		seeded, err = ac.copyTemplateFiles(ctx, tmpl, newWorkspaceID, initialVersion, now)
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to copy workspace template files")
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to copy workspace template files"})
+			return
+		}
+		for _, f := range seeded {
+			seededBytes += f.Size
+		}
+	}
+
 	workspace := Workspace{
 		WorkspaceID:      newWorkspaceID,
 		Name:             req.Name,
+		NameLower:        normalizeWorkspaceName(req.Name),
 		CreatedBy:        userID,
+		OwnedBy:          userID,
 		CreatedAt:        now, // Standardized ISO 8601 with milliseconds
 		WorkspaceVersion: initialVersion,
+		StorageBytes:     seededBytes,
+		TemplateID:       req.TemplateID,
 	}
-	workspaceDocRef := ac.FirestoreClient.Collection("workspaces").Doc(newWorkspaceID)
 
 	membershipID := uuid.New().String()
 	membership := WorkspaceMembership{
@@ -717,23 +1055,21 @@ func (ac *ApiController) CreateWorkspace(c *gin.Context) {
 		Role:         "owner",
 		JoinedAt:     now, // Standardized ISO 8601 timestamp
 	}
-	membershipDocRef := ac.FirestoreClient.Collection("workspace_memberships").Doc(membershipID)
-
-	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
-		tx.Set(workspaceDocRef, workspace)
-		tx.Set(membershipDocRef, membership)
-		return nil
-	})
 
-	if err != nil {
+	if err := ac.commitNewWorkspace(ctx, workspace, membership, seeded, ac.workspaceLimitTxCheck(ctx, userID)); err != nil {
+		if writeWorkspaceLimitReached(c, err) {
+			logCtx.Warn("Workspace limit reached")
+			return
+		}
 		logCtx.WithError(err).Error("Failed to commit transaction for workspace creation")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workspace"})
 		return
 	}
 
 	logCtx.WithFields(log.Fields{
-		"workspace_id": newWorkspaceID,
+		"workspace_id":   newWorkspaceID,
 		"workspace_name": req.Name,
+		"seeded_files":   len(seeded),
 	}).Info("Workspace created successfully")
 
 	c.JSON(http.StatusCreated, CreateWorkspaceResponse{
@@ -745,7 +1081,78 @@ func (ac *ApiController) CreateWorkspace(c *gin.Context) {
 	})
 }
 
-// ListWorkspaces retrieves all workspaces a user is a member of.
+// commitNewWorkspace writes a new workspace, its owner's membership and any files seeded
+// from a template in one transaction, once check (if any) has passed inside it. If the
+// transaction fails, the seeded files' objects are discarded.
+func (ac *ApiController) commitNewWorkspace(ctx context.Context, workspace Workspace, membership WorkspaceMembership, seeded []FileMetadata, check func(tx *docstore.Transaction) error) error {
+	workspaceDocRef := ac.FirestoreClient.Collection("workspaces").Doc(workspace.WorkspaceID)
+	membershipDocRef := ac.FirestoreClient.Collection("workspace_memberships").Doc(membership.MembershipID)
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		if check != nil {
+			if err := check(tx); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(workspaceDocRef, workspace); err != nil {
+			return err
+		}
+		if err := tx.Create(membershipDocRef, membership); err != nil {
+			return err
+		}
+		for _, f := range seeded {
+			if err := tx.Set(workspaceDocRef.Collection("files").Doc(SanitizePathToDocID(f.FilePath)), f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var copied []string
+		for _, f := range seeded {
+			if f.R2ObjectKey != "" {
+				copied = append(copied, f.R2ObjectKey)
+			}
+		}
+		ac.discardTemplateObjects(ctx, workspace.WorkspaceID, copied)
+	}
+	return err
+}
+
+// workspaceGetAllChunk is how many workspaces one batched read fetches.
+const workspaceGetAllChunk = 100
+
+// getWorkspaceDocs reads workspaces in batches of workspaceGetAllChunk and maps each ID to
+// its snapshot. Missing workspaces map to snapshots that do not exist.
+func (ac *ApiController) getWorkspaceDocs(ctx context.Context, workspaceIDs []string) (map[string]*docstore.DocumentSnapshot, error) {
+	docs := make(map[string]*docstore.DocumentSnapshot, len(workspaceIDs))
+	refs := make([]*docstore.DocumentRef, 0, len(workspaceIDs))
+	for _, id := range workspaceIDs {
+		if _, dup := docs[id]; !dup {
+			docs[id] = nil
+			refs = append(refs, ac.FirestoreClient.Collection("workspaces").Doc(id))
+		}
+	}
+	for start := 0; start < len(refs); start += workspaceGetAllChunk {
+		snaps, err := ac.getAll(ctx, refs[start:min(start+workspaceGetAllChunk, len(refs))])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read workspaces: %w", err)
+		}
+		for _, snap := range snaps {
+			docs[snap.Ref.ID] = snap
+		}
+	}
+	return docs, nil
+}
+
+// ListWorkspaces retrieves all workspaces a user is a member of. With a limit (default 50,
+// max 200) or cursor query parameter it pages by join time and answers
+// {"workspaces": [...], "nextCursor": "..."}; without either it returns every workspace as a
+// bare array, as it always has. q keeps workspaces whose name starts with it, ignoring case;
+// sort orders by name, or newest first by createdAt or lastActivity, within each page. Paging needs a composite index on
+// workspace_memberships user_id/joined_at/__name__, and skips memberships without joined_at.
+// Soft-deleted workspaces are left out unless includeTrashed=true, which lists them with
+// status "trashed" and the time they become unrestorable. Each workspace's member and file
+// counts are included unless includeCounts=false.
 func (ac *ApiController) ListWorkspaces(c *gin.Context) {
 	userID := c.GetString("userID")
 	if userID == "" {
@@ -762,31 +1169,74 @@ func (ac *ApiController) ListWorkspaces(c *gin.Context) {
 	ctx := c.Request.Context()
 	var summaries []WorkspaceSummary
 
-	membershipQuery := ac.FirestoreClient.Collection("workspace_memberships").Where("user_id", "==", userID)
-	membershipIter := membershipQuery.Documents(ctx)
-	defer membershipIter.Stop()
+	// Names live on the workspaces, not the memberships, so q cannot narrow the membership
+	// query; it is matched against each page's workspaces instead.
+	query := normalizeWorkspaceName(c.Query("q"))
+	sortBy := c.Query("sort")
+	includeTrashed := c.Query("includeTrashed") == "true"
+	includeCounts := c.Query("includeCounts") != "false"
+	if sortBy != "" && !workspaceSorts[sortBy] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of name, createdAt, lastActivity"})
+		return
+	}
 
-	for {
-		membershipDoc, err := membershipIter.Next()
-		if err == iterator.Done {
-			break
+	membershipQuery := ac.FirestoreClient.Collection("workspace_memberships").Where("user_id", "==", userID)
+	paged := c.Query("limit") != "" || c.Query("cursor") != ""
+	limit := 50
+	if paged {
+		if raw := c.Query("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > 200 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+				return
+			}
+			limit = n
 		}
-		if err != nil {
-			logCtx.WithError(err).Error("Failed to iterate over workspace memberships.")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspace memberships"})
-			return
+		membershipQuery = membershipQuery.OrderBy("joined_at", docstore.Asc).OrderBy(docstore.DocumentID, docstore.Asc)
+		if raw := c.Query("cursor"); raw != "" {
+			cursor, err := decodeWorkspacesCursor(ac.AppConfig.ListCursorSigningKey, userID, raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+				return
+			}
+			membershipQuery = membershipQuery.StartAfter(cursor.JoinedAt, cursor.membershipID())
 		}
+		membershipQuery = membershipQuery.Limit(limit)
+	}
+	membershipDocs, err := membershipQuery.Documents(ctx).GetAll()
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to iterate over workspace memberships.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspace memberships"})
+		return
+	}
 
+	now := time.Now()
+	var memberships []WorkspaceMembership
+	workspaceIDs := make([]string, 0, len(membershipDocs))
+	for _, membershipDoc := range membershipDocs {
 		var membership WorkspaceMembership
 		if err := membershipDoc.DataTo(&membership); err != nil {
 			logCtx.WithError(err).WithField("membership_doc_id", membershipDoc.Ref.ID).Warn("Failed to parse workspace membership data.")
 			continue
 		}
+		if membershipExpired(membership, now) {
+			continue
+		}
+		memberships = append(memberships, membership)
+		workspaceIDs = append(workspaceIDs, membership.WorkspaceID)
+	}
 
-		workspaceDocRef := ac.FirestoreClient.Collection("workspaces").Doc(membership.WorkspaceID)
-		workspaceDoc, err := workspaceDocRef.Get(ctx)
-		if err != nil {
-			logCtx.WithError(err).WithFields(log.Fields{
+	workspaceDocs, err := ac.getWorkspaceDocs(ctx, workspaceIDs)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to retrieve workspaces for memberships.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspaces"})
+		return
+	}
+
+	for _, membership := range memberships {
+		workspaceDoc := workspaceDocs[membership.WorkspaceID]
+		if workspaceDoc == nil || !workspaceDoc.Exists() {
+			logCtx.WithFields(log.Fields{
 				"workspace_id": membership.WorkspaceID,
 				"membership_id": membership.MembershipID,
 			}).Warn("Failed to retrieve workspace details for a membership.")
@@ -798,74 +1248,240 @@ func (ac *ApiController) ListWorkspaces(c *gin.Context) {
 			logCtx.WithError(err).WithField("workspace_doc_id", workspaceDoc.Ref.ID).Warn("Failed to parse workspace data.")
 			continue
 		}
+		if workspace.DeletedAt != "" && !includeTrashed {
+			continue
+		}
+		if query != "" && !strings.HasPrefix(workspaceNameLower(workspace), query) {
+			continue
+		}
 
-		summaries = append(summaries, WorkspaceSummary{
-			WorkspaceID: workspace.WorkspaceID,
-			Name:        workspace.Name,
-			CreatedBy:   workspace.CreatedBy,
-			CreatedAt:   workspace.CreatedAt,
-			UserRole:    membership.Role,
-		})
+		summary := WorkspaceSummary{
+			WorkspaceID:    workspace.WorkspaceID,
+			Name:           workspace.Name,
+			CreatedBy:      workspace.CreatedBy,
+			OwnedBy:        workspaceOwner(workspace),
+			CreatedAt:      workspace.CreatedAt,
+			UserRole:       membership.Role,
+			ExpiresAt:      membership.ExpiresAt,
+			LastActivityAt: workspaceLastActivity(workspace),
+		}
+		if workspace.DeletedAt != "" {
+			summary.Status, summary.DeletedAt, summary.PurgeAfter = workspaceStatusTrashed, workspace.DeletedAt, workspace.PurgeAfter
+		}
+		summaries = append(summaries, summary)
 	}
 
 	if summaries == nil {
 		summaries = make([]WorkspaceSummary, 0)
 	}
+	if includeCounts {
+		ac.countWorkspaceSummaries(ctx, summaries, logCtx)
+	}
+	sortWorkspaceSummaries(summaries, sortBy)
 
 	logCtx.WithField("retrieved_workspaces_count", len(summaries)).Info("Successfully retrieved user's workspaces.")
-	c.JSON(http.StatusOK, summaries)
+	if !paged {
+		c.JSON(http.StatusOK, summaries)
+		return
+	}
+	// Expired memberships and deleted workspaces are dropped after paging, so a page may be
+	// short; only a page that read fewer memberships than the limit is the last.
+	resp := WorkspaceListResponse{Workspaces: summaries}
+	if len(membershipDocs) == limit {
+		lastDoc := membershipDocs[len(membershipDocs)-1]
+		joinedAt, _ := lastDoc.Data()["joined_at"].(string)
+		resp.NextCursor = encodeWorkspacesCursor(ac.AppConfig.ListCursorSigningKey, workspacesCursor{
+			UserID:   userID,
+			JoinedAt: joinedAt,
+			Path:     "workspace_memberships/" + lastDoc.Ref.ID,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// SetWorkspaceReadOnly freezes or unfreezes a workspace. Only owners may change the flag.
+func (ac *ApiController) SetWorkspaceReadOnly(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+
+	logCtx := log.WithFields(log.Fields{
+		"workspace_id": workspaceID,
+		"user_id":      userID,
+		"handler":      "SetWorkspaceReadOnly",
+	})
+
+	ctx := c.Request.Context()
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+
+	var req SetReadOnlyRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+
+	wsDocRef := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+	if _, err := wsDocRef.Update(ctx, []docstore.Update{
+		{Path: "read_only", Value: *req.ReadOnly},
+		{Path: "updated_at", Value: NowISO8601()},
+	}); err != nil {
+		logCtx.WithError(err).Error("Failed to update workspace read-only flag.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update workspace"})
+		return
+	}
+
+	ac.recordActivity(ctx, workspaceID, userID, activityReadOnlyChanged, map[string]interface{}{
+		"read_only": *req.ReadOnly,
+	})
+
+	logCtx.WithField("read_only", *req.ReadOnly).Info("Workspace read-only mode updated.")
+	c.JSON(http.StatusOK, gin.H{"workspaceId": workspaceID, "readOnly": *req.ReadOnly})
 }
 
 // ExecuteCode handles non-authenticated code execution requests.
 func (ac *ApiController) ExecuteCode(c *gin.Context) {
 	var reqBody RequestBody 
-	if err := c.ShouldBindJSON(&reqBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+	if err := ac.bindJSON(c, &reqBody); err != nil {
+		return
+	}
+	idemKey, ok := ac.idempotencyKeyFor(c, "execute")
+	if !ok {
+		return
+	}
+	if !ac.admitExecution(c, false) {
 		return
 	}
 
-	jobID := uuid.New().String()
 	ctx := c.Request.Context()
-
-	// Create job with standardized ISO 8601 timestamps
-	submittedAt := NowISO8601() // Exact JavaScript toISOString() format
-	expiresAt := TimeToISO8601(time.Now().UTC().Add(15 * 24 * time.Hour))
-
-	job := Job{
-		Status:      "queued",
-		Code:        reqBody.Code,
-		Language:    reqBody.Language,
-		Input:       reqBody.Input,
-		SubmittedAt: submittedAt, // Standardized ISO 8601 with milliseconds
-		ExpiresAt:   expiresAt,   // Standardized ISO 8601 with milliseconds
+	exec, err := ac.preparePublicExecution(ctx, reqBody)
+	var execErr *executionError
+	if errors.As(err, &execErr) {
+		c.JSON(execErr.Status, gin.H{"error": execErr.Message})
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to prepare public execution")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job record"})
+		return
 	}
+	jobID := exec.JobID
 
-	docRef := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Doc(jobID)
-	if _, err := docRef.Set(ctx, job); err != nil {
-		log.WithError(err).WithField("job_id", jobID).Error("Failed to create job in Firestore")
+	var stored StoredJob
+	var replayed, cached bool
+	quota := ac.anonymousQuotaFor(c, time.Now())
+	if dedupeKey := ac.jobDedupeKeyFor(reqBody, idemKey); dedupeKey != nil {
+		stored, cached, err = ac.Jobs.CreateDeduplicated(ctx, *dedupeKey, quota, jobID, exec.Job)
+	} else {
+		stored, replayed, err = ac.createJob(ctx, idemKey, quota, jobID, exec.Job)
+	}
+	if errors.Is(err, errQuotaExceeded) {
+		rejectOverQuota(c, quota)
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithField("job_id", jobID).Error("Failed to create job record")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job record"})
 		return
 	}
-	log.WithFields(log.Fields{"job_id": jobID, "language": job.Language}).Info("Job queued in Firestore for public execution")
+	if replayed {
+		log.WithField("job_id", stored.ID).Info("Idempotent public execution replayed")
+		c.JSON(http.StatusOK, gin.H{"job_id": stored.ID, "result_token": stored.Job.ResultToken, "replayed": true})
+		return
+	}
+	if cached {
+		log.WithField("job_id", stored.ID).Info("Public execution answered from an identical completed job")
+		c.JSON(http.StatusOK, gin.H{"job_id": stored.ID, "result_token": stored.Job.ResultToken, "cached": true})
+		return
+	}
+	log.WithFields(log.Fields{"job_id": jobID, "language": exec.Job.Language}).Info("Job queued for public execution")
+
+	createdTask, err := ac.enqueuePublicExecution(ctx, exec)
+	if err != nil {
+		log.WithError(err).WithField("job_id", jobID).Error("Failed to create Cloud Task for public execution")
+		ac.releaseIdempotencyKey(ctx, idemKey, log.WithField("job_id", jobID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit job for execution"})
+		return
+	}
+
+	log.WithFields(log.Fields{"job_id": jobID, "task_name": createdTask.GetName()}).Info("Job enqueued to Cloud Tasks for public execution")
+	c.JSON(http.StatusOK, gin.H{"job_id": jobID, "result_token": exec.Job.ResultToken})
+}
+
+// publicExecution is a validated public execution request, ready to be stored and enqueued.
+type publicExecution struct {
+	JobID   string
+	Job     Job
+	Payload CloudTaskPayload
+}
+
+// executionError is a problem with an execution request, reported to the client with Status.
+type executionError struct {
+	Status  int
+	Message string
+}
+
+func (e *executionError) Error() string { return e.Message }
+
+// preparePublicExecution validates a public execution request against the language's limits
+// and builds its job and task payload. Problems with the request are *executionError.
+func (ac *ApiController) preparePublicExecution(ctx context.Context, reqBody RequestBody) (publicExecution, error) {
+	limits, err := ac.resolveExecutionLimits(reqBody.Language, reqBody.ExecutionOptions)
+	if err != nil {
+		return publicExecution{}, &executionError{http.StatusBadRequest, err.Error()}
+	}
+	if len(reqBody.Code) > limits.MaxCodeBytes {
+		return publicExecution{}, &executionError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Code exceeds the %d byte limit for %s", limits.MaxCodeBytes, reqBody.Language)}
+	}
+	deadline, err := ac.resolveJobDeadline(reqBody.ExecutionOptions)
+	if err != nil {
+		return publicExecution{}, &executionError{http.StatusBadRequest, err.Error()}
+	}
+	priority, err := resolveJobPriority(reqBody.ExecutionOptions)
+	if err != nil {
+		return publicExecution{}, &executionError{http.StatusBadRequest, err.Error()}
+	}
+	if err := validateJobLabels(reqBody.ExecutionOptions); err != nil {
+		return publicExecution{}, &executionError{http.StatusBadRequest, err.Error()}
+	}
+	if err := ac.validateJobCallback(ctx, reqBody.JobCallbackOptions); err != nil {
+		return publicExecution{}, &executionError{http.StatusBadRequest, err.Error()}
+	}
 
-	taskPayload := CloudTaskPayload{ 
+	jobID := uuid.New().String()
+	resultToken, err := newResultToken()
+	if err != nil {
+		return publicExecution{}, fmt.Errorf("failed to generate result token: %w", err)
+	}
+
+	job := newJob(executionTypePublic, ac.AppConfig.Retention.Jobs)
+	job.Code, job.Language, job.Input = reqBody.Code, reqBody.Language, reqBody.Input
+	job.CallbackURL, job.CallbackSecret = reqBody.CallbackURL, reqBody.CallbackSecret
+	job.ResultToken, job.Deadline, job.Priority, job.Labels = resultToken, deadline, priority, reqBody.Labels
+	job.Replay = &JobReplay{TimeoutSeconds: limits.TimeoutSeconds, MemoryMb: limits.MemoryMb}
+	payload := CloudTaskPayload{
 		JobID: jobID, Code: reqBody.Code, Language: reqBody.Language, Input: reqBody.Input,
+		TimeoutSeconds: limits.TimeoutSeconds, MemoryMb: limits.MemoryMb,
+		NotifyCompletion: job.CallbackURL != "" || job.NotificationRequested, Deadline: deadline,
 	}
-	payloadBytes, err := json.Marshal(taskPayload)
+	return publicExecution{JobID: jobID, Job: job, Payload: payload}, nil
+}
+
+// enqueuePublicExecution creates the Cloud Task of a stored public execution and records it
+// on the job.
+func (ac *ApiController) enqueuePublicExecution(ctx context.Context, exec publicExecution) (*cloudtaskspb.Task, error) {
+	payloadBytes, err := json.Marshal(exec.Payload)
 	if err != nil {
-		log.WithError(err).WithField("job_id", jobID).Error("Failed to marshal task payload for public execution")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare job for execution"})
-		return
+		return nil, fmt.Errorf("failed to marshal task payload: %w", err)
 	}
 
 	taskReq := &cloudtaskspb.CreateTaskRequest{
-		Parent: ac.AppConfig.GetQueuePath(ac.Services.PythonWorker.QueueID),
+		Parent: ac.AppConfig.GetQueuePath(ac.Services.PythonWorker.QueueFor(exec.Job.Priority)),
 		Task: &cloudtaskspb.Task{
 			MessageType: &cloudtaskspb.Task_HttpRequest{
 				HttpRequest: &cloudtaskspb.HttpRequest{
 					HttpMethod: cloudtaskspb.HttpMethod_POST,
 					Url:        fmt.Sprintf("%s/execute", ac.Services.PythonWorker.ServiceURL),
-					Headers:    map[string]string{"Content-Type": "application/json"},
+					Headers:    executionTaskHeaders(exec.JobID),
 					Body:       payloadBytes,
 					AuthorizationHeader: &cloudtaskspb.HttpRequest_OidcToken{
 						OidcToken: &cloudtaskspb.OidcToken{
@@ -879,13 +1495,13 @@ func (ac *ApiController) ExecuteCode(c *gin.Context) {
 
 	createdTask, err := ac.TasksClient.CreateTask(ctx, taskReq)
 	if err != nil {
-		log.WithError(err).WithField("job_id", jobID).Error("Failed to create Cloud Task for public execution")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit job for execution"})
-		return
+		return nil, err
 	}
-
-	log.WithFields(log.Fields{"job_id": jobID, "task_name": createdTask.GetName()}).Info("Job enqueued to Cloud Tasks for public execution")
-	c.JSON(http.StatusOK, gin.H{"job_id": jobID})
+	if exec.Job.Deadline != "" {
+		ac.deadlines.recordSubmitted(time.Now())
+	}
+	ac.recordJobTask(ctx, exec.JobID, createdTask.GetName())
+	return createdTask, nil
 }
 
 // ExecuteCodeAuthenticated handles requests for authenticated code execution.
@@ -895,127 +1511,222 @@ func (ac *ApiController) ExecuteCodeAuthenticated(c *gin.Context) {
 
 	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "ExecuteCodeAuthenticated"})
 
-	isMember, err := checkWorkspaceMembership(c.Request.Context(), ac.FirestoreClient, userID, workspaceID)
-	if err != nil {
-		logCtx.WithError(err).Error("Workspace membership check failed during authenticated execution.")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
-		return
-	}
-	if !isMember {
-		logCtx.Warn("User tried to execute code in a workspace they are not a member of.")
-		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+	// Viewers can read results but not run code.
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleEditor, logCtx) == nil {
 		return
 	}
 
 	var req ExecuteAuthRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := ac.bindJSON(c, &req); err != nil {
 		logCtx.WithError(err).Warn("Invalid request body for authenticated execution.")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if req.Input != "" && req.InputFilePath != "" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "input and inputFilePath are mutually exclusive"})
 		return
 	}
 
-	entrypointFile := filepath.Clean(req.EntrypointFile)
-	if entrypointFile == "." || strings.HasPrefix(entrypointFile, "..") {
+	if req.Language == "" || req.EntrypointFile == "" {
+		settings, err := ac.loadWorkspaceSettings(c.Request.Context(), workspaceID)
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to load workspace settings.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace settings"})
+			return
+		}
+		if req.Language == "" {
+			req.Language = settings.DefaultLanguage
+		}
+		if req.EntrypointFile == "" {
+			req.EntrypointFile = settings.DefaultEntrypoint
+		}
+		if req.Language == "" || req.EntrypointFile == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "language and entrypointFile are required unless the workspace settings set defaults"})
+			return
+		}
+	}
+
+	entrypointFile, ok := cleanEntrypointPath(req.EntrypointFile)
+	if !ok {
 		logCtx.Warnf("Invalid entrypoint path received: %s", req.EntrypointFile)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entrypoint file path."})
 		return
 	}
 
+	limits, err := ac.resolveExecutionLimits(req.Language, req.ExecutionOptions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !limits.allowsEntrypoint(entrypointFile) {
+		logCtx.Warnf("Entrypoint %s has an extension not runnable as %s", entrypointFile, req.Language)
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Entrypoint must have one of these extensions for %s: %s", req.Language, strings.Join(limits.EntrypointExtensions, ", "))})
+		return
+	}
+	deadline, err := ac.resolveJobDeadline(req.ExecutionOptions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	priority, err := resolveJobPriority(req.ExecutionOptions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateJobLabels(req.ExecutionOptions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	scheduleAt, err := parseScheduleAt(req.ScheduleAt, time.Now(), ac.AppConfig.ScheduleMaxHorizon)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !scheduleAt.IsZero() && deadline != "" && deadline <= TimeToISO8601(scheduleAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deadline must be after scheduleAt"})
+		return
+	}
+
+	idemKey, ok := ac.idempotencyKeyFor(c, "workspace:"+workspaceID)
+	if !ok {
+		return
+	}
+	if !ac.admitExecution(c, true) {
+		return
+	}
+
 	ctx := c.Request.Context()
 
-	// Get current workspace version to return to client
-	wsDocRef := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
-	wsDocSnap, err := wsDocRef.Get(ctx)
-	if err != nil {
-		logCtx.WithError(err).Errorf("Failed to get workspace %s for version check", workspaceID)
+	if err := ac.validateJobCallback(ctx, req.JobCallbackOptions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The version returned to the client is the one the manifest was read at
+	workspaceData, workerFiles, err := ac.readExecutionSnapshot(ctx, workspaceID)
+	if isNotFound(err) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
 		return
 	}
-	var workspaceData Workspace
-	if err := wsDocSnap.DataTo(&workspaceData); err != nil {
-		logCtx.WithError(err).Errorf("Failed to parse workspace data for %s", workspaceID)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse workspace data"})
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to build execution manifest.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspace files for execution."})
+		return
+	}
+	if req.ExpectedWorkspaceVersion != "" && req.ExpectedWorkspaceVersion != workspaceData.WorkspaceVersion {
+		logCtx.WithFields(log.Fields{
+			"expected_version": req.ExpectedWorkspaceVersion,
+			"current_version":  workspaceData.WorkspaceVersion,
+		}).Info("Execution rejected: workspace version mismatch.")
+		c.JSON(http.StatusConflict, gin.H{
+			"error":            "Workspace has changed since the expected version; sync and try again",
+			"code":             "workspace_version_mismatch",
+			"workspaceVersion": workspaceData.WorkspaceVersion,
+		})
+		return
+	}
+	if !ac.admitWorkspaceExecution(c, workspaceID, workspaceData, logCtx) {
 		return
 	}
 
-	// --- Fetch File Manifest ---
-	filesCollectionPath := fmt.Sprintf("workspaces/%s/files", workspaceID)
-	iter := ac.FirestoreClient.Collection(filesCollectionPath).Documents(ctx)
-	defer iter.Stop()
-
-	var workerFiles []WorkerFile
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			logCtx.WithError(err).Error("Failed to iterate over file documents for execution manifest.")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspace files for execution."})
+	var inputFile *WorkerFile
+	if req.InputFilePath != "" {
+		if !inputFileLanguages[req.Language] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("inputFilePath is not supported for %s", req.Language)})
 			return
 		}
-
-		var fileMeta FileMetadata
-		if err := doc.DataTo(&fileMeta); err != nil {
-			logCtx.WithError(err).WithField("document_id", doc.Ref.ID).Warn("Failed to parse file metadata for execution manifest.")
-			continue
-		}
-		// Only include actual files for the worker to download and use.
-		if fileMeta.Type == "file" {
-			workerFiles = append(workerFiles, WorkerFile{
-				R2ObjectKey: fileMeta.R2ObjectKey,
-				FilePath:    fileMeta.FilePath,
-			})
+		if inputFile = findWorkerFile(workerFiles, req.InputFilePath); inputFile == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Input file %s not found in workspace", req.InputFilePath)})
+			return
 		}
 	}
-	// --- End Fetch File Manifest ---
+
+	worker, _ := ac.Services.WorkerFor(req.Language)
+	dependencyManifests := detectDependencyManifests(workerFiles, worker.DependencyManifests)
 
 	jobID := uuid.New().String()
 	logCtx = logCtx.WithField("job_id", jobID)
 
-	jobDocRef := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Doc(jobID)
-	// Create authenticated job with standardized ISO 8601 timestamp
-	if _, err := jobDocRef.Set(ctx, Job{
-		Status:         "queued",
-		Language:       req.Language,
-		Input:          req.Input,
-		SubmittedAt:    NowISO8601(), // Exact JavaScript toISOString() format
-		UserID:         userID,
-		WorkspaceID:    workspaceID,
-		EntrypointFile: entrypointFile,
-		ExecutionType:  "authenticated_r2",
-	}); err != nil {
-		logCtx.WithError(err).Error("Failed to create authenticated job in Firestore")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job record"})
-		return
+	notificationRequested := false
+	if req.NotifyOnCompletion {
+		if ac.jobNotificationsEnabled() {
+			notificationRequested = true
+		} else {
+			logCtx.Warn("notifyOnCompletion ignored: no notifications service is configured.")
+		}
 	}
-	logCtx.Info("Authenticated job created in Firestore.")
-
 	taskPayload := CloudTaskAuthPayload{
-		WorkspaceID:    workspaceID,
-		EntrypointFile: entrypointFile,
-		Language:       req.Language,
-		Input:          req.Input,
-		R2BucketName:   ac.R2BucketName,
-		JobID:          jobID,
-		Files:          workerFiles,
+		WorkspaceID:         workspaceID,
+		EntrypointFile:      entrypointFile,
+		Language:            req.Language,
+		Input:               req.Input,
+		InputFile:           inputFile,
+		R2BucketName:        ac.R2BucketName,
+		JobID:               jobID,
+		Files:               workerFiles,
+		TimeoutSeconds:      limits.TimeoutSeconds,
+		MemoryMb:            limits.MemoryMb,
+		NotifyCompletion:    req.CallbackURL != "" || notificationRequested,
+		Deadline:            deadline,
+		DependencyManifests: dependencyManifests,
+	}
+
+	payloadBytes, manifestMode, err := ac.marshalAuthTaskPayload(ctx, taskPayload)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to prepare task payload for authenticated execution")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare job for execution"})
+		return
+	}
+	if manifestMode == manifestModeReference {
+		logCtx.WithField("file_count", len(workerFiles)).Info("Execution manifest passed by reference.")
+		if time.Until(scheduleAt) > manifestURLExpiry {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("This workspace has too many files to schedule a run more than %s ahead", manifestURLExpiry)})
+			return
+		}
 	}
 
-	payloadBytes, err := json.Marshal(taskPayload)
+	job := newJob(executionTypeWorkspace, ac.AppConfig.Retention.Jobs)
+	job.Language, job.Input = req.Language, req.Input
+	job.UserID, job.WorkspaceID = userID, workspaceID
+	job.EntrypointFile, job.InputFilePath = entrypointFile, inputFilePath(inputFile)
+	job.ManifestMode, job.DependencyManifests = manifestMode, dependencyManifestPaths(dependencyManifests)
+	job.CallbackURL, job.CallbackSecret = req.CallbackURL, req.CallbackSecret
+	job.Deadline, job.Priority, job.Labels = deadline, priority, req.Labels
+	job.NotificationRequested = notificationRequested
+	job.Replay = &JobReplay{TimeoutSeconds: limits.TimeoutSeconds, MemoryMb: limits.MemoryMb}
+	if !scheduleAt.IsZero() {
+		// Retention counts from the scheduled run, so the job outlives it as long as any other.
+		expiry := scheduleAt.Add(ac.AppConfig.Retention.Jobs)
+		job.Status, job.ScheduledAt = jobStatusScheduled, TimeToISO8601(scheduleAt)
+		job.ExpiresAt, job.DeleteAfter = TimeToISO8601(expiry), expiry
+	}
+	stored, replayed, err := ac.createJob(ctx, idemKey, nil, jobID, job)
 	if err != nil {
-		logCtx.WithError(err).Error("Failed to marshal task payload for authenticated execution")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare job for execution"})
+		logCtx.WithError(err).Error("Failed to create authenticated job in Firestore")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job record"})
 		return
 	}
+	if replayed {
+		logCtx.WithField("replayed_job_id", stored.ID).Info("Idempotent authenticated execution replayed.")
+		c.JSON(http.StatusOK, ExecuteAuthResponse{
+			Message:               "Authenticated code execution job already created for this idempotency key.",
+			JobID:                 stored.ID,
+			FinalWorkspaceVersion: workspaceData.WorkspaceVersion,
+			Replayed:              true,
+			ScheduledAt:           stored.Job.ScheduledAt,
+		})
+		return
+	}
+	logCtx.Info("Authenticated job created in Firestore.")
 
 	taskReq := &cloudtaskspb.CreateTaskRequest{
-		Parent: ac.AppConfig.GetQueuePath(ac.Services.PythonWorker.QueueID),
+		Parent: ac.AppConfig.GetQueuePath(ac.Services.PythonWorker.QueueFor(priority)),
 		Task: &cloudtaskspb.Task{
+			ScheduleTime: taskScheduleTime(scheduleAt),
 			MessageType: &cloudtaskspb.Task_HttpRequest{
 				HttpRequest: &cloudtaskspb.HttpRequest{
 					HttpMethod: cloudtaskspb.HttpMethod_POST,
 					Url:        fmt.Sprintf("%s/execute_auth", ac.Services.PythonWorker.ServiceURL),
-					Headers:    map[string]string{"Content-Type": "application/json"},
+					Headers:    executionTaskHeaders(jobID),
 					Body:       payloadBytes,
 					AuthorizationHeader: &cloudtaskspb.HttpRequest_OidcToken{
 						OidcToken: &cloudtaskspb.OidcToken{
@@ -1030,10 +1741,16 @@ func (ac *ApiController) ExecuteCodeAuthenticated(c *gin.Context) {
 	createdTask, err := ac.TasksClient.CreateTask(ctx, taskReq)
 	if err != nil {
 		logCtx.WithError(err).Error("Failed to create Cloud Task for authenticated execution")
+		ac.releaseIdempotencyKey(ctx, idemKey, logCtx)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit job for execution"})
 		return
 	}
 
+	if deadline != "" {
+		ac.deadlines.recordSubmitted(time.Now())
+	}
+	ac.recordJobTask(ctx, jobID, createdTask.GetName())
+	ac.touchWorkspace(ctx, workspaceID)
 	logCtx.WithFields(log.Fields{
 		"job_id":       jobID,
 		"task_name":    createdTask.GetName(),
@@ -1041,15 +1758,99 @@ func (ac *ApiController) ExecuteCodeAuthenticated(c *gin.Context) {
 		"final_workspace_version": workspaceData.WorkspaceVersion,
 	}).Info("Cloud Task created successfully for authenticated execution.")
 
+	message := "Authenticated code execution job created successfully."
+	if job.ScheduledAt != "" {
+		message = "Authenticated code execution job scheduled successfully."
+	}
 	c.JSON(http.StatusOK, ExecuteAuthResponse{
-		Message:               "Authenticated code execution job created successfully.",
+		Message:               message,
 		JobID:                 jobID,
 		FinalWorkspaceVersion: workspaceData.WorkspaceVersion,
+		ScheduledAt:           job.ScheduledAt,
 	})
 }
 
-// enqueueTask creates a Cloud Task with OIDC authentication
-func (ac *ApiController) enqueueTask(queuePath, serviceURL, serviceAccount string, payload interface{}) (*cloudtaskspb.Task, error) {
+// readExecutionSnapshot reads a workspace and its execution manifest in one read-only
+// transaction, so the files are those of the version returned even if a sync commits while
+// they are being read.
+func (ac *ApiController) readExecutionSnapshot(ctx context.Context, workspaceID string) (Workspace, []WorkerFile, error) {
+	var workspace Workspace
+	var workerFiles []WorkerFile
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		wsSnap, err := tx.Get(ac.FirestoreClient.Collection("workspaces").Doc(workspaceID))
+		if err != nil {
+			return err
+		}
+		if err := wsSnap.DataTo(&workspace); err != nil {
+			return fmt.Errorf("failed to parse workspace: %w", err)
+		}
+		docs, err := tx.Documents(ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID))).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to read file documents: %w", err)
+		}
+		workerFiles = workerFilesFromDocs(workspaceID, docs)
+		return nil
+	}, docstore.ReadOnly)
+	return workspace, workerFiles, err
+}
+
+// workspaceWorkerFiles builds the execution manifest: every file (not folder) in the workspace.
+func (ac *ApiController) workspaceWorkerFiles(ctx context.Context, workspaceID string) ([]WorkerFile, error) {
+	filesCollectionPath := fmt.Sprintf("workspaces/%s/files", workspaceID)
+	docs, err := ac.FirestoreClient.Collection(filesCollectionPath).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to iterate over file documents: %w", err)
+	}
+	return workerFilesFromDocs(workspaceID, docs), nil
+}
+
+// workerFilesFromDocs converts file documents into manifest entries, skipping folders.
+func workerFilesFromDocs(workspaceID string, docs []*docstore.DocumentSnapshot) []WorkerFile {
+	var workerFiles []WorkerFile
+	for _, doc := range docs {
+		var fileMeta FileMetadata
+		if err := doc.DataTo(&fileMeta); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"workspace_id": workspaceID,
+				"document_id":  doc.Ref.ID,
+			}).Warn("Failed to parse file metadata for execution manifest.")
+			continue
+		}
+		// Only include actual files for the worker to download and use.
+		if fileMeta.Type == "file" {
+			workerFiles = append(workerFiles, WorkerFile{
+				R2ObjectKey: fileMeta.R2ObjectKey,
+				FilePath:    fileMeta.FilePath,
+			})
+		}
+	}
+	return workerFiles
+}
+
+// inputFilePath returns the manifest path of an optional input file.
+func inputFilePath(file *WorkerFile) string {
+	if file == nil {
+		return ""
+	}
+	return file.FilePath
+}
+
+// findWorkerFile returns the manifest entry for a workspace path, or nil when the path is not
+// a file in the manifest. Paths are compared cleaned and without a leading slash.
+func findWorkerFile(files []WorkerFile, path string) *WorkerFile {
+	want := strings.TrimPrefix(filepath.Clean(path), "/")
+	for i := range files {
+		if strings.TrimPrefix(filepath.Clean(files[i].FilePath), "/") == want {
+			file := files[i]
+			return &file
+		}
+	}
+	return nil
+}
+
+// enqueueTask creates a Cloud Task with OIDC authentication. With a DedupeKey the task is
+// named deterministically and an existing task of that name counts as success.
+func (ac *ApiController) enqueueTask(ctx context.Context, queuePath, serviceURL, serviceAccount string, payload interface{}, opts ...enqueueOptions) (*cloudtaskspb.Task, error) {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal task payload: %w", err)
@@ -1071,16 +1872,28 @@ func (ac *ApiController) enqueueTask(queuePath, serviceURL, serviceAccount strin
 		},
 	}
 
+	if len(opts) > 0 && opts[0].DedupeKey != "" {
+		task.Name = dedupeTaskName(queuePath, opts[0], time.Now())
+	}
+	if len(opts) > 0 && opts[0].JobID != "" {
+		task.GetHttpRequest().Headers = executionTaskHeaders(opts[0].JobID)
+	}
+
 	req := &cloudtaskspb.CreateTaskRequest{
 		Parent: queuePath,
 		Task:   task,
 	}
 
-	return ac.TasksClient.CreateTask(context.Background(), req)
+	created, err := ac.TasksClient.CreateTask(ctx, req)
+	if err != nil && task.Name != "" && isAlreadyExists(err) {
+		log.WithFields(log.Fields{"task_name": task.Name, "dedupe_key": opts[0].DedupeKey}).Info("Task already enqueued; skipping duplicate.")
+		return task, nil
+	}
+	return created, err
 }
 
 // enqueueRagQuery enqueues a RAG query task
-func (ac *ApiController) enqueueRagQuery(jobID, userID, workspaceID, query string) error {
+func (ac *ApiController) enqueueRagQuery(ctx context.Context, jobID, userID, workspaceID, query string) error {
 	payload := RagQueryPayload{
 		JobID:       jobID,
 		UserID:      userID,
@@ -1089,12 +1902,13 @@ func (ac *ApiController) enqueueRagQuery(jobID, userID, workspaceID, query strin
 	}
 
 	queuePath := ac.AppConfig.GetQueuePath(ac.Services.RagQuery.QueueID)
-	_, err := ac.enqueueTask(queuePath, ac.Services.RagQuery.ServiceURL, ac.Services.RagQuery.ServiceAccount, payload)
+	_, err := ac.enqueueTask(ctx, queuePath, ac.Services.RagQuery.ServiceURL, ac.Services.RagQuery.ServiceAccount, payload)
 	return err
 }
 
-// enqueueRagIndexing enqueues a RAG indexing task
-func (ac *ApiController) enqueueRagIndexing(jobID, workspaceID string, files []WorkerFile) error {
+// enqueueRagIndexing enqueues a RAG indexing task. Each workspace version is indexed at most
+// once, so a retried sync confirm or import does not index the same files twice.
+func (ac *ApiController) enqueueRagIndexing(ctx context.Context, jobID, workspaceID, workspaceVersion string, files []WorkerFile) error {
 	payload := RagIndexingPayload{
 		JobID:       jobID,
 		WorkspaceID: workspaceID,
@@ -1102,7 +1916,9 @@ func (ac *ApiController) enqueueRagIndexing(jobID, workspaceID string, files []W
 	}
 
 	queuePath := ac.AppConfig.GetQueuePath(ac.Services.RagIndexing.QueueID)
-	_, err := ac.enqueueTask(queuePath, ac.Services.RagIndexing.ServiceURL, ac.Services.RagIndexing.ServiceAccount, payload)
+	_, err := ac.enqueueTask(ctx, queuePath, ac.Services.RagIndexing.ServiceURL, ac.Services.RagIndexing.ServiceAccount, payload, enqueueOptions{
+		DedupeKey: fmt.Sprintf("rag-index:%s:%s", workspaceID, workspaceVersion),
+	})
 	return err
 }
 
@@ -1116,9 +1932,8 @@ func (ac *ApiController) RagQuery(c *gin.Context) {
 	}
 
 	var req RagQueryRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := ac.bindJSON(c, &req); err != nil {
 		log.WithError(err).Warn("Invalid RAG query request body")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
 		return
 	}
 
@@ -1143,18 +1958,8 @@ func (ac *ApiController) RagQuery(c *gin.Context) {
 
 	// Create job in Firestore
 	jobID := uuid.New().String()
-	now := NowISO8601()
-	expiresAt := TimeToISO8601(time.Now().Add(24 * time.Hour))
-
-	job := Job{
-		Status:         "queued",
-		Language:       "rag_query",
-		SubmittedAt:    now,
-		ExpiresAt:      expiresAt,
-		UserID:         userID,
-		WorkspaceID:    req.WorkspaceID,
-		ExecutionType:  "rag_query",
-	}
+	job := newJob(executionTypeRagQuery, ac.AppConfig.Retention.Jobs)
+	job.Language, job.UserID, job.WorkspaceID = "rag_query", userID, req.WorkspaceID
 
 	jobDocRef := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Doc(jobID)
 	if _, err := jobDocRef.Set(c.Request.Context(), job); err != nil {
@@ -1164,13 +1969,17 @@ func (ac *ApiController) RagQuery(c *gin.Context) {
 	}
 
 	// Enqueue RAG query task
-	if err := ac.enqueueRagQuery(jobID, userID, req.WorkspaceID, req.Query); err != nil {
+	if err := ac.enqueueRagQuery(c.Request.Context(), jobID, userID, req.WorkspaceID, req.Query); err != nil {
 		logCtx.WithError(err).Error("Failed to enqueue RAG query task")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enqueue query task"})
 		return
 	}
 
 	logCtx.WithField("job_id", jobID).Info("RAG query task enqueued successfully")
+	ac.recordWorkspaceUsage(c.Request.Context(), req.WorkspaceID, map[string]interface{}{
+		"rag_query_count": docstore.Increment(1),
+	})
+	ac.touchWorkspace(c.Request.Context(), req.WorkspaceID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "RAG query enqueued successfully",
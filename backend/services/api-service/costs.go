@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+)
+
+// Sending X-Debug-Costs: 1 as an admin returns the request's totals in X-Request-Costs.
+const (
+	debugCostsHeader   = "X-Debug-Costs"
+	requestCostsHeader = "X-Request-Costs"
+)
+
+// RequestCosts counts the billable backend operations issued on behalf of one request.
+// Firestore reads are counted per document returned, which approximates billing
+// (empty query results are billed as one read but not counted here).
+type RequestCosts struct {
+	firestoreReads  atomic.Int64
+	firestoreWrites atomic.Int64
+	r2Operations    atomic.Int64
+}
+
+type requestCostsKey struct{}
+
+// withRequestCosts attaches a fresh cost counter to ctx.
+func withRequestCosts(ctx context.Context) (context.Context, *RequestCosts) {
+	costs := &RequestCosts{}
+	return context.WithValue(ctx, requestCostsKey{}, costs), costs
+}
+
+// requestCostsFrom returns the counter carried by ctx, or nil outside a request.
+func requestCostsFrom(ctx context.Context) *RequestCosts {
+	costs, _ := ctx.Value(requestCostsKey{}).(*RequestCosts)
+	return costs
+}
+
+// FirestoreReads returns the number of Firestore documents read.
+func (rc *RequestCosts) FirestoreReads() int64 { return rc.firestoreReads.Load() }
+
+// FirestoreWrites returns the number of Firestore document writes committed.
+func (rc *RequestCosts) FirestoreWrites() int64 { return rc.firestoreWrites.Load() }
+
+// R2Operations returns the number of R2 API calls sent (presigning is local and not counted).
+func (rc *RequestCosts) R2Operations() int64 { return rc.r2Operations.Load() }
+
+// String formats the totals for the debug response header.
+func (rc *RequestCosts) String() string {
+	return fmt.Sprintf("firestore_reads=%d;firestore_writes=%d;r2_ops=%d", rc.FirestoreReads(), rc.FirestoreWrites(), rc.R2Operations())
+}
+
+// RequestCostMiddleware attaches a cost counter to every request's context. When an admin
+// sends X-Debug-Costs: 1, the totals are added as X-Request-Costs just before the response
+// headers are written.
+func RequestCostMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, costs := withRequestCosts(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+
+		if isDebugCostsRequest(c.GetHeader(debugCostsHeader)) {
+			c.Writer = &costHeaderWriter{ResponseWriter: c.Writer, c: c, costs: costs}
+		}
+		c.Next()
+	}
+}
+
+// costHeaderWriter adds the cost header on the first header write. The admin check happens
+// at that point because AuthMiddleware runs after RequestCostMiddleware.
+type costHeaderWriter struct {
+	gin.ResponseWriter
+	c       *gin.Context
+	costs   *RequestCosts
+	written bool
+}
+
+func (w *costHeaderWriter) addHeader() {
+	if w.written {
+		return
+	}
+	w.written = true
+	if w.c.GetBool("isAdmin") {
+		w.Header().Set(requestCostsHeader, w.costs.String())
+	}
+}
+
+func (w *costHeaderWriter) WriteHeader(code int) {
+	w.addHeader()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *costHeaderWriter) WriteHeaderNow() {
+	w.addHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *costHeaderWriter) Write(data []byte) (int, error) {
+	w.addHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *costHeaderWriter) WriteString(s string) (int, error) {
+	w.addHeader()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// firestoreCostUnaryInterceptor counts committed writes against the request in ctx.
+func firestoreCostUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if costs := requestCostsFrom(ctx); costs != nil && err == nil {
+		switch r := req.(type) {
+		case *firestorepb.CommitRequest:
+			costs.firestoreWrites.Add(int64(len(r.GetWrites())))
+		case *firestorepb.BatchWriteRequest:
+			costs.firestoreWrites.Add(int64(len(r.GetWrites())))
+		}
+	}
+	return err
+}
+
+// firestoreCostStreamInterceptor counts documents returned by document gets and queries.
+func firestoreCostStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return stream, err
+	}
+	costs := requestCostsFrom(ctx)
+	if costs == nil {
+		return stream, nil
+	}
+	return &firestoreCostStream{ClientStream: stream, costs: costs}, nil
+}
+
+type firestoreCostStream struct {
+	grpc.ClientStream
+	costs *RequestCosts
+}
+
+func (s *firestoreCostStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	switch r := m.(type) {
+	case *firestorepb.BatchGetDocumentsResponse:
+		if r.GetFound() != nil || r.GetMissing() != "" {
+			s.costs.firestoreReads.Add(1)
+		}
+	case *firestorepb.RunQueryResponse:
+		if r.GetDocument() != nil {
+			s.costs.firestoreReads.Add(1)
+		}
+	case *firestorepb.RunAggregationQueryResponse:
+		if r.GetResult() != nil {
+			s.costs.firestoreReads.Add(1)
+		}
+	}
+	return nil
+}
+
+// firestoreCostDialOptions instruments the Firestore client's gRPC connection.
+func firestoreCostDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(firestoreCostUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(firestoreCostStreamInterceptor),
+	}
+}
+
+// addR2CostMiddleware counts R2 calls that are actually sent. It sits in the deserialize step,
+// which presigned requests never reach.
+func addR2CostMiddleware(stack *middleware.Stack) error {
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("CountR2Operations",
+		func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+			if costs := requestCostsFrom(ctx); costs != nil {
+				costs.r2Operations.Add(1)
+			}
+			return next.HandleDeserialize(ctx, in)
+		}), middleware.After)
+}
+
+// costLogFields returns the request's totals for the completion log entry and whether
+// Firestore reads crossed the warning threshold (0 disables the warning).
+func costLogFields(costs *RequestCosts, readWarnThreshold int) (map[string]interface{}, bool) {
+	if costs == nil {
+		return nil, false
+	}
+	fields := map[string]interface{}{
+		"firestore_reads":  costs.FirestoreReads(),
+		"firestore_writes": costs.FirestoreWrites(),
+		"r2_ops":           costs.R2Operations(),
+	}
+	return fields, readWarnThreshold > 0 && costs.FirestoreReads() > int64(readWarnThreshold)
+}
+
+// isDebugCostsRequest reports whether the caller asked for cost headers.
+func isDebugCostsRequest(header string) bool {
+	return strings.TrimSpace(header) == "1"
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func costsTestRouter(isAdmin bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestCostMiddleware())
+	r.GET("/", func(c *gin.Context) {
+		c.Set("isAdmin", isAdmin)
+		costs := requestCostsFrom(c.Request.Context())
+		costs.firestoreReads.Add(3)
+		costs.firestoreWrites.Add(1)
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	return r
+}
+
+func TestRequestCostHeader_OnlyForAdminsWhoAsk(t *testing.T) {
+	cases := []struct {
+		name     string
+		isAdmin  bool
+		debug    string
+		expected string
+	}{
+		{"admin with header", true, "1", "firestore_reads=3;firestore_writes=1;r2_ops=0"},
+		{"admin without header", true, "", ""},
+		{"non-admin with header", false, "1", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/", nil)
+			if tc.debug != "" {
+				req.Header.Set(debugCostsHeader, tc.debug)
+			}
+			costsTestRouter(tc.isAdmin).ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.expected, w.Header().Get(requestCostsHeader))
+		})
+	}
+}
+
+func TestCostLogFields_WarnThreshold(t *testing.T) {
+	costs := &RequestCosts{}
+	costs.firestoreReads.Add(250)
+
+	fields, warn := costLogFields(costs, 200)
+	assert.True(t, warn)
+	assert.Equal(t, int64(250), fields["firestore_reads"])
+
+	_, warn = costLogFields(costs, 0)
+	assert.False(t, warn, "zero disables the warning")
+
+	fields, warn = costLogFields(nil, 200)
+	assert.Nil(t, fields)
+	assert.False(t, warn)
+}
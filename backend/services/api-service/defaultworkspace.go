@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultWorkspaceName is what a user's default workspace is called until they rename it.
+const defaultWorkspaceName = "My Workspace"
+
+var (
+	// errUserHasWorkspace means the user already belongs to a workspace, so gets no default.
+	errUserHasWorkspace = errors.New("user already has a workspace")
+	// errDefaultWorkspaceTaken means the user's default workspace exists but they have left it.
+	errDefaultWorkspaceTaken = errors.New("default workspace already exists")
+)
+
+// defaultWorkspaceIDs returns the IDs of a user's default workspace and of their membership
+// in it. Both are derived from the user ID, so concurrent first requests all try to create
+// the same documents and only one can succeed.
+func defaultWorkspaceIDs(userID string) (workspaceID, membershipID string) {
+	ws := uuid.NewSHA1(uuid.NameSpaceURL, []byte("apeiron:default-workspace:"+userID))
+	return ws.String(), uuid.NewSHA1(ws, []byte(userID)).String()
+}
+
+// CreateDefaultWorkspace gives a user with no workspaces a "My Workspace" workspace they own,
+// seeded from the DefaultWorkspaceTemplateID template. It answers 201 with the new workspace,
+// or 204 when the user already belongs to a workspace, so clients may call it on every login.
+func (ac *ApiController) CreateDefaultWorkspace(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User authentication required"})
+		return
+	}
+	ctx := c.Request.Context()
+	workspaceID, membershipID := defaultWorkspaceIDs(userID)
+	logCtx := log.WithFields(log.Fields{"user_id": userID, "workspace_id": workspaceID, "handler": "CreateDefaultWorkspace"})
+
+	var req DefaultWorkspaceRequest
+	if c.Request.ContentLength > 0 {
+		if err := ac.bindJSON(c, &req); err != nil {
+			return
+		}
+	}
+
+	memberships := ac.FirestoreClient.Collection("workspace_memberships").Where("user_id", "==", userID).Limit(1)
+	existing, err := memberships.Documents(ctx).GetAll()
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to query workspace memberships")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create default workspace"})
+		return
+	}
+	if len(existing) > 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	now := NowISO8601()
+	initialVersion := "1"
+	var seeded []FileMetadata
+	var seededBytes int64
+	tmpl, err := ac.loadWorkspaceTemplate(ctx, ac.AppConfig.DefaultWorkspaceTemplateID)
+	switch {
+	case errors.Is(err, errTemplateNotFound):
+		logCtx.WithField("template_id", ac.AppConfig.DefaultWorkspaceTemplateID).Warn("Default workspace template not found; creating an empty workspace")
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to load default workspace template")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace template"})
+		return
+	default:
+		initialVersion = "2"
+		if seeded, err = ac.copyTemplateFiles(ctx, tmpl, workspaceID, initialVersion, now); err != nil {
+			logCtx.WithError(err).Error("Failed to copy default workspace template files")
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to copy workspace template files"})
+			return
+		}
+		for _, f := range seeded {
+			seededBytes += f.Size
+		}
+	}
+
+	workspace := Workspace{
+		WorkspaceID:      workspaceID,
+		Name:             defaultWorkspaceName,
+		NameLower:        normalizeWorkspaceName(defaultWorkspaceName),
+		CreatedBy:        userID,
+		OwnedBy:          userID,
+		CreatedAt:        now,
+		WorkspaceVersion: initialVersion,
+		StorageBytes:     seededBytes,
+		TemplateID:       tmpl.TemplateID,
+	}
+	membership := WorkspaceMembership{
+		MembershipID: membershipID,
+		WorkspaceID:  workspaceID,
+		UserID:       userID,
+		UserEmail:    req.UserEmail,
+		UserName:     req.UserName,
+		Role:         roleOwner,
+		JoinedAt:     now,
+	}
+	workspaceRef := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+	err = ac.commitNewWorkspace(ctx, workspace, membership, seeded, func(tx *docstore.Transaction) error {
+		// Re-checked here so that of several first requests racing, only one creates anything.
+		docs, err := tx.Documents(memberships).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			return errUserHasWorkspace
+		}
+		if _, err := tx.Get(workspaceRef); err == nil {
+			return errDefaultWorkspaceTaken
+		} else if !isNotFound(err) {
+			return err
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errUserHasWorkspace) || status.Code(err) == codes.AlreadyExists:
+		c.Status(http.StatusNoContent)
+		return
+	case errors.Is(err, errDefaultWorkspaceTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Your default workspace already exists but you are no longer a member of it"})
+		return
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to create default workspace")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create default workspace"})
+		return
+	}
+
+	logCtx.WithField("seeded_files", len(seeded)).Info("Default workspace created")
+	c.JSON(http.StatusCreated, CreateWorkspaceResponse{
+		WorkspaceID:    workspaceID,
+		Name:           defaultWorkspaceName,
+		CreatedBy:      userID,
+		CreatedAt:      now,
+		InitialVersion: initialVersion,
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultWorkspaceIDs(t *testing.T) {
+	ws, membership := defaultWorkspaceIDs("user-1")
+	again, _ := defaultWorkspaceIDs("user-1")
+	other, _ := defaultWorkspaceIDs("user-2")
+	assert.Equal(t, ws, again)
+	assert.NotEqual(t, ws, other)
+	assert.NotEqual(t, ws, membership)
+}
+
+func TestHandlers_CreateDefaultWorkspace(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.DefaultWorkspaceTemplateID = seedTemplate(h, []WorkspaceTemplateFile{
+		{FilePath: "main.py", Type: "file"},
+	}, map[string]string{"main.py": "print('hello')\n"})
+	user := "user-" + uuid.New().String()
+	workspaceID, _ := defaultWorkspaceIDs(user)
+
+	// Several tabs racing on first login make exactly one workspace.
+	codes := make([]int, 5)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = h.do(http.MethodPost, "/api/workspaces/default", user, nil, nil).Code
+		}(i)
+	}
+	wg.Wait()
+	created := 0
+	for _, code := range codes {
+		if code == http.StatusCreated {
+			created++
+		} else {
+			assert.Equal(t, http.StatusNoContent, code)
+		}
+	}
+	assert.Equal(t, 1, created, "%v", codes)
+
+	var listed []WorkspaceSummary
+	h.do(http.MethodGet, "/api/workspaces", user, nil, &listed)
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, workspaceID, listed[0].WorkspaceID)
+		assert.Equal(t, defaultWorkspaceName, listed[0].Name)
+		assert.Equal(t, roleOwner, listed[0].UserRole)
+	}
+	var manifest WorkspaceManifestResponse
+	h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/manifest", user, nil, &manifest)
+	if assert.Len(t, manifest.Manifest, 1) {
+		assert.Equal(t, "main.py", manifest.Manifest[0].FilePath)
+		assert.True(t, h.objects.has(manifest.Manifest[0].R2ObjectKey))
+	}
+	copies := 0
+	for key := range h.objects.objects {
+		if strings.HasPrefix(key, "workspaces/"+workspaceID+"/") {
+			copies++
+		}
+	}
+	assert.Equal(t, 1, copies, "the losing requests' copies are discarded")
+
+	w := h.do(http.MethodPost, "/api/workspaces/default", user, nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, "idempotent")
+}
+
+func TestHandlers_CreateDefaultWorkspaceSkipsExistingMembers(t *testing.T) {
+	h := newHandlerHarness(t)
+	user := "user-" + uuid.New().String()
+	h.createWorkspace(user)
+
+	w := h.do(http.MethodPost, "/api/workspaces/default", user, nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	workspaceID, _ := defaultWorkspaceIDs(user)
+	_, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	assert.True(t, isNotFound(err), "no default workspace: %v", err)
+}
+
+func TestHandlers_CreateDefaultWorkspaceWithoutTemplate(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.DefaultWorkspaceTemplateID = "missing-" + uuid.New().String()
+	user := "user-" + uuid.New().String()
+
+	var created CreateWorkspaceResponse
+	w := h.do(http.MethodPost, "/api/workspaces/default", user, DefaultWorkspaceRequest{UserEmail: "new@example.com"}, &created)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "1", created.InitialVersion)
+
+	var listed struct {
+		Members []WorkspaceMember `json:"members"`
+	}
+	h.do(http.MethodGet, "/api/workspaces/"+created.WorkspaceID+"/members", user, nil, &listed)
+	if assert.Len(t, listed.Members, 1) {
+		assert.Equal(t, "new@example.com", listed.Members[0].UserEmail)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// maxDependencyManifests bounds the dependency manifests listed in one task payload, so a
+// workspace with a vendored tree of package.json files cannot bloat it.
+const maxDependencyManifests = 20
+
+// detectDependencyManifests returns the files whose base name is in names, shallowest path
+// first and then by path, capped at maxDependencyManifests. Workers download these before the
+// rest of the manifest to install dependencies.
+func detectDependencyManifests(files []WorkerFile, names []string) []WorkerFile {
+	if len(names) == 0 {
+		return nil
+	}
+	var found []WorkerFile
+	for _, f := range files {
+		if slices.Contains(names, path.Base(f.FilePath)) {
+			found = append(found, f)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		di, dj := strings.Count(found[i].FilePath, "/"), strings.Count(found[j].FilePath, "/")
+		if di != dj {
+			return di < dj
+		}
+		return found[i].FilePath < found[j].FilePath
+	})
+	if len(found) > maxDependencyManifests {
+		found = found[:maxDependencyManifests]
+	}
+	return found
+}
+
+// dependencyManifestPaths returns the paths of detected manifests, for the job document.
+func dependencyManifestPaths(files []WorkerFile) []string {
+	if len(files) == 0 {
+		return nil
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.FilePath
+	}
+	return paths
+}
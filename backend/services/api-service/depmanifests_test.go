@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectDependencyManifests(t *testing.T) {
+	files := []WorkerFile{
+		{FilePath: "src/app/requirements.txt", R2ObjectKey: "k1"},
+		{FilePath: "main.py", R2ObjectKey: "k2"},
+		{FilePath: "tools/requirements.txt", R2ObjectKey: "k3"},
+		{FilePath: "pyproject.toml", R2ObjectKey: "k4"},
+		{FilePath: "requirements.txt.bak", R2ObjectKey: "k5"},
+		{FilePath: "web/package.json", R2ObjectKey: "k6"},
+	}
+
+	got := detectDependencyManifests(files, defaultPythonDependencyManifests)
+	assert.Equal(t, []WorkerFile{
+		{FilePath: "pyproject.toml", R2ObjectKey: "k4"},
+		{FilePath: "tools/requirements.txt", R2ObjectKey: "k3"},
+		{FilePath: "src/app/requirements.txt", R2ObjectKey: "k1"},
+	}, got)
+	assert.Equal(t, []string{"pyproject.toml", "tools/requirements.txt", "src/app/requirements.txt"}, dependencyManifestPaths(got))
+
+	assert.Equal(t, []WorkerFile{{FilePath: "web/package.json", R2ObjectKey: "k6"}}, detectDependencyManifests(files, []string{"package.json", "go.mod"}))
+	assert.Nil(t, detectDependencyManifests(files, nil))
+	assert.Nil(t, detectDependencyManifests(files, []string{}))
+	assert.Nil(t, dependencyManifestPaths(nil))
+}
+
+func TestDetectDependencyManifestsCap(t *testing.T) {
+	var files []WorkerFile
+	for i := 0; i < maxDependencyManifests+5; i++ {
+		files = append(files, WorkerFile{FilePath: fmt.Sprintf("vendor/pkg%02d/package.json", i)})
+	}
+	files = append(files, WorkerFile{FilePath: "package.json"})
+
+	got := detectDependencyManifests(files, []string{"package.json"})
+	assert.Len(t, got, maxDependencyManifests)
+	// The root manifest is kept even though it came last.
+	assert.Equal(t, "package.json", got[0].FilePath)
+}
+
+func TestValidateDependencyManifests(t *testing.T) {
+	assert.NoError(t, validateDependencyManifests(defaultPythonDependencyManifests))
+	assert.NoError(t, validateDependencyManifests([]string{"package.json", "go.mod"}))
+	assert.NoError(t, validateDependencyManifests(nil))
+	for _, bad := range []string{"", ".", "..", "app/requirements.txt", `app\requirements.txt`} {
+		assert.Error(t, validateDependencyManifests([]string{bad}), bad)
+	}
+}
@@ -0,0 +1,641 @@
+package docstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The in-memory store keeps field values the way Firestore returns them in
+// DocumentSnapshot.Data: nil, bool, int64, float64, string, []byte, time.Time (UTC, in
+// microseconds), []interface{} and map[string]interface{}. encodeValue and decodeValue
+// convert Go values the way the Firestore client does, honouring its "firestore" struct
+// tags, so documents round-trip the same on both backends.
+
+var (
+	typeOfByteSlice = reflect.TypeOf([]byte(nil))
+	typeOfTime      = reflect.TypeOf(time.Time{})
+)
+
+// encodeData encodes the data of a Create or Set, which must be a struct or map.
+func encodeData(data interface{}) (map[string]interface{}, error) {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, errors.New("docstore: nil document data")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct && v.Kind() != reflect.Map {
+		return nil, fmt.Errorf("docstore: document data must be a struct or map, got %T", data)
+	}
+	enc, err := encodeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := enc.(map[string]interface{})
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+	return fields, nil
+}
+
+// encodeValue converts a Go value to its stored form.
+func encodeValue(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	switch x := v.Interface().(type) {
+	case deleteSentinel:
+		return nil, errors.New("docstore: Delete can only be an update value or a value in a MergeAll map")
+	case increment:
+		return nil, errors.New("docstore: Increment can only be an update value or a top-level map value")
+	case []byte:
+		return bytes.Clone(x), nil
+	case time.Time:
+		return storedTime(x), nil
+	case *DocumentRef, *DocumentSnapshot:
+		return nil, fmt.Errorf("docstore: cannot store a %T", x)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		fallthrough
+	case reflect.Array:
+		vals := make([]interface{}, v.Len())
+		for i := range vals {
+			val, err := encodeValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = val
+		}
+		return vals, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, errors.New("docstore: map key type must be string")
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			val, err := encodeValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			m[iter.Key().String()] = val
+		}
+		return m, nil
+	case reflect.Struct:
+		fields, err := structFields(v.Type())
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			fv := v.FieldByIndex(f.index)
+			if f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			val, err := encodeValue(fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", v.Type(), f.goName, err)
+			}
+			m[f.name] = val
+		}
+		return m, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return encodeValue(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			return encodeValue(v.Elem())
+		}
+	}
+	return nil, fmt.Errorf("docstore: cannot store type %s", v.Type())
+}
+
+// storedTime is t as Firestore keeps it: in UTC, to the microsecond.
+func storedTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// isEmptyValue reports whether an omitempty field is left out, as in encoding/json, with
+// the zero time.Time also counting as empty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	if v.Type() == typeOfTime {
+		return v.Interface().(time.Time).IsZero()
+	}
+	return false
+}
+
+// decodeData decodes stored fields into p, which must be a non-nil pointer.
+func decodeData(p interface{}, fields map[string]interface{}) error {
+	v := reflect.ValueOf(p)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("docstore: nil or not a pointer")
+	}
+	return decodeValue(v.Elem(), fields)
+}
+
+// decodeValue sets dst from a stored value. Like the Firestore client, a null clears
+// nullable values and leaves others alone, and a struct only gets the fields present.
+func decodeValue(dst reflect.Value, src interface{}) error {
+	typeErr := func() error {
+		return fmt.Errorf("docstore: cannot set type %s to %T", dst.Type(), src)
+	}
+	if src == nil {
+		switch dst.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			dst.Set(reflect.Zero(dst.Type()))
+		}
+		return nil
+	}
+	switch dst.Type() {
+	case typeOfByteSlice:
+		b, ok := src.([]byte)
+		if !ok {
+			return typeErr()
+		}
+		dst.SetBytes(bytes.Clone(b))
+		return nil
+	case typeOfTime:
+		t, ok := src.(time.Time)
+		if !ok {
+			return typeErr()
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return typeErr()
+		}
+		dst.SetBool(b)
+	case reflect.String:
+		s, ok := src.(string)
+		if !ok {
+			return typeErr()
+		}
+		dst.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch x := src.(type) {
+		case int64:
+			i = x
+		case float64:
+			i = int64(x)
+			if float64(i) != x {
+				return fmt.Errorf("docstore: float %f does not fit into %s", x, dst.Type())
+			}
+		default:
+			return typeErr()
+		}
+		if dst.OverflowInt(i) {
+			return fmt.Errorf("docstore: value %d overflows type %s", i, dst.Type())
+		}
+		dst.SetInt(i)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		var u uint64
+		switch x := src.(type) {
+		case int64:
+			u = uint64(x)
+		case float64:
+			u = uint64(x)
+			if float64(u) != x {
+				return fmt.Errorf("docstore: float %f does not fit into %s", x, dst.Type())
+			}
+		default:
+			return typeErr()
+		}
+		if dst.OverflowUint(u) {
+			return fmt.Errorf("docstore: value %d overflows type %s", u, dst.Type())
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch x := src.(type) {
+		case float64:
+			f = x
+		case int64:
+			f = float64(x)
+		default:
+			return typeErr()
+		}
+		if dst.OverflowFloat(f) {
+			return fmt.Errorf("docstore: value %f overflows type %s", f, dst.Type())
+		}
+		dst.SetFloat(f)
+	case reflect.Slice:
+		vals, ok := src.([]interface{})
+		if !ok {
+			return typeErr()
+		}
+		if dst.Len() < len(vals) {
+			dst.Set(reflect.MakeSlice(dst.Type(), len(vals), len(vals)))
+		} else {
+			dst.SetLen(len(vals))
+		}
+		for i, val := range vals {
+			if err := decodeValue(dst.Index(i), val); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		vals, ok := src.([]interface{})
+		if !ok {
+			return typeErr()
+		}
+		for i := 0; i < dst.Len(); i++ {
+			if i >= len(vals) {
+				dst.Index(i).Set(reflect.Zero(dst.Type().Elem()))
+				continue
+			}
+			if err := decodeValue(dst.Index(i), vals[i]); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return typeErr()
+		}
+		if dst.Type().Key().Kind() != reflect.String {
+			return errors.New("docstore: map key type is not string")
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		for k, val := range m {
+			el := reflect.New(dst.Type().Elem()).Elem()
+			if err := decodeValue(el, val); err != nil {
+				return err
+			}
+			dst.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), el)
+		}
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return decodeValue(dst.Elem(), src)
+	case reflect.Struct:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return typeErr()
+		}
+		return decodeStruct(dst, m)
+	case reflect.Interface:
+		if dst.NumMethod() != 0 {
+			return fmt.Errorf("docstore: cannot set type %s", dst.Type())
+		}
+		if !dst.IsNil() && dst.Elem().Kind() == reflect.Ptr {
+			return decodeValue(dst.Elem(), src)
+		}
+		dst.Set(reflect.ValueOf(copyValue(src)))
+	default:
+		return fmt.Errorf("docstore: cannot set type %s", dst.Type())
+	}
+	return nil
+}
+
+// decodeStruct sets the fields of dst named in m, matching names exactly or else
+// case-insensitively.
+func decodeStruct(dst reflect.Value, m map[string]interface{}) error {
+	fields, err := structFields(dst.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		val, ok := m[f.name]
+		if !ok {
+			for k, v := range m {
+				if strings.EqualFold(k, f.name) {
+					val, ok = v, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := decodeValue(dst.FieldByIndex(f.index), val); err != nil {
+			return fmt.Errorf("%s.%s: %w", dst.Type(), f.goName, err)
+		}
+	}
+	return nil
+}
+
+// field is a stored struct field.
+type field struct {
+	name      string // Stored name
+	goName    string
+	index     []int
+	omitEmpty bool
+	tagged    bool
+}
+
+var fieldCache sync.Map // reflect.Type → []field
+
+// structFields lists the stored fields of t. As with encoding/json, fields of embedded
+// structs are promoted and a shallower field hides deeper ones with the same name.
+func structFields(t reflect.Type) ([]field, error) {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]field), nil
+	}
+	var all []field
+	if err := collectFields(t, nil, &all); err != nil {
+		return nil, err
+	}
+	byName := map[string][]field{}
+	var names []string
+	for _, f := range all {
+		if _, ok := byName[f.name]; !ok {
+			names = append(names, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+	var fields []field
+	for _, name := range names {
+		if f, ok := dominantField(byName[name]); ok {
+			fields = append(fields, f)
+		}
+	}
+	fieldCache.Store(t, fields)
+	return fields, nil
+}
+
+func collectFields(t reflect.Type, index []int, out *[]field) error {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("firestore")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		idx := append(append([]int(nil), index...), i)
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct && sf.Type != typeOfTime {
+			if err := collectFields(sf.Type, idx, out); err != nil {
+				return err
+			}
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		f := field{name: name, goName: sf.Name, index: idx, tagged: name != ""}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "":
+			case "omitempty":
+				f.omitEmpty = true
+			default:
+				return fmt.Errorf("docstore: unknown tag option %q on %s.%s", opt, t, sf.Name)
+			}
+		}
+		*out = append(*out, f)
+	}
+	return nil
+}
+
+// dominantField picks the field a name refers to: the shallowest, preferring a tagged
+// one. It reports false when the name is ambiguous.
+func dominantField(fields []field) (field, bool) {
+	depth := len(fields[0].index)
+	for _, f := range fields {
+		depth = min(depth, len(f.index))
+	}
+	var candidates []field
+	for _, f := range fields {
+		if len(f.index) == depth {
+			candidates = append(candidates, f)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	var tagged []field
+	for _, f := range candidates {
+		if f.tagged {
+			tagged = append(tagged, f)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return field{}, false
+}
+
+// copyValue deep-copies a stored value.
+func copyValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case []byte:
+		return bytes.Clone(x)
+	case []interface{}:
+		vals := make([]interface{}, len(x))
+		for i, val := range x {
+			vals[i] = copyValue(val)
+		}
+		return vals
+	case map[string]interface{}:
+		return copyFields(x)
+	}
+	return v
+}
+
+func copyFields(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = copyValue(v)
+	}
+	return out
+}
+
+// valueAt returns the stored value at a field path.
+func valueAt(fields map[string]interface{}, fp FieldPath) (interface{}, bool) {
+	var cur interface{} = fields
+	for _, seg := range fp {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[seg]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// typeOrder ranks stored values by type the way Firestore sorts mixed types.
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case int64, float64:
+		return 2
+	case time.Time:
+		return 3
+	case string:
+		return 4
+	case []byte:
+		return 5
+	case docName:
+		return 6
+	case []interface{}:
+		return 7
+	case map[string]interface{}:
+		return 8
+	}
+	return 9
+}
+
+// compareValues orders two stored values as Firestore does: by type, then by value, with
+// integers and doubles compared numerically and NaN first.
+func compareValues(a, b interface{}) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return compareInts(ta, tb)
+	}
+	switch x := a.(type) {
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case int64, float64:
+		return compareNumbers(a, b)
+	case time.Time:
+		return x.Compare(b.(time.Time))
+	case string:
+		return strings.Compare(x, b.(string))
+	case []byte:
+		return bytes.Compare(x, b.([]byte))
+	case docName:
+		return compareDocNames(x, b.(docName))
+	case []interface{}:
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(x), len(y))
+	case map[string]interface{}:
+		return compareMaps(x, b.(map[string]interface{}))
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareNumbers(a, b interface{}) int {
+	ai, aInt := a.(int64)
+	bi, bInt := b.(int64)
+	if aInt && bInt {
+		switch {
+		case ai < bi:
+			return -1
+		case ai > bi:
+			return 1
+		}
+		return 0
+	}
+	af, bf := toFloat(a), toFloat(b)
+	switch {
+	case math.IsNaN(af) && math.IsNaN(bf):
+		return 0
+	case math.IsNaN(af):
+		return -1
+	case math.IsNaN(bf):
+		return 1
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+// compareMaps orders maps by their sorted keys and values in turn.
+func compareMaps(a, b map[string]interface{}) int {
+	ak, bk := sortedKeys(a), sortedKeys(b)
+	for i := 0; i < len(ak) && i < len(bk); i++ {
+		if c := strings.Compare(ak[i], bk[i]); c != 0 {
+			return c
+		}
+		if c := compareValues(a[ak[i]], b[bk[i]]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(ak), len(bk))
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,626 @@
+// Package docstore is the document database the API service keeps its state in. It mirrors
+// the part of the Firestore client the service uses, so call sites read as they would against
+// cloud.google.com/go/firestore, and runs either on Firestore (NewFirestore) or on an
+// in-memory store for tests (NewMemory) that can be told to fail chosen operations.
+package docstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DocumentID orders or filters a query by document ID.
+const DocumentID = "__name__"
+
+// Client reads and writes documents. Use NewFirestore or NewMemory.
+type Client struct {
+	b backend
+}
+
+// backend is what a Client runs on.
+type backend interface {
+	getAll(ctx context.Context, refs []*DocumentRef) ([]*DocumentSnapshot, error)
+	query(ctx context.Context, q *Query) snapshotStream
+	aggregate(ctx context.Context, a *AggregationQuery) (AggregationResult, error)
+	commit(ctx context.Context, w *write) (*WriteResult, error)
+	runTransaction(ctx context.Context, f func(context.Context, *Transaction) error, s transactionSettings) error
+	bulkWriter(ctx context.Context) bulkWriterBackend
+	listen(ctx context.Context, ref *DocumentRef) snapshotStream
+	close() error
+}
+
+// transactionBackend runs the reads and buffers the writes of one transaction attempt.
+type transactionBackend interface {
+	getAll(refs []*DocumentRef) ([]*DocumentSnapshot, error)
+	query(q *Query) snapshotStream
+	aggregate(ctx context.Context, a *AggregationQuery) (AggregationResult, error)
+	write(w *write) error
+}
+
+// bulkWriterBackend queues independent writes.
+type bulkWriterBackend interface {
+	write(w *write) (*BulkWriterJob, error)
+	flush()
+	end()
+}
+
+// snapshotStream yields query results or document snapshots, ending with iterator.Done.
+type snapshotStream interface {
+	next() (*DocumentSnapshot, error)
+	stop()
+}
+
+// documentData is the content of an existing document as read by a backend.
+type documentData interface {
+	dataTo(p interface{}) error
+	data() map[string]interface{}
+	dataAt(path string) (interface{}, error)
+}
+
+// Collection returns a reference to the collection at path, such as "jobs" or
+// "workspaces/w1/files". It returns nil for a path naming a document.
+func (c *Client) Collection(path string) *CollectionRef {
+	segments := strings.Split(path, "/")
+	if len(segments)%2 == 0 || !validSegments(segments) {
+		return nil
+	}
+	coll := c.rootCollection(segments[0])
+	for i := 1; i < len(segments); i += 2 {
+		coll = coll.Doc(segments[i]).Collection(segments[i+1])
+	}
+	return coll
+}
+
+// Doc returns a reference to the document at path, such as "workspaces/w1". It returns nil
+// for a path naming a collection.
+func (c *Client) Doc(path string) *DocumentRef {
+	segments := strings.Split(path, "/")
+	if len(segments)%2 != 0 || !validSegments(segments) {
+		return nil
+	}
+	return c.Collection(strings.Join(segments[:len(segments)-1], "/")).Doc(segments[len(segments)-1])
+}
+
+// CollectionGroup returns a query over every collection with the given ID, at any depth.
+func (c *Client) CollectionGroup(collectionID string) *CollectionGroupRef {
+	return &CollectionGroupRef{Query: Query{c: c, collectionID: collectionID, allDescendants: true}}
+}
+
+// GetAll reads docs in one round trip. Missing documents are returned as snapshots whose
+// Exists reports false.
+func (c *Client) GetAll(ctx context.Context, docs []*DocumentRef) ([]*DocumentSnapshot, error) {
+	return c.b.getAll(ctx, docs)
+}
+
+// RunTransaction runs f in a transaction, retrying it when the transaction conflicts with
+// another one. An error returned by f aborts the transaction and is returned unchanged.
+func (c *Client) RunTransaction(ctx context.Context, f func(context.Context, *Transaction) error, opts ...TransactionOption) error {
+	s := transactionSettings{maxAttempts: DefaultTransactionMaxAttempts}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if s.maxAttempts < 1 {
+		return errors.New("docstore: MaxAttempts must be at least 1")
+	}
+	return c.b.runTransaction(ctx, f, s)
+}
+
+// BulkWriter returns a writer for many independent writes. Call End once all are queued.
+func (c *Client) BulkWriter(ctx context.Context) *BulkWriter {
+	return &BulkWriter{b: c.b.bulkWriter(ctx)}
+}
+
+// Close releases the client's connections.
+func (c *Client) Close() error {
+	return c.b.close()
+}
+
+func (c *Client) rootCollection(id string) *CollectionRef {
+	return &CollectionRef{Path: id, ID: id, Query: Query{c: c, collectionID: id}}
+}
+
+func validSegments(segments []string) bool {
+	for _, s := range segments {
+		if s == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// CollectionRef refers to a collection, and queries all of its documents.
+type CollectionRef struct {
+	// Parent is the document holding a subcollection, and nil for a root collection.
+	Parent *DocumentRef
+	// Path is the collection's path from the database root, such as "workspaces/w1/files".
+	Path string
+	// ID is the last segment of Path.
+	ID string
+	Query
+}
+
+// Doc returns a reference to the document with the given ID, or nil for an invalid ID.
+func (c *CollectionRef) Doc(id string) *DocumentRef {
+	if id == "" || strings.Contains(id, "/") {
+		return nil
+	}
+	return &DocumentRef{Parent: c, Path: c.Path + "/" + id, ID: id, c: c.c}
+}
+
+// CollectionGroupRef queries all collections with the same ID; see Client.CollectionGroup.
+type CollectionGroupRef struct {
+	Query
+}
+
+// DocumentRef refers to a document, which may or may not exist.
+type DocumentRef struct {
+	// Parent is the collection holding the document.
+	Parent *CollectionRef
+	// Path is the document's path from the database root, such as "workspaces/w1".
+	Path string
+	// ID is the last segment of Path.
+	ID string
+
+	c *Client
+}
+
+// Collection returns a reference to the document's subcollection with the given ID.
+func (d *DocumentRef) Collection(id string) *CollectionRef {
+	if id == "" || strings.Contains(id, "/") {
+		return nil
+	}
+	return &CollectionRef{
+		Parent: d,
+		Path:   d.Path + "/" + id,
+		ID:     id,
+		Query:  Query{c: d.c, parentPath: d.Path, collectionID: id},
+	}
+}
+
+// Get reads the document. For a missing document it returns a snapshot whose Exists
+// reports false together with a NotFound error.
+func (d *DocumentRef) Get(ctx context.Context) (*DocumentSnapshot, error) {
+	snaps, err := d.c.b.getAll(ctx, []*DocumentRef{d})
+	if err != nil {
+		return nil, err
+	}
+	return snaps[0], notFoundUnlessExists(snaps[0])
+}
+
+// Create writes a new document, failing with AlreadyExists when it exists.
+func (d *DocumentRef) Create(ctx context.Context, data interface{}) (*WriteResult, error) {
+	return d.c.b.commit(ctx, &write{kind: writeCreate, ref: d, data: data})
+}
+
+// Set replaces the document with data, or with MergeAll, writes only the fields in data,
+// which must then be a map.
+func (d *DocumentRef) Set(ctx context.Context, data interface{}, opts ...SetOption) (*WriteResult, error) {
+	w, err := newSetWrite(d, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	return d.c.b.commit(ctx, w)
+}
+
+// Update changes the given fields of an existing document, failing with NotFound when it
+// is missing.
+func (d *DocumentRef) Update(ctx context.Context, updates []Update, preconds ...Precondition) (*WriteResult, error) {
+	w, err := newUpdateWrite(d, updates, preconds)
+	if err != nil {
+		return nil, err
+	}
+	return d.c.b.commit(ctx, w)
+}
+
+// Delete removes the document. Deleting a missing document succeeds unless there is a
+// precondition.
+func (d *DocumentRef) Delete(ctx context.Context, preconds ...Precondition) (*WriteResult, error) {
+	w, err := newDeleteWrite(d, preconds)
+	if err != nil {
+		return nil, err
+	}
+	return d.c.b.commit(ctx, w)
+}
+
+// Snapshots listens to the document. The first Next returns its current state and each
+// later one its state after a change.
+func (d *DocumentRef) Snapshots(ctx context.Context) *DocumentSnapshotIterator {
+	return &DocumentSnapshotIterator{s: d.c.b.listen(ctx, d)}
+}
+
+// DocumentSnapshot is a document as read at one point in time.
+type DocumentSnapshot struct {
+	// Ref is the document read.
+	Ref *DocumentRef
+	// CreateTime and UpdateTime are zero when the document does not exist.
+	CreateTime time.Time
+	UpdateTime time.Time
+	// ReadTime is when the document was read.
+	ReadTime time.Time
+
+	d documentData
+}
+
+// Exists reports whether the document existed when it was read.
+func (d *DocumentSnapshot) Exists() bool {
+	return d != nil && d.d != nil
+}
+
+// DataTo decodes the document into p, a pointer to a struct or map, following the
+// "firestore" struct tags of the Firestore client.
+func (d *DocumentSnapshot) DataTo(p interface{}) error {
+	if !d.Exists() {
+		return notFound(d.Ref)
+	}
+	return d.d.dataTo(p)
+}
+
+// Data returns the document's fields, or nil when it does not exist.
+func (d *DocumentSnapshot) Data() map[string]interface{} {
+	if !d.Exists() {
+		return nil
+	}
+	return d.d.data()
+}
+
+// DataAt returns the value at a dot-separated field path.
+func (d *DocumentSnapshot) DataAt(path string) (interface{}, error) {
+	if !d.Exists() {
+		return nil, notFound(d.Ref)
+	}
+	return d.d.dataAt(path)
+}
+
+func notFound(ref *DocumentRef) error {
+	return status.Errorf(codes.NotFound, "document %s does not exist", ref.Path)
+}
+
+func notFoundUnlessExists(snap *DocumentSnapshot) error {
+	if snap.Exists() {
+		return nil
+	}
+	return notFound(snap.Ref)
+}
+
+// DocumentIterator returns query results in order.
+type DocumentIterator struct {
+	s   snapshotStream
+	err error
+}
+
+// Next returns the next result, or iterator.Done after the last one.
+func (it *DocumentIterator) Next() (*DocumentSnapshot, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	snap, err := it.s.next()
+	if err != nil {
+		it.err = err
+	}
+	return snap, err
+}
+
+// Stop ends the iteration early. It is safe to call more than once.
+func (it *DocumentIterator) Stop() {
+	if it.s != nil {
+		it.s.stop()
+	}
+	if it.err == nil {
+		it.err = iterator.Done
+	}
+}
+
+// GetAll returns the remaining results and stops the iterator.
+func (it *DocumentIterator) GetAll() ([]*DocumentSnapshot, error) {
+	defer it.Stop()
+	var snaps []*DocumentSnapshot
+	for {
+		snap, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return snaps, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+}
+
+// DocumentSnapshotIterator returns the states of a listened-to document; see
+// DocumentRef.Snapshots.
+type DocumentSnapshotIterator struct {
+	s snapshotStream
+}
+
+// Next waits for the document's next state. It returns a Canceled error once the
+// listener's context is done.
+func (it *DocumentSnapshotIterator) Next() (*DocumentSnapshot, error) {
+	return it.s.next()
+}
+
+// Stop ends the listener.
+func (it *DocumentSnapshotIterator) Stop() {
+	it.s.stop()
+}
+
+// Update changes one field in DocumentRef.Update and Transaction.Update. Path is a
+// dot-separated field path; FieldPath is used instead for segments containing dots.
+type Update struct {
+	Path      string
+	FieldPath FieldPath
+	Value     interface{}
+}
+
+// FieldPath is a field path given segment by segment.
+type FieldPath []string
+
+// WriteResult is the outcome of a write.
+type WriteResult struct {
+	// UpdateTime is when the write was applied.
+	UpdateTime time.Time
+}
+
+// deleteSentinel is the type of Delete.
+type deleteSentinel struct{}
+
+// Delete, as an Update value or as a field value with MergeAll, removes the field.
+var Delete = deleteSentinel{}
+
+// increment is a value added to a numeric field; see Increment.
+type increment struct {
+	n interface{}
+}
+
+// Increment, as an Update value or as a field value with MergeAll, adds n to the field's
+// numeric value. A missing or non-numeric field is set to n.
+func Increment(n interface{}) interface{} {
+	return increment{n: n}
+}
+
+// Precondition makes a write fail with FailedPrecondition unless the document is unchanged.
+type Precondition struct {
+	updateTime time.Time
+}
+
+// LastUpdateTime requires that the document exists and was last updated at t.
+func LastUpdateTime(t time.Time) Precondition {
+	return Precondition{updateTime: t}
+}
+
+// SetOption changes how Set writes a document.
+type SetOption interface {
+	setOption()
+}
+
+type mergeAll struct{}
+
+func (mergeAll) setOption() {}
+
+// MergeAll makes Set write only the fields present in its data.
+var MergeAll SetOption = mergeAll{}
+
+// DefaultTransactionMaxAttempts is how often a transaction is tried without MaxAttempts.
+const DefaultTransactionMaxAttempts = 5
+
+// transactionSettings are the options of one RunTransaction call.
+type transactionSettings struct {
+	maxAttempts int
+	readOnly    bool
+}
+
+// TransactionOption configures RunTransaction.
+type TransactionOption func(*transactionSettings)
+
+// MaxAttempts sets how often a conflicting transaction is tried in total.
+func MaxAttempts(n int) TransactionOption {
+	return func(s *transactionSettings) { s.maxAttempts = n }
+}
+
+// ReadOnly runs a transaction that only reads.
+var ReadOnly TransactionOption = func(s *transactionSettings) { s.readOnly = true }
+
+// errReadAfterWrite is returned for a transaction read after one of its writes.
+var errReadAfterWrite = errors.New("docstore: read after write in transaction")
+
+// Transaction reads and writes documents atomically; see Client.RunTransaction. All reads
+// must come before the first write.
+type Transaction struct {
+	t transactionBackend
+}
+
+// Get reads a document as DocumentRef.Get does.
+func (t *Transaction) Get(ref *DocumentRef) (*DocumentSnapshot, error) {
+	snaps, err := t.t.getAll([]*DocumentRef{ref})
+	if err != nil {
+		return nil, err
+	}
+	return snaps[0], notFoundUnlessExists(snaps[0])
+}
+
+// GetAll reads documents as Client.GetAll does.
+func (t *Transaction) GetAll(refs []*DocumentRef) ([]*DocumentSnapshot, error) {
+	return t.t.getAll(refs)
+}
+
+// Documents runs a query in the transaction.
+func (t *Transaction) Documents(q Queryer) *DocumentIterator {
+	query := q.query()
+	if query.err != nil {
+		return &DocumentIterator{err: query.err}
+	}
+	return &DocumentIterator{s: t.t.query(query)}
+}
+
+// Create queues a DocumentRef.Create.
+func (t *Transaction) Create(ref *DocumentRef, data interface{}) error {
+	return t.t.write(&write{kind: writeCreate, ref: ref, data: data})
+}
+
+// Set queues a DocumentRef.Set.
+func (t *Transaction) Set(ref *DocumentRef, data interface{}, opts ...SetOption) error {
+	w, err := newSetWrite(ref, data, opts)
+	if err != nil {
+		return err
+	}
+	return t.t.write(w)
+}
+
+// Update queues a DocumentRef.Update.
+func (t *Transaction) Update(ref *DocumentRef, updates []Update, preconds ...Precondition) error {
+	w, err := newUpdateWrite(ref, updates, preconds)
+	if err != nil {
+		return err
+	}
+	return t.t.write(w)
+}
+
+// Delete queues a DocumentRef.Delete.
+func (t *Transaction) Delete(ref *DocumentRef, preconds ...Precondition) error {
+	w, err := newDeleteWrite(ref, preconds)
+	if err != nil {
+		return err
+	}
+	return t.t.write(w)
+}
+
+// BulkWriter applies many independent writes; see Client.BulkWriter. Unlike a
+// transaction, each write succeeds or fails alone.
+type BulkWriter struct {
+	b bulkWriterBackend
+}
+
+// BulkWriterJob is one queued write of a BulkWriter.
+type BulkWriterJob struct {
+	results func() (*WriteResult, error)
+}
+
+// Results waits for the write and returns its outcome.
+func (j *BulkWriterJob) Results() (*WriteResult, error) {
+	return j.results()
+}
+
+// Create queues a DocumentRef.Create.
+func (bw *BulkWriter) Create(ref *DocumentRef, data interface{}) (*BulkWriterJob, error) {
+	return bw.b.write(&write{kind: writeCreate, ref: ref, data: data})
+}
+
+// Set queues a DocumentRef.Set.
+func (bw *BulkWriter) Set(ref *DocumentRef, data interface{}, opts ...SetOption) (*BulkWriterJob, error) {
+	w, err := newSetWrite(ref, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	return bw.b.write(w)
+}
+
+// Update queues a DocumentRef.Update.
+func (bw *BulkWriter) Update(ref *DocumentRef, updates []Update, preconds ...Precondition) (*BulkWriterJob, error) {
+	w, err := newUpdateWrite(ref, updates, preconds)
+	if err != nil {
+		return nil, err
+	}
+	return bw.b.write(w)
+}
+
+// Delete queues a DocumentRef.Delete.
+func (bw *BulkWriter) Delete(ref *DocumentRef, preconds ...Precondition) (*BulkWriterJob, error) {
+	w, err := newDeleteWrite(ref, preconds)
+	if err != nil {
+		return nil, err
+	}
+	return bw.b.write(w)
+}
+
+// Flush sends the queued writes and waits for them.
+func (bw *BulkWriter) Flush() {
+	bw.b.flush()
+}
+
+// End flushes the queued writes and closes the writer.
+func (bw *BulkWriter) End() {
+	bw.b.end()
+}
+
+// writeKind is the operation of a write.
+type writeKind int
+
+const (
+	writeCreate writeKind = iota
+	writeSet
+	writeUpdate
+	writeDelete
+)
+
+func (k writeKind) String() string {
+	return [...]string{"create", "set", "update", "delete"}[k]
+}
+
+// write is one document write, as queued by a transaction or bulk writer or sent alone.
+type write struct {
+	kind    writeKind
+	ref     *DocumentRef
+	data    interface{} // Create and Set
+	merge   bool        // Set with MergeAll
+	updates []Update    // Update
+	precond *Precondition
+}
+
+func newSetWrite(ref *DocumentRef, data interface{}, opts []SetOption) (*write, error) {
+	w := &write{kind: writeSet, ref: ref, data: data}
+	for _, opt := range opts {
+		if opt == MergeAll {
+			w.merge = true
+		}
+	}
+	if w.merge {
+		if _, ok := data.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("docstore: MergeAll requires map[string]interface{} data, got %T", data)
+		}
+	}
+	return w, nil
+}
+
+func newUpdateWrite(ref *DocumentRef, updates []Update, preconds []Precondition) (*write, error) {
+	if len(updates) == 0 {
+		return nil, errors.New("docstore: no updates")
+	}
+	for _, u := range updates {
+		if (u.Path == "") == (len(u.FieldPath) == 0) {
+			return nil, errors.New("docstore: an update needs exactly one of Path and FieldPath")
+		}
+	}
+	w := &write{kind: writeUpdate, ref: ref, updates: updates}
+	return w, w.setPrecondition(preconds)
+}
+
+func newDeleteWrite(ref *DocumentRef, preconds []Precondition) (*write, error) {
+	w := &write{kind: writeDelete, ref: ref}
+	return w, w.setPrecondition(preconds)
+}
+
+func (w *write) setPrecondition(preconds []Precondition) error {
+	switch len(preconds) {
+	case 0:
+		return nil
+	case 1:
+		w.precond = &preconds[0]
+		return nil
+	default:
+		return errors.New("docstore: at most one precondition is allowed")
+	}
+}
+
+// fieldPath returns the update's field path.
+func (u Update) fieldPath() FieldPath {
+	if len(u.FieldPath) > 0 {
+		return u.FieldPath
+	}
+	return strings.Split(u.Path, ".")
+}
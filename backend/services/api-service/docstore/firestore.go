@@ -0,0 +1,332 @@
+package docstore
+
+import (
+	"context"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// NewFirestore returns a client running on fs. Documents are encoded by the Firestore
+// client itself, so its struct tags and conversions apply unchanged.
+func NewFirestore(fs *firestore.Client) *Client {
+	return &Client{b: &firestoreBackend{fs: fs}}
+}
+
+// firestoreBackend runs a Client on Firestore.
+type firestoreBackend struct {
+	fs *firestore.Client
+}
+
+// fsData is the content of a Firestore document.
+type fsData struct {
+	snap *firestore.DocumentSnapshot
+}
+
+func (d fsData) dataTo(p interface{}) error {
+	return d.snap.DataTo(p)
+}
+
+func (d fsData) data() map[string]interface{} {
+	return d.snap.Data()
+}
+
+func (d fsData) dataAt(path string) (interface{}, error) {
+	return d.snap.DataAt(path)
+}
+
+func (b *firestoreBackend) ref(ref *DocumentRef) *firestore.DocumentRef {
+	return b.fs.Doc(ref.Path)
+}
+
+func (b *firestoreBackend) refs(refs []*DocumentRef) []*firestore.DocumentRef {
+	out := make([]*firestore.DocumentRef, len(refs))
+	for i, ref := range refs {
+		out[i] = b.ref(ref)
+	}
+	return out
+}
+
+// snapshot converts a snapshot read for ref, or for a query result when ref is nil.
+func (b *firestoreBackend) snapshot(c *Client, ref *DocumentRef, snap *firestore.DocumentSnapshot) *DocumentSnapshot {
+	if ref == nil {
+		_, path, _ := strings.Cut(snap.Ref.Path, "/documents/")
+		ref = c.Doc(path)
+	}
+	out := &DocumentSnapshot{Ref: ref, CreateTime: snap.CreateTime, UpdateTime: snap.UpdateTime, ReadTime: snap.ReadTime}
+	if snap.Exists() {
+		out.d = fsData{snap: snap}
+	}
+	return out
+}
+
+func (b *firestoreBackend) snapshots(refs []*DocumentRef, snaps []*firestore.DocumentSnapshot) []*DocumentSnapshot {
+	out := make([]*DocumentSnapshot, len(snaps))
+	for i, snap := range snaps {
+		out[i] = b.snapshot(refs[i].c, refs[i], snap)
+	}
+	return out
+}
+
+// buildQuery builds the Firestore query for q.
+func (b *firestoreBackend) buildQuery(q *Query) firestore.Query {
+	var fq firestore.Query
+	if q.allDescendants {
+		fq = b.fs.CollectionGroup(q.collectionID).Query
+	} else {
+		fq = b.fs.Collection(q.collectionPath()).Query
+	}
+	for _, f := range q.filters {
+		fq = fq.WherePath(firestore.FieldPath(f.path), f.op, b.queryValue(f.value))
+	}
+	for _, o := range q.orders {
+		dir := firestore.Asc
+		if o.dir == Desc {
+			dir = firestore.Desc
+		}
+		fq = fq.OrderByPath(firestore.FieldPath(o.path), dir)
+	}
+	if q.limited {
+		fq = fq.Limit(q.limit)
+	}
+	if snap := q.cursorSnapshot(); snap != nil {
+		if d, ok := snap.d.(fsData); ok {
+			fq = fq.StartAfter(d.snap)
+		}
+	} else if len(q.startAfter) > 0 {
+		values := make([]interface{}, len(q.startAfter))
+		for i, v := range q.startAfter {
+			values[i] = b.queryValue(v)
+		}
+		fq = fq.StartAfter(values...)
+	}
+	return fq
+}
+
+// queryValue translates a DocumentRef given as a filter or cursor value.
+func (b *firestoreBackend) queryValue(v interface{}) interface{} {
+	if ref, ok := v.(*DocumentRef); ok {
+		return b.ref(ref)
+	}
+	return v
+}
+
+func (b *firestoreBackend) aggregationQuery(a *AggregationQuery) *firestore.AggregationQuery {
+	aq := b.buildQuery(&a.q).NewAggregationQuery()
+	for _, agg := range a.aggregations {
+		if agg.sum == nil {
+			aq = aq.WithCount(agg.alias)
+		} else {
+			aq = aq.WithSumPath(firestore.FieldPath(agg.sum), agg.alias)
+		}
+	}
+	return aq
+}
+
+// fsValue translates Delete and Increment, also as values of a MergeAll map.
+func fsValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case deleteSentinel:
+		return firestore.Delete
+	case increment:
+		return firestore.Increment(x.n)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			m[k] = fsValue(v)
+		}
+		return m
+	}
+	return v
+}
+
+func fsUpdates(us []Update) []firestore.Update {
+	out := make([]firestore.Update, len(us))
+	for i, u := range us {
+		out[i] = firestore.Update{Path: u.Path, FieldPath: firestore.FieldPath(u.FieldPath), Value: fsValue(u.Value)}
+	}
+	return out
+}
+
+func fsSetOptions(w *write) []firestore.SetOption {
+	if w.merge {
+		return []firestore.SetOption{firestore.MergeAll}
+	}
+	return nil
+}
+
+func fsPreconditions(w *write) []firestore.Precondition {
+	if w.precond == nil {
+		return nil
+	}
+	return []firestore.Precondition{firestore.LastUpdateTime(w.precond.updateTime)}
+}
+
+func fsWriteResult(res *firestore.WriteResult, err error) (*WriteResult, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &WriteResult{UpdateTime: res.UpdateTime}, nil
+}
+
+func (b *firestoreBackend) getAll(ctx context.Context, refs []*DocumentRef) ([]*DocumentSnapshot, error) {
+	snaps, err := b.fs.GetAll(ctx, b.refs(refs))
+	if err != nil {
+		return nil, err
+	}
+	return b.snapshots(refs, snaps), nil
+}
+
+func (b *firestoreBackend) query(ctx context.Context, q *Query) snapshotStream {
+	return &fsResults{b: b, c: q.c, it: b.buildQuery(q).Documents(ctx)}
+}
+
+func (b *firestoreBackend) aggregate(ctx context.Context, a *AggregationQuery) (AggregationResult, error) {
+	res, err := b.aggregationQuery(a).Get(ctx)
+	return AggregationResult(res), err
+}
+
+func (b *firestoreBackend) commit(ctx context.Context, w *write) (*WriteResult, error) {
+	ref := b.ref(w.ref)
+	switch w.kind {
+	case writeCreate:
+		return fsWriteResult(ref.Create(ctx, w.data))
+	case writeSet:
+		return fsWriteResult(ref.Set(ctx, fsValue(w.data), fsSetOptions(w)...))
+	case writeUpdate:
+		return fsWriteResult(ref.Update(ctx, fsUpdates(w.updates), fsPreconditions(w)...))
+	default:
+		return fsWriteResult(ref.Delete(ctx, fsPreconditions(w)...))
+	}
+}
+
+func (b *firestoreBackend) runTransaction(ctx context.Context, f func(context.Context, *Transaction) error, s transactionSettings) error {
+	opts := []firestore.TransactionOption{firestore.MaxAttempts(s.maxAttempts)}
+	if s.readOnly {
+		opts = append(opts, firestore.ReadOnly)
+	}
+	return b.fs.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		return f(ctx, &Transaction{t: &fsTransaction{b: b, tx: tx}})
+	}, opts...)
+}
+
+// fsTransaction is one attempt of a Firestore transaction.
+type fsTransaction struct {
+	b  *firestoreBackend
+	tx *firestore.Transaction
+}
+
+func (t *fsTransaction) getAll(refs []*DocumentRef) ([]*DocumentSnapshot, error) {
+	snaps, err := t.tx.GetAll(t.b.refs(refs))
+	if err != nil {
+		return nil, err
+	}
+	return t.b.snapshots(refs, snaps), nil
+}
+
+func (t *fsTransaction) query(q *Query) snapshotStream {
+	return &fsResults{b: t.b, c: q.c, it: t.tx.Documents(t.b.buildQuery(q))}
+}
+
+func (t *fsTransaction) aggregate(ctx context.Context, a *AggregationQuery) (AggregationResult, error) {
+	res, err := t.b.aggregationQuery(a).Transaction(t.tx).Get(ctx)
+	return AggregationResult(res), err
+}
+
+func (t *fsTransaction) write(w *write) error {
+	ref := t.b.ref(w.ref)
+	switch w.kind {
+	case writeCreate:
+		return t.tx.Create(ref, w.data)
+	case writeSet:
+		return t.tx.Set(ref, fsValue(w.data), fsSetOptions(w)...)
+	case writeUpdate:
+		return t.tx.Update(ref, fsUpdates(w.updates), fsPreconditions(w)...)
+	default:
+		return t.tx.Delete(ref, fsPreconditions(w)...)
+	}
+}
+
+func (b *firestoreBackend) bulkWriter(ctx context.Context) bulkWriterBackend {
+	return &fsBulkWriter{b: b, bw: b.fs.BulkWriter(ctx)}
+}
+
+// fsBulkWriter queues writes on a Firestore BulkWriter.
+type fsBulkWriter struct {
+	b  *firestoreBackend
+	bw *firestore.BulkWriter
+}
+
+func (bw *fsBulkWriter) write(w *write) (*BulkWriterJob, error) {
+	ref := bw.b.ref(w.ref)
+	var job *firestore.BulkWriterJob
+	var err error
+	switch w.kind {
+	case writeCreate:
+		job, err = bw.bw.Create(ref, w.data)
+	case writeSet:
+		job, err = bw.bw.Set(ref, fsValue(w.data), fsSetOptions(w)...)
+	case writeUpdate:
+		job, err = bw.bw.Update(ref, fsUpdates(w.updates), fsPreconditions(w)...)
+	default:
+		job, err = bw.bw.Delete(ref, fsPreconditions(w)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BulkWriterJob{results: func() (*WriteResult, error) { return fsWriteResult(job.Results()) }}, nil
+}
+
+func (bw *fsBulkWriter) flush() {
+	bw.bw.Flush()
+}
+
+func (bw *fsBulkWriter) end() {
+	bw.bw.End()
+}
+
+func (b *firestoreBackend) listen(ctx context.Context, ref *DocumentRef) snapshotStream {
+	return &fsListener{b: b, ref: ref, it: b.ref(ref).Snapshots(ctx)}
+}
+
+// fsListener relays a Firestore document listener.
+type fsListener struct {
+	b   *firestoreBackend
+	ref *DocumentRef
+	it  *firestore.DocumentSnapshotIterator
+}
+
+func (l *fsListener) next() (*DocumentSnapshot, error) {
+	snap, err := l.it.Next()
+	if err != nil {
+		return nil, err
+	}
+	return l.b.snapshot(l.ref.c, l.ref, snap), nil
+}
+
+func (l *fsListener) stop() {
+	l.it.Stop()
+}
+
+func (b *firestoreBackend) close() error {
+	return b.fs.Close()
+}
+
+// fsResults relays the results of a Firestore query.
+type fsResults struct {
+	b  *firestoreBackend
+	c  *Client
+	it *firestore.DocumentIterator
+}
+
+func (r *fsResults) next() (*DocumentSnapshot, error) {
+	snap, err := r.it.Next()
+	if err != nil {
+		return nil, err
+	}
+	return r.b.snapshot(r.c, nil, snap), nil
+}
+
+func (r *fsResults) stop() {
+	r.it.Stop()
+}
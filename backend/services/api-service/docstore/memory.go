@@ -0,0 +1,930 @@
+package docstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Memory is an in-memory document store for tests. It keeps the Firestore behaviour the
+// service relies on: errors carry the same gRPC codes, preconditions are checked against
+// update times, transactions are retried when another write touched what they read, and
+// queries sort and page like Firestore's. Operations can be made to fail with InjectFault.
+type Memory struct {
+	client *Client
+
+	mu       sync.Mutex
+	docs     map[string]*memDocument
+	versions map[string]uint64 // Document path or collection key → commit that last wrote it
+	commits  uint64
+	lastTime time.Time
+	changed  chan struct{} // Closed and replaced by every commit
+	fault    func(Op) error
+}
+
+// memDocument is a stored document. Its fields are never modified once stored, so
+// snapshots can share them.
+type memDocument struct {
+	fields     map[string]interface{}
+	createTime time.Time
+	updateTime time.Time
+}
+
+// Op is an operation reaching a Memory store; see InjectFault.
+type Op struct {
+	// Kind is "get", "listen", "query", "create", "set", "update" or "delete".
+	Kind string
+	// Path is the document's path, or for a query the collection's path, or its ID for a
+	// collection group.
+	Path string
+}
+
+// NewMemory returns an empty store.
+func NewMemory() *Memory {
+	m := &Memory{
+		docs:     map[string]*memDocument{},
+		versions: map[string]uint64{},
+		changed:  make(chan struct{}),
+	}
+	m.client = &Client{b: &memoryBackend{m: m}}
+	return m
+}
+
+// Client returns the store's client.
+func (m *Memory) Client() *Client {
+	return m.client
+}
+
+// InjectFault fails every operation for which fn returns an error with that error, before
+// the operation reads or writes anything. Writes of a transaction or bulk writer are
+// checked one by one. A nil fn removes the fault.
+func (m *Memory) InjectFault(fn func(Op) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fault = fn
+}
+
+func (m *Memory) checkFault(ctx context.Context, op Op) error {
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	m.mu.Lock()
+	fault := m.fault
+	m.mu.Unlock()
+	if fault == nil {
+		return nil
+	}
+	return fault(op)
+}
+
+// commitTime returns a time later than any earlier commit's. m.mu must be held.
+func (m *Memory) commitTime() time.Time {
+	t := storedTime(time.Now())
+	if !t.After(m.lastTime) {
+		t = m.lastTime.Add(time.Microsecond)
+	}
+	m.lastTime = t
+	return t
+}
+
+// snapshot reads a document. m.mu must be held.
+func (m *Memory) snapshot(ref *DocumentRef) *DocumentSnapshot {
+	snap := &DocumentSnapshot{Ref: ref, ReadTime: storedTime(time.Now())}
+	if doc := m.docs[ref.Path]; doc != nil {
+		snap.CreateTime, snap.UpdateTime, snap.d = doc.createTime, doc.updateTime, memData(doc.fields)
+	}
+	return snap
+}
+
+// applyWrites applies writes atomically, failing them all when one fails. m.mu must be
+// held.
+func (m *Memory) applyWrites(writes []*write) (*WriteResult, error) {
+	t := m.commitTime()
+	staged := map[string]*memDocument{}
+	for _, w := range writes {
+		cur, ok := staged[w.ref.Path]
+		if !ok {
+			cur = m.docs[w.ref.Path]
+		}
+		next, err := applyWrite(cur, w, t)
+		if err != nil {
+			return nil, err
+		}
+		staged[w.ref.Path] = next
+	}
+	m.commits++
+	for path, doc := range staged {
+		if doc == nil {
+			delete(m.docs, path)
+		} else {
+			m.docs[path] = doc
+		}
+		m.versions[path] = m.commits
+		m.versions[collectionKey(path)] = m.commits
+		m.versions[groupKey(path)] = m.commits
+	}
+	close(m.changed)
+	m.changed = make(chan struct{})
+	return &WriteResult{UpdateTime: t}, nil
+}
+
+// collectionKey and groupKey are the versions keys of a document's collection, as read by
+// queries on it and collection group queries on its ID.
+func collectionKey(docPath string) string {
+	return "collection:" + docPath[:strings.LastIndex(docPath, "/")]
+}
+
+func groupKey(docPath string) string {
+	segments := strings.Split(docPath, "/")
+	return "group:" + segments[len(segments)-2]
+}
+
+func queryKey(q *Query) string {
+	if q.allDescendants {
+		return "group:" + q.collectionID
+	}
+	return "collection:" + q.collectionPath()
+}
+
+func applyWrite(cur *memDocument, w *write, t time.Time) (*memDocument, error) {
+	if w.precond != nil && (cur == nil || !cur.updateTime.Equal(w.precond.updateTime)) {
+		return nil, status.Errorf(codes.FailedPrecondition, "document %s was changed or deleted", w.ref.Path)
+	}
+	next := &memDocument{createTime: t, updateTime: t}
+	if cur != nil {
+		next.createTime = cur.createTime
+	}
+	switch w.kind {
+	case writeCreate:
+		if cur != nil {
+			return nil, status.Errorf(codes.AlreadyExists, "document %s already exists", w.ref.Path)
+		}
+		fields, err := encodeWriteData(w.data)
+		if err != nil {
+			return nil, err
+		}
+		next.fields = fields
+	case writeSet:
+		if !w.merge {
+			fields, err := encodeWriteData(w.data)
+			if err != nil {
+				return nil, err
+			}
+			next.fields = fields
+			break
+		}
+		next.fields = map[string]interface{}{}
+		if cur != nil {
+			next.fields = copyFields(cur.fields)
+		}
+		if err := mergeFields(next.fields, reflect.ValueOf(w.data)); err != nil {
+			return nil, err
+		}
+	case writeUpdate:
+		if cur == nil {
+			return nil, status.Errorf(codes.NotFound, "no document to update: %s", w.ref.Path)
+		}
+		next.fields = copyFields(cur.fields)
+		for _, u := range w.updates {
+			if err := setField(next.fields, u.fieldPath(), u.Value); err != nil {
+				return nil, err
+			}
+		}
+	case writeDelete:
+		return nil, nil
+	}
+	return next, nil
+}
+
+// encodeWriteData encodes the data of a Create or a Set without MergeAll. Increment may be
+// a top-level map value, and sets the field to its amount.
+func encodeWriteData(data interface{}) (map[string]interface{}, error) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return encodeData(data)
+	}
+	fields := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if v == Delete {
+			return nil, errors.New("docstore: Delete needs MergeAll")
+		}
+		if err := setField(fields, FieldPath{k}, v); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// mergeFields writes the leaves of data, a map, into fields. Nested maps are merged rather
+// than replaced, as Firestore does for Set with MergeAll.
+func mergeFields(fields map[string]interface{}, data reflect.Value) error {
+	iter := data.MapRange()
+	for iter.Next() {
+		key, val := iter.Key().String(), iter.Value()
+		for val.Kind() == reflect.Interface && !val.IsNil() {
+			val = val.Elem()
+		}
+		if val.Kind() == reflect.Map && !val.IsNil() {
+			if val.Len() == 0 {
+				continue
+			}
+			sub, ok := fields[key].(map[string]interface{})
+			if !ok {
+				sub = map[string]interface{}{}
+			}
+			if err := mergeFields(sub, val); err != nil {
+				return err
+			}
+			fields[key] = sub
+			continue
+		}
+		var v interface{}
+		if val.IsValid() {
+			v = val.Interface()
+		}
+		if err := setField(fields, FieldPath{key}, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setField applies one update to fields: Delete removes the field, Increment adds to it
+// and anything else replaces it. Missing parent maps are created.
+func setField(fields map[string]interface{}, fp FieldPath, value interface{}) error {
+	parent := fields
+	for _, seg := range fp[:len(fp)-1] {
+		next, ok := parent[seg].(map[string]interface{})
+		if !ok {
+			if value == Delete {
+				return nil
+			}
+			next = map[string]interface{}{}
+			parent[seg] = next
+		}
+		parent = next
+	}
+	leaf := fp[len(fp)-1]
+	switch x := value.(type) {
+	case deleteSentinel:
+		delete(parent, leaf)
+		return nil
+	case increment:
+		sum, err := addNumber(parent[leaf], x.n)
+		if err != nil {
+			return err
+		}
+		parent[leaf] = sum
+		return nil
+	}
+	v, err := encodeValue(reflect.ValueOf(value))
+	if err != nil {
+		return err
+	}
+	parent[leaf] = v
+	return nil
+}
+
+// addNumber is the result of incrementing cur by n. A non-numeric cur counts as absent.
+func addNumber(cur, n interface{}) (interface{}, error) {
+	var amount interface{}
+	switch v := reflect.ValueOf(n); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		amount = v.Int()
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		amount = int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		amount = v.Float()
+	default:
+		return nil, fmt.Errorf("docstore: Increment needs a number, got %T", n)
+	}
+	switch c := cur.(type) {
+	case int64:
+		if a, ok := amount.(int64); ok {
+			return c + a, nil
+		}
+		return float64(c) + amount.(float64), nil
+	case float64:
+		return c + toFloat(amount), nil
+	}
+	return amount, nil
+}
+
+// memData is the content of a stored document.
+type memData map[string]interface{}
+
+func (d memData) dataTo(p interface{}) error {
+	return decodeData(p, d)
+}
+
+func (d memData) data() map[string]interface{} {
+	return copyFields(d)
+}
+
+func (d memData) dataAt(path string) (interface{}, error) {
+	v, ok := valueAt(d, strings.Split(path, "."))
+	if !ok {
+		return nil, fmt.Errorf("docstore: no field %q", path)
+	}
+	return copyValue(v), nil
+}
+
+// docName is a document path as compared by queries ordered or filtered by DocumentID.
+type docName string
+
+// compareDocNames orders paths segment by segment.
+func compareDocNames(a, b docName) int {
+	as, bs := strings.Split(string(a), "/"), strings.Split(string(b), "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(as), len(bs))
+}
+
+// runQuery returns a query's results. m.mu must be held.
+func (m *Memory) runQuery(q *Query) ([]*DocumentSnapshot, error) {
+	filters := make([]filter, len(q.filters))
+	for i, f := range q.filters {
+		v, err := queryValue(q, f.path, f.op, f.value)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = filter{path: f.path, op: f.op, value: v}
+	}
+	orders := effectiveOrders(q)
+	cursor, err := m.cursorValues(q, orders)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		path string
+		doc  *memDocument
+		keys []interface{}
+	}
+	var results []result
+	for path, doc := range m.docs {
+		if !inQuery(q, path) || !matchesFilters(path, doc, filters) {
+			continue
+		}
+		keys, ok := orderValues(path, doc.fields, orders)
+		if !ok {
+			continue
+		}
+		if cursor != nil && compareKeys(keys[:len(cursor)], cursor, orders) <= 0 {
+			continue
+		}
+		results = append(results, result{path: path, doc: doc, keys: keys})
+	}
+	slices.SortFunc(results, func(a, b result) int { return compareKeys(a.keys, b.keys, orders) })
+	if q.limited && len(results) > q.limit {
+		results = results[:q.limit]
+	}
+
+	snaps := make([]*DocumentSnapshot, len(results))
+	for i, r := range results {
+		snaps[i] = m.snapshot(m.client.Doc(r.path))
+	}
+	return snaps, nil
+}
+
+// inQuery reports whether the document at path is in the queried collection.
+func inQuery(q *Query, path string) bool {
+	parent := path[:strings.LastIndex(path, "/")]
+	if q.allDescendants {
+		return parent[strings.LastIndex(parent, "/")+1:] == q.collectionID
+	}
+	return parent == q.collectionPath()
+}
+
+// queryValue encodes a filter or cursor value. Values for DocumentID may be document IDs
+// in the queried collection or references.
+func queryValue(q *Query, fp FieldPath, op string, value interface{}) (interface{}, error) {
+	if op == "in" || op == "not-in" || op == "array-contains-any" {
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return nil, fmt.Errorf("docstore: %q needs a slice, got %T", op, value)
+		}
+		vals := make([]interface{}, v.Len())
+		for i := range vals {
+			val, err := queryValue(q, fp, "==", v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = val
+		}
+		return vals, nil
+	}
+	if !isDocumentID(fp) {
+		return encodeValue(reflect.ValueOf(value))
+	}
+	switch x := value.(type) {
+	case *DocumentRef:
+		return docName(x.Path), nil
+	case string:
+		if q.allDescendants || strings.Contains(x, "/") {
+			return nil, fmt.Errorf("docstore: invalid document ID %q for %s", x, q.collectionPath())
+		}
+		return docName(q.collectionPath() + "/" + x), nil
+	}
+	return nil, fmt.Errorf("docstore: a DocumentID value must be a string or *DocumentRef, got %T", value)
+}
+
+func isDocumentID(fp FieldPath) bool {
+	return len(fp) == 1 && fp[0] == DocumentID
+}
+
+// fieldValue returns a document's value at fp, which may be DocumentID.
+func fieldValue(path string, fields map[string]interface{}, fp FieldPath) (interface{}, bool) {
+	if isDocumentID(fp) {
+		return docName(path), true
+	}
+	return valueAt(fields, fp)
+}
+
+func matchesFilters(path string, doc *memDocument, filters []filter) bool {
+	for _, f := range filters {
+		v, ok := fieldValue(path, doc.fields, f.path)
+		if !ok || !matches(v, f.op, f.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func matches(v interface{}, op string, want interface{}) bool {
+	switch op {
+	case "==":
+		return equalValues(v, want)
+	case "!=":
+		return v != nil && !equalValues(v, want)
+	case "in":
+		return slices.ContainsFunc(want.([]interface{}), func(w interface{}) bool { return equalValues(v, w) })
+	case "not-in":
+		return v != nil && !slices.ContainsFunc(want.([]interface{}), func(w interface{}) bool { return equalValues(v, w) })
+	case "array-contains":
+		arr, _ := v.([]interface{})
+		return slices.ContainsFunc(arr, func(e interface{}) bool { return equalValues(e, want) })
+	case "array-contains-any":
+		arr, _ := v.([]interface{})
+		return slices.ContainsFunc(arr, func(e interface{}) bool {
+			return slices.ContainsFunc(want.([]interface{}), func(w interface{}) bool { return equalValues(e, w) })
+		})
+	}
+	// Range filters only match values of the same type.
+	if want == nil || typeOrder(v) != typeOrder(want) {
+		return false
+	}
+	c := compareValues(v, want)
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+func equalValues(a, b interface{}) bool {
+	return typeOrder(a) == typeOrder(b) && compareValues(a, b) == 0
+}
+
+func isInequality(op string) bool {
+	switch op {
+	case "<", "<=", ">", ">=", "!=", "not-in":
+		return true
+	}
+	return false
+}
+
+// effectiveOrders are the orders Firestore sorts a query by: the explicit ones, or else
+// the fields of inequality filters, then the document name in the last order's direction.
+func effectiveOrders(q *Query) []order {
+	orders := slices.Clone(q.orders)
+	hasOrder := func(fp FieldPath) bool {
+		return slices.ContainsFunc(orders, func(o order) bool { return slices.Equal(o.path, fp) })
+	}
+	if len(orders) == 0 {
+		for _, f := range q.filters {
+			if isInequality(f.op) && !hasOrder(f.path) {
+				orders = append(orders, order{path: f.path, dir: Asc})
+			}
+		}
+	}
+	if !hasOrder(FieldPath{DocumentID}) {
+		dir := Asc
+		if len(orders) > 0 {
+			dir = orders[len(orders)-1].dir
+		}
+		orders = append(orders, order{path: FieldPath{DocumentID}, dir: dir})
+	}
+	return orders
+}
+
+// orderValues returns a document's values for orders; Firestore leaves out documents
+// missing any of the fields.
+func orderValues(path string, fields map[string]interface{}, orders []order) ([]interface{}, bool) {
+	keys := make([]interface{}, len(orders))
+	for i, o := range orders {
+		v, ok := fieldValue(path, fields, o.path)
+		if !ok {
+			return nil, false
+		}
+		keys[i] = v
+	}
+	return keys, true
+}
+
+// compareKeys compares order values, the shorter length of the two at most.
+func compareKeys(a, b []interface{}, orders []order) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		c := compareValues(a[i], b[i])
+		if orders[i].dir == Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// cursorValues returns the StartAfter position as order values, or nil without one.
+// m.mu must be held.
+func (m *Memory) cursorValues(q *Query, orders []order) ([]interface{}, error) {
+	if len(q.startAfter) == 0 {
+		return nil, nil
+	}
+	if snap := q.cursorSnapshot(); snap != nil {
+		if !snap.Exists() {
+			return nil, errors.New("docstore: StartAfter needs an existing document")
+		}
+		keys, ok := orderValues(snap.Ref.Path, snap.Data(), orders)
+		if !ok {
+			return nil, errors.New("docstore: the StartAfter document lacks a field the query is ordered by")
+		}
+		return keys, nil
+	}
+	if len(q.startAfter) > len(orders) {
+		return nil, errors.New("docstore: StartAfter has more values than the query has orders")
+	}
+	cursor := make([]interface{}, len(q.startAfter))
+	for i, v := range q.startAfter {
+		val, err := queryValue(q, orders[i].path, "==", v)
+		if err != nil {
+			return nil, err
+		}
+		cursor[i] = val
+	}
+	return cursor, nil
+}
+
+// aggregate computes an aggregation over snaps.
+func aggregate(a *AggregationQuery, snaps []*DocumentSnapshot) AggregationResult {
+	res := AggregationResult{}
+	for _, agg := range a.aggregations {
+		if agg.sum == nil {
+			res[agg.alias] = integerValue(int64(len(snaps)))
+			continue
+		}
+		var sum int64
+		var fsum float64
+		isFloat := false
+		for _, snap := range snaps {
+			v, _ := valueAt(snap.d.(memData), agg.sum)
+			switch x := v.(type) {
+			case int64:
+				if !isFloat && (x > 0 && sum > math.MaxInt64-x || x < 0 && sum < math.MinInt64-x) {
+					isFloat, fsum = true, float64(sum)
+				}
+				if isFloat {
+					fsum += float64(x)
+				} else {
+					sum += x
+				}
+			case float64:
+				if !isFloat {
+					isFloat, fsum = true, float64(sum)
+				}
+				fsum += x
+			}
+		}
+		if isFloat {
+			res[agg.alias] = doubleValue(fsum)
+		} else {
+			res[agg.alias] = integerValue(sum)
+		}
+	}
+	return res
+}
+
+// memoryBackend runs a Client on a Memory store.
+type memoryBackend struct {
+	m *Memory
+}
+
+func (b *memoryBackend) getAll(ctx context.Context, refs []*DocumentRef) ([]*DocumentSnapshot, error) {
+	for _, ref := range refs {
+		if err := b.m.checkFault(ctx, Op{Kind: "get", Path: ref.Path}); err != nil {
+			return nil, err
+		}
+	}
+	b.m.mu.Lock()
+	defer b.m.mu.Unlock()
+	snaps := make([]*DocumentSnapshot, len(refs))
+	for i, ref := range refs {
+		snaps[i] = b.m.snapshot(ref)
+	}
+	return snaps, nil
+}
+
+func (b *memoryBackend) query(ctx context.Context, q *Query) snapshotStream {
+	return &memResults{run: func() ([]*DocumentSnapshot, error) {
+		return b.m.runQueryIn(ctx, nil, q)
+	}}
+}
+
+// runQueryIn runs q, recording it as a read of tx when tx is not nil.
+func (m *Memory) runQueryIn(ctx context.Context, tx *memTransaction, q *Query) ([]*DocumentSnapshot, error) {
+	if tx != nil && len(tx.writes) > 0 {
+		return nil, errReadAfterWrite
+	}
+	path := q.collectionPath()
+	if q.allDescendants {
+		path = q.collectionID
+	}
+	if err := m.checkFault(ctx, Op{Kind: "query", Path: path}); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tx != nil {
+		tx.record(queryKey(q))
+	}
+	return m.runQuery(q)
+}
+
+func (b *memoryBackend) aggregate(ctx context.Context, a *AggregationQuery) (AggregationResult, error) {
+	snaps, err := b.m.runQueryIn(ctx, nil, &a.q)
+	if err != nil {
+		return nil, err
+	}
+	return aggregate(a, snaps), nil
+}
+
+func (b *memoryBackend) commit(ctx context.Context, w *write) (*WriteResult, error) {
+	if err := b.m.checkFault(ctx, Op{Kind: w.kind.String(), Path: w.ref.Path}); err != nil {
+		return nil, err
+	}
+	b.m.mu.Lock()
+	defer b.m.mu.Unlock()
+	return b.m.applyWrites([]*write{w})
+}
+
+// errConflict ends a transaction attempt that read data changed by another write before it
+// committed.
+var errConflict = errors.New("docstore: transaction conflict")
+
+func (b *memoryBackend) runTransaction(ctx context.Context, f func(context.Context, *Transaction) error, s transactionSettings) error {
+	for attempt := 1; ; attempt++ {
+		tx := &memTransaction{m: b.m, ctx: ctx, readOnly: s.readOnly, reads: map[string]uint64{}}
+		err := f(ctx, &Transaction{t: tx})
+		if err == nil {
+			err = tx.commit()
+		} else if tx.stale() {
+			// f may have failed because its reads were inconsistent; Firestore's would not be.
+			err = errConflict
+		}
+		if !errors.Is(err, errConflict) {
+			return err
+		}
+		if attempt >= s.maxAttempts {
+			return status.Errorf(codes.Aborted, "transaction conflicted %d times", attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-time.After(rand.N(time.Duration(attempt) * 2 * time.Millisecond)):
+		}
+	}
+}
+
+// memTransaction is one attempt of a transaction. It remembers the version of everything
+// it read, and commits only if none has changed.
+type memTransaction struct {
+	m        *Memory
+	ctx      context.Context
+	readOnly bool
+	reads    map[string]uint64 // Versions key → version when first read
+	writes   []*write
+}
+
+// record notes a read of key. m.mu must be held.
+func (t *memTransaction) record(key string) {
+	if _, ok := t.reads[key]; !ok {
+		t.reads[key] = t.m.versions[key]
+	}
+}
+
+// stale reports whether anything read has changed since.
+func (t *memTransaction) stale() bool {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	return t.staleLocked()
+}
+
+func (t *memTransaction) staleLocked() bool {
+	for key, version := range t.reads {
+		if t.m.versions[key] != version {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *memTransaction) getAll(refs []*DocumentRef) ([]*DocumentSnapshot, error) {
+	if len(t.writes) > 0 {
+		return nil, errReadAfterWrite
+	}
+	for _, ref := range refs {
+		if err := t.m.checkFault(t.ctx, Op{Kind: "get", Path: ref.Path}); err != nil {
+			return nil, err
+		}
+	}
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	snaps := make([]*DocumentSnapshot, len(refs))
+	for i, ref := range refs {
+		t.record(ref.Path)
+		snaps[i] = t.m.snapshot(ref)
+	}
+	return snaps, nil
+}
+
+func (t *memTransaction) query(q *Query) snapshotStream {
+	return &memResults{run: func() ([]*DocumentSnapshot, error) {
+		return t.m.runQueryIn(t.ctx, t, q)
+	}}
+}
+
+func (t *memTransaction) aggregate(ctx context.Context, a *AggregationQuery) (AggregationResult, error) {
+	snaps, err := t.m.runQueryIn(ctx, t, &a.q)
+	if err != nil {
+		return nil, err
+	}
+	return aggregate(a, snaps), nil
+}
+
+func (t *memTransaction) write(w *write) error {
+	if t.readOnly {
+		return errors.New("docstore: write in a read-only transaction")
+	}
+	t.writes = append(t.writes, w)
+	return nil
+}
+
+func (t *memTransaction) commit() error {
+	if len(t.writes) == 0 {
+		return nil
+	}
+	for _, w := range t.writes {
+		if err := t.m.checkFault(t.ctx, Op{Kind: w.kind.String(), Path: w.ref.Path}); err != nil {
+			return err
+		}
+	}
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	if t.staleLocked() {
+		return errConflict
+	}
+	_, err := t.m.applyWrites(t.writes)
+	return err
+}
+
+func (b *memoryBackend) bulkWriter(ctx context.Context) bulkWriterBackend {
+	return &memBulkWriter{b: b, ctx: ctx}
+}
+
+// memBulkWriter applies each write as it is queued.
+type memBulkWriter struct {
+	b     *memoryBackend
+	ctx   context.Context
+	ended bool
+}
+
+func (bw *memBulkWriter) write(w *write) (*BulkWriterJob, error) {
+	if bw.ended {
+		return nil, errors.New("docstore: BulkWriter has ended")
+	}
+	res, err := bw.b.commit(bw.ctx, w)
+	return &BulkWriterJob{results: func() (*WriteResult, error) { return res, err }}, nil
+}
+
+func (bw *memBulkWriter) flush() {}
+
+func (bw *memBulkWriter) end() {
+	bw.ended = true
+}
+
+func (b *memoryBackend) listen(ctx context.Context, ref *DocumentRef) snapshotStream {
+	return &memListener{m: b.m, ctx: ctx, ref: ref, stopped: make(chan struct{})}
+}
+
+// memListener returns a document's state whenever a commit changed it.
+type memListener struct {
+	m        *Memory
+	ctx      context.Context
+	ref      *DocumentRef
+	stopped  chan struct{}
+	stopOnce sync.Once
+	started  bool
+	seen     uint64
+}
+
+func (l *memListener) next() (*DocumentSnapshot, error) {
+	if !l.started {
+		if err := l.m.checkFault(l.ctx, Op{Kind: "listen", Path: l.ref.Path}); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		select {
+		case <-l.stopped:
+			return nil, iterator.Done
+		default:
+		}
+		if err := l.ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		l.m.mu.Lock()
+		version, changed := l.m.versions[l.ref.Path], l.m.changed
+		if !l.started || version != l.seen {
+			l.started, l.seen = true, version
+			snap := l.m.snapshot(l.ref)
+			l.m.mu.Unlock()
+			return snap, nil
+		}
+		l.m.mu.Unlock()
+		select {
+		case <-changed:
+		case <-l.stopped:
+		case <-l.ctx.Done():
+		}
+	}
+}
+
+func (l *memListener) stop() {
+	l.stopOnce.Do(func() { close(l.stopped) })
+}
+
+func (b *memoryBackend) close() error {
+	return nil
+}
+
+// memResults runs a query on the first call to next.
+type memResults struct {
+	run   func() ([]*DocumentSnapshot, error)
+	snaps []*DocumentSnapshot
+	ran   bool
+	err   error
+}
+
+func (r *memResults) next() (*DocumentSnapshot, error) {
+	if !r.ran {
+		r.ran = true
+		r.snaps, r.err = r.run()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.snaps) == 0 {
+		return nil, iterator.Done
+	}
+	snap := r.snaps[0]
+	r.snaps = r.snaps[1:]
+	return snap, nil
+}
+
+func (r *memResults) stop() {
+	r.ran, r.snaps = true, nil
+	if r.err == nil {
+		r.err = iterator.Done
+	}
+}
@@ -0,0 +1,386 @@
+package docstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testAudit struct {
+	CreatedBy string `firestore:"created_by"`
+}
+
+type testDoc struct {
+	Name    string            `firestore:"name"`
+	Size    int64             `firestore:"size,omitempty"`
+	Ratio   float64           `firestore:"ratio,omitempty"`
+	Tags    []string          `firestore:"tags"`
+	Labels  map[string]string `firestore:"labels,omitempty"`
+	At      time.Time         `firestore:"at,omitempty"`
+	Secret  string            `firestore:"-"`
+	Untaged string
+	testAudit
+}
+
+func TestMemory_RoundTripsStructs(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Client()
+	at := time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.FixedZone("x", 3600))
+	ref := c.Collection("docs").Doc("a")
+	_, err := ref.Set(ctx, testDoc{Name: "a", Size: 3, Labels: map[string]string{"k": "v"}, At: at, Secret: "s", Untaged: "u", testAudit: testAudit{CreatedBy: "me"}})
+	assert.NoError(t, err)
+
+	snap, err := ref.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":       "a",
+		"size":       int64(3),
+		"tags":       nil,
+		"labels":     map[string]interface{}{"k": "v"},
+		"at":         at.UTC().Truncate(time.Microsecond),
+		"Untaged":    "u",
+		"created_by": "me",
+	}, snap.Data())
+
+	var got testDoc
+	assert.NoError(t, snap.DataTo(&got))
+	assert.Equal(t, testDoc{Name: "a", Size: 3, Labels: map[string]string{"k": "v"}, At: at.UTC().Truncate(time.Microsecond), Untaged: "u", testAudit: testAudit{CreatedBy: "me"}}, got)
+	size, err := snap.DataAt("size")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+	label, err := snap.DataAt("labels.k")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", label)
+	_, err = snap.DataAt("missing")
+	assert.Error(t, err)
+}
+
+func TestMemory_WriteErrors(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Client()
+	ref := c.Doc("docs/a")
+
+	snap, err := ref.Get(ctx)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.False(t, snap.Exists())
+	assert.Equal(t, codes.NotFound, status.Code(snap.DataTo(&testDoc{})))
+
+	_, err = ref.Update(ctx, []Update{{Path: "name", Value: "b"}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = ref.Create(ctx, testDoc{Name: "a"})
+	assert.NoError(t, err)
+	_, err = ref.Create(ctx, testDoc{Name: "a"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	snap, err = ref.Get(ctx)
+	assert.NoError(t, err)
+	_, err = ref.Update(ctx, []Update{{Path: "name", Value: "b"}}, LastUpdateTime(snap.UpdateTime))
+	assert.NoError(t, err)
+	_, err = ref.Delete(ctx, LastUpdateTime(snap.UpdateTime))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "the document changed since it was read")
+	_, err = ref.Delete(ctx)
+	assert.NoError(t, err)
+	_, err = ref.Delete(ctx)
+	assert.NoError(t, err, "deleting a missing document succeeds")
+}
+
+func TestMemory_UpdatesAndMerges(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Client()
+	ref := c.Doc("docs/a")
+	_, err := ref.Set(ctx, map[string]interface{}{"count": 1, "ratio": 0.5, "keep": true, "gone": "x"})
+	assert.NoError(t, err)
+
+	_, err = ref.Update(ctx, []Update{
+		{Path: "count", Value: Increment(2)},
+		{Path: "ratio", Value: Increment(1)},
+		{Path: "fresh", Value: Increment(int64(4))},
+		{Path: "gone", Value: Delete},
+		{Path: "nested.deep", Value: "v"},
+		{FieldPath: FieldPath{"dotted.key"}, Value: 1},
+	})
+	assert.NoError(t, err)
+	_, err = ref.Set(ctx, map[string]interface{}{
+		"nested": map[string]interface{}{"other": "w"},
+		"keep":   Delete,
+		"total":  Increment(5),
+	}, MergeAll)
+	assert.NoError(t, err)
+
+	snap, err := ref.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"count":      int64(3),
+		"ratio":      1.5,
+		"fresh":      int64(4),
+		"nested":     map[string]interface{}{"deep": "v", "other": "w"},
+		"dotted.key": int64(1),
+		"total":      int64(5),
+	}, snap.Data())
+
+	_, err = ref.Set(ctx, map[string]interface{}{"gone": Delete})
+	assert.Error(t, err, "Delete needs MergeAll")
+	_, err = ref.Set(ctx, testDoc{Name: "replaced"})
+	assert.NoError(t, err)
+	snap, err = ref.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "replaced", "tags": nil, "Untaged": "", "created_by": ""}, snap.Data())
+}
+
+func seedJobs(t *testing.T, c *Client) {
+	t.Helper()
+	for i, status := range []string{"queued", "running", "completed", "queued", "failed"} {
+		data := map[string]interface{}{"status": status, "n": i, "size": int64(10 * i)}
+		if i == 4 {
+			delete(data, "n")
+		}
+		_, err := c.Collection("jobs").Doc(fmt.Sprintf("job%d", i)).Set(context.Background(), data)
+		assert.NoError(t, err)
+	}
+	_, err := c.Collection("workspaces/w1/jobs").Doc("nested").Set(context.Background(), map[string]interface{}{"status": "queued", "n": 9})
+	assert.NoError(t, err)
+}
+
+func ids(t *testing.T, it *DocumentIterator) []string {
+	t.Helper()
+	snaps, err := it.GetAll()
+	assert.NoError(t, err)
+	out := []string{}
+	for _, snap := range snaps {
+		out = append(out, snap.Ref.ID)
+	}
+	return out
+}
+
+func TestMemory_Queries(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Client()
+	seedJobs(t, c)
+	jobs := c.Collection("jobs")
+
+	assert.Equal(t, []string{"job0", "job3"}, ids(t, jobs.Where("status", "==", "queued").Documents(ctx)))
+	assert.Equal(t, []string{"job0", "job1", "job3"}, ids(t, jobs.Where("status", "in", []string{"queued", "running"}).Documents(ctx)))
+	assert.Equal(t, []string{"job3", "job2"}, ids(t, jobs.Where("n", ">=", 2).OrderBy("n", Desc).Documents(ctx)))
+	assert.Equal(t, []string{"job1", "job2"}, ids(t, jobs.Where("n", "<", 3).Where("n", ">", 0.5).Documents(ctx)), "ints compare with doubles")
+	assert.Equal(t, []string{}, ids(t, jobs.Where("status", ">", 1).Documents(ctx)), "range filters only match their type")
+	assert.Equal(t, []string{"job0", "job1", "job2", "job3"}, ids(t, jobs.OrderBy("n", Asc).Documents(ctx)), "documents without n are left out")
+	assert.Equal(t, []string{"job4", "job3"}, ids(t, jobs.OrderBy(DocumentID, Desc).Limit(2).Documents(ctx)))
+	assert.Equal(t, []string{"job0", "job3", "nested"}, ids(t, c.CollectionGroup("jobs").Where("status", "==", "queued").OrderBy("n", Asc).Documents(ctx)))
+
+	// Paging with values, with a document ID for the name order, and with a snapshot.
+	byStatus := jobs.OrderBy("status", Asc).OrderBy(DocumentID, Asc)
+	assert.Equal(t, []string{"job3", "job1"}, ids(t, byStatus.StartAfter("queued", "job0").Documents(ctx)))
+	assert.Equal(t, []string{"job1"}, ids(t, byStatus.StartAfter("queued").Documents(ctx)))
+	first, err := jobs.OrderBy("size", Asc).Limit(2).Documents(ctx).GetAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"job2", "job3"}, ids(t, jobs.OrderBy("size", Asc).StartAfter(first[1]).Limit(2).Documents(ctx)))
+
+	it := jobs.Documents(ctx)
+	_, err = it.Next()
+	assert.NoError(t, err)
+	it.Stop()
+	_, err = it.Next()
+	assert.ErrorIs(t, err, iterator.Done)
+	_, err = jobs.Where("status", "like", "q").Documents(ctx).GetAll()
+	assert.Error(t, err)
+}
+
+func TestMemory_Aggregations(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Client()
+	seedJobs(t, c)
+
+	res, err := c.Collection("jobs").Where("size", ">", 0).NewAggregationQuery().WithCount("count").WithSum("size", "bytes").Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), res["count"].(*firestorepb.Value).GetIntegerValue())
+	assert.Equal(t, int64(100), res["bytes"].(*firestorepb.Value).GetIntegerValue())
+
+	_, err = c.Doc("jobs/job0").Update(ctx, []Update{{Path: "size", Value: 0.5}})
+	assert.NoError(t, err)
+	res, err = c.Collection("jobs").NewAggregationQuery().WithSum("size", "bytes").Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 100.5, res["bytes"].(*firestorepb.Value).GetDoubleValue())
+}
+
+func TestMemory_TransactionsSerializeConflictingWrites(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Client()
+	ref := c.Doc("counters/c")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.RunTransaction(ctx, func(ctx context.Context, tx *Transaction) error {
+				var count struct {
+					N int `firestore:"n"`
+				}
+				snap, err := tx.Get(ref)
+				if err == nil {
+					err = snap.DataTo(&count)
+				} else if status.Code(err) == codes.NotFound {
+					err = nil
+				}
+				if err != nil {
+					return err
+				}
+				count.N++
+				return tx.Set(ref, count)
+			}, MaxAttempts(100))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	snap, err := ref.Get(ctx)
+	assert.NoError(t, err)
+	n, _ := snap.DataAt("n")
+	assert.Equal(t, int64(20), n)
+}
+
+func TestMemory_TransactionErrors(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Client()
+	ref := c.Doc("docs/a")
+
+	errStop := errors.New("stop")
+	err := c.RunTransaction(ctx, func(ctx context.Context, tx *Transaction) error {
+		if err := tx.Create(ref, map[string]interface{}{"n": 1}); err != nil {
+			return err
+		}
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	_, err = ref.Get(ctx)
+	assert.Equal(t, codes.NotFound, status.Code(err), "a failed transaction writes nothing")
+
+	err = c.RunTransaction(ctx, func(ctx context.Context, tx *Transaction) error {
+		if err := tx.Create(ref, map[string]interface{}{"n": 1}); err != nil {
+			return err
+		}
+		_, err := tx.Get(ref)
+		return err
+	})
+	assert.ErrorIs(t, err, errReadAfterWrite)
+
+	err = c.RunTransaction(ctx, func(ctx context.Context, tx *Transaction) error {
+		return tx.Create(ref, map[string]interface{}{"n": 1})
+	}, ReadOnly)
+	assert.Error(t, err)
+
+	// A transaction whose reads keep changing gives up after MaxAttempts.
+	attempts := 0
+	err = c.RunTransaction(ctx, func(ctx context.Context, tx *Transaction) error {
+		attempts++
+		if _, err := tx.Get(ref); err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if _, err := ref.Set(ctx, map[string]interface{}{"n": attempts}); err != nil {
+			return err
+		}
+		return tx.Set(ref, map[string]interface{}{"n": 0})
+	}, MaxAttempts(3))
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, 3, attempts)
+}
+
+func TestMemory_InjectFault(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	c := m.Client()
+	ref := c.Doc("docs/a")
+	_, err := ref.Set(ctx, map[string]interface{}{"n": 1})
+	assert.NoError(t, err)
+
+	unavailable := status.Error(codes.Unavailable, "injected")
+	var seen []Op
+	m.InjectFault(func(op Op) error {
+		seen = append(seen, op)
+		if op.Kind == "update" {
+			return unavailable
+		}
+		return nil
+	})
+	_, err = ref.Update(ctx, []Update{{Path: "n", Value: 2}})
+	assert.ErrorIs(t, err, unavailable)
+	err = c.RunTransaction(ctx, func(ctx context.Context, tx *Transaction) error {
+		if _, err := tx.Get(ref); err != nil {
+			return err
+		}
+		return tx.Update(ref, []Update{{Path: "n", Value: 3}})
+	})
+	assert.ErrorIs(t, err, unavailable)
+	_, err = c.Collection("docs").Documents(ctx).GetAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []Op{
+		{Kind: "update", Path: "docs/a"},
+		{Kind: "get", Path: "docs/a"},
+		{Kind: "update", Path: "docs/a"},
+		{Kind: "query", Path: "docs"},
+	}, seen)
+
+	m.InjectFault(nil)
+	snap, err := ref.Get(ctx)
+	assert.NoError(t, err)
+	n, _ := snap.DataAt("n")
+	assert.Equal(t, int64(1), n, "failed writes change nothing")
+}
+
+func TestMemory_BulkWriter(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory().Client()
+	_, err := c.Doc("docs/a").Set(ctx, map[string]interface{}{"n": 1})
+	assert.NoError(t, err)
+
+	bw := c.BulkWriter(ctx)
+	create, err := bw.Create(c.Doc("docs/a"), map[string]interface{}{"n": 2})
+	assert.NoError(t, err)
+	del, err := bw.Delete(c.Doc("docs/b"))
+	assert.NoError(t, err)
+	bw.End()
+	_, err = create.Results()
+	assert.Equal(t, codes.AlreadyExists, status.Code(err), "writes fail independently")
+	_, err = del.Results()
+	assert.NoError(t, err)
+	_, err = bw.Set(c.Doc("docs/c"), map[string]interface{}{})
+	assert.Error(t, err, "the writer has ended")
+}
+
+func TestMemory_Snapshots(t *testing.T) {
+	c := NewMemory().Client()
+	ctx, cancel := context.WithCancel(context.Background())
+	ref := c.Doc("jobs/a")
+	it := ref.Snapshots(ctx)
+	defer it.Stop()
+
+	snap, err := it.Next()
+	assert.NoError(t, err)
+	assert.False(t, snap.Exists())
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = c.Doc("jobs/other").Set(context.Background(), map[string]interface{}{"status": "queued"})
+		_, _ = ref.Set(context.Background(), map[string]interface{}{"status": "running"})
+	}()
+	snap, err = it.Next()
+	assert.NoError(t, err)
+	got, _ := snap.DataAt("status")
+	assert.Equal(t, "running", got, "changes to other documents are not reported")
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err = it.Next()
+	assert.Equal(t, codes.Canceled, status.Code(err))
+}
@@ -0,0 +1,196 @@
+package docstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+)
+
+// Direction is the sort order of Query.OrderBy.
+type Direction int32
+
+const (
+	Asc Direction = iota + 1
+	Desc
+)
+
+// Query selects documents from a collection. Its methods return modified copies.
+type Query struct {
+	c              *Client
+	parentPath     string // Document holding the collection; empty for root collections
+	collectionID   string
+	allDescendants bool // A collection group query
+	filters        []filter
+	orders         []order
+	limit          int
+	limited        bool
+	startAfter     []interface{} // Field values, or a single *DocumentSnapshot
+	err            error
+}
+
+// filter is one Where condition.
+type filter struct {
+	path  FieldPath
+	op    string
+	value interface{}
+}
+
+// order is one OrderBy clause.
+type order struct {
+	path FieldPath
+	dir  Direction
+}
+
+// filterOps are the operators Where accepts.
+var filterOps = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"in": true, "not-in": true, "array-contains": true, "array-contains-any": true,
+}
+
+// Queryer is a query or collection, as run by Transaction.Documents.
+type Queryer interface {
+	query() *Query
+}
+
+func (q Query) query() *Query { return &q }
+
+// collectionPath is the path of the queried collection; for a collection group, its ID.
+func (q *Query) collectionPath() string {
+	if q.parentPath == "" {
+		return q.collectionID
+	}
+	return q.parentPath + "/" + q.collectionID
+}
+
+// Where keeps documents whose field at the dot-separated path compares to value with op.
+func (q Query) Where(path, op string, value interface{}) Query {
+	return q.WherePath(strings.Split(path, "."), op, value)
+}
+
+// WherePath is Where with a field path given segment by segment.
+func (q Query) WherePath(fp FieldPath, op string, value interface{}) Query {
+	if !filterOps[op] {
+		q.err = fmt.Errorf("docstore: invalid filter operator %q", op)
+		return q
+	}
+	q.filters = append(append([]filter(nil), q.filters...), filter{path: fp, op: op, value: value})
+	return q
+}
+
+// OrderBy sorts by the field at the dot-separated path, or by DocumentID.
+func (q Query) OrderBy(path string, dir Direction) Query {
+	return q.OrderByPath(strings.Split(path, "."), dir)
+}
+
+// OrderByPath is OrderBy with a field path given segment by segment.
+func (q Query) OrderByPath(fp FieldPath, dir Direction) Query {
+	if dir != Asc && dir != Desc {
+		q.err = fmt.Errorf("docstore: invalid direction %d", dir)
+		return q
+	}
+	q.orders = append(append([]order(nil), q.orders...), order{path: fp, dir: dir})
+	return q
+}
+
+// Limit returns at most n documents.
+func (q Query) Limit(n int) Query {
+	q.limit, q.limited = n, true
+	return q
+}
+
+// StartAfter resumes after a position given as values for the query's orders, in order, or
+// as a document snapshot from an earlier page. A value for DocumentID may be a document ID.
+func (q Query) StartAfter(docSnapshotOrFieldValues ...interface{}) Query {
+	if len(docSnapshotOrFieldValues) == 0 {
+		q.err = errors.New("docstore: StartAfter needs a snapshot or field values")
+		return q
+	}
+	if _, ok := docSnapshotOrFieldValues[0].(*DocumentSnapshot); ok && len(docSnapshotOrFieldValues) > 1 {
+		q.err = errors.New("docstore: StartAfter takes a snapshot or field values, not both")
+		return q
+	}
+	q.startAfter = docSnapshotOrFieldValues
+	return q
+}
+
+// cursorSnapshot returns the snapshot StartAfter was given, if any.
+func (q *Query) cursorSnapshot() *DocumentSnapshot {
+	if len(q.startAfter) != 1 {
+		return nil
+	}
+	snap, _ := q.startAfter[0].(*DocumentSnapshot)
+	return snap
+}
+
+// Documents runs the query.
+func (q Query) Documents(ctx context.Context) *DocumentIterator {
+	if q.err != nil {
+		return &DocumentIterator{err: q.err}
+	}
+	return &DocumentIterator{s: q.c.b.query(ctx, &q)}
+}
+
+// NewAggregationQuery returns an aggregation over the query's documents.
+func (q Query) NewAggregationQuery() *AggregationQuery {
+	return &AggregationQuery{q: q}
+}
+
+// AggregationResult maps each aggregation's alias to its value, a *firestorepb.Value as with
+// the Firestore client.
+type AggregationResult map[string]interface{}
+
+// aggregation is one WithCount or WithSum.
+type aggregation struct {
+	alias string
+	sum   FieldPath // nil for a count
+}
+
+// AggregationQuery counts or sums a query's documents on the server.
+type AggregationQuery struct {
+	q            Query
+	aggregations []aggregation
+	tx           *Transaction
+}
+
+// WithCount adds a count of the documents under alias.
+func (a *AggregationQuery) WithCount(alias string) *AggregationQuery {
+	a.aggregations = append(a.aggregations, aggregation{alias: alias})
+	return a
+}
+
+// WithSum adds the sum of the numeric field at the dot-separated path under alias.
+func (a *AggregationQuery) WithSum(path, alias string) *AggregationQuery {
+	a.aggregations = append(a.aggregations, aggregation{alias: alias, sum: strings.Split(path, ".")})
+	return a
+}
+
+// Transaction runs the aggregation in tx.
+func (a *AggregationQuery) Transaction(tx *Transaction) *AggregationQuery {
+	a.tx = tx
+	return a
+}
+
+// Get runs the aggregation.
+func (a *AggregationQuery) Get(ctx context.Context) (AggregationResult, error) {
+	if a.q.err != nil {
+		return nil, a.q.err
+	}
+	if len(a.aggregations) == 0 {
+		return nil, errors.New("docstore: no aggregations")
+	}
+	if a.tx != nil {
+		return a.tx.t.aggregate(ctx, a)
+	}
+	return a.q.c.b.aggregate(ctx, a)
+}
+
+func integerValue(n int64) *firestorepb.Value {
+	return &firestorepb.Value{ValueType: &firestorepb.Value_IntegerValue{IntegerValue: n}}
+}
+
+func doubleValue(f float64) *firestorepb.Value {
+	return &firestorepb.Value{ValueType: &firestorepb.Value_DoubleValue{DoubleValue: f}}
+}
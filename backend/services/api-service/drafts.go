@@ -0,0 +1,624 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/1liale/api-service/docstore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// draftsCollection holds editor autosave drafts, one per user and file path.
+const draftsCollection = "file_drafts"
+
+const (
+	// draftRouteSuffix ends the /files/*path routes that address a file's draft.
+	draftRouteSuffix = "/draft"
+	// draftDeleteGrace is how long an expired draft's document outlives expires_at, so the
+	// draft-expiry task can delete its object before Firestore TTL reaps the document.
+	draftDeleteGrace = 24 * time.Hour
+	// draftExpiryBatch bounds how many expired drafts one draft-expiry run removes.
+	draftExpiryBatch = 200
+)
+
+var (
+	errDraftNotFound = errors.New("draft not found")
+	errDraftConflict = errors.New("file changed since the draft was started")
+	errDraftIsFolder = errors.New("path is a folder")
+)
+
+// draftFilePath extracts the workspace file path from a /files/*path parameter ending in
+// /draft. Paths are relative ("src/main.py") and must already be clean.
+func draftFilePath(param string) (string, bool) {
+	p, ok := strings.CutSuffix(param, draftRouteSuffix)
+	if !ok {
+		return "", false
+	}
+	return validEntryPath(strings.TrimPrefix(p, "/"))
+}
+
+// validEntryPath reports whether p names a file or folder inside the workspace.
+func validEntryPath(p string) (string, bool) {
+	if p == "" || path.Clean(p) != p || path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+	return p, true
+}
+
+// draftDocID returns the file_drafts document ID for a user's draft of a path.
+func draftDocID(workspaceID, userID, filePath string) string {
+	sum := sha256.Sum256([]byte(workspaceID + "\x00" + userID + "\x00" + filePath))
+	return hex.EncodeToString(sum[:])
+}
+
+// draftObjectKey returns a new R2 key for a draft. It sits under the workspace prefix, so a
+// workspace purge removes it, but outside files/, so sync commits cannot reference it.
+func draftObjectKey(workspaceID, userID string) string {
+	return fmt.Sprintf("workspaces/%s/drafts/%s/%s", workspaceID, userID, uuid.New().String())
+}
+
+// draftExpired reports whether a draft's expires_at has passed. Unparseable values count
+// as expired.
+func draftExpired(d FileDraft, now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, d.ExpiresAt)
+	return err != nil || !now.Before(t)
+}
+
+// draftConflict reports whether the file has changed since the draft was started: its hash
+// differs from the draft's base, or it was deleted. Drafts without a base never conflict.
+func draftConflict(d FileDraft, current *FileMetadata) bool {
+	if d.BaseHash == "" {
+		return false
+	}
+	return current == nil || current.Hash != d.BaseHash
+}
+
+// requireDraftMember checks that the user belongs to the workspace and, for writes, is not a
+// viewer. It writes the error response and returns false otherwise.
+func (ac *ApiController) requireDraftMember(c *gin.Context, workspaceID, userID string, write bool, logCtx *log.Entry) bool {
+	required := roleViewer
+	if write {
+		required = roleEditor
+	}
+	return ac.requireWorkspaceRole(c, workspaceID, userID, required, logCtx) != nil
+}
+
+// loadDraft returns the user's live draft of a path with its snapshot, or errDraftNotFound.
+// An expired draft whose document still exists is returned alongside errDraftNotFound.
+func (ac *ApiController) loadDraft(ctx context.Context, workspaceID, userID, filePath string) (FileDraft, *docstore.DocumentSnapshot, error) {
+	snap, err := ac.FirestoreClient.Collection(draftsCollection).Doc(draftDocID(workspaceID, userID, filePath)).Get(ctx)
+	if isNotFound(err) {
+		return FileDraft{}, nil, errDraftNotFound
+	}
+	if err != nil {
+		return FileDraft{}, nil, err
+	}
+	var draft FileDraft
+	if err := snap.DataTo(&draft); err != nil {
+		return FileDraft{}, nil, fmt.Errorf("failed to parse draft: %w", err)
+	}
+	if draftExpired(draft, time.Now()) {
+		return draft, nil, errDraftNotFound
+	}
+	return draft, snap, nil
+}
+
+// countLiveDrafts counts the user's unexpired drafts in a workspace.
+func (ac *ApiController) countLiveDrafts(ctx context.Context, workspaceID, userID string) (int64, error) {
+	res, err := ac.FirestoreClient.Collection(draftsCollection).
+		Where("workspace_id", "==", workspaceID).
+		Where("user_id", "==", userID).
+		Where("expires_at", ">", NowISO8601()).
+		NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	v, _ := res["count"].(*firestorepb.Value)
+	return v.GetIntegerValue(), nil
+}
+
+// withDraftContentURLs presigns a content URL for each draft. Drafts whose URL cannot be
+// signed are returned without one.
+func (ac *ApiController) withDraftContentURLs(ctx context.Context, drafts []FileDraft) []FileDraft {
+	keys := make([]string, len(drafts))
+	for i, d := range drafts {
+		keys[i] = d.R2ObjectKey
+	}
+	for i, res := range ac.presignGetURLs(ctx, keys) {
+		if res.Err != nil {
+			log.WithError(res.Err).WithField("file_path", drafts[i].FilePath).Warn("Failed to presign draft content URL.")
+			continue
+		}
+		drafts[i].ContentURL = res.URL
+	}
+	return drafts
+}
+
+// discardDraftObjects deletes draft objects, queueing failures for the r2-deletions task.
+func (ac *ApiController) discardDraftObjects(ctx context.Context, workspaceID string, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	if failures := ac.deleteR2Objects(ctx, keys); len(failures) > 0 {
+		ac.recordFailedR2Deletions(ctx, workspaceID, failures)
+	}
+}
+
+// draftRouteNotFound answers /files/*path requests that do not address a draft.
+func draftRouteNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+}
+
+// SaveDraft handles PATCH /workspaces/:workspaceId/files/*path/draft. The editor calls it on
+// autosave; the content is stored in R2 under the user's drafts and the workspace version
+// is left alone. Editors and owners only.
+func (ac *ApiController) SaveDraft(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	filePath, ok := draftFilePath(c.Param("path"))
+	if !ok {
+		draftRouteNotFound(c)
+		return
+	}
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "file_path": filePath, "handler": "SaveDraft"})
+
+	if !ac.requireDraftMember(c, workspaceID, userID, true, logCtx) {
+		return
+	}
+	var req SaveDraftRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	if len(req.Content) > ac.AppConfig.DraftMaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Draft exceeds %d bytes", ac.AppConfig.DraftMaxBytes)})
+		return
+	}
+
+	existing, _, err := ac.loadDraft(ctx, workspaceID, userID, filePath)
+	live := err == nil
+	switch {
+	case errors.Is(err, errDraftNotFound):
+		count, err := ac.countLiveDrafts(ctx, workspaceID, userID)
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to count drafts.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft"})
+			return
+		}
+		if count >= int64(ac.AppConfig.DraftMaxPerUser) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": fmt.Sprintf("You already have %d drafts in this workspace; save or discard one first", count),
+				"code":  "draft_limit_reached",
+			})
+			return
+		}
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to load draft.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft"})
+		return
+	}
+
+	now := time.Now()
+	draft := FileDraft{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+		FilePath:    filePath,
+		R2ObjectKey: existing.R2ObjectKey,
+		Size:        int64(len(req.Content)),
+		BaseHash:    req.BaseHash,
+		CreatedAt:   existing.CreatedAt,
+		UpdatedAt:   TimeToISO8601(now),
+		ExpiresAt:   TimeToISO8601(now.Add(ac.AppConfig.DraftTTL)),
+		DeleteAfter: now.Add(ac.AppConfig.DraftTTL + draftDeleteGrace).UTC(),
+	}
+	if live && draft.BaseHash == "" {
+		draft.BaseHash = existing.BaseHash
+	}
+	// A fresh draft gets its own object: an expired one's may be deleted by draft-expiry.
+	if !live {
+		draft.R2ObjectKey = draftObjectKey(workspaceID, userID)
+		draft.CreatedAt = draft.UpdatedAt
+	}
+
+	if _, err := ac.r2().S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(ac.R2BucketName),
+		Key:           aws.String(draft.R2ObjectKey),
+		Body:          strings.NewReader(req.Content),
+		ContentLength: aws.Int64(draft.Size),
+	}); err != nil {
+		logCtx.WithError(err).Error("Failed to upload draft content.")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to store draft content"})
+		return
+	}
+	if _, err := ac.FirestoreClient.Collection(draftsCollection).Doc(draftDocID(workspaceID, userID, filePath)).Set(ctx, draft); err != nil {
+		logCtx.WithError(err).Error("Failed to write draft.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft"})
+		return
+	}
+	if !live && existing.R2ObjectKey != "" {
+		ac.discardDraftObjects(ctx, workspaceID, []string{existing.R2ObjectKey})
+	}
+	c.JSON(http.StatusOK, draft)
+}
+
+// GetDraft handles GET /workspaces/:workspaceId/files/*path/draft, returning the user's draft
+// of the file with a URL for its content, so an editor can recover it after a crash.
+func (ac *ApiController) GetDraft(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	filePath, ok := draftFilePath(c.Param("path"))
+	if !ok {
+		draftRouteNotFound(c)
+		return
+	}
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "file_path": filePath, "handler": "GetDraft"})
+
+	if !ac.requireDraftMember(c, workspaceID, userID, false, logCtx) {
+		return
+	}
+	draft, _, err := ac.loadDraft(ctx, workspaceID, userID, filePath)
+	if errors.Is(err, errDraftNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No draft for this file"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load draft.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load draft"})
+		return
+	}
+	c.JSON(http.StatusOK, ac.withDraftContentURLs(ctx, []FileDraft{draft})[0])
+}
+
+// ListDrafts handles GET /workspaces/:workspaceId/drafts, returning all of the user's live
+// drafts in the workspace, most recently saved first.
+func (ac *ApiController) ListDrafts(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "ListDrafts"})
+
+	if !ac.requireDraftMember(c, workspaceID, userID, false, logCtx) {
+		return
+	}
+	docs, err := ac.FirestoreClient.Collection(draftsCollection).
+		Where("workspace_id", "==", workspaceID).
+		Where("user_id", "==", userID).
+		Where("expires_at", ">", NowISO8601()).
+		OrderBy("expires_at", docstore.Desc).
+		Limit(ac.AppConfig.DraftMaxPerUser).
+		Documents(ctx).GetAll()
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to list drafts.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list drafts"})
+		return
+	}
+	drafts := make([]FileDraft, 0, len(docs))
+	for _, doc := range docs {
+		var draft FileDraft
+		if err := doc.DataTo(&draft); err != nil {
+			logCtx.WithError(err).WithField("draft_id", doc.Ref.ID).Warn("Skipping unparseable draft.")
+			continue
+		}
+		drafts = append(drafts, draft)
+	}
+	c.JSON(http.StatusOK, ListDraftsResponse{Drafts: ac.withDraftContentURLs(ctx, drafts)})
+}
+
+// DiscardDraft handles DELETE /workspaces/:workspaceId/files/*path/draft, e.g. once the file
+// has been saved through sync. Discarding a missing draft succeeds.
+func (ac *ApiController) DiscardDraft(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	filePath, ok := draftFilePath(c.Param("path"))
+	if !ok {
+		draftRouteNotFound(c)
+		return
+	}
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "file_path": filePath, "handler": "DiscardDraft"})
+
+	if !ac.requireDraftMember(c, workspaceID, userID, false, logCtx) {
+		return
+	}
+	draft, snap, err := ac.loadDraft(ctx, workspaceID, userID, filePath)
+	if errors.Is(err, errDraftNotFound) {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if err == nil {
+		_, err = snap.Ref.Delete(ctx)
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to discard draft.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to discard draft"})
+		return
+	}
+	ac.discardDraftObjects(ctx, workspaceID, []string{draft.R2ObjectKey})
+	c.Status(http.StatusNoContent)
+}
+
+// readDraftContent downloads a draft's content, refusing anything over maxBytes.
+func (ac *ApiController) readDraftContent(ctx context.Context, key string, maxBytes int) ([]byte, error) {
+	out, err := ac.r2().S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ac.R2BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download draft: %w", err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(out.Body, int64(maxBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read draft: %w", err)
+	}
+	if len(data) > maxBytes {
+		return nil, fmt.Errorf("draft exceeds %d bytes", maxBytes)
+	}
+	return data, nil
+}
+
+// draftCommit is what promoting a draft committed.
+type draftCommit struct {
+	WorkspaceVersion string
+	File             FileMetadata
+	Totals           SyncTotals
+	StorageBytes     int64  // Workspace storage after the commit
+	ReplacedKey      string // The previous object of a file that already existed
+}
+
+// commitDraft writes a draft's content to a new file object and commits it as an upsert in
+// a transaction that bumps the workspace version, updates its storage and records the change
+// log, as ConfirmSync does. Unless force is set, a file that changed since the draft's base
+// fails with errDraftConflict. The new object is removed if the commit fails.
+func (ac *ApiController) commitDraft(ctx context.Context, draft FileDraft, content []byte, force bool) (*draftCommit, error) {
+	workspaceID := draft.WorkspaceID
+	sum := sha256.Sum256(content)
+	fileID := uuid.New().String()
+	file := FileMetadata{
+		FileID:      fileID,
+		FilePath:    draft.FilePath,
+		Type:        "file",
+		R2ObjectKey: fmt.Sprintf("workspaces/%s/files/%s/%s", workspaceID, fileID, path.Base(draft.FilePath)),
+		Size:        int64(len(content)),
+		Hash:        hex.EncodeToString(sum[:]),
+	}
+	if _, err := ac.r2().S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(ac.R2BucketName),
+		Key:           aws.String(file.R2ObjectKey),
+		Body:          bytes.NewReader(content),
+		ContentLength: aws.Int64(file.Size),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to upload %s: %w", draft.FilePath, err)
+	}
+
+	wsDocRef := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+	fileRef := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID)).Doc(SanitizePathToDocID(draft.FilePath))
+	var commit draftCommit
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		commit = draftCommit{}
+
+		workspace, version, err := beginWorkspaceCommit(tx, wsDocRef)
+		if err != nil {
+			return err
+		}
+
+		var existing *FileMetadata
+		fileSnap, err := tx.Get(fileRef)
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		if err == nil {
+			existing = &FileMetadata{}
+			if err := fileSnap.DataTo(existing); err != nil {
+				return fmt.Errorf("failed to parse file %s: %w", draft.FilePath, err)
+			}
+			if existing.Type != "file" {
+				return errDraftIsFolder
+			}
+		}
+		if !force && draftConflict(draft, existing) {
+			return errDraftConflict
+		}
+
+		now := NowISO8601()
+		commit.WorkspaceVersion = version
+		commit.File = file
+		commit.File.CreatedAt, commit.File.UpdatedAt = now, now
+		commit.File.Version = commit.WorkspaceVersion
+		commit.Totals = SyncTotals{FilesUpserted: 1, BytesAdded: file.Size}
+		if existing != nil {
+			commit.File.CreatedAt = existing.CreatedAt
+			commit.Totals.BytesRemoved = existing.Size
+			commit.ReplacedKey = existing.R2ObjectKey
+		}
+		commit.StorageBytes = workspace.StorageBytes + commit.Totals.BytesAdded - commit.Totals.BytesRemoved
+		if err := ac.checkStorageQuota(workspace, commit.Totals.BytesAdded-commit.Totals.BytesRemoved); err != nil {
+			return err
+		}
+
+		if err := tx.Update(wsDocRef, []docstore.Update{
+			{Path: "workspace_version", Value: commit.WorkspaceVersion},
+			{Path: "updated_at", Value: now},
+			{Path: "storage_bytes", Value: docstore.Increment(commit.Totals.BytesAdded - commit.Totals.BytesRemoved)},
+		}); err != nil {
+			return fmt.Errorf("failed to increment workspace version: %w", err)
+		}
+		if err := tx.Set(fileRef, commit.File); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", draft.FilePath, err)
+		}
+		return ac.recordWorkspaceChanges(tx, workspaceID, commit.WorkspaceVersion, []WorkspaceChange{
+			{FilePath: draft.FilePath, Type: "file", Action: "upsert"},
+		})
+	})
+	if err != nil {
+		ac.discardDraftObjects(ctx, workspaceID, []string{file.R2ObjectKey})
+		return nil, err
+	}
+	return &commit, nil
+}
+
+// PromoteDraft handles POST /workspaces/:workspaceId/files/draft/promote. It commits the
+// user's draft of filePath as a regular upsert, bumping the workspace version, and then
+// removes the draft. Editors and owners only; read-only workspaces are rejected.
+func (ac *ApiController) PromoteDraft(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "PromoteDraft"})
+
+	if !ac.requireDraftMember(c, workspaceID, userID, true, logCtx) {
+		return
+	}
+	var req PromoteDraftRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	filePath, ok := validEntryPath(req.FilePath)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filePath must be a relative path inside the workspace"})
+		return
+	}
+	logCtx = logCtx.WithField("file_path", filePath)
+
+	draft, draftSnap, err := ac.loadDraft(ctx, workspaceID, userID, filePath)
+	if errors.Is(err, errDraftNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No draft for this file"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load draft.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load draft"})
+		return
+	}
+	content, err := ac.readDraftContent(ctx, draft.R2ObjectKey, ac.AppConfig.DraftMaxBytes)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to read draft content.")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read draft content"})
+		return
+	}
+
+	commit, err := ac.commitDraft(ctx, draft, content, req.Force)
+	var quotaErr *storageQuotaError
+	switch {
+	case errors.Is(err, errDraftConflict):
+		c.JSON(http.StatusConflict, PromoteDraftResponse{
+			Status:       "draft_conflict",
+			ErrorMessage: "The file changed since this draft was started. Merge the changes or promote with force.",
+		})
+		return
+	case errors.Is(err, errDraftIsFolder):
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s is a folder", filePath)})
+		return
+	case errors.Is(err, errWorkspaceReadOnly):
+		c.JSON(http.StatusConflict, gin.H{"error": "Workspace is read-only. Ask an owner to lift the freeze."})
+		return
+	case errors.As(err, &quotaErr):
+		quota := quotaErr.quota
+		c.JSON(http.StatusRequestEntityTooLarge, PromoteDraftResponse{
+			Status:       syncQuotaExceeded,
+			ErrorMessage: fmt.Sprintf("Promoting this draft would grow the workspace to %d bytes, over its %d byte quota.", quota.ProjectedBytes, quota.LimitBytes),
+			StorageQuota: &quota,
+		})
+		return
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to commit draft.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to promote draft"})
+		return
+	}
+
+	if commit.ReplacedKey != "" {
+		if failures := ac.deleteR2Objects(ctx, []string{commit.ReplacedKey}); len(failures) > 0 {
+			logCtx.Error("Failed to delete the replaced R2 object; queueing it for retry.")
+			ac.recordFailedR2Deletions(ctx, workspaceID, failures)
+		}
+	}
+	// An autosave that landed while promoting keeps its draft.
+	if _, err := draftSnap.Ref.Delete(ctx, docstore.LastUpdateTime(draftSnap.UpdateTime)); err == nil {
+		ac.discardDraftObjects(ctx, workspaceID, []string{draft.R2ObjectKey})
+	} else {
+		logCtx.WithError(err).Info("Draft kept after promotion; it was saved again meanwhile.")
+	}
+
+	logCtx.WithFields(log.Fields{
+		"workspace_version": commit.WorkspaceVersion,
+		"bytes_added":       commit.Totals.BytesAdded,
+		"bytes_removed":     commit.Totals.BytesRemoved,
+	}).Info("Draft promoted.")
+	ac.recordActivity(ctx, workspaceID, userID, activityDraftPromoted, map[string]interface{}{
+		"workspace_version": commit.WorkspaceVersion,
+		"file_path":         filePath,
+		"bytes_added":       commit.Totals.BytesAdded,
+		"bytes_removed":     commit.Totals.BytesRemoved,
+	})
+	ac.recordWorkspaceUsage(ctx, workspaceID, map[string]interface{}{
+		"sync_commit_count": docstore.Increment(1),
+		"storage_bytes":     commit.StorageBytes,
+	})
+
+	c.JSON(http.StatusOK, PromoteDraftResponse{
+		Status:           "success",
+		WorkspaceVersion: commit.WorkspaceVersion,
+		File:             &commit.File,
+		Totals:           &commit.Totals,
+	})
+
+	indexingJobID := uuid.New().String()
+	files := []WorkerFile{{R2ObjectKey: commit.File.R2ObjectKey, FilePath: filePath}}
+	submitErr := ac.Background.Submit("rag_indexing", func(ctx context.Context) error {
+		if err := ac.requestRagIndexing(ctx, indexingJobID, workspaceID, commit.WorkspaceVersion, files); err != nil {
+			return fmt.Errorf("failed to request RAG indexing task %s: %w", indexingJobID, err)
+		}
+		return nil
+	})
+	if submitErr != nil {
+		logCtx.WithError(submitErr).WithField("indexing_job_id", indexingJobID).Error("Failed to schedule RAG indexing task")
+	}
+}
+
+// runDraftExpiryMaintenance is the "draft-expiry" maintenance task: it removes drafts past
+// their expires_at along with their content. A draft saved again since the scan is kept.
+func (ac *ApiController) runDraftExpiryMaintenance(ctx context.Context) (interface{}, error) {
+	docs, err := ac.FirestoreClient.Collection(draftsCollection).
+		Where("expires_at", "<=", NowISO8601()).
+		OrderBy("expires_at", docstore.Asc).
+		Limit(draftExpiryBatch).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load expired drafts: %w", err)
+	}
+
+	keysByWorkspace := make(map[string][]string)
+	expired := 0
+	for _, doc := range docs {
+		var draft FileDraft
+		if err := doc.DataTo(&draft); err != nil {
+			log.WithError(err).WithField("draft_id", doc.Ref.ID).Warn("Skipping unparseable draft.")
+			continue
+		}
+		if _, err := doc.Ref.Delete(ctx, docstore.LastUpdateTime(doc.UpdateTime)); err != nil {
+			log.WithError(err).WithField("draft_id", doc.Ref.ID).Info("Expired draft not deleted; it may have been saved again.")
+			continue
+		}
+		expired++
+		keysByWorkspace[draft.WorkspaceID] = append(keysByWorkspace[draft.WorkspaceID], draft.R2ObjectKey)
+	}
+	for workspaceID, keys := range keysByWorkspace {
+		ac.discardDraftObjects(ctx, workspaceID, keys)
+	}
+	return gin.H{"scanned": len(docs), "expired": expired}, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDraftFilePath(t *testing.T) {
+	p, ok := draftFilePath("/src/main.py/draft")
+	assert.True(t, ok)
+	assert.Equal(t, "src/main.py", p)
+
+	p, ok = draftFilePath("/draft/draft") // A file named "draft"
+	assert.True(t, ok)
+	assert.Equal(t, "draft", p)
+
+	for _, param := range []string{
+		"/src/main.py",
+		"/draft",
+		"/src/main.py/draft/",
+		"/../secret.py/draft",
+		"/src/../main.py/draft",
+		"//main.py/draft",
+		"/src//main.py/draft",
+	} {
+		_, ok := draftFilePath(param)
+		assert.False(t, ok, param)
+	}
+}
+
+func TestDraftObjectKeyStaysOutsideFiles(t *testing.T) {
+	key := draftObjectKey("ws-1", "user-1")
+	assert.True(t, strings.HasPrefix(key, "workspaces/ws-1/drafts/user-1/"))
+	assert.NotEqual(t, key, draftObjectKey("ws-1", "user-1"))
+	// Sync commits can never point a file at a draft's object.
+	assert.ErrorIs(t, validateObjectKey("ws-1", "file", "f-1", key), errInvalidObjectKey)
+}
+
+func TestDraftDocIDIsPerUser(t *testing.T) {
+	id := draftDocID("ws-1", "user-1", "main.py")
+	assert.Equal(t, id, draftDocID("ws-1", "user-1", "main.py"))
+	assert.NotEqual(t, id, draftDocID("ws-1", "user-2", "main.py"))
+	assert.NotEqual(t, id, draftDocID("ws-2", "user-1", "main.py"))
+	assert.NotEqual(t, id, draftDocID("ws-1", "user-1", "other.py"))
+}
+
+func TestDraftExpired(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	assert.False(t, draftExpired(FileDraft{ExpiresAt: TimeToISO8601(now.Add(time.Minute))}, now))
+	assert.True(t, draftExpired(FileDraft{ExpiresAt: TimeToISO8601(now)}, now))
+	assert.True(t, draftExpired(FileDraft{ExpiresAt: TimeToISO8601(now.Add(-time.Minute))}, now))
+	assert.True(t, draftExpired(FileDraft{}, now))
+}
+
+func TestDraftConflict(t *testing.T) {
+	current := &FileMetadata{Type: "file", Hash: "abc"}
+	assert.False(t, draftConflict(FileDraft{BaseHash: "abc"}, current))
+	assert.True(t, draftConflict(FileDraft{BaseHash: "old"}, current))
+	assert.True(t, draftConflict(FileDraft{BaseHash: "abc"}, nil)) // Deleted since the draft began
+	// Without a base (a file the editor created) nothing is known to have changed.
+	assert.False(t, draftConflict(FileDraft{}, current))
+	assert.False(t, draftConflict(FileDraft{}, nil))
+}
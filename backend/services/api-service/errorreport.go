@@ -0,0 +1,19 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// reportedErrorEventType marks a JSON log entry as an error event for Cloud Error Reporting,
+// which groups and alerts on these independently of ordinary error logs.
+const reportedErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// reportError escalates a failure that needs operator attention to Cloud Error Reporting.
+// Use it for conditions that will not resolve on their own; plain logging is enough otherwise.
+func reportError(err error, message string, fields log.Fields) {
+	log.WithFields(fields).WithError(err).WithFields(log.Fields{
+		"@type":          reportedErrorEventType,
+		"message":        message + ": " + err.Error(), // Error Reporting reads "message", not logrus' "msg"
+		"serviceContext": map[string]string{"service": "api-service"},
+	}).Error(message)
+}
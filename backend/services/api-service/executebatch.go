@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxExecuteBatchItems and maxExecuteBatchBytes bound one POST /api/execute/batch.
+	maxExecuteBatchItems = 50
+	maxExecuteBatchBytes = 2 << 20
+	// executeBatchConcurrency is how many of a batch's Cloud Tasks are created at once.
+	executeBatchConcurrency = 8
+)
+
+// ExecuteCodeBatch runs up to maxExecuteBatchItems public executions from one request. Each
+// item is handled as POST /api/execute would handle it, and results come back in request
+// order. An invalid item fails alone: the response is 200 when every item was queued and
+// 207 with per-item statuses otherwise.
+func (ac *ApiController) ExecuteCodeBatch(c *gin.Context) {
+	ctx := c.Request.Context()
+	logCtx := log.WithField("handler", "ExecuteCodeBatch")
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxExecuteBatchBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Batch exceeds the %d byte limit", maxExecuteBatchBytes)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	var req ExecuteBatchRequest
+	if err := ac.decodeJSON(body, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": bindingErrorDetails(err)})
+		return
+	}
+	if len(req.Items) == 0 || len(req.Items) > maxExecuteBatchItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A batch must have between 1 and %d items", maxExecuteBatchItems)})
+		return
+	}
+	if !ac.admitExecution(c, false) {
+		return
+	}
+
+	results := make([]ExecuteBatchItemResult, len(req.Items))
+	var execs []publicExecution
+	var indexes []int // Position in the request of each of execs
+	for i, raw := range req.Items {
+		results[i].Index = i
+		var item RequestBody
+		if err := ac.decodeJSON(raw, &item); err != nil {
+			results[i].Status, results[i].Error, results[i].Details = http.StatusBadRequest, "Invalid request body", bindingErrorDetails(err)
+			continue
+		}
+		exec, err := ac.preparePublicExecution(ctx, item)
+		var execErr *executionError
+		if errors.As(err, &execErr) {
+			results[i].Status, results[i].Error = execErr.Status, execErr.Message
+			continue
+		}
+		if err != nil {
+			logCtx.WithError(err).WithField("index", i).Error("Failed to prepare batch item")
+			results[i].Status, results[i].Error = http.StatusInternalServerError, "Failed to create job record"
+			continue
+		}
+		execs = append(execs, exec)
+		indexes = append(indexes, i)
+	}
+
+	stored := make([]StoredJob, len(execs))
+	for j, exec := range execs {
+		stored[j] = StoredJob{ID: exec.JobID, Job: exec.Job}
+	}
+	// The whole batch is charged up front: either every valid item fits the caller's quota
+	// or none is created.
+	if quota := ac.anonymousQuotaFor(c, time.Now()); quota != nil && len(stored) > 0 {
+		err := ac.Jobs.ChargeQuota(ctx, *quota, len(stored))
+		if errors.Is(err, errQuotaExceeded) {
+			rejectOverQuota(c, quota)
+			return
+		}
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to charge batch to execution quota")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job records"})
+			return
+		}
+	}
+
+	var created []int // Indexes into execs of the stored jobs
+	if len(stored) > 0 {
+		for j, err := range ac.Jobs.CreateMany(ctx, stored) {
+			if err != nil {
+				logCtx.WithError(err).WithField("job_id", execs[j].JobID).Error("Failed to create batch job record")
+				results[indexes[j]].Status, results[indexes[j]].Error = http.StatusInternalServerError, "Failed to create job record"
+				continue
+			}
+			created = append(created, j)
+		}
+	}
+
+	ac.enqueueBatch(ctx, execs, created, func(j int, err error) {
+		r := &results[indexes[j]]
+		if err != nil {
+			logCtx.WithError(err).WithField("job_id", execs[j].JobID).Error("Failed to create Cloud Task for batch item")
+			r.Status, r.Error = http.StatusInternalServerError, "Failed to submit job for execution"
+			return
+		}
+		r.Status, r.JobID, r.ResultToken = http.StatusOK, execs[j].JobID, execs[j].Job.ResultToken
+	})
+
+	queued := 0
+	for _, r := range results {
+		if r.Status == http.StatusOK {
+			queued++
+		}
+	}
+	logCtx.WithFields(log.Fields{"items": len(results), "queued": queued}).Info("Batch of public executions processed")
+	status := http.StatusOK
+	if queued < len(results) {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{"results": results, "queued": queued, "failed": len(results) - queued})
+}
+
+// enqueueBatch creates the Cloud Tasks of execs[j] for each j in which, at most
+// executeBatchConcurrency at a time, and reports each outcome to done. done is called from
+// one goroutine at a time.
+func (ac *ApiController) enqueueBatch(ctx context.Context, execs []publicExecution, which []int, done func(j int, err error)) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, executeBatchConcurrency)
+	for _, j := range which {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(j int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			_, err := ac.enqueuePublicExecution(ctx, execs[j])
+			mu.Lock()
+			defer mu.Unlock()
+			done(j, err)
+		}(j)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type executeBatchResponse struct {
+	Results []ExecuteBatchItemResult `json:"results"`
+	Queued  int                      `json:"queued"`
+	Failed  int                      `json:"failed"`
+}
+
+func TestExecuteCodeBatch_MixedItems(t *testing.T) {
+	r, ac, tasks := newEmbeddedHarness(t)
+
+	items := []interface{}{
+		RequestBody{Code: "print(1)", Language: "python"},
+		map[string]string{"language": "python"}, // No code
+		RequestBody{Code: "print(2)", Language: "cobol"},
+		RequestBody{Code: strings.Repeat("x", defaultPythonLimits.MaxCodeBytes+1), Language: "python"},
+		RequestBody{Code: "print(3)", Language: "python", Input: "in"},
+		"not an object",
+	}
+	var resp executeBatchResponse
+	w := serveJSON(r, http.MethodPost, "/api/execute/batch", map[string]interface{}{"items": items}, &resp)
+	assert.Equal(t, http.StatusMultiStatus, w.Code, w.Body.String())
+	assert.Equal(t, 2, resp.Queued)
+	assert.Equal(t, 4, resp.Failed)
+
+	if assert.Len(t, resp.Results, len(items)) {
+		wantStatus := []int{http.StatusOK, http.StatusBadRequest, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusOK, http.StatusBadRequest}
+		for i, result := range resp.Results {
+			assert.Equal(t, i, result.Index)
+			assert.Equal(t, wantStatus[i], result.Status, "item %d: %s", i, result.Error)
+		}
+		assert.Equal(t, "code", resp.Results[1].Details[0].Field)
+		assert.NotEqual(t, resp.Results[0].JobID, resp.Results[4].JobID)
+
+		job, err := ac.Jobs.Get(context.Background(), resp.Results[4].JobID)
+		assert.NoError(t, err)
+		assert.Equal(t, "queued", job.Status)
+		assert.Equal(t, resp.Results[4].ResultToken, job.ResultToken)
+	}
+	assert.Len(t, tasks.tasksTo("/execute"), 2)
+}
+
+func TestExecuteCodeBatch_AllQueued(t *testing.T) {
+	r, _, tasks := newEmbeddedHarness(t)
+
+	items := make([]RequestBody, maxExecuteBatchItems)
+	for i := range items {
+		items[i] = RequestBody{Code: "print(1)", Language: "python"}
+	}
+	var resp executeBatchResponse
+	w := serveJSON(r, http.MethodPost, "/api/execute/batch", map[string]interface{}{"items": items}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, maxExecuteBatchItems, resp.Queued)
+	assert.Len(t, tasks.tasksTo("/execute"), maxExecuteBatchItems)
+}
+
+func TestExecuteCodeBatch_Limits(t *testing.T) {
+	r, _, tasks := newEmbeddedHarness(t)
+
+	w := serveJSON(r, http.MethodPost, "/api/execute/batch", map[string]interface{}{"items": []RequestBody{}}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	tooMany := make([]RequestBody, maxExecuteBatchItems+1)
+	w = serveJSON(r, http.MethodPost, "/api/execute/batch", map[string]interface{}{"items": tooMany}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	body, _ := json.Marshal(map[string]interface{}{"items": []RequestBody{{Code: strings.Repeat("x", maxExecuteBatchBytes), Language: "python"}}})
+	req := httptest.NewRequest(http.MethodPost, "/api/execute/batch", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = serveRequest(r, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	assert.Empty(t, tasks.tasksTo("/execute"))
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/1liale/api-service/streaming"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// errEmptyExportSelection is returned when paths/exclude leave nothing to archive.
+var errEmptyExportSelection = errors.New("no files match the export selection")
+
+// exportFile is one manifest file and its path inside the archive.
+type exportFile struct {
+	FileMetadata
+	ArchivePath string
+}
+
+// unknownExportPathsError lists requested paths that are not in the workspace manifest.
+type unknownExportPathsError struct {
+	Paths []string
+}
+
+func (e *unknownExportPathsError) Error() string {
+	return fmt.Sprintf("unknown paths: %s", strings.Join(e.Paths, ", "))
+}
+
+// selectExportFiles filters the manifest down to the files an export should contain.
+//
+// paths selects files and folders (empty means the whole workspace); each must exist in the
+// manifest, either as an entry or as a parent folder of one. exclude holds path.Match globs
+// tested against each file's full path, its base name and every parent folder, so "*.log"
+// and "node_modules" both work. When exactly one folder is selected, archive paths are
+// rooted at that folder (e.g. "src/notebooks/a.ipynb" becomes "notebooks/a.ipynb").
+func selectExportFiles(manifest []FileMetadata, paths, exclude []string) ([]exportFile, error) {
+	for _, pattern := range exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+
+	selected := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if p = strings.Trim(path.Clean("/"+p), "/"); p != "" {
+			selected = append(selected, p)
+		}
+	}
+
+	// Resolve each selected path to a file or a folder.
+	folders := make(map[string]bool)
+	var unknown []string
+	for _, sel := range selected {
+		isFile, isFolder := false, false
+		for _, f := range manifest {
+			switch {
+			case f.FilePath == sel:
+				isFile = isFile || f.Type == "file"
+				isFolder = isFolder || f.Type == "folder"
+			case strings.HasPrefix(f.FilePath, sel+"/"):
+				isFolder = true
+			}
+		}
+		if !isFile && !isFolder {
+			unknown = append(unknown, sel)
+		}
+		folders[sel] = isFolder
+	}
+	if len(unknown) > 0 {
+		return nil, &unknownExportPathsError{Paths: unknown}
+	}
+
+	root := ""
+	if len(selected) == 1 && folders[selected[0]] {
+		root = path.Dir(selected[0])
+	}
+
+	var files []exportFile
+	for _, f := range manifest {
+		if f.Type != "file" || !matchesExportSelection(f.FilePath, selected) || isExcludedFromExport(f.FilePath, exclude) {
+			continue
+		}
+		archivePath := f.FilePath
+		if root != "." && root != "" {
+			archivePath = strings.TrimPrefix(archivePath, root+"/")
+		}
+		files = append(files, exportFile{FileMetadata: f, ArchivePath: archivePath})
+	}
+	if len(files) == 0 {
+		return nil, errEmptyExportSelection
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ArchivePath < files[j].ArchivePath })
+	return files, nil
+}
+
+// matchesExportSelection reports whether filePath is one of the selected paths or inside one.
+func matchesExportSelection(filePath string, selected []string) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for _, sel := range selected {
+		if filePath == sel || strings.HasPrefix(filePath, sel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcludedFromExport reports whether any exclude glob matches the file or one of its folders.
+func isExcludedFromExport(filePath string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if ok, _ := path.Match(pattern, path.Base(filePath)); ok {
+			return true
+		}
+		for p := filePath; p != "." && p != "/"; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, path.Base(p)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// workspaceFiles returns every manifest entry (files and folders) of a workspace.
+func (ac *ApiController) workspaceFiles(ctx context.Context, workspaceID string) ([]FileMetadata, error) {
+	docs, err := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID)).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspace files: %w", err)
+	}
+	files := make([]FileMetadata, 0, len(docs))
+	for _, doc := range docs {
+		var fileMeta FileMetadata
+		if err := doc.DataTo(&fileMeta); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"workspace_id": workspaceID,
+				"document_id":  doc.Ref.ID,
+			}).Warn("Failed to parse file metadata.")
+			continue
+		}
+		files = append(files, fileMeta)
+	}
+	return files, nil
+}
+
+// ExportWorkspace streams the workspace, or a selection of it, as an archive.
+// Query params: format ("zip" by default, or "tar" for a gzipped tarball), paths (repeatable,
+// files or folders) and exclude (repeatable globs).
+func (ac *ApiController) ExportWorkspace(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "ExportWorkspace"})
+
+	format := c.DefaultQuery("format", "zip")
+	if format != "zip" && format != "tar" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be \"zip\" or \"tar\""})
+		return
+	}
+
+	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Workspace membership check failed.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+		return
+	}
+
+	manifest, err := ac.workspaceFiles(ctx, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load workspace manifest for export.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file list"})
+		return
+	}
+
+	files, err := selectExportFiles(manifest, c.QueryArray("paths"), c.QueryArray("exclude"))
+	if err != nil {
+		writeExportSelectionError(c, err)
+		return
+	}
+
+	filename := workspaceID + ".zip"
+	if format == "tar" {
+		filename = workspaceID + ".tar.gz"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	stream := streaming.Start(ctx, c.Writer, ac.AppConfig.Streaming, ac.streams)
+	if format == "tar" {
+		c.Header("Content-Type", "application/gzip")
+		err = ac.writeTarExport(stream.Context(), stream, files)
+	} else {
+		c.Header("Content-Type", "application/zip")
+		err = ac.writeZipExport(stream.Context(), stream, files)
+	}
+	reason := stream.Close(err)
+	if err != nil {
+		// Headers are already sent; the client sees a truncated archive.
+		logCtx.WithError(err).WithField("stream_end", reason).Error("Workspace export failed mid-stream.")
+		return
+	}
+	logCtx.WithFields(log.Fields{"format": format, "file_count": len(files)}).Info("Workspace exported.")
+}
+
+// writeExportSelectionError answers 400 for a paths/exclude selection selectExportFiles refused.
+func writeExportSelectionError(c *gin.Context, err error) {
+	var unknownErr *unknownExportPathsError
+	switch {
+	case errors.As(err, &unknownErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Some paths do not exist in the workspace", "unknownPaths": unknownErr.Paths})
+	case errors.Is(err, errEmptyExportSelection):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files match the export selection"})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// openExportFile fetches a file's content and size from R2. When R2 reports no content
+// length the manifest size is used, so a tar entry is never written empty; a body shorter
+// than that size then fails the export instead of corrupting the archive.
+func (ac *ApiController) openExportFile(ctx context.Context, f exportFile) (io.ReadCloser, int64, error) {
+	out, err := ac.r2().S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ac.R2BucketName),
+		Key:    aws.String(f.R2ObjectKey),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch %s: %w", f.FilePath, err)
+	}
+	size := f.Size
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	return out.Body, size, nil
+}
+
+// writeZipExport writes files to w as a zip, one object at a time. Folder entries become
+// empty directory entries.
+func (ac *ApiController) writeZipExport(ctx context.Context, w io.Writer, files []exportFile) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		if f.Type == "folder" {
+			if _, err := zw.Create(strings.TrimSuffix(f.ArchivePath, "/") + "/"); err != nil {
+				return fmt.Errorf("failed to write %s: %w", f.ArchivePath, err)
+			}
+			continue
+		}
+		body, _, err := ac.openExportFile(ctx, f)
+		if err != nil {
+			return err
+		}
+		entry, err := zw.Create(f.ArchivePath)
+		if err == nil {
+			_, err = io.Copy(entry, body)
+		}
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.ArchivePath, err)
+		}
+	}
+	return zw.Close()
+}
+
+func (ac *ApiController) writeTarExport(ctx context.Context, w io.Writer, files []exportFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		body, size, err := ac.openExportFile(ctx, f)
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{Name: f.ArchivePath, Mode: 0o644, Size: size})
+		if err == nil {
+			_, err = io.CopyN(tw, body, size)
+		}
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.ArchivePath, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
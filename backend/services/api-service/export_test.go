@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportTestManifest() []FileMetadata {
+	return []FileMetadata{
+		{FilePath: "main.py", Type: "file"},
+		{FilePath: "src", Type: "folder"},
+		{FilePath: "src/notebooks", Type: "folder"},
+		{FilePath: "src/notebooks/a.ipynb", Type: "file"},
+		{FilePath: "src/notebooks/run.log", Type: "file"},
+		{FilePath: "src/notebooks/cache/b.bin", Type: "file"},
+		{FilePath: "data/input.csv", Type: "file"}, // No folder entry for "data"
+	}
+}
+
+func archivePaths(files []exportFile) []string {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.ArchivePath
+	}
+	return paths
+}
+
+func TestSelectExportFiles_WholeWorkspace(t *testing.T) {
+	files, err := selectExportFiles(exportTestManifest(), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"data/input.csv",
+		"main.py",
+		"src/notebooks/a.ipynb",
+		"src/notebooks/cache/b.bin",
+		"src/notebooks/run.log",
+	}, archivePaths(files))
+}
+
+func TestSelectExportFiles_SingleFolderIsRootedAtFolder(t *testing.T) {
+	files, err := selectExportFiles(exportTestManifest(), []string{"/src/notebooks/"}, []string{"*.log", "cache"})
+	if assert.NoError(t, err) && assert.Equal(t, []string{"notebooks/a.ipynb"}, archivePaths(files)) {
+		assert.Equal(t, "src/notebooks/a.ipynb", files[0].FilePath)
+	}
+}
+
+func TestSelectExportFiles_ImplicitFolderAndFile(t *testing.T) {
+	files, err := selectExportFiles(exportTestManifest(), []string{"data", "main.py"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"data/input.csv", "main.py"}, archivePaths(files), "multiple selections keep full paths")
+}
+
+func TestSelectExportFiles_UnknownPaths(t *testing.T) {
+	_, err := selectExportFiles(exportTestManifest(), []string{"src", "missing", "src/nope.py"}, nil)
+	var unknownErr *unknownExportPathsError
+	if assert.ErrorAs(t, err, &unknownErr) {
+		assert.Equal(t, []string{"missing", "src/nope.py"}, unknownErr.Paths)
+	}
+}
+
+func TestSelectExportFiles_EmptySelection(t *testing.T) {
+	_, err := selectExportFiles(exportTestManifest(), []string{"data"}, []string{"*.csv"})
+	assert.ErrorIs(t, err, errEmptyExportSelection)
+}
+
+func TestSelectExportFiles_InvalidExcludePattern(t *testing.T) {
+	_, err := selectExportFiles(exportTestManifest(), nil, []string{"[unclosed"})
+	assert.Error(t, err)
+}
+
+// readTarGz returns the content of each entry of a gzipped tarball, keyed by name.
+func readTarGz(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	entries := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("read %s: %v", hdr.Name, err)
+		}
+		assert.Equal(t, int64(len(content)), hdr.Size, hdr.Name)
+		entries[hdr.Name] = string(content)
+	}
+}
+
+func TestWriteTarExport_WithoutContentLength(t *testing.T) {
+	objects := newFakeObjectAPI()
+	objects.unsized = true
+	ac := &ApiController{R2BucketName: "test-bucket"}
+	ac.objectStore.Store(&ObjectStore{S3: objects, Presign: &fakePresigner{}, Credentials: r2CredentialsPrimary})
+	objects.put("k/main.py", []byte("print('hi')"))
+	objects.put("k/data.csv", []byte("a,b\n1,2\n"))
+	files := []exportFile{
+		{FileMetadata: FileMetadata{FilePath: "data.csv", Type: "file", R2ObjectKey: "k/data.csv", Size: 8}, ArchivePath: "data.csv"},
+		{FileMetadata: FileMetadata{FilePath: "main.py", Type: "file", R2ObjectKey: "k/main.py", Size: 11}, ArchivePath: "main.py"},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, ac.writeTarExport(context.Background(), &out, files))
+	assert.Equal(t, map[string]string{"data.csv": "a,b\n1,2\n", "main.py": "print('hi')"}, readTarGz(t, out.Bytes()))
+
+	// A manifest size the object cannot fill fails the export rather than padding the entry.
+	files[1].Size = 50
+	assert.Error(t, ac.writeTarExport(context.Background(), io.Discard, files))
+}
+
+func TestHandlers_ExportWorkspaceTar(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+	h.seedFile(workspaceID, "src/util.py", "def f(): pass")
+
+	for _, unsized := range []bool{false, true} {
+		h.objects.unsized = unsized
+		w := h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/export?format=tar", owner, nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Disposition"), workspaceID+".tar.gz")
+		assert.Equal(t, map[string]string{"main.py": "print('hi')", "src/util.py": "def f(): pass"}, readTarGz(t, w.Body.Bytes()))
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// workspaceExportsCollection holds WorkspaceExport records. Each carries delete_after,
+	// when the "workspace-export-expiry" maintenance task deletes it and its archive.
+	workspaceExportsCollection = "workspace_exports"
+	workspaceExportExpiryBatch = 100
+	// workspaceExportTimeout bounds building and uploading one archive.
+	workspaceExportTimeout = 30 * time.Minute
+	// workspaceExportURLTTL is how long a completed export's download URL is valid.
+	workspaceExportURLTTL = 15 * time.Minute
+	// workspaceExportPartSize is the part size of the archive's multipart upload, and so
+	// the most of it held in memory at once.
+	workspaceExportPartSize = 8 << 20
+)
+
+// errExportUploadStopped is what the archive writer sees once the upload stopped reading it.
+var errExportUploadStopped = errors.New("export upload stopped")
+
+// WorkspaceExport statuses.
+const (
+	exportStatusQueued    = "queued"
+	exportStatusRunning   = "running"
+	exportStatusCompleted = "completed"
+	exportStatusFailed    = "failed"
+)
+
+// workspaceExportKey is where an export's archive is stored in R2.
+func workspaceExportKey(workspaceID, exportID string) string {
+	return fmt.Sprintf("exports/%s/%s.zip", workspaceID, exportID)
+}
+
+// workspaceExportEntries returns every file and folder of the manifest as archive entries,
+// sorted by path, so empty folders survive the round trip.
+func workspaceExportEntries(manifest []FileMetadata) []exportFile {
+	entries := make([]exportFile, 0, len(manifest))
+	for _, f := range manifest {
+		if f.Type != "file" && f.Type != "folder" {
+			continue
+		}
+		entries = append(entries, exportFile{FileMetadata: f, ArchivePath: f.FilePath})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ArchivePath < entries[j].ArchivePath })
+	return entries
+}
+
+// workspaceExportSelection returns the entries of an export: the whole workspace, folders
+// included, when neither paths nor exclude is set, and otherwise the files selectExportFiles
+// picks, as a streamed export of the same selection would contain.
+func workspaceExportSelection(manifest []FileMetadata, paths, exclude []string) ([]exportFile, error) {
+	if len(paths) == 0 && len(exclude) == 0 {
+		return workspaceExportEntries(manifest), nil
+	}
+	return selectExportFiles(manifest, paths, exclude)
+}
+
+// CreateWorkspaceExport starts building a zip of the workspace in the background and answers
+// 202 with the export record; poll GetWorkspaceExport for the download URL. Query params
+// paths and exclude select part of the workspace as they do for ExportWorkspace; the
+// selection is checked now and applied to the files as they are when the export runs.
+func (ac *ApiController) CreateWorkspaceExport(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "CreateWorkspaceExport"})
+
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleViewer, logCtx) == nil {
+		return
+	}
+	paths, exclude := c.QueryArray("paths"), c.QueryArray("exclude")
+	if len(paths) > 0 || len(exclude) > 0 {
+		manifest, err := ac.workspaceFiles(ctx, workspaceID)
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to load workspace manifest for export.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file list"})
+			return
+		}
+		if _, err := selectExportFiles(manifest, paths, exclude); err != nil {
+			writeExportSelectionError(c, err)
+			return
+		}
+	}
+
+	now := time.Now()
+	export := WorkspaceExport{
+		ExportID:    uuid.New().String(),
+		WorkspaceID: workspaceID,
+		RequestedBy: userID,
+		Paths:       paths,
+		Exclude:     exclude,
+		Status:      exportStatusQueued,
+		CreatedAt:   TimeToISO8601(now),
+		UpdatedAt:   TimeToISO8601(now),
+		ExpiresAt:   TimeToISO8601(now.Add(ac.AppConfig.WorkspaceExportTTL)),
+		DeleteAfter: now.Add(ac.AppConfig.WorkspaceExportTTL).UTC(),
+	}
+	export.R2ObjectKey = workspaceExportKey(workspaceID, export.ExportID)
+	ref := ac.FirestoreClient.Collection(workspaceExportsCollection).Doc(export.ExportID)
+	if _, err := ref.Create(ctx, export); err != nil {
+		logCtx.WithError(err).Error("Failed to create workspace export.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create export"})
+		return
+	}
+
+	submitErr := ac.Background.SubmitWithTimeout("workspace_export", workspaceExportTimeout, func(ctx context.Context) error {
+		return ac.runWorkspaceExport(ctx, ref, export)
+	})
+	if submitErr != nil {
+		logCtx.WithError(submitErr).Error("Failed to schedule workspace export.")
+		ac.failWorkspaceExport(ctx, ref, "Export could not be scheduled; try again later")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Export could not be scheduled; try again later"})
+		return
+	}
+
+	logCtx.WithField("export_id", export.ExportID).Info("Workspace export queued.")
+	c.JSON(http.StatusAccepted, export)
+}
+
+// GetWorkspaceExport returns an export's progress and, once it has completed and until it
+// expires, a short-lived download URL.
+func (ac *ApiController) GetWorkspaceExport(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	exportID := c.Param("exportId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "export_id": exportID, "user_id": userID, "handler": "GetWorkspaceExport"})
+
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleViewer, logCtx) == nil {
+		return
+	}
+
+	snap, err := ac.FirestoreClient.Collection(workspaceExportsCollection).Doc(exportID).Get(ctx)
+	if err != nil && !isNotFound(err) {
+		logCtx.WithError(err).Error("Failed to load workspace export.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load export"})
+		return
+	}
+	var export WorkspaceExport
+	if err != nil || snap.DataTo(&export) != nil || export.WorkspaceID != workspaceID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+	if !time.Now().Before(export.DeleteAfter) {
+		c.JSON(http.StatusGone, gin.H{"error": "Export has expired", "code": "export_expired"})
+		return
+	}
+
+	if export.Status == exportStatusCompleted {
+		req, err := ac.r2().Presign.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket:                     aws.String(ac.R2BucketName),
+			Key:                        aws.String(export.R2ObjectKey),
+			ResponseContentDisposition: aws.String(fmt.Sprintf("attachment; filename=%q", workspaceID+".zip")),
+		}, func(po *s3.PresignOptions) {
+			po.Expires = workspaceExportURLTTL
+		})
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to presign export download URL.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download URL"})
+			return
+		}
+		export.DownloadURL = req.URL
+	}
+	c.JSON(http.StatusOK, export)
+}
+
+// runWorkspaceExport streams the archive into a multipart upload to R2 as it is built, one
+// object at a time, so neither the workspace nor the archive is held in memory or on disk,
+// and completes the record.
+func (ac *ApiController) runWorkspaceExport(ctx context.Context, ref *docstore.DocumentRef, export WorkspaceExport) error {
+	logCtx := log.WithFields(log.Fields{"export_id": export.ExportID, "workspace_id": export.WorkspaceID})
+	if _, err := ref.Update(ctx, []docstore.Update{
+		{Path: "status", Value: exportStatusRunning},
+		{Path: "updated_at", Value: NowISO8601()},
+	}); err != nil {
+		logCtx.WithError(err).Warn("Failed to mark workspace export as running.")
+	}
+
+	workspace, err := ac.loadWorkspace(ctx, export.WorkspaceID)
+	if err != nil {
+		ac.failWorkspaceExport(ctx, ref, "Failed to load workspace")
+		return fmt.Errorf("failed to load workspace: %w", err)
+	}
+	manifest, err := ac.workspaceFiles(ctx, export.WorkspaceID)
+	if err != nil {
+		ac.failWorkspaceExport(ctx, ref, "Failed to retrieve file list")
+		return err
+	}
+	// Files may have changed since the selection was checked.
+	entries, err := workspaceExportSelection(manifest, export.Paths, export.Exclude)
+	if err != nil {
+		ac.failWorkspaceExport(ctx, ref, err.Error())
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	built := make(chan error, 1)
+	go func() {
+		err := ac.writeZipExport(ctx, pw, entries)
+		pw.CloseWithError(err)
+		built <- err
+	}()
+	size, uploadErr := ac.r2().putStream(ctx, ac.R2BucketName, export.R2ObjectKey, "application/zip", pr, workspaceExportPartSize)
+	// Unblocks the archive writer if the upload stopped reading.
+	pr.CloseWithError(errExportUploadStopped)
+	if err := <-built; err != nil && !errors.Is(err, errExportUploadStopped) {
+		ac.failWorkspaceExport(ctx, ref, err.Error())
+		return err
+	}
+	if uploadErr != nil {
+		ac.failWorkspaceExport(ctx, ref, "Failed to upload archive")
+		return fmt.Errorf("failed to upload export archive: %w", uploadErr)
+	}
+
+	files, folders := 0, 0
+	for _, e := range entries {
+		if e.Type == "folder" {
+			folders++
+		} else {
+			files++
+		}
+	}
+	now := NowISO8601()
+	if _, err := ref.Update(ctx, []docstore.Update{
+		{Path: "status", Value: exportStatusCompleted},
+		{Path: "workspace_version", Value: workspace.WorkspaceVersion},
+		{Path: "file_count", Value: files},
+		{Path: "folder_count", Value: folders},
+		{Path: "size_bytes", Value: size},
+		{Path: "updated_at", Value: now},
+		{Path: "completed_at", Value: now},
+	}); err != nil {
+		return fmt.Errorf("failed to complete workspace export: %w", err)
+	}
+	logCtx.WithFields(log.Fields{"files": files, "folders": folders, "size_bytes": size}).Info("Workspace export completed.")
+	return nil
+}
+
+// failWorkspaceExport marks an export failed. It uses a fresh deadline because the export's
+// own context may already have expired.
+func (ac *ApiController) failWorkspaceExport(ctx context.Context, ref *docstore.DocumentRef, message string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	now := NowISO8601()
+	if _, err := ref.Update(ctx, []docstore.Update{
+		{Path: "status", Value: exportStatusFailed},
+		{Path: "error", Value: message},
+		{Path: "updated_at", Value: now},
+		{Path: "completed_at", Value: now},
+	}); err != nil {
+		log.WithError(err).WithField("export_id", ref.ID).Error("Failed to mark workspace export as failed.")
+	}
+}
+
+// runWorkspaceExportExpiryMaintenance is the "workspace-export-expiry" maintenance task: it
+// deletes one batch of expired exports and their archives. Archives that cannot be deleted
+// are queued for the r2-deletions task. It also cleans up after purged workspaces, whose
+// purge leaves exports to expire.
+func (ac *ApiController) runWorkspaceExportExpiryMaintenance(ctx context.Context) (interface{}, error) {
+	docs, err := ac.FirestoreClient.Collection(workspaceExportsCollection).
+		Where(deleteAfterField, "<=", time.Now().UTC()).
+		OrderBy(deleteAfterField, docstore.Asc).
+		Limit(workspaceExportExpiryBatch).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load expired exports: %w", err)
+	}
+
+	expired := 0
+	for _, doc := range docs {
+		var export WorkspaceExport
+		if err := doc.DataTo(&export); err != nil {
+			log.WithError(err).WithField("export_id", doc.Ref.ID).Warn("Failed to parse workspace export.")
+			continue
+		}
+		if export.R2ObjectKey != "" {
+			if failures := ac.deleteR2Objects(ctx, []string{export.R2ObjectKey}); len(failures) > 0 {
+				ac.recordFailedR2Deletions(ctx, export.WorkspaceID, failures)
+			}
+		}
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			log.WithError(err).WithField("export_id", doc.Ref.ID).Warn("Failed to delete expired workspace export.")
+			continue
+		}
+		expired++
+	}
+	return gin.H{"scanned": len(docs), "expired": expired}, nil
+}
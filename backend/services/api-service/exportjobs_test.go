@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspaceExportEntries(t *testing.T) {
+	entries := workspaceExportEntries(exportTestManifest())
+	paths := make([]string, len(entries))
+	for i, e := range entries {
+		paths[i] = e.ArchivePath
+	}
+	assert.Equal(t, []string{
+		"data/input.csv", "main.py", "src", "src/notebooks",
+		"src/notebooks/a.ipynb", "src/notebooks/cache/b.bin", "src/notebooks/run.log",
+	}, paths)
+}
+
+// waitForExport polls the export until it leaves the queued and running states.
+func waitForExport(h *handlerHarness, userID, workspaceID, exportID string) WorkspaceExport {
+	h.t.Helper()
+	var export WorkspaceExport
+	assert.Eventually(h.t, func() bool {
+		h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/exports/"+exportID, userID, nil, &export)
+		return export.Status == exportStatusCompleted || export.Status == exportStatusFailed
+	}, 5*time.Second, 20*time.Millisecond)
+	return export
+}
+
+func TestHandlers_WorkspaceExportJob(t *testing.T) {
+	h := newHandlerHarness(t)
+	ctx := context.Background()
+	owner, viewer := "owner-"+uuid.New().String(), "viewer-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, viewer, roleViewer, "")
+	h.seedFile(workspaceID, "main.py", "print('hi')\n")
+	h.seedFile(workspaceID, "src/lib/util.py", "x = 1\n")
+	_, err := h.fs.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID)).Doc(SanitizePathToDocID("empty")).
+		Set(ctx, FileMetadata{FileID: uuid.New().String(), FilePath: "empty", Type: "folder"})
+	assert.NoError(t, err)
+	exportPath := "/api/workspaces/" + workspaceID + "/export"
+
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, exportPath, "stranger-"+uuid.New().String(), nil, nil).Code)
+	var queued WorkspaceExport
+	w := h.do(http.MethodPost, exportPath, viewer, nil, &queued)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.NotEmpty(t, queued.ExportID)
+
+	export := waitForExport(h, viewer, workspaceID, queued.ExportID)
+	assert.Equal(t, exportStatusCompleted, export.Status, export.Error)
+	assert.Equal(t, 2, export.FileCount)
+	assert.Equal(t, 1, export.FolderCount)
+	key := workspaceExportKey(workspaceID, queued.ExportID)
+	assert.Equal(t, "https://r2.test/get/"+key, export.DownloadURL)
+
+	archive := h.objects.objects[key]
+	assert.Equal(t, int64(len(archive)), export.SizeBytes)
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if assert.NoError(t, err) {
+		contents := map[string]string{}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			assert.NoError(t, err)
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			contents[f.Name] = string(data)
+		}
+		assert.Equal(t, map[string]string{
+			"empty/":          "",
+			"main.py":         "print('hi')\n",
+			"src/lib/util.py": "x = 1\n",
+		}, contents)
+	}
+
+	otherWorkspace := h.createWorkspace(owner)
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodGet, "/api/workspaces/"+otherWorkspace+"/exports/"+queued.ExportID, owner, nil, nil).Code)
+
+	// Once expired it can no longer be downloaded, and the sweep deletes it and its archive.
+	ref := h.fs.Collection(workspaceExportsCollection).Doc(queued.ExportID)
+	_, err = ref.Update(ctx, []docstore.Update{{Path: "delete_after", Value: time.Now().Add(-time.Minute).UTC()}})
+	assert.NoError(t, err)
+	statusPath := "/api/workspaces/" + workspaceID + "/exports/" + queued.ExportID
+	assert.Equal(t, http.StatusGone, h.do(http.MethodGet, statusPath, owner, nil, nil).Code)
+	_, err = h.ac.runWorkspaceExportExpiryMaintenance(ctx)
+	assert.NoError(t, err)
+	assert.False(t, h.objects.has(key))
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodGet, statusPath, owner, nil, nil).Code)
+}
+
+func TestHandlers_WorkspaceExportJobFailure(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	file := h.seedFile(workspaceID, "main.py", "print('hi')\n")
+	h.objects.mu.Lock()
+	delete(h.objects.objects, file.R2ObjectKey)
+	h.objects.mu.Unlock()
+
+	var queued WorkspaceExport
+	h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/export", owner, nil, &queued)
+	export := waitForExport(h, owner, workspaceID, queued.ExportID)
+	assert.Equal(t, exportStatusFailed, export.Status)
+	assert.True(t, strings.Contains(export.Error, "main.py"), export.Error)
+	assert.Empty(t, export.DownloadURL)
+	assert.Zero(t, h.objects.openUploads(), "the archive upload is aborted")
+	assert.False(t, h.objects.has(workspaceExportKey(workspaceID, queued.ExportID)))
+}
+
+func TestHandlers_WorkspaceExportJobSelection(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')\n")
+	h.seedFile(workspaceID, "src/lib/util.py", "x = 1\n")
+	h.seedFile(workspaceID, "src/lib/run.log", "noise\n")
+	exportPath := "/api/workspaces/" + workspaceID + "/export"
+
+	var refused struct {
+		UnknownPaths []string `json:"unknownPaths"`
+	}
+	w := h.do(http.MethodPost, exportPath+"?paths=src&paths=docs", owner, nil, &refused)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	assert.Equal(t, []string{"docs"}, refused.UnknownPaths)
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodPost, exportPath+"?exclude=*", owner, nil, nil).Code, "nothing left to export")
+
+	var queued WorkspaceExport
+	w = h.do(http.MethodPost, exportPath+"?paths=src/lib&exclude=*.log", owner, nil, &queued)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, []string{"src/lib"}, queued.Paths)
+	export := waitForExport(h, owner, workspaceID, queued.ExportID)
+	assert.Equal(t, exportStatusCompleted, export.Status, export.Error)
+	assert.Equal(t, 1, export.FileCount)
+
+	archive := h.objects.objects[workspaceExportKey(workspaceID, queued.ExportID)]
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if assert.NoError(t, err) {
+		names := make([]string, 0, len(zr.File))
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		assert.Equal(t, []string{"lib/util.py"}, names, "paths are rooted at the selected folder")
+	}
+	assert.Zero(t, h.objects.openUploads())
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	cloudtaskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithy "github.com/aws/smithy-go"
+	gax "github.com/googleapis/gax-go/v2"
+)
+
+// fakeObjectAPI is an in-memory ObjectAPI. An error set with failOn is returned by every call
+// to that operation until cleared with failOn(op, nil).
+type fakeObjectAPI struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	failures map[string]error
+	ranges   []string // Range headers of ranged GetObject calls, in order
+	batches  []int    // Number of keys in each DeleteObjects call, in order
+	unsized  bool     // GetObject leaves ContentLength unset, as a chunked response does
+
+	uploads map[string]*fakeMultipartUpload // Multipart uploads in progress, by upload ID
+	started int                             // Number of multipart uploads ever started
+	parts   []int                           // Number of parts in each completed multipart upload, in order
+}
+
+// fakeMultipartUpload is a multipart upload that has been started but not completed or aborted.
+type fakeMultipartUpload struct {
+	key   string
+	parts map[int32][]byte
+}
+
+func newFakeObjectAPI() *fakeObjectAPI {
+	return &fakeObjectAPI{objects: make(map[string][]byte), failures: make(map[string]error), uploads: make(map[string]*fakeMultipartUpload)}
+}
+
+// openUploads returns the number of multipart uploads neither completed nor aborted.
+func (f *fakeObjectAPI) openUploads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.uploads)
+}
+
+// failOn makes op ("PutObject", "DeleteObjects", ...) fail with err; nil clears it.
+func (f *fakeObjectAPI) failOn(op string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.failures, op)
+		return
+	}
+	f.failures[op] = err
+}
+
+// put stores an object directly, as a client upload through a presigned URL would.
+func (f *fakeObjectAPI) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = data
+}
+
+// has reports whether key is stored.
+func (f *fakeObjectAPI) has(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.objects[key]
+	return ok
+}
+
+func (f *fakeObjectAPI) PutObject(_ context.Context, params *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["PutObject"]; err != nil {
+		return nil, err
+	}
+	var data []byte
+	if params.Body != nil {
+		var err error
+		if data, err = io.ReadAll(params.Body); err != nil {
+			return nil, err
+		}
+	}
+	f.objects[aws.ToString(params.Key)] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeObjectAPI) GetObject(_ context.Context, params *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["GetObject"]; err != nil {
+		return nil, err
+	}
+	data, ok := f.objects[aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("no such key")}
+	}
+	if params.Range == nil {
+		out := &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}
+		if !f.unsized {
+			out.ContentLength = aws.Int64(int64(len(data)))
+		}
+		return out, nil
+	}
+	f.ranges = append(f.ranges, aws.ToString(params.Range))
+	return rangedObject(data, aws.ToString(params.Range))
+}
+
+func (f *fakeObjectAPI) HeadObject(_ context.Context, params *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["HeadObject"]; err != nil {
+		return nil, err
+	}
+	data, ok := f.objects[aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NotFound{Message: aws.String("not found")}
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(data)))}, nil
+}
+
+// CopyObject copies within the fake; CopySource is "bucket/key" with the key URL-escaped.
+func (f *fakeObjectAPI) CopyObject(_ context.Context, params *s3.CopyObjectInput, _ ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["CopyObject"]; err != nil {
+		return nil, err
+	}
+	_, escaped, _ := strings.Cut(aws.ToString(params.CopySource), "/")
+	source, err := url.PathUnescape(escaped)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := f.objects[source]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("no such key")}
+	}
+	f.objects[aws.ToString(params.Key)] = append([]byte(nil), data...)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+// rangedObject serves a "bytes=first-last" or "bytes=-suffix" range of data as R2 does.
+func rangedObject(data []byte, httpRange string) (*s3.GetObjectOutput, error) {
+	size := int64(len(data))
+	var first, last int64
+	if _, err := fmt.Sscanf(httpRange, "bytes=-%d", &last); err == nil {
+		first, last = max(size-last, 0), size-1
+	} else if _, err := fmt.Sscanf(httpRange, "bytes=%d-%d", &first, &last); err != nil {
+		return nil, fmt.Errorf("fake GetObject: unsupported range %q", httpRange)
+	}
+	last = min(last, size-1)
+	if first >= size || first > last {
+		return nil, &smithy.GenericAPIError{Code: "InvalidRange", Message: "The requested range is not satisfiable"}
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(data[first : last+1])),
+		ContentLength: aws.Int64(last - first + 1),
+		ContentRange:  aws.String(fmt.Sprintf("bytes %d-%d/%d", first, last, size)),
+	}, nil
+}
+
+func (f *fakeObjectAPI) DeleteObjects(_ context.Context, params *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, len(params.Delete.Objects))
+	if err := f.failures["DeleteObjects"]; err != nil {
+		return nil, err
+	}
+	for _, obj := range params.Delete.Objects {
+		delete(f.objects, aws.ToString(obj.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+// ListObjectsV2 returns every matching key in one page.
+func (f *fakeObjectAPI) ListObjectsV2(_ context.Context, params *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["ListObjectsV2"]; err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false), KeyCount: aws.Int32(int32(len(keys)))}
+	for _, key := range keys {
+		out.Contents = append(out.Contents, types.Object{Key: aws.String(key), Size: aws.Int64(int64(len(f.objects[key])))})
+	}
+	return out, nil
+}
+
+func (f *fakeObjectAPI) HeadBucket(_ context.Context, _ *s3.HeadBucketInput, _ ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["HeadBucket"]; err != nil {
+		return nil, err
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (f *fakeObjectAPI) CreateMultipartUpload(_ context.Context, params *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["CreateMultipartUpload"]; err != nil {
+		return nil, err
+	}
+	f.started++
+	uploadID := fmt.Sprintf("upload-%d", f.started)
+	f.uploads[uploadID] = &fakeMultipartUpload{key: aws.ToString(params.Key), parts: make(map[int32][]byte)}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(uploadID)}, nil
+}
+
+// UploadPart stores a part; its ETag is the part number.
+func (f *fakeObjectAPI) UploadPart(_ context.Context, params *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["UploadPart"]; err != nil {
+		return nil, err
+	}
+	upload, ok := f.uploads[aws.ToString(params.UploadId)]
+	if !ok {
+		return nil, &types.NoSuchUpload{Message: aws.String("no such upload")}
+	}
+	partNumber := aws.ToInt32(params.PartNumber)
+	upload.parts[partNumber] = data
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprint(partNumber))}, nil
+}
+
+// CompleteMultipartUpload stores the listed parts, in order, as the object.
+func (f *fakeObjectAPI) CompleteMultipartUpload(_ context.Context, params *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["CompleteMultipartUpload"]; err != nil {
+		return nil, err
+	}
+	uploadID := aws.ToString(params.UploadId)
+	upload, ok := f.uploads[uploadID]
+	if !ok {
+		return nil, &types.NoSuchUpload{Message: aws.String("no such upload")}
+	}
+	var data []byte
+	for _, part := range params.MultipartUpload.Parts {
+		partNumber := aws.ToInt32(part.PartNumber)
+		if aws.ToString(part.ETag) != fmt.Sprint(partNumber) {
+			return nil, fmt.Errorf("fake CompleteMultipartUpload: part %d has ETag %q", partNumber, aws.ToString(part.ETag))
+		}
+		data = append(data, upload.parts[partNumber]...)
+	}
+	f.objects[upload.key] = data
+	f.parts = append(f.parts, len(params.MultipartUpload.Parts))
+	delete(f.uploads, uploadID)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeObjectAPI) AbortMultipartUpload(_ context.Context, params *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["AbortMultipartUpload"]; err != nil {
+		return nil, err
+	}
+	delete(f.uploads, aws.ToString(params.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// fakePresigner returns predictable URLs naming the operation and key. A non-nil err fails
+// every call.
+type fakePresigner struct {
+	err error
+}
+
+func (p *fakePresigner) PresignGetObject(_ context.Context, params *s3.GetObjectInput, _ ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &v4.PresignedHTTPRequest{URL: "https://r2.test/get/" + aws.ToString(params.Key), Method: "GET"}, nil
+}
+
+func (p *fakePresigner) PresignPutObject(_ context.Context, params *s3.PutObjectInput, _ ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &v4.PresignedHTTPRequest{URL: "https://r2.test/put/" + aws.ToString(params.Key), Method: "PUT"}, nil
+}
+
+// fakeTaskEnqueuer records created and deleted tasks. A non-nil err fails every CreateTask
+// call and a non-nil deleteErr every DeleteTask call.
+type fakeTaskEnqueuer struct {
+	mu        sync.Mutex
+	err       error
+	deleteErr error
+	requests  []*cloudtaskspb.CreateTaskRequest
+	deleted   []string
+}
+
+func (f *fakeTaskEnqueuer) CreateTask(_ context.Context, req *cloudtaskspb.CreateTaskRequest, _ ...gax.CallOption) (*cloudtaskspb.Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.requests = append(f.requests, req)
+	return &cloudtaskspb.Task{Name: fmt.Sprintf("%s/tasks/%d", req.GetParent(), len(f.requests))}, nil
+}
+
+func (f *fakeTaskEnqueuer) DeleteTask(_ context.Context, req *cloudtaskspb.DeleteTaskRequest, _ ...gax.CallOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	f.deleted = append(f.deleted, req.GetName())
+	return nil
+}
+
+// deletedTasks returns the names of the deleted tasks.
+func (f *fakeTaskEnqueuer) deletedTasks() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.deleted...)
+}
+
+// queues returns the queue path of each created task, in creation order.
+func (f *fakeTaskEnqueuer) queues() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parents := make([]string, 0, len(f.requests))
+	for _, req := range f.requests {
+		parents = append(parents, req.GetParent())
+	}
+	return parents
+}
+
+// tasksTo returns the bodies of the tasks created for URLs ending in suffix.
+func (f *fakeTaskEnqueuer) tasksTo(suffix string) [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var bodies [][]byte
+	for _, req := range f.requests {
+		if h := req.GetTask().GetHttpRequest(); strings.HasSuffix(h.GetUrl(), suffix) {
+			bodies = append(bodies, h.GetBody())
+		}
+	}
+	return bodies
+}
+
+// fakeNotifier records the notifications it is asked to send. A non-nil err fails every call.
+type fakeNotifier struct {
+	mu          sync.Mutex
+	err         error
+	invitations []InvitationNotification
+	digests     []DigestNotification
+}
+
+func (n *fakeNotifier) NotifyInvitation(_ context.Context, notification InvitationNotification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	n.invitations = append(n.invitations, notification)
+	return nil
+}
+
+func (n *fakeNotifier) NotifyDigest(_ context.Context, notification DigestNotification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	n.digests = append(n.digests, notification)
+	return nil
+}
+
+// sentInvitations returns the invitation notifications sent so far.
+func (n *fakeNotifier) sentInvitations() []InvitationNotification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]InvitationNotification(nil), n.invitations...)
+}
+
+// failWith makes later calls fail with err, or succeed again when err is nil.
+func (n *fakeNotifier) failWith(err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.err = err
+}
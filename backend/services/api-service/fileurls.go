@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// fileURLTTL is how long presigned file download URLs stay valid.
+	fileURLTTL = 15 * time.Minute
+	// presignPoolSize bounds how many URLs are presigned at once across all requests.
+	presignPoolSize = 16
+	// firestoreInLimit is the most values a Firestore "in" filter accepts.
+	firestoreInLimit = 30
+)
+
+// Per-file outcomes reported by RefreshFileURLs when no URL could be issued.
+const (
+	fileURLNotFound      = "not_found"
+	fileURLNotAFile      = "not_a_file"
+	fileURLPresignFailed = "presign_failed"
+	fileURLInvalidKey    = "invalid_object_key"
+)
+
+// presignPool runs presign calls with bounded concurrency shared by all requests, so a large
+// manifest or refresh cannot monopolize the CPU signing URLs.
+type presignPool struct {
+	slots chan struct{}
+}
+
+func newPresignPool(size int) *presignPool {
+	return &presignPool{slots: make(chan struct{}, size)}
+}
+
+// each calls fn(i) for i in [0, n) on the pool and waits for all calls to return. When ctx
+// ends before every call has started, the rest are skipped and ctx's error is returned.
+func (p *presignPool) each(ctx context.Context, n int, fn func(i int)) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-p.slots }()
+			fn(i)
+		}()
+	}
+	return nil
+}
+
+// presignedURL is the outcome of presigning one object.
+type presignedURL struct {
+	URL       string
+	ExpiresAt time.Time
+	Err       error
+}
+
+// presignGetInput is one object to sign with presignGetObjects.
+type presignGetInput struct {
+	Key          string
+	TTL          time.Duration
+	CacheControl string // Response Cache-Control R2 sends with the content; empty keeps the object's own
+}
+
+// presignGetURLs presigns a download URL for each key, valid for fileURLTTL. Results are in
+// key order.
+func (ac *ApiController) presignGetURLs(ctx context.Context, keys []string) []presignedURL {
+	inputs := make([]presignGetInput, len(keys))
+	for i, key := range keys {
+		inputs[i] = presignGetInput{Key: key, TTL: fileURLTTL}
+	}
+	return ac.presignGetObjects(ctx, inputs)
+}
+
+// presignGetObjects presigns a download URL for each input. Results are in input order. The
+// whole batch uses one object store, so it is never split across a reload.
+func (ac *ApiController) presignGetObjects(ctx context.Context, inputs []presignGetInput) []presignedURL {
+	store := ac.r2()
+	results := make([]presignedURL, len(inputs))
+	err := ac.presigns.each(ctx, len(inputs), func(i int) {
+		in := inputs[i]
+		params := &s3.GetObjectInput{
+			Bucket: aws.String(ac.R2BucketName),
+			Key:    aws.String(in.Key),
+		}
+		if in.CacheControl != "" {
+			params.ResponseCacheControl = aws.String(in.CacheControl)
+		}
+		// Taken before signing, so the URL is valid at least until ExpiresAt.
+		expiresAt := time.Now().Add(in.TTL)
+		req, err := store.Presign.PresignGetObject(ctx, params, func(po *s3.PresignOptions) {
+			po.Expires = in.TTL
+		})
+		if err != nil {
+			results[i] = presignedURL{Err: err}
+			return
+		}
+		results[i] = presignedURL{URL: req.URL, ExpiresAt: expiresAt}
+	})
+	if err != nil {
+		for i := range results {
+			if results[i].URL == "" && results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+	}
+	return results
+}
+
+// downloadURLTTL returns how long a download URL for a file of size bytes stays valid. Extended
+// expiry applies only to files at or above the large-file threshold; smaller files download
+// quickly enough that a short-lived URL can simply be refreshed.
+func (cfg *AppConfig) downloadURLTTL(size int64, extended bool) time.Duration {
+	if extended && size >= cfg.LargeFileURLThresholdBytes && cfg.LargeFileURLTTL > fileURLTTL {
+		return cfg.LargeFileURLTTL
+	}
+	return fileURLTTL
+}
+
+// immutableCacheControl lets shared caches keep a download for as long as its URL is valid.
+// Once the URL expires, a fresh one is signed and cached separately.
+func immutableCacheControl(ttl time.Duration) string {
+	return fmt.Sprintf("public, max-age=%d, immutable", int64(ttl/time.Second))
+}
+
+// uniqueStrings returns values without duplicates, keeping the first occurrence of each.
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// chunkStrings splits values into consecutive chunks of at most size elements.
+func chunkStrings(values []string, size int) [][]string {
+	var chunks [][]string
+	for len(values) > size {
+		chunks = append(chunks, values[:size])
+		values = values[size:]
+	}
+	if len(values) > 0 {
+		chunks = append(chunks, values)
+	}
+	return chunks
+}
+
+// filesByID loads the workspace's file metadata for fileIDs, keyed by file ID. IDs without
+// a file are absent from the map.
+func (ac *ApiController) filesByID(ctx context.Context, workspaceID string, fileIDs []string) (map[string]FileMetadata, error) {
+	files := make(map[string]FileMetadata, len(fileIDs))
+	collection := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID))
+	for _, chunk := range chunkStrings(fileIDs, firestoreInLimit) {
+		docs, err := collection.Where("file_id", "in", chunk).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query file metadata: %w", err)
+		}
+		for _, doc := range docs {
+			var meta FileMetadata
+			if err := doc.DataTo(&meta); err != nil {
+				log.WithError(err).WithField("document_id", doc.Ref.ID).Warn("Failed to parse file metadata from Firestore document")
+				continue
+			}
+			files[meta.FileID] = meta
+		}
+	}
+	return files, nil
+}
+
+// RefreshFileURLs issues fresh download URLs for the given files, so editors can replace
+// expired manifest URLs without refetching the manifest. Files that are missing, are
+// folders, or could not be signed are reported per entry; the batch itself still succeeds.
+// Each issued URL reports its capabilities (range support, size, extended expiry, cache
+// policy) so clients can resume large downloads.
+func (ac *ApiController) RefreshFileURLs(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "RefreshFileURLs", "workspace_id": workspaceID, "user_id": userID})
+
+	var req RefreshFileURLsRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+
+	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Workspace membership check failed for RefreshFileURLs.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+		return
+	}
+
+	fileIDs := uniqueStrings(req.FileIDs)
+	files, err := ac.filesByID(ctx, workspaceID, fileIDs)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load files for URL refresh.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load files"})
+		return
+	}
+
+	results := make([]RefreshedFileURL, len(fileIDs))
+	var inputs []presignGetInput
+	var signed []int // Index into results of each input
+	for i, id := range fileIDs {
+		results[i].FileID = id
+		meta, ok := files[id]
+		switch {
+		case !ok:
+			results[i].Error = fileURLNotFound
+		case meta.Type != "file" || meta.R2ObjectKey == "":
+			results[i].FilePath = meta.FilePath
+			results[i].Error = fileURLNotAFile
+		case validateObjectKey(workspaceID, meta.Type, meta.FileID, meta.R2ObjectKey) != nil:
+			// Stored before keys were validated at commit time; never presign it.
+			results[i].FilePath = meta.FilePath
+			results[i].Error = fileURLInvalidKey
+			ac.recordSecurityEvent(ctx, securityInvalidObjectKey, userID, workspaceID, map[string]interface{}{
+				"source":        "refresh_file_urls",
+				"client_ip":     RealClientIP(c),
+				"file_path":     meta.FilePath,
+				"file_id":       meta.FileID,
+				"r2_object_key": meta.R2ObjectKey,
+			})
+		default:
+			results[i].FilePath = meta.FilePath
+			results[i].Size = meta.Size
+			input := presignGetInput{Key: meta.R2ObjectKey, TTL: ac.AppConfig.downloadURLTTL(meta.Size, req.ExtendedExpiry)}
+			if req.Immutable {
+				input.CacheControl = immutableCacheControl(input.TTL)
+			}
+			inputs = append(inputs, input)
+			signed = append(signed, i)
+		}
+	}
+
+	for j, url := range ac.presignGetObjects(ctx, inputs) {
+		result := &results[signed[j]]
+		if url.Err != nil {
+			logCtx.WithError(url.Err).WithField("file_id", result.FileID).Warn("Failed to generate R2 pre-signed GET URL for file")
+			result.Error = fileURLPresignFailed
+			continue
+		}
+		result.ContentURL = url.URL
+		result.URLExpiresAt = TimeToISO8601(url.ExpiresAt)
+		// R2 serves byte ranges for every object.
+		result.AcceptRanges = true
+		result.ExtendedExpiry = inputs[j].TTL > fileURLTTL
+		result.CacheControl = inputs[j].CacheControl
+	}
+
+	c.JSON(http.StatusOK, RefreshFileURLsResponse{Files: results})
+}
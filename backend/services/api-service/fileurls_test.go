@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresignPoolBoundsConcurrency(t *testing.T) {
+	pool := newPresignPool(3)
+	var running, peak atomic.Int32
+	done := make([]bool, 20)
+
+	err := pool.each(context.Background(), len(done), func(i int) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		done[i] = true
+		running.Add(-1)
+	})
+
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, peak.Load(), int32(3))
+	for i, ok := range done {
+		assert.True(t, ok, i)
+	}
+}
+
+func TestPresignPoolStopsWhenContextEnds(t *testing.T) {
+	pool := newPresignPool(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+
+	err := pool.each(ctx, 10, func(i int) {
+		calls.Add(1)
+		cancel()
+		time.Sleep(time.Millisecond)
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestUniqueStrings(t *testing.T) {
+	assert.Equal(t, []string{"b", "a", "c"}, uniqueStrings([]string{"b", "a", "b", "c", "a"}))
+	assert.Equal(t, []string{}, uniqueStrings(nil))
+}
+
+func TestChunkStrings(t *testing.T) {
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, chunkStrings([]string{"a", "b", "c", "d", "e"}, 2))
+	assert.Equal(t, [][]string{{"a", "b"}}, chunkStrings([]string{"a", "b"}, 2))
+	assert.Empty(t, chunkStrings(nil, 2))
+}
+
+func TestDownloadURLTTL(t *testing.T) {
+	cfg := &AppConfig{LargeFileURLThresholdBytes: 100 << 20, LargeFileURLTTL: 12 * time.Hour}
+
+	assert.Equal(t, fileURLTTL, cfg.downloadURLTTL(2<<30, false))
+	assert.Equal(t, fileURLTTL, cfg.downloadURLTTL(1<<20, true))
+	assert.Equal(t, 12*time.Hour, cfg.downloadURLTTL(100<<20, true))
+	assert.Equal(t, 12*time.Hour, cfg.downloadURLTTL(2<<30, true))
+
+	// Never shorter than the default.
+	cfg.LargeFileURLTTL = time.Minute
+	assert.Equal(t, fileURLTTL, cfg.downloadURLTTL(2<<30, true))
+}
+
+func TestImmutableCacheControl(t *testing.T) {
+	assert.Equal(t, "public, max-age=900, immutable", immutableCacheControl(fileURLTTL))
+	assert.Equal(t, "public, max-age=43200, immutable", immutableCacheControl(12*time.Hour))
+}
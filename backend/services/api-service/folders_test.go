@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFolderPathParam(t *testing.T) {
+	for param, want := range map[string]string{
+		"/src":          "src",
+		"/src/":         "src",
+		"/src/lib/util": "src/lib/util",
+	} {
+		got, ok := folderPathParam(param)
+		assert.True(t, ok, param)
+		assert.Equal(t, want, got, param)
+	}
+	for _, param := range []string{"/", "", "/..", "/../x", "/a/../b", "/a//b", "/./a"} {
+		_, ok := folderPathParam(param)
+		assert.False(t, ok, param)
+	}
+}
+
+func TestFolderAncestors(t *testing.T) {
+	assert.Empty(t, folderAncestors("src"))
+	assert.Equal(t, []string{"a"}, folderAncestors("a/b"))
+	assert.Equal(t, []string{"a", "a/b", "a/b/c"}, folderAncestors("a/b/c/d"))
+}
+
+func TestMovedPath(t *testing.T) {
+	assert.Equal(t, "lib", movedPath("src", "src", "lib"))
+	assert.Equal(t, "lib/main.py", movedPath("src/main.py", "src", "lib"))
+	assert.Equal(t, "pkg/lib/a/b.py", movedPath("src/a/b.py", "src", "pkg/lib"))
+}
+
+func TestNewFolderEntryHasNoObjectKey(t *testing.T) {
+	meta := newFolderEntry("src/lib", "7", "2026-01-01T00:00:00.000Z")
+	assert.Equal(t, "folder", meta.Type)
+	assert.Equal(t, "src/lib", meta.FilePath)
+	assert.Empty(t, meta.R2ObjectKey)
+	assert.NotEmpty(t, meta.FileID)
+	assert.Equal(t, "7", meta.Version)
+	assert.NoError(t, validateObjectKey("ws", meta.Type, meta.FileID, meta.R2ObjectKey))
+}
@@ -0,0 +1,1643 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testUserHeader carries the caller's user ID in handler tests, in place of a Firebase token.
+const testUserHeader = "X-Test-User"
+
+// testEmailHeader carries the caller's verified email in handler tests.
+const testEmailHeader = "X-Test-Email"
+
+// newTestDocStore returns the document store for a test: the Firestore emulator when
+// FIRESTORE_EMULATOR_HOST is set, otherwise an in-memory store, which is also returned.
+func newTestDocStore(t *testing.T, projectID string) (*docstore.Client, *docstore.Memory) {
+	t.Helper()
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		mem := docstore.NewMemory()
+		return mem.Client(), mem
+	}
+	fs, err := firestore.NewClient(context.Background(), projectID)
+	if err != nil {
+		t.Fatalf("firestore.NewClient: %v", err)
+	}
+	client := docstore.NewFirestore(fs)
+	t.Cleanup(func() { client.Close() })
+	return client, nil
+}
+
+// handlerHarness runs the real handlers against an in-memory document store, or the
+// Firestore emulator when FIRESTORE_EMULATOR_HOST is set, with in-memory fakes for R2 and
+// Cloud Tasks. Every test gets fresh workspace IDs and its own jobs collection.
+type handlerHarness struct {
+	t       *testing.T
+	ac      *ApiController
+	router  *gin.Engine
+	fs      *docstore.Client
+	mem     *docstore.Memory // nil on the emulator
+	objects *fakeObjectAPI
+	presign *fakePresigner
+	tasks   *fakeTaskEnqueuer
+}
+
+func newHandlerHarness(t *testing.T) *handlerHarness {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	fs, mem := newTestDocStore(t, "demo-handlers")
+
+	limits := defaultPythonLimits
+	cfg := &AppConfig{
+		GCPProjectID: "demo-handlers",
+		GCPRegion:    "test-region",
+		Services: ServicesConfig{
+			PythonWorker: ServiceConfig{
+				QueueID:        "python-queue",
+				ServiceURL:     "https://python-worker.test",
+				ServiceAccount: "worker@demo-handlers.iam.gserviceaccount.com",
+				Limits:         &limits,
+
+				DependencyManifests: defaultPythonDependencyManifests,
+			},
+		},
+		FirestoreJobsCollection: "jobs_" + uuid.New().String(),
+		R2BucketName:            "test-bucket",
+		Retention: RetentionConfig{
+			Jobs:             time.Hour,
+			UsageCounters:    time.Hour,
+			WorkspaceChanges: time.Hour,
+		},
+		JobDeadlineMin:                  10 * time.Second,
+		DestructiveSyncMaxDeletePercent: 50,
+		DestructiveSyncMaxDeleteCount:   100,
+		TaskPayloadMaxBytes:             1 << 20,
+		JobOutputInlineBytes:            256 << 10,
+		ScheduleMaxHorizon:              30 * 24 * time.Hour,
+		LargeFileURLThresholdBytes:      10,
+		LargeFileURLTTL:                 12 * time.Hour,
+		ListCursorSigningKey:            "test-cursor-key",
+		InvitationTTL:                   7 * 24 * time.Hour,
+		InvitationDeliveryMaxAttempts:   3,
+		WarmInterval:                    5 * time.Minute,
+	}
+
+	h := &handlerHarness{
+		t:       t,
+		fs:      fs,
+		mem:     mem,
+		objects: newFakeObjectAPI(),
+		presign: &fakePresigner{},
+		tasks:   &fakeTaskEnqueuer{},
+	}
+	background := NewBackgroundRunner(2, 16, 5*time.Second)
+	t.Cleanup(func() { background.Shutdown(context.Background()) })
+	store := &ObjectStore{S3: h.objects, Presign: h.presign, Credentials: r2CredentialsPrimary}
+	h.ac = NewApiController(fs, h.tasks, store, cfg.R2BucketName, cfg, cfg.FirestoreJobsCollection, background)
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if userID := c.GetHeader(testUserHeader); userID != "" {
+			c.Set("userID", userID)
+		}
+		if email := c.GetHeader(testEmailHeader); email != "" {
+			c.Set("userEmail", email)
+		}
+		c.Next()
+	})
+	api := r.Group("/api")
+	api.GET("/ws", h.ac.ServeLiveUpdates)
+	api.POST("/execute", h.ac.ExecuteCode)
+	api.GET("/jobs", h.ac.ListMyJobs)
+	api.GET("/jobs/:jobId", h.ac.GetJob)
+	api.POST("/workspaces", h.ac.CreateWorkspace)
+	api.GET("/workspaces", h.ac.ListWorkspaces)
+	api.GET("/workspace-templates", h.ac.ListWorkspaceTemplates)
+	api.POST("/workspaces/default", h.ac.CreateDefaultWorkspace)
+	api.PATCH("/workspaces/:workspaceId", h.ac.RenameWorkspace)
+	api.DELETE("/workspaces/:workspaceId", h.ac.DeleteWorkspace)
+	api.GET("/workspaces/:workspaceId/invitations", h.ac.ListInvitations)
+	api.POST("/workspaces/:workspaceId/invitations", h.ac.CreateInvitation)
+	api.POST("/workspaces/:workspaceId/invitations/bulk", h.ac.BulkCreateInvitations)
+	api.DELETE("/workspaces/:workspaceId/invitations/:invitationId", h.ac.RevokeInvitation)
+	api.POST("/invitations/accept", h.ac.AcceptInvitation)
+	api.POST("/invites/accept", h.ac.AcceptInvitation)
+	api.POST("/invites/:inviteId/accept", h.ac.AcceptInvitationByID)
+	api.POST("/workspaces/:workspaceId/invites", h.ac.CreateInvitation)
+	api.POST("/workspaces/:workspaceId/restore", h.ac.RestoreWorkspace)
+	api.GET("/workspace-deletions/:deletionId", h.ac.GetWorkspaceDeletion)
+	api.POST("/workspaces/:workspaceId/sync", h.ac.HandleSync)
+	api.POST("/workspaces/:workspaceId/sync/confirm", h.ac.ConfirmSync)
+	api.GET("/workspaces/:workspaceId/manifest", h.ac.GetWorkspaceManifest)
+	api.POST("/workspaces/:workspaceId/files/refresh-urls", h.ac.RefreshFileURLs)
+	api.POST("/workspaces/:workspaceId/folders", h.ac.CreateFolder)
+	api.PATCH("/workspaces/:workspaceId/folders/*path", h.ac.RenameFolder)
+	api.DELETE("/workspaces/:workspaceId/folders/*path", h.ac.DeleteFolder)
+	api.POST("/workspaces/:workspaceId/execute", h.ac.ExecuteCodeAuthenticated)
+	api.POST("/workspaces/:workspaceId/execute/warm", h.ac.WarmExecutionEnvironment)
+	api.GET("/workspaces/:workspaceId/jobs", h.ac.ListWorkspaceJobs)
+	api.GET("/workspaces/:workspaceId/jobs/metrics", h.ac.GetWorkspaceJobMetrics)
+	api.GET("/workspaces/:workspaceId/usage/export", h.ac.ExportWorkspaceUsage)
+	api.GET("/workspaces/:workspaceId/export", h.ac.ExportWorkspace)
+	api.POST("/workspaces/:workspaceId/export", h.ac.CreateWorkspaceExport)
+	api.GET("/workspaces/:workspaceId/exports/:exportId", h.ac.GetWorkspaceExport)
+	api.GET("/workspaces/:workspaceId/jobs/:jobId", h.ac.GetWorkspaceJob)
+	api.GET("/workspaces/:workspaceId/jobs/:jobId/events", h.ac.StreamWorkspaceJobEvents)
+	api.DELETE("/workspaces/:workspaceId/jobs/:jobId", h.ac.CancelWorkspaceJob)
+	api.POST("/workspaces/:workspaceId/jobs/:jobId/retry", h.ac.RetryWorkspaceJob)
+	api.POST("/workspaces/:workspaceId/jobs/:jobId/share", h.ac.ShareWorkspaceJob)
+	api.DELETE("/workspaces/:workspaceId/jobs/:jobId/share", h.ac.RevokeWorkspaceJobShare)
+	api.GET("/shared/jobs/:token", h.ac.GetSharedJob)
+	api.GET("/shared/:token/manifest", h.ac.GetSharedWorkspaceManifest)
+	api.POST("/workspaces/:workspaceId/share-links", h.ac.CreateWorkspaceShareLink)
+	api.DELETE("/workspaces/:workspaceId/share-links/:linkId", h.ac.RevokeWorkspaceShareLink)
+	api.PUT("/workspaces/:workspaceId/read-only", h.ac.SetWorkspaceReadOnly)
+	api.PUT("/workspaces/:workspaceId/settings/destructive-sync", h.ac.SetDestructiveSyncSettings)
+	api.PUT("/workspaces/:workspaceId/settings/concurrency", h.ac.SetConcurrencySettings)
+	api.GET("/workspaces/:workspaceId/settings", h.ac.GetWorkspaceSettings)
+	api.PUT("/workspaces/:workspaceId/settings", h.ac.UpdateWorkspaceSettings)
+	api.GET("/workspaces/:workspaceId/members", h.ac.ListWorkspaceMembers)
+	api.PATCH("/workspaces/:workspaceId/members/:userId", h.ac.UpdateWorkspaceMember)
+	api.POST("/workspaces/:workspaceId/transfer-ownership", h.ac.TransferWorkspaceOwnership)
+	api.POST("/workspaces/:workspaceId/leave", h.ac.LeaveWorkspace)
+	api.POST("/workspaces/:workspaceId/clone", h.ac.CloneWorkspace)
+	api.GET("/workspaces/:workspaceId/clones/:cloneJobId", h.ac.GetCloneJob)
+	api.POST("/workspaces/:workspaceId/clones/:cloneJobId/resume", h.ac.ResumeCloneJob)
+	api.POST("/admin/jobs/backfill-expiry", h.ac.BackfillJobExpiry)
+	api.GET("/admin/jobs/archives", h.ac.ListJobArchives)
+	api.POST("/admin/workspaces/backfill-storage", h.ac.BackfillWorkspaceStorage)
+	api.GET("/workspaces/:workspaceId/stats", h.ac.GetWorkspaceStats)
+	api.GET("/admin/usage/top", h.ac.ListTopConsumers)
+	api.GET("/admin/jobs", h.ac.ListAdminJobs)
+	api.POST("/admin/jobs/requeue", h.ac.RequeueJobs)
+	api.POST("/admin/jobs/:id/fail", h.ac.ForceFailJob)
+	api.POST("/admin/maintenance/:task", h.ac.RunMaintenanceTask)
+	r.POST("/internal/jobs/:jobId/finished", h.ac.HandleJobFinished)
+	r.POST("/internal/notifications/invitations/:invitationId", h.ac.HandleInvitationDeliveryCallback)
+	h.router = r
+	return h
+}
+
+// do sends a JSON request as userID (anonymous when empty) and decodes the response into out
+// when it is non-nil.
+func (h *handlerHarness) do(method, path, userID string, body, out interface{}) *httptest.ResponseRecorder {
+	h.t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			h.t.Fatalf("encode request: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if userID != "" {
+		req.Header.Set(testUserHeader, userID)
+	}
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			h.t.Fatalf("decode response %q: %v", w.Body.String(), err)
+		}
+	}
+	return w
+}
+
+// createWorkspace creates a workspace owned by userID through the handler.
+func (h *handlerHarness) createWorkspace(userID string) string {
+	h.t.Helper()
+	var resp CreateWorkspaceResponse
+	w := h.do(http.MethodPost, "/api/workspaces", userID, CreateWorkspaceRequest{Name: "Test " + uuid.New().String()}, &resp)
+	if w.Code != http.StatusCreated {
+		h.t.Fatalf("create workspace: %d %s", w.Code, w.Body.String())
+	}
+	return resp.WorkspaceID
+}
+
+// injectFault fails each document store operation fn returns an error for. Tests using it
+// are skipped on the emulator, which cannot fail operations on demand.
+func (h *handlerHarness) injectFault(fn func(op docstore.Op) error) {
+	h.t.Helper()
+	if h.mem == nil {
+		h.t.Skip("fault injection needs the in-memory document store")
+	}
+	h.mem.InjectFault(fn)
+	h.t.Cleanup(func() { h.mem.InjectFault(nil) })
+}
+
+// seedFile stores a committed file directly, bypassing sync, and returns its metadata.
+func (h *handlerHarness) seedFile(workspaceID, filePath, content string) FileMetadata {
+	h.t.Helper()
+	fileID := uuid.New().String()
+	meta := FileMetadata{
+		FileID:      fileID,
+		FilePath:    filePath,
+		Type:        "file",
+		R2ObjectKey: fmt.Sprintf("workspaces/%s/files/%s/%s", workspaceID, fileID, path.Base(filePath)),
+		Size:        int64(len(content)),
+		Hash:        "seeded-" + fileID,
+		CreatedAt:   NowISO8601(),
+		UpdatedAt:   NowISO8601(),
+		Version:     "1",
+	}
+	h.objects.put(meta.R2ObjectKey, []byte(content))
+	_, err := h.fs.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID)).Doc(SanitizePathToDocID(filePath)).Set(context.Background(), meta)
+	if err != nil {
+		h.t.Fatalf("seed file: %v", err)
+	}
+	_, err = h.fs.Collection("workspaces").Doc(workspaceID).Update(context.Background(), []docstore.Update{
+		{Path: "storage_bytes", Value: docstore.Increment(meta.Size)},
+	})
+	if err != nil {
+		h.t.Fatalf("seed storage bytes: %v", err)
+	}
+	return meta
+}
+
+// seedMembership adds userID to a workspace directly, bypassing invitations.
+func (h *handlerHarness) seedMembership(workspaceID, userID, role, expiresAt string) {
+	h.t.Helper()
+	membership := WorkspaceMembership{
+		MembershipID: uuid.New().String(),
+		WorkspaceID:  workspaceID,
+		UserID:       userID,
+		Role:         role,
+		JoinedAt:     NowISO8601(),
+		ExpiresAt:    expiresAt,
+	}
+	if _, err := h.fs.Collection("workspace_memberships").Doc(membership.MembershipID).Set(context.Background(), membership); err != nil {
+		h.t.Fatalf("seed membership: %v", err)
+	}
+}
+
+// syncNewFile plans an upload of a new file from version and returns its action and the
+// tentative version.
+func (h *handlerHarness) syncNewFile(workspaceID, userID, version, filePath string) (SyncResponseFileAction, string) {
+	h.t.Helper()
+	var resp SyncResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync", userID, SyncRequest{
+		WorkspaceVersion: version,
+		Files:            []SyncFileClientState{{FilePath: filePath, Type: "file", ClientHash: "hash-" + filePath, Action: "new"}},
+	}, &resp)
+	if w.Code != http.StatusOK || resp.Status != "pending_confirmation" || len(resp.Actions) != 1 {
+		h.t.Fatalf("sync %s: %d %s", filePath, w.Code, w.Body.String())
+	}
+	return resp.Actions[0], resp.NewWorkspaceVersion
+}
+
+// upsertAction turns a planned upload into the confirm-step action for it.
+func upsertAction(action SyncResponseFileAction, size int64) FileAction {
+	return FileAction{
+		FilePath:    action.FilePath,
+		Type:        action.Type,
+		FileID:      action.FileID,
+		R2ObjectKey: action.R2ObjectKey,
+		Action:      "upsert",
+		ClientHash:  "hash-" + action.FilePath,
+		Size:        &size,
+	}
+}
+
+func TestHandlers_CreateAndListWorkspaces(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, other := "owner-"+uuid.New().String(), "other-"+uuid.New().String()
+
+	workspaceID := h.createWorkspace(owner)
+
+	var owned []WorkspaceSummary
+	w := h.do(http.MethodGet, "/api/workspaces", owner, nil, &owned)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Len(t, owned, 1) {
+		assert.Equal(t, workspaceID, owned[0].WorkspaceID)
+		assert.Equal(t, "owner", owned[0].UserRole)
+	}
+
+	var others []WorkspaceSummary
+	w = h.do(http.MethodGet, "/api/workspaces", other, nil, &others)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, others)
+}
+
+func TestHandlers_ListWorkspacesBatchesReads(t *testing.T) {
+	h := newHandlerHarness(t)
+	user := "user-" + uuid.New().String()
+	const n = 150
+	for i := 0; i < n; i++ {
+		workspaceID := uuid.New().String()
+		_, err := h.fs.Collection("workspaces").Doc(workspaceID).Set(context.Background(), Workspace{
+			WorkspaceID: workspaceID,
+			Name:        fmt.Sprintf("Assignment %d", i),
+			CreatedBy:   "teacher",
+			CreatedAt:   NowISO8601(),
+		})
+		if err != nil {
+			t.Fatalf("seed workspace: %v", err)
+		}
+		h.seedMembership(workspaceID, user, roleEditor, "")
+	}
+	h.seedMembership("missing-"+uuid.New().String(), user, roleEditor, "")
+
+	var batches, reads int
+	getAll := h.ac.getAll
+	h.ac.getAll = func(ctx context.Context, refs []*docstore.DocumentRef) ([]*docstore.DocumentSnapshot, error) {
+		batches++
+		reads += len(refs)
+		return getAll(ctx, refs)
+	}
+
+	var listed []WorkspaceSummary
+	w := h.do(http.MethodGet, "/api/workspaces", user, nil, &listed)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, listed, n, "the membership of a missing workspace is skipped")
+	assert.Equal(t, (n+1+workspaceGetAllChunk-1)/workspaceGetAllChunk, batches, "one batched read per chunk, not one per membership")
+	assert.Equal(t, n+1, reads)
+}
+
+func TestHandlers_CreateWorkspaceValidation(t *testing.T) {
+	h := newHandlerHarness(t)
+
+	w := h.do(http.MethodPost, "/api/workspaces", "user-1", CreateWorkspaceRequest{Name: "   "}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = h.do(http.MethodPost, "/api/workspaces", "", CreateWorkspaceRequest{Name: "Anonymous"}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHandlers_CreateWorkspaceStoreFailure(t *testing.T) {
+	h := newHandlerHarness(t)
+	user := "user-" + uuid.New().String()
+	h.injectFault(func(op docstore.Op) error {
+		if op.Kind == "create" && strings.HasPrefix(op.Path, "workspace_memberships/") {
+			return status.Error(codes.Unavailable, "injected")
+		}
+		return nil
+	})
+
+	w := h.do(http.MethodPost, "/api/workspaces", user, CreateWorkspaceRequest{Name: "Physics"}, nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	var listed []WorkspaceSummary
+	w = h.do(http.MethodGet, "/api/workspaces", user, nil, &listed)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, listed, "the workspace and its membership are written together or not at all")
+	docs, err := h.fs.Collection("workspaces").Where("created_by", "==", user).Documents(context.Background()).GetAll()
+	assert.NoError(t, err)
+	assert.Empty(t, docs)
+}
+
+func TestHandlers_RenameWorkspaceStoreFailure(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	snap, err := h.fs.Collection("workspaces").Doc(workspaceID).Get(context.Background())
+	assert.NoError(t, err)
+	name, _ := snap.DataAt("name")
+
+	h.injectFault(func(op docstore.Op) error {
+		if op.Kind == "update" && op.Path == "workspaces/"+workspaceID {
+			return status.Error(codes.Unavailable, "injected")
+		}
+		return nil
+	})
+	w := h.do(http.MethodPatch, "/api/workspaces/"+workspaceID, owner, RenameWorkspaceRequest{Name: "Renamed"}, nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	snap, err = h.fs.Collection("workspaces").Doc(workspaceID).Get(context.Background())
+	assert.NoError(t, err)
+	got, _ := snap.DataAt("name")
+	assert.Equal(t, name, got)
+}
+
+func TestHandlers_SyncConfirmAndManifest(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+
+	action, version := h.syncNewFile(workspaceID, owner, "1", "main.py")
+	assert.Equal(t, "upload", action.ActionRequired)
+	assert.Equal(t, "2", version)
+	assert.Equal(t, "https://r2.test/put/"+action.R2ObjectKey, action.PresignedURL)
+	h.objects.put(action.R2ObjectKey, []byte("print('hi')"))
+
+	var confirm ConfirmSyncResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync/confirm", owner, ConfirmSyncRequest{
+		WorkspaceVersion: version,
+		SyncActions:      []FileAction{upsertAction(action, 11)},
+	}, &confirm)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "success", confirm.Status)
+	assert.Equal(t, "2", confirm.FinalWorkspaceVersion)
+	if assert.NotNil(t, confirm.Totals) {
+		assert.Equal(t, 1, confirm.Totals.FilesUpserted)
+		assert.Equal(t, int64(11), confirm.Totals.BytesAdded)
+	}
+
+	var manifest WorkspaceManifestResponse
+	w = h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/manifest", owner, nil, &manifest)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", manifest.WorkspaceVersion)
+	if assert.Len(t, manifest.Manifest, 1) {
+		assert.Equal(t, "main.py", manifest.Manifest[0].FilePath)
+		assert.Equal(t, "https://r2.test/get/"+action.R2ObjectKey, manifest.Manifest[0].ContentURL)
+	}
+}
+
+func TestHandlers_RefreshFileURLsCapabilities(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	small := h.seedFile(workspaceID, "small.py", "x")
+	large := h.seedFile(workspaceID, "data.csv", "a,b,c\n1,2,3\n")
+
+	var resp RefreshFileURLsResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/files/refresh-urls", owner, RefreshFileURLsRequest{
+		FileIDs:        []string{small.FileID, large.FileID},
+		ExtendedExpiry: true,
+		Immutable:      true,
+	}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if assert.Len(t, resp.Files, 2) {
+		assert.True(t, resp.Files[0].AcceptRanges)
+		assert.Equal(t, int64(1), resp.Files[0].Size)
+		assert.False(t, resp.Files[0].ExtendedExpiry)
+		assert.Equal(t, immutableCacheControl(fileURLTTL), resp.Files[0].CacheControl)
+
+		assert.True(t, resp.Files[1].AcceptRanges)
+		assert.Equal(t, large.Size, resp.Files[1].Size)
+		assert.True(t, resp.Files[1].ExtendedExpiry)
+		assert.Equal(t, immutableCacheControl(12*time.Hour), resp.Files[1].CacheControl)
+		expiresAt, err := time.Parse(time.RFC3339, resp.Files[1].URLExpiresAt)
+		if assert.NoError(t, err) {
+			assert.WithinDuration(t, time.Now().Add(12*time.Hour), expiresAt, time.Minute)
+		}
+	}
+}
+
+// manifestPaths returns the manifest's entry paths mapped to their types.
+func (h *handlerHarness) manifestPaths(workspaceID, userID string) map[string]string {
+	h.t.Helper()
+	var manifest WorkspaceManifestResponse
+	if w := h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/manifest", userID, nil, &manifest); w.Code != http.StatusOK {
+		h.t.Fatalf("manifest: %d %s", w.Code, w.Body.String())
+	}
+	paths := make(map[string]string, len(manifest.Manifest))
+	for _, entry := range manifest.Manifest {
+		paths[entry.FilePath] = entry.Type
+	}
+	return paths
+}
+
+func TestHandlers_FolderLifecycle(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	folders := "/api/workspaces/" + workspaceID + "/folders"
+
+	var created FolderResponse
+	w := h.do(http.MethodPost, folders, owner, CreateFolderRequest{Path: "src/lib"}, &created)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "2", created.WorkspaceVersion)
+	assert.Equal(t, []string{"src"}, created.CreatedFolders)
+	if assert.NotNil(t, created.Folder) {
+		assert.Equal(t, "src/lib", created.Folder.FilePath)
+		assert.Empty(t, created.Folder.R2ObjectKey)
+	}
+	assert.Equal(t, http.StatusConflict, h.do(http.MethodPost, folders, owner, CreateFolderRequest{Path: "src/lib"}, nil).Code)
+
+	file := h.seedFile(workspaceID, "src/lib/main.py", "print(1)")
+	assert.Equal(t, http.StatusConflict, h.do(http.MethodPost, folders, owner, CreateFolderRequest{Path: "src/lib/main.py/x"}, nil).Code)
+	assert.Equal(t, http.StatusConflict, h.do(http.MethodDelete, folders+"/src", owner, nil, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodDelete, folders+"/src/lib/main.py", owner, nil, nil).Code)
+
+	var renamed FolderResponse
+	w = h.do(http.MethodPatch, folders+"/src", owner, RenameFolderRequest{NewPath: "pkg/code"}, &renamed)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "3", renamed.WorkspaceVersion)
+	assert.Equal(t, 3, renamed.EntriesMoved)
+	assert.Equal(t, []string{"pkg"}, renamed.CreatedFolders)
+	assert.Equal(t, map[string]string{
+		"pkg":                  "folder",
+		"pkg/code":             "folder",
+		"pkg/code/lib":         "folder",
+		"pkg/code/lib/main.py": "file",
+	}, h.manifestPaths(workspaceID, owner))
+
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodPatch, folders+"/pkg", owner, RenameFolderRequest{NewPath: "pkg/code/inner"}, nil).Code)
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodPatch, folders+"/src", owner, RenameFolderRequest{NewPath: "other"}, nil).Code)
+
+	var deleted FolderResponse
+	w = h.do(http.MethodDelete, folders+"/pkg?recursive=true", owner, nil, &deleted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 4, deleted.EntriesDeleted)
+	assert.Empty(t, h.manifestPaths(workspaceID, owner))
+	assert.False(t, h.objects.has(file.R2ObjectKey))
+}
+
+func TestHandlers_FoldersDenyNonMembers(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	outsider := "outsider-" + uuid.New().String()
+	folders := "/api/workspaces/" + workspaceID + "/folders"
+
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, folders, outsider, CreateFolderRequest{Path: "src"}, nil).Code)
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPatch, folders+"/src", outsider, RenameFolderRequest{NewPath: "lib"}, nil).Code)
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodDelete, folders+"/src", outsider, nil, nil).Code)
+}
+
+func TestHandlers_SyncDeniesNonMembers(t *testing.T) {
+	h := newHandlerHarness(t)
+	workspaceID := h.createWorkspace("owner-" + uuid.New().String())
+	outsider := "outsider-" + uuid.New().String()
+
+	sync := SyncRequest{WorkspaceVersion: "1", Files: []SyncFileClientState{{FilePath: "a.py", Type: "file", Action: "new"}}}
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync", outsider, sync, nil).Code)
+
+	confirm := ConfirmSyncRequest{WorkspaceVersion: "2", SyncActions: []FileAction{}}
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync/confirm", outsider, confirm, nil).Code)
+
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/manifest", outsider, nil, nil).Code)
+}
+
+func TestHandlers_SyncVersionConflict(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+
+	// Another client commits main.py at version 2.
+	action, version := h.syncNewFile(workspaceID, owner, "1", "main.py")
+	h.objects.put(action.R2ObjectKey, []byte("x"))
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync/confirm", owner, ConfirmSyncRequest{
+		WorkspaceVersion: version,
+		SyncActions:      []FileAction{upsertAction(action, 1)},
+	}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm: %d %s", w.Code, w.Body.String())
+	}
+
+	// A client still at version 1 that touches main.py conflicts...
+	var resp SyncResponse
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync", owner, SyncRequest{
+		WorkspaceVersion: "1",
+		Files:            []SyncFileClientState{{FilePath: "main.py", Type: "file", ClientHash: "mine", Action: "modified"}},
+	}, &resp)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "workspace_conflict", resp.Status)
+	assert.Equal(t, "2", resp.NewWorkspaceVersion)
+	assert.Equal(t, []string{"main.py"}, resp.ConflictingPaths)
+
+	// ...while one touching another path is rebased onto version 2.
+	resp = SyncResponse{}
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync", owner, SyncRequest{
+		WorkspaceVersion: "1",
+		Files:            []SyncFileClientState{{FilePath: "other.py", Type: "file", ClientHash: "mine", Action: "new"}},
+	}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, resp.AutoRebased)
+	assert.Equal(t, "3", resp.NewWorkspaceVersion)
+
+	// A confirm for a version the server is not about to reach is rejected.
+	var confirm ConfirmSyncResponse
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync/confirm", owner, ConfirmSyncRequest{
+		WorkspaceVersion: "7",
+		SyncActions:      []FileAction{upsertAction(action, 1)},
+	}, &confirm)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "error", confirm.Status)
+}
+
+func TestHandlers_SyncDryRun(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	kept := h.seedFile(workspaceID, "keep.py", "print('keep')")
+	h.seedFile(workspaceID, "old.py", "12345")
+	before, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	if err != nil {
+		t.Fatalf("load workspace: %v", err)
+	}
+
+	var resp SyncResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync?dryRun=true", owner, SyncRequest{
+		WorkspaceVersion: "1",
+		Files: []SyncFileClientState{
+			{FilePath: "main.py", Type: "file", ClientHash: "h", Action: "new", Size: 100},
+			{FilePath: "src", Type: "folder", Action: "new"},
+			{FilePath: "keep.py", Type: "file", ClientHash: kept.Hash, Action: "modified", Size: kept.Size},
+			{FilePath: "old.py", Type: "file", Action: "deleted"},
+		},
+	}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "dry_run", resp.Status)
+	assert.Empty(t, resp.NewWorkspaceVersion, "a dry run reserves no version")
+	if assert.NotNil(t, resp.Summary) {
+		assert.Equal(t, SyncPlanSummary{Uploads: 2, Deletes: 1, Unchanged: 1, UploadBytes: 100, DeleteBytes: 5}, *resp.Summary)
+	}
+	if assert.Len(t, resp.Actions, 4) {
+		assert.Equal(t, "upload", resp.Actions[0].ActionRequired)
+		assert.Empty(t, resp.Actions[0].FileID, "a dry run mints no IDs")
+		assert.Equal(t, "none", resp.Actions[2].ActionRequired)
+		assert.Equal(t, "delete", resp.Actions[3].ActionRequired)
+	}
+	for _, action := range resp.Actions {
+		assert.Empty(t, action.PresignedURL, action.FilePath)
+	}
+
+	// Nothing was reserved: the workspace is untouched and a real sync still targets version 2.
+	after, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.Len(t, h.manifestPaths(workspaceID, owner), 2)
+	_, version := h.syncNewFile(workspaceID, owner, "1", "main.py")
+	assert.Equal(t, "2", version)
+}
+
+func TestHandlers_SyncPresignFailure(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.presign.err = errors.New("presign unavailable")
+
+	var resp SyncResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync", owner, SyncRequest{
+		WorkspaceVersion: "1",
+		Files:            []SyncFileClientState{{FilePath: "main.py", Type: "file", ClientHash: "h", Action: "new"}},
+	}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no_changes", resp.Status)
+	if assert.Len(t, resp.Actions, 1) {
+		assert.Equal(t, "none", resp.Actions[0].ActionRequired)
+		assert.Equal(t, "Error generating upload URL", resp.Actions[0].Message)
+	}
+}
+
+func TestHandlers_ConfirmSyncQueuesFailedObjectDeletes(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	file := h.seedFile(workspaceID, "old.py", "print('old')")
+	h.objects.failOn("DeleteObjects", errors.New("r2 unavailable"))
+
+	var confirm ConfirmSyncResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync/confirm", owner, ConfirmSyncRequest{
+		WorkspaceVersion: "2",
+		SyncActions: []FileAction{{
+			FilePath:    file.FilePath,
+			Type:        "file",
+			FileID:      file.FileID,
+			R2ObjectKey: file.R2ObjectKey,
+			Action:      "delete",
+		}},
+	}, &confirm)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "success", confirm.Status)
+	assert.True(t, h.objects.has(file.R2ObjectKey))
+
+	snap, err := h.fs.Collection(pendingR2DeletionsCollection).Doc(pendingR2DeletionDocID(file.R2ObjectKey)).Get(context.Background())
+	if assert.NoError(t, err) {
+		var pending PendingR2Deletion
+		assert.NoError(t, snap.DataTo(&pending))
+		assert.Equal(t, workspaceID, pending.WorkspaceID)
+		assert.Equal(t, 1, pending.Attempts)
+	}
+}
+
+func TestHandlers_ConfirmSyncDestructiveGuard(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+
+	// The global defaults (more than 100 files) can never trip on a workspace this small.
+	w := h.do(http.MethodPut, "/api/workspaces/"+workspaceID+"/settings/destructive-sync", owner, DestructiveSyncSettings{MaxDeleteCount: 2}, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = h.do(http.MethodPut, "/api/workspaces/"+workspaceID+"/settings/destructive-sync", "stranger-"+uuid.New().String(), DestructiveSyncSettings{MaxDeleteCount: 50}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var deletes []FileAction
+	for _, name := range []string{"a.py", "b.py", "c.py", "d.py"} {
+		file := h.seedFile(workspaceID, name, "print(1)")
+		deletes = append(deletes, FileAction{FilePath: file.FilePath, Type: "file", FileID: file.FileID, R2ObjectKey: file.R2ObjectKey, Action: "delete"})
+	}
+	confirmPath := "/api/workspaces/" + workspaceID + "/sync/confirm"
+
+	// Three of four files is over both the count (2) and the default 50%.
+	var confirm ConfirmSyncResponse
+	w = h.do(http.MethodPost, confirmPath, owner, ConfirmSyncRequest{WorkspaceVersion: "2", SyncActions: deletes[:3]}, &confirm)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, syncDestructiveConfirmationRequired, confirm.Status)
+	if assert.NotNil(t, confirm.DestructiveChange) {
+		assert.Equal(t, DestructiveChange{FilesDeleted: 3, WorkspaceFiles: 4, MaxDeletePercent: 50, MaxDeleteCount: 2}, *confirm.DestructiveChange)
+	}
+	assert.Len(t, h.manifestPaths(workspaceID, owner), 4)
+
+	confirm = ConfirmSyncResponse{}
+	w = h.do(http.MethodPost, confirmPath, owner, ConfirmSyncRequest{WorkspaceVersion: "2", SyncActions: deletes[:3], ConfirmDestructive: true}, &confirm)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "success", confirm.Status)
+	assert.NotNil(t, confirm.DestructiveChange)
+	assert.Len(t, h.manifestPaths(workspaceID, owner), 1)
+
+	// Both the rejection and the confirmed commit are recorded.
+	events, err := h.fs.Collection(securityEventsCollection).
+		Where("workspace_id", "==", workspaceID).
+		Where("type", "==", securityDestructiveSync).
+		Documents(context.Background()).GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	activity, err := h.ac.activityCollection(workspaceID).Where("type", "==", activityDestructiveSync).Documents(context.Background()).GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, activity, 2)
+}
+
+func TestHandlers_WorkspaceReadOnly(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, editor := "owner-"+uuid.New().String(), "editor-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, editor, roleEditor, "")
+	freeze := func(userID string, readOnly bool) int {
+		return h.do(http.MethodPut, "/api/workspaces/"+workspaceID+"/read-only", userID, SetReadOnlyRequest{ReadOnly: &readOnly}, nil).Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, freeze(editor, true))
+	ws, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	assert.NoError(t, err)
+	assert.False(t, ws.ReadOnly, "a rejected toggle changes nothing")
+
+	// A sync planned before the freeze cannot commit after it.
+	action, version := h.syncNewFile(workspaceID, editor, "1", "main.py")
+	h.objects.put(action.R2ObjectKey, []byte("print('hi')"))
+	assert.Equal(t, http.StatusOK, freeze(owner, true))
+	var confirm ConfirmSyncResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync/confirm", editor, ConfirmSyncRequest{
+		WorkspaceVersion: version,
+		SyncActions:      []FileAction{upsertAction(action, 11)},
+	}, &confirm)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, "workspace_read_only", confirm.Status)
+	assert.Empty(t, h.manifestPaths(workspaceID, owner))
+
+	var sync SyncResponse
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync", editor, SyncRequest{
+		WorkspaceVersion: "1",
+		Files:            []SyncFileClientState{{FilePath: "main.py", Type: "file", ClientHash: "hash-main.py", Action: "new"}},
+	}, &sync)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, "workspace_read_only", sync.Status)
+
+	assert.Equal(t, http.StatusOK, freeze(owner, false))
+	h.syncNewFile(workspaceID, editor, "1", "main.py")
+
+	events, err := h.ac.activityCollection(workspaceID).Where("type", "==", activityReadOnlyChanged).Documents(context.Background()).GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	for _, doc := range events {
+		var event ActivityEvent
+		assert.NoError(t, doc.DataTo(&event))
+		assert.Equal(t, owner, event.ActorID)
+		assert.Contains(t, event.Details, "read_only")
+	}
+}
+
+func TestHandlers_ExecuteCode(t *testing.T) {
+	h := newHandlerHarness(t)
+
+	var resp map[string]string
+	w := h.do(http.MethodPost, "/api/execute", "", RequestBody{Code: "print(1)", Language: "python"}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	jobID := resp["job_id"]
+	assert.NotEmpty(t, jobID)
+	assert.NotEmpty(t, resp["result_token"])
+
+	bodies := h.tasks.tasksTo("/execute")
+	if assert.Len(t, bodies, 1) {
+		var payload CloudTaskPayload
+		assert.NoError(t, json.Unmarshal(bodies[0], &payload))
+		assert.Equal(t, jobID, payload.JobID)
+		assert.Equal(t, defaultPythonLimits.DefaultTimeoutSeconds, payload.TimeoutSeconds)
+	}
+
+	snap, err := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(jobID).Get(context.Background())
+	if assert.NoError(t, err) {
+		var job Job
+		assert.NoError(t, snap.DataTo(&job))
+		assert.Equal(t, "queued", job.Status)
+	}
+}
+
+func TestHandlers_ExecuteCodeFailures(t *testing.T) {
+	h := newHandlerHarness(t)
+
+	w := h.do(http.MethodPost, "/api/execute", "", RequestBody{Code: "puts 1", Language: "ruby"}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	h.tasks.err = errors.New("queue unavailable")
+	var resp map[string]string
+	w = h.do(http.MethodPost, "/api/execute", "", RequestBody{Code: "print(1)", Language: "python"}, &resp)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "Failed to submit job for execution", resp["error"])
+}
+
+func TestHandlers_ExecuteCodeDeadline(t *testing.T) {
+	h := newHandlerHarness(t)
+	ctx := context.Background()
+
+	deadline := TimeToISO8601(time.Now().Add(time.Minute))
+	var resp map[string]string
+	w := h.do(http.MethodPost, "/api/execute", "", RequestBody{
+		Code: "print(1)", Language: "python", ExecutionOptions: ExecutionOptions{Deadline: deadline},
+	}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	jobID := resp["job_id"]
+
+	bodies := h.tasks.tasksTo("/execute")
+	if assert.Len(t, bodies, 1) {
+		var payload CloudTaskPayload
+		assert.NoError(t, json.Unmarshal(bodies[0], &payload))
+		assert.Equal(t, deadline, payload.Deadline)
+	}
+
+	// The task never ran and the deadline passed: reading the job expires it.
+	jobRef := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(jobID)
+	_, err := jobRef.Update(ctx, []docstore.Update{{Path: "deadline", Value: TimeToISO8601(time.Now().Add(-time.Second))}})
+	assert.NoError(t, err)
+	var status JobStatusResponse
+	w = h.do(http.MethodGet, "/api/jobs/"+jobID, "", nil, &status)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "failed", status.Status)
+	assert.Equal(t, failureTypeExpiredBeforeExecution, status.FailureType)
+	assert.Equal(t, 1, h.ac.deadlines.Stats(time.Now()).Missed)
+
+	for _, bad := range []string{
+		"tomorrow",
+		TimeToISO8601(time.Now().Add(time.Second)),
+		TimeToISO8601(time.Now().Add(2 * time.Hour)), // Past the one-hour job retention
+	} {
+		w = h.do(http.MethodPost, "/api/execute", "", RequestBody{
+			Code: "print(1)", Language: "python", ExecutionOptions: ExecutionOptions{Deadline: bad},
+		}, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, bad)
+	}
+	assert.Len(t, h.tasks.tasksTo("/execute"), 1)
+}
+
+func TestHandlers_ExecuteCodeAuthenticated(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	file := h.seedFile(workspaceID, "main.py", "print('hi')")
+
+	var resp ExecuteAuthResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{
+		Language:       "python",
+		EntrypointFile: "main.py",
+	}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEmpty(t, resp.JobID)
+	assert.Equal(t, "1", resp.FinalWorkspaceVersion)
+
+	bodies := h.tasks.tasksTo("/execute_auth")
+	if assert.Len(t, bodies, 1) {
+		var payload CloudTaskAuthPayload
+		assert.NoError(t, json.Unmarshal(bodies[0], &payload))
+		assert.Equal(t, resp.JobID, payload.JobID)
+		assert.Equal(t, "main.py", payload.EntrypointFile)
+		assert.Equal(t, []WorkerFile{{R2ObjectKey: file.R2ObjectKey, FilePath: "main.py"}}, payload.Files)
+		assert.Empty(t, payload.DependencyManifests)
+	}
+
+	job, err := h.ac.Jobs.Get(context.Background(), resp.JobID)
+	assert.NoError(t, err)
+	assert.False(t, job.DeleteAfter.IsZero())
+	assert.Equal(t, TimeToISO8601(job.DeleteAfter), job.ExpiresAt)
+}
+
+func TestHandlers_ExecuteCodeAuthenticatedExpectedVersion(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+	execute := "/api/workspaces/" + workspaceID + "/execute"
+
+	var resp ExecuteAuthResponse
+	w := h.do(http.MethodPost, execute, owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py", ExpectedWorkspaceVersion: "1"}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "1", resp.FinalWorkspaceVersion)
+
+	var conflict struct {
+		Code             string `json:"code"`
+		WorkspaceVersion string `json:"workspaceVersion"`
+	}
+	w = h.do(http.MethodPost, execute, owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py", ExpectedWorkspaceVersion: "0"}, &conflict)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "workspace_version_mismatch", conflict.Code)
+	assert.Equal(t, "1", conflict.WorkspaceVersion)
+	assert.Len(t, h.tasks.tasksTo("/execute_auth"), 1) // The rejected request queued nothing
+}
+
+func TestHandlers_ExecuteCodeAuthenticatedDependencyManifests(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "import requests")
+	requirements := h.seedFile(workspaceID, "requirements.txt", "requests==2.32.3")
+	h.seedFile(workspaceID, "web/package.json", "{}") // Not a python manifest
+
+	var resp ExecuteAuthResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{
+		Language:       "python",
+		EntrypointFile: "main.py",
+	}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	bodies := h.tasks.tasksTo("/execute_auth")
+	if assert.Len(t, bodies, 1) {
+		var payload CloudTaskAuthPayload
+		assert.NoError(t, json.Unmarshal(bodies[0], &payload))
+		assert.Equal(t, []WorkerFile{{R2ObjectKey: requirements.R2ObjectKey, FilePath: "requirements.txt"}}, payload.DependencyManifests)
+	}
+
+	snap, err := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(resp.JobID).Get(context.Background())
+	if assert.NoError(t, err) {
+		var job Job
+		assert.NoError(t, snap.DataTo(&job))
+		assert.Equal(t, []string{"requirements.txt"}, job.DependencyManifests)
+	}
+}
+
+func TestHandlers_ExecuteCodeAuthenticatedFailures(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+	req := ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py"}
+
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", "outsider-"+uuid.New().String(), req, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, h.tasks.tasksTo("/execute_auth"))
+
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "../main.py"}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	h.tasks.err = errors.New("queue unavailable")
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, req, nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestHandlers_GetWorkspaceJob(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, outsider := "owner-"+uuid.New().String(), "outsider-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	otherWorkspaceID := h.createWorkspace(outsider)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+
+	var submitted ExecuteAuthResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	jobPath := "/api/workspaces/" + workspaceID + "/jobs/" + submitted.JobID
+
+	// Still queued: no result yet.
+	var result WorkspaceJobResult
+	w = h.do(http.MethodGet, jobPath, owner, nil, &result)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "queued", result.Status)
+	assert.Equal(t, executionTypeWorkspace, result.ExecutionType)
+	assert.Empty(t, result.Output)
+	assert.Empty(t, result.CompletedAt)
+
+	_, err := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(submitted.JobID).Update(context.Background(), []docstore.Update{
+		{Path: "status", Value: "completed"},
+		{Path: "output", Value: "hi\n"},
+		{Path: "completed_at", Value: NowISO8601()},
+	})
+	assert.NoError(t, err)
+	w = h.do(http.MethodGet, jobPath, owner, nil, &result)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "completed", result.Status)
+	assert.Equal(t, "hi\n", result.Output)
+	assert.NotEmpty(t, result.CompletedAt)
+
+	w = h.do(http.MethodGet, jobPath, outsider, nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs/"+uuid.New().String(), owner, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	// A job is only reachable under its own workspace.
+	w = h.do(http.MethodGet, "/api/workspaces/"+otherWorkspaceID+"/jobs/"+submitted.JobID, outsider, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlers_ExecuteCodeAuthenticatedPriority(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.Services.PythonWorker.BatchQueueID = "python-batch-queue"
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+	executePath := "/api/workspaces/" + workspaceID + "/execute"
+
+	var submitted ExecuteAuthResponse
+	w := h.do(http.MethodPost, executePath, owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py", ExecutionOptions: ExecutionOptions{Priority: "batch"}}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = h.do(http.MethodPost, executePath, owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py", ExecutionOptions: ExecutionOptions{Priority: "later"}}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	if queues := h.tasks.queues(); assert.NotEmpty(t, queues) {
+		assert.True(t, strings.HasSuffix(queues[len(queues)-1], "/queues/python-batch-queue"), queues[len(queues)-1])
+	}
+	var listed struct {
+		Jobs []WorkspaceJobSummary `json:"jobs"`
+	}
+	h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs", owner, nil, &listed)
+	if assert.Len(t, listed.Jobs, 1) {
+		assert.Equal(t, submitted.JobID, listed.Jobs[0].JobID)
+		assert.Equal(t, jobPriorityBatch, listed.Jobs[0].Priority)
+	}
+}
+
+func TestHandlers_ListWorkspaceJobs(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, outsider := "owner-"+uuid.New().String(), "outsider-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	jobsPath := "/api/workspaces/" + workspaceID + "/jobs"
+
+	type page struct {
+		Jobs       []WorkspaceJobSummary `json:"jobs"`
+		NextCursor string                `json:"nextCursor"`
+	}
+	var listed page
+	w := h.do(http.MethodGet, jobsPath, owner, nil, &listed)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, listed.Jobs)
+	assert.Empty(t, listed.NextCursor)
+
+	// Five runs a minute apart; the two oldest share a timestamp to exercise the tie-break.
+	base := time.Now().Add(-time.Hour)
+	seeded := []struct{ id, status, submittedAt string }{
+		{"a-" + uuid.New().String(), "completed", TimeToISO8601(base)},
+		{"b-" + uuid.New().String(), "failed", TimeToISO8601(base)},
+		{"c-" + uuid.New().String(), "processing_auth_workspace", TimeToISO8601(base.Add(time.Minute))},
+		{"d-" + uuid.New().String(), "queued", TimeToISO8601(base.Add(2 * time.Minute))},
+		{"e-" + uuid.New().String(), "completed", TimeToISO8601(base.Add(3 * time.Minute))},
+	}
+	jobs := h.fs.Collection(h.ac.FirestoreJobsCollection)
+	for _, job := range seeded {
+		_, err := jobs.Doc(job.id).Set(context.Background(), Job{
+			Status: job.status, Language: "python", Code: "print('secret')", WorkspaceID: workspaceID,
+			ExecutionType: executionTypeWorkspace, UserID: owner, SubmittedAt: job.submittedAt,
+		})
+		assert.NoError(t, err)
+	}
+	_, err := jobs.Doc(uuid.New().String()).Set(context.Background(), Job{
+		Status: "completed", Language: "python", WorkspaceID: h.createWorkspace(owner),
+		ExecutionType: executionTypeWorkspace, SubmittedAt: TimeToISO8601(base),
+	})
+	assert.NoError(t, err)
+
+	// Pages of two walk every job exactly once, newest first.
+	var ids []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		w = h.do(http.MethodGet, jobsPath+"?limit=2&cursor="+cursor, owner, nil, &listed)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		for _, job := range listed.Jobs {
+			ids = append(ids, job.JobID)
+		}
+		if cursor = listed.NextCursor; cursor == "" {
+			break
+		}
+		listed = page{}
+	}
+	assert.Equal(t, []string{seeded[4].id, seeded[3].id, seeded[2].id, seeded[1].id, seeded[0].id}, ids)
+	assert.NotContains(t, w.Body.String(), "secret", "summaries leave out the code")
+
+	listed = page{}
+	h.do(http.MethodGet, jobsPath+"?status=completed", owner, nil, &listed)
+	if assert.Len(t, listed.Jobs, 2) {
+		assert.Equal(t, seeded[4].id, listed.Jobs[0].JobID)
+		assert.Equal(t, seeded[0].id, listed.Jobs[1].JobID)
+	}
+	listed = page{}
+	h.do(http.MethodGet, jobsPath+"?status=running&language=python", owner, nil, &listed)
+	if assert.Len(t, listed.Jobs, 1) {
+		assert.Equal(t, seeded[2].id, listed.Jobs[0].JobID)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodGet, jobsPath+"?status=paused", owner, nil, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodGet, jobsPath+"?language=cobol", owner, nil, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodGet, jobsPath+"?limit=101", owner, nil, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodGet, jobsPath+"?cursor=not-a-cursor", owner, nil, nil).Code)
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodGet, jobsPath, outsider, nil, nil).Code)
+}
+
+func TestHandlers_StreamWorkspaceJobEvents(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.JobEventsMaxDuration = 5 * time.Second
+	owner, outsider := "owner-"+uuid.New().String(), "outsider-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+
+	var submitted ExecuteAuthResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	eventsPath := "/api/workspaces/" + workspaceID + "/jobs/" + submitted.JobID + "/events"
+
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodGet, eventsPath, outsider, nil, nil).Code)
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs/"+uuid.New().String()+"/events", owner, nil, nil).Code)
+
+	// The worker picks the job up, heartbeats and finishes while the client listens.
+	jobRef := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(submitted.JobID)
+	go func() {
+		for _, updates := range [][]docstore.Update{
+			{{Path: "status", Value: "running_auth_workspace"}},
+			{{Path: "last_heartbeat_at", Value: NowISO8601()}},
+			{{Path: "status", Value: "completed"}, {Path: "output", Value: "hi\n"}, {Path: "completed_at", Value: NowISO8601()}},
+		} {
+			time.Sleep(200 * time.Millisecond)
+			_, err := jobRef.Update(context.Background(), updates)
+			assert.NoError(t, err)
+		}
+	}()
+	started := time.Now()
+	w = h.do(http.MethodGet, eventsPath, owner, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Less(t, time.Since(started), 5*time.Second, "the stream closes once the job finishes")
+
+	var events []JobEvent
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var event JobEvent
+			assert.NoError(t, json.Unmarshal([]byte(data), &event))
+			events = append(events, event)
+		}
+	}
+	if assert.Len(t, events, 3, "heartbeats do not produce events") {
+		assert.Equal(t, "queued", events[0].Status)
+		assert.Equal(t, "running_auth_workspace", events[1].Status)
+		assert.Empty(t, events[1].Output)
+		assert.Equal(t, JobEvent{JobID: submitted.JobID, Status: "completed", Output: "hi\n", CompletedAt: events[2].CompletedAt}, events[2])
+	}
+
+	// A job that never finishes is cut off at the maximum duration.
+	h.ac.AppConfig.JobEventsMaxDuration = 300 * time.Millisecond
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs/"+submitted.JobID+"/events", owner, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "event: status"))
+}
+
+func TestHandlers_CancelWorkspaceJob(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, viewer, outsider := "owner-"+uuid.New().String(), "viewer-"+uuid.New().String(), "outsider-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, viewer, "viewer", "")
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+
+	var submitted ExecuteAuthResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	jobPath := "/api/workspaces/" + workspaceID + "/jobs/" + submitted.JobID
+
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodDelete, jobPath, outsider, nil, nil).Code)
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodDelete, jobPath, viewer, nil, nil).Code, "viewers only cancel their own jobs")
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodDelete, "/api/workspaces/"+workspaceID+"/jobs/"+uuid.New().String(), owner, nil, nil).Code)
+
+	w = h.do(http.MethodDelete, jobPath, owner, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var result WorkspaceJobResult
+	h.do(http.MethodGet, jobPath, owner, nil, &result)
+	assert.Equal(t, jobStatusCancelled, result.Status)
+	assert.Len(t, h.tasks.deletedTasks(), 1, "the pending task is deleted")
+
+	assert.Equal(t, http.StatusConflict, h.do(http.MethodDelete, jobPath, owner, nil, nil).Code)
+}
+
+func TestHandlers_ScheduledExecution(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('report')")
+	execute := "/api/workspaces/" + workspaceID + "/execute"
+
+	for _, at := range []time.Time{time.Now().Add(-time.Hour), time.Now().Add(31 * 24 * time.Hour)} {
+		w := h.do(http.MethodPost, execute, owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py", ScheduleAt: TimeToISO8601(at)}, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	}
+	assert.Empty(t, h.tasks.queues(), "rejected schedules create no task")
+
+	scheduleAt := time.Now().UTC().Add(6 * time.Hour).Truncate(time.Millisecond)
+	var submitted ExecuteAuthResponse
+	w := h.do(http.MethodPost, execute, owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py", ScheduleAt: TimeToISO8601(scheduleAt)}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, TimeToISO8601(scheduleAt), submitted.ScheduledAt)
+	if assert.Len(t, h.tasks.requests, 1) {
+		assert.True(t, h.tasks.requests[0].GetTask().GetScheduleTime().AsTime().Equal(scheduleAt))
+	}
+
+	job, err := h.ac.Jobs.Get(context.Background(), submitted.JobID)
+	assert.NoError(t, err)
+	assert.Equal(t, jobStatusScheduled, job.Status)
+	assert.True(t, job.DeleteAfter.After(scheduleAt), "retention counts from the scheduled run")
+
+	jobPath := "/api/workspaces/" + workspaceID + "/jobs/" + submitted.JobID
+	w = h.do(http.MethodDelete, jobPath, owner, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{job.TaskName}, h.tasks.deletedTasks(), "the held task is deleted before it dispatches")
+	var result WorkspaceJobResult
+	h.do(http.MethodGet, jobPath, owner, nil, &result)
+	assert.Equal(t, jobStatusCancelled, result.Status)
+}
+
+func TestHandlers_RetryWorkspaceJob(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, outsider := "owner-"+uuid.New().String(), "outsider-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+
+	var submitted ExecuteAuthResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py", Input: "42"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	retryPath := "/api/workspaces/" + workspaceID + "/jobs/" + submitted.JobID + "/retry"
+
+	assert.Equal(t, http.StatusConflict, h.do(http.MethodPost, retryPath, owner, nil, nil).Code, "only failed jobs are retried")
+	_, err := h.ac.Jobs.Update(context.Background(), submitted.JobID, func(job *Job) (bool, error) {
+		job.Status, job.FailureType = "failed", "oom"
+		return true, nil
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, retryPath, outsider, nil, nil).Code)
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/jobs/"+uuid.New().String()+"/retry", owner, nil, nil).Code)
+
+	var retried map[string]string
+	w = h.do(http.MethodPost, retryPath, owner, nil, &retried)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, submitted.JobID, retried["retry_of"])
+	assert.NotEqual(t, submitted.JobID, retried["job_id"])
+
+	var result WorkspaceJobResult
+	h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs/"+retried["job_id"], owner, nil, &result)
+	assert.Equal(t, "queued", result.Status)
+	assert.Equal(t, submitted.JobID, result.RetryOf)
+	if bodies := h.tasks.tasksTo("/execute_auth"); assert.Len(t, bodies, 2) {
+		var payload CloudTaskAuthPayload
+		assert.NoError(t, json.Unmarshal(bodies[1], &payload))
+		assert.Equal(t, retried["job_id"], payload.JobID)
+		assert.Equal(t, "main.py", payload.EntrypointFile)
+		assert.Equal(t, "42", payload.Input)
+	}
+
+	// A workspace removed since the job ran is reported as missing.
+	_, err = h.fs.Collection("workspaces").Doc(workspaceID).Delete(context.Background())
+	assert.NoError(t, err)
+	w = h.do(http.MethodPost, retryPath, owner, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+}
+
+func TestHandlers_TimeBoxedMembership(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, reviewer := "owner-"+uuid.New().String(), "reviewer-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	manifest := "/api/workspaces/" + workspaceID + "/manifest"
+	member := "/api/workspaces/" + workspaceID + "/members/" + reviewer
+
+	// An expired reviewer behaves as a non-member.
+	lapsed := TimeToISO8601(time.Now().Add(-time.Hour))
+	h.seedMembership(workspaceID, reviewer, "viewer", lapsed)
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodGet, manifest, reviewer, nil, nil).Code)
+	var summaries []WorkspaceSummary
+	h.do(http.MethodGet, "/api/workspaces", reviewer, nil, &summaries)
+	assert.Empty(t, summaries)
+
+	var listed struct {
+		Members []WorkspaceMember `json:"members"`
+	}
+	w := h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/members", owner, nil, &listed)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Len(t, listed.Members, 2) {
+		assert.Equal(t, owner, listed.Members[0].UserID)
+		assert.Equal(t, WorkspaceMember{MembershipID: listed.Members[1].MembershipID, UserID: reviewer, Role: "viewer", JoinedAt: listed.Members[1].JoinedAt, ExpiresAt: lapsed, Expired: true}, listed.Members[1])
+	}
+
+	// Only owners extend access, and only into the future.
+	extended := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPatch, member, reviewer, UpdateMemberRequest{ExpiresAt: extended.Format(time.RFC3339)}, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodPatch, member, owner, UpdateMemberRequest{ExpiresAt: lapsed}, nil).Code)
+	var updated WorkspaceMember
+	w = h.do(http.MethodPatch, member, owner, UpdateMemberRequest{ExpiresAt: extended.Format(time.RFC3339)}, &updated)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, TimeToISO8601(extended), updated.ExpiresAt)
+	assert.False(t, updated.Expired)
+
+	assert.Equal(t, http.StatusOK, h.do(http.MethodGet, manifest, reviewer, nil, nil).Code)
+	h.do(http.MethodGet, "/api/workspaces", reviewer, nil, &summaries)
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, TimeToISO8601(extended), summaries[0].ExpiresAt)
+	}
+
+	w = h.do(http.MethodPatch, member, owner, UpdateMemberRequest{Role: "editor", ClearExpiry: true}, &updated)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "editor", updated.Role)
+	assert.Empty(t, updated.ExpiresAt)
+
+	assert.Equal(t, http.StatusConflict, h.do(http.MethodPatch, "/api/workspaces/"+workspaceID+"/members/"+owner, owner, UpdateMemberRequest{Role: "viewer"}, nil).Code)
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodPatch, "/api/workspaces/"+workspaceID+"/members/nobody", owner, UpdateMemberRequest{Role: "viewer"}, nil).Code)
+}
+
+func TestHandlers_MembershipExpiryMaintenance(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, reviewer := "owner-"+uuid.New().String(), "reviewer-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, reviewer, "viewer", TimeToISO8601(time.Now().Add(-time.Hour)))
+
+	_, err := h.ac.runMembershipExpiryMaintenance(context.Background())
+	assert.NoError(t, err)
+
+	var listed struct {
+		Members []WorkspaceMember `json:"members"`
+	}
+	h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/members", owner, nil, &listed)
+	if assert.Len(t, listed.Members, 1) {
+		assert.Equal(t, owner, listed.Members[0].UserID)
+	}
+
+	events, err := h.ac.activityCollection(workspaceID).Where("type", "==", activityMemberExpired).Documents(context.Background()).GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func TestHandlers_BackfillJobExpiry(t *testing.T) {
+	h := newHandlerHarness(t)
+	jobs := h.fs.Collection(h.ac.FirestoreJobsCollection)
+	ctx := context.Background()
+	submitted := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+	expiry := submitted.Add(h.ac.AppConfig.Retention.Jobs)
+
+	// Written as jobs were before every creation path set an expiry.
+	legacy := map[string]map[string]interface{}{
+		"a-no-expiry":       {"status": "completed", "language": "python", "submitted_at": TimeToISO8601(submitted), "execution_type": executionTypeWorkspace},
+		"b-pinned":          {"status": "completed", "language": "python", "submitted_at": TimeToISO8601(submitted), "pinned": true},
+		"c-delete-after":    {"status": "completed", "language": "python", "submitted_at": TimeToISO8601(submitted), deleteAfterField: expiry},
+		"d-already-stamped": {"status": "queued", "language": "python", "submitted_at": TimeToISO8601(submitted), deleteAfterField: expiry, "expires_at": TimeToISO8601(expiry)},
+	}
+	for id, data := range legacy {
+		_, err := jobs.Doc(id).Set(ctx, data)
+		assert.NoError(t, err)
+	}
+
+	var result BackfillJobExpiryResult
+	w := h.do(http.MethodPost, "/api/admin/jobs/backfill-expiry", "admin", BackfillJobExpiryRequest{Limit: 3}, &result)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, BackfillJobExpiryResult{Scanned: 3, Stamped: 2, NextCursor: "c-delete-after"}, result)
+
+	w = h.do(http.MethodPost, "/api/admin/jobs/backfill-expiry", "admin", BackfillJobExpiryRequest{Cursor: result.NextCursor, Limit: 3}, &result)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, BackfillJobExpiryResult{Scanned: 1}, result)
+
+	for _, id := range []string{"a-no-expiry", "c-delete-after"} {
+		job, err := h.ac.Jobs.Get(ctx, id)
+		assert.NoError(t, err)
+		assert.True(t, job.DeleteAfter.Equal(expiry), id)
+		assert.Equal(t, TimeToISO8601(expiry), job.ExpiresAt, id)
+	}
+	pinned, err := h.ac.Jobs.Get(ctx, "b-pinned")
+	assert.NoError(t, err)
+	assert.True(t, pinned.DeleteAfter.IsZero())
+	assert.Empty(t, pinned.ExpiresAt)
+}
+
+func TestHandlers_WorkspaceJobMetrics(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, outsider := "owner-"+uuid.New().String(), "outsider-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	metricsPath := "/api/workspaces/" + workspaceID + "/jobs/metrics"
+	ctx := context.Background()
+
+	var metrics WorkspaceJobMetrics
+	w := h.do(http.MethodGet, metricsPath, owner, nil, &metrics)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Zero(t, metrics.Total)
+	assert.Zero(t, metrics.FailureRate)
+	assert.Nil(t, metrics.LatencyMs)
+
+	// Runs on either side of since, which is an hour ago; only those inside the window count.
+	since := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	seeded := []struct {
+		status    string
+		submitted time.Time
+		latency   time.Duration
+	}{
+		{"completed", since.Add(time.Minute), 2 * time.Second},
+		{"completed", since.Add(2 * time.Minute), 4 * time.Second},
+		{"completed", since, 6 * time.Second},
+		{"failed", since.Add(3 * time.Minute), 10 * time.Second},
+		{jobStatusRunning, since.Add(4 * time.Minute), 0},
+		{"queued", since.Add(5 * time.Minute), 0},
+		{"completed", since.Add(-time.Second), time.Second},
+		{"failed", since.Add(-time.Hour), time.Second},
+	}
+	jobs := h.fs.Collection(h.ac.FirestoreJobsCollection)
+	for _, job := range seeded {
+		seed := Job{
+			Status: job.status, Language: "python", WorkspaceID: workspaceID,
+			ExecutionType: executionTypeWorkspace, UserID: owner, SubmittedAt: TimeToISO8601(job.submitted),
+		}
+		if job.latency > 0 {
+			seed.CompletedAt = TimeToISO8601(job.submitted.Add(job.latency))
+		}
+		_, err := jobs.Doc(uuid.New().String()).Set(ctx, seed)
+		assert.NoError(t, err)
+	}
+	// Another workspace's run and a RAG query in this one are left out.
+	_, err := jobs.Doc(uuid.New().String()).Set(ctx, Job{
+		Status: "failed", Language: "python", WorkspaceID: h.createWorkspace(owner),
+		ExecutionType: executionTypeWorkspace, SubmittedAt: TimeToISO8601(since.Add(time.Minute)),
+	})
+	assert.NoError(t, err)
+	_, err = jobs.Doc(uuid.New().String()).Set(ctx, Job{
+		Status: "failed", Language: "python", WorkspaceID: workspaceID,
+		ExecutionType: executionTypeRagQuery, SubmittedAt: TimeToISO8601(since.Add(time.Minute)),
+	})
+	assert.NoError(t, err)
+
+	windowPath := metricsPath + "?since=" + url.QueryEscape(since.Format(time.RFC3339))
+	metrics = WorkspaceJobMetrics{}
+	w = h.do(http.MethodGet, windowPath, owner, nil, &metrics)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, TimeToISO8601(since), metrics.Since)
+	assert.EqualValues(t, 6, metrics.Total)
+	assert.Equal(t, map[string]int64{
+		"queued": 1, jobStatusScheduled: 0, "running": 1, "completed": 3, "failed": 1, jobStatusCancelled: 0,
+	}, metrics.ByStatus)
+	assert.Equal(t, map[string]int64{"python": 6}, metrics.ByLanguage)
+	assert.InDelta(t, 0.25, metrics.FailureRate, 1e-9)
+	assert.Equal(t, &JobLatencyPercentiles{P50: 4000, P95: 10000, Samples: 4}, metrics.LatencyMs)
+	assert.False(t, metrics.Approximate)
+
+	// The default window of a week takes in every seeded run.
+	metrics = WorkspaceJobMetrics{}
+	h.do(http.MethodGet, metricsPath, owner, nil, &metrics)
+	assert.EqualValues(t, 8, metrics.Total)
+
+	// Reading fewer finished jobs than matched flags the latencies as approximate.
+	defer func(limit int) { jobMetricsScanLimit = limit }(jobMetricsScanLimit)
+	jobMetricsScanLimit = 2
+	metrics = WorkspaceJobMetrics{}
+	h.do(http.MethodGet, windowPath, owner, nil, &metrics)
+	assert.True(t, metrics.Approximate)
+	assert.EqualValues(t, 6, metrics.Total, "counts still cover the whole window")
+	if assert.NotNil(t, metrics.LatencyMs) {
+		assert.Equal(t, 2, metrics.LatencyMs.Samples)
+	}
+
+	w = h.do(http.MethodGet, metricsPath, outsider, nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	for _, raw := range []string{"yesterday", time.Now().Add(time.Hour).UTC().Format(time.RFC3339)} {
+		w = h.do(http.MethodGet, metricsPath+"?since="+url.QueryEscape(raw), owner, nil, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, raw)
+	}
+}
+
+func TestHandlers_JobLabels(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+	executePath := "/api/workspaces/" + workspaceID + "/execute"
+
+	submit := func(labels map[string]string) string {
+		var resp ExecuteAuthResponse
+		w := h.do(http.MethodPost, executePath, owner, ExecuteAuthRequest{
+			Language:         "python",
+			EntrypointFile:   "main.py",
+			ExecutionOptions: ExecutionOptions{Labels: labels},
+		}, &resp)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return resp.JobID
+	}
+	ciUnit := submit(map[string]string{"purpose": "ci", "suite": "unit"})
+	ciLint := submit(map[string]string{"purpose": "ci", "suite": "lint"})
+	grading := submit(map[string]string{"purpose": "grading"})
+	submit(nil)
+
+	var rejected map[string]string
+	w := h.do(http.MethodPost, executePath, owner, ExecuteAuthRequest{
+		Language:         "python",
+		EntrypointFile:   "main.py",
+		ExecutionOptions: ExecutionOptions{Labels: map[string]string{"team/purpose": "ci"}},
+	}, &rejected)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, rejected["error"], "team/purpose")
+
+	list := func(query string) []string {
+		var listed struct {
+			Jobs []WorkspaceJobSummary `json:"jobs"`
+		}
+		w := h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs?"+query, owner, nil, &listed)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var ids []string
+		for _, job := range listed.Jobs {
+			ids = append(ids, job.JobID)
+		}
+		return ids
+	}
+	assert.ElementsMatch(t, []string{ciUnit, ciLint}, list("label.purpose=ci"))
+	assert.Equal(t, []string{ciUnit}, list("label.purpose=ci&label.suite=unit"))
+	assert.Equal(t, []string{grading}, list("label.purpose=grading"))
+	assert.Empty(t, list("label.purpose=adhoc"))
+	w = h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs?label.a/b=ci", owner, nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var result WorkspaceJobResult
+	h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs/"+ciUnit, owner, nil, &result)
+	assert.Equal(t, map[string]string{"purpose": "ci", "suite": "unit"}, result.Labels)
+}
+
+func TestHandlers_ListMyJobs(t *testing.T) {
+	h := newHandlerHarness(t)
+	ctx := context.Background()
+	user, other := "user-"+uuid.New().String(), "other-"+uuid.New().String()
+	first, second := h.createWorkspace(user), h.createWorkspace(user)
+	_, err := h.fs.Collection("workspaces").Doc(second).Update(ctx, []docstore.Update{{Path: "name", Value: "Grading"}})
+	assert.NoError(t, err)
+
+	base := time.Now().Add(-time.Hour)
+	jobs := h.fs.Collection(h.ac.FirestoreJobsCollection)
+	seed := func(id string, job Job, minutes int) {
+		job.Language, job.SubmittedAt = "python", TimeToISO8601(base.Add(time.Duration(minutes)*time.Minute))
+		_, err := jobs.Doc(id).Set(ctx, job)
+		assert.NoError(t, err)
+	}
+	a, b, c := "a-"+uuid.New().String(), "b-"+uuid.New().String(), "c-"+uuid.New().String()
+	seed(a, Job{Status: "completed", UserID: user, WorkspaceID: first, ExecutionType: executionTypeWorkspace}, 0)
+	seed(b, Job{Status: "failed", UserID: user, WorkspaceID: second, ExecutionType: executionTypeWorkspace}, 1)
+	seed(c, Job{Status: "completed", UserID: user, WorkspaceID: second, ExecutionType: executionTypeWorkspace}, 2)
+	// Other users' runs, public runs and RAG queries are never listed.
+	seed(uuid.New().String(), Job{Status: "completed", UserID: other, WorkspaceID: first, ExecutionType: executionTypeWorkspace}, 3)
+	seed(uuid.New().String(), Job{Status: "completed", ExecutionType: executionTypePublic}, 4)
+	seed(uuid.New().String(), Job{Status: "completed", UserID: user, WorkspaceID: first, ExecutionType: executionTypeRagQuery}, 5)
+
+	type page struct {
+		Jobs       []UserJobSummary `json:"jobs"`
+		NextCursor string           `json:"nextCursor"`
+	}
+	var listed page
+	w := h.do(http.MethodGet, "/api/jobs?limit=2", user, nil, &listed)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if assert.Len(t, listed.Jobs, 2) {
+		assert.Equal(t, c, listed.Jobs[0].JobID)
+		assert.Equal(t, second, listed.Jobs[0].WorkspaceID)
+		assert.Equal(t, "Grading", listed.Jobs[0].WorkspaceName)
+		assert.Equal(t, b, listed.Jobs[1].JobID)
+	}
+	cursor := listed.NextCursor
+	assert.NotEmpty(t, cursor)
+	listed = page{}
+	h.do(http.MethodGet, "/api/jobs?limit=2&cursor="+cursor, user, nil, &listed)
+	if assert.Len(t, listed.Jobs, 1) {
+		assert.Equal(t, a, listed.Jobs[0].JobID)
+		assert.Equal(t, first, listed.Jobs[0].WorkspaceID)
+		assert.NotEmpty(t, listed.Jobs[0].WorkspaceName)
+	}
+	assert.Empty(t, listed.NextCursor)
+
+	listed = page{}
+	h.do(http.MethodGet, "/api/jobs?status=completed&workspaceId="+second, user, nil, &listed)
+	if assert.Len(t, listed.Jobs, 1) {
+		assert.Equal(t, c, listed.Jobs[0].JobID)
+	}
+
+	// A deleted workspace's runs stay listed without a name.
+	_, err = h.fs.Collection("workspaces").Doc(first).Delete(ctx)
+	assert.NoError(t, err)
+	listed = page{}
+	h.do(http.MethodGet, "/api/jobs?workspaceId="+first, user, nil, &listed)
+	if assert.Len(t, listed.Jobs, 1) {
+		assert.Empty(t, listed.Jobs[0].WorkspaceName)
+	}
+
+	for _, query := range []string{"status=paused", "limit=0", "cursor=!!"} {
+		w = h.do(http.MethodGet, "/api/jobs?"+query, user, nil, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	w = h.do(http.MethodGet, "/api/jobs", "", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
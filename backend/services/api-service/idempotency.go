@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// idempotencyKeyHeader lets clients retry execute requests without queuing the job twice.
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 128
+
+	// idempotencyKeysCollection maps idempotency keys to the jobs they created. Documents carry
+	// delete_after for a TTL policy; the "idempotency-key-expiry" maintenance task removes
+	// them where none is configured.
+	idempotencyKeysCollection = "idempotency_keys"
+	idempotencyKeyExpiryBatch = 300
+)
+
+// IdempotencyKey identifies one client's key for one endpoint. ID is a digest, so keys of any
+// content make valid document IDs and are not stored as sent.
+type IdempotencyKey struct {
+	ID          string
+	DeleteAfter time.Time
+}
+
+// IdempotencyRecord is the stored mapping from an idempotency key to its job.
+type IdempotencyRecord struct {
+	JobID       string    `firestore:"job_id"`
+	CreatedAt   string    `firestore:"created_at"` // ISO 8601 string
+	DeleteAfter time.Time `firestore:"delete_after"`
+}
+
+// idempotencyKeyFor reads the Idempotency-Key header. Keys are scoped to the caller (a user,
+// or the client IP for anonymous requests) and to the endpoint. It returns nil without a
+// header, and writes a 400 and returns false for a key that is too long.
+func (ac *ApiController) idempotencyKeyFor(c *gin.Context, endpoint string) (*IdempotencyKey, bool) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		return nil, true
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLen)})
+		return nil, false
+	}
+	caller := "ip:" + RealClientIP(c)
+	if userID := c.GetString("userID"); userID != "" {
+		caller = "user:" + userID
+	}
+	sum := sha256.Sum256([]byte(caller + "\x00" + endpoint + "\x00" + key))
+	return &IdempotencyKey{
+		ID:          hex.EncodeToString(sum[:]),
+		DeleteAfter: deleteAfter(ac.AppConfig.Retention.IdempotencyKeys),
+	}, true
+}
+
+// createJob stores a new job, or with an idempotency key returns the job the key already
+// created, with replayed set. A non-nil quota is charged for a new job only.
+func (ac *ApiController) createJob(ctx context.Context, key *IdempotencyKey, quota *JobQuota, jobID string, job Job) (StoredJob, bool, error) {
+	if key != nil {
+		return ac.Jobs.CreateIdempotent(ctx, *key, quota, jobID, job)
+	}
+	if quota != nil {
+		return StoredJob{ID: jobID, Job: job}, false, ac.Jobs.CreateWithQuota(ctx, *quota, jobID, job)
+	}
+	return StoredJob{ID: jobID, Job: job}, false, ac.Jobs.Create(ctx, jobID, job)
+}
+
+// releaseIdempotencyKey forgets a key whose job could not be submitted, so the client's
+// retry creates a new job rather than replaying one that will never run.
+func (ac *ApiController) releaseIdempotencyKey(ctx context.Context, key *IdempotencyKey, logCtx *log.Entry) {
+	if key == nil {
+		return
+	}
+	if err := ac.Jobs.ReleaseIdempotencyKey(ctx, *key); err != nil {
+		logCtx.WithError(err).Warn("Failed to release idempotency key.")
+	}
+}
+
+// CreateIdempotent writes the key mapping, the quota charge and the job in one transaction,
+// so a key never outlives a job that was not created. A key whose job has since been
+// deleted is reused.
+func (s *firestoreJobStore) CreateIdempotent(ctx context.Context, key IdempotencyKey, quota *JobQuota, jobID string, job Job) (StoredJob, bool, error) {
+	keyRef := s.fs.Collection(idempotencyKeysCollection).Doc(key.ID)
+	var stored StoredJob
+	var replayed bool
+	err := s.fs.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		replayed = false
+		snap, err := tx.Get(keyRef)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if err == nil && !snapshotPastDeleteAfter(snap) {
+			var record IdempotencyRecord
+			if err := snap.DataTo(&record); err != nil {
+				return fmt.Errorf("failed to parse idempotency key: %w", err)
+			}
+			jobSnap, err := tx.Get(s.fs.Collection(s.collection).Doc(record.JobID))
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			if err == nil {
+				var existing Job
+				if err := jobSnap.DataTo(&existing); err != nil {
+					return fmt.Errorf("failed to parse job: %w", err)
+				}
+				stored, replayed = StoredJob{ID: record.JobID, Job: existing}, true
+				return nil
+			}
+		}
+
+		if quota != nil {
+			if err := s.chargeJobQuota(tx, *quota, 1); err != nil {
+				return err
+			}
+		}
+		if err := tx.Set(keyRef, IdempotencyRecord{JobID: jobID, CreatedAt: NowISO8601(), DeleteAfter: key.DeleteAfter}); err != nil {
+			return err
+		}
+		stored = StoredJob{ID: jobID, Job: job}
+		return tx.Set(s.fs.Collection(s.collection).Doc(jobID), job)
+	})
+	return stored, replayed, err
+}
+
+func (s *firestoreJobStore) ReleaseIdempotencyKey(ctx context.Context, key IdempotencyKey) error {
+	_, err := s.fs.Collection(idempotencyKeysCollection).Doc(key.ID).Delete(ctx)
+	return err
+}
+
+// runIdempotencyKeyExpiryMaintenance is the "idempotency-key-expiry" maintenance task: it
+// removes one batch of key mappings past their delete_after.
+func (ac *ApiController) runIdempotencyKeyExpiryMaintenance(ctx context.Context) (interface{}, error) {
+	return ac.deleteExpiredRecords(ctx, idempotencyKeysCollection, idempotencyKeyExpiryBatch)
+}
+
+// deleteExpiredRecords removes up to batch documents of a collection whose delete_after has
+// passed. A document rewritten since the scan has a fresh delete_after and is kept.
+func (ac *ApiController) deleteExpiredRecords(ctx context.Context, collection string, batch int) (interface{}, error) {
+	docs, err := ac.FirestoreClient.Collection(collection).
+		Where(deleteAfterField, "<=", time.Now().UTC()).
+		OrderBy(deleteAfterField, docstore.Asc).
+		Limit(batch).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load expired %s: %w", collection, err)
+	}
+
+	expired := 0
+	for _, doc := range docs {
+		if _, err := doc.Ref.Delete(ctx, docstore.LastUpdateTime(doc.UpdateTime)); err != nil {
+			log.WithError(err).WithFields(log.Fields{"collection": collection, "doc_id": doc.Ref.ID}).Info("Expired record not deleted; it may have been reused.")
+			continue
+		}
+		expired++
+	}
+	return gin.H{"scanned": len(docs), "expired": expired}, nil
+}
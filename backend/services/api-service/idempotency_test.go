@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func executeWithKey(r *gin.Engine, key string, out interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RequestBody{Code: "print(1)", Language: "python"})
+	req := httptest.NewRequest(http.MethodPost, "/api/execute", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyKeyHeader, key)
+	w := serveRequest(r, req)
+	if out != nil {
+		json.Unmarshal(w.Body.Bytes(), out)
+	}
+	return w
+}
+
+func TestExecuteCode_IdempotencyKey(t *testing.T) {
+	r, _, tasks := newEmbeddedHarness(t)
+
+	var first, second, other map[string]interface{}
+	w := executeWithKey(r, "retry-1", &first)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Nil(t, first["replayed"])
+
+	w = executeWithKey(r, "retry-1", &second)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, first["job_id"], second["job_id"])
+	assert.Equal(t, first["result_token"], second["result_token"])
+	assert.Equal(t, true, second["replayed"])
+	assert.Len(t, tasks.tasksTo("/execute"), 1, "a replay queues no task")
+
+	w = executeWithKey(r, "retry-2", &other)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEqual(t, first["job_id"], other["job_id"])
+
+	w = executeWithKey(r, strings.Repeat("k", maxIdempotencyKeyLen+1), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func TestExecuteCode_IdempotencyKeyReleasedWhenTaskFails(t *testing.T) {
+	r, _, tasks := newEmbeddedHarness(t)
+
+	tasks.err = errors.New("queue unavailable")
+	w := executeWithKey(r, "retry-1", nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+
+	tasks.err = nil
+	var retried map[string]interface{}
+	w = executeWithKey(r, "retry-1", &retried)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Nil(t, retried["replayed"], "a job that was never queued is not replayed")
+	assert.Len(t, tasks.tasksTo("/execute"), 1)
+}
+
+func TestEmbeddedJobStore_IdempotencyKeyExpiry(t *testing.T) {
+	_, ac, _ := newEmbeddedHarness(t)
+	ctx := context.Background()
+	key := IdempotencyKey{ID: "k", DeleteAfter: time.Now().Add(time.Hour)}
+
+	stored, replayed, err := ac.Jobs.CreateIdempotent(ctx, key, nil, "job-1", Job{Status: "queued"})
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+	assert.Equal(t, "job-1", stored.ID)
+
+	_, err = ac.Jobs.DeleteExpired(ctx, time.Now().Add(2*time.Hour), nil)
+	assert.NoError(t, err)
+	stored, replayed, err = ac.Jobs.CreateIdempotent(ctx, key, nil, "job-2", Job{Status: "queued"})
+	assert.NoError(t, err)
+	assert.False(t, replayed, "an expired key starts a new job")
+	assert.Equal(t, "job-2", stored.ID)
+}
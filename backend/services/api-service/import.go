@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// importJobsCollection holds asynchronous workspace imports, keyed by import job ID.
+const importJobsCollection = "import_jobs"
+
+// maxStoredImportSkips caps the skip reasons kept on an import job document.
+const maxStoredImportSkips = 200
+
+// importHeadTimeout bounds the size check made before an import is accepted.
+const importHeadTimeout = 15 * time.Second
+
+// errImportPathConflict is returned when an imported file or folder would replace an
+// existing entry of the other type.
+var errImportPathConflict = errors.New("import conflicts with an existing file or folder")
+
+// importCommit is the outcome of committing imported files to a workspace.
+type importCommit struct {
+	WorkspaceVersion string
+	Totals           SyncTotals
+	Files            []WorkerFile
+	StorageBytes     int64 // Workspace storage after the commit
+}
+
+// ImportFromURL starts an asynchronous import of a zip or tar.gz archive from an https URL.
+// The archive's size is checked with a HEAD request when the server reports one.
+func (ac *ApiController) ImportFromURL(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "ImportFromURL"})
+
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleEditor, logCtx) == nil {
+		return
+	}
+
+	var req ImportURLRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	targetPath := ""
+	if req.TargetPath != "" {
+		var err error
+		if targetPath, err = cleanImportPath(req.TargetPath); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid targetPath: " + err.Error()})
+			return
+		}
+	}
+	if err := ac.outbound.ValidateURL(ctx, req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import URL: " + err.Error()})
+		return
+	}
+
+	workspace, err := ac.loadWorkspace(ctx, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load workspace for import.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace"})
+		return
+	}
+	if workspace.ReadOnly {
+		c.JSON(http.StatusConflict, gin.H{"error": "Workspace is read-only. Ask an owner to lift the freeze."})
+		return
+	}
+
+	// Servers that refuse HEAD (e.g. presigned GET URLs) are still imported; the download
+	// itself is capped at the same limit.
+	if size, err := ac.importContentLength(ctx, req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not reach the import URL"})
+		return
+	} else if size > ac.AppConfig.ImportMaxArchiveBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":    "Archive is larger than the import limit",
+			"size":     size,
+			"maxBytes": ac.AppConfig.ImportMaxArchiveBytes,
+		})
+		return
+	}
+
+	now := NowISO8601()
+	job := ImportJob{
+		ImportJobID: uuid.New().String(),
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+		SourceURL:   req.URL,
+		TargetPath:  targetPath,
+		Status:      "queued",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		DeleteAfter: deleteAfter(ac.AppConfig.Retention.Jobs),
+	}
+	jobRef := ac.FirestoreClient.Collection(importJobsCollection).Doc(job.ImportJobID)
+	if _, err := jobRef.Set(ctx, job); err != nil {
+		logCtx.WithError(err).Error("Failed to create import job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import job"})
+		return
+	}
+
+	submitErr := ac.Background.SubmitWithTimeout("workspace_import", ac.AppConfig.ImportTimeout, func(ctx context.Context) error {
+		return ac.runURLImport(ctx, jobRef, job)
+	})
+	if submitErr != nil {
+		logCtx.WithError(submitErr).Error("Failed to schedule import job.")
+		ac.failImport(ctx, jobRef, "Import could not be scheduled; try again later", nil)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Import could not be scheduled; try again later"})
+		return
+	}
+
+	logCtx.WithField("import_job_id", job.ImportJobID).Info("Workspace import queued.")
+	c.JSON(http.StatusAccepted, gin.H{"importJobId": job.ImportJobID, "status": job.Status})
+}
+
+// GetImportJob returns an import job's progress, counts and per-file skip reasons.
+func (ac *ApiController) GetImportJob(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	importJobID := c.Param("importJobId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+
+	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+		return
+	}
+
+	snap, err := ac.FirestoreClient.Collection(importJobsCollection).Doc(importJobID).Get(ctx)
+	if err != nil && !isNotFound(err) {
+		log.WithError(err).WithField("import_job_id", importJobID).Error("Failed to load import job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load import job"})
+		return
+	}
+	var job ImportJob
+	if err != nil || snap.DataTo(&job) != nil || job.WorkspaceID != workspaceID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// importContentLength returns the archive size reported by a HEAD request, or -1 when the
+// server does not report one. Only a failure to reach the server is an error.
+func (ac *ApiController) importContentLength(ctx context.Context, sourceURL string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, sourceURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := ac.outbound.Client(importHeadTimeout).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return -1, nil
+	}
+	return resp.ContentLength, nil
+}
+
+// runURLImport downloads the archive and runs it through the import pipeline.
+func (ac *ApiController) runURLImport(ctx context.Context, jobRef *docstore.DocumentRef, job ImportJob) error {
+	logCtx := log.WithFields(log.Fields{"import_job_id": job.ImportJobID, "workspace_id": job.WorkspaceID})
+	ac.setImportStatus(ctx, jobRef, "downloading")
+
+	archive, size, err := ac.downloadImportArchive(ctx, job.SourceURL)
+	if err != nil {
+		ac.failImport(ctx, jobRef, err.Error(), nil)
+		return fmt.Errorf("import download failed: %w", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	ac.setImportStatus(ctx, jobRef, "importing")
+	result, err := readImportArchive(archive, size, importPolicy{
+		TargetPath:        job.TargetPath,
+		MaxFileBytes:      ac.AppConfig.ImportMaxFileBytes,
+		MaxTotalBytes:     ac.AppConfig.ImportMaxArchiveBytes,
+		BlockedExtensions: ac.AppConfig.ImportBlockedExtensions,
+	})
+	if err != nil {
+		var skipped []ImportSkip
+		if result != nil {
+			skipped = result.Skipped
+		}
+		ac.failImport(ctx, jobRef, err.Error(), skipped)
+		return fmt.Errorf("import archive rejected: %w", err)
+	}
+
+	commit, err := ac.commitImport(ctx, job.WorkspaceID, result.Entries)
+	if err != nil {
+		message := "Failed to save imported files"
+		var quotaErr *storageQuotaError
+		switch {
+		case errors.Is(err, errWorkspaceReadOnly):
+			message = "Workspace is read-only"
+		case errors.As(err, &quotaErr):
+			message = fmt.Sprintf("Import would grow the workspace to %d bytes, over its %d byte quota", quotaErr.quota.ProjectedBytes, quotaErr.quota.LimitBytes)
+		case errors.Is(err, errImportPathConflict):
+			message = err.Error()
+		}
+		ac.failImport(ctx, jobRef, message, result.Skipped)
+		return fmt.Errorf("import commit failed: %w", err)
+	}
+
+	skipped, skippedCount := capImportSkips(result.Skipped)
+	now := NowISO8601()
+	if _, err := jobRef.Update(ctx, []docstore.Update{
+		{Path: "status", Value: "completed"},
+		{Path: "files_imported", Value: commit.Totals.FilesUpserted},
+		{Path: "bytes_imported", Value: commit.Totals.BytesAdded},
+		{Path: "skipped", Value: skipped},
+		{Path: "skipped_count", Value: skippedCount},
+		{Path: "workspace_version", Value: commit.WorkspaceVersion},
+		{Path: "updated_at", Value: now},
+		{Path: "completed_at", Value: now},
+	}); err != nil {
+		logCtx.WithError(err).Warn("Import committed but the job status could not be updated.")
+	}
+
+	logCtx.WithFields(log.Fields{
+		"files_imported":    commit.Totals.FilesUpserted,
+		"bytes_imported":    commit.Totals.BytesAdded,
+		"skipped":           skippedCount,
+		"workspace_version": commit.WorkspaceVersion,
+	}).Info("Workspace import committed.")
+	ac.recordActivity(ctx, job.WorkspaceID, job.UserID, activityImportCommitted, map[string]interface{}{
+		"import_job_id":     job.ImportJobID,
+		"source_url":        job.SourceURL,
+		"workspace_version": commit.WorkspaceVersion,
+		"files_upserted":    commit.Totals.FilesUpserted,
+		"bytes_added":       commit.Totals.BytesAdded,
+		"bytes_removed":     commit.Totals.BytesRemoved,
+	})
+	ac.recordWorkspaceUsage(ctx, job.WorkspaceID, map[string]interface{}{
+		"storage_bytes": commit.StorageBytes,
+	})
+
+	indexingJobID := uuid.New().String()
+	if err := ac.requestRagIndexing(ctx, indexingJobID, job.WorkspaceID, commit.WorkspaceVersion, commit.Files); err != nil {
+		logCtx.WithError(err).WithField("indexing_job_id", indexingJobID).Error("Failed to request RAG indexing for imported files")
+	}
+	return nil
+}
+
+// downloadImportArchive saves the archive to a temporary file, failing once it exceeds the
+// configured maximum. The caller removes the file.
+func (ac *ApiController) downloadImportArchive(ctx context.Context, sourceURL string) (*os.File, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid import URL")
+	}
+	resp, err := ac.outbound.Client(ac.AppConfig.ImportTimeout).Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, 0, fmt.Errorf("download failed with HTTP %d", resp.StatusCode)
+	}
+
+	tmp, err := os.CreateTemp("", "workspace-import-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	maxBytes := ac.AppConfig.ImportMaxArchiveBytes
+	size, err := io.Copy(tmp, io.LimitReader(resp.Body, maxBytes+1))
+	if err == nil && size > maxBytes {
+		err = fmt.Errorf("archive is larger than the %d byte import limit", maxBytes)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+	return tmp, size, nil
+}
+
+// commitImport uploads the files to R2 and then, in one transaction, writes their metadata,
+// creates missing parent folders and bumps the workspace version. Replaced objects are
+// deleted afterwards; on failure the freshly uploaded objects are removed instead.
+func (ac *ApiController) commitImport(ctx context.Context, workspaceID string, entries []importEntry) (*importCommit, error) {
+	logCtx := log.WithField("workspace_id", workspaceID)
+	folders := importFolders(entries)
+	if len(entries)+len(folders)+1 > 500 {
+		return nil, fmt.Errorf("%w: too many files and folders for one import", errImportPathConflict)
+	}
+
+	newFiles := make(map[string]FileMetadata, len(entries))
+	uploadedKeys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		fileID := uuid.New().String()
+		key := fmt.Sprintf("workspaces/%s/files/%s/%s", workspaceID, fileID, path.Base(entry.Path))
+		if _, err := ac.r2().S3.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(ac.R2BucketName),
+			Key:           aws.String(key),
+			Body:          bytes.NewReader(entry.Data),
+			ContentLength: aws.Int64(int64(len(entry.Data))),
+		}); err != nil {
+			ac.discardImportUploads(ctx, workspaceID, uploadedKeys)
+			return nil, fmt.Errorf("failed to upload %s: %w", entry.Path, err)
+		}
+		uploadedKeys = append(uploadedKeys, key)
+
+		sum := sha256.Sum256(entry.Data)
+		newFiles[entry.Path] = FileMetadata{
+			FileID:      fileID,
+			FilePath:    entry.Path,
+			Type:        "file",
+			R2ObjectKey: key,
+			Size:        int64(len(entry.Data)),
+			Hash:        hex.EncodeToString(sum[:]),
+		}
+	}
+
+	filesCollection := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID))
+	wsDocRef := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+	var commit importCommit
+	var replacedKeys []string
+
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		commit = importCommit{}
+		replacedKeys = nil
+
+		workspace, version, err := beginWorkspaceCommit(tx, wsDocRef)
+		if err != nil {
+			return err
+		}
+
+		paths := make([]string, 0, len(entries)+len(folders))
+		refs := make([]*docstore.DocumentRef, 0, len(entries)+len(folders))
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+			refs = append(refs, filesCollection.Doc(SanitizePathToDocID(entry.Path)))
+		}
+		for _, folder := range folders {
+			paths = append(paths, folder)
+			refs = append(refs, filesCollection.Doc(SanitizePathToDocID(folder)))
+		}
+		snaps, err := tx.GetAll(refs)
+		if err != nil {
+			return fmt.Errorf("failed to read existing files: %w", err)
+		}
+		existing := make(map[string]*FileMetadata, len(snaps))
+		for i, snap := range snaps {
+			if !snap.Exists() {
+				continue
+			}
+			var meta FileMetadata
+			if err := snap.DataTo(&meta); err == nil {
+				existing[paths[i]] = &meta
+			}
+		}
+
+		now := NowISO8601()
+		commit.WorkspaceVersion = version
+		for _, entry := range entries {
+			meta := newFiles[entry.Path]
+			meta.CreatedAt, meta.UpdatedAt = now, now
+			meta.Version = commit.WorkspaceVersion
+			if old := existing[entry.Path]; old != nil {
+				if old.Type != "file" {
+					return fmt.Errorf("%w: %s is a folder", errImportPathConflict, entry.Path)
+				}
+				meta.CreatedAt = old.CreatedAt
+				commit.Totals.BytesRemoved += old.Size
+				if old.R2ObjectKey != "" {
+					replacedKeys = append(replacedKeys, old.R2ObjectKey)
+				}
+			}
+			commit.Totals.FilesUpserted++
+			commit.Totals.BytesAdded += meta.Size
+			commit.Files = append(commit.Files, WorkerFile{R2ObjectKey: meta.R2ObjectKey, FilePath: meta.FilePath})
+		}
+		for _, folder := range folders {
+			if old := existing[folder]; old != nil && old.Type != "folder" {
+				return fmt.Errorf("%w: %s is a file", errImportPathConflict, folder)
+			}
+		}
+
+		commit.StorageBytes = workspace.StorageBytes + commit.Totals.BytesAdded - commit.Totals.BytesRemoved
+		if err := ac.checkStorageQuota(workspace, commit.Totals.BytesAdded-commit.Totals.BytesRemoved); err != nil {
+			return err
+		}
+		if err := tx.Update(wsDocRef, []docstore.Update{
+			{Path: "workspace_version", Value: commit.WorkspaceVersion},
+			{Path: "updated_at", Value: now},
+			{Path: "storage_bytes", Value: docstore.Increment(commit.Totals.BytesAdded - commit.Totals.BytesRemoved)},
+		}); err != nil {
+			return fmt.Errorf("failed to increment workspace version: %w", err)
+		}
+		changes := make([]WorkspaceChange, 0, len(entries)+len(folders))
+		for i, entry := range entries {
+			meta := newFiles[entry.Path]
+			meta.CreatedAt, meta.UpdatedAt = now, now
+			if old := existing[entry.Path]; old != nil {
+				meta.CreatedAt = old.CreatedAt
+			}
+			if err := tx.Set(refs[i], meta); err != nil {
+				return fmt.Errorf("failed to write file %s: %w", entry.Path, err)
+			}
+			changes = append(changes, WorkspaceChange{FilePath: entry.Path, Type: "file", Action: "upsert"})
+		}
+		for i, folder := range folders {
+			if existing[folder] != nil {
+				continue
+			}
+			if err := tx.Set(refs[len(entries)+i], newFolderEntry(folder, commit.WorkspaceVersion, now)); err != nil {
+				return fmt.Errorf("failed to write folder %s: %w", folder, err)
+			}
+			changes = append(changes, WorkspaceChange{FilePath: folder, Type: "folder", Action: "upsert"})
+		}
+		return ac.recordWorkspaceChanges(tx, workspaceID, commit.WorkspaceVersion, changes)
+	})
+	if err != nil {
+		ac.discardImportUploads(ctx, workspaceID, uploadedKeys)
+		return nil, err
+	}
+
+	if len(replacedKeys) > 0 {
+		if failures := ac.deleteR2Objects(ctx, replacedKeys); len(failures) > 0 {
+			logCtx.Errorf("Failed to delete %d replaced R2 objects after import; queueing them for retry.", len(failures))
+			ac.recordFailedR2Deletions(ctx, workspaceID, failures)
+		}
+	}
+	return &commit, nil
+}
+
+// discardImportUploads removes objects uploaded for an import that was not committed.
+func (ac *ApiController) discardImportUploads(ctx context.Context, workspaceID string, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	if failures := ac.deleteR2Objects(ctx, keys); len(failures) > 0 {
+		ac.recordFailedR2Deletions(ctx, workspaceID, failures)
+	}
+}
+
+// setImportStatus records progress on an import job; failures are only logged.
+func (ac *ApiController) setImportStatus(ctx context.Context, jobRef *docstore.DocumentRef, status string) {
+	if _, err := jobRef.Update(ctx, []docstore.Update{
+		{Path: "status", Value: status},
+		{Path: "updated_at", Value: NowISO8601()},
+	}); err != nil {
+		log.WithError(err).WithField("import_job_id", jobRef.ID).Warn("Failed to update import job status.")
+	}
+}
+
+// failImport marks an import job failed. It uses a fresh deadline because the job's own
+// context may already have expired.
+func (ac *ApiController) failImport(ctx context.Context, jobRef *docstore.DocumentRef, message string, skipped []ImportSkip) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	stored, count := capImportSkips(skipped)
+	now := NowISO8601()
+	if _, err := jobRef.Update(ctx, []docstore.Update{
+		{Path: "status", Value: "failed"},
+		{Path: "error", Value: message},
+		{Path: "skipped", Value: stored},
+		{Path: "skipped_count", Value: count},
+		{Path: "updated_at", Value: now},
+		{Path: "completed_at", Value: now},
+	}); err != nil {
+		log.WithError(err).WithField("import_job_id", jobRef.ID).Error("Failed to mark import job as failed.")
+	}
+}
+
+// capImportSkips keeps the first maxStoredImportSkips skip reasons and returns the total.
+func capImportSkips(skipped []ImportSkip) ([]ImportSkip, int) {
+	if len(skipped) > maxStoredImportSkips {
+		return skipped[:maxStoredImportSkips], len(skipped)
+	}
+	return skipped, len(skipped)
+}
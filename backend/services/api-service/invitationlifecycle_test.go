@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var invitationTestNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func pendingInvitation() Invitation {
+	return Invitation{
+		Status:     invitationPending,
+		CreatedAt:  TimeToISO8601(invitationTestNow.Add(-time.Hour)),
+		LastSentAt: TimeToISO8601(invitationTestNow.Add(-time.Hour)),
+		ExpiresAt:  TimeToISO8601(invitationTestNow.Add(24 * time.Hour)),
+	}
+}
+
+func TestInvitationExpired(t *testing.T) {
+	ttl := 14 * 24 * time.Hour
+	inv := pendingInvitation()
+	assert.False(t, invitationExpired(inv, invitationTestNow, ttl))
+
+	inv.ExpiresAt = TimeToISO8601(invitationTestNow)
+	assert.True(t, invitationExpired(inv, invitationTestNow, ttl))
+
+	// Invitations without expires_at expire ttl after creation.
+	legacy := Invitation{CreatedAt: TimeToISO8601(invitationTestNow.Add(-13 * 24 * time.Hour))}
+	assert.False(t, invitationExpired(legacy, invitationTestNow, ttl))
+	legacy.CreatedAt = TimeToISO8601(invitationTestNow.Add(-15 * 24 * time.Hour))
+	assert.True(t, invitationExpired(legacy, invitationTestNow, ttl))
+	assert.True(t, invitationExpired(Invitation{}, invitationTestNow, ttl))
+}
+
+func TestCheckInvitationAcceptable(t *testing.T) {
+	ttl := 14 * 24 * time.Hour
+	assert.NoError(t, checkInvitationAcceptable(pendingInvitation(), invitationTestNow, ttl))
+
+	expired := pendingInvitation()
+	expired.ExpiresAt = TimeToISO8601(invitationTestNow.Add(-time.Minute))
+	assert.ErrorIs(t, checkInvitationAcceptable(expired, invitationTestNow, ttl), errInvitationExpired)
+
+	revoked := expired
+	revoked.Status = invitationRevoked
+	assert.ErrorIs(t, checkInvitationAcceptable(revoked, invitationTestNow, ttl), errInvitationRevoked)
+
+	accepted := pendingInvitation()
+	accepted.AcceptedAt = TimeToISO8601(invitationTestNow.Add(-time.Minute))
+	assert.ErrorIs(t, checkInvitationAcceptable(accepted, invitationTestNow, ttl), errInvitationUsed)
+
+	// The invitation is still open but the access it grants has already lapsed.
+	lapsed := pendingInvitation()
+	lapsed.MembershipExpiresAt = TimeToISO8601(invitationTestNow)
+	assert.ErrorIs(t, checkInvitationAcceptable(lapsed, invitationTestNow, ttl), errInvitationExpired)
+	lapsed.MembershipExpiresAt = TimeToISO8601(invitationTestNow.Add(time.Hour))
+	assert.NoError(t, checkInvitationAcceptable(lapsed, invitationTestNow, ttl))
+}
+
+func TestInvitationAcceptRevokeRace(t *testing.T) {
+	ttl := 14 * 24 * time.Hour
+	accept := func(inv *Invitation) error {
+		if err := checkInvitationAcceptable(*inv, invitationTestNow, ttl); err != nil {
+			return err
+		}
+		inv.Status, inv.AcceptedAt = invitationAccepted, TimeToISO8601(invitationTestNow)
+		return nil
+	}
+	revoke := func(inv *Invitation) error {
+		if err := checkInvitationRevocable(*inv); err != nil {
+			return err
+		}
+		inv.Status, inv.RevokedAt = invitationRevoked, TimeToISO8601(invitationTestNow)
+		return nil
+	}
+
+	// The transactions serialize on the invitation document; whichever commits second sees
+	// the first one's write and must fail without changing it.
+	inv := pendingInvitation()
+	assert.NoError(t, accept(&inv))
+	assert.ErrorIs(t, revoke(&inv), errInvitationUsed)
+	assert.Equal(t, invitationAccepted, inv.Status)
+	assert.ErrorIs(t, accept(&inv), errInvitationUsed) // Replaying the link
+
+	inv = pendingInvitation()
+	assert.NoError(t, revoke(&inv))
+	assert.ErrorIs(t, accept(&inv), errInvitationRevoked)
+	assert.Equal(t, invitationRevoked, inv.Status)
+	assert.Empty(t, inv.AcceptedAt)
+	assert.ErrorIs(t, revoke(&inv), errInvitationRevoked) // Treated as success by the handler
+}
+
+func TestInvitationResendWait(t *testing.T) {
+	cooldown := 10 * time.Minute
+	inv := pendingInvitation()
+	assert.Equal(t, time.Duration(0), invitationResendWait(inv, invitationTestNow, cooldown))
+
+	inv.LastSentAt = TimeToISO8601(invitationTestNow.Add(-4 * time.Minute))
+	assert.Equal(t, 6*time.Minute, invitationResendWait(inv, invitationTestNow, cooldown))
+
+	// Invitations sent before last_sent_at was tracked use their creation time.
+	inv.LastSentAt = ""
+	inv.CreatedAt = TimeToISO8601(invitationTestNow.Add(-time.Minute))
+	assert.Equal(t, 9*time.Minute, invitationResendWait(inv, invitationTestNow, cooldown))
+}
+
+func TestInvitationGoneCodesAreDistinct(t *testing.T) {
+	seen := map[string]bool{}
+	for _, code := range invitationGoneCodes {
+		assert.False(t, seen[code], code)
+		seen[code] = true
+	}
+	assert.Len(t, seen, 3)
+}
+
+func TestInvitationAddressedTo(t *testing.T) {
+	inv := Invitation{Email: "ada@example.com"}
+	assert.True(t, invitationAddressedTo(inv, "ada@example.com"))
+	assert.True(t, invitationAddressedTo(inv, " Ada@Example.COM"), "case and padding are ignored")
+	assert.False(t, invitationAddressedTo(inv, "eve@example.com"))
+	assert.False(t, invitationAddressedTo(inv, ""), "a caller without a verified email matches nothing")
+}
+
+// acceptInvitationAs accepts the invitation with token as userID signed in with email.
+func acceptInvitationAs(h *handlerHarness, userID, email, token string, out interface{}) int {
+	h.t.Helper()
+	body, _ := json.Marshal(AcceptInvitationRequest{Token: token})
+	req := httptest.NewRequest(http.MethodPost, "/api/invitations/accept", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(testUserHeader, userID)
+	req.Header.Set(testEmailHeader, email)
+	w := httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	if out != nil {
+		json.Unmarshal(w.Body.Bytes(), out)
+	}
+	return w.Code
+}
+
+func TestHandlers_InvitationFlow(t *testing.T) {
+	h := newHandlerHarness(t)
+	ctx := context.Background()
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	invitationsPath := "/api/workspaces/" + workspaceID + "/invitations"
+
+	var inv, other Invitation
+	w := h.do(http.MethodPost, invitationsPath, owner, gin.H{"email": "Ada@Example.com", "role": "editor"}, &inv)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	h.do(http.MethodPost, invitationsPath, owner, gin.H{"email": "bob@example.com", "role": "viewer"}, &other)
+	snap, err := h.fs.Collection(invitationsCollection).Doc(inv.InvitationID).Get(ctx)
+	assert.NoError(t, err)
+	var stored Invitation
+	assert.NoError(t, snap.DataTo(&stored))
+
+	var listed struct {
+		Invitations []Invitation `json:"invitations"`
+	}
+	w = h.do(http.MethodGet, invitationsPath, owner, nil, &listed)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Len(t, listed.Invitations, 2)
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodGet, invitationsPath, "stranger-"+uuid.New().String(), nil, nil).Code)
+
+	ada := "ada-" + uuid.New().String()
+	var refused map[string]interface{}
+	assert.Equal(t, http.StatusForbidden, acceptInvitationAs(h, ada, "eve@example.com", stored.Token, &refused))
+	assert.Equal(t, "email_mismatch", refused["code"])
+	assert.Equal(t, http.StatusForbidden, acceptInvitationAs(h, ada, "", stored.Token, nil), "an unverified email is refused")
+
+	var membership WorkspaceMembership
+	assert.Equal(t, http.StatusOK, acceptInvitationAs(h, ada, "ADA@example.com", stored.Token, &membership))
+	assert.Equal(t, "editor", membership.Role)
+	assert.Equal(t, http.StatusGone, acceptInvitationAs(h, ada, "ada@example.com", stored.Token, nil), "an invitation works once")
+
+	w = h.do(http.MethodDelete, invitationsPath+"/"+other.InvitationID, owner, nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = h.do(http.MethodGet, invitationsPath, owner, nil, &listed)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, listed.Invitations, "accepted and revoked invitations are not pending")
+	h.do(http.MethodGet, invitationsPath+"?status=all", owner, nil, &listed)
+	assert.Len(t, listed.Invitations, 2)
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodGet, invitationsPath+"?status=expired", owner, nil, nil).Code)
+}
+
+func TestHandlers_AcceptInvitationByID(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	invitesPath := "/api/workspaces/" + workspaceID + "/invites"
+	accept := func(invitationID, userID, email string, out interface{}) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/invites/"+invitationID+"/accept", nil)
+		req.Header.Set(testUserHeader, userID)
+		req.Header.Set(testEmailHeader, email)
+		w := httptest.NewRecorder()
+		h.router.ServeHTTP(w, req)
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, invitesPath, "stranger-"+uuid.New().String(), gin.H{"email": "ada@example.com", "role": "editor"}, nil).Code)
+	var inv Invitation
+	w := h.do(http.MethodPost, invitesPath, owner, gin.H{"email": "ada@example.com", "role": "viewer"}, &inv)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	ada := "ada-" + uuid.New().String()
+	assert.Equal(t, http.StatusNotFound, accept(uuid.New().String(), ada, "ada@example.com", nil))
+	var refused map[string]interface{}
+	assert.Equal(t, http.StatusForbidden, accept(inv.InvitationID, ada, "eve@example.com", &refused))
+	assert.Equal(t, "email_mismatch", refused["code"])
+
+	var membership WorkspaceMembership
+	assert.Equal(t, http.StatusOK, accept(inv.InvitationID, ada, "Ada@Example.com", &membership))
+	assert.Equal(t, workspaceID, membership.WorkspaceID)
+	assert.Equal(t, roleViewer, membership.Role)
+	assert.Equal(t, http.StatusGone, accept(inv.InvitationID, ada, "ada@example.com", nil), "an invitation works once")
+}
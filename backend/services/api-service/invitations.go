@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// invitationsCollection holds workspace invitations, keyed by invitation ID.
+const invitationsCollection = "workspace_invitations"
+
+// invitationListLimit caps GET /workspaces/:workspaceId/invitations; older invitations are
+// purged by the invitation-purge task, so a workspace rarely has more.
+const invitationListLimit = 200
+
+// Invitation delivery states recorded on the invitation document.
+const (
+	deliveryQueued   = "queued"
+	deliverySent     = "sent"
+	deliveryFailed   = "failed"
+	deliveryDisabled = "disabled" // No notifier configured; the invitee sees it on next login
+)
+
+// errInvitationExists is returned when the email already has a pending, unexpired invitation
+// to the workspace; the owner should resend that one instead.
+var errInvitationExists = errors.New("an invitation is already pending for this email")
+
+// newInvitationToken returns an unguessable token for the accept link.
+func newInvitationToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate invitation token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// invitationAcceptLink builds the frontend URL the invitee follows to accept.
+func (ac *ApiController) invitationAcceptLink(token string) string {
+	return fmt.Sprintf("%s/invitations/accept?token=%s", ac.AppConfig.AppBaseURL, url.QueryEscape(token))
+}
+
+// invitationCallbackURL is where the email sender reports the delivery outcome.
+func (ac *ApiController) invitationCallbackURL(invitationID string) string {
+	return fmt.Sprintf("%s/internal/notifications/invitations/%s", ac.AppConfig.APIBaseURL, invitationID)
+}
+
+// requireWorkspaceOwner writes a 403/500 unless the caller owns the workspace. It returns
+// nil when the request has already been answered.
+func (ac *ApiController) requireWorkspaceOwner(c *gin.Context, workspaceID, userID string, logCtx *log.Entry) *WorkspaceMembership {
+	return ac.requireWorkspaceRole(c, workspaceID, userID, roleOwner, logCtx)
+}
+
+// createInvitation stores a pending invitation and hands it to the notifier.
+func (ac *ApiController) createInvitation(ctx context.Context, workspace Workspace, inviter *WorkspaceMembership, req InviteRequest) (*Invitation, error) {
+	now := time.Now()
+	membershipExpiresAt, err := parseMembershipExpiry(req.MembershipExpiresAt, now)
+	if err != nil {
+		return nil, err
+	}
+	invitationID := uuid.New().String()
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if err := ac.checkNoPendingInvitation(ctx, workspace.WorkspaceID, email, now); err != nil {
+		return nil, err
+	}
+	expiresAt := now.Add(ac.AppConfig.InvitationTTL)
+	token, err := ac.newInvitationAcceptToken(invitationID, email, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Invitation{
+		InvitationID:   invitationID,
+		WorkspaceID:    workspace.WorkspaceID,
+		WorkspaceName:  workspace.Name,
+		Email:          email,
+		Role:           req.Role,
+		InvitedBy:      inviter.UserID,
+		InviterName:    inviter.UserName,
+		Token:          token,
+		Status:         invitationPending,
+		DeliveryStatus: deliveryQueued,
+		CreatedAt:      TimeToISO8601(now),
+		ExpiresAt:      TimeToISO8601(expiresAt),
+		LastSentAt:     TimeToISO8601(now),
+
+		MembershipExpiresAt: membershipExpiresAt,
+	}
+	if ac.Notifier == nil {
+		inv.DeliveryStatus = deliveryDisabled
+	}
+
+	ref := ac.FirestoreClient.Collection(invitationsCollection).Doc(inv.InvitationID)
+	if _, err := ref.Set(ctx, inv); err != nil {
+		return nil, fmt.Errorf("failed to store invitation: %w", err)
+	}
+
+	if ac.Notifier != nil {
+		ac.deliverInvitation(ctx, ref, inv)
+	}
+	return inv, nil
+}
+
+// checkNoPendingInvitation returns errInvitationExists when email already has a pending
+// invitation to the workspace that has not expired.
+func (ac *ApiController) checkNoPendingInvitation(ctx context.Context, workspaceID, email string, now time.Time) error {
+	docs, err := ac.FirestoreClient.Collection(invitationsCollection).
+		Where("workspace_id", "==", workspaceID).
+		Where("email", "==", email).
+		Where("status", "==", invitationPending).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to check pending invitations: %w", err)
+	}
+	for _, doc := range docs {
+		var inv Invitation
+		if err := doc.DataTo(&inv); err == nil && !invitationExpired(inv, now, ac.AppConfig.InvitationTTL) {
+			return errInvitationExists
+		}
+	}
+	return nil
+}
+
+// deliverInvitation asks the notifier to send an invitation and records the attempt. A failure
+// leaves the invitation in "failed" for the invitation-deliveries maintenance task to retry.
+func (ac *ApiController) deliverInvitation(ctx context.Context, ref *docstore.DocumentRef, inv *Invitation) {
+	logCtx := log.WithFields(log.Fields{"invitation_id": inv.InvitationID, "workspace_id": inv.WorkspaceID})
+
+	err := ac.Notifier.NotifyInvitation(ctx, InvitationNotification{
+		InvitationID:  inv.InvitationID,
+		Recipient:     inv.Email,
+		TemplateID:    ac.AppConfig.InvitationTemplateID,
+		WorkspaceName: inv.WorkspaceName,
+		InviterName:   inv.InviterName,
+		AcceptLink:    ac.invitationAcceptLink(inv.Token),
+		CallbackURL:   ac.invitationCallbackURL(inv.InvitationID),
+	})
+
+	inv.DeliveryAttempts++
+	inv.DeliveryUpdatedAt = NowISO8601()
+	inv.DeliveryStatus, inv.DeliveryError = deliveryQueued, ""
+	if err != nil {
+		logCtx.WithError(err).Warn("Failed to hand invitation to notifier.")
+		inv.DeliveryStatus, inv.DeliveryError = deliveryFailed, err.Error()
+	}
+
+	if _, err := ref.Update(ctx, []docstore.Update{
+		{Path: "delivery_status", Value: inv.DeliveryStatus},
+		{Path: "delivery_error", Value: inv.DeliveryError},
+		{Path: "delivery_attempts", Value: docstore.Increment(1)},
+		{Path: "delivery_updated_at", Value: inv.DeliveryUpdatedAt},
+	}); err != nil {
+		logCtx.WithError(err).Warn("Failed to record invitation delivery state.")
+	}
+}
+
+// loadWorkspace reads a workspace document.
+func (ac *ApiController) loadWorkspace(ctx context.Context, workspaceID string) (Workspace, error) {
+	var workspace Workspace
+	snap, err := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID).Get(ctx)
+	if err != nil {
+		return workspace, err
+	}
+	if err := snap.DataTo(&workspace); err != nil {
+		return workspace, fmt.Errorf("failed to parse workspace: %w", err)
+	}
+	return workspace, nil
+}
+
+// CreateInvitation invites one person to the workspace (owners only).
+func (ac *ApiController) CreateInvitation(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "CreateInvitation"})
+
+	inviter := ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx)
+	if inviter == nil {
+		return
+	}
+
+	var req InviteRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+
+	workspace, err := ac.loadWorkspace(ctx, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load workspace for invitation.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace"})
+		return
+	}
+
+	inv, err := ac.createInvitation(ctx, workspace, inviter, req)
+	if errors.Is(err, errInvalidMembership) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, errInvitationExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An invitation is already pending for this email; resend it instead", "code": "invitation_exists"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to create invitation.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	logCtx.WithFields(log.Fields{"invitation_id": inv.InvitationID, "delivery_status": inv.DeliveryStatus}).Info("Invitation created.")
+	c.JSON(http.StatusCreated, inv)
+}
+
+// BulkCreateInvitations invites up to 50 people at once (owners only). Entries are processed
+// independently; the response reports each one.
+func (ac *ApiController) BulkCreateInvitations(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "BulkCreateInvitations"})
+
+	inviter := ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx)
+	if inviter == nil {
+		return
+	}
+
+	var req BulkInviteRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+
+	workspace, err := ac.loadWorkspace(ctx, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load workspace for invitations.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace"})
+		return
+	}
+
+	results := make([]BulkInviteResult, 0, len(req.Invitations))
+	created := 0
+	for _, entry := range req.Invitations {
+		inv, err := ac.createInvitation(ctx, workspace, inviter, entry)
+		if errors.Is(err, errInvalidMembership) || errors.Is(err, errInvitationExists) {
+			results = append(results, BulkInviteResult{Email: entry.Email, Error: err.Error()})
+			continue
+		}
+		if err != nil {
+			logCtx.WithError(err).WithField("email", entry.Email).Error("Failed to create invitation.")
+			results = append(results, BulkInviteResult{Email: entry.Email, Error: "Failed to create invitation"})
+			continue
+		}
+		created++
+		results = append(results, BulkInviteResult{Email: entry.Email, Invitation: inv})
+	}
+
+	logCtx.WithFields(log.Fields{"requested": len(req.Invitations), "created": created}).Info("Bulk invitations processed.")
+	c.JSON(http.StatusOK, gin.H{"created": created, "results": results})
+}
+
+// invitationListStatuses are the status filters of ListInvitations.
+var invitationListStatuses = map[string]bool{
+	invitationPending:  true,
+	invitationAccepted: true,
+	invitationRevoked:  true,
+	"all":              true,
+}
+
+// ListInvitations lists a workspace's invitations, newest first (owners only). Query param:
+// status=pending (default; expired ones included and flagged), accepted, revoked or all.
+// Needs composite indexes on workspace_id/created_at and workspace_id/status/created_at.
+func (ac *ApiController) ListInvitations(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "ListInvitations"})
+
+	status := c.DefaultQuery("status", invitationPending)
+	if !invitationListStatuses[status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of pending, accepted, revoked, all"})
+		return
+	}
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+
+	q := ac.FirestoreClient.Collection(invitationsCollection).Where("workspace_id", "==", workspaceID)
+	if status != "all" {
+		q = q.Where("status", "==", status)
+	}
+	docs, err := q.OrderBy("created_at", docstore.Desc).Limit(invitationListLimit).Documents(ctx).GetAll()
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to list invitations.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load invitations"})
+		return
+	}
+
+	now := time.Now()
+	invitations := make([]Invitation, 0, len(docs))
+	for _, doc := range docs {
+		var inv Invitation
+		if err := doc.DataTo(&inv); err != nil {
+			logCtx.WithError(err).WithField("invitation_id", doc.Ref.ID).Warn("Failed to parse invitation.")
+			continue
+		}
+		inv.Expired = inv.Status == invitationPending && invitationExpired(inv, now, ac.AppConfig.InvitationTTL)
+		invitations = append(invitations, inv)
+	}
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// HandleInvitationDeliveryCallback records the email sender's final delivery outcome.
+func (ac *ApiController) HandleInvitationDeliveryCallback(c *gin.Context) {
+	invitationID := c.Param("invitationId")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"invitation_id": invitationID, "handler": "HandleInvitationDeliveryCallback"})
+
+	var req InvitationDeliveryCallback
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+
+	ref := ac.FirestoreClient.Collection(invitationsCollection).Doc(invitationID)
+	_, err := ref.Update(ctx, []docstore.Update{
+		{Path: "delivery_status", Value: req.Status},
+		{Path: "delivery_error", Value: req.Error},
+		{Path: "delivery_updated_at", Value: NowISO8601()},
+	})
+	if isNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to record invitation delivery outcome.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invitation"})
+		return
+	}
+
+	logCtx.WithField("delivery_status", req.Status).Info("Invitation delivery outcome recorded.")
+	c.Status(http.StatusNoContent)
+}
+
+// runInvitationDeliveryMaintenance is the "invitation-deliveries" maintenance task: it retries
+// invitations whose delivery failed, up to InvitationDeliveryMaxAttempts attempts in total.
+func (ac *ApiController) runInvitationDeliveryMaintenance(ctx context.Context) (interface{}, error) {
+	if ac.Notifier == nil {
+		return gin.H{"retried": 0, "disabled": true}, nil
+	}
+
+	// Invitations that used up their attempts stay "failed" and drop out of this query.
+	docs, err := ac.FirestoreClient.Collection(invitationsCollection).
+		Where("delivery_status", "==", deliveryFailed).
+		Where("delivery_attempts", "<", ac.AppConfig.InvitationDeliveryMaxAttempts).
+		Limit(100).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load failed invitation deliveries: %w", err)
+	}
+
+	retried := 0
+	for _, doc := range docs {
+		var inv Invitation
+		if err := doc.DataTo(&inv); err != nil {
+			log.WithError(err).WithField("invitation_id", doc.Ref.ID).Warn("Failed to parse invitation.")
+			continue
+		}
+		if checkInvitationAcceptable(inv, time.Now(), ac.AppConfig.InvitationTTL) != nil {
+			continue // Revoked, accepted or expired since the failed attempt
+		}
+		ac.deliverInvitation(ctx, doc.Ref, &inv)
+		retried++
+	}
+	return gin.H{"retried": retried}, nil
+}
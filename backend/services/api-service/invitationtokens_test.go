@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvitationTokenRoundTrip(t *testing.T) {
+	claims := invitationTokenClaims{InvitationID: "inv-1", Email: "ada@example.com", ExpiresAt: invitationTestNow.Unix()}
+	token := encodeInvitationToken("key", claims)
+
+	got, err := decodeInvitationToken("key", token)
+	assert.NoError(t, err)
+	assert.Equal(t, claims, got)
+
+	_, err = decodeInvitationToken("other-key", token)
+	assert.ErrorIs(t, err, errInvalidInvitationToken, "a token signed with another key is refused")
+
+	payload, sig, _ := strings.Cut(token, ".")
+	forged, _ := json.Marshal(invitationTokenClaims{InvitationID: "inv-1", Email: "eve@example.com", ExpiresAt: claims.ExpiresAt})
+	for _, bad := range []string{
+		"", "no-dot", payload, payload + ".",
+		encodeInvitationToken("key", invitationTokenClaims{InvitationID: "inv-1"}),
+		base64.RawURLEncoding.EncodeToString(forged) + "." + sig,
+		encodeInvitationToken("key", invitationTokenClaims{Email: "ada@example.com"}),
+	} {
+		_, err := decodeInvitationToken("key", bad)
+		assert.ErrorIs(t, err, errInvalidInvitationToken, "%q", bad)
+	}
+}
+
+func TestNewInvitationAcceptToken(t *testing.T) {
+	ac := &ApiController{AppConfig: &AppConfig{}}
+	expires := invitationTestNow.Add(time.Hour)
+	opaque, err := ac.newInvitationAcceptToken("inv-1", "ada@example.com", expires)
+	assert.NoError(t, err)
+	assert.NotContains(t, opaque, ".", "without a key tokens stay opaque")
+	assert.False(t, ac.isSignedInvitationToken(opaque))
+
+	ac.AppConfig.InvitationSigningKey = "key"
+	signed, err := ac.newInvitationAcceptToken("inv-1", "ada@example.com", expires)
+	assert.NoError(t, err)
+	assert.True(t, ac.isSignedInvitationToken(signed))
+	assert.False(t, ac.isSignedInvitationToken(opaque), "opaque tokens issued earlier are still looked up")
+	claims, err := decodeInvitationToken("key", signed)
+	assert.NoError(t, err)
+	assert.Equal(t, expires.Unix(), claims.ExpiresAt)
+}
+
+func TestHandlers_SignedInvitationAccept(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.InvitationSigningKey = "test-invitation-key"
+	ctx := context.Background()
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	invitationsPath := "/api/workspaces/" + workspaceID + "/invitations"
+	accept := func(userID, email, token string, out interface{}) int {
+		body, _ := json.Marshal(AcceptInvitationRequest{Token: token})
+		req := httptest.NewRequest(http.MethodPost, "/api/invites/accept", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(testUserHeader, userID)
+		req.Header.Set(testEmailHeader, email)
+		w := httptest.NewRecorder()
+		h.router.ServeHTTP(w, req)
+		if out != nil {
+			json.Unmarshal(w.Body.Bytes(), out)
+		}
+		return w.Code
+	}
+	storedToken := func(invitationID string) string {
+		snap, err := h.fs.Collection(invitationsCollection).Doc(invitationID).Get(ctx)
+		assert.NoError(t, err)
+		var stored Invitation
+		assert.NoError(t, snap.DataTo(&stored))
+		return stored.Token
+	}
+
+	var inv Invitation
+	w := h.do(http.MethodPost, invitationsPath, owner, gin.H{"email": "Ada@Example.com", "role": "editor"}, &inv)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	token := storedToken(inv.InvitationID)
+	claims, err := decodeInvitationToken("test-invitation-key", token)
+	assert.NoError(t, err)
+	assert.Equal(t, inv.InvitationID, claims.InvitationID)
+	assert.Equal(t, "ada@example.com", claims.Email)
+
+	ada := "ada-" + uuid.New().String()
+	var refused map[string]interface{}
+	assert.Equal(t, http.StatusForbidden, accept(ada, "eve@example.com", token, &refused))
+	assert.Equal(t, "email_mismatch", refused["code"])
+	assert.Equal(t, "ada@example.com", refused["invitedEmail"])
+	assert.Equal(t, http.StatusNotFound, accept(ada, "ada@example.com", encodeInvitationToken("wrong-key", claims), nil), "a token signed with another key is unknown")
+
+	expired := encodeInvitationToken("test-invitation-key", invitationTokenClaims{
+		InvitationID: inv.InvitationID, Email: "ada@example.com", ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+	})
+	var gone map[string]interface{}
+	assert.Equal(t, http.StatusGone, accept(ada, "ada@example.com", expired, &gone))
+	assert.Equal(t, "invitation_expired", gone["code"])
+
+	// A validly signed token that is no longer the stored one (say, replaced by a resend) is unknown.
+	stale := encodeInvitationToken("test-invitation-key", invitationTokenClaims{
+		InvitationID: inv.InvitationID, Email: "ada@example.com", ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	assert.Equal(t, http.StatusNotFound, accept(ada, "ada@example.com", stale, nil))
+
+	var membership WorkspaceMembership
+	assert.Equal(t, http.StatusOK, accept(ada, "ADA@example.com", token, &membership))
+	assert.Equal(t, "editor", membership.Role)
+	assert.Equal(t, http.StatusGone, accept(ada, "ada@example.com", token, nil), "an invitation works once")
+
+	// Invitations sent before the key was configured keep their opaque tokens.
+	var legacy Invitation
+	h.do(http.MethodPost, invitationsPath, owner, gin.H{"email": "bob@example.com", "role": "viewer"}, &legacy)
+	_, err = h.fs.Collection(invitationsCollection).Doc(legacy.InvitationID).Update(ctx, []docstore.Update{{Path: "token", Value: "opaque-token"}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, accept("bob-"+uuid.New().String(), "bob@example.com", "opaque-token", nil))
+}
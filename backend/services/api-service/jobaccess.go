@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+)
+
+// Job execution types, stored in Job.ExecutionType.
+const (
+	executionTypePublic     = "" // Anonymous /api/execute runs
+	executionTypeWorkspace  = "authenticated_r2"
+	executionTypeRagQuery   = "rag_query"
+	executionTypeDataExport = "data_export"
+)
+
+// jobReadPolicy says who may read a job's status and results.
+type jobReadPolicy int
+
+const (
+	jobReadPublic           jobReadPolicy = iota + 1 // Anyone holding the job ID or result token
+	jobReadWorkspaceMembers                          // Any member of the job's workspace
+	jobReadSubmitter                                 // Only the user who submitted it
+)
+
+// jobReadPolicies must name every execution type. Jobs of a type missing here are
+// readable by admins only, so a new job type stays private until it declares a policy.
+var jobReadPolicies = map[string]jobReadPolicy{
+	executionTypePublic:     jobReadPublic,
+	executionTypeWorkspace:  jobReadWorkspaceMembers,
+	executionTypeRagQuery:   jobReadSubmitter, // Q&A is personal to the asker
+	executionTypeDataExport: jobReadSubmitter,
+}
+
+// jobReader identifies who is reading a job; UserID is empty for anonymous callers.
+type jobReader struct {
+	UserID  string
+	IsAdmin bool
+}
+
+// workspaceMembershipFunc reports whether userID belongs to workspaceID.
+type workspaceMembershipFunc func(ctx context.Context, userID, workspaceID string) (bool, error)
+
+// canReadJob applies the job's read policy. Admins can read every job. Membership is only
+// looked up for workspace executions read by someone other than the submitter.
+func canReadJob(ctx context.Context, reader jobReader, job Job, isMember workspaceMembershipFunc) (bool, error) {
+	if reader.IsAdmin {
+		return true, nil
+	}
+	switch jobReadPolicies[job.ExecutionType] {
+	case jobReadPublic:
+		return true, nil
+	case jobReadSubmitter:
+		return reader.UserID != "" && reader.UserID == job.UserID, nil
+	case jobReadWorkspaceMembers:
+		if reader.UserID == "" || job.WorkspaceID == "" {
+			return false, nil
+		}
+		if reader.UserID == job.UserID {
+			return true, nil
+		}
+		return isMember(ctx, reader.UserID, job.WorkspaceID)
+	}
+	return false, nil
+}
+
+// ownsJob reports whether reader submitted job or is an admin. Details that only concern the
+// submitter, such as where the result callback goes, are shown to owners only; anonymous
+// jobs have no owner.
+func ownsJob(reader jobReader, job Job) bool {
+	return reader.IsAdmin || (reader.UserID != "" && reader.UserID == job.UserID)
+}
+
+// isWorkspaceMember adapts checkWorkspaceMembership for canReadJob.
+func (ac *ApiController) isWorkspaceMember(ctx context.Context, userID, workspaceID string) (bool, error) {
+	return checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// knownExecutionTypes lists every execution type the service creates. Adding a type here
+// without a read policy fails TestJobReadPolicies_CoverEveryExecutionType.
+var knownExecutionTypes = []string{
+	executionTypePublic,
+	executionTypeWorkspace,
+	executionTypeRagQuery,
+	executionTypeDataExport,
+}
+
+func TestJobReadPolicies_CoverEveryExecutionType(t *testing.T) {
+	for _, executionType := range knownExecutionTypes {
+		_, ok := jobReadPolicies[executionType]
+		assert.True(t, ok, "execution type %q has no read policy", executionType)
+	}
+	assert.Len(t, jobReadPolicies, len(knownExecutionTypes))
+}
+
+func TestCanReadJob(t *testing.T) {
+	members := map[string]bool{"member": true, "submitter": true}
+	isMember := func(_ context.Context, userID, workspaceID string) (bool, error) {
+		return workspaceID == "ws-1" && members[userID], nil
+	}
+
+	anonymous := jobReader{}
+	submitter := jobReader{UserID: "submitter"}
+	member := jobReader{UserID: "member"}
+	outsider := jobReader{UserID: "outsider"}
+	admin := jobReader{UserID: "admin", IsAdmin: true}
+
+	public := Job{ExecutionType: executionTypePublic}
+	workspace := Job{ExecutionType: executionTypeWorkspace, UserID: "submitter", WorkspaceID: "ws-1"}
+	ragQuery := Job{ExecutionType: executionTypeRagQuery, UserID: "submitter", WorkspaceID: "ws-1"}
+	dataExport := Job{ExecutionType: executionTypeDataExport, UserID: "submitter", WorkspaceID: "ws-1"}
+	undeclared := Job{ExecutionType: "future_type", UserID: "submitter", WorkspaceID: "ws-1"}
+
+	cases := []struct {
+		name   string
+		reader jobReader
+		job    Job
+		want   bool
+	}{
+		{"public/anonymous", anonymous, public, true},
+		{"public/outsider", outsider, public, true},
+		{"workspace/anonymous", anonymous, workspace, false},
+		{"workspace/submitter", submitter, workspace, true},
+		{"workspace/member", member, workspace, true},
+		{"workspace/outsider", outsider, workspace, false},
+		{"workspace/admin", admin, workspace, true},
+		{"rag/anonymous", anonymous, ragQuery, false},
+		{"rag/submitter", submitter, ragQuery, true},
+		{"rag/member", member, ragQuery, false},
+		{"rag/admin", admin, ragQuery, true},
+		{"export/submitter", submitter, dataExport, true},
+		{"export/member", member, dataExport, false},
+		{"export/admin", admin, dataExport, true},
+		{"undeclared/submitter", submitter, undeclared, false},
+		{"undeclared/admin", admin, undeclared, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := canReadJob(context.Background(), tc.reader, tc.job, isMember)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCanReadJob_PropagatesMembershipErrors(t *testing.T) {
+	failing := func(context.Context, string, string) (bool, error) { return false, errors.New("firestore down") }
+	job := Job{ExecutionType: executionTypeWorkspace, UserID: "submitter", WorkspaceID: "ws-1"}
+
+	_, err := canReadJob(context.Background(), jobReader{UserID: "member"}, job, failing)
+	assert.Error(t, err)
+}
+
+func TestOwnsJob(t *testing.T) {
+	job := Job{UserID: "submitter"}
+	assert.True(t, ownsJob(jobReader{UserID: "submitter"}, job))
+	assert.True(t, ownsJob(jobReader{UserID: "admin", IsAdmin: true}, job))
+	assert.False(t, ownsJob(jobReader{UserID: "member"}, job))
+	assert.False(t, ownsJob(jobReader{}, job))
+	assert.False(t, ownsJob(jobReader{}, Job{}), "anonymous jobs have no owner")
+}
+
+func TestHandlers_GetJobHidesCallbackFromNonOwners(t *testing.T) {
+	h := newHandlerHarness(t)
+	submitter := "submitter-" + uuid.New().String()
+	jobID := uuid.New().String()
+	_, err := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(jobID).Set(context.Background(), Job{
+		Status:           "completed",
+		Language:         "python",
+		SubmittedAt:      NowISO8601(),
+		UserID:           submitter,
+		ExecutionType:    executionTypePublic,
+		CallbackURL:      "https://hooks.example.com/private",
+		CallbackDelivery: &WebhookDelivery{AttemptedAt: NowISO8601(), Success: false, StatusCode: 500},
+	})
+	if err != nil {
+		t.Fatalf("seed job: %v", err)
+	}
+
+	var resp JobStatusResponse
+	w := h.do(http.MethodGet, "/api/jobs/"+jobID, submitter, nil, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "https://hooks.example.com/private", resp.CallbackURL)
+	if assert.NotNil(t, resp.LastCallbackDelivery) {
+		assert.Equal(t, 500, resp.LastCallbackDelivery.StatusCode)
+	}
+
+	for _, reader := range []string{"", "other-" + uuid.New().String()} {
+		w := h.do(http.MethodGet, "/api/jobs/"+jobID, reader, nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.NotContains(t, w.Body.String(), "hooks.example.com")
+		assert.NotContains(t, w.Body.String(), "lastCallbackDelivery")
+	}
+}
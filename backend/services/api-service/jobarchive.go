@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobArchiveBatchSize is how many jobs one archive object holds at most. A Firestore sweep
+// page fits in one object; an embedded sweep may write several.
+var jobArchiveBatchSize = 500
+
+// jobArchiveURLTTL is how long the download URLs returned by ListJobArchives stay valid.
+const jobArchiveURLTTL = 15 * time.Minute
+
+// jobArchiveFunc stores expired jobs before DeleteExpired deletes them. When it fails, none of
+// the jobs it was given are deleted.
+type jobArchiveFunc func(ctx context.Context, jobs []StoredJob) error
+
+// archivedJob is one line of a job archive object: the job as clients see it, without its
+// code and input.
+type archivedJob struct {
+	JobID      string `json:"jobId"`
+	ArchivedAt string `json:"archivedAt"`
+	Job
+}
+
+// jobArchiveDayPrefix is the prefix of the archive objects written on day, in UTC.
+func jobArchiveDayPrefix(prefix string, day time.Time) string {
+	return fmt.Sprintf("%s/%s/", prefix, day.UTC().Format("2006/01/02"))
+}
+
+// jobArchiveKey returns a new key for an archive object written at.
+func jobArchiveKey(prefix string, at time.Time) string {
+	return fmt.Sprintf("%s%s-%s.ndjson", jobArchiveDayPrefix(prefix, at), at.UTC().Format("150405"), uuid.NewString())
+}
+
+// encodeJobArchive writes jobs as newline-delimited JSON.
+func encodeJobArchive(jobs []StoredJob, archivedAt string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, stored := range jobs {
+		job := stored.Job
+		job.Code, job.Input = "", ""
+		if err := enc.Encode(archivedJob{JobID: stored.ID, ArchivedAt: archivedAt, Job: job}); err != nil {
+			return nil, fmt.Errorf("failed to encode job %s: %w", stored.ID, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// jobArchiver returns the archive step of expired job sweeps, or nil when ArchiveJobs is off.
+func (ac *ApiController) jobArchiver() jobArchiveFunc {
+	if !ac.AppConfig.ArchiveJobs {
+		return nil
+	}
+	return ac.archiveJobs
+}
+
+// archiveJobs uploads jobs to R2 under JobArchivePrefix, at most jobArchiveBatchSize to an
+// object. A sweep that fails part way keeps every job it was given, so a job may appear in
+// more than one archive object; readers should dedupe on jobId.
+func (ac *ApiController) archiveJobs(ctx context.Context, jobs []StoredJob) error {
+	now := time.Now().UTC()
+	for start := 0; start < len(jobs); start += jobArchiveBatchSize {
+		batch := jobs[start:min(start+jobArchiveBatchSize, len(jobs))]
+		body, err := encodeJobArchive(batch, TimeToISO8601(now))
+		if err != nil {
+			return err
+		}
+		if _, err := ac.r2().S3.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(ac.R2BucketName),
+			Key:         aws.String(jobArchiveKey(ac.AppConfig.JobArchivePrefix, now)),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/x-ndjson"),
+		}); err != nil {
+			return fmt.Errorf("failed to upload job archive: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListJobArchives lists the job archive objects written on a day, with download URLs valid
+// for jobArchiveURLTTL.
+// Query params: date (YYYY-MM-DD, UTC; required).
+func (ac *ApiController) ListJobArchives(c *gin.Context) {
+	ctx := c.Request.Context()
+	logCtx := log.WithField("handler", "ListJobArchives")
+
+	day, err := time.Parse(time.DateOnly, c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be a day such as 2024-01-31"})
+		return
+	}
+
+	store := ac.r2()
+	paginator := s3.NewListObjectsV2Paginator(store.S3, &s3.ListObjectsV2Input{
+		Bucket: aws.String(ac.R2BucketName),
+		Prefix: aws.String(jobArchiveDayPrefix(ac.AppConfig.JobArchivePrefix, day)),
+	})
+	objects := make([]JobArchiveObject, 0)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to list job archives")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list job archives"})
+			return
+		}
+		for _, obj := range page.Contents {
+			req, err := store.Presign.PresignGetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(ac.R2BucketName),
+				Key:    obj.Key,
+			}, func(po *s3.PresignOptions) {
+				po.Expires = jobArchiveURLTTL
+			})
+			if err != nil {
+				logCtx.WithError(err).WithField("key", aws.ToString(obj.Key)).Error("Failed to presign job archive URL")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign job archive URLs"})
+				return
+			}
+			archive := JobArchiveObject{Key: aws.ToString(obj.Key), Size: aws.ToInt64(obj.Size), URL: req.URL}
+			if obj.LastModified != nil {
+				archive.LastModified = TimeToISO8601(*obj.LastModified)
+			}
+			objects = append(objects, archive)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"date": day.Format(time.DateOnly), "objects": objects})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// archivedJobIDs returns the job IDs in the archive objects under prefix, by object key.
+func archivedJobIDs(t *testing.T, objects *fakeObjectAPI, prefix string) map[string][]string {
+	t.Helper()
+	objects.mu.Lock()
+	defer objects.mu.Unlock()
+	byKey := make(map[string][]string)
+	for key, data := range objects.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var line map[string]interface{}
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			assert.NotContains(t, line, "code")
+			assert.NotContains(t, line, "input")
+			byKey[key] = append(byKey[key], fmt.Sprint(line["jobId"]))
+		}
+	}
+	return byKey
+}
+
+func TestArchiveJobs_Batches(t *testing.T) {
+	h := newHandlerHarness(t)
+	defer func(size int) { jobArchiveBatchSize = size }(jobArchiveBatchSize)
+	jobArchiveBatchSize = 2
+	h.ac.AppConfig.JobArchivePrefix = "archives/jobs"
+
+	var jobs []StoredJob
+	for i := 0; i < 5; i++ {
+		jobs = append(jobs, StoredJob{ID: fmt.Sprintf("job-%d", i), Job: Job{Status: "completed", Code: "print(1)", Input: "secret"}})
+	}
+	assert.NoError(t, h.ac.archiveJobs(context.Background(), jobs))
+
+	byKey := archivedJobIDs(t, h.objects, jobArchiveDayPrefix("archives/jobs", time.Now()))
+	assert.Len(t, byKey, 3)
+	var ids []string
+	for key, keyIDs := range byKey {
+		assert.True(t, strings.HasSuffix(key, ".ndjson"), key)
+		assert.LessOrEqual(t, len(keyIDs), 2)
+		ids = append(ids, keyIDs...)
+	}
+	sort.Strings(ids)
+	assert.Equal(t, []string{"job-0", "job-1", "job-2", "job-3", "job-4"}, ids)
+}
+
+func TestCleanupExpiredJobs_Archives(t *testing.T) {
+	h := newHandlerHarness(t)
+	defer func(size int) { jobCleanupBatchSize = size }(jobCleanupBatchSize)
+	jobCleanupBatchSize = 3
+	h.ac.AppConfig.ArchiveJobs, h.ac.AppConfig.JobArchivePrefix = true, "archives/jobs"
+	ctx := context.Background()
+
+	expiredAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, h.ac.Jobs.Create(ctx, fmt.Sprintf("expired-%d", i), Job{Status: "completed", Output: "ok", DeleteAfter: expiredAt.Add(time.Duration(i) * time.Second)}))
+	}
+	assert.NoError(t, h.ac.Jobs.Create(ctx, "running", Job{Status: "processing_auth_workspace", DeleteAfter: expiredAt}))
+
+	// Each page of the sweep is written to one object before it is deleted.
+	result, err := h.ac.CleanupExpiredJobs(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, result.Deleted)
+	assert.Equal(t, 5, result.Archived)
+	assert.Equal(t, 2, result.Batches)
+
+	prefix := jobArchiveDayPrefix("archives/jobs", time.Now())
+	byKey := archivedJobIDs(t, h.objects, prefix)
+	var sizes []int
+	for _, ids := range byKey {
+		sizes = append(sizes, len(ids))
+	}
+	sort.Ints(sizes)
+	assert.Equal(t, []int{2, 3}, sizes)
+	_, err = h.ac.Jobs.Get(ctx, "running")
+	assert.NoError(t, err, "running jobs are neither archived nor deleted")
+
+	var listed struct {
+		Date    string             `json:"date"`
+		Objects []JobArchiveObject `json:"objects"`
+	}
+	w := h.do(http.MethodGet, "/api/admin/jobs/archives?date="+time.Now().UTC().Format(time.DateOnly), "", nil, &listed)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if assert.Len(t, listed.Objects, 2) {
+		for _, obj := range listed.Objects {
+			assert.Contains(t, byKey, obj.Key)
+			assert.Equal(t, "https://r2.test/get/"+obj.Key, obj.URL)
+			assert.Positive(t, obj.Size)
+		}
+	}
+
+	w = h.do(http.MethodGet, "/api/admin/jobs/archives?date=2001-01-01", "", nil, &listed)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, listed.Objects)
+	w = h.do(http.MethodGet, "/api/admin/jobs/archives?date=yesterday", "", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCleanupExpiredJobs_ArchiveFailureKeepsJobs(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.ArchiveJobs, h.ac.AppConfig.JobArchivePrefix = true, "archives/jobs"
+	h.objects.failOn("PutObject", errors.New("r2 unavailable"))
+	ctx := context.Background()
+
+	expiredAt := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, h.ac.Jobs.Create(ctx, fmt.Sprintf("expired-%d", i), Job{Status: "completed", DeleteAfter: expiredAt}))
+	}
+
+	result, err := h.ac.CleanupExpiredJobs(ctx)
+	assert.Error(t, err)
+	assert.Zero(t, result.Deleted)
+	assert.Zero(t, result.Archived)
+	for i := 0; i < 3; i++ {
+		_, err := h.ac.Jobs.Get(ctx, fmt.Sprintf("expired-%d", i))
+		assert.NoError(t, err, "jobs that could not be archived are kept")
+	}
+
+	// Once R2 recovers the next sweep archives and deletes them.
+	h.objects.failOn("PutObject", nil)
+	result, err = h.ac.CleanupExpiredJobs(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Deleted)
+	assert.Equal(t, 3, result.Archived)
+}
+
+func TestEmbeddedJobStore_DeleteExpiredArchiveFailure(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store, err := NewEmbeddedJobStore("")
+	assert.NoError(t, err)
+	assert.NoError(t, store.Create(ctx, "done", Job{Status: "completed", DeleteAfter: now.Add(-time.Minute)}))
+
+	failing := func(context.Context, []StoredJob) error { return errors.New("r2 unavailable") }
+	_, err = store.DeleteExpired(ctx, now, failing)
+	assert.Error(t, err)
+	_, err = store.Get(ctx, "done")
+	assert.NoError(t, err, "jobs that could not be archived are kept")
+
+	var archived []StoredJob
+	result, err := store.DeleteExpired(ctx, now, func(_ context.Context, jobs []StoredJob) error {
+		archived = append(archived, jobs...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, JobCleanupResult{Deleted: 1, Archived: 1, Batches: 1, Complete: true}, result)
+	if assert.Len(t, archived, 1) {
+		assert.Equal(t, "done", archived[0].ID)
+	}
+	_, err = store.Get(ctx, "done")
+	assert.ErrorIs(t, err, errJobNotFound)
+}
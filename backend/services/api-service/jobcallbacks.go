@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// jobFinishedEvent is the webhook event sent when a job reaches a terminal state.
+	jobFinishedEvent = "job.finished"
+
+	// maxCallbackOutputBytes caps the job output embedded in a callback body.
+	maxCallbackOutputBytes = 16 << 10
+)
+
+// callbackRetryDelays are the waits before each retry of a failed job callback, so a
+// callback is attempted at most len(callbackRetryDelays)+1 times.
+var callbackRetryDelays = []time.Duration{2 * time.Second, 10 * time.Second, 30 * time.Second}
+
+// validateJobCallback rejects callback URLs that are not https or resolve to internal addresses.
+func (ac *ApiController) validateJobCallback(ctx context.Context, opts JobCallbackOptions) error {
+	if opts.CallbackURL == "" {
+		return nil
+	}
+	if opts.CallbackSecret == "" && ac.AppConfig.WebhookSigningSecret == "" {
+		return errors.New("callbackSecret is required with callbackUrl")
+	}
+	if err := ac.outbound.ValidateURL(ctx, opts.CallbackURL); err != nil {
+		return fmt.Errorf("invalid callbackUrl: %w", err)
+	}
+	return nil
+}
+
+// callbackSecret is the key that signs job's callback.
+func (ac *ApiController) callbackSecret(job Job) string {
+	if job.CallbackSecret != "" {
+		return job.CallbackSecret
+	}
+	return ac.AppConfig.WebhookSigningSecret
+}
+
+// retryableDelivery reports whether a failed callback may succeed on another attempt:
+// network errors, 429 and 5xx responses are retried, other rejections are final.
+func retryableDelivery(delivery WebhookDelivery) bool {
+	if delivery.Success {
+		return false
+	}
+	return delivery.StatusCode == 0 || delivery.StatusCode == http.StatusTooManyRequests || delivery.StatusCode >= 500
+}
+
+// jobFinishedWebhook builds the callback body for a finished job.
+func jobFinishedWebhook(jobID string, job Job) JobFinishedWebhook {
+	digest := sha256.Sum256([]byte(job.Output))
+	output := truncateUTF8(job.Output, maxCallbackOutputBytes)
+	truncated := len(output) < len(job.Output)
+	return JobFinishedWebhook{
+		JobID:           jobID,
+		Status:          job.Status,
+		Language:        job.Language,
+		WorkspaceID:     job.WorkspaceID,
+		EntrypointFile:  job.EntrypointFile,
+		Output:          output,
+		OutputTruncated: truncated,
+		OutputSHA256:    hex.EncodeToString(digest[:]),
+		Error:           job.Error,
+		FailureType:     job.FailureType,
+		SubmittedAt:     job.SubmittedAt,
+		CompletedAt:     job.CompletedAt,
+	}
+}
+
+// applyJobResult records a worker's reported result on a job that has not finished yet. A
+// job the API already finished (expired, worker lost) keeps its status.
+func applyJobResult(job *Job, report JobResultReport) bool {
+	if isTerminalJobStatus(job.Status) {
+		return false
+	}
+	completedAt := report.CompletedAt
+	if completedAt == "" {
+		completedAt = NowISO8601()
+	}
+	job.Status, job.Output, job.Error, job.FailureType = report.Status, report.Output, report.Error, report.FailureType
+	job.CompletedAt, job.UpdatedAt = completedAt, NowISO8601()
+	if completed, err := time.Parse(time.RFC3339, completedAt); err == nil {
+		job.DurationMs, _ = jobDurationMs(job.StartedAt, completed)
+	}
+	return true
+}
+
+// HandleJobFinished is called by execution workers once a job's final status is written.
+// Workers without access to the job store send the result in the body instead.
+func (ac *ApiController) HandleJobFinished(c *gin.Context) {
+	jobID := c.Param("jobId")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"job_id": jobID, "handler": "HandleJobFinished"})
+
+	var job Job
+	var err error
+	if c.Request.ContentLength > 0 {
+		var report JobResultReport
+		if err := ac.bindJSON(c, &report); err != nil {
+			return
+		}
+		stored := ac.storeReportedOutput(ctx, jobID, report.Output, logCtx)
+		var applied bool
+		job, err = ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+			if applied = applyJobResult(job, report); applied {
+				stored.apply(job)
+			}
+			return applied, nil
+		})
+		if err == nil && applied {
+			// The callback carries the full output, not what the job kept of it.
+			job.Output = report.Output
+		}
+	} else {
+		job, err = ac.Jobs.Get(ctx, jobID)
+	}
+	if errors.Is(err, errJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load finished job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+
+	if !isTerminalJobStatus(job.Status) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has not finished"})
+		return
+	}
+	if job.FailureType == failureTypeExpiredBeforeExecution {
+		ac.deadlines.recordMissed(time.Now())
+	}
+	ac.afterJobFinished(ctx, jobID, job, logCtx)
+	c.Status(http.StatusNoContent)
+}
+
+// afterJobFinished charges the job's execution cost, records failed workspace executions
+// in the activity log, then queues the job's completion notification and the delivery of
+// its result callback, if requested.
+func (ac *ApiController) afterJobFinished(ctx context.Context, jobID string, job Job, logCtx *log.Entry) {
+	ac.chargeFinishedJob(ctx, jobID, job, logCtx)
+	if job.Status == "failed" && job.ExecutionType == executionTypeWorkspace && job.WorkspaceID != "" {
+		ac.recordActivityOnce(ctx, job.WorkspaceID, "job_failed_"+jobID, job.UserID, activityJobFailed, map[string]interface{}{
+			"job_id":       jobID,
+			"failure_type": job.FailureType,
+		})
+	}
+	// Users who cancelled a job know it has stopped.
+	if job.NotificationRequested && !job.NotificationSent && job.Status != jobStatusCancelled && ac.jobNotificationsEnabled() {
+		err := ac.Background.Submit("job_notification", func(ctx context.Context) error {
+			return ac.notifyJobCompletion(ctx, jobID, job, logCtx)
+		})
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to queue job completion notification.")
+		}
+	}
+	if job.CallbackURL == "" || job.CallbackAttempts > 0 {
+		return
+	}
+
+	// Every attempt may wait out the webhook timeout, plus the delays in between.
+	timeout := time.Duration(len(callbackRetryDelays)+1) * ac.AppConfig.WebhookTimeout
+	for _, delay := range callbackRetryDelays {
+		timeout += delay
+	}
+	err := ac.Background.SubmitWithTimeout("job_callback", timeout, func(ctx context.Context) error {
+		return ac.deliverJobCallback(ctx, jobID, job, logCtx)
+	})
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to queue job callback.")
+	}
+}
+
+// chargeFinishedJob charges a finished job's run time to its user's and workspace's usage
+// rollups. The run time is derived from the job's start and finish times when nothing
+// recorded it, as for jobs a worker finished in the job store. recordExecutionCost keys the
+// charge by job ID, so every path that finishes a job may call this and repeated callbacks
+// are charged once. Failures are logged and swallowed.
+func (ac *ApiController) chargeFinishedJob(ctx context.Context, jobID string, job Job, logCtx *log.Entry) {
+	durationMs := job.DurationMs
+	finishedAt, err := time.Parse(time.RFC3339, job.CompletedAt)
+	if err != nil {
+		finishedAt = time.Now()
+	} else if durationMs == 0 {
+		durationMs, _ = jobDurationMs(job.StartedAt, finishedAt)
+	}
+	if _, err := ac.recordExecutionCost(ctx, jobID, job, durationMs, finishedAt); err != nil {
+		logCtx.WithError(err).Warn("Failed to record job execution cost.")
+	}
+}
+
+// deliverJobCallback POSTs the job's result callback, retrying after callbackRetryDelays
+// while the failure is retryable, and records each attempt on the job. A delivery that
+// already succeeded is not repeated.
+func (ac *ApiController) deliverJobCallback(ctx context.Context, jobID string, job Job, logCtx *log.Entry) error {
+	payload := jobFinishedWebhook(jobID, job)
+	secret := ac.callbackSecret(job)
+	for attempt := 0; ; attempt++ {
+		delivery := ac.webhooks.Deliver(ctx, job.CallbackURL, secret, jobFinishedEvent, payload)
+		if _, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+			job.CallbackDelivery = &delivery
+			job.CallbackAttempts++
+			return true, nil
+		}); err != nil {
+			logCtx.WithError(err).Warn("Failed to record job callback delivery.")
+		}
+
+		attemptCtx := logCtx.WithFields(log.Fields{
+			"attempt":     attempt + 1,
+			"success":     delivery.Success,
+			"status_code": delivery.StatusCode,
+			"duration_ms": delivery.DurationMs,
+		})
+		if !retryableDelivery(delivery) || attempt == len(callbackRetryDelays) {
+			if delivery.Success {
+				attemptCtx.Info("Job callback delivered.")
+				return nil
+			}
+			attemptCtx.WithField("error", delivery.Error).Warn("Job callback failed; giving up.")
+			return fmt.Errorf("job callback failed: %s", delivery.Error)
+		}
+		attemptCtx.WithField("error", delivery.Error).Info("Job callback failed; retrying.")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(callbackRetryDelays[attempt]):
+		}
+	}
+}
+
+// GetJob returns a job's status and result to callers allowed by canReadJob; everyone else
+// gets 404. Public executions need no auth. The callback URL and last delivery attempt are
+// included for the submitter (and admins) so they can debug their receiver.
+// Query params: outputOffset and outputLimit (bytes) select the part of the output returned;
+// by default it is the last 64 KB.
+func (ac *ApiController) GetJob(c *gin.Context) {
+	jobID := c.Param("jobId")
+	ctx := c.Request.Context()
+	reader := jobReader{UserID: c.GetString("userID"), IsAdmin: c.GetBool("isAdmin")}
+
+	outputRange, err := parseJobOutputRange(c.Query("outputOffset"), c.Query("outputLimit"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := ac.Jobs.Get(ctx, jobID)
+	if errors.Is(err, errJobNotFound) || (err == nil && isPastDeleteAfter(job.DeleteAfter)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithField("job_id", jobID).Error("Failed to load job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+	allowed, err := canReadJob(ctx, reader, job, ac.isWorkspaceMember)
+	if err != nil {
+		log.WithError(err).WithField("job_id", jobID).Error("Failed to check job read access.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify job access"})
+		return
+	}
+	if !allowed {
+		// Same response as a missing job so IDs of private jobs cannot be probed.
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if jobDeadlineMissed(job, time.Now()) {
+		expired, ok, err := ac.expireQueuedJob(ctx, jobID)
+		switch {
+		case err != nil:
+			log.WithError(err).WithField("job_id", jobID).Warn("Failed to expire job past its deadline.")
+		case ok:
+			job = expired
+		}
+	}
+
+	job, outputURL := ac.jobOutputForRead(ctx, jobID, job, log.WithField("job_id", jobID))
+	output := ac.jobOutputSliceForRead(ctx, job, outputRange, log.WithField("job_id", jobID))
+	resp := JobStatusResponse{
+		JobID:            jobID,
+		Status:           job.Status,
+		Language:         job.Language,
+		FailureType:      job.FailureType,
+		SubmittedAt:      job.SubmittedAt,
+		StartedAt:        job.StartedAt,
+		CompletedAt:      job.CompletedAt,
+		DurationMs:       job.DurationMs,
+		LastHeartbeatAt:  job.LastHeartbeatAt,
+		CancelRequested:  job.CancelRequested,
+		Deadline:         job.Deadline,
+		Output:           output.Output,
+		OutputOffset:     output.Offset,
+		OutputTotalBytes: output.TotalBytes,
+		OutputTruncated:  output.Truncated,
+		OutputURL:        outputURL,
+		Error:            job.Error,
+		Labels:           job.Labels,
+	}
+	if ownsJob(reader, job) {
+		resp.CallbackURL, resp.LastCallbackDelivery = job.CallbackURL, job.CallbackDelivery
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetWorkspaceJob returns a workspace execution's status and, once it has finished, its
+// result. Callers must be members of the workspace; jobs of other workspaces are reported
+// as missing. The output is selected as for GetJob.
+func (ac *ApiController) GetWorkspaceJob(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	jobID := c.Param("jobId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "GetWorkspaceJob", "workspace_id": workspaceID, "job_id": jobID, "user_id": userID})
+
+	outputRange, err := parseJobOutputRange(c.Query("outputOffset"), c.Query("outputLimit"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Workspace membership check failed for GetWorkspaceJob.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+		return
+	}
+
+	job, err := ac.Jobs.Get(ctx, jobID)
+	if errors.Is(err, errJobNotFound) || (err == nil && (job.WorkspaceID != workspaceID || isPastDeleteAfter(job.DeleteAfter))) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+	if jobDeadlineMissed(job, time.Now()) {
+		expired, ok, err := ac.expireQueuedJob(ctx, jobID)
+		switch {
+		case err != nil:
+			logCtx.WithError(err).Warn("Failed to expire job past its deadline.")
+		case ok:
+			job = expired
+		}
+	}
+
+	job, outputURL := ac.jobOutputForRead(ctx, jobID, job, logCtx)
+	output := ac.jobOutputSliceForRead(ctx, job, outputRange, logCtx)
+	c.JSON(http.StatusOK, WorkspaceJobResult{
+		JobID:            jobID,
+		Status:           job.Status,
+		Language:         job.Language,
+		ExecutionType:    job.ExecutionType,
+		EntrypointFile:   job.EntrypointFile,
+		Output:           output.Output,
+		OutputOffset:     output.Offset,
+		OutputTotalBytes: output.TotalBytes,
+		OutputTruncated:  output.Truncated,
+		OutputURL:        outputURL,
+		Error:            job.Error,
+		FailureType:      job.FailureType,
+		SubmittedAt:      job.SubmittedAt,
+		StartedAt:        job.StartedAt,
+		CompletedAt:      job.CompletedAt,
+		DurationMs:       job.DurationMs,
+		ScheduledAt:      job.ScheduledAt,
+		RetryOf:          job.RetryOf,
+		Labels:           job.Labels,
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	cloudtaskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobStatusCancelled is the terminal status of a cancelled job.
+const jobStatusCancelled = "cancelled"
+
+// applyJobCancel cancels a queued or scheduled job, or flags a job a worker is already running so the
+// worker can stop it. It reports whether the job was cancelled outright and returns
+// errJobAlreadyTerminal for finished jobs. Repeated requests change nothing.
+func applyJobCancel(job *Job, now string) (cancelled, changed bool, err error) {
+	switch {
+	case isTerminalJobStatus(job.Status):
+		return false, false, errJobAlreadyTerminal
+	case isAwaitingDispatch(job.Status):
+		job.Status, job.CompletedAt, job.UpdatedAt = jobStatusCancelled, now, now
+		job.Error = "The job was cancelled before it started"
+		return true, true, nil
+	case job.CancelRequested:
+		return false, false, nil
+	default:
+		job.CancelRequested, job.CancelRequestedAt = true, now
+		return false, true, nil
+	}
+}
+
+// recordJobTask stores the name of a job's Cloud Task so a cancellation can delete it. A job
+// without one can still be cancelled; its worker then finds it cancelled and skips it.
+func (ac *ApiController) recordJobTask(ctx context.Context, jobID, taskName string) {
+	if taskName == "" {
+		return
+	}
+	if _, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+		job.TaskName = taskName
+		return true, nil
+	}); err != nil {
+		log.WithError(err).WithFields(log.Fields{"job_id": jobID, "task_name": taskName}).Warn("Failed to record job task name.")
+	}
+}
+
+// deleteJobTask deletes the Cloud Task of a job that finished before it was dispatched. A
+// task that was dispatched in the meantime is already gone.
+func (ac *ApiController) deleteJobTask(ctx context.Context, job Job, logCtx *log.Entry) {
+	if job.TaskName == "" {
+		return
+	}
+	err := ac.TasksClient.DeleteTask(ctx, &cloudtaskspb.DeleteTaskRequest{Name: job.TaskName})
+	switch {
+	case isNotFound(err):
+		logCtx.WithField("task_name", job.TaskName).Info("Job task was already dispatched.")
+	case err != nil:
+		logCtx.WithError(err).WithField("task_name", job.TaskName).Warn("Failed to delete job task.")
+	}
+}
+
+// cancelJob cancels a job through applyJobCancel and, for a queued or scheduled job, deletes
+// its Cloud Task. A task that was dispatched in the meantime is already gone; its worker finds the job
+// cancelled and does not run it. It returns the job as stored and whether it was cancelled.
+func (ac *ApiController) cancelJob(ctx context.Context, jobID string, logCtx *log.Entry) (Job, bool, error) {
+	cancelled := false
+	job, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+		var changed bool
+		var err error
+		cancelled, changed, err = applyJobCancel(job, NowISO8601())
+		return changed, err
+	})
+	if err != nil {
+		return job, false, err
+	}
+	if !cancelled {
+		logCtx.WithField("status", job.Status).Info("Cancellation requested for running job.")
+		return job, false, nil
+	}
+
+	ac.deleteJobTask(ctx, job, logCtx)
+	logCtx.Info("Job cancelled.")
+	ac.afterJobFinished(ctx, jobID, job, logCtx)
+	return job, true, nil
+}
+
+// respondJobCancel writes the response to a cancellation: 200 for a cancelled job, 202 when
+// the worker still has to stop it, 409 for a finished job.
+func respondJobCancel(c *gin.Context, jobID string, job Job, cancelled bool, err error, logCtx *log.Entry) {
+	switch {
+	case errors.Is(err, errJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	case errors.Is(err, errJobAlreadyTerminal):
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has already finished", "status": job.Status})
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to cancel job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
+	case cancelled:
+		c.JSON(http.StatusOK, gin.H{"jobId": jobID, "status": job.Status})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"jobId": jobID, "status": job.Status, "cancelRequested": true})
+	}
+}
+
+// CancelWorkspaceJob cancels a workspace execution. Viewers can only cancel their own runs.
+func (ac *ApiController) CancelWorkspaceJob(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	jobID := c.Param("jobId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "CancelWorkspaceJob", "workspace_id": workspaceID, "job_id": jobID, "user_id": userID})
+
+	membership := ac.requireWorkspaceRole(c, workspaceID, userID, roleViewer, logCtx)
+	if membership == nil {
+		return
+	}
+
+	job, err := ac.Jobs.Get(ctx, jobID)
+	if errors.Is(err, errJobNotFound) || (err == nil && (job.WorkspaceID != workspaceID || isPastDeleteAfter(job.DeleteAfter))) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+	if membership.Role == "viewer" && job.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Viewers can only cancel their own jobs"})
+		return
+	}
+
+	job, cancelled, err := ac.cancelJob(ctx, jobID, logCtx)
+	respondJobCancel(c, jobID, job, cancelled, err, logCtx)
+}
+
+// CancelPublicJob cancels a public execution for the holder of its result token, given as
+// for GetClaimedResult. A missing or wrong token gets the same 404 as an unknown job.
+func (ac *ApiController) CancelPublicJob(c *gin.Context) {
+	jobID := c.Param("jobId")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "CancelPublicJob", "job_id": jobID})
+
+	token := resultClaimToken(c)
+	if !isWellFormedResultToken(token) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	job, err := ac.Jobs.Get(ctx, jobID)
+	if err != nil && !errors.Is(err, errJobNotFound) {
+		logCtx.WithError(err).Error("Failed to load job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+	if err != nil || !claimsResult(job, token) || isPastDeleteAfter(job.DeleteAfter) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	job, cancelled, err := ac.cancelJob(ctx, jobID, logCtx)
+	respondJobCancel(c, jobID, job, cancelled, err, logCtx)
+}
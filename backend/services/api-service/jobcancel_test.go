@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestApplyJobCancel(t *testing.T) {
+	now := "2026-03-01T12:00:00.000Z"
+
+	job := Job{Status: "queued"}
+	cancelled, changed, err := applyJobCancel(&job, now)
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+	assert.True(t, changed)
+	assert.Equal(t, jobStatusCancelled, job.Status)
+	assert.Equal(t, now, job.CompletedAt)
+
+	job = Job{Status: "processing_direct"}
+	cancelled, changed, err = applyJobCancel(&job, now)
+	assert.NoError(t, err)
+	assert.False(t, cancelled)
+	assert.True(t, changed)
+	assert.True(t, job.CancelRequested)
+	assert.Equal(t, "processing_direct", job.Status, "a running job is left to its worker")
+
+	_, changed, err = applyJobCancel(&job, now)
+	assert.NoError(t, err)
+	assert.False(t, changed, "repeated requests change nothing")
+
+	for _, finished := range []string{"completed", "failed", jobStatusCancelled} {
+		job = Job{Status: finished}
+		_, _, err = applyJobCancel(&job, now)
+		assert.ErrorIs(t, err, errJobAlreadyTerminal, finished)
+	}
+}
+
+func TestCancelPublicJob(t *testing.T) {
+	r, ac, tasks := newEmbeddedHarness(t)
+	submit := func() (string, string) {
+		var submitted map[string]string
+		w := serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return submitted["job_id"], submitted["result_token"]
+	}
+
+	jobID, token := submit()
+	other, err := newResultToken()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, serveJSON(r, http.MethodDelete, "/api/execute/"+jobID, nil, nil).Code, "the result token is required")
+	assert.Equal(t, http.StatusNotFound, serveJSON(r, http.MethodDelete, "/api/execute/"+jobID+"?token="+other, nil, nil).Code)
+
+	var cancelled map[string]interface{}
+	w := serveJSON(r, http.MethodDelete, "/api/execute/"+jobID+"?token="+token, nil, &cancelled)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, jobStatusCancelled, cancelled["status"])
+	assert.Len(t, tasks.deletedTasks(), 1, "the pending task is deleted")
+
+	// The worker was handed the task anyway; it finds the job cancelled and stops.
+	w = serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/heartbeat", nil, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = serveJSON(r, http.MethodDelete, "/api/execute/"+jobID+"?token="+token, nil, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// The task was dispatched before it could be deleted: the job is cancelled regardless.
+	tasks.deleteErr = status.Error(codes.NotFound, "task not found")
+	jobID, token = submit()
+	w = serveJSON(r, http.MethodDelete, "/api/execute/"+jobID+"?token="+token, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	job, err := ac.Jobs.Get(context.Background(), jobID)
+	assert.NoError(t, err)
+	assert.Equal(t, jobStatusCancelled, job.Status)
+
+	// A running job is flagged for its worker, which sees it on the next heartbeat.
+	jobID, token = submit()
+	_, err = ac.Jobs.Update(context.Background(), jobID, func(job *Job) (bool, error) {
+		job.Status = "processing_direct"
+		return true, nil
+	})
+	assert.NoError(t, err)
+	w = serveJSON(r, http.MethodDelete, "/api/execute/"+jobID+"?token="+token, nil, &cancelled)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, true, cancelled["cancelRequested"])
+	var heartbeat map[string]interface{}
+	w = serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/heartbeat", nil, &heartbeat)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, true, heartbeat["cancelRequested"])
+}
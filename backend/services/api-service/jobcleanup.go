@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobCleanupBatchSize is how many expired jobs one query of a Firestore sweep reads and
+// deletes; jobCleanupMaxBatches bounds a sweep so it ends well within a request timeout.
+// The next scheduled run continues where an incomplete sweep stopped.
+var (
+	jobCleanupBatchSize  = 300
+	jobCleanupMaxBatches = 50
+)
+
+// JobCleanupResult summarizes one sweep of expired jobs. Jobs whose worker is still running
+// are skipped; Failed counts deletions Firestore rejected, such as of a job pinned mid-sweep.
+// With archiving on, Archived counts jobs written to an archive and Unarchivable jobs kept
+// because they could not be read.
+type JobCleanupResult struct {
+	Deleted        int   `json:"deleted"`
+	SkippedRunning int   `json:"skippedRunning"`
+	Failed         int   `json:"failed"`
+	Archived       int   `json:"archived,omitempty"`
+	Unarchivable   int   `json:"unarchivable,omitempty"`
+	Batches        int   `json:"batches"`
+	Complete       bool  `json:"complete"` // False when the sweep stopped before the end of the backlog
+	DurationMs     int64 `json:"durationMs"`
+	// R2 keys of the offloaded output of deleted jobs, for CleanupExpiredJobs to delete.
+	OutputKeys []string `json:"-"`
+}
+
+// jobCleanupCounters are this instance's job cleanup totals, reported by GetServiceMetrics.
+type jobCleanupCounters struct {
+	mu      sync.Mutex
+	runs    int64
+	deleted int64
+	last    *JobCleanupResult
+	lastAt  string
+}
+
+func (jc *jobCleanupCounters) record(result JobCleanupResult) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	jc.runs++
+	jc.deleted += int64(result.Deleted)
+	jc.last, jc.lastAt = &result, NowISO8601()
+}
+
+// Stats returns the counters and the most recent sweep.
+func (jc *jobCleanupCounters) Stats() gin.H {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	return gin.H{"runs": jc.runs, "deleted": jc.deleted, "last": jc.last, "lastAt": jc.lastAt}
+}
+
+// CleanupExpiredJobs deletes jobs past their delete_after, in batches, and logs and counts
+// the outcome. With ArchiveJobs, each batch is archived to R2 first, and a failed upload
+// ends the sweep with the batch kept. It is safe to run repeatedly and concurrently: a job
+// deleted twice is only counted once.
+func (ac *ApiController) CleanupExpiredJobs(ctx context.Context) (JobCleanupResult, error) {
+	start := time.Now()
+	result, err := ac.Jobs.DeleteExpired(ctx, start.UTC(), ac.jobArchiver())
+	result.DurationMs = time.Since(start).Milliseconds()
+	ac.jobCleanups.record(result)
+
+	logCtx := log.WithFields(log.Fields{
+		"deleted":         result.Deleted,
+		"skipped_running": result.SkippedRunning,
+		"failed":          result.Failed,
+		"archived":        result.Archived,
+		"unarchivable":    result.Unarchivable,
+		"batches":         result.Batches,
+		"complete":        result.Complete,
+		"duration_ms":     result.DurationMs,
+	})
+	// Output of jobs deleted before a sweep failed is removed all the same.
+	ac.deleteJobOutputs(ctx, result.OutputKeys, logCtx)
+	if err != nil {
+		logCtx.WithError(err).Error("Expired job cleanup failed.")
+		return result, fmt.Errorf("failed to delete expired jobs: %w", err)
+	}
+	logCtx.Info("Expired jobs cleaned up.")
+	return result, nil
+}
+
+// deleteJobOutputs deletes the offloaded output of deleted jobs. Failures are queued for
+// retry where there is Firestore to queue them in; otherwise they are only logged.
+func (ac *ApiController) deleteJobOutputs(ctx context.Context, keys []string, logCtx *log.Entry) {
+	if len(keys) == 0 {
+		return
+	}
+	failures := ac.deleteR2Objects(ctx, keys)
+	if len(failures) == 0 {
+		return
+	}
+	logCtx.WithField("failed_output_deletions", len(failures)).Warn("Failed to delete the output of expired jobs.")
+	if ac.FirestoreClient != nil {
+		ac.recordFailedR2Deletions(ctx, "", failures)
+	}
+}
+
+// HandleJobCleanup runs CleanupExpiredJobs for Cloud Scheduler. A 200 with complete=false
+// means more expired jobs remain for the next run.
+func (ac *ApiController) HandleJobCleanup(c *gin.Context) {
+	result, err := ac.CleanupExpiredJobs(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clean up expired jobs", "result": result})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// DeleteExpired pages through jobs past delete_after, oldest first, archiving and then
+// deleting each page with a BulkWriter. Paging continues after the last document read rather
+// than restarting, so skipped running jobs cannot hold a sweep at the front of the backlog.
+func (s *firestoreJobStore) DeleteExpired(ctx context.Context, now time.Time, archive jobArchiveFunc) (JobCleanupResult, error) {
+	q := s.fs.Collection(s.collection).
+		Where(deleteAfterField, "<=", now).
+		OrderBy(deleteAfterField, docstore.Asc).
+		Limit(jobCleanupBatchSize)
+
+	var result JobCleanupResult
+	var last *docstore.DocumentSnapshot
+	for result.Batches < jobCleanupMaxBatches {
+		page := q
+		if last != nil {
+			page = q.StartAfter(last)
+		}
+		snaps, err := page.Documents(ctx).GetAll()
+		if err != nil {
+			return result, fmt.Errorf("failed to query expired jobs: %w", err)
+		}
+		if len(snaps) == 0 {
+			result.Complete = true
+			return result, nil
+		}
+		result.Batches++
+
+		expired := make([]*docstore.DocumentSnapshot, 0, len(snaps))
+		for _, snap := range snaps {
+			if status, _ := snap.DataAt("status"); slices.Contains(runningJobStatuses, fmt.Sprint(status)) {
+				result.SkippedRunning++
+				continue
+			}
+			expired = append(expired, snap)
+		}
+		if archive != nil {
+			if expired, err = archiveExpiredJobs(ctx, expired, archive, &result); err != nil {
+				return result, err
+			}
+		}
+
+		bw := s.fs.BulkWriter(ctx)
+		deletes := make([]*docstore.BulkWriterJob, 0, len(expired))
+		outputKeys := make([]string, 0, len(expired))
+		for _, snap := range expired {
+			// The precondition keeps a job that changed since it was read, such as one
+			// pinned mid-sweep, from being deleted.
+			del, err := bw.Delete(snap.Ref, docstore.LastUpdateTime(snap.UpdateTime))
+			if err != nil {
+				bw.End()
+				return result, fmt.Errorf("failed to queue job deletion: %w", err)
+			}
+			deletes = append(deletes, del)
+			key, _ := snap.DataAt("output_r2_key")
+			outputKey, _ := key.(string)
+			outputKeys = append(outputKeys, outputKey)
+		}
+		bw.End()
+		for i, del := range deletes {
+			if _, err := del.Results(); err != nil {
+				result.Failed++
+				continue
+			}
+			result.Deleted++
+			if outputKeys[i] != "" {
+				result.OutputKeys = append(result.OutputKeys, outputKeys[i])
+			}
+		}
+
+		if len(snaps) < jobCleanupBatchSize {
+			result.Complete = true
+			return result, nil
+		}
+		last = snaps[len(snaps)-1]
+	}
+	return result, nil
+}
+
+// archiveExpiredJobs archives a page of expired jobs and returns those that may be deleted.
+// Jobs that cannot be parsed are kept rather than deleted unarchived.
+func archiveExpiredJobs(ctx context.Context, snaps []*docstore.DocumentSnapshot, archive jobArchiveFunc, result *JobCleanupResult) ([]*docstore.DocumentSnapshot, error) {
+	archivable := make([]*docstore.DocumentSnapshot, 0, len(snaps))
+	jobs := make([]StoredJob, 0, len(snaps))
+	for _, snap := range snaps {
+		var job Job
+		if err := snap.DataTo(&job); err != nil {
+			log.WithError(err).WithField("job_id", snap.Ref.ID).Warn("Failed to parse expired job; keeping it unarchived.")
+			result.Unarchivable++
+			continue
+		}
+		archivable = append(archivable, snap)
+		jobs = append(jobs, StoredJob{ID: snap.Ref.ID, Job: job})
+	}
+	if len(jobs) == 0 {
+		return archivable, nil
+	}
+	if err := archive(ctx, jobs); err != nil {
+		return nil, fmt.Errorf("failed to archive expired jobs: %w", err)
+	}
+	result.Archived += len(jobs)
+	return archivable, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirestoreJobStore_DeleteExpiredInBatches(t *testing.T) {
+	h := newHandlerHarness(t)
+	defer func(size, batches int) { jobCleanupBatchSize, jobCleanupMaxBatches = size, batches }(jobCleanupBatchSize, jobCleanupMaxBatches)
+	jobCleanupBatchSize, jobCleanupMaxBatches = 3, 2
+	ctx := context.Background()
+
+	// Seven expired jobs, oldest first; the running ones straddle the first page boundary.
+	expiredAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+	for i := 0; i < 7; i++ {
+		status := "completed"
+		if i == 2 || i == 3 {
+			status = "processing_auth_workspace"
+		}
+		assert.NoError(t, h.ac.Jobs.Create(ctx, fmt.Sprintf("expired-%d", i), Job{Status: status, DeleteAfter: expiredAt.Add(time.Duration(i) * time.Second)}))
+	}
+	assert.NoError(t, h.ac.Jobs.Create(ctx, "live", Job{Status: "completed", DeleteAfter: time.Now().UTC().Add(time.Hour)}))
+	assert.NoError(t, h.ac.Jobs.Create(ctx, "pinned", Job{Status: "completed", Pinned: true}))
+
+	// The first run stops after two pages.
+	result, err := h.ac.CleanupExpiredJobs(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, result.Deleted)
+	assert.Equal(t, 2, result.SkippedRunning)
+	assert.Equal(t, 2, result.Batches)
+	assert.False(t, result.Complete)
+
+	// The next run finishes the backlog; its only page is exactly full.
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/internal/jobs/cleanup", nil)
+	h.ac.HandleJobCleanup(c)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	result = JobCleanupResult{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	result.DurationMs = 0
+	assert.Equal(t, JobCleanupResult{Deleted: 1, SkippedRunning: 2, Batches: 1, Complete: true}, result)
+
+	for id, kept := range map[string]bool{"expired-0": false, "expired-2": true, "expired-3": true, "expired-6": false, "live": true, "pinned": true} {
+		_, err := h.ac.Jobs.Get(ctx, id)
+		if kept {
+			assert.NoError(t, err, id)
+		} else {
+			assert.ErrorIs(t, err, errJobNotFound, id)
+		}
+	}
+}
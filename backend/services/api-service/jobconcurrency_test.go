@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// runMain submits main.py in a workspace and returns the response status.
+func runMain(h *handlerHarness, userID, workspaceID string, out interface{}) int {
+	h.t.Helper()
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", userID, ExecuteAuthRequest{
+		Language:       "python",
+		EntrypointFile: "main.py",
+	}, out)
+	return w.Code
+}
+
+func TestHandlers_ConcurrentJobLimit(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.MaxConcurrentJobs = 2
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print(1)")
+
+	var first, second ExecuteAuthResponse
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, &first))
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, &second), "one below the limit is admitted")
+
+	var refused map[string]interface{}
+	assert.Equal(t, http.StatusTooManyRequests, runMain(h, owner, workspaceID, &refused), "exactly at the limit is refused")
+	assert.Equal(t, float64(2), refused["activeJobs"])
+	assert.Equal(t, float64(2), refused["maxConcurrentJobs"])
+	assert.Len(t, h.tasks.tasksTo("/execute"), 2)
+
+	other := h.createWorkspace(owner)
+	h.seedFile(other, "main.py", "print(1)")
+	assert.Equal(t, http.StatusOK, runMain(h, owner, other, nil), "each workspace has its own limit")
+
+	finishJob(h, first.JobID)
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, nil), "a finished job frees its slot")
+}
+
+func TestHandlers_ConcurrentJobLimitGrace(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.MaxConcurrentJobs = 1
+	h.ac.AppConfig.JobConcurrencyGrace = 500 * time.Millisecond
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print(1)")
+
+	var first ExecuteAuthResponse
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, &first))
+
+	// The job's finish lands while the request waits out the grace window.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(50 * time.Millisecond)
+		finishJob(h, first.JobID)
+	}()
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, nil))
+	<-done
+}
+
+func TestHandlers_ConcurrencySettings(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.MaxConcurrentJobs = 1
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print(1)")
+	settingsPath := "/api/workspaces/" + workspaceID + "/settings/concurrency"
+
+	w := h.do(http.MethodPut, settingsPath, "stranger-"+uuid.New().String(), gin.H{"maxConcurrentJobs": 5}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = h.do(http.MethodPut, settingsPath, owner, gin.H{}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the limit is required")
+
+	var resp map[string]interface{}
+	w = h.do(http.MethodPut, settingsPath, owner, gin.H{"maxConcurrentJobs": 2}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, float64(2), resp["maxConcurrentJobs"])
+
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, nil))
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, nil), "the workspace setting overrides the default")
+	assert.Equal(t, http.StatusTooManyRequests, runMain(h, owner, workspaceID, nil))
+
+	w = h.do(http.MethodPut, settingsPath, owner, gin.H{"maxConcurrentJobs": 0}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, float64(1), resp["maxConcurrentJobs"], "0 restores the default")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// taskJobIDHeader carries the job ID on every execution task, so a dispatch that keeps
+// failing can be tied to its job without reading the payload.
+const taskJobIDHeader = "X-Job-ID"
+
+// taskRetryCountHeader is set by Cloud Tasks on each dispatch to the number of times the
+// task was retried before it.
+const taskRetryCountHeader = "X-CloudTasks-TaskRetryCount"
+
+// Failure types of jobs no worker ever picked up: the dispatch kept failing until the last
+// retry, or the job sat queued for longer than JobQueuedTTL.
+const (
+	failureTypeDispatchExhausted = "dispatch_exhausted"
+	failureTypeQueueTimeout      = "queue_timeout"
+)
+
+// executionTaskHeaders returns the headers of the execution task of jobID.
+func executionTaskHeaders(jobID string) map[string]string {
+	return map[string]string{"Content-Type": "application/json", taskJobIDHeader: jobID}
+}
+
+// jobQueuedSince returns when a job last became due for dispatch: its submission, scheduled
+// run time or latest requeue, whichever is latest.
+func jobQueuedSince(job Job) time.Time {
+	var since time.Time
+	for _, ts := range []string{job.SubmittedAt, job.ScheduledAt, job.RequeuedAt} {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil && t.After(since) {
+			since = t
+		}
+	}
+	return since
+}
+
+// jobStuckInQueue reports whether a job has waited for a worker for longer than ttl since
+// it became due.
+func jobStuckInQueue(job Job, now time.Time, ttl time.Duration) bool {
+	return isAwaitingDispatch(job.Status) && now.Sub(jobQueuedSince(job)) > ttl
+}
+
+// HandleJobDeadLetter fails a job whose execution task is on its last attempt, so the user
+// sees a final state instead of a job queued forever. The queue's final retry, or a worker
+// seeing it, calls it with that dispatch's X-CloudTasks-TaskRetryCount header; below
+// JobDeadLetterRetryCount retries it changes nothing. Jobs a worker already picked up are
+// left to the stale-jobs task.
+func (ac *ApiController) HandleJobDeadLetter(c *gin.Context) {
+	jobID := c.Param("jobId")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"job_id": jobID, "handler": "HandleJobDeadLetter"})
+
+	retries, err := strconv.Atoi(c.GetHeader(taskRetryCountHeader))
+	if err != nil || retries < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": taskRetryCountHeader + " header must be a retry count"})
+		return
+	}
+	if retries < ac.AppConfig.JobDeadLetterRetryCount {
+		c.JSON(http.StatusOK, gin.H{"jobId": jobID, "deadLettered": false, "retryCount": retries})
+		return
+	}
+
+	attempts := retries + 1
+	failed := false
+	job, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+		failed = isAwaitingDispatch(job.Status)
+		if !failed {
+			return false, nil
+		}
+		now := NowISO8601()
+		job.Status, job.FailureType, job.CompletedAt, job.UpdatedAt = "failed", failureTypeDispatchExhausted, now, now
+		job.Error = fmt.Sprintf("Execution service unavailable after %d attempts", attempts)
+		return true, nil
+	})
+	switch {
+	case errors.Is(err, errJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to dead-letter job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update job"})
+		return
+	}
+	if failed {
+		logCtx.WithField("attempts", attempts).Warn("Job failed: execution task retries exhausted.")
+		ac.afterJobFinished(ctx, jobID, job, logCtx)
+	}
+	c.JSON(http.StatusOK, gin.H{"jobId": jobID, "deadLettered": failed, "status": job.Status, "retryCount": retries})
+}
+
+// runStuckJobMaintenance is the "stuck-jobs" maintenance task: it fails queued and scheduled
+// jobs no worker picked up within JobQueuedTTL of becoming due, with failure_type
+// queue_timeout, and deletes their tasks. Jobs past their deadline are expired instead.
+func (ac *ApiController) runStuckJobMaintenance(ctx context.Context) (interface{}, error) {
+	jobs, err := ac.Jobs.List(ctx, JobQuery{Statuses: []string{"queued", jobStatusScheduled}, Limit: staleJobScanLimit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load queued jobs: %w", err)
+	}
+
+	ttl := ac.AppConfig.JobQueuedTTL
+	failed, expired, errored := 0, 0, 0
+	for _, stored := range jobs {
+		if jobDeadlineMissed(stored.Job, time.Now()) {
+			if _, ok, err := ac.expireQueuedJob(ctx, stored.ID); err != nil {
+				log.WithError(err).WithField("job_id", stored.ID).Warn("Failed to expire job past its deadline.")
+				errored++
+			} else if ok {
+				expired++
+			}
+			continue
+		}
+		if !jobStuckInQueue(stored.Job, time.Now(), ttl) {
+			continue
+		}
+		ok, err := ac.failStuckJob(ctx, stored.ID, ttl)
+		switch {
+		case err != nil:
+			log.WithError(err).WithField("job_id", stored.ID).Warn("Failed to fail stuck job.")
+			errored++
+		case ok:
+			failed++
+		}
+	}
+	return gin.H{"scanned": len(jobs), "failed": failed, "expired": expired, "errors": errored}, nil
+}
+
+// failStuckJob marks a job stuck in the queue as failed, rechecking inside the update so a
+// worker that just picked it up wins. It returns false in that case.
+func (ac *ApiController) failStuckJob(ctx context.Context, jobID string, ttl time.Duration) (bool, error) {
+	stuck := false
+	job, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+		stuck = jobStuckInQueue(*job, time.Now(), ttl)
+		if !stuck {
+			return false, nil
+		}
+		now := NowISO8601()
+		job.Status, job.FailureType, job.CompletedAt, job.UpdatedAt = "failed", failureTypeQueueTimeout, now, now
+		job.Error = fmt.Sprintf("No worker picked the job up within %s", ttl)
+		return true, nil
+	})
+	if err != nil || !stuck {
+		return false, err
+	}
+
+	logCtx := log.WithFields(log.Fields{"job_id": jobID, "queued_since": TimeToISO8601(jobQueuedSince(job))})
+	logCtx.Warn("Job failed: stuck in queue.")
+	ac.deleteJobTask(ctx, job, logCtx)
+	ac.afterJobFinished(ctx, jobID, job, logCtx)
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobStuckInQueue(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ttl := time.Hour
+	twoHoursAgo := TimeToISO8601(now.Add(-2 * time.Hour))
+	recently := TimeToISO8601(now.Add(-time.Minute))
+
+	assert.True(t, jobStuckInQueue(Job{Status: "queued", SubmittedAt: twoHoursAgo}, now, ttl))
+	assert.False(t, jobStuckInQueue(Job{Status: "queued", SubmittedAt: recently}, now, ttl))
+	assert.False(t, jobStuckInQueue(Job{Status: jobStatusScheduled, SubmittedAt: twoHoursAgo, ScheduledAt: recently}, now, ttl), "a scheduled job waits from its run time")
+	assert.False(t, jobStuckInQueue(Job{Status: "queued", SubmittedAt: twoHoursAgo, RequeuedAt: recently}, now, ttl), "a requeued job waits from its requeue")
+	assert.False(t, jobStuckInQueue(Job{Status: jobStatusRunning, SubmittedAt: twoHoursAgo}, now, ttl), "running jobs are left to the stale-jobs task")
+}
+
+func TestHandleJobDeadLetter(t *testing.T) {
+	r, ac, tasks := newEmbeddedHarness(t)
+	var submitted map[string]string
+	serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+	jobID := submitted["job_id"]
+	if assert.Len(t, tasks.requests, 1) {
+		assert.Equal(t, jobID, tasks.requests[0].GetTask().GetHttpRequest().GetHeaders()[taskJobIDHeader])
+	}
+
+	deadLetter := func(retryCount string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/internal/jobs/"+jobID+"/dead-letter", nil)
+		if retryCount != "" {
+			req.Header.Set(taskRetryCountHeader, retryCount)
+		}
+		w := serveRequest(r, req)
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	code, _ := deadLetter("")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Retries left: Cloud Tasks keeps going and the job stays queued.
+	code, body := deadLetter("3")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["deadLettered"])
+	job, _ := ac.Jobs.Get(context.Background(), jobID)
+	assert.Equal(t, "queued", job.Status)
+
+	code, body = deadLetter("4")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["deadLettered"])
+	job, _ = ac.Jobs.Get(context.Background(), jobID)
+	assert.Equal(t, "failed", job.Status)
+	assert.Equal(t, failureTypeDispatchExhausted, job.FailureType)
+	assert.Equal(t, "Execution service unavailable after 5 attempts", job.Error)
+	assert.NotEmpty(t, job.CompletedAt)
+
+	// A repeated call leaves the failed job as it is.
+	code, body = deadLetter("5")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, body["deadLettered"])
+	assert.Equal(t, "failed", body["status"])
+
+	req := httptest.NewRequest(http.MethodPost, "/internal/jobs/unknown/dead-letter", nil)
+	req.Header.Set(taskRetryCountHeader, "9")
+	assert.Equal(t, http.StatusNotFound, serveRequest(r, req).Code)
+}
+
+func TestStuckJobMaintenance(t *testing.T) {
+	r, ac, tasks := newEmbeddedHarness(t)
+	submit := func() string {
+		var submitted map[string]string
+		serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+		return submitted["job_id"]
+	}
+	stuck, fresh := submit(), submit()
+	_, err := ac.Jobs.Update(context.Background(), stuck, func(job *Job) (bool, error) {
+		job.SubmittedAt = TimeToISO8601(time.Now().Add(-2 * time.Hour))
+		return true, nil
+	})
+	assert.NoError(t, err)
+
+	summary, err := ac.runStuckJobMaintenance(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.(gin.H)["failed"])
+
+	job, _ := ac.Jobs.Get(context.Background(), stuck)
+	assert.Equal(t, "failed", job.Status)
+	assert.Equal(t, failureTypeQueueTimeout, job.FailureType)
+	assert.Equal(t, []string{job.TaskName}, tasks.deletedTasks())
+	job, _ = ac.Jobs.Get(context.Background(), fresh)
+	assert.Equal(t, "queued", job.Status)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// failureTypeExpiredBeforeExecution marks a job whose deadline passed while it was queued.
+// The worker writes it when it picks up such a job; GetJob and RequeueJobs write it when
+// they find one still queued.
+const failureTypeExpiredBeforeExecution = "expired_before_execution"
+
+// The deadline miss rate looks back over deadlineMissWindow. Execution queues are reported
+// degraded once at least deadlineMissMinSamples deadline jobs were submitted in the window
+// and deadlineMissDegradedRate of them were missed.
+const (
+	deadlineMissWindow       = 15 * time.Minute
+	deadlineMissMinSamples   = 10
+	deadlineMissDegradedRate = 0.2
+)
+
+// parseJobDeadline validates a requested deadline: it must be at least minAhead and at most
+// maxAhead after now. An empty deadline is valid and returns the zero time.
+func parseJobDeadline(raw string, now time.Time, minAhead, maxAhead time.Duration) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	deadline, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("deadline must be an ISO 8601 timestamp")
+	}
+	if deadline.Sub(now) < minAhead {
+		return time.Time{}, fmt.Errorf("deadline must be at least %s in the future", minAhead)
+	}
+	if deadline.Sub(now) > maxAhead {
+		return time.Time{}, fmt.Errorf("deadline must be within %s", maxAhead)
+	}
+	return deadline, nil
+}
+
+// resolveJobDeadline returns the request's deadline in the stored ISO 8601 form, or "" when
+// none was given. Deadlines past the job retention window are refused: the job document
+// would be gone before the deadline could be enforced.
+func (ac *ApiController) resolveJobDeadline(opts ExecutionOptions) (string, error) {
+	deadline, err := parseJobDeadline(opts.Deadline, time.Now(), ac.AppConfig.JobDeadlineMin, ac.AppConfig.Retention.Jobs)
+	if err != nil || deadline.IsZero() {
+		return "", err
+	}
+	return TimeToISO8601(deadline), nil
+}
+
+// jobDeadlineMissed reports whether a job is still queued or scheduled after its deadline. A
+// job a worker has already picked up runs to completion.
+func jobDeadlineMissed(job Job, now time.Time) bool {
+	if job.Deadline == "" || !isAwaitingDispatch(job.Status) {
+		return false
+	}
+	deadline, err := time.Parse(time.RFC3339, job.Deadline)
+	return err == nil && now.After(deadline)
+}
+
+// expireQueuedJob fails a queued job whose deadline has passed with failure_type
+// expired_before_execution, rechecking inside the update so a worker that just picked the
+// job up wins. It returns the job as stored and whether it was expired.
+func (ac *ApiController) expireQueuedJob(ctx context.Context, jobID string) (Job, bool, error) {
+	expired := false
+	job, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+		expired = jobDeadlineMissed(*job, time.Now())
+		if !expired {
+			return false, nil
+		}
+		now := NowISO8601()
+		job.Status, job.FailureType, job.CompletedAt, job.UpdatedAt = "failed", failureTypeExpiredBeforeExecution, now, now
+		job.Error = "The job's deadline passed before a worker picked it up"
+		return true, nil
+	})
+	if err != nil || !expired {
+		return job, false, err
+	}
+
+	logCtx := log.WithFields(log.Fields{"job_id": jobID, "deadline": job.Deadline})
+	logCtx.Warn("Job expired before execution.")
+	ac.deadlines.recordMissed(time.Now())
+	ac.afterJobFinished(ctx, jobID, job, logCtx)
+	return job, true, nil
+}
+
+// DeadlineMissStats summarizes this instance's recent deadline jobs for GET /admin/metrics.
+type DeadlineMissStats struct {
+	WindowMinutes int     `json:"windowMinutes"`
+	Submitted     int     `json:"submitted"`
+	Missed        int     `json:"missed"`
+	MissRate      float64 `json:"missRate"`
+	Degraded      bool    `json:"degraded"`
+}
+
+// deadlineMisses counts jobs submitted with a deadline and missed deadlines in one-minute
+// buckets over deadlineMissWindow. Like queueHealth it is per instance: a miss is counted by
+// whichever instance observes it, so the rate is an approximation.
+type deadlineMisses struct {
+	mu      sync.Mutex
+	buckets []deadlineMissBucket
+}
+
+type deadlineMissBucket struct {
+	minute    int64
+	submitted int
+	missed    int
+}
+
+func newDeadlineMisses() *deadlineMisses {
+	return &deadlineMisses{buckets: make([]deadlineMissBucket, int(deadlineMissWindow/time.Minute))}
+}
+
+// bucket returns now's bucket, resetting it if it still holds an older minute. Callers hold mu.
+func (d *deadlineMisses) bucket(now time.Time) *deadlineMissBucket {
+	minute := now.Unix() / 60
+	b := &d.buckets[minute%int64(len(d.buckets))]
+	if b.minute != minute {
+		*b = deadlineMissBucket{minute: minute}
+	}
+	return b
+}
+
+func (d *deadlineMisses) recordSubmitted(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.bucket(now).submitted++
+}
+
+func (d *deadlineMisses) recordMissed(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.bucket(now).missed++
+}
+
+// Stats sums the buckets still inside the window.
+func (d *deadlineMisses) Stats(now time.Time) DeadlineMissStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	current := now.Unix() / 60
+	stats := DeadlineMissStats{WindowMinutes: len(d.buckets)}
+	for _, b := range d.buckets {
+		if b.minute > current-int64(len(d.buckets)) && b.minute <= current {
+			stats.Submitted += b.submitted
+			stats.Missed += b.missed
+		}
+	}
+	if stats.Submitted > 0 {
+		// Misses seen here may belong to jobs another instance accepted.
+		stats.MissRate = min(1, float64(stats.Missed)/float64(stats.Submitted))
+	}
+	stats.Degraded = stats.Submitted >= deadlineMissMinSamples && stats.MissRate >= deadlineMissDegradedRate
+	return stats
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJobDeadline(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	minAhead, maxAhead := 10*time.Second, time.Hour
+
+	deadline, err := parseJobDeadline("", now, minAhead, maxAhead)
+	assert.NoError(t, err)
+	assert.True(t, deadline.IsZero())
+
+	deadline, err = parseJobDeadline("2025-03-01T12:05:00.000Z", now, minAhead, maxAhead)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(5*time.Minute), deadline)
+
+	deadline, err = parseJobDeadline("2025-03-01T13:05:00+01:00", now, minAhead, maxAhead)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(5*time.Minute), deadline.UTC())
+
+	for _, raw := range []string{
+		"in five minutes",
+		"2025-03-01 12:05:00",      // Not RFC 3339
+		"2025-03-01T12:00:05Z",     // Under the minimum
+		"2025-03-01T11:59:00Z",     // Already passed
+		"2025-03-01T13:00:01.000Z", // Past the retention window
+	} {
+		_, err := parseJobDeadline(raw, now, minAhead, maxAhead)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestJobDeadlineMissed(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	past, future := TimeToISO8601(now.Add(-time.Second)), TimeToISO8601(now.Add(time.Second))
+
+	assert.True(t, jobDeadlineMissed(Job{Status: "queued", Deadline: past}, now))
+	assert.False(t, jobDeadlineMissed(Job{Status: "queued", Deadline: future}, now))
+	assert.False(t, jobDeadlineMissed(Job{Status: "queued"}, now))
+	// Jobs a worker has picked up, or that already finished, are left alone.
+	assert.False(t, jobDeadlineMissed(Job{Status: "processing_direct", Deadline: past}, now))
+	assert.False(t, jobDeadlineMissed(Job{Status: "completed", Deadline: past}, now))
+}
+
+func TestDeadlineMissesStats(t *testing.T) {
+	d := newDeadlineMisses()
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < deadlineMissMinSamples; i++ {
+		d.recordSubmitted(start)
+	}
+	d.recordMissed(start.Add(time.Minute))
+	stats := d.Stats(start.Add(time.Minute))
+	assert.Equal(t, int(deadlineMissWindow/time.Minute), stats.WindowMinutes)
+	assert.Equal(t, deadlineMissMinSamples, stats.Submitted)
+	assert.Equal(t, 1, stats.Missed)
+	assert.InDelta(t, 0.1, stats.MissRate, 1e-9)
+	assert.False(t, stats.Degraded)
+
+	d.recordMissed(start.Add(2 * time.Minute))
+	assert.True(t, d.Stats(start.Add(2*time.Minute)).Degraded)
+
+	// Submissions age out of the window before the later misses do.
+	stats = d.Stats(start.Add(deadlineMissWindow))
+	assert.Equal(t, 0, stats.Submitted)
+	assert.Equal(t, 2, stats.Missed)
+	assert.Zero(t, stats.MissRate)
+	assert.False(t, stats.Degraded)
+
+	// A bucket reused for a later minute starts from zero.
+	d.recordSubmitted(start.Add(deadlineMissWindow))
+	stats = d.Stats(start.Add(deadlineMissWindow))
+	assert.Equal(t, 1, stats.Submitted)
+	assert.Equal(t, 1.0, stats.MissRate)
+}
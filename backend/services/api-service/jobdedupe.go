@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+)
+
+// jobDedupeCollection maps the content hash of a public execution to the job that last ran
+// it. Documents carry delete_after for a TTL policy; the "job-dedupe-expiry" maintenance task
+// removes them where none is configured.
+const (
+	jobDedupeCollection  = "job_dedupe"
+	jobDedupeExpiryBatch = 300
+)
+
+// JobDedupeKey identifies a public execution by what it runs. A record stored under it is
+// reused until DeleteAfter.
+type JobDedupeKey struct {
+	Hash        string
+	DeleteAfter time.Time
+}
+
+// JobDedupeRecord is the stored mapping from a content hash to its job.
+type JobDedupeRecord struct {
+	JobID       string    `firestore:"job_id"`
+	CreatedAt   string    `firestore:"created_at"` // ISO 8601 string
+	DeleteAfter time.Time `firestore:"delete_after"`
+}
+
+// publicExecutionHash is the hex SHA-256 of a public execution's code, language and input.
+// Each part is length-prefixed so no two requests hash the same by shifting bytes between
+// parts.
+func publicExecutionHash(code, language, input string) string {
+	h := sha256.New()
+	for _, part := range []string{language, code, input} {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// jobDedupeKeyFor returns the dedupe key of a public execution, or nil when it must run on
+// its own: dedupe is off, the client sent an idempotency key, or it asked for a result
+// callback only a new run would deliver. Authenticated executions are never deduplicated,
+// since the workspace can change between runs.
+func (ac *ApiController) jobDedupeKeyFor(reqBody RequestBody, idemKey *IdempotencyKey) *JobDedupeKey {
+	if !ac.AppConfig.DedupePublicExecutions || idemKey != nil || reqBody.CallbackURL != "" {
+		return nil
+	}
+	return &JobDedupeKey{
+		Hash:        publicExecutionHash(reqBody.Code, reqBody.Language, reqBody.Input),
+		DeleteAfter: time.Now().UTC().Add(ac.AppConfig.JobDedupeWindow),
+	}
+}
+
+// reusableDedupedJob reports whether a job can answer a request with the same content hash.
+// Only completed jobs are reused; a pending, failed or cancelled one is superseded by the new
+// job.
+func reusableDedupedJob(job Job) bool {
+	return job.Status == "completed" && !isPastDeleteAfter(job.DeleteAfter)
+}
+
+// CreateDeduplicated writes the job, the quota charge and the record pointing at the job in
+// one transaction, unless it returns a cached job instead.
+func (s *firestoreJobStore) CreateDeduplicated(ctx context.Context, key JobDedupeKey, quota *JobQuota, jobID string, job Job) (StoredJob, bool, error) {
+	recordRef := s.fs.Collection(jobDedupeCollection).Doc(key.Hash)
+	var stored StoredJob
+	var cached bool
+	err := s.fs.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		cached = false
+		snap, err := tx.Get(recordRef)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if err == nil && !snapshotPastDeleteAfter(snap) {
+			var record JobDedupeRecord
+			if err := snap.DataTo(&record); err != nil {
+				return fmt.Errorf("failed to parse dedupe record: %w", err)
+			}
+			jobSnap, err := tx.Get(s.fs.Collection(s.collection).Doc(record.JobID))
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			if err == nil {
+				var existing Job
+				if err := jobSnap.DataTo(&existing); err != nil {
+					return fmt.Errorf("failed to parse job: %w", err)
+				}
+				if reusableDedupedJob(existing) {
+					stored, cached = StoredJob{ID: record.JobID, Job: existing}, true
+					return nil
+				}
+			}
+		}
+
+		if quota != nil {
+			if err := s.chargeJobQuota(tx, *quota, 1); err != nil {
+				return err
+			}
+		}
+		if err := tx.Set(recordRef, JobDedupeRecord{JobID: jobID, CreatedAt: NowISO8601(), DeleteAfter: key.DeleteAfter}); err != nil {
+			return err
+		}
+		stored = StoredJob{ID: jobID, Job: job}
+		return tx.Set(s.fs.Collection(s.collection).Doc(jobID), job)
+	})
+	return stored, cached, err
+}
+
+// runJobDedupeExpiryMaintenance is the "job-dedupe-expiry" maintenance task: it removes one
+// batch of dedupe records past their delete_after.
+func (ac *ApiController) runJobDedupeExpiryMaintenance(ctx context.Context) (interface{}, error) {
+	return ac.deleteExpiredRecords(ctx, jobDedupeCollection, jobDedupeExpiryBatch)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// executeSnippet submits a public execution and returns its response.
+func executeSnippet(t *testing.T, r *gin.Engine, body RequestBody) map[string]interface{} {
+	t.Helper()
+	var resp map[string]interface{}
+	w := serveJSON(r, http.MethodPost, "/api/execute", body, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	return resp
+}
+
+// completePublicJob reports a job completed through the worker callback.
+func completePublicJob(t *testing.T, r *gin.Engine, jobID string) {
+	t.Helper()
+	w := serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/finished", JobResultReport{Status: "completed", Output: "1\n"}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+}
+
+func TestPublicExecutionHash(t *testing.T) {
+	base := publicExecutionHash("print(1)", "python", "")
+	assert.Equal(t, base, publicExecutionHash("print(1)", "python", ""))
+	assert.NotEqual(t, base, publicExecutionHash("print(1)", "python", "x"))
+	assert.NotEqual(t, base, publicExecutionHash("print(1)", "javascript", ""))
+	assert.NotEqual(t, publicExecutionHash("ab", "python", "c"), publicExecutionHash("a", "python", "bc"),
+		"bytes moved between parts change the hash")
+}
+
+func TestExecuteCode_DedupeDisabled(t *testing.T) {
+	r, _, tasks := newEmbeddedHarness(t)
+	body := RequestBody{Code: "print(1)", Language: "python"}
+
+	first := executeSnippet(t, r, body)
+	completePublicJob(t, r, first["job_id"].(string))
+	second := executeSnippet(t, r, body)
+	assert.NotEqual(t, first["job_id"], second["job_id"])
+	assert.Nil(t, second["cached"])
+	assert.Len(t, tasks.tasksTo("/execute"), 2)
+}
+
+func TestExecuteCode_DedupeCompletedJob(t *testing.T) {
+	r, ac, tasks := newEmbeddedHarness(t)
+	ac.AppConfig.DedupePublicExecutions = true
+	ac.AppConfig.JobDedupeWindow = time.Hour
+	body := RequestBody{Code: "print(1)", Language: "python"}
+
+	first := executeSnippet(t, r, body)
+	pending := executeSnippet(t, r, body)
+	assert.NotEqual(t, first["job_id"], pending["job_id"], "a job that has not completed is not reused")
+	assert.Nil(t, pending["cached"])
+
+	completePublicJob(t, r, pending["job_id"].(string))
+	cached := executeSnippet(t, r, body)
+	assert.Equal(t, pending["job_id"], cached["job_id"])
+	assert.Equal(t, pending["result_token"], cached["result_token"])
+	assert.Equal(t, true, cached["cached"])
+	assert.Len(t, tasks.tasksTo("/execute"), 2, "a cached execution queues no task")
+
+	other := executeSnippet(t, r, RequestBody{Code: "print(1)", Language: "python", Input: "x"})
+	assert.NotEqual(t, pending["job_id"], other["job_id"])
+	assert.Nil(t, other["cached"])
+
+	var keyed map[string]interface{}
+	w := executeWithKey(r, "retry-1", &keyed)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEqual(t, pending["job_id"], keyed["job_id"], "an idempotency key bypasses dedupe")
+	assert.Nil(t, keyed["cached"])
+
+	withCallback := body
+	withCallback.CallbackURL = "https://hooks.example.com/done"
+	assert.Nil(t, ac.jobDedupeKeyFor(withCallback, nil), "a result callback bypasses dedupe")
+}
+
+func TestExecuteCode_DedupeFailedJobNotReused(t *testing.T) {
+	r, ac, _ := newEmbeddedHarness(t)
+	ac.AppConfig.DedupePublicExecutions = true
+	ac.AppConfig.JobDedupeWindow = time.Hour
+	body := RequestBody{Code: "raise SystemExit(1)", Language: "python"}
+
+	first := executeSnippet(t, r, body)
+	w := serveJSON(r, http.MethodPost, "/internal/jobs/"+first["job_id"].(string)+"/finished", JobResultReport{Status: "failed", Error: "exit 1"}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	second := executeSnippet(t, r, body)
+	assert.NotEqual(t, first["job_id"], second["job_id"])
+	assert.Nil(t, second["cached"])
+}
+
+func TestEmbeddedJobStore_DedupeExpiry(t *testing.T) {
+	_, ac, _ := newEmbeddedHarness(t)
+	ctx := context.Background()
+	key := JobDedupeKey{Hash: "h", DeleteAfter: time.Now().Add(time.Hour)}
+
+	stored, cached, err := ac.Jobs.CreateDeduplicated(ctx, key, nil, "job-1", Job{Status: "completed"})
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, "job-1", stored.ID)
+
+	stored, cached, err = ac.Jobs.CreateDeduplicated(ctx, key, nil, "job-2", Job{Status: "queued"})
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, "job-1", stored.ID)
+
+	_, err = ac.Jobs.DeleteExpired(ctx, time.Now().Add(2*time.Hour), nil)
+	assert.NoError(t, err)
+	stored, cached, err = ac.Jobs.CreateDeduplicated(ctx, key, nil, "job-3", Job{Status: "queued"})
+	assert.NoError(t, err)
+	assert.False(t, cached, "an expired record starts a new job")
+	assert.Equal(t, "job-3", stored.ID)
+}
+
+func TestFirestoreJobStore_CreateDeduplicated(t *testing.T) {
+	h := newHandlerHarness(t)
+	ctx := context.Background()
+	store := newFirestoreJobStore(h.ac.FirestoreClient, h.ac.FirestoreJobsCollection)
+	key := JobDedupeKey{Hash: publicExecutionHash("print(1)", "python", uuid.NewString()), DeleteAfter: time.Now().Add(time.Hour)}
+	first, second, third := uuid.NewString(), uuid.NewString(), uuid.NewString()
+
+	stored, cached, err := store.CreateDeduplicated(ctx, key, nil, first, Job{Status: "queued"})
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, first, stored.ID)
+
+	stored, cached, err = store.CreateDeduplicated(ctx, key, nil, second, Job{Status: "queued"})
+	assert.NoError(t, err)
+	assert.False(t, cached, "a queued job is superseded")
+	assert.Equal(t, second, stored.ID)
+
+	_, err = store.Update(ctx, second, func(job *Job) (bool, error) {
+		job.Status = "completed"
+		return true, nil
+	})
+	assert.NoError(t, err)
+	stored, cached, err = store.CreateDeduplicated(ctx, key, nil, third, Job{Status: "queued"})
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, second, stored.ID)
+	_, err = store.Get(ctx, third)
+	assert.ErrorIs(t, err, errJobNotFound, "a cached hit stores no job")
+
+	// Once the record expires the maintenance task removes it.
+	_, err = h.ac.FirestoreClient.Collection(jobDedupeCollection).Doc(key.Hash).Update(ctx, []docstore.Update{
+		{Path: deleteAfterField, Value: time.Now().Add(-time.Minute)},
+	})
+	assert.NoError(t, err)
+	_, err = h.ac.runJobDedupeExpiryMaintenance(ctx)
+	assert.NoError(t, err)
+	_, err = h.ac.FirestoreClient.Collection(jobDedupeCollection).Doc(key.Hash).Get(ctx)
+	assert.True(t, isNotFound(err))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/1liale/api-service/streaming"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// jobEventFor builds the event for job, including the result once it has finished.
+func jobEventFor(jobID string, job Job) JobEvent {
+	event := JobEvent{JobID: jobID, Status: job.Status, CancelRequested: job.CancelRequested, StartedAt: job.StartedAt}
+	if isTerminalJobStatus(job.Status) {
+		event.Output, event.Error, event.FailureType, event.CompletedAt = job.Output, job.Error, job.FailureType, job.CompletedAt
+		event.DurationMs = job.DurationMs
+	}
+	return event
+}
+
+// StreamWorkspaceJobEvents streams a workspace job's status as server-sent "status" events:
+// one for the current state, then one per change. The stream closes after the event for a
+// finished job, when the job is deleted ("gone" event), when the client disconnects or
+// after JobEventsMaxDuration, after which clients reconnect or fall back to polling.
+func (ac *ApiController) StreamWorkspaceJobEvents(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	jobID := c.Param("jobId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "StreamWorkspaceJobEvents", "workspace_id": workspaceID, "job_id": jobID, "user_id": userID})
+
+	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Workspace membership check failed for StreamWorkspaceJobEvents.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+		return
+	}
+
+	jobRef := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Doc(jobID)
+	snap, err := getLiveDocument(ctx, jobRef)
+	if err != nil && !isNotFound(err) {
+		logCtx.WithError(err).Error("Failed to load job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+	var job Job
+	if err != nil || snap.DataTo(&job) != nil || job.WorkspaceID != workspaceID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	cfg := ac.AppConfig.Streaming
+	cfg.MaxDuration = ac.AppConfig.JobEventsMaxDuration
+	sse := streaming.StartSSE(ctx, c.Writer, cfg, ac.streams)
+	err = ac.sendJobEvents(sse, jobID)
+	reason := sse.Close(err)
+	if err != nil {
+		logCtx.WithError(err).WithField("stream_end", reason).Error("Job event stream failed.")
+		return
+	}
+	logCtx.WithField("stream_end", reason).Info("Job event stream ended.")
+}
+
+// sendJobEvents relays changes of the job document to sse until the job finishes or the
+// stream's context ends. Updates that do not change the event, such as heartbeats, are not
+// sent.
+func (ac *ApiController) sendJobEvents(sse *streaming.SSE, jobID string) error {
+	ctx := sse.Context()
+	snapshots := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Doc(jobID).Snapshots(ctx)
+	defer snapshots.Stop()
+
+	var last *JobEvent
+	for {
+		snap, err := snapshots.Next()
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled {
+			// Client gone or maximum duration reached; Close records which.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !snap.Exists() {
+			return sse.Send("gone", gin.H{"jobId": jobID})
+		}
+		var job Job
+		if err := snap.DataTo(&job); err != nil {
+			return err
+		}
+		event := jobEventFor(jobID, job)
+		if last == nil || *last != event {
+			if err := sse.Send("status", event); err != nil {
+				return err
+			}
+			last = &event
+		}
+		if isTerminalJobStatus(job.Status) {
+			return nil
+		}
+	}
+}
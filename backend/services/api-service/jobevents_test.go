@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobEventFor(t *testing.T) {
+	job := Job{Status: "running_auth_workspace", Output: "partial", CancelRequested: true}
+	assert.Equal(t, JobEvent{JobID: "job-1", Status: "running_auth_workspace", CancelRequested: true}, jobEventFor("job-1", job),
+		"output is only sent once the job has finished")
+
+	job = Job{Status: "failed", Error: "boom", FailureType: "runtime_error", CompletedAt: "2026-03-01T12:00:00.000Z"}
+	assert.Equal(t, JobEvent{JobID: "job-1", Status: "failed", Error: "boom", FailureType: "runtime_error", CompletedAt: job.CompletedAt}, jobEventFor("job-1", job))
+}
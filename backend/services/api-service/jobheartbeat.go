@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// staleJobScanLimit bounds how many running jobs one stale-jobs run inspects.
+const staleJobScanLimit = 500
+
+// runningJobStatuses are the non-terminal statuses a worker sets once it has picked a job up.
+// Queued jobs have no worker yet; RequeueJobs handles those.
+var runningJobStatuses = []string{
+	jobStatusRunning,
+	"processing_direct",
+	"processing_auth_workspace",
+	"fetching_from_r2",
+	"running_auth_workspace",
+}
+
+// jobLastSeen returns the last time a worker was heard from for a job: its latest heartbeat,
+// status change or, failing both, its submission.
+func jobLastSeen(job Job) time.Time {
+	var last time.Time
+	for _, ts := range []string{job.LastHeartbeatAt, job.UpdatedAt, job.SubmittedAt} {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil && t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// jobWorkerLost reports whether a running job's worker has been silent for longer than timeout.
+func jobWorkerLost(job Job, now time.Time, timeout time.Duration) bool {
+	if isTerminalJobStatus(job.Status) || isAwaitingDispatch(job.Status) {
+		return false
+	}
+	return now.Sub(jobLastSeen(job)) > timeout
+}
+
+// HandleJobHeartbeat is called by execution workers every ~30s while they run a job. A
+// finished job answers 409, telling a worker that was presumed lost to stop; cancelRequested
+// tells a worker its job was cancelled while running.
+func (ac *ApiController) HandleJobHeartbeat(c *gin.Context) {
+	jobID := c.Param("jobId")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"job_id": jobID, "handler": "HandleJobHeartbeat"})
+
+	var status, heartbeatAt string
+	job, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+		status = job.Status
+		if isTerminalJobStatus(job.Status) {
+			return false, errJobAlreadyTerminal
+		}
+		heartbeatAt = NowISO8601()
+		job.LastHeartbeatAt = heartbeatAt
+		return true, nil
+	})
+	switch {
+	case errors.Is(err, errJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	case errors.Is(err, errJobAlreadyTerminal):
+		logCtx.WithField("status", status).Warn("Heartbeat for a finished job; the worker should stop.")
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has already finished", "status": status})
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to record job heartbeat.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record heartbeat"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"jobId": jobID, "status": status, "lastHeartbeatAt": heartbeatAt, "cancelRequested": job.CancelRequested})
+	}
+}
+
+// runStaleJobMaintenance is the "stale-jobs" maintenance task: it fails running jobs whose
+// worker has not sent a heartbeat or status change within JobHeartbeatTimeout, with
+// failure_type worker_lost. Workers can only send heartbeats when API_BASE_URL is set, so the
+// task does nothing without it.
+func (ac *ApiController) runStaleJobMaintenance(ctx context.Context) (interface{}, error) {
+	if ac.AppConfig.APIBaseURL == "" {
+		return gin.H{"failed": 0, "disabled": true}, nil
+	}
+
+	jobs, err := ac.Jobs.List(ctx, JobQuery{Statuses: runningJobStatuses, Limit: staleJobScanLimit})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load running jobs: %w", err)
+	}
+
+	timeout := ac.AppConfig.JobHeartbeatTimeout
+	failed, errored := 0, 0
+	for _, stored := range jobs {
+		if !jobWorkerLost(stored.Job, time.Now(), timeout) {
+			continue
+		}
+		ok, err := ac.failLostJob(ctx, stored.ID, timeout)
+		switch {
+		case err != nil:
+			log.WithError(err).WithField("job_id", stored.ID).Warn("Failed to fail lost job.")
+			errored++
+		case ok:
+			failed++
+		}
+	}
+	return gin.H{"scanned": len(jobs), "failed": failed, "errors": errored}, nil
+}
+
+// failLostJob marks a job whose worker went silent as failed, rechecking inside the update
+// so a heartbeat or result that just arrived wins. It returns false in that case.
+func (ac *ApiController) failLostJob(ctx context.Context, jobID string, timeout time.Duration) (bool, error) {
+	lost := false
+	job, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+		lost = jobWorkerLost(*job, time.Now(), timeout)
+		if !lost {
+			return false, nil
+		}
+		now := NowISO8601()
+		job.Status, job.FailureType, job.CompletedAt, job.UpdatedAt = "failed", "worker_lost", now, now
+		job.Error = fmt.Sprintf("The worker stopped responding (no heartbeat for over %s)", timeout)
+		return true, nil
+	})
+	if err != nil || !lost {
+		return false, err
+	}
+
+	logCtx := log.WithFields(log.Fields{"job_id": jobID, "last_seen": TimeToISO8601(jobLastSeen(job))})
+	logCtx.Warn("Job failed: worker lost.")
+	ac.afterJobFinished(ctx, jobID, job, logCtx)
+	return true, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var heartbeatTestNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func minutesAgo(m int) string {
+	return TimeToISO8601(heartbeatTestNow.Add(-time.Duration(m) * time.Minute))
+}
+
+func TestJobLastSeen(t *testing.T) {
+	job := Job{Status: "running_auth_workspace", SubmittedAt: minutesAgo(60)}
+	assert.Equal(t, minutesAgo(60), TimeToISO8601(jobLastSeen(job)))
+
+	job.UpdatedAt = minutesAgo(10)
+	assert.Equal(t, minutesAgo(10), TimeToISO8601(jobLastSeen(job)))
+
+	job.LastHeartbeatAt = minutesAgo(1)
+	assert.Equal(t, minutesAgo(1), TimeToISO8601(jobLastSeen(job)))
+
+	// A status change after the last heartbeat also counts.
+	job.UpdatedAt = TimeToISO8601(heartbeatTestNow)
+	assert.Equal(t, TimeToISO8601(heartbeatTestNow), TimeToISO8601(jobLastSeen(job)))
+}
+
+func TestJobWorkerLost(t *testing.T) {
+	timeout := 2 * time.Minute
+
+	// Long-running job with a recent heartbeat: alive, however old the submission.
+	job := Job{Status: "running_auth_workspace", SubmittedAt: minutesAgo(240), LastHeartbeatAt: minutesAgo(1)}
+	assert.False(t, jobWorkerLost(job, heartbeatTestNow, timeout))
+
+	job.LastHeartbeatAt = minutesAgo(3)
+	assert.True(t, jobWorkerLost(job, heartbeatTestNow, timeout))
+
+	// A job that just started has no heartbeat yet but a fresh status change.
+	started := Job{Status: "processing_auth_workspace", SubmittedAt: minutesAgo(30), UpdatedAt: minutesAgo(0)}
+	assert.False(t, jobWorkerLost(started, heartbeatTestNow, timeout))
+
+	// Queued and finished jobs are never lost.
+	assert.False(t, jobWorkerLost(Job{Status: "queued", SubmittedAt: minutesAgo(60)}, heartbeatTestNow, timeout))
+	assert.False(t, jobWorkerLost(Job{Status: "failed", SubmittedAt: minutesAgo(60)}, heartbeatTestNow, timeout))
+	assert.False(t, jobWorkerLost(Job{Status: "completed", SubmittedAt: minutesAgo(60)}, heartbeatTestNow, timeout))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on the labels a job may carry.
+const (
+	maxJobLabels         = 10
+	maxJobLabelKeyLen    = 64
+	maxJobLabelValueLen  = 128
+	jobLabelFilterPrefix = "label."
+)
+
+// validateJobLabelKey rejects label keys that are empty, too long or contain '/'.
+func validateJobLabelKey(key string) error {
+	switch {
+	case key == "":
+		return fmt.Errorf("label keys must not be empty")
+	case utf8.RuneCountInString(key) > maxJobLabelKeyLen:
+		return fmt.Errorf("label key %q is longer than %d characters", key, maxJobLabelKeyLen)
+	case strings.Contains(key, "/"):
+		return fmt.Errorf("label key %q must not contain '/'", key)
+	}
+	return nil
+}
+
+// validateJobLabels checks the labels of an execution request. No labels is valid.
+func validateJobLabels(opts ExecutionOptions) error {
+	if len(opts.Labels) > maxJobLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxJobLabels)
+	}
+	for key, value := range opts.Labels {
+		if err := validateJobLabelKey(key); err != nil {
+			return err
+		}
+		if utf8.RuneCountInString(value) > maxJobLabelValueLen {
+			return fmt.Errorf("value of label %q is longer than %d characters", key, maxJobLabelValueLen)
+		}
+	}
+	return nil
+}
+
+// parseJobLabelFilters returns the label.key=value filters of a job listing query. Each key
+// may be given once.
+func parseJobLabelFilters(query url.Values) (map[string]string, error) {
+	filters := make(map[string]string)
+	for param, values := range query {
+		key, ok := strings.CutPrefix(param, jobLabelFilterPrefix)
+		if !ok {
+			continue
+		}
+		if err := validateJobLabelKey(key); err != nil {
+			return nil, err
+		}
+		if len(values) != 1 {
+			return nil, fmt.Errorf("label %q may only be filtered on once", key)
+		}
+		filters[key] = values[0]
+	}
+	if len(filters) > maxJobLabels {
+		return nil, fmt.Errorf("at most %d label filters are allowed", maxJobLabels)
+	}
+	return filters, nil
+}
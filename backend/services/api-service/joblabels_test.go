@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateJobLabels(t *testing.T) {
+	assert.NoError(t, validateJobLabels(ExecutionOptions{}))
+	assert.NoError(t, validateJobLabels(ExecutionOptions{Labels: map[string]string{"purpose": "ci", "suite.name": "", "ü": "ok"}}))
+
+	for name, labels := range map[string]map[string]string{
+		"empty key":    {"": "ci"},
+		"slash in key": {"team/purpose": "ci"},
+		"long key":     {strings.Repeat("k", maxJobLabelKeyLen+1): "ci"},
+		"long value":   {"purpose": strings.Repeat("v", maxJobLabelValueLen+1)},
+	} {
+		assert.Error(t, validateJobLabels(ExecutionOptions{Labels: labels}), name)
+	}
+
+	err := validateJobLabels(ExecutionOptions{Labels: map[string]string{"team/purpose": "ci"}})
+	assert.ErrorContains(t, err, `"team/purpose"`, "the offending key is named")
+
+	tooMany := make(map[string]string)
+	for _, key := range strings.Split("a b c d e f g h i j k", " ") {
+		tooMany[key] = "x"
+	}
+	assert.Error(t, validateJobLabels(ExecutionOptions{Labels: tooMany}))
+}
+
+func TestParseJobLabelFilters(t *testing.T) {
+	query, _ := url.ParseQuery("status=completed&label.purpose=ci&label.suite=unit")
+	filters, err := parseJobLabelFilters(query)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"purpose": "ci", "suite": "unit"}, filters)
+
+	for _, raw := range []string{"label.=ci", "label.a/b=ci", "label.purpose=ci&label.purpose=grading"} {
+		query, _ := url.ParseQuery(raw)
+		_, err := parseJobLabelFilters(query)
+		assert.Error(t, err, raw)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobMetricsDefaultWindow is the window GET /workspaces/:workspaceId/jobs/metrics covers
+// without a since parameter.
+const jobMetricsDefaultWindow = 7 * 24 * time.Hour
+
+// jobMetricsScanLimit bounds how many jobs one metrics request reads. Latencies, and counts
+// when aggregate queries fail, come from at most this many of the newest jobs; the response
+// is flagged approximate when more matched.
+var jobMetricsScanLimit = 2000
+
+// newJobMetrics returns empty metrics for a window, with a zero count for every status group
+// and supported language.
+func newJobMetrics(since, until time.Time) WorkspaceJobMetrics {
+	metrics := WorkspaceJobMetrics{
+		Since:      TimeToISO8601(since),
+		Until:      TimeToISO8601(until),
+		ByStatus:   make(map[string]int64, len(workspaceJobStatusFilters)),
+		ByLanguage: make(map[string]int64, len(supportedLanguages)),
+	}
+	for group := range workspaceJobStatusFilters {
+		metrics.ByStatus[group] = 0
+	}
+	for _, language := range supportedLanguages {
+		metrics.ByLanguage[language] = 0
+	}
+	return metrics
+}
+
+// countJobMetrics counts the jobs matched by q in total, by status group and by language
+// with aggregate queries, so no job is read.
+func countJobMetrics(ctx context.Context, q docstore.Query, metrics *WorkspaceJobMetrics) error {
+	count := func(q docstore.Query) (int64, error) {
+		res, err := q.NewAggregationQuery().WithCount("count").Get(ctx)
+		if err != nil {
+			return 0, err
+		}
+		v, _ := res["count"].(*firestorepb.Value)
+		return v.GetIntegerValue(), nil
+	}
+
+	total, err := count(q)
+	if err != nil {
+		return fmt.Errorf("failed to count jobs: %w", err)
+	}
+	metrics.Total = total
+	for group, statuses := range workspaceJobStatusFilters {
+		if metrics.ByStatus[group], err = count(q.Where("status", "in", statuses)); err != nil {
+			return fmt.Errorf("failed to count %s jobs: %w", group, err)
+		}
+	}
+	for _, language := range supportedLanguages {
+		if metrics.ByLanguage[language], err = count(q.Where("language", "==", language)); err != nil {
+			return fmt.Errorf("failed to count %s jobs: %w", language, err)
+		}
+	}
+	return nil
+}
+
+// jobStatusGroup returns the status filter of GET /workspaces/:workspaceId/jobs a stored
+// status falls under, or "" for a status none covers.
+func jobStatusGroup(status string) string {
+	for group, statuses := range workspaceJobStatusFilters {
+		if slices.Contains(statuses, status) {
+			return group
+		}
+	}
+	return ""
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted values.
+func percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// jobLatencies returns the submission-to-completion latency of each finished job, sorted.
+// Jobs with timestamps that cannot be parsed are left out.
+func jobLatencies(jobs []Job) []int64 {
+	var latencies []int64
+	for _, job := range jobs {
+		if job.Status != "completed" && job.Status != "failed" {
+			continue
+		}
+		submitted, err1 := time.Parse(time.RFC3339, job.SubmittedAt)
+		completed, err2 := time.Parse(time.RFC3339, job.CompletedAt)
+		if err1 != nil || err2 != nil || completed.Before(submitted) {
+			continue
+		}
+		latencies = append(latencies, completed.Sub(submitted).Milliseconds())
+	}
+	slices.Sort(latencies)
+	return latencies
+}
+
+// GetWorkspaceJobMetrics summarizes a workspace's executions submitted since the since query
+// parameter (ISO 8601, default 7 days ago): counts by status and language, the failure rate
+// and latency percentiles. Counts come from aggregate queries; when those fail, from a scan of
+// at most jobMetricsScanLimit jobs. Needs the composite indexes of ListWorkspaceJobs.
+func (ac *ApiController) GetWorkspaceJobMetrics(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "GetWorkspaceJobMetrics", "workspace_id": workspaceID, "user_id": userID})
+
+	now := time.Now().UTC()
+	since := now.Add(-jobMetricsDefaultWindow)
+	if raw := c.Query("since"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil || !parsed.Before(now) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an ISO 8601 timestamp in the past"})
+			return
+		}
+		since = parsed
+	}
+
+	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Workspace membership check failed for GetWorkspaceJobMetrics.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+		return
+	}
+
+	q := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).
+		Where("workspace_id", "==", workspaceID).
+		Where("execution_type", "==", executionTypeWorkspace).
+		Where("submitted_at", ">=", TimeToISO8601(since))
+	metrics := newJobMetrics(since, now)
+
+	// With the counts in hand only finished jobs need reading, for their latencies.
+	scan := q.Where("status", "in", []string{"completed", "failed"})
+	counted := true
+	if err := countJobMetrics(ctx, q, &metrics); err != nil {
+		logCtx.WithError(err).Warn("Aggregate job counts failed; counting from a scan.")
+		metrics, counted, scan = newJobMetrics(since, now), false, q
+	}
+	docs, err := scan.OrderBy("submitted_at", docstore.Desc).Limit(jobMetricsScanLimit + 1).Documents(ctx).GetAll()
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to query jobs")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load jobs"})
+		return
+	}
+	if len(docs) > jobMetricsScanLimit {
+		docs, metrics.Approximate = docs[:jobMetricsScanLimit], true
+	}
+	jobs := make([]Job, 0, len(docs))
+	for _, doc := range docs {
+		var job Job
+		if err := doc.DataTo(&job); err != nil {
+			logCtx.WithError(err).WithField("job_id", doc.Ref.ID).Warn("Failed to parse job")
+			continue
+		}
+		jobs = append(jobs, job)
+		if !counted {
+			metrics.Total++
+			if group := jobStatusGroup(job.Status); group != "" {
+				metrics.ByStatus[group]++
+			}
+			metrics.ByLanguage[job.Language]++
+		}
+	}
+
+	if finished := metrics.ByStatus["completed"] + metrics.ByStatus["failed"]; finished > 0 {
+		metrics.FailureRate = float64(metrics.ByStatus["failed"]) / float64(finished)
+	}
+	if latencies := jobLatencies(jobs); len(latencies) > 0 {
+		metrics.LatencyMs = &JobLatencyPercentiles{
+			P50:     percentile(latencies, 50),
+			P95:     percentile(latencies, 95),
+			Samples: len(latencies),
+		}
+	}
+	c.JSON(http.StatusOK, metrics)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	assert.Equal(t, int64(7), percentile([]int64{7}, 50))
+	assert.Equal(t, int64(7), percentile([]int64{7}, 95))
+
+	values := []int64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	assert.Equal(t, int64(50), percentile(values, 50))
+	assert.Equal(t, int64(100), percentile(values, 95))
+	assert.Equal(t, int64(10), percentile(values, 0))
+}
+
+func TestJobLatencies(t *testing.T) {
+	jobs := []Job{
+		{Status: "completed", SubmittedAt: "2026-03-01T12:00:00.000Z", CompletedAt: "2026-03-01T12:00:03.000Z"},
+		{Status: "failed", SubmittedAt: "2026-03-01T12:00:00.000Z", CompletedAt: "2026-03-01T12:00:01.500Z"},
+		{Status: "running", SubmittedAt: "2026-03-01T12:00:00.000Z"},
+		{Status: "completed", SubmittedAt: "2026-03-01T12:00:00.000Z"},                                          // No completion time
+		{Status: "completed", SubmittedAt: "2026-03-01T12:00:05.000Z", CompletedAt: "2026-03-01T12:00:00.000Z"}, // Clock skew
+	}
+	assert.Equal(t, []int64{1500, 3000}, jobLatencies(jobs))
+}
+
+func TestJobStatusGroup(t *testing.T) {
+	assert.Equal(t, "running", jobStatusGroup(jobStatusRunning))
+	assert.Equal(t, "scheduled", jobStatusGroup(jobStatusScheduled))
+	assert.Equal(t, "completed", jobStatusGroup("completed"))
+	assert.Empty(t, jobStatusGroup("unknown"))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// JobCompletionNotification is the task payload asking the notifications service to tell a
+// user their workspace execution finished.
+type JobCompletionNotification struct {
+	JobID         string `json:"job_id"`
+	UserID        string `json:"user_id"`
+	Recipient     string `json:"recipient"`
+	WorkspaceID   string `json:"workspace_id"`
+	WorkspaceName string `json:"workspace_name"`
+	Status        string `json:"status"`
+	FailureType   string `json:"failure_type,omitempty"`
+	CompletedAt   string `json:"completed_at,omitempty"`
+	Link          string `json:"link"` // Opens the job in the editor
+}
+
+// errNoUserEmail is returned for users whose auth record has no email address.
+var errNoUserEmail = errors.New("user has no email address")
+
+// firebaseUserEmail returns the email address on a user's Firebase Auth record.
+func firebaseUserEmail(ctx context.Context, userID string) (string, error) {
+	if firebaseApp == nil {
+		return "", errors.New("firebase app not initialized")
+	}
+	client, err := firebaseApp.Auth(ctx)
+	if err != nil {
+		return "", err
+	}
+	user, err := client.GetUser(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if user.Email == "" {
+		return "", errNoUserEmail
+	}
+	return user.Email, nil
+}
+
+// jobNotificationsEnabled reports whether completion notifications can be sent.
+func (ac *ApiController) jobNotificationsEnabled() bool {
+	return ac.AppConfig.Services.Notifications.Enabled()
+}
+
+// jobLink builds the frontend URL of a workspace execution.
+func (ac *ApiController) jobLink(workspaceID, jobID string) string {
+	return fmt.Sprintf("%s/workspaces/%s/jobs/%s", ac.AppConfig.AppBaseURL, url.PathEscape(workspaceID), url.PathEscape(jobID))
+}
+
+// notifyJobCompletion enqueues the completion notification of a finished job that asked for
+// one. The job is marked notification_sent before the task is enqueued, so a second finish
+// report cannot send it twice; the mark is cleared again when enqueueing fails.
+func (ac *ApiController) notifyJobCompletion(ctx context.Context, jobID string, job Job, logCtx *log.Entry) error {
+	claimed := false
+	if _, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+		claimed = job.NotificationRequested && !job.NotificationSent
+		job.NotificationSent = true
+		return claimed, nil
+	}); err != nil {
+		return fmt.Errorf("failed to claim job notification: %w", err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	err := ac.enqueueJobNotification(ctx, jobID, job)
+	if errors.Is(err, errNoUserEmail) {
+		// Nothing can ever be sent; the claim stays so no one tries again.
+		logCtx.WithField("user_id", job.UserID).Warn("Job completion notification skipped: user has no email address.")
+		return nil
+	}
+	if err != nil {
+		if _, releaseErr := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+			job.NotificationSent = false
+			return true, nil
+		}); releaseErr != nil {
+			logCtx.WithError(releaseErr).Warn("Failed to release job notification claim.")
+		}
+		return err
+	}
+	logCtx.Info("Job completion notification queued.")
+	return nil
+}
+
+// enqueueJobNotification looks up the recipient and workspace of a job and enqueues its
+// notification task.
+func (ac *ApiController) enqueueJobNotification(ctx context.Context, jobID string, job Job) error {
+	recipient, err := ac.lookupUserEmail(ctx, job.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to look up user email: %w", err)
+	}
+	names, err := ac.workspaceNames(ctx, []string{job.WorkspaceID})
+	if err != nil {
+		return err
+	}
+
+	svc := ac.AppConfig.Services.Notifications
+	notification := JobCompletionNotification{
+		JobID:         jobID,
+		UserID:        job.UserID,
+		Recipient:     recipient,
+		WorkspaceID:   job.WorkspaceID,
+		WorkspaceName: names[job.WorkspaceID],
+		Status:        job.Status,
+		FailureType:   job.FailureType,
+		CompletedAt:   job.CompletedAt,
+		Link:          ac.jobLink(job.WorkspaceID, jobID),
+	}
+	queuePath := ac.AppConfig.GetQueuePath(svc.QueueID)
+	if _, err := ac.enqueueTask(ctx, queuePath, fmt.Sprintf("%s/job-completed", svc.ServiceURL), svc.ServiceAccount, notification); err != nil {
+		return fmt.Errorf("failed to enqueue job notification: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// enableJobNotifications configures a notifications service and a fixed email per user.
+func enableJobNotifications(h *handlerHarness) {
+	h.ac.AppConfig.AppBaseURL = "https://app.test"
+	h.ac.AppConfig.Services.Notifications = ServiceConfig{
+		QueueID:        "notifications-queue",
+		ServiceURL:     "https://notifications.test",
+		ServiceAccount: "notifications@demo-handlers.iam.gserviceaccount.com",
+	}
+	h.ac.lookupUserEmail = func(_ context.Context, userID string) (string, error) {
+		return userID + "@example.com", nil
+	}
+}
+
+// submitNotifiedJob runs main.py in a new workspace with notifyOnCompletion set.
+func submitNotifiedJob(h *handlerHarness, owner string) (string, string) {
+	h.t.Helper()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+	var resp ExecuteAuthResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{
+		Language:           "python",
+		EntrypointFile:     "main.py",
+		NotifyOnCompletion: true,
+	}, &resp)
+	assert.Equal(h.t, http.StatusOK, w.Code, w.Body.String())
+	return workspaceID, resp.JobID
+}
+
+// finishJob marks a job completed as a worker report would.
+func finishJob(h *handlerHarness, jobID string) Job {
+	h.t.Helper()
+	job, err := h.ac.Jobs.Update(context.Background(), jobID, func(job *Job) (bool, error) {
+		job.Status, job.Output, job.CompletedAt = "completed", "hi\n", NowISO8601()
+		return true, nil
+	})
+	assert.NoError(h.t, err)
+	return job
+}
+
+func TestJobNotifications_SentOnce(t *testing.T) {
+	h := newHandlerHarness(t)
+	enableJobNotifications(h)
+	ctx := context.Background()
+	owner := "owner-" + uuid.New().String()
+	workspaceID, jobID := submitNotifiedJob(h, owner)
+
+	job, err := h.ac.Jobs.Get(ctx, jobID)
+	assert.NoError(t, err)
+	assert.True(t, job.NotificationRequested)
+	assert.False(t, job.NotificationSent)
+	assert.True(t, lastAuthPayload(h).NotifyCompletion, "the worker must report the job for the notification to be sent")
+
+	finishedPath := "/internal/jobs/" + jobID + "/finished"
+	w := h.do(http.MethodPost, finishedPath, "", JobResultReport{Status: "completed", Output: "hi\n"}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Eventually(t, func() bool { return len(h.tasks.tasksTo("/job-completed")) == 1 }, 5*time.Second, 20*time.Millisecond)
+
+	var notification JobCompletionNotification
+	assert.NoError(t, json.Unmarshal(h.tasks.tasksTo("https://notifications.test/job-completed")[0], &notification))
+	assert.Equal(t, jobID, notification.JobID)
+	assert.Equal(t, owner, notification.UserID)
+	assert.Equal(t, owner+"@example.com", notification.Recipient)
+	assert.Equal(t, workspaceID, notification.WorkspaceID)
+	assert.True(t, strings.HasPrefix(notification.WorkspaceName, "Test "), notification.WorkspaceName)
+	assert.Equal(t, "completed", notification.Status)
+	assert.Equal(t, "https://app.test/workspaces/"+workspaceID+"/jobs/"+jobID, notification.Link)
+	assert.Contains(t, h.tasks.queues(), h.ac.AppConfig.GetQueuePath("notifications-queue"))
+
+	job, err = h.ac.Jobs.Get(ctx, jobID)
+	assert.NoError(t, err)
+	assert.True(t, job.NotificationSent)
+
+	// A repeated finish report does not send it again.
+	w = h.do(http.MethodPost, finishedPath, "", JobResultReport{Status: "completed", Output: "hi\n"}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Len(t, h.tasks.tasksTo("/job-completed"), 1)
+}
+
+// lastAuthPayload decodes the most recent workspace execution task.
+func lastAuthPayload(h *handlerHarness) CloudTaskAuthPayload {
+	h.t.Helper()
+	var payload CloudTaskAuthPayload
+	bodies := h.tasks.tasksTo("/execute_auth")
+	if assert.NotEmpty(h.t, bodies) {
+		assert.NoError(h.t, json.Unmarshal(bodies[len(bodies)-1], &payload))
+	}
+	return payload
+}
+
+func TestJobNotifications_RetryAndRequeueReportCompletion(t *testing.T) {
+	h := newHandlerHarness(t)
+	enableJobNotifications(h)
+	ctx := context.Background()
+	owner := "owner-" + uuid.New().String()
+	workspaceID, jobID := submitNotifiedJob(h, owner)
+	w := h.do(http.MethodPost, "/internal/jobs/"+jobID+"/finished", "", JobResultReport{Status: "failed", Error: "exit 1"}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	var retried map[string]interface{}
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/jobs/"+jobID+"/retry", owner, nil, &retried)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	retryID, _ := retried["job_id"].(string)
+	assert.Equal(t, retryID, lastAuthPayload(h).JobID)
+	assert.True(t, lastAuthPayload(h).NotifyCompletion, "a retry is notified like the job it repeats")
+	job, err := h.ac.Jobs.Get(ctx, retryID)
+	assert.NoError(t, err)
+	assert.True(t, job.NotificationRequested)
+
+	backdateJob(h, retryID, time.Hour)
+	w = h.do(http.MethodPost, "/api/admin/jobs/requeue", "admin-"+uuid.New().String(), RequeueJobsRequest{OlderThan: "30m"}, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, retryID, lastAuthPayload(h).JobID)
+	assert.True(t, lastAuthPayload(h).NotifyCompletion, "a requeued job is still reported")
+}
+
+func TestJobNotifications_EnqueueFailureReleasesClaim(t *testing.T) {
+	h := newHandlerHarness(t)
+	enableJobNotifications(h)
+	ctx := context.Background()
+	_, jobID := submitNotifiedJob(h, "owner-"+uuid.New().String())
+	finished := finishJob(h, jobID)
+
+	h.tasks.err = errors.New("queue unavailable")
+	assert.Error(t, h.ac.notifyJobCompletion(ctx, jobID, finished, log.WithField("job_id", jobID)))
+	job, err := h.ac.Jobs.Get(ctx, jobID)
+	assert.NoError(t, err)
+	assert.False(t, job.NotificationSent, "a failed enqueue can be retried")
+
+	h.tasks.err = nil
+	assert.NoError(t, h.ac.notifyJobCompletion(ctx, jobID, finished, log.WithField("job_id", jobID)))
+	assert.Len(t, h.tasks.tasksTo("/job-completed"), 1)
+}
+
+func TestJobNotifications_NoEmail(t *testing.T) {
+	h := newHandlerHarness(t)
+	enableJobNotifications(h)
+	h.ac.lookupUserEmail = func(context.Context, string) (string, error) { return "", errNoUserEmail }
+	ctx := context.Background()
+	_, jobID := submitNotifiedJob(h, "owner-"+uuid.New().String())
+	finished := finishJob(h, jobID)
+
+	assert.NoError(t, h.ac.notifyJobCompletion(ctx, jobID, finished, log.WithField("job_id", jobID)))
+	assert.Empty(t, h.tasks.tasksTo("/job-completed"))
+	job, err := h.ac.Jobs.Get(ctx, jobID)
+	assert.NoError(t, err)
+	assert.True(t, job.NotificationSent, "there is no one to retry for")
+}
+
+func TestJobNotifications_DisabledWithoutService(t *testing.T) {
+	h := newHandlerHarness(t)
+	ctx := context.Background()
+	_, jobID := submitNotifiedJob(h, "owner-"+uuid.New().String())
+
+	job, err := h.ac.Jobs.Get(ctx, jobID)
+	assert.NoError(t, err)
+	assert.False(t, job.NotificationRequested, "the flag is ignored")
+	assert.False(t, lastAuthPayload(h).NotifyCompletion)
+
+	w := h.do(http.MethodPost, "/internal/jobs/"+jobID+"/finished", "", JobResultReport{Status: "completed", Output: "hi\n"}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Empty(t, h.tasks.tasksTo("/job-completed"))
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithy "github.com/aws/smithy-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobOutputURLTTL is how long the download URL of an offloaded job output stays valid. A new
+// URL is signed on every read of the job.
+const jobOutputURLTTL = 15 * time.Minute
+
+// jobOutputKey is where the full output of a job is stored in R2 once it is too long to keep
+// on the job; it is deleted with the job.
+func jobOutputKey(jobID string) string {
+	return fmt.Sprintf("jobs/%s/output.txt", jobID)
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// storedJobOutput is what a job keeps of its output: all of it, or its start and the R2 key
+// of the full output.
+type storedJobOutput struct {
+	Output    string
+	R2Key     string
+	Truncated bool
+}
+
+func (o storedJobOutput) apply(job *Job) {
+	job.Output, job.OutputR2Key, job.OutputTruncated = o.Output, o.R2Key, o.Truncated
+}
+
+// storeJobOutput uploads output longer than JobOutputInlineBytes to R2 and returns the start
+// the job keeps inline. When the upload fails, the truncated output is returned without a
+// key along with the error.
+func (ac *ApiController) storeJobOutput(ctx context.Context, jobID, output string) (storedJobOutput, error) {
+	limit := ac.AppConfig.JobOutputInlineBytes
+	if len(output) <= limit {
+		return storedJobOutput{Output: output}, nil
+	}
+	stored := storedJobOutput{Output: truncateUTF8(output, limit), Truncated: true}
+	key := jobOutputKey(jobID)
+	if _, err := ac.r2().S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(ac.R2BucketName),
+		Key:         aws.String(key),
+		Body:        strings.NewReader(output),
+		ContentType: aws.String("text/plain; charset=utf-8"),
+	}); err != nil {
+		return stored, fmt.Errorf("failed to upload job output: %w", err)
+	}
+	stored.R2Key = key
+	return stored, nil
+}
+
+// storeReportedOutput stores the output a worker reported for a job. It is uploaded only
+// while the job has not finished, so a late or repeated report cannot replace the object a
+// finished job points to. A failed upload still records the start of the output.
+func (ac *ApiController) storeReportedOutput(ctx context.Context, jobID, output string, logCtx *log.Entry) storedJobOutput {
+	if len(output) <= ac.AppConfig.JobOutputInlineBytes {
+		return storedJobOutput{Output: output}
+	}
+	if job, err := ac.Jobs.Get(ctx, jobID); err == nil && isTerminalJobStatus(job.Status) {
+		return storedJobOutput{Output: job.Output, R2Key: job.OutputR2Key, Truncated: job.OutputTruncated}
+	}
+	stored, err := ac.storeJobOutput(ctx, jobID, output)
+	if err != nil {
+		logCtx.WithError(err).WithField("output_bytes", len(output)).Error("Failed to offload job output; keeping only its start.")
+	}
+	return stored
+}
+
+// errJobOutputChanged aborts offloading the output of a job that changed since it was read.
+var errJobOutputChanged = errors.New("job output changed")
+
+// jobOutputForRead prepares a finished job's output for a result response. Output still
+// inline but over the limit, as written by workers that update the job themselves, is
+// offloaded first. The returned URL is signed afresh for this read and is empty when the
+// output is all inline or could not be signed.
+func (ac *ApiController) jobOutputForRead(ctx context.Context, jobID string, job Job, logCtx *log.Entry) (Job, string) {
+	if !isTerminalJobStatus(job.Status) {
+		return job, ""
+	}
+	if job.OutputR2Key == "" && len(job.Output) > ac.AppConfig.JobOutputInlineBytes {
+		stored, err := ac.storeJobOutput(ctx, jobID, job.Output)
+		if err != nil {
+			logCtx.WithError(err).Warn("Failed to offload job output.")
+			return job, ""
+		}
+		output := job.Output
+		updated, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+			if job.OutputR2Key != "" || job.Output != output {
+				return false, errJobOutputChanged
+			}
+			stored.apply(job)
+			return true, nil
+		})
+		if err != nil {
+			logCtx.WithError(err).Warn("Failed to record offloaded job output.")
+			return job, ""
+		}
+		job = updated
+	}
+	if job.OutputR2Key == "" {
+		return job, ""
+	}
+	req, err := ac.r2().Presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ac.R2BucketName),
+		Key:    aws.String(job.OutputR2Key),
+	}, func(po *s3.PresignOptions) {
+		po.Expires = jobOutputURLTTL
+	})
+	if err != nil {
+		logCtx.WithError(err).Warn("Failed to presign job output URL.")
+		return job, ""
+	}
+	return job, req.URL
+}
+
+// Job reads return the final jobOutputDefaultSlice bytes of the output unless the caller asks
+// for another range, and never more than jobOutputMaxSlice bytes.
+const (
+	jobOutputDefaultSlice = 64 << 10
+	jobOutputMaxSlice     = 1 << 20
+)
+
+// jobOutputRange is the part of a job's output a read asks for: Limit bytes from Offset, or
+// the last Limit bytes when no offset was given.
+type jobOutputRange struct {
+	Offset    int64
+	HasOffset bool
+	Limit     int64
+}
+
+// parseJobOutputRange parses the outputOffset and outputLimit query parameters; either may
+// be empty.
+func parseJobOutputRange(offset, limit string) (jobOutputRange, error) {
+	rng := jobOutputRange{Limit: jobOutputDefaultSlice}
+	if offset != "" {
+		n, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || n < 0 {
+			return rng, fmt.Errorf("outputOffset must be a non-negative integer")
+		}
+		rng.Offset, rng.HasOffset = n, true
+	}
+	if limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 1 || n > jobOutputMaxSlice {
+			return rng, fmt.Errorf("outputLimit must be between 1 and %d", jobOutputMaxSlice)
+		}
+		rng.Limit = n
+	}
+	return rng, nil
+}
+
+// bounds returns the bytes [start, end) the range covers of an output of total bytes.
+func (r jobOutputRange) bounds(total int64) (int64, int64) {
+	start := max(total-r.Limit, 0)
+	if r.HasOffset {
+		start = min(r.Offset, total)
+	}
+	return start, min(start+r.Limit, total)
+}
+
+// jobOutputSlice is the part of a job's output a read returns. Offset and TotalBytes are in
+// bytes; TotalBytes is zero when the size of the full output is unknown. Truncated is set
+// when Output is not all of it.
+type jobOutputSlice struct {
+	Output     string
+	Offset     int64
+	TotalBytes int64
+	Truncated  bool
+}
+
+// utf8Window narrows data[start:end] to whole characters. A start inside a character moves
+// past it and an end inside one moves back before it, unless that leaves no character, when
+// the end moves forward to take the first one whole. data must extend past end when the
+// output does. Invalid UTF-8 is cut where it lies.
+func utf8Window(data []byte, start, end int) (int, int) {
+	for i := 0; i < utf8.UTFMax-1 && start < end && !utf8.RuneStart(data[start]); i++ {
+		start++
+	}
+	if end >= len(data) || utf8.RuneStart(data[end]) {
+		return start, end
+	}
+	cut := end
+	for i := 0; i < utf8.UTFMax-1 && cut > start && !utf8.RuneStart(data[cut]); i++ {
+		cut--
+	}
+	switch {
+	case !utf8.RuneStart(data[cut]):
+		return start, end
+	case cut > start:
+		return start, cut
+	}
+	for i := 0; i < utf8.UTFMax-1 && end < len(data) && !utf8.RuneStart(data[end]); i++ {
+		end++
+	}
+	return start, end
+}
+
+// sliceJobOutput returns the part of an output of total bytes that covers [start, end),
+// narrowed to whole characters. data holds the output from byte base on.
+func sliceJobOutput(data []byte, base, start, end, total int64) jobOutputSlice {
+	s, e := utf8Window(data, int(start-base), int(end-base))
+	return jobOutputSlice{
+		Output:     string(data[s:e]),
+		Offset:     base + int64(s),
+		TotalBytes: total,
+		Truncated:  int64(e-s) < total,
+	}
+}
+
+// jobOutputSliceForRead returns the part of a job's output a read asks for. Offloaded output
+// is read from R2 with a ranged GetObject; when that fails, the start kept on the job is
+// returned instead.
+func (ac *ApiController) jobOutputSliceForRead(ctx context.Context, job Job, rng jobOutputRange, logCtx *log.Entry) jobOutputSlice {
+	if job.OutputR2Key != "" {
+		slice, err := ac.readJobOutputRange(ctx, job.OutputR2Key, rng)
+		if err == nil {
+			return slice
+		}
+		logCtx.WithError(err).Warn("Failed to read job output range; returning its start.")
+		return jobOutputSlice{Output: job.Output, Truncated: true}
+	}
+	data := []byte(job.Output)
+	start, end := rng.bounds(int64(len(data)))
+	slice := sliceJobOutput(data, 0, start, end, int64(len(data)))
+	if job.OutputTruncated {
+		// The upload of the full output failed; only its start is known.
+		slice.TotalBytes, slice.Truncated = 0, true
+	}
+	return slice
+}
+
+// readJobOutputRange reads the part of an offloaded output a read asks for from R2.
+func (ac *ApiController) readJobOutputRange(ctx context.Context, key string, rng jobOutputRange) (jobOutputSlice, error) {
+	httpRange := fmt.Sprintf("bytes=-%d", rng.Limit)
+	if rng.HasOffset {
+		// The few bytes past the end show whether it splits a character.
+		httpRange = fmt.Sprintf("bytes=%d-%d", rng.Offset, rng.Offset+rng.Limit+utf8.UTFMax-2)
+	}
+	data, base, total, err := ac.getJobOutputRange(ctx, key, httpRange)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+		// The offset is past the end; reading the last byte gives the size.
+		if _, _, total, err = ac.getJobOutputRange(ctx, key, "bytes=-1"); err != nil {
+			return jobOutputSlice{}, err
+		}
+		return jobOutputSlice{Offset: total, TotalBytes: total, Truncated: total > 0}, nil
+	}
+	if err != nil {
+		return jobOutputSlice{}, err
+	}
+	start, end := rng.bounds(total)
+	return sliceJobOutput(data, base, start, end, total), nil
+}
+
+// getJobOutputRange reads an HTTP byte range of an object and returns the bytes, the offset
+// of the first of them and the size of the object.
+func (ac *ApiController) getJobOutputRange(ctx context.Context, key, httpRange string) ([]byte, int64, int64, error) {
+	out, err := ac.r2().S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ac.R2BucketName),
+		Key:    aws.String(key),
+		Range:  aws.String(httpRange),
+	})
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to read job output: %w", err)
+	}
+	defer out.Body.Close()
+	var first, last, total int64
+	if _, err := fmt.Sscanf(aws.ToString(out.ContentRange), "bytes %d-%d/%d", &first, &last, &total); err != nil {
+		return nil, 0, 0, fmt.Errorf("unexpected Content-Range %q", aws.ToString(out.ContentRange))
+	}
+	data, err := io.ReadAll(io.LimitReader(out.Body, last-first+1))
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to read job output: %w", err)
+	}
+	return data, first, total, nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateUTF8(t *testing.T) {
+	assert.Equal(t, "short", truncateUTF8("short", 16))
+	assert.Equal(t, "abc", truncateUTF8("abcdef", 3))
+	// "é" is two bytes; cutting through it drops the whole character.
+	assert.Equal(t, "ab", truncateUTF8("abé", 3))
+}
+
+// newJobOutputHarness is the embedded harness with an in-memory object store and a 16-byte
+// inline output limit.
+func newJobOutputHarness(t *testing.T) (*gin.Engine, *ApiController, *fakeObjectAPI) {
+	t.Helper()
+	r, ac, _ := newEmbeddedHarness(t)
+	objects := newFakeObjectAPI()
+	ac.objectStore.Store(&ObjectStore{S3: objects, Presign: &fakePresigner{}, Credentials: r2CredentialsPrimary})
+	ac.AppConfig.JobOutputInlineBytes = 16
+	return r, ac, objects
+}
+
+func TestJobOutput_OffloadedFromStatusReport(t *testing.T) {
+	r, ac, objects := newJobOutputHarness(t)
+	output := strings.Repeat("line of output\n", 10)
+
+	var submitted map[string]string
+	w := serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	jobID := submitted["job_id"]
+	key := jobOutputKey(jobID)
+
+	serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/status", JobStatusReport{Status: jobStatusRunning}, nil)
+	w = serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/status", JobStatusReport{Status: "completed", Output: output}, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, output, string(objects.objects[key]))
+
+	job, err := ac.Jobs.Get(context.Background(), jobID)
+	assert.NoError(t, err)
+	assert.Equal(t, key, job.OutputR2Key)
+	assert.Equal(t, output[:16], job.Output)
+
+	var status JobStatusResponse
+	w = serveJSON(r, http.MethodGet, "/api/jobs/"+jobID, nil, &status)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.False(t, status.OutputTruncated, "the whole output fits the default slice")
+	assert.Equal(t, output, status.Output)
+	assert.Equal(t, int64(len(output)), status.OutputTotalBytes)
+	assert.Equal(t, "https://r2.test/get/"+key, status.OutputURL)
+
+	var shared PublicResultResponse
+	w = serveJSON(r, http.MethodGet, "/api/results/"+submitted["result_token"], nil, &shared)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "https://r2.test/get/"+key, shared.OutputURL)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"), "signed URLs are not cached")
+
+	// A late report neither replaces the stored output nor the job's result.
+	w = serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/status", JobStatusReport{Status: "failed", Output: strings.Repeat("x", 64)}, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, output, string(objects.objects[key]))
+
+	// Expiring the job deletes its output too.
+	_, err = ac.Jobs.Update(context.Background(), jobID, func(job *Job) (bool, error) {
+		job.DeleteAfter = time.Now().Add(-time.Minute)
+		return true, nil
+	})
+	assert.NoError(t, err)
+	result, err := ac.CleanupExpiredJobs(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Deleted)
+	assert.False(t, objects.has(key))
+}
+
+func TestJobOutput_OffloadedOnRead(t *testing.T) {
+	r, ac, objects := newJobOutputHarness(t)
+	output := strings.Repeat("0123456789", 5)
+
+	var submitted map[string]string
+	serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+	jobID := submitted["job_id"]
+	// A worker that writes the job itself stores the whole output inline.
+	_, err := ac.Jobs.Update(context.Background(), jobID, func(job *Job) (bool, error) {
+		job.Status, job.Output, job.CompletedAt = "completed", output, NowISO8601()
+		return true, nil
+	})
+	assert.NoError(t, err)
+
+	var status JobStatusResponse
+	w := serveJSON(r, http.MethodGet, "/api/jobs/"+jobID, nil, &status)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, output, status.Output)
+	assert.Equal(t, "https://r2.test/get/"+jobOutputKey(jobID), status.OutputURL)
+	assert.Equal(t, output, string(objects.objects[jobOutputKey(jobID)]))
+
+	job, _ := ac.Jobs.Get(context.Background(), jobID)
+	assert.Equal(t, jobOutputKey(jobID), job.OutputR2Key)
+}
+
+func TestJobOutput_UploadFailureKeepsStart(t *testing.T) {
+	r, ac, objects := newJobOutputHarness(t)
+	objects.failOn("PutObject", errors.New("r2 unavailable"))
+
+	var submitted map[string]string
+	serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+	jobID := submitted["job_id"]
+
+	w := serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/finished", JobResultReport{Status: "completed", Output: strings.Repeat("a", 40)}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	job, err := ac.Jobs.Get(context.Background(), jobID)
+	assert.NoError(t, err)
+	assert.Equal(t, "completed", job.Status)
+	assert.Equal(t, strings.Repeat("a", 16), job.Output)
+	assert.True(t, job.OutputTruncated)
+	assert.Empty(t, job.OutputR2Key)
+
+	var status JobStatusResponse
+	serveJSON(r, http.MethodGet, "/api/jobs/"+jobID, nil, &status)
+	assert.True(t, status.OutputTruncated)
+	assert.Empty(t, status.OutputURL)
+}
+
+func TestParseJobOutputRange(t *testing.T) {
+	rng, err := parseJobOutputRange("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, jobOutputRange{Limit: jobOutputDefaultSlice}, rng)
+	rng, err = parseJobOutputRange("0", "100")
+	assert.NoError(t, err)
+	assert.Equal(t, jobOutputRange{Offset: 0, HasOffset: true, Limit: 100}, rng)
+	rng, err = parseJobOutputRange("", "1048576")
+	assert.NoError(t, err)
+	assert.Equal(t, jobOutputRange{Limit: jobOutputMaxSlice}, rng)
+
+	for _, bad := range [][2]string{{"-1", ""}, {"x", ""}, {"1.5", ""}, {"", "0"}, {"", "-5"}, {"", "1048577"}, {"", "lots"}} {
+		_, err := parseJobOutputRange(bad[0], bad[1])
+		assert.Error(t, err, "offset %q limit %q", bad[0], bad[1])
+	}
+}
+
+func TestJobOutputRange_Bounds(t *testing.T) {
+	for _, tc := range []struct {
+		rng        jobOutputRange
+		total      int64
+		start, end int64
+	}{
+		{jobOutputRange{Limit: 10}, 100, 90, 100},
+		{jobOutputRange{Limit: 10}, 4, 0, 4},
+		{jobOutputRange{Offset: 20, HasOffset: true, Limit: 10}, 100, 20, 30},
+		{jobOutputRange{Offset: 95, HasOffset: true, Limit: 10}, 100, 95, 100},
+		{jobOutputRange{Offset: 150, HasOffset: true, Limit: 10}, 100, 100, 100},
+		{jobOutputRange{Limit: 10}, 0, 0, 0},
+	} {
+		start, end := tc.rng.bounds(tc.total)
+		assert.Equal(t, [2]int64{tc.start, tc.end}, [2]int64{start, end}, "%+v of %d", tc.rng, tc.total)
+	}
+}
+
+func TestSliceJobOutput_UTF8(t *testing.T) {
+	// "é" is two bytes at 1-2, "😀" four bytes at 4-7.
+	data := []byte("aéb😀c")
+	total := int64(len(data))
+	for _, tc := range []struct {
+		name       string
+		start, end int64
+		want       string
+		offset     int64
+	}{
+		{"whole characters", 0, 3, "aé", 0},
+		{"start inside a character moves past it", 2, 4, "b", 3},
+		{"end inside a character moves back", 0, 2, "a", 0},
+		{"end inside an emoji moves back", 3, 6, "b", 3},
+		{"a window inside one character takes it whole", 4, 5, "😀", 4},
+		{"a window inside one character after its start is empty", 5, 6, "", 6},
+		{"the end of the output", 8, 9, "c", 8},
+	} {
+		slice := sliceJobOutput(data, 0, tc.start, tc.end, total)
+		assert.Equal(t, tc.want, slice.Output, tc.name)
+		assert.Equal(t, tc.offset, slice.Offset, tc.name)
+		assert.Equal(t, total, slice.TotalBytes, tc.name)
+		assert.True(t, slice.Truncated, tc.name)
+	}
+
+	whole := sliceJobOutput(data, 0, 0, total, total)
+	assert.Equal(t, string(data), whole.Output)
+	assert.False(t, whole.Truncated)
+
+	// Bytes that are not UTF-8 are cut where they lie rather than dropped wholesale.
+	invalid := []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80}
+	slice := sliceJobOutput(invalid, 0, 0, 5, int64(len(invalid)))
+	assert.Equal(t, int64(3), slice.Offset)
+	assert.Len(t, slice.Output, 2)
+}
+
+func TestJobOutput_RangedReads(t *testing.T) {
+	r, ac, objects := newJobOutputHarness(t)
+	// 40 two-byte characters: an odd offset or end falls inside one.
+	output := strings.Repeat("é", 40)
+
+	var submitted map[string]string
+	serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+	jobID := submitted["job_id"]
+	w := serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/finished", JobResultReport{Status: "completed", Output: output}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	job, err := ac.Jobs.Get(context.Background(), jobID)
+	assert.NoError(t, err)
+	assert.Equal(t, jobOutputKey(jobID), job.OutputR2Key)
+
+	get := func(query string) JobStatusResponse {
+		t.Helper()
+		var status JobStatusResponse
+		w := serveJSON(r, http.MethodGet, "/api/jobs/"+jobID+query, nil, &status)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return status
+	}
+
+	status := get("?outputOffset=11&outputLimit=10")
+	assert.Equal(t, strings.Repeat("é", 4), status.Output, "the split characters at both ends are left out")
+	assert.Equal(t, int64(12), status.OutputOffset)
+	assert.Equal(t, int64(80), status.OutputTotalBytes)
+	assert.True(t, status.OutputTruncated)
+
+	status = get("?outputLimit=7")
+	assert.Equal(t, strings.Repeat("é", 3), status.Output)
+	assert.Equal(t, int64(74), status.OutputOffset)
+
+	status = get("?outputOffset=70")
+	assert.Equal(t, strings.Repeat("é", 5), status.Output)
+	assert.Equal(t, int64(70), status.OutputOffset)
+
+	status = get("?outputOffset=500")
+	assert.Empty(t, status.Output)
+	assert.Equal(t, int64(80), status.OutputOffset)
+	assert.Equal(t, int64(80), status.OutputTotalBytes)
+
+	// The output was read in ranges, never whole.
+	assert.Equal(t, []string{"bytes=11-23", "bytes=-7", "bytes=70-65608", "bytes=500-66038", "bytes=-1"}, objects.ranges)
+
+	for _, query := range []string{"?outputOffset=-1", "?outputLimit=0", "?outputLimit=2000000", "?outputOffset=abc"} {
+		w := serveJSON(r, http.MethodGet, "/api/jobs/"+jobID+query, nil, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	// When R2 cannot be read the start kept on the job is returned.
+	objects.failOn("GetObject", errors.New("r2 unavailable"))
+	status = get("?outputOffset=40")
+	assert.Equal(t, output[:16], status.Output)
+	assert.True(t, status.OutputTruncated)
+	assert.Zero(t, status.OutputTotalBytes)
+}
+
+func TestJobOutput_InlineSlices(t *testing.T) {
+	r, _, _ := newJobOutputHarness(t)
+
+	var submitted map[string]string
+	serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+	jobID := submitted["job_id"]
+	serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/finished", JobResultReport{Status: "completed", Output: "0123456789"}, nil)
+
+	var status JobStatusResponse
+	w := serveJSON(r, http.MethodGet, "/api/jobs/"+jobID+"?outputOffset=2&outputLimit=3", nil, &status)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "234", status.Output)
+	assert.Equal(t, int64(2), status.OutputOffset)
+	assert.Equal(t, int64(10), status.OutputTotalBytes)
+	assert.True(t, status.OutputTruncated)
+	assert.Empty(t, status.OutputURL)
+
+	status = JobStatusResponse{}
+	serveJSON(r, http.MethodGet, "/api/jobs/"+jobID, nil, &status)
+	assert.Equal(t, "0123456789", status.Output)
+	assert.False(t, status.OutputTruncated)
+}
+
+// The workspace result endpoint returns the output slice under the same fields and JSON
+// names as GET /api/jobs/:jobId.
+func TestWorkspaceJobResult_OutputFieldsMatchJobStatus(t *testing.T) {
+	status, result := reflect.TypeOf(JobStatusResponse{}), reflect.TypeOf(WorkspaceJobResult{})
+	for i := 0; i < status.NumField(); i++ {
+		field := status.Field(i)
+		if !strings.HasPrefix(field.Name, "Output") {
+			continue
+		}
+		other, ok := result.FieldByName(field.Name)
+		if assert.True(t, ok, "WorkspaceJobResult lacks %s", field.Name) {
+			assert.Equal(t, field.Type, other.Type, field.Name)
+			assert.Equal(t, field.Tag.Get("json"), other.Tag.Get("json"), field.Name)
+		}
+	}
+}
+
+func TestHandlers_WorkspaceJobOutputSlices(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+	var submitted ExecuteAuthResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	jobID := submitted.JobID
+	w = h.do(http.MethodPost, "/internal/jobs/"+jobID+"/finished", "", JobResultReport{Status: "completed", Output: "0123456789"}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	var result WorkspaceJobResult
+	w = h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs/"+jobID+"?outputOffset=2&outputLimit=3", owner, nil, &result)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "234", result.Output)
+	assert.Equal(t, int64(2), result.OutputOffset)
+	assert.Equal(t, int64(10), result.OutputTotalBytes)
+	assert.True(t, result.OutputTruncated)
+
+	var status JobStatusResponse
+	w = h.do(http.MethodGet, "/api/jobs/"+jobID+"?outputOffset=2&outputLimit=3", owner, nil, &status)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, status.Output, result.Output)
+	assert.Equal(t, status.OutputOffset, result.OutputOffset)
+	assert.Equal(t, status.OutputTotalBytes, result.OutputTotalBytes)
+	assert.Equal(t, status.OutputTruncated, result.OutputTruncated)
+
+	w = h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs/"+jobID+"?outputOffset=-1", owner, nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	result = WorkspaceJobResult{}
+	w = h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/jobs/"+jobID, owner, nil, &result)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "0123456789", result.Output)
+	assert.False(t, result.OutputTruncated)
+}
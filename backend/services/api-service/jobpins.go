@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+// maxPinnedJobsPerWorkspace caps pinned runs per workspace, since pinned jobs are never
+// reaped by TTL.
+const maxPinnedJobsPerWorkspace = 20
+
+var (
+	errJobNotPinnable = errors.New("only workspace executions can be pinned")
+	errPinLimit       = fmt.Errorf("a workspace can have at most %d pinned jobs", maxPinnedJobsPerWorkspace)
+)
+
+// checkPinnable reports whether job can be pinned or unpinned. Only workspace executions
+// qualify; RAG queries and exports are private to their submitter and short-lived.
+func checkPinnable(job Job) error {
+	if job.ExecutionType != executionTypeWorkspace || job.WorkspaceID == "" {
+		return errJobNotPinnable
+	}
+	return nil
+}
+
+// loadPinnableJob loads a live job and checks that userID may change its pin. It writes the
+// error response itself and returns nil when the request should stop.
+func (ac *ApiController) loadPinnableJob(c *gin.Context, jobRef *docstore.DocumentRef, userID string) *Job {
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"job_id": jobRef.ID, "user_id": userID})
+
+	snap, err := getLiveDocument(ctx, jobRef)
+	if err != nil && !isNotFound(err) {
+		logCtx.WithError(err).Error("Failed to load job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return nil
+	}
+	var job Job
+	if err != nil || snap.DataTo(&job) != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil
+	}
+	if err := checkPinnable(job); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil
+	}
+
+	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, job.WorkspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Workspace membership check failed for job pin.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+		return nil
+	}
+	if !isMember {
+		// Same response as a missing job, as for GET /jobs/:jobId.
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil
+	}
+	return &job
+}
+
+// PinJob exempts a workspace execution from retention so it can serve as a baseline.
+// Pinning an already pinned job succeeds without changes.
+func (ac *ApiController) PinJob(c *gin.Context) {
+	jobID := c.Param("jobId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "PinJob", "job_id": jobID, "user_id": userID})
+
+	jobRef := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Doc(jobID)
+	job := ac.loadPinnableJob(c, jobRef, userID)
+	if job == nil {
+		return
+	}
+
+	pinnedAt := NowISO8601()
+	alreadyPinned := false
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		snap, err := tx.Get(jobRef)
+		if err != nil {
+			return err
+		}
+		var current Job
+		if err := snap.DataTo(&current); err != nil {
+			return fmt.Errorf("failed to parse job: %w", err)
+		}
+		if alreadyPinned = current.Pinned; alreadyPinned {
+			return nil
+		}
+
+		// Reading the pinned set inside the transaction keeps concurrent pins under the cap.
+		pinned, err := tx.Documents(ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).
+			Where("workspace_id", "==", job.WorkspaceID).
+			Where("pinned", "==", true).
+			Limit(maxPinnedJobsPerWorkspace)).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to count pinned jobs: %w", err)
+		}
+		if len(pinned) >= maxPinnedJobsPerWorkspace {
+			return errPinLimit
+		}
+
+		return tx.Update(jobRef, []docstore.Update{
+			{Path: "pinned", Value: true},
+			{Path: "pinned_at", Value: pinnedAt},
+			{Path: "pinned_by", Value: userID},
+			{Path: deleteAfterField, Value: docstore.Delete},
+			{Path: "expires_at", Value: docstore.Delete},
+		})
+	})
+	if errors.Is(err, errPinLimit) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to pin job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to pin job"})
+		return
+	}
+
+	if !alreadyPinned {
+		ac.recordActivity(ctx, job.WorkspaceID, userID, activityJobPinned, map[string]interface{}{"job_id": jobID})
+	}
+	c.JSON(http.StatusOK, gin.H{"jobId": jobID, "pinned": true})
+}
+
+// UnpinJob returns a pinned job to normal retention, counted from now so a long-pinned job
+// is not removed the moment it is unpinned. Unpinning an unpinned job succeeds without changes.
+func (ac *ApiController) UnpinJob(c *gin.Context) {
+	jobID := c.Param("jobId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "UnpinJob", "job_id": jobID, "user_id": userID})
+
+	jobRef := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Doc(jobID)
+	job := ac.loadPinnableJob(c, jobRef, userID)
+	if job == nil {
+		return
+	}
+
+	jobDeleteAfter := deleteAfter(ac.AppConfig.Retention.Jobs)
+	wasPinned := false
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		snap, err := tx.Get(jobRef)
+		if err != nil {
+			return err
+		}
+		var current Job
+		if err := snap.DataTo(&current); err != nil {
+			return fmt.Errorf("failed to parse job: %w", err)
+		}
+		if wasPinned = current.Pinned; !wasPinned {
+			return nil
+		}
+		return tx.Update(jobRef, []docstore.Update{
+			{Path: "pinned", Value: docstore.Delete},
+			{Path: "pinned_at", Value: docstore.Delete},
+			{Path: "pinned_by", Value: docstore.Delete},
+			{Path: deleteAfterField, Value: jobDeleteAfter},
+			{Path: "expires_at", Value: TimeToISO8601(jobDeleteAfter)},
+		})
+	})
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to unpin job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unpin job"})
+		return
+	}
+
+	if wasPinned {
+		ac.recordActivity(ctx, job.WorkspaceID, userID, activityJobUnpinned, map[string]interface{}{"job_id": jobID})
+	}
+	c.JSON(http.StatusOK, gin.H{"jobId": jobID, "pinned": false})
+}
+
+// workspaceJobsCursor marks where a page of GET /workspaces/:workspaceId/jobs ended. It
+// carries the sort key itself, so paging survives the last job on a page being reaped.
+type workspaceJobsCursor struct {
+	SubmittedAt string `json:"s"`
+	JobID       string `json:"j"`
+}
+
+func encodeWorkspaceJobsCursor(cursor workspaceJobsCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeWorkspaceJobsCursor(token string) (workspaceJobsCursor, error) {
+	var cursor workspaceJobsCursor
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(b, &cursor)
+	}
+	if err != nil || cursor.JobID == "" {
+		return workspaceJobsCursor{}, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// workspaceJobStatusFilters maps the status filter of GET /workspaces/:workspaceId/jobs to
+// stored statuses; "running" covers every status a worker sets while it runs a job.
+var workspaceJobStatusFilters = map[string][]string{
+	"queued":           {"queued"},
+	jobStatusScheduled: {jobStatusScheduled},
+	"running":          runningJobStatuses,
+	"completed":        {"completed"},
+	"failed":           {"failed"},
+	jobStatusCancelled: {jobStatusCancelled},
+}
+
+// workspaceJobSummary is the list entry of a workspace execution.
+func workspaceJobSummary(jobID string, job Job) WorkspaceJobSummary {
+	return WorkspaceJobSummary{
+		JobID:          jobID,
+		Status:         job.Status,
+		Language:       job.Language,
+		EntrypointFile: job.EntrypointFile,
+		UserID:         job.UserID,
+		FailureType:    job.FailureType,
+		SubmittedAt:    job.SubmittedAt,
+		StartedAt:      job.StartedAt,
+		CompletedAt:    job.CompletedAt,
+		DurationMs:     job.DurationMs,
+		ExpiresAt:      job.ExpiresAt,
+		ScheduledAt:    job.ScheduledAt,
+		Pinned:         job.Pinned,
+		PinnedAt:       job.PinnedAt,
+		RetryOf:        job.RetryOf,
+		Priority:       job.Priority,
+		Labels:         job.Labels,
+	}
+}
+
+// ListWorkspaceJobs lists a workspace's executions, newest first.
+// Query params: status (queued, running, completed, failed or cancelled), language,
+// pinnedOnly (true returns only pinned runs), label.<key>=<value> (repeatable for different
+// keys), limit (default 25, max 100), cursor.
+// The cursor is the opaque nextCursor value from the previous page.
+// Firestore needs composite indexes on workspace_id/execution_type/submitted_at/__name__,
+// with each combination of pinned, status and language, and one per filtered labels.<key>.
+func (ac *ApiController) ListWorkspaceJobs(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "ListWorkspaceJobs", "workspace_id": workspaceID, "user_id": userID})
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "25"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	pinnedOnly, err := strconv.ParseBool(c.DefaultQuery("pinnedOnly", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pinnedOnly must be true or false"})
+		return
+	}
+	status := c.Query("status")
+	statuses, ok := workspaceJobStatusFilters[status]
+	if status != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of queued, running, completed, failed, cancelled"})
+		return
+	}
+	language := c.Query("language")
+	if _, ok := ac.Services.WorkerFor(language); language != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported language: %s", language)})
+		return
+	}
+	labels, err := parseJobLabelFilters(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var cursor *workspaceJobsCursor
+	if token := c.Query("cursor"); token != "" {
+		decoded, err := decodeWorkspaceJobsCursor(token)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		cursor = &decoded
+	}
+
+	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Workspace membership check failed for ListWorkspaceJobs.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+		return
+	}
+
+	q := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).
+		Where("workspace_id", "==", workspaceID).
+		Where("execution_type", "==", executionTypeWorkspace)
+	if pinnedOnly {
+		q = q.Where("pinned", "==", true)
+	}
+	if len(statuses) == 1 {
+		q = q.Where("status", "==", statuses[0])
+	} else if len(statuses) > 1 {
+		q = q.Where("status", "in", statuses)
+	}
+	if language != "" {
+		q = q.Where("language", "==", language)
+	}
+	for key, value := range labels {
+		q = q.WherePath(docstore.FieldPath{"labels", key}, "==", value)
+	}
+	// Ordering by document ID as well keeps jobs submitted in the same millisecond from
+	// being skipped or repeated across pages.
+	q = q.OrderBy("submitted_at", docstore.Desc).OrderBy(docstore.DocumentID, docstore.Desc)
+	if cursor != nil {
+		q = q.StartAfter(cursor.SubmittedAt, cursor.JobID)
+	}
+
+	iter := q.Limit(limit).Documents(ctx)
+	defer iter.Stop()
+
+	summaries := make([]WorkspaceJobSummary, 0, limit)
+	read := 0
+	var last workspaceJobsCursor
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to query jobs")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load jobs"})
+			return
+		}
+		read++
+		var job Job
+		if err := doc.DataTo(&job); err != nil {
+			logCtx.WithError(err).WithField("job_id", doc.Ref.ID).Warn("Failed to parse job")
+			continue
+		}
+		last = workspaceJobsCursor{SubmittedAt: job.SubmittedAt, JobID: doc.Ref.ID}
+		if isPastDeleteAfter(job.DeleteAfter) {
+			continue
+		}
+		summaries = append(summaries, workspaceJobSummary(doc.Ref.ID, job))
+	}
+
+	// The cursor follows the last document read, so skipped expired jobs do not end paging early.
+	response := gin.H{"jobs": summaries}
+	if read == limit && last.JobID != "" {
+		response["nextCursor"] = encodeWorkspaceJobsCursor(last)
+	}
+	c.JSON(http.StatusOK, response)
+}
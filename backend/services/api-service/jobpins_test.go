@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPinnable(t *testing.T) {
+	assert.NoError(t, checkPinnable(Job{ExecutionType: executionTypeWorkspace, WorkspaceID: "ws-1"}))
+
+	for name, job := range map[string]Job{
+		"public":            {ExecutionType: executionTypePublic},
+		"rag query":         {ExecutionType: executionTypeRagQuery, WorkspaceID: "ws-1"},
+		"data export":       {ExecutionType: executionTypeDataExport, WorkspaceID: "ws-1"},
+		"missing workspace": {ExecutionType: executionTypeWorkspace},
+	} {
+		assert.ErrorIs(t, checkPinnable(job), errJobNotPinnable, name)
+	}
+}
+
+func TestWorkspaceJobsCursor(t *testing.T) {
+	cursor := workspaceJobsCursor{SubmittedAt: "2026-03-01T12:00:00.000Z", JobID: "job-1"}
+	decoded, err := decodeWorkspaceJobsCursor(encodeWorkspaceJobsCursor(cursor))
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, decoded)
+
+	for _, token := range []string{"job-1", "!!", encodeWorkspaceJobsCursor(workspaceJobsCursor{SubmittedAt: "2026-03-01T12:00:00.000Z"})} {
+		_, err := decodeWorkspaceJobsCursor(token)
+		assert.Error(t, err, token)
+	}
+}
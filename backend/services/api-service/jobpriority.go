@@ -0,0 +1,31 @@
+package main
+
+import "fmt"
+
+// Execution priorities. Interactive runs are what a user is waiting on; batch runs go to the
+// worker's batch queue, where one exists, so they cannot hold interactive runs up.
+const (
+	jobPriorityInteractive = "interactive"
+	jobPriorityBatch       = "batch"
+)
+
+// resolveJobPriority validates a requested priority; an empty one is interactive.
+func resolveJobPriority(opts ExecutionOptions) (string, error) {
+	switch opts.Priority {
+	case "", jobPriorityInteractive:
+		return jobPriorityInteractive, nil
+	case jobPriorityBatch:
+		return jobPriorityBatch, nil
+	default:
+		return "", fmt.Errorf("priority must be %q or %q", jobPriorityInteractive, jobPriorityBatch)
+	}
+}
+
+// QueueFor returns the queue ID for jobs of the given priority. Without a batch queue every
+// priority shares the worker's queue.
+func (s ServiceConfig) QueueFor(priority string) string {
+	if priority == jobPriorityBatch && s.BatchQueueID != "" {
+		return s.BatchQueueID
+	}
+	return s.QueueID
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveJobPriority(t *testing.T) {
+	for raw, want := range map[string]string{"": jobPriorityInteractive, "interactive": jobPriorityInteractive, "batch": jobPriorityBatch} {
+		got, err := resolveJobPriority(ExecutionOptions{Priority: raw})
+		assert.NoError(t, err, raw)
+		assert.Equal(t, want, got, raw)
+	}
+	_, err := resolveJobPriority(ExecutionOptions{Priority: "urgent"})
+	assert.Error(t, err)
+}
+
+func TestServiceConfig_QueueFor(t *testing.T) {
+	worker := ServiceConfig{QueueID: "python-queue"}
+	assert.Equal(t, "python-queue", worker.QueueFor(jobPriorityBatch), "batch falls back to the only queue")
+
+	worker.BatchQueueID = "python-batch-queue"
+	assert.Equal(t, "python-queue", worker.QueueFor(jobPriorityInteractive))
+	assert.Equal(t, "python-queue", worker.QueueFor(""), "jobs from before priorities are interactive")
+	assert.Equal(t, "python-batch-queue", worker.QueueFor(jobPriorityBatch))
+}
+
+func TestExecuteCode_PriorityRouting(t *testing.T) {
+	r, ac, tasks := newEmbeddedHarness(t)
+	ac.Services.PythonWorker.BatchQueueID = "python-batch-queue"
+
+	var interactive, batch map[string]string
+	w := serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &interactive)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python", ExecutionOptions: ExecutionOptions{Priority: "batch"}}, &batch)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python", ExecutionOptions: ExecutionOptions{Priority: "urgent"}}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	if queues := tasks.queues(); assert.Len(t, queues, 2) {
+		assert.True(t, strings.HasSuffix(queues[0], "/queues/python-queue"), queues[0])
+		assert.True(t, strings.HasSuffix(queues[1], "/queues/python-batch-queue"), queues[1])
+	}
+	job, err := ac.Jobs.Get(context.Background(), interactive["job_id"])
+	assert.NoError(t, err)
+	assert.Equal(t, jobPriorityInteractive, job.Priority)
+	job, err = ac.Jobs.Get(context.Background(), batch["job_id"])
+	assert.NoError(t, err)
+	assert.Equal(t, jobPriorityBatch, job.Priority)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// jobQuotasCollection holds the shards of daily execution counters. Each document is for
+	// one caller and UTC day and carries delete_after for a TTL policy; the
+	// "job-quota-expiry" maintenance task removes them where none is configured.
+	jobQuotasCollection  = "job_quotas"
+	jobQuotaExpiryBatch  = 300
+	jobQuotaDayKeyFormat = "20060102"
+)
+
+// errQuotaExceeded is returned when creating a job would take its caller past their quota.
+var errQuotaExceeded = errors.New("daily execution quota exceeded")
+
+// JobQuota is a caller's daily execution allowance, charged when a job is created. The
+// count is split over Shards documents, each allowed an equal part of Limit, so concurrent
+// submissions from one caller rarely write the same document.
+type JobQuota struct {
+	Key     string // Hashed caller, safe in document IDs
+	Day     string // UTC day, YYYYMMDD
+	Limit   int
+	Shards  int
+	ResetAt time.Time // Start of the next UTC day
+}
+
+// jobQuotaShard is one shard document of a caller's daily count.
+type jobQuotaShard struct {
+	Key         string    `firestore:"key"`
+	Day         string    `firestore:"day"`
+	Count       int       `firestore:"count"`
+	DeleteAfter time.Time `firestore:"delete_after"`
+}
+
+// newJobQuota returns the quota of subject for the UTC day containing now.
+func newJobQuota(subject string, limit, shards int, now time.Time) JobQuota {
+	day := now.UTC().Truncate(24 * time.Hour)
+	return JobQuota{
+		Key:     rateLimitKeyHash(subject),
+		Day:     day.Format(jobQuotaDayKeyFormat),
+		Limit:   limit,
+		Shards:  shards,
+		ResetAt: day.Add(24 * time.Hour),
+	}
+}
+
+// shardCount caps the shard count at the limit, so no shard is allowed nothing.
+func (q JobQuota) shardCount() int {
+	return max(1, min(q.Shards, q.Limit))
+}
+
+// shardCapacity is how much of the limit a shard holds. The capacities sum to Limit, so
+// the quota is exact however the count is spread.
+func (q JobQuota) shardCapacity(shard int) int {
+	shards := q.shardCount()
+	capacity := q.Limit / shards
+	if shard < q.Limit%shards {
+		capacity++
+	}
+	return capacity
+}
+
+func (q JobQuota) shardID(shard int) string {
+	return fmt.Sprintf("%s_%s_%d", q.Key, q.Day, shard)
+}
+
+// chargeJobQuota reserves n executions of quota in tx, filling shards from a random one, or
+// returns errQuotaExceeded without writing. It reads before it writes, so callers must have
+// done their own reads and may only write afterwards.
+func (s *firestoreJobStore) chargeJobQuota(tx *docstore.Transaction, quota JobQuota, n int) error {
+	type grant struct {
+		ref   *docstore.DocumentRef
+		count int
+	}
+	var grants []grant
+	shards := quota.shardCount()
+	first := rand.IntN(shards)
+	for i := 0; i < shards && n > 0; i++ {
+		shard := (first + i) % shards
+		ref := s.fs.Collection(jobQuotasCollection).Doc(quota.shardID(shard))
+		count := 0
+		snap, err := tx.Get(ref)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if err == nil {
+			var existing jobQuotaShard
+			if err := snap.DataTo(&existing); err != nil {
+				return fmt.Errorf("failed to parse quota shard: %w", err)
+			}
+			count = existing.Count
+		}
+		if room := quota.shardCapacity(shard) - count; room > 0 {
+			add := min(room, n)
+			grants = append(grants, grant{ref, count + add})
+			n -= add
+		}
+	}
+	if n > 0 {
+		return errQuotaExceeded
+	}
+	for _, g := range grants {
+		if err := tx.Set(g.ref, jobQuotaShard{Key: quota.Key, Day: quota.Day, Count: g.count, DeleteAfter: quota.ResetAt}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *firestoreJobStore) CreateWithQuota(ctx context.Context, quota JobQuota, jobID string, job Job) error {
+	return s.fs.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		if err := s.chargeJobQuota(tx, quota, 1); err != nil {
+			return err
+		}
+		return tx.Set(s.fs.Collection(s.collection).Doc(jobID), job)
+	})
+}
+
+func (s *firestoreJobStore) ChargeQuota(ctx context.Context, quota JobQuota, n int) error {
+	return s.fs.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		return s.chargeJobQuota(tx, quota, n)
+	})
+}
+
+// anonymousQuotaFor returns the daily quota of an anonymous caller, keyed by client IP, or
+// nil for signed-in callers and when the quota is off.
+func (ac *ApiController) anonymousQuotaFor(c *gin.Context, now time.Time) *JobQuota {
+	if ac.AppConfig.AnonymousDailyQuota <= 0 || c.GetString("userID") != "" {
+		return nil
+	}
+	quota := newJobQuota("ip:"+RealClientIP(c), ac.AppConfig.AnonymousDailyQuota, ac.AppConfig.AnonymousQuotaShards, now)
+	return &quota
+}
+
+// rejectOverQuota writes the 429 for a caller past their quota.
+func rejectOverQuota(c *gin.Context, quota *JobQuota) {
+	log.WithFields(log.Fields{"client_ip": RealClientIP(c), "quota_limit": quota.Limit}).Info("Execution refused: daily anonymous quota exceeded.")
+	c.Header("Retry-After", strconv.Itoa(int(time.Until(quota.ResetAt).Seconds())+1))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":        "Daily execution quota exceeded; sign in or try again after quotaResetAt",
+		"quotaLimit":   quota.Limit,
+		"quotaResetAt": TimeToISO8601(quota.ResetAt),
+	})
+}
+
+// runJobQuotaExpiryMaintenance is the "job-quota-expiry" maintenance task: it removes one
+// batch of quota shards from past days.
+func (ac *ApiController) runJobQuotaExpiryMaintenance(ctx context.Context) (interface{}, error) {
+	return ac.deleteExpiredRecords(ctx, jobQuotasCollection, jobQuotaExpiryBatch)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// executeFrom submits a public execution from a client address.
+func executeFrom(r *gin.Engine, remoteAddr string, out interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RequestBody{Code: "print(1)", Language: "python"})
+	req := httptest.NewRequest(http.MethodPost, "/api/execute", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remoteAddr
+	w := serveRequest(r, req)
+	if out != nil {
+		json.Unmarshal(w.Body.Bytes(), out)
+	}
+	return w
+}
+
+func TestNewJobQuota_DayRollover(t *testing.T) {
+	before := time.Date(2024, 3, 9, 23, 59, 59, 0, time.UTC)
+	after := before.Add(time.Second)
+
+	q1 := newJobQuota("ip:198.51.100.7", 5, 4, before)
+	q2 := newJobQuota("ip:198.51.100.7", 5, 4, after)
+	assert.Equal(t, "20240309", q1.Day)
+	assert.Equal(t, "20240310", q2.Day)
+	assert.Equal(t, after, q1.ResetAt)
+	assert.Equal(t, after.Add(24*time.Hour), q2.ResetAt)
+	assert.Equal(t, q1.Key, q2.Key)
+	assert.NotEqual(t, q1.shardID(0), q2.shardID(0), "each day counts on its own documents")
+
+	// Days are UTC whatever the caller's zone.
+	east := time.FixedZone("UTC+9", 9*60*60)
+	assert.Equal(t, "20240309", newJobQuota("ip:198.51.100.7", 5, 4, time.Date(2024, 3, 10, 8, 0, 0, 0, east)).Day)
+}
+
+func TestJobQuota_ShardCapacities(t *testing.T) {
+	q := JobQuota{Limit: 10, Shards: 4}
+	assert.Equal(t, []int{3, 3, 2, 2}, []int{q.shardCapacity(0), q.shardCapacity(1), q.shardCapacity(2), q.shardCapacity(3)})
+
+	small := JobQuota{Limit: 2, Shards: 4}
+	assert.Equal(t, 2, small.shardCount(), "no shard is allowed nothing")
+}
+
+func TestExecuteCode_AnonymousQuota(t *testing.T) {
+	r, ac, tasks := newEmbeddedHarness(t)
+	ac.AppConfig.AnonymousDailyQuota = 2
+	ac.AppConfig.AnonymousQuotaShards = 4
+
+	var first, replayed map[string]interface{}
+	w := executeWithKey(r, "retry-1", &first)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = executeWithKey(r, "retry-1", &replayed)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, true, replayed["replayed"], "a replay is not charged")
+
+	w = executeFrom(r, "192.0.2.1:1234", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var refused map[string]interface{}
+	w = executeFrom(r, "192.0.2.1:1234", &refused)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, float64(2), refused["quotaLimit"])
+	assert.Equal(t, TimeToISO8601(time.Now().UTC().Truncate(24*time.Hour).Add(24*time.Hour)), refused["quotaResetAt"])
+
+	batch := ExecuteBatchRequest{Items: []json.RawMessage{json.RawMessage(`{"code":"print(1)","language":"python"}`)}}
+	w = serveJSON(r, http.MethodPost, "/api/execute/batch", batch, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	assert.Len(t, tasks.tasksTo("/execute"), 2, "refused executions queue no task")
+
+	w = executeFrom(r, "198.51.100.7:1234", nil)
+	assert.Equal(t, http.StatusOK, w.Code, "each client IP has its own quota")
+}
+
+func TestAnonymousQuotaFor_ExemptsSignedInCallers(t *testing.T) {
+	_, ac, _ := newEmbeddedHarness(t)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/execute", nil)
+
+	assert.Nil(t, ac.anonymousQuotaFor(c, time.Now()), "the quota is off by default")
+
+	ac.AppConfig.AnonymousDailyQuota = 5
+	assert.NotNil(t, ac.anonymousQuotaFor(c, time.Now()))
+	c.Set("userID", "user-1")
+	assert.Nil(t, ac.anonymousQuotaFor(c, time.Now()))
+}
+
+func TestEmbeddedJobStore_QuotaDayRollover(t *testing.T) {
+	_, ac, _ := newEmbeddedHarness(t)
+	ctx := context.Background()
+	day1 := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	quota := newJobQuota("ip:192.0.2.1", 1, 4, day1)
+
+	assert.NoError(t, ac.Jobs.CreateWithQuota(ctx, quota, "job-1", Job{Status: "queued"}))
+	assert.ErrorIs(t, ac.Jobs.CreateWithQuota(ctx, quota, "job-2", Job{Status: "queued"}), errQuotaExceeded)
+	_, err := ac.Jobs.Get(ctx, "job-2")
+	assert.ErrorIs(t, err, errJobNotFound, "a refused job is not stored")
+	assert.ErrorIs(t, ac.Jobs.ChargeQuota(ctx, quota, 1), errQuotaExceeded)
+
+	nextDay := newJobQuota("ip:192.0.2.1", 1, 4, day1.Add(24*time.Hour))
+	assert.NoError(t, ac.Jobs.CreateWithQuota(ctx, nextDay, "job-3", Job{Status: "queued"}))
+
+	// A sweep past the reset forgets the old day's count.
+	_, err = ac.Jobs.DeleteExpired(ctx, quota.ResetAt, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, ac.Jobs.ChargeQuota(ctx, quota, 1))
+}
+
+func TestFirestoreJobStore_QuotaShards(t *testing.T) {
+	h := newHandlerHarness(t)
+	ctx := context.Background()
+	store := newFirestoreJobStore(h.ac.FirestoreClient, h.ac.FirestoreJobsCollection)
+	quota := newJobQuota("ip:"+uuid.NewString(), 10, 4, time.Now())
+
+	shardCounts := func() []int {
+		counts := make([]int, quota.shardCount())
+		for i := range counts {
+			snap, err := h.ac.FirestoreClient.Collection(jobQuotasCollection).Doc(quota.shardID(i)).Get(ctx)
+			if isNotFound(err) {
+				continue
+			}
+			assert.NoError(t, err)
+			var shard jobQuotaShard
+			assert.NoError(t, snap.DataTo(&shard))
+			counts[i] = shard.Count
+		}
+		return counts
+	}
+
+	assert.NoError(t, store.ChargeQuota(ctx, quota, 4), "a charge spans shards when one is too small")
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, store.CreateWithQuota(ctx, quota, uuid.NewString(), Job{Status: "queued"}))
+	}
+	assert.Equal(t, []int{3, 3, 2, 2}, shardCounts(), "the shards sum to the limit")
+
+	jobID := uuid.NewString()
+	assert.ErrorIs(t, store.CreateWithQuota(ctx, quota, jobID, Job{Status: "queued"}), errQuotaExceeded)
+	_, err := store.Get(ctx, jobID)
+	assert.ErrorIs(t, err, errJobNotFound, "a refused job is not stored")
+
+	// A charge that does not fit writes nothing.
+	fresh := newJobQuota("ip:"+uuid.NewString(), 5, 4, time.Now())
+	assert.NoError(t, store.ChargeQuota(ctx, fresh, 3))
+	assert.ErrorIs(t, store.ChargeQuota(ctx, fresh, 3), errQuotaExceeded)
+	assert.NoError(t, store.ChargeQuota(ctx, fresh, 2))
+
+	// Idempotent replays are not charged.
+	keyed := newJobQuota("ip:"+uuid.NewString(), 1, 4, time.Now())
+	key := IdempotencyKey{ID: uuid.NewString(), DeleteAfter: time.Now().Add(time.Hour)}
+	first, replayed, err := store.CreateIdempotent(ctx, key, &keyed, uuid.NewString(), Job{Status: "queued"})
+	assert.NoError(t, err)
+	assert.False(t, replayed)
+	again, replayed, err := store.CreateIdempotent(ctx, key, &keyed, uuid.NewString(), Job{Status: "queued"})
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, first.ID, again.ID)
+}
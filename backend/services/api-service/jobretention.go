@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// newJob starts a queued job of the given execution type, submitted now. ExpiresAt and the
+// delete_after marker the sweeper deletes by name the same instant, retention from now.
+func newJob(executionType string, retention time.Duration) Job {
+	expiry := deleteAfter(retention)
+	return Job{
+		Status:        "queued",
+		SubmittedAt:   NowISO8601(),
+		ExpiresAt:     TimeToISO8601(expiry),
+		DeleteAfter:   expiry,
+		ExecutionType: executionType,
+	}
+}
+
+// backfilledJobExpiry returns the expiry a job created before every creation path set one
+// should have, and whether it lacks expires_at or delete_after at all. An expiry the job has
+// in either field is kept; otherwise it expires the job retention after it was submitted.
+// Pinned jobs are never given one.
+func backfilledJobExpiry(job Job, retention time.Duration) (time.Time, bool) {
+	if job.Pinned || (job.ExpiresAt != "" && !job.DeleteAfter.IsZero()) {
+		return time.Time{}, false
+	}
+	if !job.DeleteAfter.IsZero() {
+		return job.DeleteAfter, true
+	}
+	if expiresAt, err := time.Parse(time.RFC3339, job.ExpiresAt); err == nil {
+		return expiresAt.UTC().Truncate(time.Millisecond), true
+	}
+	submittedAt, err := time.Parse(time.RFC3339, job.SubmittedAt)
+	if err != nil {
+		return deleteAfter(retention), true
+	}
+	return submittedAt.UTC().Add(retention).Truncate(time.Millisecond), true
+}
+
+// BackfillJobExpiry stamps expires_at and delete_after on one page of jobs created without
+// them, so the expired-job sweeper eventually deletes them. Jobs already past their backfilled
+// expiry go in the next sweep. Pages are in document ID order; repeat the call with the
+// returned nextCursor until none is returned.
+func (ac *ApiController) BackfillJobExpiry(c *gin.Context) {
+	adminID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "BackfillJobExpiry", "admin_id": adminID})
+
+	var req BackfillJobExpiryRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = 200
+	}
+	if limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	q := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).OrderBy(docstore.DocumentID, docstore.Asc)
+	if req.Cursor != "" {
+		q = q.StartAfter(req.Cursor)
+	}
+	docs, err := q.Limit(limit).Documents(ctx).GetAll()
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to query jobs")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load jobs"})
+		return
+	}
+
+	result := BackfillJobExpiryResult{Scanned: len(docs)}
+	for _, doc := range docs {
+		var job Job
+		if err := doc.DataTo(&job); err != nil {
+			logCtx.WithError(err).WithField("job_id", doc.Ref.ID).Warn("Failed to parse job")
+			result.Failed++
+			continue
+		}
+		expiry, missing := backfilledJobExpiry(job, ac.AppConfig.Retention.Jobs)
+		if !missing {
+			continue
+		}
+		// A job changed since it was read, such as one pinned meanwhile, is left as it is.
+		if _, err := doc.Ref.Update(ctx, []docstore.Update{
+			{Path: "expires_at", Value: TimeToISO8601(expiry)},
+			{Path: deleteAfterField, Value: expiry},
+		}, docstore.LastUpdateTime(doc.UpdateTime)); err != nil {
+			logCtx.WithError(err).WithField("job_id", doc.Ref.ID).Info("Job expiry not backfilled; it may have changed meanwhile.")
+			result.Failed++
+			continue
+		}
+		result.Stamped++
+	}
+	if len(docs) == limit {
+		result.NextCursor = docs[len(docs)-1].Ref.ID
+	}
+
+	logCtx.WithFields(log.Fields{"scanned": result.Scanned, "stamped": result.Stamped, "failed": result.Failed}).Warn("Job expiry backfilled by operator.")
+	ac.recordAudit(ctx, adminID, auditJobExpiryBackfilled, "jobs", "", map[string]interface{}{
+		"cursor":  req.Cursor,
+		"limit":   limit,
+		"scanned": result.Scanned,
+		"stamped": result.Stamped,
+		"failed":  result.Failed,
+	})
+	c.JSON(http.StatusOK, result)
+}
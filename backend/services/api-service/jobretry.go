@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryWorkspaceJob re-runs a failed workspace job as a new job against the workspace's
+// current files. The new job keeps the original's language, input, entrypoint, limits and
+// priority and records the original in retry_of; the original job is left as it was.
+func (ac *ApiController) RetryWorkspaceJob(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	originalID := c.Param("jobId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "RetryWorkspaceJob", "workspace_id": workspaceID, "retry_of": originalID, "user_id": userID})
+
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleEditor, logCtx) == nil {
+		return
+	}
+
+	original, err := ac.Jobs.Get(ctx, originalID)
+	if errors.Is(err, errJobNotFound) || (err == nil && (original.WorkspaceID != workspaceID || isPastDeleteAfter(original.DeleteAfter))) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+	if original.Status != "failed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only failed jobs can be retried", "status": original.Status})
+		return
+	}
+	if original.ExecutionType != executionTypeWorkspace || original.Replay == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "This job was submitted before retries were supported and cannot be retried"})
+		return
+	}
+	worker, ok := ac.Services.WorkerFor(original.Language)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Unsupported language: " + original.Language})
+		return
+	}
+
+	workspaceData, workerFiles, err := ac.readExecutionSnapshot(ctx, workspaceID)
+	if isNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to build execution manifest.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve workspace files for execution."})
+		return
+	}
+	var inputFile *WorkerFile
+	if original.InputFilePath != "" {
+		if inputFile = findWorkerFile(workerFiles, original.InputFilePath); inputFile == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Input file %s no longer exists in the workspace", original.InputFilePath)})
+			return
+		}
+	}
+	dependencyManifests := detectDependencyManifests(workerFiles, worker.DependencyManifests)
+
+	jobID := uuid.New().String()
+	logCtx = logCtx.WithField("job_id", jobID)
+
+	notificationRequested := original.NotificationRequested && ac.jobNotificationsEnabled()
+	body, manifestMode, err := ac.marshalAuthTaskPayload(ctx, CloudTaskAuthPayload{
+		WorkspaceID:         workspaceID,
+		EntrypointFile:      original.EntrypointFile,
+		Language:            original.Language,
+		Input:               original.Input,
+		InputFile:           inputFile,
+		R2BucketName:        ac.R2BucketName,
+		JobID:               jobID,
+		Files:               workerFiles,
+		TimeoutSeconds:      original.Replay.TimeoutSeconds,
+		MemoryMb:            original.Replay.MemoryMb,
+		NotifyCompletion:    original.CallbackURL != "" || notificationRequested,
+		DependencyManifests: dependencyManifests,
+	})
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to prepare task payload for retry")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare job for execution"})
+		return
+	}
+
+	// The original deadline is not carried over: it has usually passed by the time a
+	// failure is retried.
+	job := newJob(original.ExecutionType, ac.AppConfig.Retention.Jobs)
+	job.Language, job.Input, job.UserID, job.WorkspaceID = original.Language, original.Input, userID, workspaceID
+	job.EntrypointFile, job.InputFilePath = original.EntrypointFile, inputFilePath(inputFile)
+	job.ManifestMode, job.DependencyManifests = manifestMode, dependencyManifestPaths(dependencyManifests)
+	job.CallbackURL, job.CallbackSecret = original.CallbackURL, original.CallbackSecret
+	job.RetryOf, job.Priority, job.Replay = originalID, original.Priority, original.Replay
+	job.Labels, job.NotificationRequested = original.Labels, notificationRequested
+	if err := ac.Jobs.Create(ctx, jobID, job); err != nil {
+		logCtx.WithError(err).Error("Failed to create retry job in Firestore")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job record"})
+		return
+	}
+
+	task, err := ac.enqueueTask(ctx, ac.AppConfig.GetQueuePath(worker.QueueFor(original.Priority)), fmt.Sprintf("%s/execute_auth", worker.ServiceURL), worker.ServiceAccount, json.RawMessage(body), enqueueOptions{JobID: jobID})
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to create Cloud Task for job retry")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit job for execution"})
+		return
+	}
+	ac.recordJobTask(ctx, jobID, task.GetName())
+	logCtx.WithFields(log.Fields{
+		"task_name":               task.GetName(),
+		"final_workspace_version": workspaceData.WorkspaceVersion,
+	}).Info("Failed job retried.")
+
+	c.JSON(http.StatusOK, gin.H{
+		"job_id":                jobID,
+		"retry_of":              originalID,
+		"finalWorkspaceVersion": workspaceData.WorkspaceVersion,
+	})
+}
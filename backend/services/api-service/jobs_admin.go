@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+// nonTerminalJobStatuses are the statuses a job passes through before a worker reports a result.
+// Listing without a status filter returns jobs in any of these.
+var nonTerminalJobStatuses = []string{
+	"queued",
+	jobStatusScheduled,
+	"processing_direct",
+	"processing_auth_workspace",
+	"fetching_from_r2",
+	"running_auth_workspace",
+}
+
+// errJobAlreadyTerminal is returned when forcing a job that already completed or failed.
+var errJobAlreadyTerminal = errors.New("job already in a terminal state")
+
+// isTerminalJobStatus reports whether a job has finished.
+func isTerminalJobStatus(status string) bool {
+	return status == "completed" || status == "failed" || status == jobStatusCancelled
+}
+
+// adminJobsQuery builds the shared job filter used by listing and requeue. Without a status
+// it matches every non-terminal job. A positive olderThan keeps jobs submitted before now-olderThan.
+// Results are ordered oldest first; Firestore needs composite indexes on status/language/submitted_at.
+func (ac *ApiController) adminJobsQuery(status, language string, olderThan time.Duration) docstore.Query {
+	q := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Query
+	if status != "" {
+		q = q.Where("status", "==", status)
+	} else {
+		q = q.Where("status", "in", nonTerminalJobStatuses)
+	}
+	if language != "" {
+		q = q.Where("language", "==", language)
+	}
+	if olderThan > 0 {
+		q = q.Where("submitted_at", "<", TimeToISO8601(time.Now().Add(-olderThan)))
+	}
+	return q.OrderBy("submitted_at", docstore.Asc)
+}
+
+// parseOlderThan parses a positive Go duration such as "30m"; empty means no age filter.
+func parseOlderThan(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("olderThan must be a positive duration such as \"30m\"")
+	}
+	return d, nil
+}
+
+// ListAdminJobs lists jobs across all users, oldest first.
+// Query params: status, olderThan (e.g. 30m), language, limit (default 50, max 200), cursor.
+// The cursor is the nextCursor value from the previous page.
+func (ac *ApiController) ListAdminJobs(c *gin.Context) {
+	ctx := c.Request.Context()
+	logCtx := log.WithField("handler", "ListAdminJobs")
+
+	olderThan, err := parseOlderThan(c.Query("olderThan"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+
+	q := ac.adminJobsQuery(c.Query("status"), c.Query("language"), olderThan)
+	if cursor := c.Query("cursor"); cursor != "" {
+		cursorSnap, err := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Doc(cursor).Get(ctx)
+		if err != nil {
+			if isNotFound(err) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+				return
+			}
+			logCtx.WithError(err).Error("Failed to load cursor job")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load jobs"})
+			return
+		}
+		q = q.StartAfter(cursorSnap)
+	}
+
+	iter := q.Limit(limit).Documents(ctx)
+	defer iter.Stop()
+
+	jobs := make([]AdminJob, 0, limit)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to query jobs")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load jobs"})
+			return
+		}
+		var job Job
+		if err := doc.DataTo(&job); err != nil {
+			logCtx.WithError(err).WithField("job_id", doc.Ref.ID).Warn("Failed to parse job")
+			continue
+		}
+		jobs = append(jobs, AdminJob{JobID: doc.Ref.ID, Job: job})
+	}
+
+	response := gin.H{"jobs": jobs}
+	if len(jobs) == limit {
+		response["nextCursor"] = jobs[len(jobs)-1].JobID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ForceFailJob moves a stuck job to "failed" with an operator note.
+func (ac *ApiController) ForceFailJob(c *gin.Context) {
+	jobID := c.Param("id")
+	adminID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "ForceFailJob", "job_id": jobID, "admin_id": adminID})
+
+	var req FailJobRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+
+	var previousStatus string
+	jobRef := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Doc(jobID)
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		snap, err := tx.Get(jobRef)
+		if err != nil {
+			return err
+		}
+		var job Job
+		if err := snap.DataTo(&job); err != nil {
+			return fmt.Errorf("failed to parse job: %w", err)
+		}
+		if isTerminalJobStatus(job.Status) {
+			return errJobAlreadyTerminal
+		}
+		previousStatus = job.Status
+
+		now := NowISO8601()
+		return tx.Update(jobRef, []docstore.Update{
+			{Path: "status", Value: "failed"},
+			{Path: "error", Value: "Terminated by operator: " + req.Note},
+			{Path: "failure_type", Value: "operator_terminated"},
+			{Path: "operator_note", Value: req.Note},
+			{Path: "completed_at", Value: now},
+			{Path: "updated_at", Value: now},
+		})
+	})
+	switch {
+	case isNotFound(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	case errors.Is(err, errJobAlreadyTerminal):
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has already finished"})
+		return
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to force-fail job")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update job"})
+		return
+	}
+
+	logCtx.WithField("previous_status", previousStatus).Warn("Job force-failed by operator.")
+	ac.recordAudit(ctx, adminID, auditJobForceFailed, "job", jobID, map[string]interface{}{
+		"note":            req.Note,
+		"previous_status": previousStatus,
+	})
+	c.JSON(http.StatusOK, gin.H{"jobId": jobID, "status": "failed", "previousStatus": previousStatus})
+}
+
+// RequeueJobs recreates the Cloud Tasks of queued jobs whose task was lost. Only jobs still
+// in "queued" are considered, and olderThan is required so in-flight tasks are not doubled.
+// Jobs whose deadline has passed are expired instead of requeued.
+func (ac *ApiController) RequeueJobs(c *gin.Context) {
+	adminID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "RequeueJobs", "admin_id": adminID})
+
+	var req RequeueJobsRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	olderThan, err := parseOlderThan(req.OlderThan)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = 50
+	}
+	if limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+
+	docs, err := ac.adminJobsQuery("queued", req.Language, olderThan).Limit(limit).Documents(ctx).GetAll()
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to query queued jobs")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load jobs"})
+		return
+	}
+
+	results := make([]RequeueJobResult, 0, len(docs))
+	requeued := 0
+	for _, doc := range docs {
+		var job Job
+		if err := doc.DataTo(&job); err != nil {
+			results = append(results, RequeueJobResult{JobID: doc.Ref.ID, Status: "failed", Message: "Failed to parse job"})
+			continue
+		}
+		result := ac.requeueJob(ctx, doc.Ref, job)
+		if result.Status == "requeued" {
+			requeued++
+		}
+		results = append(results, result)
+	}
+
+	logCtx.WithFields(log.Fields{"matched": len(docs), "requeued": requeued}).Warn("Jobs requeued by operator.")
+	ac.recordAudit(ctx, adminID, auditJobsRequeued, "jobs", "", map[string]interface{}{
+		"older_than": req.OlderThan,
+		"language":   req.Language,
+		"limit":      limit,
+		"matched":    len(docs),
+		"requeued":   requeued,
+	})
+	c.JSON(http.StatusOK, gin.H{"matched": len(docs), "requeued": requeued, "results": results})
+}
+
+// requeueJob re-enqueues one job's Cloud Task from its stored replay data. Authenticated jobs
+// get a fresh manifest, so they run against the workspace's current files.
+func (ac *ApiController) requeueJob(ctx context.Context, jobRef *docstore.DocumentRef, job Job) RequeueJobResult {
+	result := RequeueJobResult{JobID: jobRef.ID}
+	logCtx := log.WithField("job_id", jobRef.ID)
+
+	if jobDeadlineMissed(job, time.Now()) {
+		if _, ok, err := ac.expireQueuedJob(ctx, jobRef.ID); err != nil {
+			logCtx.WithError(err).Error("Failed to expire job past its deadline")
+			result.Status, result.Message = "failed", "Failed to expire job past its deadline"
+		} else if ok {
+			result.Status, result.Message = "expired", "The job's deadline has passed"
+		} else {
+			result.Status, result.Message = "skipped", "Job is no longer queued"
+		}
+		return result
+	}
+	if job.Replay == nil {
+		result.Status, result.Message = "skipped", "No replay data stored for this job"
+		return result
+	}
+	worker, ok := ac.Services.WorkerFor(job.Language)
+	if !ok {
+		result.Status, result.Message = "skipped", "Unsupported language: "+job.Language
+		return result
+	}
+
+	var payload interface{}
+	var endpoint, manifestMode string
+	switch job.ExecutionType {
+	case executionTypePublic:
+		endpoint = "execute"
+		payload = CloudTaskPayload{
+			JobID:            jobRef.ID,
+			Code:             job.Code,
+			Language:         job.Language,
+			Input:            job.Input,
+			TimeoutSeconds:   job.Replay.TimeoutSeconds,
+			MemoryMb:         job.Replay.MemoryMb,
+			NotifyCompletion: job.CallbackURL != "" || job.NotificationRequested,
+			Deadline:         job.Deadline,
+		}
+	case executionTypeWorkspace:
+		workerFiles, err := ac.workspaceWorkerFiles(ctx, job.WorkspaceID)
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to rebuild manifest for requeue")
+			result.Status, result.Message = "failed", "Failed to rebuild workspace manifest"
+			return result
+		}
+		var inputFile *WorkerFile
+		if job.InputFilePath != "" {
+			if inputFile = findWorkerFile(workerFiles, job.InputFilePath); inputFile == nil {
+				result.Status, result.Message = "failed", "Input file no longer exists: "+job.InputFilePath
+				return result
+			}
+		}
+		endpoint = "execute_auth"
+		body, mode, err := ac.marshalAuthTaskPayload(ctx, CloudTaskAuthPayload{
+			JobID:               jobRef.ID,
+			WorkspaceID:         job.WorkspaceID,
+			EntrypointFile:      job.EntrypointFile,
+			Language:            job.Language,
+			Input:               job.Input,
+			InputFile:           inputFile,
+			R2BucketName:        ac.R2BucketName,
+			Files:               workerFiles,
+			TimeoutSeconds:      job.Replay.TimeoutSeconds,
+			MemoryMb:            job.Replay.MemoryMb,
+			NotifyCompletion:    job.CallbackURL != "" || job.NotificationRequested,
+			Deadline:            job.Deadline,
+			DependencyManifests: detectDependencyManifests(workerFiles, worker.DependencyManifests),
+		})
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to prepare task payload for requeue")
+			result.Status, result.Message = "failed", "Failed to prepare task payload"
+			return result
+		}
+		payload, manifestMode = json.RawMessage(body), mode
+	default:
+		result.Status, result.Message = "skipped", "Execution type cannot be requeued: "+job.ExecutionType
+		return result
+	}
+
+	queuePath := ac.AppConfig.GetQueuePath(worker.QueueFor(job.Priority))
+	// A repeated requeue of the same job within a minute (double submit, overlapping batch
+	// requeues) must not run it twice.
+	dedupe := enqueueOptions{DedupeKey: "requeue:" + jobRef.ID, DedupeWindow: time.Minute, JobID: jobRef.ID}
+	task, err := ac.enqueueTask(ctx, queuePath, fmt.Sprintf("%s/%s", worker.ServiceURL, endpoint), worker.ServiceAccount, payload, dedupe)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to re-enqueue job")
+		result.Status, result.Message = "failed", "Failed to enqueue task"
+		return result
+	}
+
+	updates := []docstore.Update{
+		{Path: "requeued_at", Value: NowISO8601()},
+		{Path: "requeue_count", Value: docstore.Increment(1)},
+		{Path: "task_name", Value: task.GetName()},
+	}
+	if manifestMode != "" {
+		updates = append(updates, docstore.Update{Path: "manifest_mode", Value: manifestMode})
+	}
+	if _, err := jobRef.Update(ctx, updates); err != nil {
+		// The task is already enqueued; only the bookkeeping is missing.
+		logCtx.WithError(err).Warn("Failed to record requeue on job")
+	}
+	result.Status = "requeued"
+	return result
+}
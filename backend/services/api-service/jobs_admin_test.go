@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// submitPublicJob runs code through POST /api/execute and returns the queued job's ID.
+func submitPublicJob(h *handlerHarness, code, input string) string {
+	h.t.Helper()
+	var resp map[string]string
+	w := h.do(http.MethodPost, "/api/execute", "", RequestBody{Code: code, Language: "python", Input: input}, &resp)
+	if w.Code != http.StatusOK {
+		h.t.Fatalf("execute: %d %s", w.Code, w.Body.String())
+	}
+	return resp["job_id"]
+}
+
+// backdateJob moves a job's submission time into the past.
+func backdateJob(h *handlerHarness, jobID string, age time.Duration) {
+	h.t.Helper()
+	_, err := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(jobID).Update(context.Background(), []docstore.Update{
+		{Path: "submitted_at", Value: TimeToISO8601(time.Now().Add(-age))},
+	})
+	if err != nil {
+		h.t.Fatalf("backdate job: %v", err)
+	}
+}
+
+// auditEntries returns the audit log entries written by actorID.
+func auditEntries(h *handlerHarness, actorID string) []AuditEntry {
+	h.t.Helper()
+	docs, err := h.fs.Collection(auditLogCollection).Where("actor_id", "==", actorID).Documents(context.Background()).GetAll()
+	assert.NoError(h.t, err)
+	entries := make([]AuditEntry, 0, len(docs))
+	for _, doc := range docs {
+		var entry AuditEntry
+		assert.NoError(h.t, doc.DataTo(&entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestHandlers_ForceFailJob(t *testing.T) {
+	h := newHandlerHarness(t)
+	admin := "admin-" + uuid.New().String()
+	jobID := submitPublicJob(h, "while True: pass", "")
+	path := "/api/admin/jobs/" + jobID + "/fail"
+
+	var resp map[string]string
+	w := h.do(http.MethodPost, path, admin, FailJobRequest{Note: "stuck worker"}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "queued", resp["previousStatus"])
+	job, err := h.ac.Jobs.Get(context.Background(), jobID)
+	assert.NoError(t, err)
+	assert.Equal(t, "failed", job.Status)
+	assert.Equal(t, "operator_terminated", job.FailureType)
+	assert.Equal(t, "Terminated by operator: stuck worker", job.Error)
+
+	// A finished job keeps its result.
+	w = h.do(http.MethodPost, path, admin, FailJobRequest{Note: "again"}, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	job, err = h.ac.Jobs.Get(context.Background(), jobID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Terminated by operator: stuck worker", job.Error)
+
+	w = h.do(http.MethodPost, "/api/admin/jobs/"+uuid.New().String()+"/fail", admin, FailJobRequest{Note: "x"}, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+	entries := auditEntries(h, admin)
+	if assert.Len(t, entries, 1, "only the applied force-fail is audited") {
+		assert.Equal(t, auditJobForceFailed, entries[0].Action)
+		assert.Equal(t, jobID, entries[0].TargetID)
+		assert.Equal(t, "stuck worker", entries[0].Details["note"])
+		assert.Equal(t, "queued", entries[0].Details["previous_status"])
+	}
+}
+
+func TestHandlers_RequeueJobs(t *testing.T) {
+	h := newHandlerHarness(t)
+	admin := "admin-" + uuid.New().String()
+	owner := "owner-" + uuid.New().String()
+
+	publicJob := submitPublicJob(h, "print(input())", "stdin text")
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print(input())")
+	h.seedFile(workspaceID, "data.txt", "file stdin")
+	var submitted ExecuteAuthResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{
+		Language:       "python",
+		EntrypointFile: "main.py",
+		InputFilePath:  "data.txt",
+	}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	workspaceJob := submitted.JobID
+	recentJob := submitPublicJob(h, "print(2)", "")
+	backdateJob(h, publicJob, time.Hour)
+	backdateJob(h, workspaceJob, time.Hour)
+
+	// Code and input are stored once, on the job itself.
+	snap, err := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(publicJob).Get(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "print(input())", snap.Data()["code"])
+	assert.Equal(t, map[string]interface{}{"timeout_seconds": int64(defaultPythonLimits.DefaultTimeoutSeconds), "memory_mb": int64(defaultPythonLimits.DefaultMemoryMb)}, snap.Data()["replay"])
+
+	publicTasks, authTasks := len(h.tasks.tasksTo("/execute")), len(h.tasks.tasksTo("/execute_auth"))
+	var resp struct {
+		Matched  int                `json:"matched"`
+		Requeued int                `json:"requeued"`
+		Results  []RequeueJobResult `json:"results"`
+	}
+	w = h.do(http.MethodPost, "/api/admin/jobs/requeue", admin, RequeueJobsRequest{OlderThan: "30m"}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 2, resp.Matched)
+	assert.Equal(t, 2, resp.Requeued)
+	for _, result := range resp.Results {
+		assert.NotEqual(t, recentJob, result.JobID, "jobs younger than olderThan stay untouched")
+		assert.Equal(t, "requeued", result.Status, result.Message)
+	}
+
+	bodies := h.tasks.tasksTo("/execute")
+	if assert.Len(t, bodies, publicTasks+1) {
+		var payload CloudTaskPayload
+		assert.NoError(t, json.Unmarshal(bodies[len(bodies)-1], &payload))
+		assert.Equal(t, publicJob, payload.JobID)
+		assert.Equal(t, "print(input())", payload.Code)
+		assert.Equal(t, "stdin text", payload.Input)
+		assert.Equal(t, defaultPythonLimits.DefaultTimeoutSeconds, payload.TimeoutSeconds)
+	}
+	bodies = h.tasks.tasksTo("/execute_auth")
+	if assert.Len(t, bodies, authTasks+1) {
+		var payload CloudTaskAuthPayload
+		assert.NoError(t, json.Unmarshal(bodies[len(bodies)-1], &payload))
+		assert.Equal(t, workspaceJob, payload.JobID)
+		if assert.NotNil(t, payload.InputFile) {
+			assert.Equal(t, "data.txt", payload.InputFile.FilePath)
+		}
+	}
+	snap, err = h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(publicJob).Get(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), snap.Data()["requeue_count"])
+
+	entries := auditEntries(h, admin)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, auditJobsRequeued, entries[0].Action)
+		assert.EqualValues(t, 2, entries[0].Details["matched"])
+		assert.EqualValues(t, 2, entries[0].Details["requeued"])
+	}
+}
+
+func TestHandlers_ListAdminJobs(t *testing.T) {
+	h := newHandlerHarness(t)
+	admin := "admin-" + uuid.New().String()
+	older, newer := submitPublicJob(h, "print(1)", ""), submitPublicJob(h, "print(2)", "")
+	backdateJob(h, older, 2*time.Hour)
+	backdateJob(h, newer, time.Hour)
+	submitPublicJob(h, "print(3)", "")
+
+	var page struct {
+		Jobs       []AdminJob `json:"jobs"`
+		NextCursor string     `json:"nextCursor"`
+	}
+	w := h.do(http.MethodGet, "/api/admin/jobs?olderThan=30m&limit=1", admin, nil, &page)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if assert.Len(t, page.Jobs, 1) {
+		assert.Equal(t, older, page.Jobs[0].JobID, "oldest first")
+		assert.Empty(t, page.Jobs[0].Code, "code is never returned")
+	}
+	assert.Equal(t, older, page.NextCursor)
+
+	cursor := page.NextCursor
+	page.Jobs, page.NextCursor = nil, ""
+	w = h.do(http.MethodGet, "/api/admin/jobs?olderThan=30m&limit=1&cursor="+cursor, admin, nil, &page)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if assert.Len(t, page.Jobs, 1) {
+		assert.Equal(t, newer, page.Jobs[0].JobID)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodGet, "/api/admin/jobs?limit=0", admin, nil, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodGet, "/api/admin/jobs?olderThan=soon", admin, nil, nil).Code)
+}
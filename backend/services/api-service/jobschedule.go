@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// jobStatusScheduled is the status of a job whose Cloud Task is held until its scheduled_at.
+// Workers treat it like queued once the task is dispatched.
+const jobStatusScheduled = "scheduled"
+
+// scheduleMinLead is how far ahead a scheduled run must be; anything sooner is just a run.
+const scheduleMinLead = time.Minute
+
+// isAwaitingDispatch reports whether a job's task has not reached a worker yet, so cancelling
+// it stops it outright.
+func isAwaitingDispatch(status string) bool {
+	return status == "queued" || status == jobStatusScheduled
+}
+
+// parseScheduleAt validates a requested run time: it must be at least scheduleMinLead and at
+// most maxAhead after now. An empty scheduleAt is valid and returns the zero time.
+func parseScheduleAt(raw string, now time.Time, maxAhead time.Duration) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	at, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("scheduleAt must be an ISO 8601 timestamp")
+	}
+	if at.Sub(now) < scheduleMinLead {
+		return time.Time{}, fmt.Errorf("scheduleAt must be at least %s in the future", scheduleMinLead)
+	}
+	if at.Sub(now) > maxAhead {
+		return time.Time{}, fmt.Errorf("scheduleAt must be within %s", maxAhead)
+	}
+	return at.UTC().Truncate(time.Millisecond), nil
+}
+
+// taskScheduleTime converts a run time to a Cloud Task ScheduleTime; the zero time dispatches
+// the task immediately.
+func taskScheduleTime(at time.Time) *timestamppb.Timestamp {
+	if at.IsZero() {
+		return nil
+	}
+	return timestamppb.New(at)
+}
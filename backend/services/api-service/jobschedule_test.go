@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScheduleAt(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	horizon := 30 * 24 * time.Hour
+
+	at, err := parseScheduleAt("", now, horizon)
+	assert.NoError(t, err)
+	assert.True(t, at.IsZero())
+
+	at, err = parseScheduleAt("2025-03-02T02:00:00+01:00", now, horizon)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 3, 2, 1, 0, 0, 0, time.UTC), at)
+
+	for _, raw := range []string{
+		"tomorrow",
+		"2025-03-01T11:00:00Z", // In the past
+		"2025-03-01T12:00:30Z", // Too soon
+		"2025-04-01T12:00:00Z", // Beyond the horizon
+	} {
+		_, err := parseScheduleAt(raw, now, horizon)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestTaskScheduleTime(t *testing.T) {
+	assert.Nil(t, taskScheduleTime(time.Time{}))
+
+	at := time.Date(2025, 3, 2, 2, 0, 0, 500_000_000, time.UTC)
+	ts := taskScheduleTime(at)
+	assert.Equal(t, int64(1740880800), ts.GetSeconds())
+	assert.Equal(t, int32(500_000_000), ts.GetNanos())
+	assert.True(t, ts.AsTime().Equal(at))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// errInvalidShareToken covers every share token that must not be honoured: malformed,
+// tampered with or expired. Callers answer all of them with the same 404.
+var errInvalidShareToken = errors.New("invalid share token")
+
+// signJobShare returns the HMAC-SHA256 of payload under key.
+func signJobShare(key, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// newJobShareToken returns a token granting read access to jobID until expiresAt:
+// base64url("<jobID>.<unix expiry>") + "." + base64url(signature). It needs no storage; the
+// job's share_revoked flag is the only way to withdraw it early.
+func newJobShareToken(key, jobID string, expiresAt time.Time) string {
+	payload := jobID + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(signJobShare(key, payload))
+}
+
+// parseJobShareToken returns the job ID of a token signed with key that has not expired at now.
+func parseJobShareToken(key, token string, now time.Time) (string, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errInvalidShareToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", errInvalidShareToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, signJobShare(key, string(payload))) {
+		return "", errInvalidShareToken
+	}
+	// The signature is checked first, so only payloads this service wrote are parsed.
+	dot := strings.LastIndexByte(string(payload), '.')
+	if dot <= 0 {
+		return "", errInvalidShareToken
+	}
+	expiry, err := strconv.ParseInt(string(payload[dot+1:]), 10, 64)
+	if err != nil || !now.Before(time.Unix(expiry, 0)) {
+		return "", errInvalidShareToken
+	}
+	return string(payload[:dot]), nil
+}
+
+// jobShareExpiry returns when a link to job made at now for ttl should expire. Links never
+// outlive the job, so they cannot point at a reaped result.
+func jobShareExpiry(job Job, ttl time.Duration, now time.Time) time.Time {
+	expiresAt := now.Add(ttl)
+	if !job.DeleteAfter.IsZero() && job.DeleteAfter.Before(expiresAt) {
+		expiresAt = job.DeleteAfter
+	}
+	return expiresAt
+}
+
+// loadShareableJob loads a live workspace job and checks that userID may share it: workspace
+// owners and the job's submitter only. It writes the error response itself and returns nil
+// when the request should stop.
+func (ac *ApiController) loadShareableJob(c *gin.Context, workspaceID, jobID, userID string, logCtx *log.Entry) *Job {
+	ctx := c.Request.Context()
+	membership := ac.requireWorkspaceRole(c, workspaceID, userID, roleViewer, logCtx)
+	if membership == nil {
+		return nil
+	}
+
+	job, err := ac.Jobs.Get(ctx, jobID)
+	if errors.Is(err, errJobNotFound) || (err == nil && (job.WorkspaceID != workspaceID || job.ExecutionType != executionTypeWorkspace || isPastDeleteAfter(job.DeleteAfter))) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return nil
+	}
+	if !roleSatisfies(membership.Role, roleOwner) && job.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only workspace owners and the job's submitter can share it"})
+		return nil
+	}
+	return &job
+}
+
+// ShareWorkspaceJob returns a signed link that shows a workspace job's result without
+// sign-in. Links of a job whose sharing was revoked are not reissued.
+func (ac *ApiController) ShareWorkspaceJob(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	jobID := c.Param("jobId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "ShareWorkspaceJob", "workspace_id": workspaceID, "job_id": jobID, "user_id": userID})
+
+	if ac.AppConfig.JobShareSigningKey == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Job sharing is not configured"})
+		return
+	}
+	var req JobShareRequest
+	if c.Request.ContentLength > 0 {
+		if err := ac.bindJSON(c, &req); err != nil {
+			return
+		}
+	}
+	ttl := ac.AppConfig.JobShareTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if ttl > ac.AppConfig.JobShareTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresInHours must not exceed " + strconv.Itoa(int(ac.AppConfig.JobShareTTL.Hours()))})
+		return
+	}
+
+	job := ac.loadShareableJob(c, workspaceID, jobID, userID, logCtx)
+	if job == nil {
+		return
+	}
+	if job.ShareRevoked {
+		c.JSON(http.StatusConflict, gin.H{"error": "Sharing was revoked for this job"})
+		return
+	}
+
+	expiresAt := jobShareExpiry(*job, ttl, time.Now())
+	token := newJobShareToken(ac.AppConfig.JobShareSigningKey, jobID, expiresAt)
+
+	ac.recordActivity(ctx, workspaceID, userID, activityJobShared, map[string]interface{}{
+		"job_id":     jobID,
+		"expires_at": TimeToISO8601(expiresAt),
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"jobId":     jobID,
+		"token":     token,
+		"path":      "/api/shared/jobs/" + token,
+		"expiresAt": TimeToISO8601(expiresAt),
+	})
+}
+
+// RevokeWorkspaceJobShare withdraws every share link of a workspace job. Revoking twice
+// succeeds without changes.
+func (ac *ApiController) RevokeWorkspaceJobShare(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	jobID := c.Param("jobId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "RevokeWorkspaceJobShare", "workspace_id": workspaceID, "job_id": jobID, "user_id": userID})
+
+	job := ac.loadShareableJob(c, workspaceID, jobID, userID, logCtx)
+	if job == nil {
+		return
+	}
+	if !job.ShareRevoked {
+		_, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+			if job.ShareRevoked {
+				return false, nil
+			}
+			job.ShareRevoked = true
+			return true, nil
+		})
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to revoke job share.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sharing"})
+			return
+		}
+		ac.recordActivity(ctx, workspaceID, userID, activityJobUnshared, map[string]interface{}{"job_id": jobID})
+	}
+	c.JSON(http.StatusOK, gin.H{"jobId": jobID, "shareRevoked": true})
+}
+
+// GetSharedJob serves a workspace job's result to the holder of a share link. No auth is
+// required; the signed token is the capability. Code, input and submitter are never
+// returned. Malformed, tampered, expired and revoked tokens all get the same 404.
+func (ac *ApiController) GetSharedJob(c *gin.Context) {
+	ctx := c.Request.Context()
+	notFound := func() { c.JSON(http.StatusNotFound, gin.H{"error": "Result not found"}) }
+
+	key := ac.AppConfig.JobShareSigningKey
+	if key == "" {
+		notFound()
+		return
+	}
+	jobID, err := parseJobShareToken(key, c.Param("token"), time.Now())
+	if err != nil {
+		notFound()
+		return
+	}
+	logCtx := log.WithFields(log.Fields{"handler": "GetSharedJob", "job_id": jobID})
+
+	job, err := ac.Jobs.Get(ctx, jobID)
+	if err != nil && !errors.Is(err, errJobNotFound) {
+		logCtx.WithError(err).Error("Failed to load shared job.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load result"})
+		return
+	}
+	if err != nil || job.ShareRevoked || job.ExecutionType != executionTypeWorkspace || isPastDeleteAfter(job.DeleteAfter) {
+		notFound()
+		return
+	}
+
+	// Links can be revoked, so no cache may keep the result.
+	job, outputURL := ac.jobOutputForRead(ctx, jobID, job, logCtx)
+	c.Header("Cache-Control", "private, no-store")
+	c.JSON(http.StatusOK, publicResult(job, outputURL))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobShareToken(t *testing.T) {
+	now := time.Now()
+	token := newJobShareToken("key", "job-1", now.Add(time.Hour))
+
+	jobID, err := parseJobShareToken("key", token, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "job-1", jobID)
+
+	_, err = parseJobShareToken("other-key", token, now)
+	assert.ErrorIs(t, err, errInvalidShareToken, "signed with another key")
+	_, err = parseJobShareToken("key", token, now.Add(time.Hour))
+	assert.ErrorIs(t, err, errInvalidShareToken, "expired")
+
+	// Extending the expiry or swapping the job ID breaks the signature.
+	payload, sig, _ := strings.Cut(token, ".")
+	forged, _, _ := strings.Cut(newJobShareToken("key", "job-2", now.Add(time.Hour)), ".")
+	_, err = parseJobShareToken("key", forged+"."+sig, now)
+	assert.ErrorIs(t, err, errInvalidShareToken)
+	_, err = parseJobShareToken("key", payload+"."+sig[:len(sig)-2]+"AA", now)
+	assert.ErrorIs(t, err, errInvalidShareToken)
+
+	for _, garbage := range []string{"", ".", "job-1", "!!!.???"} {
+		_, err = parseJobShareToken("key", garbage, now)
+		assert.ErrorIs(t, err, errInvalidShareToken, garbage)
+	}
+}
+
+func TestJobShareExpiry(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, now.Add(24*time.Hour), jobShareExpiry(Job{}, 24*time.Hour, now), "pinned jobs never expire")
+
+	reaped := now.Add(time.Hour)
+	assert.Equal(t, reaped, jobShareExpiry(Job{DeleteAfter: reaped}, 24*time.Hour, now), "links never outlive the job")
+}
+
+func TestHandlers_ShareWorkspaceJob(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.JobShareSigningKey = "test-share-key"
+	h.ac.AppConfig.JobShareTTL = 24 * time.Hour
+	owner := "owner-" + uuid.New().String()
+	viewer := "viewer-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, viewer, "viewer", "")
+	h.seedFile(workspaceID, "main.py", "print(1)")
+
+	var run ExecuteAuthResponse
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, &run))
+	finishJob(h, run.JobID)
+	sharePath := "/api/workspaces/" + workspaceID + "/jobs/" + run.JobID + "/share"
+
+	w := h.do(http.MethodPost, sharePath, viewer, nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "viewers cannot share others' jobs")
+	w = h.do(http.MethodPost, sharePath, owner, gin.H{"expiresInHours": 48}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "longer than the configured lifetime")
+
+	var share map[string]interface{}
+	w = h.do(http.MethodPost, sharePath, owner, gin.H{"expiresInHours": 2}, &share)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	token := share["token"].(string)
+
+	var result map[string]interface{}
+	w = h.do(http.MethodGet, "/api/shared/jobs/"+token, "", nil, &result)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "completed", result["status"])
+	assert.Equal(t, "hi\n", result["output"])
+	for _, field := range []string{"code", "input", "userID", "entrypointFile", "jobId"} {
+		assert.NotContains(t, result, field)
+	}
+
+	w = h.do(http.MethodGet, "/api/shared/jobs/"+token+"x", "", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, "a tampered token")
+	expired := newJobShareToken("test-share-key", run.JobID, time.Now().Add(-time.Second))
+	w = h.do(http.MethodGet, "/api/shared/jobs/"+expired, "", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, "an expired token")
+
+	w = h.do(http.MethodDelete, sharePath, owner, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = h.do(http.MethodGet, "/api/shared/jobs/"+token, "", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, "a revoked job's links stop working")
+	w = h.do(http.MethodPost, sharePath, owner, nil, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobStatusRunning is the status a worker reports through HandleJobStatus once it starts a
+// job; workers that write Firestore directly use the finer-grained runningJobStatuses.
+const jobStatusRunning = "running"
+
+// errJobTransition is returned for a status report that does not follow queued (or
+// scheduled) → running → completed, failed or cancelled.
+var errJobTransition = errors.New("illegal job status transition")
+
+// errJobTiming is returned for a status report that finishes a job before it started.
+var errJobTiming = errors.New("job finished before it started")
+
+// reportedTime parses a timestamp a worker reported, or returns fallback when it sent none.
+// Reports are validated before they are applied.
+func reportedTime(raw string, fallback time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t
+	}
+	return fallback
+}
+
+// jobDurationMs returns the run time of a job started at startedAt and finished at finished,
+// in milliseconds. It is 0 when startedAt is unknown, and ok is false when finished is
+// before startedAt.
+func jobDurationMs(startedAt string, finished time.Time) (ms int64, ok bool) {
+	started, err := time.Parse(time.RFC3339, startedAt)
+	if err != nil {
+		return 0, true
+	}
+	if finished.Before(started) {
+		return 0, false
+	}
+	return finished.Sub(started).Milliseconds(), true
+}
+
+// applyJobStatusReport moves a job to the reported status. Reporting the status the job is
+// already in (any running status counts as running) changes nothing, so a retried report
+// succeeds; any other move that skips or reverses a step returns errJobTransition. A queued
+// or scheduled job whose deadline has passed is failed as expired_before_execution instead
+// of being started, and expired is set. Finishing a job records its run time; a reported
+// finish before the job's start returns errJobTiming.
+func applyJobStatusReport(job *Job, report JobStatusReport, now time.Time) (changed, expired bool, err error) {
+	if job.Status == report.Status {
+		return false, false, nil
+	}
+	stamp := TimeToISO8601(now)
+	switch {
+	case isAwaitingDispatch(job.Status) && report.Status == jobStatusRunning:
+		if jobDeadlineMissed(*job, now) {
+			job.Status, job.FailureType, job.CompletedAt, job.UpdatedAt = "failed", failureTypeExpiredBeforeExecution, stamp, stamp
+			job.Error = "The job's deadline passed before a worker picked it up"
+			return true, true, nil
+		}
+		job.Status, job.StartedAt, job.UpdatedAt = jobStatusRunning, TimeToISO8601(reportedTime(report.StartedAt, now)), stamp
+		return true, false, nil
+	case slices.Contains(runningJobStatuses, job.Status) && report.Status == jobStatusRunning:
+		return false, false, nil
+	case slices.Contains(runningJobStatuses, job.Status) && isTerminalJobStatus(report.Status):
+		startedAt := job.StartedAt
+		if startedAt == "" && report.StartedAt != "" {
+			startedAt = TimeToISO8601(reportedTime(report.StartedAt, now))
+		}
+		finished := reportedTime(report.FinishedAt, now)
+		durationMs, ok := jobDurationMs(startedAt, finished)
+		if !ok && report.FinishedAt != "" {
+			return false, false, errJobTiming
+		}
+		if !ok {
+			// The worker's clock is ahead of ours; its start is the earliest the job finished.
+			finished = reportedTime(startedAt, now)
+		}
+		job.Status, job.Output, job.Error, job.FailureType = report.Status, report.Output, report.Error, report.FailureType
+		job.StartedAt, job.CompletedAt, job.DurationMs, job.UpdatedAt = startedAt, TimeToISO8601(finished), durationMs, stamp
+		return true, false, nil
+	default:
+		return false, false, errJobTransition
+	}
+}
+
+// HandleJobStatus is called by execution workers on every status change of a job, and runs
+// the side effects of a job finishing (activity, result callback) once it has. Out-of-order
+// reports get 409 with the job's current status; a worker told its job was expired or has
+// already finished must not run it.
+func (ac *ApiController) HandleJobStatus(c *gin.Context) {
+	jobID := c.Param("jobId")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"job_id": jobID, "handler": "HandleJobStatus"})
+
+	var report JobStatusReport
+	if err := ac.bindJSON(c, &report); err != nil {
+		return
+	}
+	for name, ts := range map[string]string{"started_at": report.StartedAt, "finished_at": report.FinishedAt} {
+		if _, err := time.Parse(time.RFC3339, ts); ts != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be an ISO 8601 timestamp", name)})
+			return
+		}
+	}
+	if report.StartedAt != "" && report.FinishedAt != "" && reportedTime(report.FinishedAt, time.Time{}).Before(reportedTime(report.StartedAt, time.Time{})) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "finished_at must not be before started_at"})
+		return
+	}
+
+	var stored storedJobOutput
+	if isTerminalJobStatus(report.Status) {
+		stored = ac.storeReportedOutput(ctx, jobID, report.Output, logCtx)
+	}
+
+	var previous string
+	var changed, expired bool
+	job, err := ac.Jobs.Update(ctx, jobID, func(job *Job) (bool, error) {
+		previous = job.Status
+		var err error
+		changed, expired, err = applyJobStatusReport(job, report, time.Now().UTC())
+		if changed && isTerminalJobStatus(report.Status) {
+			stored.apply(job)
+		}
+		return changed, err
+	})
+	switch {
+	case errors.Is(err, errJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	case errors.Is(err, errJobTiming):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "finished_at must not be before the job started"})
+		return
+	case errors.Is(err, errJobTransition):
+		logCtx.WithFields(log.Fields{"status": previous, "reported_status": report.Status}).Warn("Out-of-order job status report rejected.")
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Job cannot move from %s to %s", previous, report.Status), "status": previous})
+		return
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to record job status.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record job status"})
+		return
+	}
+
+	if changed {
+		logCtx.WithFields(log.Fields{"previous_status": previous, "status": job.Status}).Info("Job status reported.")
+		if expired {
+			ac.deadlines.recordMissed(time.Now())
+		}
+		if isTerminalJobStatus(job.Status) {
+			// The callback carries the full output, not what the job kept of it.
+			finished := job
+			if !expired {
+				finished.Output = report.Output
+			}
+			ac.afterJobFinished(ctx, jobID, finished, logCtx)
+		}
+	}
+	if expired {
+		c.JSON(http.StatusConflict, gin.H{"error": "The job's deadline passed before it started", "status": job.Status, "failureType": job.FailureType})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"jobId": jobID, "status": job.Status, "cancelRequested": job.CancelRequested})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyJobStatusReport(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		from, to string
+		changed  bool
+		err      error
+	}{
+		{"start", "queued", jobStatusRunning, true, nil},
+		{"start a scheduled job", jobStatusScheduled, jobStatusRunning, true, nil},
+		{"finish", jobStatusRunning, "completed", true, nil},
+		{"fail", jobStatusRunning, "failed", true, nil},
+		{"cancel while running", jobStatusRunning, jobStatusCancelled, true, nil},
+		{"finish a job the worker set running itself", "running_auth_workspace", "completed", true, nil},
+		{"repeated start", jobStatusRunning, jobStatusRunning, false, nil},
+		{"start reported after a detailed running status", "fetching_from_r2", jobStatusRunning, false, nil},
+		{"repeated finish", "completed", "completed", false, nil},
+		{"finish without starting", "queued", "completed", false, errJobTransition},
+		{"restart a finished job", "completed", jobStatusRunning, false, errJobTransition},
+		{"change a finished result", "failed", "completed", false, errJobTransition},
+		{"start a cancelled job", jobStatusCancelled, jobStatusRunning, false, errJobTransition},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			job := Job{Status: tc.from}
+			changed, expired, err := applyJobStatusReport(&job, JobStatusReport{Status: tc.to, Output: "ok"}, now)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.changed, changed)
+			assert.False(t, expired)
+			if changed {
+				assert.Equal(t, tc.to, job.Status)
+			}
+		})
+	}
+
+	job := Job{Status: "queued", Deadline: TimeToISO8601(now.Add(-time.Minute))}
+	changed, expired, err := applyJobStatusReport(&job, JobStatusReport{Status: jobStatusRunning}, now)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, expired)
+	assert.Equal(t, "failed", job.Status)
+	assert.Equal(t, failureTypeExpiredBeforeExecution, job.FailureType)
+}
+
+func TestApplyJobStatusReport_Timing(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Reported times are stored in the API's own ISO 8601 form.
+	job := Job{Status: "queued"}
+	_, _, err := applyJobStatusReport(&job, JobStatusReport{Status: jobStatusRunning, StartedAt: "2025-03-01T12:59:58.5+01:00"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, TimeToISO8601(now.Add(-1500*time.Millisecond)), job.StartedAt)
+	_, _, err = applyJobStatusReport(&job, JobStatusReport{Status: "completed"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, TimeToISO8601(now), job.CompletedAt)
+	assert.Equal(t, int64(1500), job.DurationMs)
+
+	// Without a reported start the report time is used.
+	job = Job{Status: jobStatusScheduled}
+	_, _, err = applyJobStatusReport(&job, JobStatusReport{Status: jobStatusRunning}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, TimeToISO8601(now), job.StartedAt)
+	_, _, err = applyJobStatusReport(&job, JobStatusReport{Status: "failed", FinishedAt: TimeToISO8601(now.Add(2 * time.Second))}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2000), job.DurationMs)
+
+	// A job a worker set running itself has no start; it gets none from the finish either.
+	job = Job{Status: "running_auth_workspace"}
+	_, _, err = applyJobStatusReport(&job, JobStatusReport{Status: "completed"}, now)
+	assert.NoError(t, err)
+	assert.Empty(t, job.StartedAt)
+	assert.Zero(t, job.DurationMs)
+	assert.Equal(t, TimeToISO8601(now), job.CompletedAt)
+
+	// A reported finish before the start is refused and changes nothing.
+	job = Job{Status: jobStatusRunning, StartedAt: TimeToISO8601(now)}
+	changed, _, err := applyJobStatusReport(&job, JobStatusReport{Status: "completed", FinishedAt: TimeToISO8601(now.Add(-time.Second))}, now)
+	assert.ErrorIs(t, err, errJobTiming)
+	assert.False(t, changed)
+	assert.Equal(t, jobStatusRunning, job.Status)
+
+	// A worker clock ahead of the API's: the job finished no earlier than it started.
+	job = Job{Status: jobStatusRunning, StartedAt: TimeToISO8601(now.Add(time.Second))}
+	_, _, err = applyJobStatusReport(&job, JobStatusReport{Status: "completed"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, job.StartedAt, job.CompletedAt)
+	assert.Zero(t, job.DurationMs)
+}
+
+func TestHandleJobStatus(t *testing.T) {
+	r, _, _ := newEmbeddedHarness(t)
+
+	var submitted map[string]string
+	w := serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	statusPath := "/internal/jobs/" + submitted["job_id"] + "/status"
+
+	w = serveJSON(r, http.MethodPost, statusPath, JobStatusReport{Status: "completed"}, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, "a queued job must start first")
+	w = serveJSON(r, http.MethodPost, statusPath, JobStatusReport{Status: "paused"}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveJSON(r, http.MethodPost, statusPath, JobStatusReport{Status: jobStatusRunning, StartedAt: "yesterday"}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveJSON(r, http.MethodPost, statusPath, JobStatusReport{Status: jobStatusRunning, StartedAt: "2025-03-01T12:00:01Z", FinishedAt: "2025-03-01T12:00:00Z"}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	startedAt := TimeToISO8601(time.Now().Add(-time.Second))
+	w = serveJSON(r, http.MethodPost, statusPath, JobStatusReport{Status: jobStatusRunning, StartedAt: startedAt}, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serveJSON(r, http.MethodPost, statusPath, JobStatusReport{Status: "completed", FinishedAt: TimeToISO8601(time.Now().Add(-time.Minute))}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the job cannot finish before it started")
+	w = serveJSON(r, http.MethodPost, statusPath, JobStatusReport{Status: "completed", Output: "1\n"}, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serveJSON(r, http.MethodPost, statusPath, JobStatusReport{Status: "completed", Output: "1\n"}, nil)
+	assert.Equal(t, http.StatusOK, w.Code, "a retried report is accepted")
+	var rejected map[string]string
+	w = serveJSON(r, http.MethodPost, statusPath, JobStatusReport{Status: jobStatusRunning}, &rejected)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "completed", rejected["status"])
+
+	var status JobStatusResponse
+	serveJSON(r, http.MethodGet, "/api/jobs/"+submitted["job_id"], nil, &status)
+	assert.Equal(t, "completed", status.Status)
+	assert.Equal(t, "1\n", status.Output)
+	assert.Equal(t, startedAt, status.StartedAt)
+	assert.GreaterOrEqual(t, status.DurationMs, int64(1000))
+
+	w = serveJSON(r, http.MethodPost, "/internal/jobs/unknown/status", JobStatusReport{Status: jobStatusRunning}, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+)
+
+// Job stores selected with JOB_STORE. The embedded store keeps jobs in the API process and
+// is only for single-instance deployments without workspaces; see EmbeddedJobStore.
+const (
+	jobStoreFirestore = "firestore"
+	jobStoreEmbedded  = "embedded"
+)
+
+// validateJobStore checks JOB_STORE against the features that need Firestore: the embedded
+// store only serves public executions, from a single instance.
+func (cfg *AppConfig) validateJobStore() error {
+	switch cfg.JobStore {
+	case jobStoreFirestore:
+		return nil
+	case jobStoreEmbedded:
+	default:
+		return fmt.Errorf("JOB_STORE must be %q or %q, got %q", jobStoreFirestore, jobStoreEmbedded, cfg.JobStore)
+	}
+	if cfg.WorkspacesEnabled {
+		return fmt.Errorf("JOB_STORE=embedded requires WORKSPACES_ENABLED=false: workspaces need Firestore")
+	}
+	if cfg.RateLimiter != "memory" {
+		return fmt.Errorf("JOB_STORE=embedded requires RATE_LIMITER=memory")
+	}
+	return nil
+}
+
+// errJobNotFound is returned by a JobStore for unknown job IDs and result tokens.
+var errJobNotFound = errors.New("job not found")
+
+// StoredJob is a job together with its ID, as returned by JobStore.List.
+type StoredJob struct {
+	ID  string
+	Job Job
+}
+
+// JobQuery selects jobs for JobStore.List, oldest submission first.
+type JobQuery struct {
+	Statuses []string // Required
+	Limit    int
+}
+
+// JobStore holds job documents for the execution endpoints, the worker callbacks and the
+// stale-jobs task. Workspace features (pins, workspace job lists, requeues) still query the
+// Firestore jobs collection directly and are unavailable with the embedded store.
+type JobStore interface {
+	// Create stores a new job. Code and Input are never persisted.
+	Create(ctx context.Context, jobID string, job Job) error
+	// CreateMany stores new jobs in one pass. It returns one error per job, nil for each job
+	// that was stored.
+	CreateMany(ctx context.Context, jobs []StoredJob) []error
+	// CreateWithQuota stores a new job and charges it to quota, or returns errQuotaExceeded
+	// and stores nothing.
+	CreateWithQuota(ctx context.Context, quota JobQuota, jobID string, job Job) error
+	// ChargeQuota charges n executions to quota, or returns errQuotaExceeded and charges
+	// nothing.
+	ChargeQuota(ctx context.Context, quota JobQuota, n int) error
+	// CreateIdempotent stores a new job under an idempotency key, or, while the key is live
+	// and its job exists, returns that job with replayed set instead. A non-nil quota is
+	// charged only for a new job, as for CreateWithQuota.
+	CreateIdempotent(ctx context.Context, key IdempotencyKey, quota *JobQuota, jobID string, job Job) (stored StoredJob, replayed bool, err error)
+	// CreateDeduplicated stores a new job under a content hash, or, while the hash's record is
+	// live and its job completed, returns that job with cached set instead. A non-nil quota is
+	// charged only for a new job, as for CreateWithQuota.
+	CreateDeduplicated(ctx context.Context, key JobDedupeKey, quota *JobQuota, jobID string, job Job) (stored StoredJob, cached bool, err error)
+	// ReleaseIdempotencyKey forgets a key, so its next use creates a new job.
+	ReleaseIdempotencyKey(ctx context.Context, key IdempotencyKey) error
+	// Get returns a job, or errJobNotFound.
+	Get(ctx context.Context, jobID string) (Job, error)
+	// FindByResultToken returns the public job shared under token, or errJobNotFound.
+	FindByResultToken(ctx context.Context, token string) (string, Job, error)
+	// Update atomically applies fn to a job and stores the result when fn reports a change.
+	// It returns the job as stored afterwards, or errJobNotFound.
+	Update(ctx context.Context, jobID string, fn func(job *Job) (bool, error)) (Job, error)
+	// List returns jobs in any of the query's statuses.
+	List(ctx context.Context, q JobQuery) ([]StoredJob, error)
+	// Count returns how many jobs are in any of statuses.
+	Count(ctx context.Context, statuses []string) (int, error)
+	// DeleteExpired removes jobs whose delete_after has passed at now, except those a worker
+	// is still running. A sweep may stop before the end of a large backlog; see
+	// JobCleanupResult.Complete. A non-nil archive is given each batch of jobs before they
+	// are deleted; when it fails, the batch is kept and the sweep stops.
+	DeleteExpired(ctx context.Context, now time.Time, archive jobArchiveFunc) (JobCleanupResult, error)
+}
+
+// openJobStore returns the store selected by JOB_STORE. fs may be nil for the embedded store.
+func openJobStore(cfg *AppConfig, fs *docstore.Client) (JobStore, error) {
+	if cfg.JobStore == jobStoreEmbedded {
+		return NewEmbeddedJobStore(cfg.JobStorePath)
+	}
+	return newFirestoreJobStore(fs, cfg.FirestoreJobsCollection), nil
+}
+
+// runJobExpiryMaintenance is the "job-expiry" maintenance task; see CleanupExpiredJobs.
+func (ac *ApiController) runJobExpiryMaintenance(ctx context.Context) (interface{}, error) {
+	return ac.CleanupExpiredJobs(ctx)
+}
+
+// firestoreJobStore keeps jobs in a Firestore collection. Expired jobs are removed by the
+// collection's TTL policy on delete_after where one is configured, and by DeleteExpired.
+type firestoreJobStore struct {
+	fs         *docstore.Client
+	collection string
+}
+
+func newFirestoreJobStore(fs *docstore.Client, collection string) *firestoreJobStore {
+	return &firestoreJobStore{fs: fs, collection: collection}
+}
+
+func (s *firestoreJobStore) Create(ctx context.Context, jobID string, job Job) error {
+	_, err := s.fs.Collection(s.collection).Doc(jobID).Set(ctx, job)
+	return err
+}
+
+func (s *firestoreJobStore) CreateMany(ctx context.Context, jobs []StoredJob) []error {
+	errs := make([]error, len(jobs))
+	bw := s.fs.BulkWriter(ctx)
+	writes := make([]*docstore.BulkWriterJob, len(jobs))
+	for i, job := range jobs {
+		writes[i], errs[i] = bw.Set(s.fs.Collection(s.collection).Doc(job.ID), job.Job)
+	}
+	bw.End()
+	for i, write := range writes {
+		if write != nil {
+			_, errs[i] = write.Results()
+		}
+	}
+	return errs
+}
+
+func (s *firestoreJobStore) Get(ctx context.Context, jobID string) (Job, error) {
+	snap, err := s.fs.Collection(s.collection).Doc(jobID).Get(ctx)
+	if isNotFound(err) {
+		return Job{}, errJobNotFound
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	var job Job
+	if err := snap.DataTo(&job); err != nil {
+		return Job{}, fmt.Errorf("failed to parse job: %w", err)
+	}
+	return job, nil
+}
+
+func (s *firestoreJobStore) FindByResultToken(ctx context.Context, token string) (string, Job, error) {
+	snaps, err := s.fs.Collection(s.collection).Where("result_token", "==", token).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return "", Job{}, err
+	}
+	if len(snaps) == 0 {
+		return "", Job{}, errJobNotFound
+	}
+	var job Job
+	if err := snaps[0].DataTo(&job); err != nil {
+		return "", Job{}, fmt.Errorf("failed to parse job: %w", err)
+	}
+	return snaps[0].Ref.ID, job, nil
+}
+
+func (s *firestoreJobStore) Update(ctx context.Context, jobID string, fn func(job *Job) (bool, error)) (Job, error) {
+	ref := s.fs.Collection(s.collection).Doc(jobID)
+	var job Job
+	err := s.fs.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var before Job
+		if err := snap.DataTo(&before); err != nil {
+			return fmt.Errorf("failed to parse job: %w", err)
+		}
+		job = before
+		changed, err := fn(&job)
+		if err != nil || !changed {
+			return err
+		}
+		if updates := jobFieldUpdates(before, job); len(updates) > 0 {
+			return tx.Update(ref, updates)
+		}
+		return nil
+	})
+	if isNotFound(err) {
+		return Job{}, errJobNotFound
+	}
+	return job, err
+}
+
+func (s *firestoreJobStore) List(ctx context.Context, q JobQuery) ([]StoredJob, error) {
+	query := s.fs.Collection(s.collection).
+		Where("status", "in", q.Statuses).
+		OrderBy("submitted_at", docstore.Asc)
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]StoredJob, 0, len(docs))
+	for _, doc := range docs {
+		var job Job
+		if err := doc.DataTo(&job); err != nil {
+			continue
+		}
+		jobs = append(jobs, StoredJob{ID: doc.Ref.ID, Job: job})
+	}
+	return jobs, nil
+}
+
+// Count uses an aggregate query, so no job is read.
+func (s *firestoreJobStore) Count(ctx context.Context, statuses []string) (int, error) {
+	res, err := s.fs.Collection(s.collection).
+		Where("status", "in", statuses).
+		NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	v, _ := res["count"].(*firestorepb.Value)
+	return int(v.GetIntegerValue()), nil
+}
+
+// jobFieldUpdates returns the Firestore updates turning before into after: one per stored
+// field that differs, deleting omitempty fields that became empty.
+func jobFieldUpdates(before, after Job) []docstore.Update {
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+	var updates []docstore.Update
+	for i := 0; i < a.NumField(); i++ {
+		name, opts, _ := strings.Cut(a.Type().Field(i).Tag.Get("firestore"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		av := a.Field(i)
+		if reflect.DeepEqual(b.Field(i).Interface(), av.Interface()) {
+			continue
+		}
+		if av.IsZero() && strings.Contains(opts, "omitempty") {
+			updates = append(updates, docstore.Update{Path: name, Value: docstore.Delete})
+			continue
+		}
+		updates = append(updates, docstore.Update{Path: name, Value: av.Interface()})
+	}
+	return updates
+}
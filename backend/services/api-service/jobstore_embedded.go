@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// embeddedMaintenanceInterval is how often an embedded deployment runs its job maintenance.
+const embeddedMaintenanceInterval = time.Minute
+
+// EmbeddedJobStore keeps jobs in memory and, when given a path, writes a snapshot after every
+// change so a restarted single-instance deployment picks up where it left off. Jobs are small
+// and expire after the retention window, so rewriting the whole snapshot stays cheap.
+//
+// Idempotency keys, dedupe records and quota counts are kept in memory only: after a
+// restart a retried request creates a new job and quotas start over.
+type EmbeddedJobStore struct {
+	mu     sync.Mutex
+	jobs   map[string]Job
+	keys   map[string]IdempotencyRecord
+	dedupe map[string]JobDedupeRecord
+	quotas map[string]embeddedQuotaCount
+	path   string // Empty keeps jobs in memory only
+}
+
+// embeddedQuotaCount is a caller's count for one day. One instance needs no sharding.
+type embeddedQuotaCount struct {
+	count       int
+	deleteAfter time.Time
+}
+
+// NewEmbeddedJobStore opens the store, loading the snapshot at path if one exists.
+func NewEmbeddedJobStore(path string) (*EmbeddedJobStore, error) {
+	s := &EmbeddedJobStore{
+		jobs:   make(map[string]Job),
+		keys:   make(map[string]IdempotencyRecord),
+		dedupe: make(map[string]JobDedupeRecord),
+		quotas: make(map[string]embeddedQuotaCount),
+		path:   path,
+	}
+	if path == "" {
+		return s, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job snapshot: %w", err)
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&s.jobs); err != nil {
+		return nil, fmt.Errorf("failed to read job snapshot %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// persist writes the snapshot through a temporary file, so a crash mid-write leaves the
+// previous snapshot intact. Callers hold mu.
+func (s *EmbeddedJobStore) persist() error {
+	if s.path == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write job snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(s.jobs); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write job snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write job snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace job snapshot: %w", err)
+	}
+	return nil
+}
+
+func (s *EmbeddedJobStore) Create(ctx context.Context, jobID string, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createCharged(nil, jobID, job)
+}
+
+func (s *EmbeddedJobStore) CreateMany(ctx context.Context, jobs []StoredJob) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := make([]error, len(jobs))
+	var added []string
+	for i, stored := range jobs {
+		if _, exists := s.jobs[stored.ID]; exists {
+			errs[i] = fmt.Errorf("job %s already exists", stored.ID)
+			continue
+		}
+		s.jobs[stored.ID] = stored.Job
+		added = append(added, stored.ID)
+	}
+	// One snapshot covers the whole batch; if it cannot be written, none of the jobs is kept.
+	if err := s.persist(); err != nil {
+		for _, id := range added {
+			delete(s.jobs, id)
+		}
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+func (s *EmbeddedJobStore) CreateWithQuota(ctx context.Context, quota JobQuota, jobID string, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createCharged(&quota, jobID, job)
+}
+
+func (s *EmbeddedJobStore) ChargeQuota(ctx context.Context, quota JobQuota, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.chargeQuota(quota, n)
+}
+
+// chargeQuota adds n to a quota's count, or returns errQuotaExceeded. Callers hold mu.
+func (s *EmbeddedJobStore) chargeQuota(quota JobQuota, n int) error {
+	id := quota.Key + "_" + quota.Day
+	current := s.quotas[id]
+	if current.count+n > quota.Limit {
+		return errQuotaExceeded
+	}
+	s.quotas[id] = embeddedQuotaCount{count: current.count + n, deleteAfter: quota.ResetAt}
+	return nil
+}
+
+// createCharged stores a new job and charges it to a non-nil quota. Callers hold mu.
+func (s *EmbeddedJobStore) createCharged(quota *JobQuota, jobID string, job Job) error {
+	if _, exists := s.jobs[jobID]; exists {
+		return fmt.Errorf("job %s already exists", jobID)
+	}
+	if quota != nil && s.quotas[quota.Key+"_"+quota.Day].count >= quota.Limit {
+		return errQuotaExceeded
+	}
+	s.jobs[jobID] = job
+	if err := s.persist(); err != nil {
+		delete(s.jobs, jobID)
+		return err
+	}
+	if quota != nil {
+		return s.chargeQuota(*quota, 1)
+	}
+	return nil
+}
+
+func (s *EmbeddedJobStore) CreateIdempotent(ctx context.Context, key IdempotencyKey, quota *JobQuota, jobID string, job Job) (StoredJob, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.keys[key.ID]; ok && !isPastDeleteAfter(record.DeleteAfter) {
+		if existing, ok := s.jobs[record.JobID]; ok {
+			return StoredJob{ID: record.JobID, Job: existing}, true, nil
+		}
+	}
+	if err := s.createCharged(quota, jobID, job); err != nil {
+		return StoredJob{}, false, err
+	}
+	s.keys[key.ID] = IdempotencyRecord{JobID: jobID, CreatedAt: NowISO8601(), DeleteAfter: key.DeleteAfter}
+	return StoredJob{ID: jobID, Job: job}, false, nil
+}
+
+func (s *EmbeddedJobStore) CreateDeduplicated(ctx context.Context, key JobDedupeKey, quota *JobQuota, jobID string, job Job) (StoredJob, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.dedupe[key.Hash]; ok && !isPastDeleteAfter(record.DeleteAfter) {
+		if existing, ok := s.jobs[record.JobID]; ok && reusableDedupedJob(existing) {
+			return StoredJob{ID: record.JobID, Job: existing}, true, nil
+		}
+	}
+	if err := s.createCharged(quota, jobID, job); err != nil {
+		return StoredJob{}, false, err
+	}
+	s.dedupe[key.Hash] = JobDedupeRecord{JobID: jobID, CreatedAt: NowISO8601(), DeleteAfter: key.DeleteAfter}
+	return StoredJob{ID: jobID, Job: job}, false, nil
+}
+
+func (s *EmbeddedJobStore) ReleaseIdempotencyKey(ctx context.Context, key IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key.ID)
+	return nil
+}
+
+func (s *EmbeddedJobStore) Get(ctx context.Context, jobID string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	return job, nil
+}
+
+func (s *EmbeddedJobStore) FindByResultToken(ctx context.Context, token string) (string, Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, job := range s.jobs {
+		if job.ResultToken != "" && job.ResultToken == token {
+			return id, job, nil
+		}
+	}
+	return "", Job{}, errJobNotFound
+}
+
+func (s *EmbeddedJobStore) Update(ctx context.Context, jobID string, fn func(job *Job) (bool, error)) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before, ok := s.jobs[jobID]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	job := before
+	changed, err := fn(&job)
+	if err != nil || !changed {
+		return job, err
+	}
+	s.jobs[jobID] = job
+	if err := s.persist(); err != nil {
+		s.jobs[jobID] = before
+		return before, err
+	}
+	return job, nil
+}
+
+func (s *EmbeddedJobStore) List(ctx context.Context, q JobQuery) ([]StoredJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []StoredJob
+	for id, job := range s.jobs {
+		if slices.Contains(q.Statuses, job.Status) {
+			jobs = append(jobs, StoredJob{ID: id, Job: job})
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Job.SubmittedAt != jobs[j].Job.SubmittedAt {
+			return jobs[i].Job.SubmittedAt < jobs[j].Job.SubmittedAt
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	if q.Limit > 0 && len(jobs) > q.Limit {
+		jobs = jobs[:q.Limit]
+	}
+	return jobs, nil
+}
+
+func (s *EmbeddedJobStore) Count(ctx context.Context, statuses []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, job := range s.jobs {
+		if slices.Contains(statuses, job.Status) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// DeleteExpired removes expired jobs in one batch. Archiving runs without the store locked;
+// jobs that changed meanwhile so they are no longer expired, or started running, are kept.
+func (s *EmbeddedJobStore) DeleteExpired(ctx context.Context, now time.Time, archive jobArchiveFunc) (JobCleanupResult, error) {
+	s.mu.Lock()
+	result := JobCleanupResult{Batches: 1, Complete: true}
+	for id, record := range s.keys {
+		if !record.DeleteAfter.IsZero() && !now.Before(record.DeleteAfter) {
+			delete(s.keys, id)
+		}
+	}
+	for hash, record := range s.dedupe {
+		if !record.DeleteAfter.IsZero() && !now.Before(record.DeleteAfter) {
+			delete(s.dedupe, hash)
+		}
+	}
+	for id, quota := range s.quotas {
+		if !now.Before(quota.deleteAfter) {
+			delete(s.quotas, id)
+		}
+	}
+	var expired []StoredJob
+	for id, job := range s.jobs {
+		if !embeddedJobExpired(job, now) {
+			continue
+		}
+		if slices.Contains(runningJobStatuses, job.Status) {
+			result.SkippedRunning++
+			continue
+		}
+		expired = append(expired, StoredJob{ID: id, Job: job})
+	}
+	s.mu.Unlock()
+	if len(expired) == 0 {
+		return result, nil
+	}
+	if archive != nil {
+		if err := archive(ctx, expired); err != nil {
+			return result, fmt.Errorf("failed to archive expired jobs: %w", err)
+		}
+		result.Archived = len(expired)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := make(map[string]Job, len(expired))
+	for _, stored := range expired {
+		job, ok := s.jobs[stored.ID]
+		if !ok {
+			continue
+		}
+		if !embeddedJobExpired(job, now) || slices.Contains(runningJobStatuses, job.Status) {
+			result.Failed++
+			continue
+		}
+		removed[stored.ID] = job
+		delete(s.jobs, stored.ID)
+	}
+	if len(removed) == 0 {
+		return result, nil
+	}
+	if err := s.persist(); err != nil {
+		for id, job := range removed {
+			s.jobs[id] = job
+		}
+		return JobCleanupResult{}, err
+	}
+	result.Deleted = len(removed)
+	for _, job := range removed {
+		if job.OutputR2Key != "" {
+			result.OutputKeys = append(result.OutputKeys, job.OutputR2Key)
+		}
+	}
+	return result, nil
+}
+
+// embeddedJobExpired reports whether a job's delete_after has passed at now.
+func embeddedJobExpired(job Job, now time.Time) bool {
+	return !job.DeleteAfter.IsZero() && !now.Before(job.DeleteAfter)
+}
+
+// runEmbeddedJobMaintenance runs the stale-jobs, stuck-jobs and job-expiry maintenance tasks
+// every interval until ctx is done. Embedded deployments serve no admin routes for a scheduler
+// to call them through.
+func (ac *ApiController) runEmbeddedJobMaintenance(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for name, task := range map[string]maintenanceTask{
+			"stale-jobs": ac.runStaleJobMaintenance,
+			"stuck-jobs": ac.runStuckJobMaintenance,
+			"job-expiry": ac.runJobExpiryMaintenance,
+		} {
+			if _, err := task(ctx); err != nil {
+				log.WithError(err).WithField("maintenance_task", name).Error("Maintenance task failed.")
+			}
+		}
+	}
+}
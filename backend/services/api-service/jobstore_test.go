@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateJobStore(t *testing.T) {
+	cfg := &AppConfig{JobStore: jobStoreFirestore, WorkspacesEnabled: true, RateLimiter: "firestore"}
+	assert.NoError(t, cfg.validateJobStore())
+
+	cfg = &AppConfig{JobStore: jobStoreEmbedded, WorkspacesEnabled: false, RateLimiter: "memory"}
+	assert.NoError(t, cfg.validateJobStore())
+
+	// Workspaces and the shared rate limiter need Firestore.
+	cfg.WorkspacesEnabled = true
+	assert.ErrorContains(t, cfg.validateJobStore(), "WORKSPACES_ENABLED=false")
+	cfg.WorkspacesEnabled, cfg.RateLimiter = false, "firestore"
+	assert.ErrorContains(t, cfg.validateJobStore(), "RATE_LIMITER=memory")
+
+	cfg = &AppConfig{JobStore: "sqlite"}
+	assert.ErrorContains(t, cfg.validateJobStore(), "JOB_STORE must be")
+}
+
+func TestEmbeddedJobStore_SnapshotSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "jobs.gob")
+
+	store, err := NewEmbeddedJobStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Create(ctx, "job-1", Job{Status: "queued", Code: "print(1)", Input: "x", ResultToken: "tok"}))
+	assert.Error(t, store.Create(ctx, "job-1", Job{Status: "queued"}), "job IDs are unique")
+	_, err = store.Update(ctx, "job-1", func(job *Job) (bool, error) {
+		job.Status, job.Output = "completed", "1\n"
+		return true, nil
+	})
+	assert.NoError(t, err)
+
+	reopened, err := NewEmbeddedJobStore(path)
+	assert.NoError(t, err)
+	job, err := reopened.Get(ctx, "job-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "completed", job.Status)
+	assert.Equal(t, "1\n", job.Output)
+	assert.Empty(t, job.Code, "code is never persisted")
+	assert.Empty(t, job.Input, "input is never persisted")
+
+	id, _, err := reopened.FindByResultToken(ctx, "tok")
+	assert.NoError(t, err)
+	assert.Equal(t, "job-1", id)
+	_, _, err = reopened.FindByResultToken(ctx, "other")
+	assert.ErrorIs(t, err, errJobNotFound)
+	_, err = reopened.Get(ctx, "missing")
+	assert.ErrorIs(t, err, errJobNotFound)
+}
+
+func TestEmbeddedJobStore_ListAndDeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	store, err := NewEmbeddedJobStore("")
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Create(ctx, "late", Job{Status: "processing_direct", SubmittedAt: "2026-03-01T11:50:00.000Z"}))
+	assert.NoError(t, store.Create(ctx, "early", Job{Status: "processing_direct", SubmittedAt: "2026-03-01T11:40:00.000Z"}))
+	assert.NoError(t, store.Create(ctx, "done", Job{Status: "completed", SubmittedAt: "2026-03-01T11:30:00.000Z", DeleteAfter: now.Add(-time.Minute)}))
+	assert.NoError(t, store.Create(ctx, "pinned", Job{Status: "completed", SubmittedAt: "2026-03-01T11:30:00.000Z"}))
+
+	jobs, err := store.List(ctx, JobQuery{Statuses: runningJobStatuses})
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 2) {
+		assert.Equal(t, "early", jobs[0].ID, "oldest submission first")
+	}
+	jobs, err = store.List(ctx, JobQuery{Statuses: runningJobStatuses, Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	assert.NoError(t, store.Create(ctx, "stuck", Job{Status: "processing_direct", SubmittedAt: "2026-03-01T11:30:00.000Z", DeleteAfter: now.Add(-time.Minute)}))
+	result, err := store.DeleteExpired(ctx, now, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, JobCleanupResult{Deleted: 1, SkippedRunning: 1, Batches: 1, Complete: true}, result)
+	_, err = store.Get(ctx, "done")
+	assert.ErrorIs(t, err, errJobNotFound)
+	_, err = store.Get(ctx, "pinned")
+	assert.NoError(t, err, "jobs without delete_after are kept")
+	_, err = store.Get(ctx, "stuck")
+	assert.NoError(t, err, "running jobs are kept")
+}
+
+// newEmbeddedHarness serves the public execution and worker endpoints from an embedded job
+// store, with no Firestore client at all.
+func newEmbeddedHarness(t *testing.T) (*gin.Engine, *ApiController, *fakeTaskEnqueuer) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	limits := defaultPythonLimits
+	cfg := &AppConfig{
+		JobStore:     jobStoreEmbedded,
+		JobStorePath: filepath.Join(t.TempDir(), "jobs.gob"),
+		RateLimiter:  "memory",
+		Services: ServicesConfig{
+			PythonWorker: ServiceConfig{QueueID: "python-queue", ServiceURL: "https://python-worker.test", Limits: &limits},
+		},
+		Retention:               RetentionConfig{Jobs: time.Hour, IdempotencyKeys: time.Hour},
+		JobDeadlineMin:          10 * time.Second,
+		JobHeartbeatTimeout:     time.Minute,
+		JobOutputInlineBytes:    256 << 10,
+		JobDeadLetterRetryCount: 4,
+		JobQueuedTTL:            time.Hour,
+	}
+	assert.NoError(t, cfg.validateJobStore())
+
+	jobs, err := openJobStore(cfg, nil)
+	if err != nil {
+		t.Fatalf("openJobStore: %v", err)
+	}
+	tasks := &fakeTaskEnqueuer{}
+	background := NewBackgroundRunner(1, 4, 5*time.Second)
+	t.Cleanup(func() { background.Shutdown(context.Background()) })
+	ac := NewApiController(nil, tasks, &ObjectStore{}, "", cfg, "", background)
+	ac.Jobs = jobs
+
+	r := gin.New()
+	r.POST("/api/execute", ac.ExecuteCode)
+	r.POST("/api/execute/batch", ac.ExecuteCodeBatch)
+	r.GET("/api/jobs/:jobId", ac.GetJob)
+	r.GET("/api/results/:resultToken", ac.GetSharedResult)
+	r.GET("/api/execute/result/:jobId", ac.GetClaimedResult)
+	r.DELETE("/api/execute/:jobId", ac.CancelPublicJob)
+	r.POST("/internal/jobs/:jobId/finished", ac.HandleJobFinished)
+	r.POST("/internal/jobs/:jobId/heartbeat", ac.HandleJobHeartbeat)
+	r.POST("/internal/jobs/:jobId/status", ac.HandleJobStatus)
+	r.POST("/internal/jobs/:jobId/dead-letter", ac.HandleJobDeadLetter)
+	return r, ac, tasks
+}
+
+// serveJSON sends a JSON request to r and decodes the response into out when it is non-nil.
+func serveJSON(r *gin.Engine, method, path string, body, out interface{}) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	w := serveRequest(r, req)
+	if out != nil {
+		json.Unmarshal(w.Body.Bytes(), out)
+	}
+	return w
+}
+
+func serveRequest(r *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestEmbeddedJobStore_PublicExecutionEndToEnd(t *testing.T) {
+	r, _, tasks := newEmbeddedHarness(t)
+
+	var submitted map[string]string
+	w := serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	jobID, token := submitted["job_id"], submitted["result_token"]
+	assert.Len(t, tasks.tasksTo("/execute"), 1)
+
+	var status JobStatusResponse
+	w = serveJSON(r, http.MethodGet, "/api/jobs/"+jobID, nil, &status)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "queued", status.Status)
+
+	w = serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/heartbeat", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The worker cannot write the job itself, so it reports the result in the body.
+	w = serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/finished", JobResultReport{Status: "completed", Output: "1\n"}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	w = serveJSON(r, http.MethodGet, "/api/jobs/"+jobID, nil, &status)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "completed", status.Status)
+	assert.Equal(t, "1\n", status.Output)
+	assert.NotEmpty(t, status.CompletedAt)
+
+	var shared PublicResultResponse
+	w = serveJSON(r, http.MethodGet, "/api/results/"+token, nil, &shared)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "completed", shared.Status)
+	assert.Equal(t, "1\n", shared.Output)
+
+	// A finished job keeps its result and tells a late worker to stop.
+	w = serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/finished", JobResultReport{Status: "failed", Error: "late"}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	serveJSON(r, http.MethodGet, "/api/jobs/"+jobID, nil, &status)
+	assert.Equal(t, "completed", status.Status)
+	w = serveJSON(r, http.MethodPost, "/internal/jobs/"+jobID+"/heartbeat", nil, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = serveJSON(r, http.MethodGet, "/api/jobs/unknown", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serveJSON(r, http.MethodPost, "/internal/jobs/unknown/finished", JobResultReport{Status: "completed"}, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResolvedExecutionLimits are the limits that apply to one run after request overrides.
+type ResolvedExecutionLimits struct {
+	TimeoutSeconds       int
+	MemoryMb             int
+	MaxCodeBytes         int
+	EntrypointExtensions []string
+}
+
+// LanguageInfo describes an executable language for GET /api/languages.
+type LanguageInfo struct {
+	Language string          `json:"language"`
+	Limits   ExecutionLimits `json:"limits"`
+	// SupportsInputFile reports whether inputFilePath can be used with this language.
+	SupportsInputFile bool `json:"supportsInputFile"`
+	// SupportsDependencies reports whether the worker installs dependencies declared in one of
+	// DependencyManifests before a workspace run.
+	SupportsDependencies bool     `json:"supportsDependencies"`
+	DependencyManifests  []string `json:"dependencyManifests,omitempty"`
+}
+
+// resolveExecutionLimits applies a request's optional timeout and memory overrides on top of
+// the language's configured defaults. Overrides above the configured maxima are rejected
+// rather than clamped so callers learn the real cap.
+func (ac *ApiController) resolveExecutionLimits(language string, req ExecutionOptions) (ResolvedExecutionLimits, error) {
+	worker, ok := ac.Services.WorkerFor(language)
+	if !ok || worker.Limits == nil {
+		return ResolvedExecutionLimits{}, fmt.Errorf("unsupported language: %s", language)
+	}
+	limits := worker.Limits
+
+	resolved := ResolvedExecutionLimits{
+		TimeoutSeconds:       limits.DefaultTimeoutSeconds,
+		MemoryMb:             limits.DefaultMemoryMb,
+		MaxCodeBytes:         limits.MaxCodeBytes,
+		EntrypointExtensions: limits.EntrypointExtensions,
+	}
+
+	if req.TimeoutSeconds < 0 || req.TimeoutSeconds > limits.MaxTimeoutSeconds {
+		return ResolvedExecutionLimits{}, fmt.Errorf("timeoutSeconds must be between 1 and %d for %s", limits.MaxTimeoutSeconds, language)
+	}
+	if req.TimeoutSeconds > 0 {
+		resolved.TimeoutSeconds = req.TimeoutSeconds
+	}
+
+	if req.MemoryMb < 0 || req.MemoryMb > limits.MaxMemoryMb {
+		return ResolvedExecutionLimits{}, fmt.Errorf("memoryMb must be between 1 and %d for %s", limits.MaxMemoryMb, language)
+	}
+	if req.MemoryMb > 0 {
+		resolved.MemoryMb = req.MemoryMb
+	}
+
+	return resolved, nil
+}
+
+// allowsEntrypoint reports whether the entrypoint's extension is runnable for the language.
+func (l ResolvedExecutionLimits) allowsEntrypoint(entrypoint string) bool {
+	return slices.Contains(l.EntrypointExtensions, filepath.Ext(entrypoint))
+}
+
+// ListLanguages returns the executable languages with their default and maximum limits.
+func (ac *ApiController) ListLanguages(c *gin.Context) {
+	languages := make([]LanguageInfo, 0, len(supportedLanguages))
+	for _, language := range supportedLanguages {
+		worker, ok := ac.Services.WorkerFor(language)
+		if !ok || worker.Limits == nil {
+			continue
+		}
+		languages = append(languages, LanguageInfo{
+			Language:             language,
+			Limits:               *worker.Limits,
+			SupportsInputFile:    inputFileLanguages[language],
+			SupportsDependencies: len(worker.DependencyManifests) > 0,
+			DependencyManifests:  worker.DependencyManifests,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"languages": languages})
+}
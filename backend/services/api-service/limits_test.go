@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func limitsTestController() *ApiController {
+	limits := defaultPythonLimits
+	return &ApiController{Services: ServicesConfig{PythonWorker: ServiceConfig{Limits: &limits}}}
+}
+
+func TestExecutionLimitsValidate(t *testing.T) {
+	valid := defaultPythonLimits
+	assert.NoError(t, valid.validate())
+
+	defaultAboveMax := defaultPythonLimits
+	defaultAboveMax.DefaultTimeoutSeconds = defaultAboveMax.MaxTimeoutSeconds + 1
+	assert.Error(t, defaultAboveMax.validate())
+
+	noExtensions := defaultPythonLimits
+	noExtensions.EntrypointExtensions = nil
+	assert.Error(t, noExtensions.validate())
+
+	badExtension := defaultPythonLimits
+	badExtension.EntrypointExtensions = []string{"py"}
+	assert.Error(t, badExtension.validate())
+}
+
+func TestResolveExecutionLimits(t *testing.T) {
+	ac := limitsTestController()
+
+	resolved, err := ac.resolveExecutionLimits("python", ExecutionOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultPythonLimits.DefaultTimeoutSeconds, resolved.TimeoutSeconds)
+	assert.Equal(t, defaultPythonLimits.DefaultMemoryMb, resolved.MemoryMb)
+
+	resolved, err = ac.resolveExecutionLimits("python", ExecutionOptions{TimeoutSeconds: 45, MemoryMb: 512})
+	assert.NoError(t, err)
+	assert.Equal(t, 45, resolved.TimeoutSeconds)
+	assert.Equal(t, 512, resolved.MemoryMb)
+
+	_, err = ac.resolveExecutionLimits("python", ExecutionOptions{TimeoutSeconds: defaultPythonLimits.MaxTimeoutSeconds + 1})
+	assert.Error(t, err)
+
+	_, err = ac.resolveExecutionLimits("python", ExecutionOptions{MemoryMb: defaultPythonLimits.MaxMemoryMb + 1})
+	assert.Error(t, err)
+
+	_, err = ac.resolveExecutionLimits("cobol", ExecutionOptions{})
+	assert.Error(t, err)
+}
+
+func TestResolvedLimitsAllowsEntrypoint(t *testing.T) {
+	resolved, err := limitsTestController().resolveExecutionLimits("python", ExecutionOptions{})
+	assert.NoError(t, err)
+	assert.True(t, resolved.allowsEntrypoint("src/main.py"))
+	assert.False(t, resolved.allowsEntrypoint("README.md"))
+	assert.False(t, resolved.allowsEntrypoint("Makefile"))
+}
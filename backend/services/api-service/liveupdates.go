@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxLiveSubscriptions caps the jobs and workspaces one connection follows, each of
+	// which holds a Firestore listener.
+	maxLiveSubscriptions = 25
+
+	liveAuthTimeout  = 10 * time.Second // The auth message must arrive within this time
+	livePingInterval = 30 * time.Second
+	livePongTimeout  = 2 * livePingInterval // No pong for this long closes the connection
+	liveWriteTimeout = 10 * time.Second
+	liveMaxMessage   = 4 << 10
+)
+
+// Client messages on /api/ws.
+const (
+	liveMsgAuth        = "auth"
+	liveMsgSubscribe   = "subscribe"
+	liveMsgUnsubscribe = "unsubscribe"
+)
+
+// Server frames on /api/ws.
+const (
+	liveFrameReady        = "ready"
+	liveFrameSubscribed   = "subscribed"
+	liveFrameUnsubscribed = "unsubscribed"
+	liveFrameJob          = "job"
+	liveFrameWorkspace    = "workspace"
+	liveFrameError        = "error"
+)
+
+// liveUpgrader accepts every origin: the connection is authenticated by the ID token in its
+// first message, never by cookies, so a cross-site page gains nothing by opening one.
+var liveUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(*http.Request) bool { return true },
+}
+
+// LiveMessage is a client message on /api/ws. Subscribe and unsubscribe name exactly one
+// of JobID and WorkspaceID.
+type LiveMessage struct {
+	Type        string `json:"type"`
+	Token       string `json:"token,omitempty"`
+	JobID       string `json:"jobId,omitempty"`
+	WorkspaceID string `json:"workspaceId,omitempty"`
+}
+
+// LiveFrame is a server frame on /api/ws. Job frames carry the job's current state and
+// workspace frames its current version; error frames that refer to a subscription name it.
+type LiveFrame struct {
+	Type             string    `json:"type"`
+	JobID            string    `json:"jobId,omitempty"`
+	WorkspaceID      string    `json:"workspaceId,omitempty"`
+	Job              *JobEvent `json:"job,omitempty"`
+	WorkspaceVersion string    `json:"workspaceVersion,omitempty"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// liveTopic is something a connection can subscribe to.
+type liveTopic struct {
+	JobID, WorkspaceID string
+}
+
+// liveTopicFor validates the target of a subscribe or unsubscribe message.
+func liveTopicFor(msg LiveMessage) (liveTopic, error) {
+	if (msg.JobID == "") == (msg.WorkspaceID == "") {
+		return liveTopic{}, errors.New("exactly one of jobId and workspaceId is required")
+	}
+	return liveTopic{JobID: msg.JobID, WorkspaceID: msg.WorkspaceID}, nil
+}
+
+// verifyFirebaseIDToken returns the user ID of a Firebase ID token.
+func verifyFirebaseIDToken(ctx context.Context, idToken string) (string, error) {
+	if firebaseApp == nil {
+		return "", errors.New("firebase app not initialized")
+	}
+	client, err := firebaseApp.Auth(ctx)
+	if err != nil {
+		return "", err
+	}
+	token, err := client.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return "", err
+	}
+	if token.UID == "" {
+		return "", errors.New("token UID is empty")
+	}
+	return token.UID, nil
+}
+
+// liveConn is one /api/ws connection. The read loop owns subs; listeners only write frames.
+type liveConn struct {
+	ac     *ApiController
+	conn   *websocket.Conn
+	ctx    context.Context
+	userID string
+	logCtx *log.Entry
+
+	writeMu   sync.Mutex
+	subsMu    sync.Mutex
+	subs      map[liveTopic]*liveSubscription
+	listeners sync.WaitGroup
+}
+
+// liveSubscription is one topic's listener; cancel stops it.
+type liveSubscription struct {
+	cancel context.CancelFunc
+}
+
+// ServeLiveUpdates upgrades to a WebSocket over which a client follows jobs and workspaces.
+// The first message must be {"type":"auth","token":<Firebase ID token>}; after the "ready"
+// frame the client sends subscribe and unsubscribe messages. Job subscriptions follow the
+// job's read policy and end after the frame for a finished job; workspace subscriptions
+// need membership and send a frame whenever the workspace version changes.
+func (ac *ApiController) ServeLiveUpdates(c *gin.Context) {
+	conn, err := liveUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written the error response.
+		log.WithError(err).Warn("WebSocket upgrade failed.")
+		return
+	}
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	lc := &liveConn{
+		ac:     ac,
+		conn:   conn,
+		ctx:    ctx,
+		logCtx: log.WithField("handler", "ServeLiveUpdates"),
+		subs:   make(map[liveTopic]*liveSubscription),
+	}
+	defer func() {
+		cancel()
+		lc.listeners.Wait()
+		conn.Close()
+	}()
+
+	conn.SetReadLimit(liveMaxMessage)
+	if err := lc.authenticate(); err != nil {
+		lc.logCtx.WithError(err).Info("WebSocket authentication failed.")
+		lc.closeWith(websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+	lc.logCtx = lc.logCtx.WithField("user_id", lc.userID)
+
+	conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	})
+	lc.listeners.Add(1)
+	go lc.ping()
+
+	for {
+		var msg LiveMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				lc.logCtx.WithError(err).Debug("WebSocket read ended.")
+			}
+			return
+		}
+		lc.handle(msg)
+	}
+}
+
+// authenticate reads the auth message and verifies its token.
+func (lc *liveConn) authenticate() error {
+	lc.conn.SetReadDeadline(time.Now().Add(liveAuthTimeout))
+	var msg LiveMessage
+	if err := lc.conn.ReadJSON(&msg); err != nil {
+		return fmt.Errorf("no auth message: %w", err)
+	}
+	if msg.Type != liveMsgAuth || msg.Token == "" {
+		return errors.New("the first message must be an auth message with a token")
+	}
+	userID, err := lc.ac.verifyIDToken(lc.ctx, msg.Token)
+	if err != nil {
+		return errors.New("invalid token")
+	}
+	lc.userID = userID
+	return lc.send(LiveFrame{Type: liveFrameReady})
+}
+
+func (lc *liveConn) handle(msg LiveMessage) {
+	if msg.Type != liveMsgSubscribe && msg.Type != liveMsgUnsubscribe {
+		lc.sendError(liveTopic{}, fmt.Sprintf("unknown message type %q", msg.Type))
+		return
+	}
+	topic, err := liveTopicFor(msg)
+	if err != nil {
+		lc.sendError(liveTopic{}, err.Error())
+		return
+	}
+	if msg.Type == liveMsgUnsubscribe {
+		lc.unsubscribe(topic)
+		lc.send(LiveFrame{Type: liveFrameUnsubscribed, JobID: topic.JobID, WorkspaceID: topic.WorkspaceID})
+		return
+	}
+	lc.subscribe(topic)
+}
+
+func (lc *liveConn) subscribe(topic liveTopic) {
+	lc.subsMu.Lock()
+	_, exists := lc.subs[topic]
+	count := len(lc.subs)
+	lc.subsMu.Unlock()
+	if exists {
+		lc.send(LiveFrame{Type: liveFrameSubscribed, JobID: topic.JobID, WorkspaceID: topic.WorkspaceID})
+		return
+	}
+	if count >= maxLiveSubscriptions {
+		lc.sendError(topic, fmt.Sprintf("a connection can have at most %d subscriptions", maxLiveSubscriptions))
+		return
+	}
+
+	allowed, err := lc.canSubscribe(topic)
+	if err != nil {
+		lc.logCtx.WithError(err).WithFields(log.Fields{"job_id": topic.JobID, "workspace_id": topic.WorkspaceID}).Error("Live subscription check failed.")
+		lc.sendError(topic, "Failed to verify access")
+		return
+	}
+	if !allowed {
+		// Same answer for a missing job and one the user may not read, as for GET /jobs/:jobId.
+		lc.sendError(topic, "Not found or access denied")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(lc.ctx)
+	sub := &liveSubscription{cancel: cancel}
+	lc.subsMu.Lock()
+	lc.subs[topic] = sub
+	lc.subsMu.Unlock()
+	lc.send(LiveFrame{Type: liveFrameSubscribed, JobID: topic.JobID, WorkspaceID: topic.WorkspaceID})
+
+	lc.listeners.Add(1)
+	go func() {
+		defer lc.listeners.Done()
+		var err error
+		if topic.JobID != "" {
+			err = lc.followJob(ctx, topic.JobID)
+		} else {
+			err = lc.followWorkspace(ctx, topic.WorkspaceID)
+		}
+		if err != nil && ctx.Err() == nil {
+			lc.logCtx.WithError(err).WithFields(log.Fields{"job_id": topic.JobID, "workspace_id": topic.WorkspaceID}).Warn("Live subscription failed.")
+			lc.sendError(topic, "Subscription ended unexpectedly")
+		}
+		lc.remove(topic, sub)
+	}()
+}
+
+func (lc *liveConn) unsubscribe(topic liveTopic) {
+	lc.subsMu.Lock()
+	sub := lc.subs[topic]
+	lc.subsMu.Unlock()
+	if sub != nil {
+		lc.remove(topic, sub)
+	}
+}
+
+// remove stops sub and frees its slot unless the topic has been subscribed again since.
+func (lc *liveConn) remove(topic liveTopic, sub *liveSubscription) {
+	sub.cancel()
+	lc.subsMu.Lock()
+	defer lc.subsMu.Unlock()
+	if lc.subs[topic] == sub {
+		delete(lc.subs, topic)
+	}
+}
+
+// canSubscribe applies the job's read policy, or workspace membership.
+func (lc *liveConn) canSubscribe(topic liveTopic) (bool, error) {
+	if topic.WorkspaceID != "" {
+		return checkWorkspaceMembership(lc.ctx, lc.ac.FirestoreClient, lc.userID, topic.WorkspaceID)
+	}
+	job, err := lc.ac.Jobs.Get(lc.ctx, topic.JobID)
+	if errors.Is(err, errJobNotFound) || (err == nil && isPastDeleteAfter(job.DeleteAfter)) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return canReadJob(lc.ctx, jobReader{UserID: lc.userID}, job, lc.ac.isWorkspaceMember)
+}
+
+// followJob sends a job frame for the job's current state and each change, as
+// sendJobEvents does, until the job finishes.
+func (lc *liveConn) followJob(ctx context.Context, jobID string) error {
+	snapshots := lc.ac.FirestoreClient.Collection(lc.ac.FirestoreJobsCollection).Doc(jobID).Snapshots(ctx)
+	defer snapshots.Stop()
+
+	var last *JobEvent
+	for {
+		snap, err := nextLiveSnapshot(ctx, snapshots)
+		if err != nil || snap == nil {
+			return err
+		}
+		if !snap.Exists() {
+			return lc.sendError(liveTopic{JobID: jobID}, "Job was deleted")
+		}
+		var job Job
+		if err := snap.DataTo(&job); err != nil {
+			return err
+		}
+		event := jobEventFor(jobID, job)
+		if last == nil || *last != event {
+			if err := lc.send(LiveFrame{Type: liveFrameJob, JobID: jobID, Job: &event}); err != nil {
+				return err
+			}
+			last = &event
+		}
+		if isTerminalJobStatus(job.Status) {
+			return nil
+		}
+	}
+}
+
+// followWorkspace sends a workspace frame for the current version and each version bump.
+func (lc *liveConn) followWorkspace(ctx context.Context, workspaceID string) error {
+	snapshots := lc.ac.FirestoreClient.Collection("workspaces").Doc(workspaceID).Snapshots(ctx)
+	defer snapshots.Stop()
+
+	sent, lastVersion := false, ""
+	for {
+		snap, err := nextLiveSnapshot(ctx, snapshots)
+		if err != nil || snap == nil {
+			return err
+		}
+		var workspace Workspace
+		if !snap.Exists() || snap.DataTo(&workspace) != nil || workspace.DeletedAt != "" {
+			return lc.sendError(liveTopic{WorkspaceID: workspaceID}, "Workspace was deleted")
+		}
+		if sent && workspace.WorkspaceVersion == lastVersion {
+			continue
+		}
+		if err := lc.send(LiveFrame{Type: liveFrameWorkspace, WorkspaceID: workspaceID, WorkspaceVersion: workspace.WorkspaceVersion}); err != nil {
+			return err
+		}
+		sent, lastVersion = true, workspace.WorkspaceVersion
+	}
+}
+
+// nextLiveSnapshot returns the next snapshot, or nil without an error once ctx is done.
+func nextLiveSnapshot(ctx context.Context, it *docstore.DocumentSnapshotIterator) (*docstore.DocumentSnapshot, error) {
+	snap, err := it.Next()
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled {
+		return nil, nil
+	}
+	return snap, err
+}
+
+// send writes one frame. gorilla/websocket allows one concurrent writer, so listeners take
+// turns.
+func (lc *liveConn) send(frame LiveFrame) error {
+	lc.writeMu.Lock()
+	defer lc.writeMu.Unlock()
+	lc.conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+	return lc.conn.WriteJSON(frame)
+}
+
+func (lc *liveConn) sendError(topic liveTopic, message string) error {
+	return lc.send(LiveFrame{Type: liveFrameError, JobID: topic.JobID, WorkspaceID: topic.WorkspaceID, Error: message})
+}
+
+func (lc *liveConn) closeWith(code int, reason string) {
+	lc.writeMu.Lock()
+	defer lc.writeMu.Unlock()
+	lc.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(liveWriteTimeout))
+}
+
+// ping keeps the connection alive through proxies; a missing pong trips the read deadline.
+func (lc *liveConn) ping() {
+	defer lc.listeners.Done()
+	ticker := time.NewTicker(livePingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lc.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := lc.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveTopicFor(t *testing.T) {
+	topic, err := liveTopicFor(LiveMessage{Type: liveMsgSubscribe, JobID: "job-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, liveTopic{JobID: "job-1"}, topic)
+
+	_, err = liveTopicFor(LiveMessage{Type: liveMsgSubscribe})
+	assert.Error(t, err)
+	_, err = liveTopicFor(LiveMessage{Type: liveMsgSubscribe, JobID: "job-1", WorkspaceID: "ws-1"})
+	assert.Error(t, err)
+}
+
+// dialLive opens /api/ws on h and authenticates as userID.
+func dialLive(t *testing.T, h *handlerHarness, userID string) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(h.router)
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	assert.NoError(t, conn.WriteJSON(LiveMessage{Type: liveMsgAuth, Token: userID}))
+	assert.Equal(t, liveFrameReady, readLiveFrame(t, conn).Type)
+	return conn
+}
+
+func readLiveFrame(t *testing.T, conn *websocket.Conn) LiveFrame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame LiveFrame
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	return frame
+}
+
+func TestHandlers_LiveUpdates(t *testing.T) {
+	h := newHandlerHarness(t)
+	// Tokens are user IDs; "bad" is rejected.
+	h.ac.verifyIDToken = func(_ context.Context, token string) (string, error) {
+		if token == "bad" {
+			return "", errors.New("invalid")
+		}
+		return token, nil
+	}
+	owner, outsider := "owner-"+uuid.New().String(), "outsider-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+
+	var submitted ExecuteAuthResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute", owner, ExecuteAuthRequest{Language: "python", EntrypointFile: "main.py"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	conn := dialLive(t, h, owner)
+	assert.NoError(t, conn.WriteJSON(LiveMessage{Type: liveMsgSubscribe, JobID: submitted.JobID}))
+	assert.Equal(t, LiveFrame{Type: liveFrameSubscribed, JobID: submitted.JobID}, readLiveFrame(t, conn))
+	frame := readLiveFrame(t, conn)
+	if assert.Equal(t, liveFrameJob, frame.Type) && assert.NotNil(t, frame.Job) {
+		assert.Equal(t, "queued", frame.Job.Status)
+	}
+	assert.NoError(t, conn.WriteJSON(LiveMessage{Type: liveMsgSubscribe, WorkspaceID: workspaceID}))
+	assert.Equal(t, LiveFrame{Type: liveFrameSubscribed, WorkspaceID: workspaceID}, readLiveFrame(t, conn))
+	assert.Equal(t, liveFrameWorkspace, readLiveFrame(t, conn).Type)
+
+	_, err := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(submitted.JobID).Update(context.Background(), []docstore.Update{
+		{Path: "status", Value: "completed"},
+		{Path: "output", Value: "hi\n"},
+		{Path: "completed_at", Value: NowISO8601()},
+	})
+	assert.NoError(t, err)
+	frame = readLiveFrame(t, conn)
+	if assert.Equal(t, liveFrameJob, frame.Type) && assert.NotNil(t, frame.Job) {
+		assert.Equal(t, "completed", frame.Job.Status)
+		assert.Equal(t, "hi\n", frame.Job.Output)
+	}
+
+	_, err = h.fs.Collection("workspaces").Doc(workspaceID).Update(context.Background(), []docstore.Update{{Path: "workspace_version", Value: "v-next"}})
+	assert.NoError(t, err)
+	assert.Equal(t, LiveFrame{Type: liveFrameWorkspace, WorkspaceID: workspaceID, WorkspaceVersion: "v-next"}, readLiveFrame(t, conn))
+
+	// Outsiders cannot follow the workspace or its jobs.
+	other := dialLive(t, h, outsider)
+	assert.NoError(t, other.WriteJSON(LiveMessage{Type: liveMsgSubscribe, WorkspaceID: workspaceID}))
+	assert.Equal(t, LiveFrame{Type: liveFrameError, WorkspaceID: workspaceID, Error: "Not found or access denied"}, readLiveFrame(t, other))
+	assert.NoError(t, other.WriteJSON(LiveMessage{Type: liveMsgSubscribe, JobID: submitted.JobID}))
+	assert.Equal(t, liveFrameError, readLiveFrame(t, other).Type)
+
+	// Subscriptions are capped per connection.
+	for i := 0; i < maxLiveSubscriptions; i++ {
+		assert.NoError(t, other.WriteJSON(LiveMessage{Type: liveMsgSubscribe, WorkspaceID: h.createWorkspace(outsider)}))
+		assert.Equal(t, liveFrameSubscribed, readLiveFrame(t, other).Type)
+		assert.Equal(t, liveFrameWorkspace, readLiveFrame(t, other).Type)
+	}
+	assert.NoError(t, other.WriteJSON(LiveMessage{Type: liveMsgSubscribe, WorkspaceID: h.createWorkspace(outsider)}))
+	frame = readLiveFrame(t, other)
+	assert.Equal(t, liveFrameError, frame.Type)
+	assert.Contains(t, frame.Error, "at most")
+}
+
+func TestHandlers_LiveUpdatesRequireAuth(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.verifyIDToken = func(context.Context, string) (string, error) { return "", errors.New("invalid") }
+	server := httptest.NewServer(h.router)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	assert.NoError(t, conn.WriteJSON(LiveMessage{Type: liveMsgAuth, Token: "bad"}))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "got %v", err)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Optional request log fields, selected with LOG_REQUEST_FIELDS. The status, latency, client
+// IP, method, path, trace, query and error fields are always logged.
+const (
+	logFieldUserID    = "user_id"    // Set once AuthMiddleware has run
+	logFieldRequestID = "request_id" // See requestIDHeader
+	logFieldCosts     = "costs"      // firestore_reads, firestore_writes and r2_ops; see RequestCosts
+	logFieldBodySizes = "body_sizes" // request_bytes and response_bytes
+)
+
+var requestLogFieldNames = []string{logFieldUserID, logFieldRequestID, logFieldCosts, logFieldBodySizes}
+
+// A request ID sent in X-Request-Id is kept when it looks like one; otherwise a new one is
+// generated. Either way it is echoed back and logged, so client reports can be matched up.
+const (
+	requestIDHeader = "X-Request-Id"
+	requestIDKey    = "requestID"
+)
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestLogOptions configures RequestLogger.
+type RequestLogOptions struct {
+	// Logger receives the entries; nil means the standard logrus logger.
+	Logger *log.Logger
+	// Fields lists the optional fields to log; see logFieldUserID and friends.
+	Fields []string
+	// PathLevels sets the level of successful requests to a path, e.g. debug for health
+	// checks. A key ending in "*" matches paths with that prefix. Warnings and errors are
+	// never lowered.
+	PathLevels map[string]log.Level
+	// Requests slower than SlowThreshold are logged as warnings (0 disables).
+	SlowThreshold time.Duration
+	// Requests reading more Firestore documents than this are logged as warnings (0 disables).
+	FirestoreReadWarnThreshold int
+}
+
+// requestLogOptions builds the request logger options from the configuration.
+func (cfg *AppConfig) requestLogOptions() RequestLogOptions {
+	return RequestLogOptions{
+		Fields:                     cfg.RequestLogFields,
+		PathLevels:                 cfg.RequestLogPathLevels,
+		SlowThreshold:              cfg.SlowRequestThreshold,
+		FirestoreReadWarnThreshold: cfg.FirestoreReadWarnThreshold,
+	}
+}
+
+// parseRequestLogFields parses a comma-separated list of optional request log fields.
+func parseRequestLogFields(raw string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(raw, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		known := false
+		for _, name := range requestLogFieldNames {
+			known = known || field == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown request log field %q (known: %s)", field, strings.Join(requestLogFieldNames, ", "))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// parsePathLogLevels parses comma-separated path=level pairs, e.g. "/healthz=debug".
+func parsePathLogLevels(raw string) (map[string]log.Level, error) {
+	levels := make(map[string]log.Level)
+	for _, pair := range strings.Split(raw, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		path, name, ok := strings.Cut(pair, "=")
+		path = strings.TrimSpace(path)
+		if !ok || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid path log level %q; expected /path=level", pair)
+		}
+		level, err := log.ParseLevel(strings.TrimSpace(name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid path log level %q: %w", pair, err)
+		}
+		levels[path] = level
+	}
+	return levels, nil
+}
+
+// pathLogLevel returns the level override for path, preferring an exact match over the
+// longest matching prefix.
+func pathLogLevel(levels map[string]log.Level, path string) (log.Level, bool) {
+	if level, ok := levels[path]; ok {
+		return level, true
+	}
+	best, found := "", false
+	var bestLevel log.Level
+	for key, level := range levels {
+		prefix, ok := strings.CutSuffix(key, "*")
+		if ok && strings.HasPrefix(path, prefix) && (!found || len(prefix) > len(best)) {
+			best, bestLevel, found = prefix, level, true
+		}
+	}
+	return bestLevel, found
+}
+
+// requestLogLevel picks the level and message of a request's completion entry. Server and
+// client errors, excessive reads and slow requests are always logged at error or warn; the
+// path override only applies to requests that would otherwise be logged at info.
+func requestLogLevel(opts RequestLogOptions, path string, statusCode int, costWarning, slow bool) (log.Level, string) {
+	switch {
+	case statusCode >= 500:
+		return log.ErrorLevel, "Request completed with server error"
+	case statusCode >= 400:
+		return log.WarnLevel, "Request completed with client error"
+	case costWarning:
+		return log.WarnLevel, "Request completed with excessive Firestore reads"
+	case slow:
+		return log.WarnLevel, "Request completed slowly"
+	}
+	if level, ok := pathLogLevel(opts.PathLevels, path); ok && level > log.InfoLevel {
+		return level, "Request completed"
+	}
+	return log.InfoLevel, "Request completed"
+}
+
+// requestID returns the caller's X-Request-Id when it is well formed, or a new one.
+func requestID(header string) string {
+	if requestIDPattern.MatchString(header) {
+		return header
+	}
+	return uuid.New().String()
+}
+
+// RequestLogger logs one entry per request when it completes. It must run after
+// ClientIPMiddleware and RequestCostMiddleware; the user ID is read after the handler, so
+// routes behind AuthMiddleware are attributed even though it runs later.
+func RequestLogger(opts RequestLogOptions) gin.HandlerFunc {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+	include := make(map[string]bool, len(opts.Fields))
+	for _, field := range opts.Fields {
+		include[field] = true
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		raw := c.Request.URL.RawQuery
+		if include[logFieldRequestID] {
+			id := requestID(c.GetHeader(requestIDHeader))
+			c.Set(requestIDKey, id)
+			c.Header(requestIDHeader, id)
+		}
+		c.Next()
+		latency := time.Since(start)
+		statusCode := c.Writer.Status()
+		logFields := log.Fields{
+			"status_code": statusCode,
+			"latency_ms":  latency.Milliseconds(),
+			"client_ip":   RealClientIP(c),
+			"method":      c.Request.Method,
+			"path":        path,
+			"trace_id":    c.Request.Header.Get("X-Cloud-Trace-Context"),
+		}
+		if raw != "" {
+			logFields["query"] = raw
+		}
+		if len(c.Errors) > 0 {
+			logFields["error"] = c.Errors.String()
+		}
+		if userID := c.GetString("userID"); include[logFieldUserID] && userID != "" {
+			logFields["user_id"] = userID
+		}
+		if include[logFieldRequestID] {
+			logFields["request_id"] = c.GetString(requestIDKey)
+		}
+		if include[logFieldBodySizes] {
+			if c.Request.ContentLength >= 0 {
+				logFields["request_bytes"] = c.Request.ContentLength
+			}
+			logFields["response_bytes"] = max(c.Writer.Size(), 0)
+		}
+		costFields, costWarning := costLogFields(requestCostsFrom(c.Request.Context()), opts.FirestoreReadWarnThreshold)
+		if include[logFieldCosts] {
+			for k, v := range costFields {
+				logFields[k] = v
+			}
+		}
+		if costWarning {
+			logFields["cost_warning"] = true
+		}
+		slow := opts.SlowThreshold > 0 && latency >= opts.SlowThreshold
+		if slow {
+			logFields["slow"] = true
+		}
+
+		level, msg := requestLogLevel(opts, path, statusCode, costWarning, slow)
+		logger.WithFields(logFields).Log(level, msg)
+	}
+}
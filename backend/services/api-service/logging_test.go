@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequestLogFields(t *testing.T) {
+	fields, err := parseRequestLogFields(" user_id, costs ,,body_sizes")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{logFieldUserID, logFieldCosts, logFieldBodySizes}, fields)
+
+	fields, err = parseRequestLogFields("")
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+
+	_, err = parseRequestLogFields("user_id,headers")
+	assert.Error(t, err)
+}
+
+func TestParsePathLogLevels(t *testing.T) {
+	levels, err := parsePathLogLevels("/healthz=debug, /internal/jobs/*=warn")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]log.Level{"/healthz": log.DebugLevel, "/internal/jobs/*": log.WarnLevel}, levels)
+
+	for _, bad := range []string{"/healthz", "healthz=debug", "/healthz=loud"} {
+		_, err := parsePathLogLevels(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestPathLogLevel(t *testing.T) {
+	levels := map[string]log.Level{
+		"/healthz":        log.DebugLevel,
+		"/internal/*":     log.DebugLevel,
+		"/internal/jobs*": log.TraceLevel,
+	}
+	for path, want := range map[string]log.Level{
+		"/healthz":               log.DebugLevel,
+		"/internal/admin/reload": log.DebugLevel,
+		"/internal/jobs/j1/done": log.TraceLevel, // Longest prefix wins
+	} {
+		level, ok := pathLogLevel(levels, path)
+		assert.True(t, ok, path)
+		assert.Equal(t, want, level, path)
+	}
+	_, ok := pathLogLevel(levels, "/healthz/deep")
+	assert.False(t, ok)
+}
+
+func TestRequestLogLevel(t *testing.T) {
+	opts := RequestLogOptions{PathLevels: map[string]log.Level{"/healthz": log.DebugLevel, "/loud": log.ErrorLevel}}
+
+	level, _ := requestLogLevel(opts, "/api/workspaces", 200, false, false)
+	assert.Equal(t, log.InfoLevel, level)
+	level, _ = requestLogLevel(opts, "/healthz", 200, false, false)
+	assert.Equal(t, log.DebugLevel, level)
+	// Overrides only ever quieten successful requests.
+	level, _ = requestLogLevel(opts, "/loud", 200, false, false)
+	assert.Equal(t, log.InfoLevel, level)
+	level, _ = requestLogLevel(opts, "/healthz", 503, false, false)
+	assert.Equal(t, log.ErrorLevel, level)
+	level, msg := requestLogLevel(opts, "/healthz", 200, false, true)
+	assert.Equal(t, log.WarnLevel, level)
+	assert.Equal(t, "Request completed slowly", msg)
+	level, msg = requestLogLevel(opts, "/api/workspaces", 404, true, true)
+	assert.Equal(t, log.WarnLevel, level)
+	assert.Equal(t, "Request completed with client error", msg)
+}
+
+func TestRequestID(t *testing.T) {
+	assert.Equal(t, "req-123.abc", requestID("req-123.abc"))
+	for _, bad := range []string{"", "has space", strings.Repeat("a", 129), "x\ny"} {
+		id := requestID(bad)
+		assert.NotEqual(t, bad, id)
+		assert.Len(t, id, 36)
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(log.DebugLevel)
+
+	r := gin.New()
+	r.Use(RequestCostMiddleware())
+	r.Use(RequestLogger(RequestLogOptions{
+		Logger:        logger,
+		Fields:        []string{logFieldUserID, logFieldRequestID, logFieldBodySizes},
+		PathLevels:    map[string]log.Level{"/healthz": log.DebugLevel},
+		SlowThreshold: 50 * time.Millisecond,
+	}))
+	r.GET("/healthz", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	r.GET("/slow", func(c *gin.Context) {
+		c.Set("userID", "u1")
+		time.Sleep(60 * time.Millisecond)
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/healthz?probe=1", nil)
+	req.Header.Set(requestIDHeader, "probe-1")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "probe-1", w.Header().Get(requestIDHeader))
+	entry := hook.LastEntry()
+	assert.Equal(t, log.DebugLevel, entry.Level)
+	assert.Equal(t, "Request completed", entry.Message)
+	assert.Equal(t, "probe-1", entry.Data["request_id"])
+	assert.Equal(t, "probe=1", entry.Data["query"])
+	assert.Equal(t, 2, entry.Data["response_bytes"])
+	assert.NotContains(t, entry.Data, "user_id")
+	assert.NotContains(t, entry.Data, "firestore_reads") // Costs were not selected
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	entry = hook.LastEntry()
+	assert.Equal(t, log.WarnLevel, entry.Level)
+	assert.Equal(t, "u1", entry.Data["user_id"])
+	assert.Equal(t, true, entry.Data["slow"])
+	assert.Equal(t, 204, entry.Data["status_code"])
+	assert.Len(t, w.Header().Get(requestIDHeader), 36)
+	assert.Equal(t, w.Header().Get(requestIDHeader), entry.Data["request_id"])
+}
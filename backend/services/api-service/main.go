@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	// Firebase Admin SDK
@@ -11,24 +15,19 @@ import (
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	"cloud.google.com/go/firestore"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config" // Renamed to avoid conflict with package 'config'
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/1liale/api-service/docstore"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
 )
 
 // Global variables for clients that are initialized once and used throughout.
 var (
-	firestoreClient *firestore.Client
+	firestoreClient *docstore.Client
 	tasksClient     *cloudtasks.Client
-	r2PresignClient *s3.PresignClient
-	r2S3Client      *s3.Client
 	firebaseApp     *firebase.App // Added for Firebase Admin SDK
 )
 
@@ -64,17 +63,26 @@ func main() {
 
 	ctx := context.Background()
 
-	// Initialize Firebase Admin SDK
-	if err := initializeFirebase(ctx, cfg.GCPProjectID); err != nil {
-		log.Fatalf("Failed to initialize Firebase Admin SDK: %v", err)
-	}
+	// The embedded job store runs without Firestore and Firebase; see validateJobStore
+	usesFirestore := cfg.JobStore == jobStoreFirestore
+	if usesFirestore {
+		// Initialize Firebase Admin SDK
+		if err := initializeFirebase(ctx, cfg.GCPProjectID); err != nil {
+			log.Fatalf("Failed to initialize Firebase Admin SDK: %v", err)
+		}
 
-	// Initialize Firestore Client
-	fsClient, err := firestore.NewClient(ctx, cfg.GCPProjectID)
+		// Initialize Firestore Client
+		// The gRPC interceptors count reads and writes against the request that issued them.
+		fsClient, err := firestore.NewClient(ctx, cfg.GCPProjectID, option.WithGRPCDialOption(firestoreCostDialOptions()...))
+		if err != nil {
+			log.Fatalf("Failed to create Firestore client: %v", err)
+		}
+		firestoreClient = docstore.NewFirestore(fsClient)
+	}
+	jobStore, err := openJobStore(cfg, firestoreClient)
 	if err != nil {
-		log.Fatalf("Failed to create Firestore client: %v", err)
+		log.Fatalf("Failed to open job store: %v", err)
 	}
-	firestoreClient = fsClient
 
 	// Initialize CloudTasks Client
 	tClient, err := cloudtasks.NewClient(ctx)
@@ -82,30 +90,13 @@ func main() {
 		log.Fatalf("Failed to create Cloud Tasks client: %v", err)
 	}
 	tasksClient = tClient
-	log.Info("API Service initialized with Firestore and CloudTasks clients.")
+	log.WithField("job_store", cfg.JobStore).Info("API Service initialized.")
 
-	// Initialize R2/S3 Client
-	r2AwsCfg, err := awsconfig.LoadDefaultConfig(context.TODO(),
-		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.R2AccessKeyID, cfg.R2SecretAccessKey, "")),
-		awsconfig.WithRegion("auto"),
-	)
+	// Initialize R2/S3 Client; the secondary key pair can be switched to at runtime
+	objectStore, err := newObjectStore(cfg.R2AccountID, r2CredentialsPrimary, R2Credentials{cfg.R2AccessKeyID, cfg.R2SecretAccessKey})
 	if err != nil {
-		log.Fatalf("Failed to load R2 S3 configuration: %v", err)
-	}
-
-	r2S3Client = s3.NewFromConfig(r2AwsCfg, func(o *s3.Options) {
-		o.EndpointResolver = s3.EndpointResolverFunc(
-			func(region string, options s3.EndpointResolverOptions) (aws.Endpoint, error) {
-				return aws.Endpoint{
-					URL:               fmt.Sprintf("https://%s.r2.cloudflarestorage.com", cfg.R2AccountID),
-					HostnameImmutable: true,
-					SigningRegion:     "auto",
-					SigningName:       "s3",
-				}, nil
-			})
-		o.UsePathStyle = true
-	})
-	r2PresignClient = s3.NewPresignClient(r2S3Client)
+		log.Fatalf("Failed to create R2 S3 client: %v", err)
+	}
 	log.Info("R2 S3 Client initialized.")
 
 	// Defer client closing
@@ -123,84 +114,263 @@ func main() {
 	}()
 
 	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	// Resolves the caller's IP for rate limiting and logs; see RealClientIP
+	r.Use(ClientIPMiddleware(cfg.TrustedProxyHops))
 
 	// CORS middleware remains the same
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowAllOrigins = true
 	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", debugCostsHeader, requestIDHeader}
+	corsConfig.ExposeHeaders = []string{requestCostsHeader, requestIDHeader}
 	r.Use(cors.New(corsConfig))
 
-	// Request Logging middleware remains the same
-	r.Use(func(c *gin.Context) {
-		start := time.Now()
-		path := c.Request.URL.Path
-		raw := c.Request.URL.RawQuery
-		c.Next()
-		latency := time.Since(start)
-		statusCode := c.Writer.Status()
-		clientIP := c.ClientIP()
-		method := c.Request.Method
-		traceID := c.Request.Header.Get("X-Cloud-Trace-Context")
-		logFields := log.Fields{
-			"status_code": statusCode,
-			"latency_ms":  latency.Milliseconds(),
-			"client_ip":   clientIP,
-			"method":      method,
-			"path":        path,
-			"trace_id":    traceID,
-		}
-		if raw != "" {
-			logFields["query"] = raw
-		}
-		if len(c.Errors) > 0 {
-			logFields["error"] = c.Errors.String()
-		}
-		entry := log.WithFields(logFields)
-		if statusCode >= 500 {
-			entry.Error("Request completed with server error")
-		} else if statusCode >= 400 {
-			entry.Warn("Request completed with client error")
-		} else {
-			entry.Info("Request completed")
-		}
+	// Per-request Firestore/R2 operation counters, reported by the logging middleware below
+	r.Use(RequestCostMiddleware())
+
+	// One log entry per request, covering the /api and /internal groups registered below
+	r.Use(RequestLogger(cfg.requestLogOptions()))
+
+	// Cheap liveness endpoint for load balancer and uptime probes, logged at debug by default
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	backgroundRunner := NewBackgroundRunner(cfg.BackgroundWorkers, cfg.BackgroundQueueSize, cfg.BackgroundTaskTimeout)
+
 	apiController := NewApiController(
 		firestoreClient,
 		tasksClient,
-		r2PresignClient,
-		r2S3Client,
+		objectStore,
 		cfg.R2BucketName,
 		cfg,
 		cfg.FirestoreJobsCollection,
+		backgroundRunner,
 	)
+	apiController.Jobs = jobStore
 
-	authenticatedRoutes := r.Group("/api")
-	authenticatedRoutes.Use(AuthMiddleware()) // No longer pass JWTSecret
-	{
-		// Workspace and File Sync Endpoints
-		authenticatedRoutes.POST("/workspaces", apiController.CreateWorkspace)      // Changed from /workspaces/create
-		authenticatedRoutes.GET("/workspaces", apiController.ListWorkspaces)          // New route for listing workspaces
-		authenticatedRoutes.POST("/workspaces/:workspaceId/sync", apiController.HandleSync)
-		authenticatedRoutes.POST("/workspaces/:workspaceId/sync/confirm", apiController.ConfirmSync)
-		authenticatedRoutes.GET("/workspaces/:workspaceId/manifest", apiController.GetWorkspaceManifest)
+	rateLimiter := newRateLimiter(cfg, firestoreClient)
+	executeRateLimit := RateLimit(rateLimiter, "execute", cfg.ExecuteRateLimitPerMinute, time.Minute)
+
+	// Workspace features need Firestore and Firebase Auth; see WorkspacesEnabled
+	if cfg.WorkspacesEnabled {
+		// Delete and restore must still reach soft-deleted workspaces, so they sit outside the
+		// group that rejects them.
+		workspaceLifecycleRoutes := r.Group("/api")
+		workspaceLifecycleRoutes.Use(AuthMiddleware())
+		{
+			workspaceLifecycleRoutes.DELETE("/workspaces/:workspaceId", apiController.DeleteWorkspace)
+			workspaceLifecycleRoutes.POST("/workspaces/:workspaceId/restore", apiController.RestoreWorkspace)
+			workspaceLifecycleRoutes.GET("/workspace-deletions/:deletionId", apiController.GetWorkspaceDeletion)
+		}
+
+		// Live job and workspace updates; the WebSocket authenticates in its first message.
+		r.GET("/api/ws", apiController.ServeLiveUpdates)
+
+		// Read-only workspace share links need no sign-in; the token is the capability, so
+		// it is rate limited per token rather than per caller.
+		sharedManifestRateLimit := RateLimitByParam(rateLimiter, "shared-manifest", "token", cfg.SharedManifestRateLimitPerMinute, time.Minute)
+		r.GET("/api/shared/:token/manifest", sharedManifestRateLimit, apiController.GetSharedWorkspaceManifest)
+
+		authenticatedRoutes := r.Group("/api")
+		authenticatedRoutes.Use(AuthMiddleware(), apiController.RejectDeletedWorkspaces()) // No longer pass JWTSecret
+		{
+			// Workspace and File Sync Endpoints
+			authenticatedRoutes.POST("/workspaces", apiController.CreateWorkspace)      // Changed from /workspaces/create
+			authenticatedRoutes.GET("/workspaces", apiController.ListWorkspaces)          // New route for listing workspaces
+			authenticatedRoutes.GET("/workspace-templates", apiController.ListWorkspaceTemplates)
+			authenticatedRoutes.POST("/workspaces/default", apiController.CreateDefaultWorkspace)
+			authenticatedRoutes.PATCH("/workspaces/:workspaceId", apiController.RenameWorkspace)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/sync", apiController.HandleSync)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/sync/confirm", apiController.ConfirmSync)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/manifest", apiController.GetWorkspaceManifest)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/stats", apiController.GetWorkspaceStats)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/files/refresh-urls", apiController.RefreshFileURLs)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/files/bulk-delete", apiController.BulkDeleteFiles)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/folders", apiController.CreateFolder)
+			authenticatedRoutes.PATCH("/workspaces/:workspaceId/folders/*path", apiController.RenameFolder)
+			authenticatedRoutes.DELETE("/workspaces/:workspaceId/folders/*path", apiController.DeleteFolder)
+
+			// Editor drafts. Gin's catch-all must end the route, so the draft handlers take
+			// /files/*path and require it to end in /draft; promote names the file in its body.
+			authenticatedRoutes.PATCH("/workspaces/:workspaceId/files/*path", apiController.SaveDraft)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/files/*path", apiController.GetDraft)
+			authenticatedRoutes.DELETE("/workspaces/:workspaceId/files/*path", apiController.DiscardDraft)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/files/draft/promote", apiController.PromoteDraft)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/drafts", apiController.ListDrafts)
+
+			authenticatedRoutes.PUT("/workspaces/:workspaceId/read-only", apiController.SetWorkspaceReadOnly)
+			authenticatedRoutes.PUT("/workspaces/:workspaceId/settings/destructive-sync", apiController.SetDestructiveSyncSettings)
+			authenticatedRoutes.PUT("/workspaces/:workspaceId/settings/concurrency", apiController.SetConcurrencySettings)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/settings", apiController.GetWorkspaceSettings)
+			authenticatedRoutes.PUT("/workspaces/:workspaceId/settings", apiController.UpdateWorkspaceSettings)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/export", apiController.ExportWorkspace)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/export", apiController.CreateWorkspaceExport)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/exports/:exportId", apiController.GetWorkspaceExport)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/import/url", apiController.ImportFromURL)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/imports/:importJobId", apiController.GetImportJob)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/clone", apiController.CloneWorkspace)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/clones/:cloneJobId", apiController.GetCloneJob)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/clones/:cloneJobId/resume", apiController.ResumeCloneJob)
+
+			// Invitations
+			authenticatedRoutes.GET("/workspaces/:workspaceId/invitations", apiController.ListInvitations)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/invitations", apiController.CreateInvitation)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/invitations/bulk", apiController.BulkCreateInvitations)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/invitations/:invitationId/resend", apiController.ResendInvitation)
+			authenticatedRoutes.DELETE("/workspaces/:workspaceId/invitations/:invitationId", apiController.RevokeInvitation)
+			authenticatedRoutes.POST("/invitations/accept", apiController.AcceptInvitation)
+			authenticatedRoutes.POST("/invites/accept", apiController.AcceptInvitation)
+			authenticatedRoutes.POST("/invites/:inviteId/accept", apiController.AcceptInvitationByID)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/invites", apiController.ListInvitations)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/invites", apiController.CreateInvitation)
+			authenticatedRoutes.DELETE("/workspaces/:workspaceId/invites/:invitationId", apiController.RevokeInvitation)
+
+			// Members
+			authenticatedRoutes.GET("/workspaces/:workspaceId/members", apiController.ListWorkspaceMembers)
+			authenticatedRoutes.PATCH("/workspaces/:workspaceId/members/:userId", apiController.UpdateWorkspaceMember)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/transfer-ownership", apiController.TransferWorkspaceOwnership)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/leave", apiController.LeaveWorkspace)
+
+			// Authenticated Code Execution
+			authenticatedRoutes.POST("/workspaces/:workspaceId/execute", executeRateLimit, apiController.ExecuteCodeAuthenticated)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/execute/warm", apiController.WarmExecutionEnvironment)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/jobs", apiController.ListWorkspaceJobs)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/jobs/metrics", apiController.GetWorkspaceJobMetrics)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/jobs/:jobId", apiController.GetWorkspaceJob)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/jobs/:jobId/events", apiController.StreamWorkspaceJobEvents)
+			authenticatedRoutes.DELETE("/workspaces/:workspaceId/jobs/:jobId", apiController.CancelWorkspaceJob)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/jobs/:jobId/retry", executeRateLimit, apiController.RetryWorkspaceJob)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/jobs/:jobId/share", apiController.ShareWorkspaceJob)
+			authenticatedRoutes.DELETE("/workspaces/:workspaceId/jobs/:jobId/share", apiController.RevokeWorkspaceJobShare)
+			authenticatedRoutes.POST("/workspaces/:workspaceId/share-links", apiController.CreateWorkspaceShareLink)
+			authenticatedRoutes.DELETE("/workspaces/:workspaceId/share-links/:linkId", apiController.RevokeWorkspaceShareLink)
+			authenticatedRoutes.GET("/jobs", apiController.ListMyJobs)
+			authenticatedRoutes.POST("/jobs/:jobId/pin", apiController.PinJob)
+			authenticatedRoutes.DELETE("/jobs/:jobId/pin", apiController.UnpinJob)
 
-		// Authenticated Code Execution
-		authenticatedRoutes.POST("/workspaces/:workspaceId/execute", apiController.ExecuteCodeAuthenticated)
+			// RAG Query Endpoint
+			authenticatedRoutes.POST("/rag/query", apiController.RagQuery)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/rag/status", apiController.GetRagStatus)
 
-		// RAG Query Endpoint
-		authenticatedRoutes.POST("/rag/query", apiController.RagQuery)
+			// Usage accounting
+			authenticatedRoutes.GET("/me/usage", apiController.GetMyUsage)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/reports/usage", apiController.GetWorkspaceUsageReport)
+			authenticatedRoutes.GET("/workspaces/:workspaceId/usage/export", apiController.ExportWorkspaceUsage)
+
+			// Notification preferences
+			authenticatedRoutes.GET("/workspaces/:workspaceId/notifications/preferences", apiController.GetNotificationPreferences)
+			authenticatedRoutes.PUT("/workspaces/:workspaceId/notifications/preferences", apiController.UpdateNotificationPreferences)
+		}
+	}
+
+	if usesFirestore {
+		// Admin routes require the "admin" custom claim on the Firebase token
+		adminRoutes := r.Group("/api/admin")
+		adminRoutes.Use(AuthMiddleware(), RequireAdmin())
+		{
+			adminRoutes.GET("/usage/top", apiController.ListTopConsumers)
+			adminRoutes.GET("/metrics", apiController.GetServiceMetrics)
+			adminRoutes.POST("/maintenance/:task", apiController.RunMaintenanceTask)
+			adminRoutes.GET("/jobs", apiController.ListAdminJobs)
+			adminRoutes.GET("/jobs/archives", apiController.ListJobArchives)
+			adminRoutes.POST("/jobs/requeue", apiController.RequeueJobs)
+			adminRoutes.POST("/jobs/backfill-expiry", apiController.BackfillJobExpiry)
+			adminRoutes.POST("/jobs/:id/fail", apiController.ForceFailJob)
+			adminRoutes.POST("/workspaces/backfill-storage", apiController.BackfillWorkspaceStorage)
+		}
+
+		// Operational endpoints for admins, kept off the public /api prefix
+		internalAdminRoutes := r.Group("/internal/admin")
+		internalAdminRoutes.Use(AuthMiddleware(), RequireAdmin())
+		{
+			internalAdminRoutes.POST("/reload-storage-credentials", apiController.ReloadStorageCredentials)
+		}
+	}
+
+	// Service-to-service callbacks, authenticated with Google-signed ID tokens
+	if cfg.WorkspacesEnabled && cfg.Services.EmailSender.Enabled() {
+		notificationRoutes := r.Group("/internal/notifications")
+		notificationRoutes.Use(RequireServiceIdentity(cfg.APIBaseURL, cfg.Services.EmailSender.ServiceAccount))
+		{
+			notificationRoutes.POST("/invitations/:invitationId", apiController.HandleInvitationDeliveryCallback)
+		}
+	}
+
+	// Execution workers report status changes and finished jobs so result callbacks can be
+	// delivered, and send heartbeats while running so lost workers can be told apart from long jobs
+	if cfg.APIBaseURL != "" {
+		workerRoutes := r.Group("/internal/jobs")
+		workerRoutes.Use(RequireServiceIdentity(cfg.APIBaseURL, cfg.Services.PythonWorker.ServiceAccount))
+		{
+			workerRoutes.POST("/:jobId/finished", apiController.HandleJobFinished)
+			workerRoutes.POST("/:jobId/heartbeat", apiController.HandleJobHeartbeat)
+			workerRoutes.POST("/:jobId/status", apiController.HandleJobStatus)
+			workerRoutes.POST("/:jobId/dead-letter", apiController.HandleJobDeadLetter)
+		}
 	}
 
-	// Setup public routes (no auth required)
+	// Cloud Scheduler sweeps expired jobs for deployments without a Firestore TTL policy
+	if usesFirestore && cfg.APIBaseURL != "" && cfg.SchedulerServiceAccount != "" {
+		schedulerRoutes := r.Group("/internal/jobs")
+		schedulerRoutes.Use(RequireServiceIdentity(cfg.APIBaseURL, cfg.SchedulerServiceAccount))
+		{
+			schedulerRoutes.POST("/cleanup", apiController.HandleJobCleanup)
+		}
+	}
+
+	// Setup public routes (no auth required); without Firebase every job reader is anonymous
+	optionalAuth := func(c *gin.Context) { c.Next() }
+	if usesFirestore {
+		optionalAuth = OptionalAuthMiddleware()
+	}
 	publicRoutes := r.Group("/api")
 	{
-		publicRoutes.POST("/execute", apiController.ExecuteCode) // Public code execution
+		// Signed-in callers are identified so they are exempt from the anonymous daily quota
+		publicRoutes.POST("/execute", optionalAuth, executeRateLimit, apiController.ExecuteCode) // Public code execution
+		publicRoutes.POST("/execute/batch", optionalAuth, executeRateLimit, apiController.ExecuteCodeBatch)
+		publicRoutes.GET("/languages", apiController.ListLanguages)
+		publicRoutes.GET("/jobs/:jobId", optionalAuth, apiController.GetJob)
+		publicRoutes.GET("/results/:resultToken", apiController.GetSharedResult)
+		publicRoutes.GET("/shared/jobs/:token", apiController.GetSharedJob)
+		publicRoutes.GET("/execute/result/:jobId", apiController.GetClaimedResult)
+		publicRoutes.DELETE("/execute/:jobId", apiController.CancelPublicJob)
 	}
 
-	log.Info("Starting API server on port ", cfg.Port)
-	if err := r.Run(":" + cfg.Port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: r,
+	}
+
+	go func() {
+		log.Info("Starting API server on port ", cfg.Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Wait for Cloud Run's SIGTERM (or Ctrl+C locally), then stop accepting requests
+	// and drain background work before the deferred client shutdown runs.
+	stopCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if !usesFirestore {
+		go apiController.runEmbeddedJobMaintenance(stopCtx, embeddedMaintenanceInterval)
+	}
+	if apiController.queueBackpressureEnabled() {
+		go apiController.runQueueDepthRefresh(stopCtx, cfg.QueueDepthRefresh)
+	}
+	<-stopCtx.Done()
+	log.Info("Shutdown signal received, draining server and background tasks.")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Errorf("HTTP server shutdown failed: %v", err)
+	}
+	if err := backgroundRunner.Shutdown(shutdownCtx); err != nil {
+		log.Errorf("Background runner shutdown failed: %v", err)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// maintenanceTask performs one bounded pass of periodic upkeep and returns a JSON-able summary.
+type maintenanceTask func(ctx context.Context) (interface{}, error)
+
+// maintenanceTasks lists the tasks runnable via POST /api/admin/maintenance/:task. Each is
+// meant to be invoked on a schedule (e.g. Cloud Scheduler every few minutes) and must be
+// safe to run concurrently with itself.
+func (ac *ApiController) maintenanceTasks() map[string]maintenanceTask {
+	return map[string]maintenanceTask{
+		"r2-deletions":              ac.runR2DeletionMaintenance,
+		"invitation-deliveries":     ac.runInvitationDeliveryMaintenance,
+		"invitation-purge":          ac.runInvitationPurgeMaintenance,
+		"rag-indexing":              ac.runRagIndexingMaintenance,
+		"notification-digests":      ac.runNotificationDigestMaintenance,
+		"workspace-purge":           ac.runWorkspacePurgeMaintenance,
+		"workspace-deletion-expiry": ac.runWorkspaceDeletionExpiryMaintenance,
+		"workspace-export-expiry":   ac.runWorkspaceExportExpiryMaintenance,
+		"stale-jobs":                ac.runStaleJobMaintenance,
+		"stuck-jobs":                ac.runStuckJobMaintenance,
+		"job-expiry":                ac.runJobExpiryMaintenance,
+		"draft-expiry":              ac.runDraftExpiryMaintenance,
+		"idempotency-key-expiry":    ac.runIdempotencyKeyExpiryMaintenance,
+		"job-dedupe-expiry":         ac.runJobDedupeExpiryMaintenance,
+		"job-quota-expiry":          ac.runJobQuotaExpiryMaintenance,
+		"folder-keys":               ac.runFolderKeyMigration,
+		"membership-expiry":         ac.runMembershipExpiryMaintenance,
+		"storage-backfill":          ac.runStorageBackfillMaintenance,
+	}
+}
+
+// RunMaintenanceTask runs the maintenance task named in the path.
+func (ac *ApiController) RunMaintenanceTask(c *gin.Context) {
+	name := c.Param("task")
+	logCtx := log.WithFields(log.Fields{"handler": "RunMaintenanceTask", "maintenance_task": name})
+
+	tasks := ac.maintenanceTasks()
+	task, ok := tasks[name]
+	if !ok {
+		known := make([]string, 0, len(tasks))
+		for k := range tasks {
+			known = append(known, k)
+		}
+		sort.Strings(known)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown maintenance task", "tasks": known})
+		return
+	}
+
+	summary, err := task(c.Request.Context())
+	if err != nil {
+		logCtx.WithError(err).Error("Maintenance task failed.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Maintenance task failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task": name, "summary": summary})
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+// membershipPurgeBatch bounds the memberships one membership-expiry run deletes.
+const membershipPurgeBatch = 200
+
+var (
+	errMemberNotFound    = errors.New("member not found")
+	errOwnerMembership   = errors.New("owner memberships cannot be changed")
+	errInvalidMembership = errors.New("invalid membership expiry")
+	errSoleOwner         = errors.New("caller is the workspace's only owner")
+)
+
+// parseMembershipExpiry validates a requested membership expiry, which must be in the future,
+// and returns it in the stored ISO 8601 form. An empty expiry returns "".
+func parseMembershipExpiry(raw string, now time.Time) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: expiresAt must be an ISO 8601 timestamp", errInvalidMembership)
+	}
+	if !expiresAt.After(now) {
+		return "", fmt.Errorf("%w: expiresAt must be in the future", errInvalidMembership)
+	}
+	return TimeToISO8601(expiresAt), nil
+}
+
+// membershipExpired reports whether a time-boxed membership has lapsed. Expired members are
+// treated as non-members until the membership-expiry task deletes them or an owner extends
+// them. An unparseable expiry counts as lapsed.
+func membershipExpired(m WorkspaceMembership, now time.Time) bool {
+	if m.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, m.ExpiresAt)
+	return err != nil || !now.Before(expiresAt)
+}
+
+// newWorkspaceMember converts a membership into its GET /members form.
+func newWorkspaceMember(m WorkspaceMembership, now time.Time) WorkspaceMember {
+	return WorkspaceMember{
+		MembershipID: m.MembershipID,
+		UserID:       m.UserID,
+		UserEmail:    m.UserEmail,
+		UserName:     m.UserName,
+		Role:         m.Role,
+		JoinedAt:     m.JoinedAt,
+		ExpiresAt:    m.ExpiresAt,
+		Expired:      membershipExpired(m, now),
+	}
+}
+
+// workspaceMembersCursor marks where a page of GET /workspaces/:workspaceId/members ended.
+type workspaceMembersCursor struct {
+	JoinedAt     string `json:"j"`
+	MembershipID string `json:"m"`
+}
+
+func encodeWorkspaceMembersCursor(cursor workspaceMembersCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeWorkspaceMembersCursor(token string) (workspaceMembersCursor, error) {
+	var cursor workspaceMembersCursor
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(b, &cursor)
+	}
+	if err != nil || cursor.MembershipID == "" {
+		return workspaceMembersCursor{}, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// workspaceMemberRoles are the values of ListWorkspaceMembers' role filter.
+var workspaceMemberRoles = map[string]bool{"owner": true, "editor": true, "viewer": true}
+
+// ListWorkspaceMembers lists a workspace's members by join time, the caller's own entry
+// flagged. Expired members stay listed, flagged, so owners can extend their access.
+// Query params: role (owner, editor or viewer), limit (default 100, max 500), cursor (the
+// opaque nextCursor of the previous page). Firestore needs composite indexes on
+// workspace_id/joined_at/__name__ and workspace_id/role/joined_at/__name__.
+func (ac *ApiController) ListWorkspaceMembers(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "ListWorkspaceMembers"})
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+	role := c.Query("role")
+	if role != "" && !workspaceMemberRoles[role] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be one of owner, editor, viewer"})
+		return
+	}
+	var cursor *workspaceMembersCursor
+	if token := c.Query("cursor"); token != "" {
+		decoded, err := decodeWorkspaceMembersCursor(token)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		cursor = &decoded
+	}
+
+	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Workspace membership check failed.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+		return
+	}
+
+	q := ac.FirestoreClient.Collection("workspace_memberships").Where("workspace_id", "==", workspaceID)
+	if role != "" {
+		q = q.Where("role", "==", role)
+	}
+	q = q.OrderBy("joined_at", docstore.Asc).OrderBy(docstore.DocumentID, docstore.Asc)
+	if cursor != nil {
+		q = q.StartAfter(cursor.JoinedAt, cursor.MembershipID)
+	}
+	iter := q.Limit(limit).Documents(ctx)
+	defer iter.Stop()
+
+	now := time.Now()
+	members := make([]WorkspaceMember, 0, limit)
+	read := 0
+	var last workspaceMembersCursor
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to list workspace members.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list workspace members"})
+			return
+		}
+		read++
+		var membership WorkspaceMembership
+		if err := doc.DataTo(&membership); err != nil {
+			logCtx.WithError(err).WithField("membership_id", doc.Ref.ID).Warn("Skipping unparseable membership.")
+			continue
+		}
+		last = workspaceMembersCursor{JoinedAt: membership.JoinedAt, MembershipID: doc.Ref.ID}
+		member := newWorkspaceMember(membership, now)
+		member.IsCurrentUser = membership.UserID == userID
+		members = append(members, member)
+	}
+
+	response := gin.H{"workspaceId": workspaceID, "members": members}
+	if read == limit && last.MembershipID != "" {
+		response["nextCursor"] = encodeWorkspaceMembersCursor(last)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateWorkspaceMember changes a member's role and expiry (owners only). Setting, extending
+// and clearing an expiry all go through here; owner memberships cannot be changed.
+func (ac *ApiController) UpdateWorkspaceMember(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	memberID := c.Param("userId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{
+		"workspace_id": workspaceID,
+		"user_id":      userID,
+		"member_id":    memberID,
+		"handler":      "UpdateWorkspaceMember",
+	})
+
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+
+	var req UpdateMemberRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	if req.ClearExpiry && req.ExpiresAt != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresAt and clearExpiry cannot both be set"})
+		return
+	}
+	if req.Role == "" && req.ExpiresAt == "" && !req.ClearExpiry {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+	expiresAt, err := parseMembershipExpiry(req.ExpiresAt, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var before, after WorkspaceMembership
+	err = ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		docs, err := tx.Documents(ac.FirestoreClient.Collection("workspace_memberships").
+			Where("user_id", "==", memberID).
+			Where("workspace_id", "==", workspaceID).
+			Limit(1)).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to query workspace membership: %w", err)
+		}
+		if len(docs) == 0 {
+			return errMemberNotFound
+		}
+		if err := docs[0].DataTo(&before); err != nil {
+			return fmt.Errorf("failed to parse workspace membership: %w", err)
+		}
+		if before.Role == "owner" {
+			return errOwnerMembership
+		}
+
+		after = before
+		var updates []docstore.Update
+		if req.Role != "" {
+			after.Role = req.Role
+			updates = append(updates, docstore.Update{Path: "role", Value: req.Role})
+		}
+		if expiresAt != "" {
+			after.ExpiresAt = expiresAt
+			updates = append(updates, docstore.Update{Path: "expires_at", Value: expiresAt})
+		}
+		if req.ClearExpiry {
+			after.ExpiresAt = ""
+			updates = append(updates, docstore.Update{Path: "expires_at", Value: docstore.Delete})
+		}
+		return tx.Update(docs[0].Ref, updates)
+	})
+	if errors.Is(err, errMemberNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if errors.Is(err, errOwnerMembership) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Owner memberships cannot be changed"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to update workspace member.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update workspace member"})
+		return
+	}
+
+	ac.recordActivity(ctx, workspaceID, userID, activityMemberUpdated, map[string]interface{}{
+		"user_id":             memberID,
+		"role":                after.Role,
+		"previous_role":       before.Role,
+		"expires_at":          after.ExpiresAt,
+		"previous_expires_at": before.ExpiresAt,
+	})
+	ac.touchWorkspace(ctx, workspaceID)
+	logCtx.WithFields(log.Fields{"role": after.Role, "expires_at": after.ExpiresAt}).Info("Workspace member updated.")
+	c.JSON(http.StatusOK, newWorkspaceMember(after, time.Now()))
+}
+
+// LeaveWorkspace removes the caller's own membership. An owner may leave only while another
+// owner remains, who takes over owned_by if it named the caller. The membership is re-read
+// in the transaction, so a concurrent role change cannot leave the workspace ownerless.
+func (ac *ApiController) LeaveWorkspace(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "LeaveWorkspace"})
+
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleViewer, logCtx) == nil {
+		return
+	}
+
+	var left WorkspaceMembership
+	var ownedBy string // Set when owned_by moved to another owner
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		ownedBy = ""
+		memberships := ac.FirestoreClient.Collection("workspace_memberships")
+		wsRef := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+		wsSnap, err := tx.Get(wsRef)
+		if err != nil {
+			return fmt.Errorf("failed to read workspace: %w", err)
+		}
+		var ws Workspace
+		if err := wsSnap.DataTo(&ws); err != nil {
+			return fmt.Errorf("failed to parse workspace: %w", err)
+		}
+
+		docs, err := tx.Documents(memberships.
+			Where("user_id", "==", userID).
+			Where("workspace_id", "==", workspaceID).
+			Limit(1)).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to query workspace membership: %w", err)
+		}
+		if len(docs) == 0 {
+			return errMemberNotFound
+		}
+		if err := docs[0].DataTo(&left); err != nil {
+			return fmt.Errorf("failed to parse workspace membership: %w", err)
+		}
+
+		if left.Role == roleOwner {
+			owners, err := tx.Documents(memberships.
+				Where("workspace_id", "==", workspaceID).
+				Where("role", "==", roleOwner).
+				OrderBy("joined_at", docstore.Asc)).GetAll()
+			if err != nil {
+				return fmt.Errorf("failed to query workspace owners: %w", err)
+			}
+			var successor string
+			for _, doc := range owners {
+				var owner WorkspaceMembership
+				if doc.DataTo(&owner) == nil && owner.UserID != userID {
+					successor = owner.UserID
+					break
+				}
+			}
+			if successor == "" {
+				return errSoleOwner
+			}
+			if workspaceOwner(ws) == userID {
+				ownedBy = successor
+				if err := tx.Update(wsRef, []docstore.Update{
+					{Path: "owned_by", Value: successor},
+					{Path: "updated_at", Value: NowISO8601()},
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Delete(docs[0].Ref)
+	})
+	switch {
+	case errors.Is(err, errMemberNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	case errors.Is(err, errSoleOwner):
+		c.JSON(http.StatusConflict, gin.H{"error": "You are this workspace's only owner. Transfer ownership to another member or delete the workspace instead."})
+		return
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to leave workspace.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to leave workspace"})
+		return
+	}
+
+	details := map[string]interface{}{"user_id": userID, "role": left.Role}
+	if ownedBy != "" {
+		details["owned_by"] = ownedBy
+	}
+	ac.recordActivity(ctx, workspaceID, userID, activityMemberLeft, details)
+	ac.touchWorkspace(ctx, workspaceID)
+	logCtx.WithField("role", left.Role).Info("Member left workspace.")
+	c.Status(http.StatusNoContent)
+}
+
+// runMembershipExpiryMaintenance is the "membership-expiry" maintenance task: it deletes
+// memberships that expired more than MembershipPurgeAfter ago and records each removal in
+// the workspace activity log. Needs a single-field index on workspace_memberships expires_at.
+func (ac *ApiController) runMembershipExpiryMaintenance(ctx context.Context) (interface{}, error) {
+	cutoff := TimeToISO8601(time.Now().Add(-ac.AppConfig.MembershipPurgeAfter))
+	docs, err := ac.FirestoreClient.Collection("workspace_memberships").
+		Where("expires_at", "<", cutoff).
+		OrderBy("expires_at", docstore.Asc).
+		Limit(membershipPurgeBatch).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load expired memberships: %w", err)
+	}
+
+	removed := 0
+	for _, doc := range docs {
+		var membership WorkspaceMembership
+		if err := doc.DataTo(&membership); err != nil {
+			log.WithError(err).WithField("membership_id", doc.Ref.ID).Warn("Skipping unparseable membership.")
+			continue
+		}
+		// An owner extending the membership since the query updates it; the precondition keeps it.
+		if _, err := doc.Ref.Delete(ctx, docstore.LastUpdateTime(doc.UpdateTime)); err != nil {
+			log.WithError(err).WithField("membership_id", doc.Ref.ID).Info("Expired membership not deleted; it may have been extended.")
+			continue
+		}
+		removed++
+		ac.recordActivity(ctx, membership.WorkspaceID, membership.UserID, activityMemberExpired, map[string]interface{}{
+			"user_id":    membership.UserID,
+			"role":       membership.Role,
+			"expires_at": membership.ExpiresAt,
+		})
+	}
+	return gin.H{"scanned": len(docs), "removed": removed}, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMembershipExpiry(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	expiresAt, err := parseMembershipExpiry("", now)
+	assert.NoError(t, err)
+	assert.Empty(t, expiresAt)
+
+	expiresAt, err = parseMembershipExpiry("2025-03-08T13:00:00+01:00", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "2025-03-08T12:00:00.000Z", expiresAt)
+
+	for _, raw := range []string{"next week", "2025-03-08", "2025-03-01T12:00:00Z", "2025-02-28T12:00:00Z"} {
+		_, err := parseMembershipExpiry(raw, now)
+		assert.ErrorIs(t, err, errInvalidMembership, raw)
+	}
+}
+
+func TestMembershipExpired(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.False(t, membershipExpired(WorkspaceMembership{}, now))
+	assert.False(t, membershipExpired(WorkspaceMembership{ExpiresAt: TimeToISO8601(now.Add(time.Second))}, now))
+	assert.True(t, membershipExpired(WorkspaceMembership{ExpiresAt: TimeToISO8601(now)}, now))
+	assert.True(t, membershipExpired(WorkspaceMembership{ExpiresAt: TimeToISO8601(now.Add(-time.Hour))}, now))
+	assert.True(t, membershipExpired(WorkspaceMembership{ExpiresAt: "garbage"}, now))
+
+	member := newWorkspaceMember(WorkspaceMembership{UserID: "u1", Role: "viewer", ExpiresAt: TimeToISO8601(now)}, now)
+	assert.True(t, member.Expired)
+	assert.Equal(t, "u1", member.UserID)
+}
+
+func TestWorkspaceMembersCursor(t *testing.T) {
+	cursor := workspaceMembersCursor{JoinedAt: "2025-03-01T12:00:00.000Z", MembershipID: "m-1"}
+	decoded, err := decodeWorkspaceMembersCursor(encodeWorkspaceMembersCursor(cursor))
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, decoded)
+
+	for _, garbage := range []string{"!!!", "e30", "bm90IGpzb24"} {
+		_, err := decodeWorkspaceMembersCursor(garbage)
+		assert.Error(t, err, garbage)
+	}
+}
+
+func TestHandlers_ListWorkspaceMembersPages(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	editors := []string{"editor-" + uuid.New().String(), "editor-" + uuid.New().String()}
+	for _, editor := range editors {
+		h.seedMembership(workspaceID, editor, "editor", "")
+	}
+	h.seedMembership(workspaceID, "viewer-"+uuid.New().String(), "viewer", "")
+	path := "/api/workspaces/" + workspaceID + "/members"
+
+	type page struct {
+		Members    []WorkspaceMember `json:"members"`
+		NextCursor string            `json:"nextCursor"`
+	}
+	var seen []WorkspaceMember
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		var resp page
+		w := h.do(http.MethodGet, path+"?limit=2&cursor="+cursor, editors[0], nil, &resp)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		seen = append(seen, resp.Members...)
+		if cursor = resp.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if assert.Len(t, seen, 4) {
+		assert.Equal(t, owner, seen[0].UserID, "oldest membership first")
+		for _, member := range seen {
+			assert.NotEmpty(t, member.MembershipID)
+			assert.Equal(t, member.UserID == editors[0], member.IsCurrentUser, member.UserID)
+		}
+	}
+
+	var filtered page
+	w := h.do(http.MethodGet, path+"?role=editor", owner, nil, &filtered)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if assert.Len(t, filtered.Members, 2) {
+		assert.ElementsMatch(t, editors, []string{filtered.Members[0].UserID, filtered.Members[1].UserID})
+	}
+	assert.Empty(t, filtered.NextCursor)
+
+	for _, query := range []string{"?role=admin", "?limit=0", "?limit=501", "?cursor=!!!"} {
+		assert.Equal(t, http.StatusBadRequest, h.do(http.MethodGet, path+query, owner, nil, nil).Code, query)
+	}
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodGet, path, "stranger-"+uuid.New().String(), nil, nil).Code)
+}
+
+func TestHandlers_LeaveWorkspace(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, editor := "owner-"+uuid.New().String(), "editor-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, editor, roleEditor, "")
+	ws := "/api/workspaces/" + workspaceID
+
+	w := h.do(http.MethodPost, ws+"/leave", owner, nil, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, "the only owner cannot leave")
+	assert.Contains(t, w.Body.String(), "Transfer ownership")
+
+	w = h.do(http.MethodPost, ws+"/leave", editor, nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	// The workspace is gone for the editor on every route.
+	var listed []WorkspaceSummary
+	h.do(http.MethodGet, "/api/workspaces", editor, nil, &listed)
+	assert.Empty(t, listed)
+	for _, path := range []string{ws + "/manifest", ws + "/members", ws + "/stats"} {
+		assert.Equal(t, http.StatusForbidden, h.do(http.MethodGet, path, editor, nil, nil).Code, path)
+	}
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, ws+"/leave", editor, nil, nil).Code)
+
+	// With a second owner, the original one may leave and hands over owned_by.
+	coOwner := "co-owner-" + uuid.New().String()
+	h.seedMembership(workspaceID, coOwner, roleOwner, "")
+	w = h.do(http.MethodPost, ws+"/leave", owner, nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	got, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, coOwner, workspaceOwner(got))
+	}
+	assert.Equal(t, http.StatusConflict, h.do(http.MethodPost, ws+"/leave", coOwner, nil, nil).Code)
+}
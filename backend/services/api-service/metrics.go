@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// GetServiceMetrics returns this instance's in-process counters for operators,
+// plus the shared pending R2 deletion backlog so leaked objects are visible. A degraded
+// "deadlines" entry means execution jobs are waiting in the queue past their deadlines.
+func (ac *ApiController) GetServiceMetrics(c *gin.Context) {
+	store := ac.r2()
+	metrics := gin.H{
+		"background": ac.Background.Stats(),
+		"warm":       ac.warm.Stats(),
+		"streams":    ac.streams.Stats(),
+		"queues":     ac.queues.Stats(),
+		"queueDepth": ac.queueDepth.Stats(),
+		"deadlines":  ac.deadlines.Stats(time.Now()),
+		"jobCleanup": ac.jobCleanups.Stats(),
+		"r2Credentials": gin.H{
+			"credentials": store.Credentials,
+			"accessKeyId": maskAccessKeyID(store.AccessKeyID),
+		},
+	}
+
+	r2Stats, err := ac.pendingR2DeletionStats(c.Request.Context())
+	if err != nil {
+		log.WithError(err).Warn("Failed to compute pending R2 deletion stats for metrics.")
+	}
+	metrics["r2PendingDeletions"] = r2Stats
+
+	c.JSON(http.StatusOK, metrics)
+}
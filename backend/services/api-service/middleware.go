@@ -1,11 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/idtoken"
 )
 
 // AuthMiddleware creates a gin.HandlerFunc for Firebase JWT authentication and user ID extraction.
@@ -54,8 +57,79 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		isAdmin, _ := token.Claims["admin"].(bool)
+
 		c.Set("userID", userID)
+		c.Set("isAdmin", isAdmin)
+		// Only a verified address shows the caller owns it; invitations are matched against it.
+		if verified, _ := token.Claims["email_verified"].(bool); verified {
+			email, _ := token.Claims["email"].(string)
+			c.Set("userEmail", email)
+		}
 		log.Infof("Firebase JWT validated. User ID: %s", userID)
 		c.Next()
 	}
-} 
\ No newline at end of file
+}
+
+// OptionalAuthMiddleware authenticates requests that carry an Authorization header and lets
+// anonymous ones through, for routes that serve both. A bad token is still rejected.
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		auth(c)
+	}
+}
+
+// RequireAdmin rejects requests whose Firebase token lacks the "admin" custom claim.
+// It must run after AuthMiddleware.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("isAdmin") {
+			log.Warnf("Non-admin user %s attempted to access admin route %s", c.GetString("userID"), c.FullPath())
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin privileges required"})
+			return
+		}
+		c.Next()
+	}
+} 
+
+// googleIDTokenIssuers are the issuers of Google-signed ID tokens.
+var googleIDTokenIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// RequireServiceIdentity authenticates service-to-service callbacks. The caller must present a
+// Google-signed ID token minted for audience whose email claim is one of allowedEmails.
+func RequireServiceIdentity(audience string, allowedEmails ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if authHeader == "" || tokenString == authHeader {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Bearer token required"})
+			return
+		}
+
+		payload, err := idtoken.Validate(c.Request.Context(), tokenString, audience)
+		if err == nil && !slices.Contains(googleIDTokenIssuers, payload.Issuer) {
+			err = fmt.Errorf("unexpected issuer %q", payload.Issuer)
+		}
+		if err != nil {
+			log.WithError(err).WithField("path", c.FullPath()).Warn("Service ID token validation failed")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+
+		email, _ := payload.Claims["email"].(string)
+		verified, _ := payload.Claims["email_verified"].(bool)
+		if !verified || !slices.Contains(allowedEmails, email) {
+			log.WithFields(log.Fields{"email": email, "path": c.FullPath()}).Warn("Service identity not allowed")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Caller is not allowed"})
+			return
+		}
+
+		c.Set("serviceEmail", email)
+		c.Next()
+	}
+}
@@ -1,10 +1,54 @@
 package main
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // RequestBody struct for the /execute endpoint (public, non-workspace specific)
 type RequestBody struct {
 	Code     string `json:"code" binding:"required"`
 	Language string `json:"language" binding:"required"`
 	Input    string `json:"input"`
+	ExecutionOptions
+	JobCallbackOptions
+}
+
+// ExecutionOptions are optional per-run overrides, capped by the language's configured limits.
+type ExecutionOptions struct {
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	MemoryMb       int `json:"memoryMb,omitempty"`
+	// Deadline (ISO 8601) after which the job must not start; it is failed as
+	// expired_before_execution instead.
+	Deadline string `json:"deadline,omitempty"`
+	// Priority is "interactive" (the default) or "batch"; batch jobs use the worker's batch queue.
+	Priority string `json:"priority,omitempty"`
+	// Labels tag the job, e.g. by purpose; workspace job lists filter on them. See validateJobLabels.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// JobCallbackOptions request a signed POST to CallbackURL once the job finishes. Without a
+// CallbackSecret the callback is signed with the deployment's WEBHOOK_SIGNING_SECRET.
+type JobCallbackOptions struct {
+	CallbackURL    string `json:"callbackUrl,omitempty" binding:"omitempty,url,startswith=https://"`
+	CallbackSecret string `json:"callbackSecret,omitempty" binding:"max=256"`
+}
+
+// ExecuteBatchRequest is the request body for POST /api/execute/batch. Items are decoded and
+// validated one by one, so an invalid item fails alone.
+type ExecuteBatchRequest struct {
+	Items []json.RawMessage `json:"items" binding:"required"`
+}
+
+// ExecuteBatchItemResult reports one item of a batch execution, in request order. Status is
+// the HTTP status the item would have had as a single POST /api/execute.
+type ExecuteBatchItemResult struct {
+	Index       int          `json:"index"`
+	Status      int          `json:"status"`
+	JobID       string       `json:"job_id,omitempty"`
+	ResultToken string       `json:"result_token,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Details     []FieldError `json:"details,omitempty"`
 }
 
 // --- Structs for Workspace Management ---
@@ -13,10 +57,41 @@ type RequestBody struct {
 type Workspace struct {
 	WorkspaceID      string `json:"workspaceId" firestore:"workspace_id"`
 	Name             string `json:"name" firestore:"name"`
+	NameLower        string `json:"-" firestore:"name_lower,omitempty"` // Name trimmed and case folded, see normalizeWorkspaceName
 	CreatedBy        string `json:"createdBy" firestore:"created_by"`
 	CreatedAt        string `json:"createdAt" firestore:"created_at"`                                   // ISO 8601 string
 	UpdatedAt        string `json:"updatedAt,omitempty" firestore:"updated_at,omitempty"`              // ISO 8601 string
+	LastActivityAt   string `json:"lastActivityAt,omitempty" firestore:"last_activity_at,omitempty"`    // ISO 8601; syncs, runs, queries and membership changes
 	WorkspaceVersion string `json:"workspaceVersion,omitempty" firestore:"workspace_version,omitempty"` // Added for OCC
+	ReadOnly         bool   `json:"readOnly,omitempty" firestore:"read_only,omitempty"`                 // Freezes sync while set
+	StorageBytes     int64  `json:"storageBytes" firestore:"storage_bytes"`                             // Sum of file sizes, maintained by ConfirmSync
+	// The owner accountable for quota and billing; moved by ownership transfers. Workspaces
+	// created before it existed fall back to CreatedBy, see workspaceOwner.
+	OwnedBy string `json:"ownedBy,omitempty" firestore:"owned_by,omitempty"`
+	// The workspace this one was cloned from. The clone stays read-only until its copy completes.
+	ClonedFrom string `json:"clonedFrom,omitempty" firestore:"cloned_from,omitempty"`
+	// The workspace template the workspace was seeded from, if any.
+	TemplateID string `json:"templateId,omitempty" firestore:"template_id,omitempty"`
+	// Set while the workspace is soft-deleted; it is purged once PurgeAfter has passed.
+	Status     string `json:"status,omitempty" firestore:"status,omitempty"` // workspaceStatusTrashed, or empty
+	DeletedAt  string `json:"deletedAt,omitempty" firestore:"deleted_at,omitempty"`
+	DeletedBy  string `json:"deletedBy,omitempty" firestore:"deleted_by,omitempty"`
+	PurgeAfter string `json:"purgeAfter,omitempty" firestore:"purge_after,omitempty"`
+	// Set when a purge claims the workspace; it can no longer be restored.
+	PurgeStartedAt string `json:"-" firestore:"purge_started_at,omitempty"`
+	// ID of the workspace_deletions record tracking the current deletion.
+	DeletionID string `json:"-" firestore:"deletion_id,omitempty"`
+	// Overrides of the global destructive sync thresholds; nil uses the defaults.
+	DestructiveSync *DestructiveSyncSettings `json:"destructiveSync,omitempty" firestore:"destructive_sync,omitempty"`
+	// Overrides the global MaxConcurrentJobs; 0 uses the default.
+	MaxConcurrentJobs int `json:"maxConcurrentJobs,omitempty" firestore:"max_concurrent_jobs,omitempty"`
+}
+
+// DestructiveSyncSettings are a workspace's destructive sync thresholds. A zero field uses
+// the global default.
+type DestructiveSyncSettings struct {
+	MaxDeletePercent int `json:"maxDeletePercent,omitempty" firestore:"max_delete_percent,omitempty" binding:"min=0,max=100"`
+	MaxDeleteCount   int `json:"maxDeleteCount,omitempty" firestore:"max_delete_count,omitempty" binding:"min=0"`
 }
 
 // CreateWorkspaceRequest defines the expected request body for creating a new workspace.
@@ -24,6 +99,8 @@ type CreateWorkspaceRequest struct {
 	Name      string `json:"name" binding:"required"`
 	UserEmail string `json:"userEmail,omitempty"`
 	UserName  string `json:"userName,omitempty"`
+	// TemplateID seeds the workspace with a template's files; see GET /workspace-templates.
+	TemplateID string `json:"templateId,omitempty"`
 }
 
 // CreateWorkspaceResponse is the response after creating a new workspace.
@@ -35,13 +112,151 @@ type CreateWorkspaceResponse struct {
 	InitialVersion string `json:"initialVersion"` // Added initial version
 }
 
+// DefaultWorkspaceRequest is the optional body of POST /workspaces/default.
+type DefaultWorkspaceRequest struct {
+	UserEmail string `json:"userEmail,omitempty"`
+	UserName  string `json:"userName,omitempty"`
+}
+
+// DeleteWorkspaceResponse is returned by DELETE /workspaces/:workspaceId.
+type DeleteWorkspaceResponse struct {
+	WorkspaceID string `json:"workspaceId"`
+	Permanent   bool   `json:"permanent"`
+	DeletedAt   string `json:"deletedAt,omitempty"`
+	PurgeAfter  string `json:"purgeAfter,omitempty"` // Restorable until then
+	DeletionID  string `json:"deletionId"`           // Poll GET /workspace-deletions/:deletionId
+}
+
+// WorkspaceDeletion tracks one deletion of a workspace (workspace_deletions/{id}) so its
+// progress can be polled after the workspace and its memberships are gone.
+type WorkspaceDeletion struct {
+	DeletionID  string `json:"deletionId" firestore:"deletion_id"`
+	WorkspaceID string `json:"workspaceId" firestore:"workspace_id"`
+	RequestedBy string `json:"-" firestore:"requested_by"`
+	Status      string `json:"status" firestore:"status"` // See workspaceDeletion* constants
+	Error       string `json:"error,omitempty" firestore:"error,omitempty"`
+	Objects     int    `json:"objects,omitempty" firestore:"objects,omitempty"`     // R2 objects deleted
+	Documents   int    `json:"documents,omitempty" firestore:"documents,omitempty"` // Firestore documents deleted
+	CreatedAt   string `json:"createdAt" firestore:"created_at"`                    // ISO 8601 string
+	UpdatedAt   string `json:"updatedAt" firestore:"updated_at"`                    // ISO 8601 string
+	PurgeAfter  string `json:"purgeAfter,omitempty" firestore:"purge_after,omitempty"`
+	// Set once the deletion finished or was cancelled; the record is removed after it.
+	DeleteAfter time.Time `json:"-" firestore:"delete_after,omitempty"`
+}
+
+// RenameWorkspaceRequest is the request body for PATCH /workspaces/:workspaceId.
+type RenameWorkspaceRequest struct {
+	Name string `json:"name" binding:"required,max=200"`
+}
+
+// SetReadOnlyRequest is the request body for PUT /workspaces/:workspaceId/read-only.
+type SetReadOnlyRequest struct {
+	ReadOnly *bool `json:"readOnly" binding:"required"`
+}
+
+// ConcurrencySettingsRequest sets a workspace's concurrent job limit; 0 restores the
+// global default.
+type ConcurrencySettingsRequest struct {
+	MaxConcurrentJobs *int `json:"maxConcurrentJobs" binding:"required,min=0,max=1000"`
+}
+
+// WorkspaceSettings are a workspace's execution defaults, stored at
+// workspaces/{workspaceId}/meta/settings. Authenticated executions that omit language or
+// entrypointFile use DefaultLanguage and DefaultEntrypoint.
+type WorkspaceSettings struct {
+	DefaultLanguage   string `json:"defaultLanguage" firestore:"default_language"`
+	DefaultEntrypoint string `json:"defaultEntrypoint" firestore:"default_entrypoint"`
+	AutoRunOnSync     bool   `json:"autoRunOnSync" firestore:"auto_run_on_sync"`
+	RagEnabled        bool   `json:"ragEnabled" firestore:"rag_enabled"`
+	UpdatedAt         string `json:"updatedAt,omitempty" firestore:"updated_at,omitempty"`
+	UpdatedBy         string `json:"updatedBy,omitempty" firestore:"updated_by,omitempty"`
+}
+
+// WorkspaceSettingsRequest replaces a workspace's settings; empty strings clear a default.
+type WorkspaceSettingsRequest struct {
+	DefaultLanguage   string `json:"defaultLanguage"`
+	DefaultEntrypoint string `json:"defaultEntrypoint"`
+	AutoRunOnSync     bool   `json:"autoRunOnSync"`
+	RagEnabled        bool   `json:"ragEnabled"`
+}
+
+// JobShareRequest asks for a share link to a workspace job; ExpiresInHours defaults to the
+// configured link lifetime and may not exceed it.
+type JobShareRequest struct {
+	ExpiresInHours int `json:"expiresInHours" binding:"omitempty,min=1"`
+}
+
+// WorkspaceShareLink lets anyone holding its token read a workspace's files until ExpiresAt
+// or until it is revoked. Only the token's SHA-256 is stored.
+type WorkspaceShareLink struct {
+	LinkID      string    `json:"linkId" firestore:"link_id"`
+	WorkspaceID string    `json:"workspaceId" firestore:"workspace_id"`
+	TokenHash   string    `json:"-" firestore:"token_hash"`
+	CreatedBy   string    `json:"createdBy" firestore:"created_by"`
+	CreatedAt   string    `json:"createdAt" firestore:"created_at"` // ISO 8601
+	ExpiresAt   string    `json:"expiresAt" firestore:"expires_at"` // ISO 8601
+	RevokedAt   string    `json:"revokedAt,omitempty" firestore:"revoked_at,omitempty"`
+	DeleteAfter time.Time `json:"-" firestore:"delete_after"` // Firestore TTL field
+}
+
+// WorkspaceShareLinkRequest asks for a workspace share link; ExpiresInHours defaults to the
+// configured link lifetime and may not exceed it.
+type WorkspaceShareLinkRequest struct {
+	ExpiresInHours int `json:"expiresInHours" binding:"omitempty,min=1"`
+}
+
+// WorkspaceShareLinkResponse returns a new share link. The token is shown only once.
+type WorkspaceShareLinkResponse struct {
+	LinkID    string `json:"linkId"`
+	Token     string `json:"token"`
+	Path      string `json:"path"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// SharedWorkspaceFile is a manifest entry as shown through a share link: only what is needed
+// to browse and download, none of the IDs, keys or hashes sync works with.
+type SharedWorkspaceFile struct {
+	FilePath   string `json:"filePath"`
+	Type       string `json:"type"`
+	Size       int64  `json:"size,omitempty"`
+	UpdatedAt  string `json:"updatedAt"`
+	ContentURL string `json:"contentUrl,omitempty"`
+}
+
+// SharedWorkspaceManifest is the response for GET /shared/:token/manifest.
+type SharedWorkspaceManifest struct {
+	Name             string                `json:"name"`
+	WorkspaceVersion string                `json:"workspaceVersion"`
+	Manifest         []SharedWorkspaceFile `json:"manifest"`
+	ExpiresAt        string                `json:"expiresAt"`
+}
+
 // WorkspaceSummary defines the data structure for listing workspaces for a user.
 type WorkspaceSummary struct {
 	WorkspaceID string `json:"workspaceId"`
 	Name        string `json:"name"`
 	CreatedBy   string `json:"createdBy"`
+	OwnedBy     string `json:"ownedBy"`
 	CreatedAt   string `json:"createdAt"` // ISO 8601 string
 	UserRole    string `json:"userRole"`
+	ExpiresAt   string `json:"expiresAt,omitempty"` // ISO 8601; when the caller's access ends
+	// ISO 8601; the workspace's last activity, or its last update or creation before
+	// activity was tracked.
+	LastActivityAt string `json:"lastActivityAt"`
+	// Current members and files, left out with ?includeCounts=false; -1 when counting failed.
+	MemberCount *int64 `json:"memberCount,omitempty"`
+	FileCount   *int64 `json:"fileCount,omitempty"`
+	// Set on soft-deleted workspaces, which are only listed with ?includeTrashed=true.
+	Status     string `json:"status,omitempty"`
+	DeletedAt  string `json:"deletedAt,omitempty"`
+	PurgeAfter string `json:"purgeAfter,omitempty"` // Restorable until then
+}
+
+// WorkspaceListResponse is the paged response of GET /workspaces, returned when limit or
+// cursor is given.
+type WorkspaceListResponse struct {
+	Workspaces []WorkspaceSummary `json:"workspaces"`
+	NextCursor string             `json:"nextCursor,omitempty"` // Empty on the last page
 }
 
 // WorkspaceMembership links a user to a workspace with a specific role.
@@ -53,6 +268,64 @@ type WorkspaceMembership struct {
 	UserName     string `json:"userName" firestore:"user_name"`
 	Role         string `json:"role" firestore:"role"`
 	JoinedAt     string `json:"joinedAt" firestore:"joined_at"` // ISO 8601 string
+
+	// Time-boxed members lose access at ExpiresAt (ISO 8601); empty means no expiry.
+	ExpiresAt string `json:"expiresAt,omitempty" firestore:"expires_at,omitempty"`
+
+	// Notification preferences; see GET /workspaces/:workspaceId/notifications/preferences.
+	NotifyOn     *[]string `json:"notifyOn,omitempty" firestore:"notify_on,omitempty"` // nil means every category
+	Digest       string    `json:"digest,omitempty" firestore:"digest,omitempty"`      // "off" (default) or "daily"
+	LastDigestAt string    `json:"-" firestore:"last_digest_at,omitempty"`             // ISO 8601; end of the last digest window
+}
+
+// NotificationPreferences is a member's notification settings for one workspace.
+type NotificationPreferences struct {
+	WorkspaceID string   `json:"workspaceId"`
+	NotifyOn    []string `json:"notifyOn"` // Categories: "sync", "job_failed", "member_added"
+	Digest      string   `json:"digest"`   // "off" or "daily"
+}
+
+// NotificationPreferencesRequest is the request body for PUT
+// /workspaces/:workspaceId/notifications/preferences. Omitted fields are left unchanged.
+type NotificationPreferencesRequest struct {
+	NotifyOn *[]string `json:"notifyOn"`
+	Digest   *string   `json:"digest" binding:"omitempty,oneof=off daily"`
+}
+
+// WorkspaceMember is one entry of GET /workspaces/:workspaceId/members.
+type WorkspaceMember struct {
+	MembershipID  string `json:"membershipId"`
+	UserID        string `json:"userId"`
+	UserEmail     string `json:"userEmail"`
+	UserName      string `json:"userName"`
+	Role          string `json:"role"`
+	JoinedAt      string `json:"joinedAt"`                // ISO 8601 string
+	ExpiresAt     string `json:"expiresAt,omitempty"`     // ISO 8601 string
+	Expired       bool   `json:"expired,omitempty"`       // Access has lapsed; the membership awaits cleanup
+	IsCurrentUser bool   `json:"isCurrentUser,omitempty"` // The caller's own membership; set by GET /members
+}
+
+// UpdateMemberRequest is the request body for PATCH /workspaces/:workspaceId/members/:userId.
+// Omitted fields are left unchanged; clearExpiry makes the membership permanent.
+type UpdateMemberRequest struct {
+	Role        string `json:"role" binding:"omitempty,oneof=editor viewer"`
+	ExpiresAt   string `json:"expiresAt"` // RFC 3339
+	ClearExpiry bool   `json:"clearExpiry"`
+}
+
+// TransferOwnershipRequest is the request body for POST /workspaces/:workspaceId/transfer-ownership.
+// With keepAsEditor the caller is downgraded to editor; otherwise they stay an owner.
+type TransferOwnershipRequest struct {
+	NewOwnerMembershipID string `json:"newOwnerMembershipId" binding:"required"`
+	KeepAsEditor         bool   `json:"keepAsEditor"`
+}
+
+// TransferOwnershipResponse is returned by a successful ownership transfer.
+type TransferOwnershipResponse struct {
+	WorkspaceID   string `json:"workspaceId"`
+	OwnedBy       string `json:"ownedBy"`
+	PreviousOwner string `json:"previousOwner"`
+	CallerRole    string `json:"callerRole"`
 }
 
 // --- Structs for File Manifest ---
@@ -65,8 +338,9 @@ type FileMetadata struct {
 	R2ObjectKey string `json:"r2ObjectKey,omitempty" firestore:"r2_object_key,omitempty"`
 	Size        int64  `json:"size,omitempty" firestore:"size,omitempty"`
 	Hash        string `json:"hash,omitempty" firestore:"hash,omitempty"`
-	CreatedAt   string `json:"createdAt" firestore:"created_at"`  // ISO 8601 string
-	UpdatedAt   string `json:"updatedAt" firestore:"updated_at"`  // ISO 8601 string
+	CreatedAt   string `json:"createdAt" firestore:"created_at"`                // ISO 8601 string
+	UpdatedAt   string `json:"updatedAt" firestore:"updated_at"`                // ISO 8601 string
+	Version     string `json:"version,omitempty" firestore:"version,omitempty"` // Workspace version that last wrote this entry
 	ContentURL  string `json:"contentUrl,omitempty" firestore:"-"` 
 }
 
@@ -76,6 +350,121 @@ type WorkspaceManifestResponse struct {
 	WorkspaceVersion string         `json:"workspaceVersion"`
 }
 
+// RefreshFileURLsRequest is the request body for POST /workspaces/:workspaceId/files/refresh-urls.
+type RefreshFileURLsRequest struct {
+	FileIDs []string `json:"fileIds" binding:"required,min=1,max=200"`
+	// ExtendedExpiry asks for long-lived URLs for files at or above the large-file threshold,
+	// so a resumed download does not outlive its URL.
+	ExtendedExpiry bool `json:"extendedExpiry,omitempty"`
+	// Immutable signs the URLs with a response Cache-Control that lets CDNs cache the
+	// content for as long as the URL is valid.
+	Immutable bool `json:"immutable,omitempty"`
+}
+
+// RefreshedFileURL is the outcome for one requested file. Either ContentURL and URLExpiresAt
+// are set, or Error is "not_found", "not_a_file" or "presign_failed".
+type RefreshedFileURL struct {
+	FileID       string `json:"fileId"`
+	FilePath     string `json:"filePath,omitempty"`
+	ContentURL   string `json:"contentUrl,omitempty"`
+	URLExpiresAt string `json:"urlExpiresAt,omitempty"` // ISO 8601 string
+	Error        string `json:"error,omitempty"`
+
+	// Capabilities of ContentURL. When AcceptRanges is set, a client can resume an
+	// interrupted download with a Range request against Size.
+	Size           int64  `json:"size,omitempty"`
+	AcceptRanges   bool   `json:"acceptRanges,omitempty"`
+	ExtendedExpiry bool   `json:"extendedExpiry,omitempty"` // URL uses LargeFileURLTTL
+	CacheControl   string `json:"cacheControl,omitempty"`   // Cache-Control R2 will send with the content
+}
+
+// RefreshFileURLsResponse lists one entry per distinct requested file ID, in request order.
+type RefreshFileURLsResponse struct {
+	Files []RefreshedFileURL `json:"files"`
+}
+
+// BulkDeleteFilesRequest is the request body for POST /workspaces/:workspaceId/files/bulk-delete.
+type BulkDeleteFilesRequest struct {
+	Paths []string `json:"paths" binding:"required,min=1,max=100"` // Files, or folders to delete with their contents
+}
+
+// BulkDeleteResult is the outcome for one requested path: "deleted", "not_found" or "failed".
+type BulkDeleteResult struct {
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	Deleted int    `json:"deleted"` // Files and folders removed for this path
+}
+
+// BulkDeleteFilesResponse reports each distinct requested path, in request order.
+type BulkDeleteFilesResponse struct {
+	WorkspaceVersion string             `json:"workspaceVersion,omitempty"` // Omitted when nothing was deleted
+	Results          []BulkDeleteResult `json:"results"`
+}
+
+// CreateFolderRequest is the request body for POST /workspaces/:workspaceId/folders.
+type CreateFolderRequest struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// RenameFolderRequest is the request body for PATCH /workspaces/:workspaceId/folders/*path.
+type RenameFolderRequest struct {
+	NewPath string `json:"newPath" binding:"required"` // Must not exist and must not be inside the folder
+}
+
+// FolderResponse is returned by the folder endpoints.
+type FolderResponse struct {
+	WorkspaceVersion string        `json:"workspaceVersion"`
+	Folder           *FileMetadata `json:"folder,omitempty"`         // The created or renamed folder
+	CreatedFolders   []string      `json:"createdFolders,omitempty"` // Missing parent folders created along the way
+	EntriesMoved     int           `json:"entriesMoved,omitempty"`   // Rename: the folder and everything inside it
+	EntriesDeleted   int           `json:"entriesDeleted,omitempty"` // Delete: the folder and everything inside it
+}
+
+// --- Structs for Editor Drafts ---
+
+// FileDraft is one user's autosaved, uncommitted content for a file (file_drafts/{draftId}).
+// Drafts live outside the files collection, so they never reach the manifest or RAG indexing.
+type FileDraft struct {
+	WorkspaceID string    `json:"workspaceId" firestore:"workspace_id"`
+	UserID      string    `json:"-" firestore:"user_id"`
+	FilePath    string    `json:"filePath" firestore:"file_path"`
+	R2ObjectKey string    `json:"-" firestore:"r2_object_key"` // workspaces/{ws}/drafts/{userId}/{draftFileId}
+	Size        int64     `json:"size" firestore:"size"`
+	BaseHash    string    `json:"baseHash,omitempty" firestore:"base_hash,omitempty"` // Hash of the file the draft was started from
+	CreatedAt   string    `json:"createdAt" firestore:"created_at"`                   // ISO 8601 string
+	UpdatedAt   string    `json:"updatedAt" firestore:"updated_at"`                   // ISO 8601 string
+	ExpiresAt   string    `json:"expiresAt" firestore:"expires_at"`                   // ISO 8601 string; pushed back by each save
+	DeleteAfter time.Time `json:"-" firestore:"delete_after"`                         // TTL backstop, a day after expires_at
+	ContentURL  string    `json:"contentUrl,omitempty" firestore:"-"`
+}
+
+// SaveDraftRequest is the request body for PATCH /workspaces/:workspaceId/files/*path/draft.
+type SaveDraftRequest struct {
+	Content  string `json:"content"`
+	BaseHash string `json:"baseHash,omitempty"` // Hash of the file as the editor loaded it; kept from the first save if omitted
+}
+
+// PromoteDraftRequest is the request body for POST /workspaces/:workspaceId/files/draft/promote.
+type PromoteDraftRequest struct {
+	FilePath string `json:"filePath" binding:"required"`
+	Force    bool   `json:"force,omitempty"` // Commit even if the file changed since the draft's base
+}
+
+// PromoteDraftResponse reports the commit a promoted draft produced.
+type PromoteDraftResponse struct {
+	Status           string        `json:"status"` // "success", "draft_conflict" or "quota_exceeded"
+	WorkspaceVersion string        `json:"workspaceVersion,omitempty"`
+	File             *FileMetadata `json:"file,omitempty"`
+	Totals           *SyncTotals   `json:"totals,omitempty"`
+	ErrorMessage     string        `json:"errorMessage,omitempty"`
+	StorageQuota     *StorageQuota `json:"storageQuota,omitempty"` // Set on quota_exceeded
+}
+
+// ListDraftsResponse is the response for GET /workspaces/:workspaceId/drafts.
+type ListDraftsResponse struct {
+	Drafts []FileDraft `json:"drafts"`
+}
+
 // --- Structs for Sync Endpoint (/workspaces/:workspaceId/sync) ---
 
 // SyncFileClientState represents a single file's state as known by the client.
@@ -84,6 +473,7 @@ type SyncFileClientState struct {
 	Type       string `json:"type" binding:"required"`
 	ClientHash string `json:"clientHash,omitempty"`
 	Action     string `json:"action" binding:"required"` // "new", "modified", "deleted", "unchanged"
+	Size       int64  `json:"size,omitempty"`            // Optional; dry-run byte totals and the storage quota check
 }
 
 // SyncRequest is the request body for POST /api/sync/:workspaceId.
@@ -105,10 +495,63 @@ type SyncResponseFileAction struct {
 
 // SyncResponse is the response body from POST /api/sync/:workspaceId.
 type SyncResponse struct {
-	Status              string                   `json:"status"` // "pending_confirmation", "workspace_conflict", "no_changes", "error"
+	Status              string                   `json:"status"` // "pending_confirmation", "workspace_conflict", "no_changes", "dry_run", "error"
 	Actions             []SyncResponseFileAction `json:"actions"`
 	NewWorkspaceVersion string                   `json:"newWorkspaceVersion,omitempty"`
 	ErrorMessage        string                   `json:"errorMessage,omitempty"`
+	Summary             *SyncPlanSummary         `json:"summary,omitempty"` // Only set for dry runs
+	// Set when the client was behind but changed no path the server changed in the meantime;
+	// the plan was made against the current version and the client should pull ServerChanges.
+	AutoRebased   bool              `json:"autoRebased,omitempty"`
+	ServerChanges []WorkspaceChange `json:"serverChanges,omitempty"`
+	// On workspace_conflict, the client paths that overlap server changes, when known.
+	ConflictingPaths []string `json:"conflictingPaths,omitempty"`
+	// On quota_exceeded, the workspace size the sync would reach and the quota.
+	StorageQuota *StorageQuota `json:"storageQuota,omitempty"`
+}
+
+// StorageQuota compares the size a sync would leave a workspace at with its quota.
+type StorageQuota struct {
+	ProjectedBytes int64 `json:"projectedBytes"`
+	LimitBytes     int64 `json:"limitBytes"`
+}
+
+// WorkspaceChange is one path written by a commit.
+type WorkspaceChange struct {
+	FilePath string `json:"filePath" firestore:"file_path"`
+	Type     string `json:"type" firestore:"type"`     // "file" or "folder"
+	Action   string `json:"action" firestore:"action"` // "upsert" or "delete"
+	Version  string `json:"version" firestore:"-"`     // Workspace version of the commit
+}
+
+// WorkspaceChangeLog records what one commit changed (workspaces/{id}/changes/{version}).
+type WorkspaceChangeLog struct {
+	WorkspaceVersion string            `firestore:"workspace_version"`
+	Changes          []WorkspaceChange `firestore:"changes"`
+	CommittedAt      string            `firestore:"committed_at"` // ISO 8601 string
+	DeleteAfter      time.Time         `firestore:"delete_after"`
+}
+
+// SyncPlanSummary aggregates the planned actions of a dry-run sync.
+type SyncPlanSummary struct {
+	Uploads     int   `json:"uploads"`
+	Deletes     int   `json:"deletes"`
+	Unchanged   int   `json:"unchanged"`
+	UploadBytes int64 `json:"uploadBytes"`
+	DeleteBytes int64 `json:"deleteBytes"`
+}
+
+func (s *SyncPlanSummary) add(actionRequired string, bytes int64) {
+	switch actionRequired {
+	case "upload":
+		s.Uploads++
+		s.UploadBytes += bytes
+	case "delete":
+		s.Deletes++
+		s.DeleteBytes += bytes
+	default:
+		s.Unchanged++
+	}
 }
 
 // --- Structs for Confirm Sync Endpoint (/workspaces/:workspaceId/sync/confirm) ---
@@ -121,13 +564,15 @@ type FileAction struct {
 	R2ObjectKey string `json:"r2ObjectKey"` // Key for new object in "upsert", old object in "delete"
 	Action      string `json:"action" binding:"required"` // "upsert", "delete"
 	ClientHash  string `json:"clientHash,omitempty"`      // For "upsert"
-	Size        int64  `json:"size,omitempty"`            // For "upsert"
+	Size        *int64 `json:"size,omitempty"`            // For "upsert"; omitted keeps the previous size
 }
 
 // ConfirmSyncRequest is the request body for POST /api/sync/:workspaceId/confirm.
 type ConfirmSyncRequest struct {
 	WorkspaceVersion string       `json:"workspaceVersion" binding:"required"`
 	SyncActions      []FileAction `json:"syncActions" binding:"required"`
+	// ConfirmDestructive commits a sync that the destructive sync guard would otherwise reject.
+	ConfirmDestructive bool `json:"confirmDestructive,omitempty"`
 }
 
 // ConfirmSyncResponse is the response body for the confirmation step.
@@ -135,21 +580,63 @@ type ConfirmSyncResponse struct {
 	Status              string `json:"status"` // "success", "error"
 	FinalWorkspaceVersion string `json:"finalWorkspaceVersion,omitempty"`
 	ErrorMessage        string `json:"errorMessage,omitempty"`
+	Totals              *SyncTotals `json:"totals,omitempty"` // Set on success
+	// Set when the destructive sync guard rejected the sync, or let a confirmed one through.
+	DestructiveChange *DestructiveChange `json:"destructiveChange,omitempty"`
+	// Set on quota_exceeded, once the uploaded objects' actual sizes were counted.
+	StorageQuota *StorageQuota `json:"storageQuota,omitempty"`
+}
+
+// DestructiveChange describes a sync that crossed the destructive sync thresholds.
+type DestructiveChange struct {
+	FilesDeleted     int `json:"filesDeleted"`
+	WorkspaceFiles   int `json:"workspaceFiles"` // Files in the workspace before the sync
+	MaxDeletePercent int `json:"maxDeletePercent"`
+	MaxDeleteCount   int `json:"maxDeleteCount"`
+}
+
+// SyncTotals counts the files and bytes changed by a committed sync.
+type SyncTotals struct {
+	FilesUpserted int   `json:"filesUpserted"`
+	FilesDeleted  int   `json:"filesDeleted"`
+	BytesAdded    int64 `json:"bytesAdded"`
+	BytesRemoved  int64 `json:"bytesRemoved"`
 }
 
 // --- Structs for Authenticated Code Execution ---
 
 // ExecuteAuthRequest is the request body for the authenticated code execution endpoint.
 type ExecuteAuthRequest struct {
-	Language       string `json:"language" binding:"required"`
-	EntrypointFile string `json:"entrypointFile" binding:"required"`
+	// Language and EntrypointFile default to the workspace's settings; see WorkspaceSettings.
+	Language       string `json:"language"`
+	EntrypointFile string `json:"entrypointFile"`
 	Input          string `json:"input,omitempty"`
+	// InputFilePath names a workspace file fed to the program as stdin; exclusive with Input.
+	InputFilePath string `json:"inputFilePath,omitempty"`
+	// ExpectedWorkspaceVersion makes the request fail with 409 workspace_version_mismatch
+	// unless the workspace is still at this version, so clients run exactly what they synced.
+	ExpectedWorkspaceVersion string `json:"expectedWorkspaceVersion,omitempty"`
+	// ScheduleAt (ISO 8601) holds the run until then; the job is "scheduled" meanwhile. The run
+	// uses the files as they are when it is scheduled.
+	ScheduleAt string `json:"scheduleAt,omitempty"`
+	// NotifyOnCompletion emails the caller when the job finishes. It is ignored when no
+	// notifications service is configured.
+	NotifyOnCompletion bool `json:"notifyOnCompletion,omitempty"`
+	ExecutionOptions
+	JobCallbackOptions
+}
+
+// WarmRequest is the request body for POST /workspaces/:workspaceId/execute/warm.
+type WarmRequest struct {
+	Language string `json:"language" binding:"required"`
 }
 
 type ExecuteAuthResponse struct {
 	Message                string `json:"message"`
 	JobID                  string `json:"job_id"`
 	FinalWorkspaceVersion  string `json:"finalWorkspaceVersion,omitempty"`
+	Replayed               bool   `json:"replayed,omitempty"` // Set when an Idempotency-Key matched an earlier job
+	ScheduledAt            string `json:"scheduledAt,omitempty"`
 }
 
 // --- Structs for Jobs & Cloud Tasks (existing, largely unchanged for this refactor scope) ---
@@ -157,9 +644,9 @@ type ExecuteAuthResponse struct {
 // Job struct stores information about a code execution job.
 type Job struct {
 	Status         string `json:"status" firestore:"status"`
-	Code           string `json:"code,omitempty" firestore:"-"`
+	Code           string `json:"-" firestore:"code,omitempty"` // Public executions only; kept for requeues, never returned to clients
 	Language       string `json:"language" firestore:"language"`
-	Input          string `json:"input,omitempty" firestore:"-"`
+	Input          string `json:"-" firestore:"input,omitempty"` // Kept for requeues and retries, never returned to clients
 	Output         string `json:"output,omitempty" firestore:"output,omitempty"`
 	Error          string `json:"error,omitempty" firestore:"error,omitempty"`
 	SubmittedAt    string `json:"submittedAt" firestore:"submitted_at"`                 // ISO 8601 string
@@ -167,15 +654,86 @@ type Job struct {
 	UserID         string `json:"userID,omitempty" firestore:"user_id,omitempty"`
 	WorkspaceID    string `json:"workspaceID,omitempty" firestore:"workspace_id,omitempty"`
 	EntrypointFile string `json:"entrypointFile,omitempty" firestore:"entrypoint_file,omitempty"`
+	InputFilePath  string `json:"inputFilePath,omitempty" firestore:"input_file_path,omitempty"`
 	ExecutionType  string `json:"executionType,omitempty" firestore:"execution_type,omitempty"`
+	// DeleteAfter is the Firestore TTL marker; readers treat the job as absent once it has passed.
+	DeleteAfter time.Time `json:"-" firestore:"delete_after,omitempty"`
+	// Replay keeps the rest of what is needed to re-enqueue the job's Cloud Task; never returned to clients.
+	Replay *JobReplay `json:"-" firestore:"replay,omitempty"`
+	// Written by the worker when the job finishes.
+	FailureType string `json:"failureType,omitempty" firestore:"failure_type,omitempty"`
+	CompletedAt string `json:"completedAt,omitempty" firestore:"completed_at,omitempty"` // ISO 8601 string
+	// Written by the worker on every status change and heartbeat while it runs the job.
+	UpdatedAt       string `json:"-" firestore:"updated_at,omitempty"`
+	LastHeartbeatAt string `json:"lastHeartbeatAt,omitempty" firestore:"last_heartbeat_at,omitempty"` // ISO 8601 string
+	// Per-job result callback; the secret is never returned to clients.
+	CallbackURL      string           `json:"callbackUrl,omitempty" firestore:"callback_url,omitempty"`
+	CallbackSecret   string           `json:"-" firestore:"callback_secret,omitempty"`
+	CallbackDelivery *WebhookDelivery `json:"callbackDelivery,omitempty" firestore:"callback_delivery,omitempty"`
+	CallbackAttempts int              `json:"-" firestore:"callback_attempts,omitempty"`
+	// How the execution manifest reached the worker: "inline" or "reference"; authenticated jobs only.
+	ManifestMode string `json:"manifestMode,omitempty" firestore:"manifest_mode,omitempty"`
+	// Unguessable token for the shareable result page; public executions only.
+	ResultToken string `json:"-" firestore:"result_token,omitempty"`
+	// Pinned jobs have no delete_after, so TTL never removes them; workspace executions only.
+	Pinned   bool   `json:"pinned,omitempty" firestore:"pinned,omitempty"`
+	PinnedAt string `json:"pinnedAt,omitempty" firestore:"pinned_at,omitempty"` // ISO 8601 string
+	PinnedBy string `json:"pinnedBy,omitempty" firestore:"pinned_by,omitempty"`
+	// Requested execution deadline; a job still queued after it is never run.
+	Deadline string `json:"deadline,omitempty" firestore:"deadline,omitempty"` // ISO 8601 string
+	// When a scheduled job's task is dispatched; see jobStatusScheduled.
+	ScheduledAt string `json:"scheduledAt,omitempty" firestore:"scheduled_at,omitempty"` // ISO 8601 string
+	// Paths of the dependency manifests detected when the job was submitted; workspace executions only.
+	DependencyManifests []string `json:"dependencyManifests,omitempty" firestore:"dependency_manifests,omitempty"`
+	// Name of the job's Cloud Task, deleted when the job is cancelled before it is dispatched.
+	TaskName string `json:"-" firestore:"task_name,omitempty"`
+	// When RequeueJobs last recreated the job's task.
+	RequeuedAt string `json:"-" firestore:"requeued_at,omitempty"` // ISO 8601 string
+	// Set when cancellation was requested after a worker picked the job up; the worker stops it.
+	CancelRequested   bool   `json:"cancelRequested,omitempty" firestore:"cancel_requested,omitempty"`
+	CancelRequestedAt string `json:"-" firestore:"cancel_requested_at,omitempty"` // ISO 8601 string
+	// When the worker started the job, as reported to HandleJobStatus.
+	StartedAt string `json:"startedAt,omitempty" firestore:"started_at,omitempty"` // ISO 8601 string
+	// Run time from StartedAt to CompletedAt; absent for jobs finished without a known start.
+	DurationMs int64 `json:"durationMs,omitempty" firestore:"duration_ms,omitempty"`
+	// ID of the failed job this one retries; workspace executions only.
+	RetryOf string `json:"retryOf,omitempty" firestore:"retry_of,omitempty"`
+	// Execution priority, which selected the job's queue: "interactive" or "batch".
+	Priority string `json:"priority,omitempty" firestore:"priority,omitempty"`
+	// Labels given when the job was submitted; filtered on as labels.<key>.
+	Labels map[string]string `json:"labels,omitempty" firestore:"labels,omitempty"`
+	// R2 key of the full output when it was too long to keep inline; Output then holds its
+	// start and OutputTruncated is set.
+	OutputR2Key     string `json:"-" firestore:"output_r2_key,omitempty"`
+	OutputTruncated bool   `json:"outputTruncated,omitempty" firestore:"output_truncated,omitempty"`
+	// Set when the submitter asked to be notified when the job finishes; NotificationSent
+	// once the notification was queued, so it is sent at most once.
+	NotificationRequested bool `json:"notificationRequested,omitempty" firestore:"notification_requested,omitempty"`
+	NotificationSent      bool `json:"-" firestore:"notification_sent,omitempty"`
+	// Set when the job's share links were revoked; GET /shared/jobs/:token then refuses them.
+	ShareRevoked bool `json:"shareRevoked,omitempty" firestore:"share_revoked,omitempty"`
+}
+
+// JobReplay is the part of an execution task payload that is stored nowhere else on the job;
+// code, input and input file path are read from the job itself. Authenticated jobs re-read
+// the workspace manifest at requeue time instead of storing it.
+type JobReplay struct {
+	TimeoutSeconds int `firestore:"timeout_seconds,omitempty"`
+	MemoryMb       int `firestore:"memory_mb,omitempty"`
 }
 
 // CloudTaskPayload is the structure for public code execution.
 type CloudTaskPayload struct {
-	JobID    string `json:"job_id"`
-	Code     string `json:"code"`
-	Language string `json:"language"`
-	Input    string `json:"input"`
+	JobID          string `json:"job_id"`
+	Code           string `json:"code"`
+	Language       string `json:"language"`
+	Input          string `json:"input"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+	MemoryMb       int    `json:"memory_mb,omitempty"`
+	// NotifyCompletion is kept for older workers, which report a finished job to the API only
+	// when it is set; current workers report every status change.
+	NotifyCompletion bool   `json:"notify_completion,omitempty"`
+	Deadline         string `json:"deadline,omitempty"` // ISO 8601; the worker skips the job after it
 }
 
 // WorkerFile provides the necessary info for the worker to download a file.
@@ -191,8 +749,20 @@ type CloudTaskAuthPayload struct {
 	EntrypointFile string       `json:"entrypoint_file"`
 	Language       string       `json:"language"`
 	Input          string       `json:"input,omitempty"`
+	InputFile      *WorkerFile  `json:"input_file,omitempty"` // Workspace file to use as stdin instead of Input
 	R2BucketName   string       `json:"r2_bucket_name"`
-	Files          []WorkerFile `json:"files"`
+	Files          []WorkerFile `json:"files,omitempty"`
+	ManifestURL    string       `json:"manifest_url,omitempty"` // Set instead of Files when the manifest is too large to inline
+	Warm           bool         `json:"warm,omitempty"`         // Warm-up ping: the worker returns without executing
+	TimeoutSeconds int          `json:"timeout_seconds,omitempty"`
+	MemoryMb       int          `json:"memory_mb,omitempty"`
+	// NotifyCompletion is kept for older workers, which report a finished job to the API only
+	// when it is set; current workers report every status change.
+	NotifyCompletion bool   `json:"notify_completion,omitempty"`
+	Deadline         string `json:"deadline,omitempty"` // ISO 8601; the worker skips the job after it
+	// Dependency files (requirements.txt, ...) in the manifest; always inline so the worker can
+	// install dependencies before fetching the rest.
+	DependencyManifests []WorkerFile `json:"dependency_manifests,omitempty"`
 }
 
 // RAG Query payload for Cloud Tasks
@@ -210,8 +780,565 @@ type RagIndexingPayload struct {
 	Files       []WorkerFile `json:"files"`
 }
 
+// RagIndexStatus is a workspace's indexing backlog, stored in rag_status/{workspaceId}. It
+// only exists once an enqueue has failed; PendingFiles maps file path to R2 object key.
+type RagIndexStatus struct {
+	WorkspaceID  string            `firestore:"workspace_id"`
+	State        string            `firestore:"state"`
+	PendingFiles map[string]string `firestore:"pending_files"`
+	LastError    string            `firestore:"last_error,omitempty"`
+	UpdatedAt    string            `firestore:"updated_at"`
+}
+
+// RagStatusResponse is returned by GET /workspaces/:workspaceId/rag/status.
+type RagStatusResponse struct {
+	WorkspaceID      string `json:"workspaceId"`
+	State            string `json:"state"`
+	PendingFileCount int    `json:"pendingFileCount"`
+	LastError        string `json:"lastError,omitempty"`
+	UpdatedAt        string `json:"updatedAt,omitempty"`
+}
+
 // RAG Query request from frontend
 type RagQueryRequest struct {
 	Query       string `json:"query" binding:"required"`
 	WorkspaceID string `json:"workspaceId" binding:"required"`
-} 
\ No newline at end of file
+}
+
+// JobStatusResponse is the response for GET /api/jobs/:jobId, returned only to readers
+// allowed by the job's read policy (see canReadJob).
+type JobStatusResponse struct {
+	JobID                string            `json:"jobId"`
+	Status               string            `json:"status"`
+	Language             string            `json:"language"`
+	Output               string            `json:"output,omitempty"`          // The part of the output asked for; see GetJob
+	OutputOffset         int64             `json:"outputOffset,omitempty"`    // Byte offset of Output in the full output
+	OutputTotalBytes     int64             `json:"totalBytes,omitempty"`      // Size of the full output; absent when unknown
+	OutputTruncated      bool              `json:"outputTruncated,omitempty"` // Output is only part of the full output, at OutputURL when offloaded
+	OutputURL            string            `json:"outputUrl,omitempty"`
+	Error                string            `json:"error,omitempty"`
+	FailureType          string            `json:"failureType,omitempty"`
+	SubmittedAt          string            `json:"submittedAt"`
+	StartedAt            string            `json:"startedAt,omitempty"`
+	CompletedAt          string            `json:"completedAt,omitempty"`
+	DurationMs           int64             `json:"durationMs,omitempty"`
+	LastHeartbeatAt      string            `json:"lastHeartbeatAt,omitempty"` // Set while a worker runs the job
+	CancelRequested      bool              `json:"cancelRequested,omitempty"` // Stopping the running job was requested
+	Deadline             string            `json:"deadline,omitempty"`
+	CallbackURL          string            `json:"callbackUrl,omitempty"`
+	LastCallbackDelivery *WebhookDelivery  `json:"lastCallbackDelivery,omitempty"`
+	Labels               map[string]string `json:"labels,omitempty"`
+}
+
+// WorkspaceJobResult is the response for GET /workspaces/:workspaceId/jobs/:jobId. Output,
+// error and completedAt stay empty until the job finishes.
+type WorkspaceJobResult struct {
+	JobID            string            `json:"jobId"`
+	Status           string            `json:"status"`
+	Language         string            `json:"language"`
+	ExecutionType    string            `json:"executionType"`
+	EntrypointFile   string            `json:"entrypointFile,omitempty"`
+	Output           string            `json:"output,omitempty"`
+	OutputOffset     int64             `json:"outputOffset,omitempty"`
+	OutputTotalBytes int64             `json:"totalBytes,omitempty"`
+	OutputTruncated  bool              `json:"outputTruncated,omitempty"`
+	OutputURL        string            `json:"outputUrl,omitempty"`
+	Error            string            `json:"error,omitempty"`
+	FailureType      string            `json:"failureType,omitempty"`
+	SubmittedAt      string            `json:"submittedAt"`
+	StartedAt        string            `json:"startedAt,omitempty"`
+	CompletedAt      string            `json:"completedAt,omitempty"`
+	DurationMs       int64             `json:"durationMs,omitempty"`
+	ScheduledAt      string            `json:"scheduledAt,omitempty"`
+	RetryOf          string            `json:"retryOf,omitempty"` // The failed job this one retries
+	Labels           map[string]string `json:"labels,omitempty"`
+}
+
+// JobEvent is the data of a "status" event on GET /workspaces/:workspaceId/jobs/:jobId/events.
+// Output, error and completedAt are only set once the job has finished.
+type JobEvent struct {
+	JobID           string `json:"jobId"`
+	Status          string `json:"status"`
+	CancelRequested bool   `json:"cancelRequested,omitempty"`
+	Output          string `json:"output,omitempty"`
+	Error           string `json:"error,omitempty"`
+	FailureType     string `json:"failureType,omitempty"`
+	StartedAt       string `json:"startedAt,omitempty"`
+	CompletedAt     string `json:"completedAt,omitempty"`
+	DurationMs      int64  `json:"durationMs,omitempty"`
+}
+
+// WorkspaceJobSummary is one entry of GET /workspaces/:workspaceId/jobs. Output is left out;
+// clients fetch it from GET /jobs/:jobId.
+type WorkspaceJobSummary struct {
+	JobID          string            `json:"jobId"`
+	Status         string            `json:"status"`
+	Language       string            `json:"language"`
+	EntrypointFile string            `json:"entrypointFile,omitempty"`
+	UserID         string            `json:"userID,omitempty"`
+	FailureType    string            `json:"failureType,omitempty"`
+	SubmittedAt    string            `json:"submittedAt"`
+	StartedAt      string            `json:"startedAt,omitempty"`
+	CompletedAt    string            `json:"completedAt,omitempty"`
+	DurationMs     int64             `json:"durationMs,omitempty"`
+	ExpiresAt      string            `json:"expiresAt,omitempty"`
+	ScheduledAt    string            `json:"scheduledAt,omitempty"`
+	Pinned         bool              `json:"pinned,omitempty"`
+	PinnedAt       string            `json:"pinnedAt,omitempty"`
+	RetryOf        string            `json:"retryOf,omitempty"`
+	Priority       string            `json:"priority,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+// UserJobSummary is one entry of GET /jobs: a workspace job summary naming its workspace.
+// WorkspaceName is empty once the workspace is gone.
+type UserJobSummary struct {
+	WorkspaceJobSummary
+	WorkspaceID   string `json:"workspaceId"`
+	WorkspaceName string `json:"workspaceName,omitempty"`
+}
+
+// WorkspaceJobMetrics is the response for GET /workspaces/:workspaceId/jobs/metrics. ByStatus
+// is keyed by the status filters of GET /workspaces/:workspaceId/jobs.
+type WorkspaceJobMetrics struct {
+	Since      string           `json:"since"` // ISO 8601
+	Until      string           `json:"until"` // ISO 8601
+	Total      int64            `json:"total"`
+	ByStatus   map[string]int64 `json:"byStatus"`
+	ByLanguage map[string]int64 `json:"byLanguage"`
+	// FailureRate is failed / (completed + failed); cancelled and unfinished jobs are left out.
+	FailureRate float64                `json:"failureRate"`
+	LatencyMs   *JobLatencyPercentiles `json:"latencyMs,omitempty"` // Submission to completion; absent until a job finishes
+	// Approximate is set when more jobs matched than one request reads: latencies, and counts
+	// if they had to be taken from the scan, then cover only the newest jobs.
+	Approximate bool `json:"approximate,omitempty"`
+}
+
+// JobLatencyPercentiles are nearest-rank percentiles, in milliseconds, over Samples jobs.
+type JobLatencyPercentiles struct {
+	P50     int64 `json:"p50"`
+	P95     int64 `json:"p95"`
+	Samples int   `json:"samples"`
+}
+
+// WorkspaceStats is the response for GET /workspaces/:workspaceId/stats. Counts come from
+// aggregate queries; any that failed are listed in Partial and reported as zero.
+type WorkspaceStats struct {
+	WorkspaceID      string `json:"workspaceId"`
+	WorkspaceVersion string `json:"workspaceVersion"`
+	Files            int64  `json:"files"`
+	Folders          int64  `json:"folders"`
+	TotalBytes       int64  `json:"totalBytes"` // Sum of file sizes
+	Members          int64  `json:"members"`
+	// JobsByStatus counts workspace executions submitted since JobsSince, keyed by the status
+	// filters of GET /workspaces/:workspaceId/jobs.
+	JobsByStatus map[string]int64 `json:"jobsByStatus"`
+	JobsSince    string           `json:"jobsSince"` // ISO 8601
+	// Usage is the workspace's usage rollup for the current month.
+	Usage   *UsageRollup `json:"usage,omitempty"`
+	Partial []string     `json:"partial,omitempty"`
+}
+
+// JobArchiveObject is one archive object listed by GET /api/admin/jobs/archives; URL is a
+// presigned download link.
+type JobArchiveObject struct {
+	Key          string `json:"key"`
+	Size         int64  `json:"size"`
+	LastModified string `json:"lastModified,omitempty"` // ISO 8601
+	URL          string `json:"url"`
+}
+
+// PublicResultResponse is what a shared result link reveals. It deliberately omits the job
+// ID and anything that identifies the submitter.
+type PublicResultResponse struct {
+	Status          string `json:"status"`
+	Language        string `json:"language"`
+	Output          string `json:"output,omitempty"`
+	OutputTruncated bool   `json:"outputTruncated,omitempty"`
+	OutputURL       string `json:"outputUrl,omitempty"`
+	Error           string `json:"error,omitempty"`
+	FailureType     string `json:"failureType,omitempty"`
+	SubmittedAt     string `json:"submittedAt"`
+	StartedAt       string `json:"startedAt,omitempty"`
+	CompletedAt     string `json:"completedAt,omitempty"`
+	DurationMs      int64  `json:"durationMs,omitempty"`
+	ExpiresAt       string `json:"expiresAt,omitempty"`
+}
+
+// JobFinishedWebhook is the body of the "job.finished" callback.
+type JobFinishedWebhook struct {
+	JobID           string `json:"jobId"`
+	Status          string `json:"status"`
+	Language        string `json:"language"`
+	WorkspaceID     string `json:"workspaceId,omitempty"`
+	EntrypointFile  string `json:"entrypointFile,omitempty"`
+	Output          string `json:"output"`
+	OutputTruncated bool   `json:"outputTruncated,omitempty"`
+	OutputSHA256    string `json:"outputSha256"` // Hex SHA-256 of the full output, before truncation
+	Error           string `json:"error,omitempty"`
+	FailureType     string `json:"failureType,omitempty"`
+	SubmittedAt     string `json:"submittedAt"`
+	CompletedAt     string `json:"completedAt,omitempty"`
+}
+
+// JobResultReport is the optional body of POST /internal/jobs/:jobId/finished, sent by
+// workers that cannot write the job themselves (the embedded job store).
+type JobResultReport struct {
+	Status      string `json:"status" binding:"required,oneof=completed failed"`
+	Output      string `json:"output"`
+	Error       string `json:"error"`
+	FailureType string `json:"failure_type"`
+	CompletedAt string `json:"completed_at"` // ISO 8601; defaults to the time of the report
+}
+
+// JobStatusReport is the request body for POST /internal/jobs/:jobId/status.
+type JobStatusReport struct {
+	Status      string `json:"status" binding:"required,oneof=running completed failed cancelled"`
+	Output      string `json:"output"`
+	Error       string `json:"error"`
+	FailureType string `json:"failure_type"`
+	StartedAt   string `json:"started_at"`  // ISO 8601
+	FinishedAt  string `json:"finished_at"` // ISO 8601; defaults to the time of the report
+}
+
+// --- Structs for Usage Accounting ---
+
+// UsageRollup aggregates execution cost for one user or workspace in one calendar month.
+type UsageRollup struct {
+	Scope       string  `json:"scope" firestore:"scope"`          // "user" or "workspace"
+	SubjectID   string  `json:"subjectId" firestore:"subject_id"` // User ID or workspace ID
+	Month       string  `json:"month" firestore:"month"`          // YYYY-MM (UTC)
+	ExecutionMs int64   `json:"executionMs" firestore:"execution_ms"`
+	CostUnits   float64 `json:"costUnits" firestore:"cost_units"`
+	JobCount    int64   `json:"jobCount" firestore:"job_count"`
+	UpdatedAt   string  `json:"updatedAt,omitempty" firestore:"updated_at"` // ISO 8601 string
+	// Workspace rollups only. StorageBytes is the storage after the month's last commit.
+	RagQueryCount   int64  `json:"ragQueryCount,omitempty" firestore:"rag_query_count"`
+	SyncCommitCount int64  `json:"syncCommitCount,omitempty" firestore:"sync_commit_count"`
+	StorageBytes    *int64 `json:"storageBytes,omitempty" firestore:"storage_bytes,omitempty"`
+}
+
+// UsageCharge is the per-job record behind the rollups (usage_charges/{jobId}).
+type UsageCharge struct {
+	JobID       string  `firestore:"job_id"`
+	UserID      string  `firestore:"user_id"`
+	WorkspaceID string  `firestore:"workspace_id"`
+	Language    string  `firestore:"language"`
+	Month       string  `firestore:"month"`
+	ExecutionMs int64   `firestore:"execution_ms"`
+	CostUnits   float64 `firestore:"cost_units"`
+}
+
+// UsageReportExecutionRow is one user and language's executions in a usage report.
+type UsageReportExecutionRow struct {
+	UserID      string  `json:"userId"`
+	Language    string  `json:"language"`
+	JobCount    int64   `json:"jobCount"`
+	ExecutionMs int64   `json:"executionMs"`
+	CostUnits   float64 `json:"costUnits"`
+}
+
+// WorkspaceUsageReport is the JSON form of GET /workspaces/:workspaceId/reports/usage.
+type WorkspaceUsageReport struct {
+	WorkspaceID  string                    `json:"workspaceId"`
+	Month        string                    `json:"month"`
+	Executions   []UsageReportExecutionRow `json:"executions"`
+	RagQueries   int64                     `json:"ragQueries"`
+	SyncCommits  int64                     `json:"syncCommits"`
+	StorageBytes *int64                    `json:"storageBytes"` // Null when no month-end value was recorded
+	GeneratedAt  string                    `json:"generatedAt"`
+}
+
+// UsageExportRow is one job in GET /workspaces/:workspaceId/usage/export.
+type UsageExportRow struct {
+	JobID       string `json:"jobId"`
+	UserID      string `json:"userId"`
+	Language    string `json:"language"`
+	SubmittedAt string `json:"submittedAt"`
+	DurationMs  *int64 `json:"durationMs"` // Null when the job has no recorded run time
+	Status      string `json:"status"`
+}
+
+// UsageExportSummary totals the jobs of a usage export.
+type UsageExportSummary struct {
+	Runs           int64   `json:"runs"`
+	ComputeSeconds float64 `json:"computeSeconds"`
+}
+
+// --- Structs for R2 Deletion Retries ---
+
+// PendingR2Deletion is an R2 object whose deletion failed and is awaiting retry (pending_r2_deletions/{id}).
+type PendingR2Deletion struct {
+	R2ObjectKey   string    `json:"r2ObjectKey" firestore:"r2_object_key"`
+	WorkspaceID   string    `json:"workspaceId" firestore:"workspace_id"`
+	FirstFailedAt time.Time `json:"firstFailedAt" firestore:"first_failed_at"`
+	LastAttemptAt time.Time `json:"lastAttemptAt" firestore:"last_attempt_at"`
+	Attempts      int       `json:"attempts" firestore:"attempts"`
+	LastError     string    `json:"lastError,omitempty" firestore:"last_error,omitempty"`
+	Escalated     bool      `json:"escalated" firestore:"escalated"` // Reported once attempts reach the configured maximum
+}
+
+// --- Structs for Admin Tooling ---
+
+// AdminJob is a job as listed by the admin job endpoints.
+type AdminJob struct {
+	JobID string `json:"jobId"`
+	Job
+}
+
+// FailJobRequest is the request body for POST /api/admin/jobs/:id/fail.
+type FailJobRequest struct {
+	Note string `json:"note" binding:"required"`
+}
+
+// ReloadStorageCredentialsRequest is the request body for POST /internal/admin/reload-storage-credentials.
+type ReloadStorageCredentialsRequest struct {
+	Credentials string `json:"credentials" binding:"required,oneof=primary secondary"`
+}
+
+// RequeueJobsRequest selects queued jobs whose Cloud Task was lost.
+type RequeueJobsRequest struct {
+	OlderThan string `json:"olderThan" binding:"required"` // Go duration, e.g. "15m"; guards against requeuing jobs still in flight
+	Language  string `json:"language,omitempty"`
+	Limit     int    `json:"limit,omitempty"` // Default 50, max 200
+}
+
+// BackfillJobExpiryRequest is the request body for POST /api/admin/jobs/backfill-expiry.
+type BackfillJobExpiryRequest struct {
+	Cursor string `json:"cursor,omitempty"` // nextCursor of the previous page
+	Limit  int    `json:"limit,omitempty"`  // Default 200, max 500
+}
+
+// BackfillStorageRequest is the request body for POST /api/admin/workspaces/backfill-storage.
+type BackfillStorageRequest struct {
+	Cursor string `json:"cursor,omitempty"` // nextCursor of the previous page
+	Limit  int    `json:"limit,omitempty"`  // Default 50, max 200
+}
+
+// BackfillStorageResult summarizes one page of a storage backfill. Corrected counts
+// workspaces whose storage_bytes did not match their files.
+type BackfillStorageResult struct {
+	Scanned    int    `json:"scanned"`
+	Corrected  int    `json:"corrected"`
+	Failed     int    `json:"failed"`
+	NextCursor string `json:"nextCursor,omitempty"` // Empty once every workspace was scanned
+}
+
+// BackfillJobExpiryResult summarizes one page of an expiry backfill. Failed counts jobs that
+// could not be parsed or changed while the page was processed.
+type BackfillJobExpiryResult struct {
+	Scanned    int    `json:"scanned"`
+	Stamped    int    `json:"stamped"`
+	Failed     int    `json:"failed"`
+	NextCursor string `json:"nextCursor,omitempty"` // Empty once the whole collection was scanned
+}
+
+// RequeueJobResult reports what happened to one job in a requeue request.
+type RequeueJobResult struct {
+	JobID   string `json:"jobId"`
+	Status  string `json:"status"` // "requeued", "skipped", "failed"
+	Message string `json:"message,omitempty"`
+}
+
+// AuditEntry records an administrative action (audit_log/{id}).
+type AuditEntry struct {
+	EntryID    string                 `json:"entryId" firestore:"entry_id"`
+	ActorID    string                 `json:"actorId" firestore:"actor_id"`
+	Action     string                 `json:"action" firestore:"action"`
+	TargetType string                 `json:"targetType" firestore:"target_type"`
+	TargetID   string                 `json:"targetId,omitempty" firestore:"target_id,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty" firestore:"details,omitempty"`
+	CreatedAt  string                 `json:"createdAt" firestore:"created_at"` // ISO 8601 string
+}
+
+// SecurityEvent records a rejected request with security impact (security_events/{id}).
+type SecurityEvent struct {
+	EventID     string                 `json:"eventId" firestore:"event_id"`
+	Type        string                 `json:"type" firestore:"type"`
+	UserID      string                 `json:"userId,omitempty" firestore:"user_id,omitempty"`
+	WorkspaceID string                 `json:"workspaceId,omitempty" firestore:"workspace_id,omitempty"`
+	Details     map[string]interface{} `json:"details,omitempty" firestore:"details,omitempty"`
+	CreatedAt   string                 `json:"createdAt" firestore:"created_at"` // ISO 8601 string
+}
+
+// --- Structs for Invitations ---
+
+// Invitation is a pending offer to join a workspace (workspace_invitations/{invitationId}).
+type Invitation struct {
+	InvitationID      string `json:"invitationId" firestore:"invitation_id"`
+	WorkspaceID       string `json:"workspaceId" firestore:"workspace_id"`
+	WorkspaceName     string `json:"workspaceName" firestore:"workspace_name"`
+	Email             string `json:"email" firestore:"email"` // Lowercased
+	Role              string `json:"role" firestore:"role"`
+	InvitedBy         string `json:"invitedBy" firestore:"invited_by"`
+	InviterName       string `json:"inviterName,omitempty" firestore:"inviter_name,omitempty"`
+	Token             string `json:"-" firestore:"token"`                        // Embedded in the accept link; never returned by the API
+	Status            string `json:"status" firestore:"status"`                  // "pending", "accepted" or "revoked"
+	DeliveryStatus    string `json:"deliveryStatus" firestore:"delivery_status"` // "queued", "sent", "failed", "disabled"
+	DeliveryAttempts  int    `json:"deliveryAttempts" firestore:"delivery_attempts"`
+	DeliveryError     string `json:"deliveryError,omitempty" firestore:"delivery_error,omitempty"`
+	DeliveryUpdatedAt string `json:"deliveryUpdatedAt,omitempty" firestore:"delivery_updated_at,omitempty"` // ISO 8601 string
+	CreatedAt         string `json:"createdAt" firestore:"created_at"`                                      // ISO 8601 string
+	ExpiresAt         string `json:"expiresAt,omitempty" firestore:"expires_at,omitempty"`                  // ISO 8601 string; refreshed on resend
+	LastSentAt        string `json:"lastSentAt,omitempty" firestore:"last_sent_at,omitempty"`               // ISO 8601 string
+	ResendCount       int    `json:"resendCount,omitempty" firestore:"resend_count,omitempty"`
+	AcceptedAt        string `json:"acceptedAt,omitempty" firestore:"accepted_at,omitempty"` // ISO 8601 string; set once
+	AcceptedBy        string `json:"acceptedBy,omitempty" firestore:"accepted_by,omitempty"`
+	RevokedAt         string `json:"revokedAt,omitempty" firestore:"revoked_at,omitempty"` // ISO 8601 string
+	RevokedBy         string `json:"revokedBy,omitempty" firestore:"revoked_by,omitempty"`
+
+	MembershipExpiresAt string `json:"membershipExpiresAt,omitempty" firestore:"membership_expires_at,omitempty"` // ISO 8601; copied to the membership on accept
+
+	// Set on read for pending invitations past their expiry; see invitationExpired.
+	Expired bool `json:"expired,omitempty" firestore:"-"`
+}
+
+// AcceptInvitationRequest is the request body for POST /invitations/accept and /invites/accept.
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	UserName string `json:"userName"` // Display name for the new membership
+}
+
+// AcceptInvitationByIDRequest is the optional request body for POST /invites/:inviteId/accept.
+type AcceptInvitationByIDRequest struct {
+	UserName string `json:"userName"` // Display name for the new membership
+}
+
+// InviteRequest is the request body for POST /workspaces/:workspaceId/invitations.
+type InviteRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=editor viewer"`
+
+	// MembershipExpiresAt (RFC 3339) time-boxes the access the invitation grants.
+	MembershipExpiresAt string `json:"membershipExpiresAt,omitempty"`
+}
+
+// BulkInviteRequest is the request body for POST /workspaces/:workspaceId/invitations/bulk.
+type BulkInviteRequest struct {
+	Invitations []InviteRequest `json:"invitations" binding:"required,min=1,max=50,dive"`
+}
+
+// BulkInviteResult reports the outcome for one entry of a bulk invite.
+type BulkInviteResult struct {
+	Email      string      `json:"email"`
+	Invitation *Invitation `json:"invitation,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// InvitationDeliveryCallback is sent by the email sender once delivery succeeds or gives up.
+type InvitationDeliveryCallback struct {
+	Status string `json:"status" binding:"required,oneof=sent failed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// --- Structs for Workspace Import ---
+
+// ImportURLRequest is the request body for POST /workspaces/:workspaceId/import/url.
+type ImportURLRequest struct {
+	URL        string `json:"url" binding:"required,url"`
+	TargetPath string `json:"targetPath,omitempty"` // Folder to import into; the workspace root by default
+}
+
+// ImportSkip records an archive entry that was not imported and why.
+type ImportSkip struct {
+	Path   string `json:"path" firestore:"path"`
+	Reason string `json:"reason" firestore:"reason"`
+}
+
+// ImportJob tracks an asynchronous workspace import (import_jobs/{importJobId}).
+type ImportJob struct {
+	ImportJobID      string       `json:"importJobId" firestore:"import_job_id"`
+	WorkspaceID      string       `json:"workspaceId" firestore:"workspace_id"`
+	UserID           string       `json:"userId" firestore:"user_id"`
+	SourceURL        string       `json:"sourceUrl" firestore:"source_url"`
+	TargetPath       string       `json:"targetPath,omitempty" firestore:"target_path,omitempty"`
+	Status           string       `json:"status" firestore:"status"` // "queued", "downloading", "importing", "completed", "failed"
+	Error            string       `json:"error,omitempty" firestore:"error,omitempty"`
+	FilesImported    int          `json:"filesImported" firestore:"files_imported"`
+	BytesImported    int64        `json:"bytesImported" firestore:"bytes_imported"`
+	Skipped          []ImportSkip `json:"skipped,omitempty" firestore:"skipped,omitempty"` // Capped; see SkippedCount
+	SkippedCount     int          `json:"skippedCount" firestore:"skipped_count"`
+	WorkspaceVersion string       `json:"workspaceVersion,omitempty" firestore:"workspace_version,omitempty"`
+	CreatedAt        string       `json:"createdAt" firestore:"created_at"`                         // ISO 8601 string
+	UpdatedAt        string       `json:"updatedAt" firestore:"updated_at"`                         // ISO 8601 string
+	CompletedAt      string       `json:"completedAt,omitempty" firestore:"completed_at,omitempty"` // ISO 8601 string
+	DeleteAfter      time.Time    `json:"-" firestore:"delete_after,omitempty"`
+}
+
+// WorkspaceTemplate is a starter set of files new workspaces can be created from
+// (workspace_templates/{templateId}). Template file contents live in R2 under templates/.
+type WorkspaceTemplate struct {
+	TemplateID  string                  `json:"templateId" firestore:"template_id"`
+	Name        string                  `json:"name" firestore:"name"`
+	Description string                  `json:"description,omitempty" firestore:"description,omitempty"`
+	Language    string                  `json:"language,omitempty" firestore:"language,omitempty"`
+	Files       []WorkspaceTemplateFile `json:"files" firestore:"files"`
+}
+
+// WorkspaceTemplateFile is one entry of a template's manifest. Folders have no object.
+type WorkspaceTemplateFile struct {
+	FilePath    string `json:"filePath" firestore:"file_path"`
+	Type        string `json:"type" firestore:"type"` // "file" or "folder"
+	R2ObjectKey string `json:"-" firestore:"r2_object_key,omitempty"`
+	Size        int64  `json:"size,omitempty" firestore:"size,omitempty"`
+	Hash        string `json:"hash,omitempty" firestore:"hash,omitempty"`
+}
+
+// CloneWorkspaceRequest is the optional body of POST /workspaces/:workspaceId/clone.
+type CloneWorkspaceRequest struct {
+	Name string `json:"name" binding:"omitempty,max=200"` // Defaults to "Copy of <source name>"
+}
+
+// CloneJob tracks the asynchronous copy of a workspace's files into its clone
+// (clone_jobs/{cloneJobId}). A failed clone resumes after Cursor.
+type CloneJob struct {
+	CloneJobID        string    `json:"cloneJobId" firestore:"clone_job_id"`
+	SourceWorkspaceID string    `json:"sourceWorkspaceId" firestore:"source_workspace_id"`
+	WorkspaceID       string    `json:"workspaceId" firestore:"workspace_id"` // The clone
+	UserID            string    `json:"userId" firestore:"user_id"`
+	Status            string    `json:"status" firestore:"status"` // See cloneStatus* constants
+	Error             string    `json:"error,omitempty" firestore:"error,omitempty"`
+	FilesCopied       int       `json:"filesCopied" firestore:"files_copied"`
+	BytesCopied       int64     `json:"bytesCopied" firestore:"bytes_copied"`
+	Cursor            string    `json:"-" firestore:"cursor,omitempty"`                           // Last source file document copied
+	CreatedAt         string    `json:"createdAt" firestore:"created_at"`                         // ISO 8601 string
+	UpdatedAt         string    `json:"updatedAt" firestore:"updated_at"`                         // ISO 8601 string
+	CompletedAt       string    `json:"completedAt,omitempty" firestore:"completed_at,omitempty"` // ISO 8601 string
+	DeleteAfter       time.Time `json:"-" firestore:"delete_after,omitempty"`
+}
+
+// WorkspaceExport is a zip archive of a workspace built in the background
+// (workspace_exports/{exportId}). The archive is stored in R2 under
+// exports/{workspaceId}/{exportId}.zip until ExpiresAt.
+type WorkspaceExport struct {
+	ExportID         string    `json:"exportId" firestore:"export_id"`
+	WorkspaceID      string    `json:"workspaceId" firestore:"workspace_id"`
+	RequestedBy      string    `json:"requestedBy" firestore:"requested_by"`
+	Paths            []string  `json:"paths,omitempty" firestore:"paths,omitempty"`
+	Exclude          []string  `json:"exclude,omitempty" firestore:"exclude,omitempty"`
+	Status           string    `json:"status" firestore:"status"` // See exportStatus* constants
+	Error            string    `json:"error,omitempty" firestore:"error,omitempty"`
+	WorkspaceVersion string    `json:"workspaceVersion,omitempty" firestore:"workspace_version,omitempty"`
+	FileCount        int       `json:"fileCount" firestore:"file_count"`
+	FolderCount      int       `json:"folderCount" firestore:"folder_count"`
+	SizeBytes        int64     `json:"sizeBytes" firestore:"size_bytes"` // Size of the archive
+	R2ObjectKey      string    `json:"-" firestore:"r2_object_key"`
+	DownloadURL      string    `json:"downloadUrl,omitempty" firestore:"-"`                      // Presigned once completed
+	CreatedAt        string    `json:"createdAt" firestore:"created_at"`                         // ISO 8601 string
+	UpdatedAt        string    `json:"updatedAt" firestore:"updated_at"`                         // ISO 8601 string
+	CompletedAt      string    `json:"completedAt,omitempty" firestore:"completed_at,omitempty"` // ISO 8601 string
+	ExpiresAt        string    `json:"expiresAt" firestore:"expires_at"`                         // ISO 8601; no download afterwards
+	DeleteAfter      time.Time `json:"-" firestore:"delete_after"`
+}
+
+// --- Structs for Workspace Activity ---
+
+// ActivityEvent is an entry in a workspace's activity log (workspaces/{id}/activity).
+type ActivityEvent struct {
+	EventID     string                 `json:"eventId" firestore:"event_id"`
+	WorkspaceID string                 `json:"workspaceId" firestore:"workspace_id"`
+	ActorID     string                 `json:"actorId" firestore:"actor_id"`
+	Type        string                 `json:"type" firestore:"type"`
+	Details     map[string]interface{} `json:"details,omitempty" firestore:"details,omitempty"`
+	CreatedAt   string                 `json:"createdAt" firestore:"created_at"` // ISO 8601 string
+}
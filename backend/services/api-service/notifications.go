@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Notification categories a member can subscribe to (WorkspaceMembership.NotifyOn).
+const (
+	notifySync        = "sync"
+	notifyJobFailed   = "job_failed"
+	notifyMemberAdded = "member_added"
+)
+
+// Digest schedules (WorkspaceMembership.Digest).
+const (
+	digestOff   = "off"
+	digestDaily = "daily"
+)
+
+const (
+	digestInterval = 24 * time.Hour
+	// digestBatch bounds how many members one notification-digests run handles.
+	digestBatch = 100
+	// digestScanLimit bounds the activity events read for one digest; maxDigestEvents bounds
+	// how many of the selected events are sent.
+	digestScanLimit = 500
+	maxDigestEvents = 50
+)
+
+// notificationCategories maps each category to the activity event types it covers.
+var notificationCategories = map[string][]string{
+	notifySync:        {activitySyncCommitted, activityImportCommitted, activityFilesDeleted, activityFolderCreated, activityFolderDeleted, activityFolderRenamed, activityDestructiveSync},
+	notifyJobFailed:   {activityJobFailed},
+	notifyMemberAdded: {activityMemberAdded},
+}
+
+// activityCategory returns the notification category of an activity event type, or "" when
+// members cannot be notified about it.
+func activityCategory(eventType string) string {
+	for category, types := range notificationCategories {
+		for _, t := range types {
+			if t == eventType {
+				return category
+			}
+		}
+	}
+	return ""
+}
+
+// normalizeNotifyOn validates categories and returns them sorted without duplicates.
+func normalizeNotifyOn(categories []string) ([]string, error) {
+	seen := make(map[string]bool, len(categories))
+	normalized := make([]string, 0, len(categories))
+	for _, category := range categories {
+		if _, ok := notificationCategories[category]; !ok {
+			return nil, fmt.Errorf("unknown notification category %q", category)
+		}
+		if !seen[category] {
+			seen[category] = true
+			normalized = append(normalized, category)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// notificationPreferences returns a member's effective preferences. Members who never set
+// notify_on get every category; digests are off unless turned on.
+func notificationPreferences(m WorkspaceMembership) NotificationPreferences {
+	prefs := NotificationPreferences{WorkspaceID: m.WorkspaceID, Digest: m.Digest}
+	if m.NotifyOn != nil {
+		prefs.NotifyOn = *m.NotifyOn
+	} else {
+		for category := range notificationCategories {
+			prefs.NotifyOn = append(prefs.NotifyOn, category)
+		}
+		sort.Strings(prefs.NotifyOn)
+	}
+	if prefs.NotifyOn == nil {
+		prefs.NotifyOn = []string{}
+	}
+	if prefs.Digest == "" {
+		prefs.Digest = digestOff
+	}
+	return prefs
+}
+
+// digestEvents selects the events userID should hear about: those in a subscribed category
+// that someone else triggered. Order is preserved.
+func digestEvents(events []ActivityEvent, userID string, notifyOn []string) []DigestEvent {
+	subscribed := make(map[string]bool, len(notifyOn))
+	for _, category := range notifyOn {
+		subscribed[category] = true
+	}
+	var selected []DigestEvent
+	for _, event := range events {
+		category := activityCategory(event.Type)
+		if category == "" || !subscribed[category] || event.ActorID == userID {
+			continue
+		}
+		selected = append(selected, DigestEvent{
+			Category:  category,
+			Type:      event.Type,
+			ActorID:   event.ActorID,
+			Details:   event.Details,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+	return selected
+}
+
+// membershipRef returns the document of a membership.
+func (ac *ApiController) membershipRef(membershipID string) *docstore.DocumentRef {
+	return ac.FirestoreClient.Collection("workspace_memberships").Doc(membershipID)
+}
+
+// GetNotificationPreferences returns the caller's notification preferences for a workspace.
+func (ac *ApiController) GetNotificationPreferences(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	logCtx := log.WithFields(log.Fields{"handler": "GetNotificationPreferences", "workspace_id": workspaceID, "user_id": userID})
+
+	membership := ac.requireWorkspaceRole(c, workspaceID, userID, roleViewer, logCtx)
+	if membership == nil {
+		return
+	}
+	c.JSON(http.StatusOK, notificationPreferences(*membership))
+}
+
+// UpdateNotificationPreferences changes the caller's notification preferences for a
+// workspace. Omitted fields keep their value. Turning the digest on starts its window now, so
+// the first digest does not replay older activity. Changes apply from the next digest run.
+func (ac *ApiController) UpdateNotificationPreferences(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "UpdateNotificationPreferences", "workspace_id": workspaceID, "user_id": userID})
+
+	var req NotificationPreferencesRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	if req.NotifyOn == nil && req.Digest == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "notifyOn or digest is required"})
+		return
+	}
+
+	membership := ac.requireWorkspaceRole(c, workspaceID, userID, roleViewer, logCtx)
+	if membership == nil {
+		return
+	}
+
+	var updates []docstore.Update
+	if req.NotifyOn != nil {
+		notifyOn, err := normalizeNotifyOn(*req.NotifyOn)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		membership.NotifyOn = &notifyOn
+		updates = append(updates, docstore.Update{Path: "notify_on", Value: notifyOn})
+	}
+	if req.Digest != nil {
+		if *req.Digest == digestDaily && membership.Digest != digestDaily {
+			membership.LastDigestAt = NowISO8601()
+			updates = append(updates, docstore.Update{Path: "last_digest_at", Value: membership.LastDigestAt})
+		}
+		membership.Digest = *req.Digest
+		updates = append(updates, docstore.Update{Path: "digest", Value: membership.Digest})
+	}
+
+	if _, err := ac.membershipRef(membership.MembershipID).Update(ctx, updates); err != nil {
+		logCtx.WithError(err).Error("Failed to update notification preferences.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+	c.JSON(http.StatusOK, notificationPreferences(*membership))
+}
+
+// runNotificationDigestMaintenance is the "notification-digests" maintenance task: it sends
+// each member with a daily digest the activity since their last one, once a day. Firestore
+// needs a composite index on workspace_memberships digest/last_digest_at.
+func (ac *ApiController) runNotificationDigestMaintenance(ctx context.Context) (interface{}, error) {
+	if ac.Notifier == nil {
+		return gin.H{"sent": 0, "disabled": true}, nil
+	}
+
+	now := time.Now()
+	docs, err := ac.FirestoreClient.Collection("workspace_memberships").
+		Where("digest", "==", digestDaily).
+		Where("last_digest_at", "<=", TimeToISO8601(now.Add(-digestInterval))).
+		OrderBy("last_digest_at", docstore.Asc).
+		Limit(digestBatch).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load digest subscribers: %w", err)
+	}
+
+	sent, empty, failed := 0, 0, 0
+	for _, doc := range docs {
+		ok, err := ac.sendDigest(ctx, doc, now)
+		switch {
+		case err != nil:
+			log.WithError(err).WithField("membership_id", doc.Ref.ID).Warn("Failed to send notification digest.")
+			failed++
+		case ok:
+			sent++
+		default:
+			empty++
+		}
+	}
+	return gin.H{"sent": sent, "empty": empty, "failed": failed}, nil
+}
+
+// sendDigest hands one member's digest for the window ending at now to the notifier. The
+// window is claimed before sending, so concurrent runs never send it twice, and released
+// again when sending fails. It returns false when there was nothing to send.
+func (ac *ApiController) sendDigest(ctx context.Context, doc *docstore.DocumentSnapshot, now time.Time) (bool, error) {
+	var membership WorkspaceMembership
+	if err := doc.DataTo(&membership); err != nil {
+		return false, fmt.Errorf("failed to parse membership: %w", err)
+	}
+	periodStart, periodEnd := membership.LastDigestAt, TimeToISO8601(now)
+
+	_, err := doc.Ref.Update(ctx, []docstore.Update{{Path: "last_digest_at", Value: periodEnd}}, docstore.LastUpdateTime(doc.UpdateTime))
+	if status.Code(err) == codes.FailedPrecondition {
+		return false, nil // Claimed by a concurrent run, or preferences changed; the next run rechecks
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim digest window: %w", err)
+	}
+	if membershipExpired(membership, now) {
+		return false, nil // Lapsed reviewers stop hearing about the workspace
+	}
+
+	ok, err := ac.deliverDigest(ctx, membership, periodStart, periodEnd)
+	if err != nil {
+		if _, restoreErr := doc.Ref.Update(ctx, []docstore.Update{{Path: "last_digest_at", Value: periodStart}}); restoreErr != nil {
+			log.WithError(restoreErr).WithField("membership_id", doc.Ref.ID).Error("Failed to release digest window; its activity will be skipped.")
+		}
+		return false, err
+	}
+	return ok, nil
+}
+
+// deliverDigest assembles the digest for (periodStart, periodEnd] and sends it if it has events.
+func (ac *ApiController) deliverDigest(ctx context.Context, membership WorkspaceMembership, periodStart, periodEnd string) (bool, error) {
+	if membership.UserEmail == "" {
+		return false, nil
+	}
+
+	docs, err := ac.activityCollection(membership.WorkspaceID).
+		Where("created_at", ">", periodStart).
+		Where("created_at", "<=", periodEnd).
+		OrderBy("created_at", docstore.Asc).
+		Limit(digestScanLimit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return false, fmt.Errorf("failed to load workspace activity: %w", err)
+	}
+	events := make([]ActivityEvent, 0, len(docs))
+	for _, doc := range docs {
+		var event ActivityEvent
+		if err := doc.DataTo(&event); err != nil {
+			log.WithError(err).WithField("event_id", doc.Ref.ID).Warn("Failed to parse activity event.")
+			continue
+		}
+		events = append(events, event)
+	}
+
+	selected := digestEvents(events, membership.UserID, notificationPreferences(membership).NotifyOn)
+	if len(selected) == 0 {
+		return false, nil
+	}
+	total := len(selected)
+	if total > maxDigestEvents {
+		selected = selected[:maxDigestEvents]
+	}
+
+	workspace, err := ac.loadWorkspace(ctx, membership.WorkspaceID)
+	if err != nil {
+		return false, err
+	}
+	if workspace.DeletedAt != "" {
+		return false, nil
+	}
+
+	err = ac.Notifier.NotifyDigest(ctx, DigestNotification{
+		Recipient:     membership.UserEmail,
+		UserID:        membership.UserID,
+		TemplateID:    ac.AppConfig.DigestTemplateID,
+		WorkspaceID:   membership.WorkspaceID,
+		WorkspaceName: workspace.Name,
+		PeriodStart:   periodStart,
+		PeriodEnd:     periodEnd,
+		Events:        selected,
+		TotalEvents:   total,
+		Truncated:     total > len(selected) || len(docs) == digestScanLimit,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivityCategory(t *testing.T) {
+	assert.Equal(t, notifySync, activityCategory(activitySyncCommitted))
+	assert.Equal(t, notifySync, activityCategory(activityImportCommitted))
+	assert.Equal(t, notifySync, activityCategory(activityFilesDeleted))
+	assert.Equal(t, notifyJobFailed, activityCategory(activityJobFailed))
+	assert.Equal(t, notifyMemberAdded, activityCategory(activityMemberAdded))
+	assert.Equal(t, "", activityCategory(activityJobPinned))
+}
+
+func TestNormalizeNotifyOn(t *testing.T) {
+	got, err := normalizeNotifyOn([]string{notifySync, notifyJobFailed, notifySync})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{notifyJobFailed, notifySync}, got)
+
+	got, err = normalizeNotifyOn(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, got)
+
+	_, err = normalizeNotifyOn([]string{notifySync, "job_pinned"})
+	assert.Error(t, err)
+}
+
+func TestNotificationPreferencesDefaults(t *testing.T) {
+	prefs := notificationPreferences(WorkspaceMembership{WorkspaceID: "ws-1"})
+	assert.Equal(t, []string{notifyJobFailed, notifyMemberAdded, notifySync}, prefs.NotifyOn)
+	assert.Equal(t, digestOff, prefs.Digest)
+
+	none := []string{}
+	prefs = notificationPreferences(WorkspaceMembership{WorkspaceID: "ws-1", NotifyOn: &none, Digest: digestDaily})
+	assert.Equal(t, []string{}, prefs.NotifyOn)
+	assert.Equal(t, digestDaily, prefs.Digest)
+}
+
+func TestDigestEvents(t *testing.T) {
+	events := []ActivityEvent{
+		{ActorID: "alice", Type: activitySyncCommitted, CreatedAt: "2026-01-01T00:00:00.000Z"},
+		{ActorID: "bob", Type: activitySyncCommitted, CreatedAt: "2026-01-01T01:00:00.000Z"},
+		{ActorID: "bob", Type: activityJobPinned, CreatedAt: "2026-01-01T02:00:00.000Z"},
+		{ActorID: "bob", Type: activityJobFailed, CreatedAt: "2026-01-01T03:00:00.000Z"},
+		{ActorID: "carol", Type: activityImportCommitted, CreatedAt: "2026-01-01T04:00:00.000Z"},
+	}
+
+	got := digestEvents(events, "alice", []string{notifySync})
+	assert.Len(t, got, 2)
+	assert.Equal(t, "bob", got[0].ActorID)
+	assert.Equal(t, notifySync, got[0].Category)
+	assert.Equal(t, activityImportCommitted, got[1].Type)
+
+	got = digestEvents(events, "bob", []string{notifySync, notifyJobFailed})
+	assert.Len(t, got, 2) // Bob's own sync and failed job are excluded
+	assert.Equal(t, "alice", got[0].ActorID)
+	assert.Equal(t, "carol", got[1].ActorID)
+
+	assert.Empty(t, digestEvents(events, "alice", nil))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Notifier delivers notifications to users outside the app (e.g. by email).
+// ApiController.Notifier is nil when no delivery channel is configured.
+type Notifier interface {
+	NotifyInvitation(ctx context.Context, n InvitationNotification) error
+	NotifyDigest(ctx context.Context, n DigestNotification) error
+}
+
+// InvitationNotification is everything a sender needs to render and send an invitation.
+// The sender reports the outcome by POSTing to CallbackURL.
+type InvitationNotification struct {
+	InvitationID  string `json:"invitation_id"`
+	Recipient     string `json:"recipient"`
+	TemplateID    string `json:"template_id"`
+	WorkspaceName string `json:"workspace_name"`
+	InviterName   string `json:"inviter_name"`
+	AcceptLink    string `json:"accept_link"`
+	CallbackURL   string `json:"callback_url"`
+}
+
+// DigestNotification is one member's periodic summary of activity in a workspace.
+type DigestNotification struct {
+	Recipient     string        `json:"recipient"`
+	UserID        string        `json:"user_id"`
+	TemplateID    string        `json:"template_id"`
+	WorkspaceID   string        `json:"workspace_id"`
+	WorkspaceName string        `json:"workspace_name"`
+	PeriodStart   string        `json:"period_start"` // ISO 8601, exclusive
+	PeriodEnd     string        `json:"period_end"`   // ISO 8601, inclusive
+	Events        []DigestEvent `json:"events"`       // Oldest first
+	TotalEvents   int           `json:"total_events"`
+	Truncated     bool          `json:"truncated"` // Events or TotalEvents do not cover the whole period
+}
+
+// DigestEvent is one activity event in a digest.
+type DigestEvent struct {
+	Category  string                 `json:"category"` // Notification category, e.g. "sync"
+	Type      string                 `json:"type"`     // Activity event type
+	ActorID   string                 `json:"actor_id"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt string                 `json:"created_at"`
+}
+
+// cloudTasksNotifier hands notifications to the email-sender service through Cloud Tasks,
+// so delivery is retried by the queue and never blocks the request.
+type cloudTasksNotifier struct {
+	ac     *ApiController
+	sender ServiceConfig
+}
+
+// newCloudTasksNotifier returns a Notifier for the email-sender service.
+func newCloudTasksNotifier(ac *ApiController, sender ServiceConfig) *cloudTasksNotifier {
+	return &cloudTasksNotifier{ac: ac, sender: sender}
+}
+
+func (n *cloudTasksNotifier) NotifyInvitation(ctx context.Context, notification InvitationNotification) error {
+	queuePath := n.ac.AppConfig.GetQueuePath(n.sender.QueueID)
+	if _, err := n.ac.enqueueTask(ctx, queuePath, fmt.Sprintf("%s/send", n.sender.ServiceURL), n.sender.ServiceAccount, notification); err != nil {
+		return fmt.Errorf("failed to enqueue invitation email: %w", err)
+	}
+	return nil
+}
+
+func (n *cloudTasksNotifier) NotifyDigest(ctx context.Context, notification DigestNotification) error {
+	queuePath := n.ac.AppConfig.GetQueuePath(n.sender.QueueID)
+	if _, err := n.ac.enqueueTask(ctx, queuePath, fmt.Sprintf("%s/digest", n.sender.ServiceURL), n.sender.ServiceAccount, notification); err != nil {
+		return fmt.Errorf("failed to enqueue digest email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// loadInvitation reads an invitation document.
+func loadInvitation(h *handlerHarness, invitationID string) Invitation {
+	h.t.Helper()
+	snap, err := h.fs.Collection(invitationsCollection).Doc(invitationID).Get(context.Background())
+	if err != nil {
+		h.t.Fatalf("load invitation: %v", err)
+	}
+	var inv Invitation
+	assert.NoError(h.t, snap.DataTo(&inv))
+	return inv
+}
+
+func TestHandlers_InvitationNotifications(t *testing.T) {
+	h := newHandlerHarness(t)
+	notifier := &fakeNotifier{}
+	h.ac.Notifier = notifier
+	h.ac.AppConfig.AppBaseURL = "https://app.test"
+	h.ac.AppConfig.InvitationTemplateID = "workspace_invitation"
+	owner, editor := "owner-"+uuid.New().String(), "editor-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, editor, roleEditor, "")
+	invitationsPath := "/api/workspaces/" + workspaceID + "/invitations"
+
+	w := h.do(http.MethodPost, invitationsPath, editor, gin.H{"email": "ada@example.com", "role": "viewer"}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only owners invite")
+	assert.Empty(t, notifier.sentInvitations())
+
+	var inv Invitation
+	w = h.do(http.MethodPost, invitationsPath, owner, gin.H{"email": "Ada@Example.com", "role": "viewer"}, &inv)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, deliveryQueued, inv.DeliveryStatus)
+	sent := notifier.sentInvitations()
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, inv.InvitationID, sent[0].InvitationID)
+		assert.Equal(t, "ada@example.com", sent[0].Recipient)
+		assert.Equal(t, "workspace_invitation", sent[0].TemplateID)
+		assert.True(t, strings.HasPrefix(sent[0].AcceptLink, "https://app.test/invitations/accept?token="))
+		assert.True(t, strings.HasSuffix(sent[0].CallbackURL, "/internal/notifications/invitations/"+inv.InvitationID))
+	}
+
+	// A second invitation to the same address, in any case, is refused while the first is pending.
+	var conflict map[string]interface{}
+	w = h.do(http.MethodPost, invitationsPath, owner, gin.H{"email": "ADA@example.com", "role": "editor"}, &conflict)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, "invitation_exists", conflict["code"])
+	var bulk struct {
+		Created int                `json:"created"`
+		Results []BulkInviteResult `json:"results"`
+	}
+	w = h.do(http.MethodPost, invitationsPath+"/bulk", owner, gin.H{"invitations": []gin.H{
+		{"email": "ada@example.com", "role": "viewer"},
+		{"email": "bob@example.com", "role": "viewer"},
+		{"email": "bob@example.com", "role": "editor"},
+	}}, &bulk)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 1, bulk.Created)
+	if assert.Len(t, bulk.Results, 3) {
+		assert.NotEmpty(t, bulk.Results[0].Error)
+		assert.NotNil(t, bulk.Results[1].Invitation)
+		assert.NotEmpty(t, bulk.Results[2].Error)
+	}
+	assert.Len(t, notifier.sentInvitations(), 2)
+
+	// A revoked invitation no longer blocks a new one.
+	w = h.do(http.MethodDelete, invitationsPath+"/"+inv.InvitationID, owner, nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = h.do(http.MethodPost, invitationsPath, owner, gin.H{"email": "ada@example.com", "role": "viewer"}, &inv)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// The sender reports the outcome.
+	callbackPath := "/internal/notifications/invitations/" + inv.InvitationID
+	w = h.do(http.MethodPost, callbackPath, "", InvitationDeliveryCallback{Status: deliverySent}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Equal(t, deliverySent, loadInvitation(h, inv.InvitationID).DeliveryStatus)
+	w = h.do(http.MethodPost, callbackPath, "", InvitationDeliveryCallback{Status: deliveryFailed, Error: "mailbox full"}, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	stored := loadInvitation(h, inv.InvitationID)
+	assert.Equal(t, deliveryFailed, stored.DeliveryStatus)
+	assert.Equal(t, "mailbox full", stored.DeliveryError)
+	w = h.do(http.MethodPost, callbackPath, "", InvitationDeliveryCallback{Status: deliveryQueued}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "only final outcomes are reported")
+	w = h.do(http.MethodPost, "/internal/notifications/invitations/"+uuid.New().String(), "", InvitationDeliveryCallback{Status: deliverySent}, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+}
+
+func TestHandlers_InvitationDeliveryRetry(t *testing.T) {
+	h := newHandlerHarness(t)
+	notifier := &fakeNotifier{}
+	h.ac.Notifier = notifier
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+
+	notifier.failWith(errors.New("queue unavailable"))
+	var inv Invitation
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/invitations", owner, gin.H{"email": "retry-" + uuid.New().String() + "@example.com", "role": "viewer"}, &inv)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	stored := loadInvitation(h, inv.InvitationID)
+	assert.Equal(t, deliveryFailed, stored.DeliveryStatus)
+	assert.Equal(t, 1, stored.DeliveryAttempts)
+
+	notifier.failWith(nil)
+	w = h.do(http.MethodPost, "/api/admin/maintenance/invitation-deliveries", "admin", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	stored = loadInvitation(h, inv.InvitationID)
+	assert.Equal(t, deliveryQueued, stored.DeliveryStatus)
+	assert.Equal(t, 2, stored.DeliveryAttempts)
+	retried := false
+	for _, sent := range notifier.sentInvitations() {
+		retried = retried || sent.InvitationID == inv.InvitationID
+	}
+	assert.True(t, retried)
+}
+
+func TestHandlers_RunMaintenanceTaskUnknown(t *testing.T) {
+	h := newHandlerHarness(t)
+
+	var resp struct {
+		Error string   `json:"error"`
+		Tasks []string `json:"tasks"`
+	}
+	w := h.do(http.MethodPost, "/api/admin/maintenance/no-such-task", "admin", nil, &resp)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	assert.Contains(t, resp.Tasks, "invitation-deliveries")
+	assert.IsIncreasing(t, resp.Tasks)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errInvalidObjectKey is returned for R2 keys outside the canonical layout of their entry.
+var errInvalidObjectKey = errors.New("invalid object key")
+
+// validPathSegment reports whether s can be used as one segment of an R2 key.
+func validPathSegment(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, "/\\")
+}
+
+// validateObjectKey checks that key is the canonical R2 key of a workspace entry:
+// workspaces/{workspaceID}/files/{fileID}/{name} for files and no key for folders, or the
+// placeholder workspaces/{workspaceID}/folders/{fileID} older clients still send. Anything
+// else could point the workspace's manifest at another workspace's objects.
+func validateObjectKey(workspaceID, entryType, fileID, key string) error {
+	if !validPathSegment(fileID) {
+		return fmt.Errorf("%w: file ID %q is not a single path segment", errInvalidObjectKey, fileID)
+	}
+	if entryType == "folder" {
+		if key == "" || key == fmt.Sprintf("workspaces/%s/folders/%s", workspaceID, fileID) {
+			return nil
+		}
+		return fmt.Errorf("%w: %q is not this folder's key", errInvalidObjectKey, key)
+	}
+
+	prefix := fmt.Sprintf("workspaces/%s/files/%s/", workspaceID, fileID)
+	name, ok := strings.CutPrefix(key, prefix)
+	if !ok || !validPathSegment(name) {
+		return fmt.Errorf("%w: %q is not under %s", errInvalidObjectKey, key, prefix)
+	}
+	return nil
+}
+
+// validateSyncObjectKeys checks the keys of a sync commit's upserts and returns the first
+// offending action. Deletes are not checked: they remove the key stored on the server, not
+// the one the client sent.
+func validateSyncObjectKeys(workspaceID string, actions []FileAction) (*FileAction, error) {
+	for i := range actions {
+		action := &actions[i]
+		if action.Action != "upsert" {
+			continue
+		}
+		if err := validateObjectKey(workspaceID, action.Type, action.FileID, action.R2ObjectKey); err != nil {
+			return action, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateObjectKeyAcceptsCanonicalKeys(t *testing.T) {
+	assert.NoError(t, validateObjectKey("ws-1", "file", "f-1", "workspaces/ws-1/files/f-1/main.py"))
+	assert.NoError(t, validateObjectKey("ws-1", "file", "f-1", "workspaces/ws-1/files/f-1/old name.py")) // Renamed files keep their key
+	assert.NoError(t, validateObjectKey("ws-1", "folder", "d-1", "workspaces/ws-1/folders/d-1"))
+	assert.NoError(t, validateObjectKey("ws-1", "folder", "d-1", ""))
+}
+
+func TestValidateObjectKeyRejectsCrossWorkspaceKeys(t *testing.T) {
+	assert.ErrorIs(t, validateObjectKey("ws-1", "file", "f-1", "workspaces/ws-2/files/f-1/main.py"), errInvalidObjectKey)
+	assert.ErrorIs(t, validateObjectKey("ws-1", "file", "f-1", "workspaces/ws-10/files/f-1/main.py"), errInvalidObjectKey)
+	assert.ErrorIs(t, validateObjectKey("ws-1", "folder", "d-1", "workspaces/ws-2/folders/d-1"), errInvalidObjectKey)
+	// The key must belong to the declared file, not just the workspace.
+	assert.ErrorIs(t, validateObjectKey("ws-1", "file", "f-1", "workspaces/ws-1/files/f-2/main.py"), errInvalidObjectKey)
+}
+
+func TestValidateObjectKeyRejectsTraversal(t *testing.T) {
+	for _, key := range []string{
+		"workspaces/ws-1/files/f-1/../../../ws-2/files/f-9/secret.py",
+		"workspaces/ws-1/files/f-1/..",
+		"workspaces/ws-1/files/f-1/.",
+		"workspaces/ws-1/files/f-1/",
+		"workspaces/ws-1/files/f-1/sub/main.py",
+		"workspaces/ws-1/files/f-1/..\\..\\x",
+		"/workspaces/ws-1/files/f-1/main.py",
+		"",
+	} {
+		assert.ErrorIs(t, validateObjectKey("ws-1", "file", "f-1", key), errInvalidObjectKey, key)
+	}
+	for _, fileID := range []string{"", ".", "..", "f-1/../f-2", "a\\b"} {
+		assert.ErrorIs(t, validateObjectKey("ws-1", "file", fileID, "workspaces/ws-1/files/"+fileID+"/main.py"), errInvalidObjectKey, fileID)
+	}
+}
+
+func TestValidateObjectKeyRejectsFolderKeysForFiles(t *testing.T) {
+	assert.ErrorIs(t, validateObjectKey("ws-1", "file", "f-1", "workspaces/ws-1/folders/f-1"), errInvalidObjectKey)
+	assert.ErrorIs(t, validateObjectKey("ws-1", "file", "f-1", "workspaces/ws-1/folders/f-1/main.py"), errInvalidObjectKey)
+	assert.ErrorIs(t, validateObjectKey("ws-1", "folder", "d-1", "workspaces/ws-1/files/d-1/x"), errInvalidObjectKey)
+}
+
+func TestValidateSyncObjectKeys(t *testing.T) {
+	actions := []FileAction{
+		{FilePath: "main.py", Type: "file", FileID: "f-1", Action: "upsert", R2ObjectKey: "workspaces/ws-1/files/f-1/main.py"},
+		{FilePath: "gone.py", Type: "file", FileID: "f-2", Action: "delete", R2ObjectKey: "workspaces/ws-2/files/f-2/gone.py"},
+	}
+	action, err := validateSyncObjectKeys("ws-1", actions)
+	assert.NoError(t, err)
+	assert.Nil(t, action)
+
+	actions = append(actions, FileAction{FilePath: "steal.py", Type: "file", FileID: "f-3", Action: "upsert", R2ObjectKey: "workspaces/ws-2/files/f-3/secret.py"})
+	action, err = validateSyncObjectKeys("ws-1", actions)
+	assert.ErrorIs(t, err, errInvalidObjectKey)
+	assert.Equal(t, "steal.py", action.FilePath)
+}
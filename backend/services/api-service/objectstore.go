@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Names of the R2 credential pairs in AppConfig.
+const (
+	r2CredentialsPrimary   = "primary"
+	r2CredentialsSecondary = "secondary"
+)
+
+// storageProbeTimeout bounds the HeadBucket check of reloaded credentials.
+const storageProbeTimeout = 10 * time.Second
+
+// R2Credentials is one R2 access key pair.
+type R2Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// r2Credentials returns the named key pair, or false when it is not configured.
+func (cfg *AppConfig) r2Credentials(name string) (R2Credentials, bool) {
+	switch name {
+	case r2CredentialsPrimary:
+		return R2Credentials{cfg.R2AccessKeyID, cfg.R2SecretAccessKey}, true
+	case r2CredentialsSecondary:
+		if cfg.R2SecondaryAccessKeyID == "" {
+			return R2Credentials{}, false
+		}
+		return R2Credentials{cfg.R2SecondaryAccessKeyID, cfg.R2SecondarySecretAccessKey}, true
+	}
+	return R2Credentials{}, false
+}
+
+// ObjectAPI is the part of the S3 API the service calls on R2. *s3.Client implements it;
+// handler tests substitute an in-memory fake.
+type ObjectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// Presigner signs object URLs. *s3.PresignClient implements it.
+type Presigner interface {
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+	PresignPutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// ObjectStore is the R2 client and presigner built from one key pair. It is never modified:
+// a credential reload builds a new ObjectStore and swaps it in, so requests holding the old
+// one finish with it while new requests, and new presigned URLs, use the new key.
+type ObjectStore struct {
+	S3          ObjectAPI
+	Presign     Presigner
+	Credentials string // Which AppConfig pair signed this store: "primary" or "secondary"
+	AccessKeyID string
+}
+
+// newObjectStore builds R2 clients for the account's S3-compatible endpoint.
+func newObjectStore(accountID, name string, creds R2Credentials) (*ObjectStore, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.TODO(),
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, "")),
+		awsconfig.WithRegion("auto"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load R2 S3 configuration: %w", err)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.EndpointResolver = s3.EndpointResolverFunc(
+			func(region string, options s3.EndpointResolverOptions) (aws.Endpoint, error) {
+				return aws.Endpoint{
+					URL:               fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID),
+					HostnameImmutable: true,
+					SigningRegion:     "auto",
+					SigningName:       "s3",
+				}, nil
+			})
+		o.UsePathStyle = true
+		o.APIOptions = append(o.APIOptions, addR2CostMiddleware)
+	})
+	return &ObjectStore{
+		S3:          client,
+		Presign:     s3.NewPresignClient(client),
+		Credentials: name,
+		AccessKeyID: creds.AccessKeyID,
+	}, nil
+}
+
+// probe checks that the store's key can reach bucket.
+func (s *ObjectStore) probe(ctx context.Context, bucket string) error {
+	_, err := s.S3.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	return err
+}
+
+// putStream uploads body to key as a multipart upload of partSize parts, so an object of
+// unknown length is stored without being buffered whole. R2 needs every part but the last to
+// be at least 5 MiB. The upload is aborted if body or any part fails. It returns the size.
+func (s *ObjectStore) putStream(ctx context.Context, bucket, key, contentType string, body io.Reader, partSize int) (int64, error) {
+	created, err := s.S3.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to start upload: %w", err)
+	}
+	abort := func(cause error) (int64, error) {
+		// The caller's context may be what failed; the abort still needs to reach R2.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), storageProbeTimeout)
+		defer cancel()
+		if _, err := s.S3.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+		}); err != nil {
+			log.WithError(err).WithField("r2_object_key", key).Warn("Failed to abort multipart upload.")
+		}
+		return 0, cause
+	}
+
+	var parts []types.CompletedPart
+	var size int64
+	buf := make([]byte, partSize)
+	for {
+		n, readErr := io.ReadFull(body, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return abort(readErr)
+		}
+		// An empty body is still uploaded as one empty part.
+		if n > 0 || len(parts) == 0 {
+			partNumber := int32(len(parts) + 1)
+			out, err := s.S3.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:        aws.String(bucket),
+				Key:           aws.String(key),
+				UploadId:      created.UploadId,
+				PartNumber:    aws.Int32(partNumber),
+				Body:          bytes.NewReader(buf[:n]),
+				ContentLength: aws.Int64(int64(n)),
+			})
+			if err != nil {
+				return abort(fmt.Errorf("failed to upload part %d: %w", partNumber, err))
+			}
+			parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(partNumber)})
+			size += int64(n)
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	if _, err := s.S3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		return abort(fmt.Errorf("failed to complete upload: %w", err))
+	}
+	return size, nil
+}
+
+// r2 returns the object store currently in use. Load it once per operation so one
+// operation never mixes clients across a reload.
+func (ac *ApiController) r2() *ObjectStore {
+	return ac.objectStore.Load()
+}
+
+// maskAccessKeyID keeps only the last four characters of an access key ID.
+func maskAccessKeyID(id string) string {
+	if len(id) <= 4 {
+		return strings.Repeat("*", len(id))
+	}
+	return strings.Repeat("*", len(id)-4) + id[len(id)-4:]
+}
+
+// ReloadStorageCredentials rebuilds the R2 clients from the requested configured key pair
+// and swaps them in once a HeadBucket probe succeeds. A failed probe leaves the current
+// clients in place. Reloads are serialized so the audit log records them in order.
+func (ac *ApiController) ReloadStorageCredentials(c *gin.Context) {
+	adminID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "ReloadStorageCredentials", "admin_id": adminID})
+
+	var req ReloadStorageCredentialsRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	creds, ok := ac.AppConfig.r2Credentials(req.Credentials)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("No %s R2 credentials are configured", req.Credentials)})
+		return
+	}
+
+	ac.storageReloadMu.Lock()
+	defer ac.storageReloadMu.Unlock()
+
+	store, err := newObjectStore(ac.AppConfig.R2AccountID, req.Credentials, creds)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to build R2 clients.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build storage clients"})
+		return
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, storageProbeTimeout)
+	defer cancel()
+	if err := store.probe(probeCtx, ac.R2BucketName); err != nil {
+		logCtx.WithError(err).WithField("credentials", req.Credentials).Warn("Reloaded R2 credentials failed the bucket probe; keeping current clients.")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "The new credentials could not access the bucket", "details": err.Error()})
+		return
+	}
+
+	previous := ac.objectStore.Swap(store)
+	details := map[string]interface{}{
+		"credentials":   req.Credentials,
+		"access_key_id": maskAccessKeyID(store.AccessKeyID),
+	}
+	if previous != nil {
+		details["previous_credentials"] = previous.Credentials
+		details["previous_access_key_id"] = maskAccessKeyID(previous.AccessKeyID)
+	}
+	logCtx.WithFields(details).Info("R2 credentials reloaded.")
+	ac.recordAudit(ctx, adminID, auditStorageCredentialsReloaded, "storage", ac.R2BucketName, details)
+
+	c.JSON(http.StatusOK, gin.H{
+		"credentials": req.Credentials,
+		"accessKeyId": maskAccessKeyID(store.AccessKeyID),
+	})
+}
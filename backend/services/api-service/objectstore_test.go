@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestR2CredentialsSelection(t *testing.T) {
+	cfg := &AppConfig{R2AccessKeyID: "primary-id", R2SecretAccessKey: "primary-secret"}
+
+	creds, ok := cfg.r2Credentials(r2CredentialsPrimary)
+	assert.True(t, ok)
+	assert.Equal(t, R2Credentials{"primary-id", "primary-secret"}, creds)
+
+	_, ok = cfg.r2Credentials(r2CredentialsSecondary)
+	assert.False(t, ok, "secondary is optional")
+
+	cfg.R2SecondaryAccessKeyID, cfg.R2SecondarySecretAccessKey = "next-id", "next-secret"
+	creds, ok = cfg.r2Credentials(r2CredentialsSecondary)
+	assert.True(t, ok)
+	assert.Equal(t, R2Credentials{"next-id", "next-secret"}, creds)
+
+	_, ok = cfg.r2Credentials("tertiary")
+	assert.False(t, ok)
+}
+
+func TestMaskAccessKeyID(t *testing.T) {
+	assert.Equal(t, "********cdef", maskAccessKeyID("456789abcdef"))
+	assert.Equal(t, "***", maskAccessKeyID("abc"))
+	assert.Equal(t, "", maskAccessKeyID(""))
+}
+
+func TestObjectStoreSwapKeepsLoadedStore(t *testing.T) {
+	var ac ApiController
+	first := &ObjectStore{Credentials: r2CredentialsPrimary, AccessKeyID: "old"}
+	ac.objectStore.Store(first)
+
+	inFlight := ac.r2()
+	previous := ac.objectStore.Swap(&ObjectStore{Credentials: r2CredentialsSecondary, AccessKeyID: "new"})
+
+	assert.Same(t, first, previous)
+	assert.Equal(t, "old", inFlight.AccessKeyID, "callers keep the store they loaded")
+	assert.Equal(t, "new", ac.r2().AccessKeyID)
+}
+
+func TestObjectStorePutStream(t *testing.T) {
+	ctx := context.Background()
+	objects := newFakeObjectAPI()
+	store := &ObjectStore{S3: objects}
+
+	size, err := store.putStream(ctx, "bucket", "exports/a.zip", "application/zip", strings.NewReader("hello world!"), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), size)
+	assert.Equal(t, []byte("hello world!"), objects.objects["exports/a.zip"])
+	assert.Equal(t, []int{3}, objects.parts)
+
+	size, err = store.putStream(ctx, "bucket", "exports/empty.zip", "application/zip", strings.NewReader(""), 5)
+	assert.NoError(t, err)
+	assert.Zero(t, size)
+	assert.True(t, objects.has("exports/empty.zip"), "an empty body is still stored")
+
+	// A failing body or part aborts the upload instead of leaving it open.
+	_, err = store.putStream(ctx, "bucket", "exports/b.zip", "application/zip", iotest.ErrReader(errors.New("archive failed")), 5)
+	assert.ErrorContains(t, err, "archive failed")
+	objects.failOn("UploadPart", errors.New("boom"))
+	_, err = store.putStream(ctx, "bucket", "exports/c.zip", "application/zip", strings.NewReader("hello world!"), 5)
+	assert.Error(t, err)
+	assert.Zero(t, objects.openUploads())
+	assert.False(t, objects.has("exports/b.zip"))
+	assert.False(t, objects.has("exports/c.zip"))
+}
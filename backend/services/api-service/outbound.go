@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// errOutboundAddressDenied is returned when a user-supplied URL reaches a blocked address.
+var errOutboundAddressDenied = errors.New("outbound address is not allowed")
+
+// alwaysDeniedOutboundCIDRs are ranges user-supplied URLs may never reach unless explicitly
+// allowed: private, loopback, link-local (including the metadata server), CGNAT and unique-local.
+var alwaysDeniedOutboundCIDRs = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = ipNet
+	}
+	return nets
+}
+
+// outboundGuard protects requests to user-supplied URLs (webhooks, imports) against SSRF.
+type outboundGuard struct {
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+}
+
+// newOutboundGuard builds a guard from the outbound settings in cfg.
+func newOutboundGuard(cfg *AppConfig) *outboundGuard {
+	return &outboundGuard{
+		allowed: cfg.OutboundAllowedCIDRs,
+		denied:  append(append([]*net.IPNet{}, alwaysDeniedOutboundCIDRs...), cfg.OutboundDeniedCIDRs...),
+	}
+}
+
+// checkIP rejects addresses in a denied range that are not explicitly allowed.
+func (g *outboundGuard) checkIP(ip net.IP) error {
+	if ip == nil {
+		return errOutboundAddressDenied
+	}
+	for _, n := range g.allowed {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsMulticast() || ip.IsUnspecified() {
+		return errOutboundAddressDenied
+	}
+	for _, n := range g.denied {
+		if n.Contains(ip) {
+			return errOutboundAddressDenied
+		}
+	}
+	return nil
+}
+
+// ValidateURL checks that target is an https URL whose host resolves only to permitted
+// addresses. It is meant for request validation; Client re-checks at connect time.
+func (g *outboundGuard) ValidateURL(ctx context.Context, target string) error {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("URL must be an https URL without credentials")
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("host %q could not be resolved", u.Hostname())
+	}
+	for _, ip := range ips {
+		if err := g.checkIP(ip); err != nil {
+			return fmt.Errorf("host %q resolves to a disallowed address", u.Hostname())
+		}
+	}
+	return nil
+}
+
+// Client returns an HTTP client that checks every connection's address, so a DNS answer
+// that changed since validation (rebinding) cannot reach an internal host. Redirects are
+// not followed because their targets were never validated.
+func (g *outboundGuard) Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return g.checkIP(net.ParseIP(host))
+		},
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errTransferToSelf     = errors.New("cannot transfer ownership to yourself")
+	errTransferNotOwner   = errors.New("caller is no longer an owner")
+	errTransferTargetGone = errors.New("target membership has expired")
+)
+
+// workspaceOwner returns the user accountable for a workspace's quota and billing.
+func workspaceOwner(ws Workspace) string {
+	if ws.OwnedBy != "" {
+		return ws.OwnedBy
+	}
+	return ws.CreatedBy
+}
+
+// TransferWorkspaceOwnership makes another member of the workspace its owner and the
+// workspace's owned_by. The caller, who must be an owner, stays one unless keepAsEditor
+// downgrades them. created_by is left as the historical creator. Memberships, roles and
+// owned_by change in one transaction, so a workspace is never left without an owner.
+func (ac *ApiController) TransferWorkspaceOwnership(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "TransferWorkspaceOwnership"})
+
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+	var req TransferOwnershipRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	logCtx = logCtx.WithField("membership_id", req.NewOwnerMembershipID)
+
+	var target WorkspaceMembership
+	var previousOwner, callerRole string
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		wsRef := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+		wsSnap, err := tx.Get(wsRef)
+		if err != nil {
+			return fmt.Errorf("failed to read workspace: %w", err)
+		}
+		var ws Workspace
+		if err := wsSnap.DataTo(&ws); err != nil {
+			return fmt.Errorf("failed to parse workspace: %w", err)
+		}
+		if ws.DeletedAt != "" {
+			return errWorkspaceDeleting
+		}
+
+		// Re-read the caller's membership: another owner may have demoted them since the check.
+		callerDocs, err := tx.Documents(ac.FirestoreClient.Collection("workspace_memberships").
+			Where("user_id", "==", userID).
+			Where("workspace_id", "==", workspaceID).
+			Limit(1)).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to query caller membership: %w", err)
+		}
+		var caller WorkspaceMembership
+		if len(callerDocs) == 1 {
+			if err := callerDocs[0].DataTo(&caller); err != nil {
+				return fmt.Errorf("failed to parse caller membership: %w", err)
+			}
+		}
+		if caller.Role != roleOwner {
+			return errTransferNotOwner
+		}
+
+		targetRef := ac.FirestoreClient.Collection("workspace_memberships").Doc(req.NewOwnerMembershipID)
+		targetSnap, err := tx.Get(targetRef)
+		if err != nil {
+			if isNotFound(err) {
+				return errMemberNotFound
+			}
+			return fmt.Errorf("failed to read target membership: %w", err)
+		}
+		if err := targetSnap.DataTo(&target); err != nil {
+			return fmt.Errorf("failed to parse target membership: %w", err)
+		}
+		// Memberships of other workspaces are reported like missing ones.
+		if target.WorkspaceID != workspaceID {
+			return errMemberNotFound
+		}
+		if target.UserID == userID {
+			return errTransferToSelf
+		}
+		if membershipExpired(target, time.Now()) {
+			return errTransferTargetGone
+		}
+
+		previousOwner = workspaceOwner(ws)
+		callerRole = roleOwner
+		if req.KeepAsEditor {
+			callerRole = roleEditor
+			if err := tx.Update(callerDocs[0].Ref, []docstore.Update{{Path: "role", Value: roleEditor}}); err != nil {
+				return err
+			}
+		}
+		// Owners do not lapse; drop any expiry the target had as a guest.
+		target.Role = roleOwner
+		target.ExpiresAt = ""
+		if err := tx.Update(targetRef, []docstore.Update{
+			{Path: "role", Value: roleOwner},
+			{Path: "expires_at", Value: docstore.Delete},
+		}); err != nil {
+			return err
+		}
+		return tx.Update(wsRef, []docstore.Update{
+			{Path: "owned_by", Value: target.UserID},
+			{Path: "updated_at", Value: NowISO8601()},
+		})
+	})
+	switch {
+	case errors.Is(err, errMemberNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	case errors.Is(err, errTransferToSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ownership cannot be transferred to yourself"})
+		return
+	case errors.Is(err, errTransferTargetGone):
+		c.JSON(http.StatusConflict, gin.H{"error": "The member's access has expired"})
+		return
+	case errors.Is(err, errTransferNotOwner):
+		insufficientRole(c, "Only workspace owners can perform this action", roleOwner, "")
+		return
+	case errors.Is(err, errWorkspaceDeleting):
+		c.JSON(http.StatusConflict, gin.H{"error": "Workspace is being deleted"})
+		return
+	case err != nil:
+		logCtx.WithError(err).Error("Failed to transfer workspace ownership.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
+		return
+	}
+
+	ac.recordActivity(ctx, workspaceID, userID, activityOwnershipTransferred, map[string]interface{}{
+		"owned_by":       target.UserID,
+		"previous_owner": previousOwner,
+		"caller_role":    callerRole,
+	})
+	ac.touchWorkspace(ctx, workspaceID)
+	logCtx.WithFields(log.Fields{"owned_by": target.UserID, "caller_role": callerRole}).Info("Workspace ownership transferred.")
+	c.JSON(http.StatusOK, TransferOwnershipResponse{
+		WorkspaceID:   workspaceID,
+		OwnedBy:       target.UserID,
+		PreviousOwner: previousOwner,
+		CallerRole:    callerRole,
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspaceOwner(t *testing.T) {
+	assert.Equal(t, "creator", workspaceOwner(Workspace{CreatedBy: "creator"}), "older workspaces fall back to the creator")
+	assert.Equal(t, "heir", workspaceOwner(Workspace{CreatedBy: "creator", OwnedBy: "heir"}))
+}
+
+// membershipIDOf returns the ID of userID's membership in the workspace.
+func membershipIDOf(h *handlerHarness, workspaceID, userID string) string {
+	h.t.Helper()
+	var listed struct {
+		Members []WorkspaceMember `json:"members"`
+	}
+	h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/members", userID, nil, &listed)
+	for _, m := range listed.Members {
+		if m.UserID == userID {
+			return m.MembershipID
+		}
+	}
+	h.t.Fatalf("%s is not a member of %s", userID, workspaceID)
+	return ""
+}
+
+func TestHandlers_TransferWorkspaceOwnership(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	heir := "heir-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, heir, "editor", "")
+	heirMembership := membershipIDOf(h, workspaceID, heir)
+	path := "/api/workspaces/" + workspaceID + "/transfer-ownership"
+
+	other := h.createWorkspace(owner)
+	stranger := "stranger-" + uuid.New().String()
+	h.seedMembership(other, stranger, "editor", "")
+
+	w := h.do(http.MethodPost, path, heir, gin.H{"newOwnerMembershipId": heirMembership}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "editors cannot take ownership")
+	w = h.do(http.MethodPost, path, owner, gin.H{"newOwnerMembershipId": membershipIDOf(h, workspaceID, owner)}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "to the caller themselves")
+	w = h.do(http.MethodPost, path, owner, gin.H{"newOwnerMembershipId": membershipIDOf(h, other, stranger)}, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, "a membership of another workspace")
+	w = h.do(http.MethodPost, path, owner, gin.H{}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var resp TransferOwnershipResponse
+	w = h.do(http.MethodPost, path, owner, gin.H{"newOwnerMembershipId": heirMembership, "keepAsEditor": true}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, TransferOwnershipResponse{WorkspaceID: workspaceID, OwnedBy: heir, PreviousOwner: owner, CallerRole: "editor"}, resp)
+
+	// The roles swapped and owned_by moved together; created_by stays historical.
+	snap, err := h.fs.Collection("workspaces").Doc(workspaceID).Get(context.Background())
+	assert.NoError(t, err)
+	var ws Workspace
+	assert.NoError(t, snap.DataTo(&ws))
+	assert.Equal(t, heir, ws.OwnedBy)
+	assert.Equal(t, owner, ws.CreatedBy)
+	roles := map[string]string{}
+	var listed struct {
+		Members []WorkspaceMember `json:"members"`
+	}
+	h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/members", heir, nil, &listed)
+	for _, m := range listed.Members {
+		roles[m.UserID] = m.Role
+	}
+	assert.Equal(t, map[string]string{owner: "editor", heir: "owner"}, roles)
+
+	// The former owner can no longer transfer; the new one can hand it back and stay owner.
+	w = h.do(http.MethodPost, path, owner, gin.H{"newOwnerMembershipId": heirMembership}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = h.do(http.MethodPost, path, heir, gin.H{"newOwnerMembershipId": membershipIDOf(h, workspaceID, owner)}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "owner", resp.CallerRole)
+	assert.Equal(t, owner, resp.OwnedBy)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// queueDepthStaleAfter is how many refresh intervals a counted depth is trusted for. Past
+// that, execution requests are let through as if the count were unavailable, like the rate
+// limiter does when its store fails.
+const queueDepthStaleAfter = 3
+
+// QueueDepthStats is the last queue depth this instance counted.
+type QueueDepthStats struct {
+	Queued      int       `json:"queued"`
+	RefreshedAt time.Time `json:"refreshedAt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// queueDepthCache holds the number of queued jobs, refreshed in the background by
+// runQueueDepthRefresh so execution requests never wait on a count. State is per instance.
+type queueDepthCache struct {
+	mu          sync.Mutex
+	queued      int
+	refreshedAt time.Time
+	lastError   string
+}
+
+func (q *queueDepthCache) set(queued int, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queued, q.refreshedAt, q.lastError = queued, now, ""
+}
+
+func (q *queueDepthCache) fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lastError = err.Error()
+}
+
+// current returns the last counted depth, and false when none was counted since maxAge
+// before now.
+func (q *queueDepthCache) current(now time.Time, maxAge time.Duration) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.refreshedAt.IsZero() || now.Sub(q.refreshedAt) > maxAge {
+		return 0, false
+	}
+	return q.queued, true
+}
+
+func (q *queueDepthCache) Stats() QueueDepthStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QueueDepthStats{Queued: q.queued, RefreshedAt: q.refreshedAt, LastError: q.lastError}
+}
+
+// queueBackpressureEnabled reports whether either execution limit is set, and so whether
+// the queue depth needs counting.
+func (ac *ApiController) queueBackpressureEnabled() bool {
+	return ac.AppConfig.QueueBackpressurePublicLimit > 0 || ac.AppConfig.QueueBackpressureAuthLimit > 0
+}
+
+// refreshQueueDepth counts the queued jobs into the depth cache.
+func (ac *ApiController) refreshQueueDepth(ctx context.Context) error {
+	queued, err := ac.Jobs.Count(ctx, []string{"queued"})
+	if err != nil {
+		ac.queueDepth.fail(err)
+		return err
+	}
+	ac.queueDepth.set(queued, time.Now())
+	return nil
+}
+
+// runQueueDepthRefresh refreshes the queue depth now and then every interval until ctx is
+// done.
+func (ac *ApiController) runQueueDepthRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := ac.refreshQueueDepth(ctx); err != nil && ctx.Err() == nil {
+			log.WithError(err).Warn("Failed to count queued jobs; execution backpressure uses the last count.")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// admitExecution checks the cached queue depth against the public or authenticated limit.
+// Once the limit is reached it writes a 429 with Retry-After set to the refresh interval,
+// when the depth is next counted, and returns false.
+func (ac *ApiController) admitExecution(c *gin.Context, authenticated bool) bool {
+	limit := ac.AppConfig.QueueBackpressurePublicLimit
+	if authenticated {
+		limit = ac.AppConfig.QueueBackpressureAuthLimit
+	}
+	if limit <= 0 {
+		return true
+	}
+	refresh := ac.AppConfig.QueueDepthRefresh
+	queued, ok := ac.queueDepth.current(time.Now(), queueDepthStaleAfter*refresh)
+	if !ok || queued < limit {
+		return true
+	}
+
+	retryAfter := max(int(refresh.Seconds()), 1)
+	log.WithFields(log.Fields{"queued_jobs": queued, "limit": limit, "authenticated": authenticated}).
+		Warn("Execution refused: the job queue is saturated.")
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":             "The execution queue is saturated; try again later",
+		"queuedJobs":        queued,
+		"retryAfterSeconds": retryAfter,
+	})
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecuteCode_QueueBackpressure(t *testing.T) {
+	r, ac, tasks := newEmbeddedHarness(t)
+	ac.AppConfig.QueueBackpressurePublicLimit = 2
+	ac.AppConfig.QueueDepthRefresh = 15 * time.Second
+	ctx := context.Background()
+	body := RequestBody{Code: "print(1)", Language: "python"}
+
+	var jobIDs []string
+	for i := 0; i < 3; i++ {
+		// The limit applies to the last count, not to jobs created since it was taken.
+		jobIDs = append(jobIDs, executeSnippet(t, r, body)["job_id"].(string))
+	}
+
+	assert.NoError(t, ac.refreshQueueDepth(ctx))
+	var refused map[string]interface{}
+	w := serveJSON(r, http.MethodPost, "/api/execute", body, &refused)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	assert.Equal(t, "15", w.Header().Get("Retry-After"))
+	assert.Equal(t, float64(3), refused["queuedJobs"])
+	assert.Equal(t, float64(15), refused["retryAfterSeconds"])
+	batch := ExecuteBatchRequest{Items: []json.RawMessage{json.RawMessage(`{"code":"print(1)","language":"python"}`)}}
+	w = serveJSON(r, http.MethodPost, "/api/execute/batch", batch, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	assert.Len(t, tasks.tasksTo("/execute"), 3, "refused executions queue no task")
+
+	completePublicJob(t, r, jobIDs[0])
+	completePublicJob(t, r, jobIDs[1])
+	w = serveJSON(r, http.MethodPost, "/api/execute", body, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "the last count holds until the next refresh")
+
+	assert.NoError(t, ac.refreshQueueDepth(ctx))
+	executeSnippet(t, r, body)
+	assert.Equal(t, 1, ac.queueDepth.Stats().Queued)
+}
+
+func TestAdmitExecution_Limits(t *testing.T) {
+	_, ac, _ := newEmbeddedHarness(t)
+	ac.AppConfig.QueueBackpressurePublicLimit = 10
+	ac.AppConfig.QueueBackpressureAuthLimit = 20
+	ac.AppConfig.QueueDepthRefresh = time.Minute
+
+	admit := func(authenticated bool) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		if !ac.admitExecution(c, authenticated) {
+			return w.Code
+		}
+		return http.StatusOK
+	}
+
+	assert.Equal(t, http.StatusOK, admit(false), "nothing counted yet")
+
+	now := time.Now()
+	ac.queueDepth.set(15, now)
+	assert.Equal(t, http.StatusTooManyRequests, admit(false))
+	assert.Equal(t, http.StatusOK, admit(true), "authenticated executions have a higher limit")
+
+	ac.queueDepth.set(20, now)
+	assert.Equal(t, http.StatusTooManyRequests, admit(true))
+
+	ac.queueDepth.set(20, now.Add(-queueDepthStaleAfter*time.Minute-time.Second))
+	assert.Equal(t, http.StatusOK, admit(false), "a stale count admits executions")
+
+	ac.queueDepth.set(20, now)
+	ac.AppConfig.QueueBackpressureAuthLimit = 0
+	assert.Equal(t, http.StatusOK, admit(true), "a zero limit is disabled")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// QueueHealthStats is one enqueue target's health as seen by this instance.
+type QueueHealthStats struct {
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Degraded            bool      `json:"degraded"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastFailureAt       time.Time `json:"lastFailureAt"`
+}
+
+// queueHealth counts consecutive enqueue failures per target. Once a target reaches the
+// threshold it is degraded: only one attempt per drip interval (jittered so instances do
+// not probe in lockstep) is let through. The first success makes the target healthy again.
+// State is per instance; each instance backs off on its own evidence.
+type queueHealth struct {
+	threshold int
+	drip      time.Duration
+
+	mu      sync.Mutex
+	targets map[string]*queueTargetHealth
+}
+
+type queueTargetHealth struct {
+	failures      int
+	lastError     string
+	lastFailureAt time.Time
+	nextAttempt   time.Time
+}
+
+func newQueueHealth(threshold int, drip time.Duration) *queueHealth {
+	return &queueHealth{threshold: threshold, drip: drip, targets: make(map[string]*queueTargetHealth)}
+}
+
+// allowAttempt reports whether an enqueue to target should be tried now. While degraded it
+// returns true at most once per drip slot.
+func (h *queueHealth) allowAttempt(target string, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.targets[target]
+	if t == nil || t.failures < h.threshold {
+		return true
+	}
+	if now.Before(t.nextAttempt) {
+		return false
+	}
+	t.nextAttempt = now.Add(h.jitteredDrip())
+	return true
+}
+
+// record notes the outcome of an enqueue and reports whether it ended a degraded period.
+func (h *queueHealth) record(target string, err error, now time.Time) (recovered bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.targets[target]
+	if err == nil {
+		if t != nil {
+			delete(h.targets, target)
+			return t.failures >= h.threshold
+		}
+		return false
+	}
+	if t == nil {
+		t = &queueTargetHealth{}
+		h.targets[target] = t
+	}
+	t.failures++
+	t.lastError = err.Error()
+	t.lastFailureAt = now
+	if t.failures >= h.threshold && !now.Before(t.nextAttempt) {
+		t.nextAttempt = now.Add(h.jitteredDrip())
+	}
+	return false
+}
+
+// degraded reports whether target is past the failure threshold.
+func (h *queueHealth) degraded(target string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t := h.targets[target]
+	return t != nil && t.failures >= h.threshold
+}
+
+// Stats returns the health of every target that has failed since its last success.
+func (h *queueHealth) Stats() map[string]QueueHealthStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := make(map[string]QueueHealthStats, len(h.targets))
+	for target, t := range h.targets {
+		stats[target] = QueueHealthStats{
+			ConsecutiveFailures: t.failures,
+			Degraded:            t.failures >= h.threshold,
+			LastError:           t.lastError,
+			LastFailureAt:       t.lastFailureAt,
+		}
+	}
+	return stats
+}
+
+// jitteredDrip returns a delay between half and one and a half drip intervals.
+func (h *queueHealth) jitteredDrip() time.Duration {
+	return h.drip/2 + rand.N(h.drip)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueHealthDegradesAfterThreshold(t *testing.T) {
+	h := newQueueHealth(3, time.Minute)
+	now := time.Now()
+	failure := errors.New("queue paused")
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, h.allowAttempt("q", now))
+		h.record("q", failure, now)
+	}
+	assert.False(t, h.degraded("q"))
+	assert.True(t, h.allowAttempt("q", now))
+
+	h.record("q", failure, now)
+	assert.True(t, h.degraded("q"))
+	assert.False(t, h.allowAttempt("q", now), "a degraded target waits for the next drip slot")
+
+	stats := h.Stats()["q"]
+	assert.Equal(t, 3, stats.ConsecutiveFailures)
+	assert.True(t, stats.Degraded)
+	assert.Equal(t, "queue paused", stats.LastError)
+}
+
+func TestQueueHealthDripsWithJitter(t *testing.T) {
+	h := newQueueHealth(1, time.Minute)
+	now := time.Now()
+	h.record("q", errors.New("unavailable"), now)
+
+	assert.False(t, h.allowAttempt("q", now.Add(29*time.Second)))
+	assert.True(t, h.allowAttempt("q", now.Add(91*time.Second)))
+	assert.False(t, h.allowAttempt("q", now.Add(92*time.Second)), "one attempt per slot")
+}
+
+func TestQueueHealthRecoversOnSuccess(t *testing.T) {
+	h := newQueueHealth(1, time.Minute)
+	now := time.Now()
+
+	assert.False(t, h.record("q", nil, now), "success on a healthy target is not a recovery")
+	h.record("q", errors.New("unavailable"), now)
+	h.record("other", errors.New("unavailable"), now)
+
+	assert.True(t, h.record("q", nil, now))
+	assert.False(t, h.degraded("q"))
+	assert.True(t, h.allowAttempt("q", now))
+	assert.True(t, h.degraded("other"), "targets are tracked independently")
+	assert.NotContains(t, h.Stats(), "q")
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/1liale/api-service/docstore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// pendingR2DeletionsCollection holds R2 objects whose deletion failed and must be retried.
+	pendingR2DeletionsCollection = "pending_r2_deletions"
+
+	// r2DeleteObjectsLimit is the maximum number of keys a single DeleteObjects call accepts.
+	r2DeleteObjectsLimit = 1000
+)
+
+// R2DeletionRunResult summarizes one pass over the pending deletions queue.
+type R2DeletionRunResult struct {
+	Processed    int `json:"processed"`
+	Deleted      int `json:"deleted"`
+	StillFailing int `json:"stillFailing"`
+	Escalated    int `json:"escalated"`
+}
+
+// PendingR2DeletionStats describes the backlog of R2 objects awaiting deletion.
+type PendingR2DeletionStats struct {
+	QueueDepth              int64 `json:"queueDepth"`
+	OldestPendingAgeSeconds int64 `json:"oldestPendingAgeSeconds"`
+	Retried                 int64 `json:"retried"`   // Since this instance started
+	Deleted                 int64 `json:"deleted"`   // Since this instance started
+	Escalated               int64 `json:"escalated"` // Since this instance started
+}
+
+// r2DeletionCounters tracks retry outcomes on this instance.
+type r2DeletionCounters struct {
+	retried   atomic.Int64
+	deleted   atomic.Int64
+	escalated atomic.Int64
+}
+
+// pendingR2DeletionDocID derives a stable document ID from an object key, which may contain slashes.
+func pendingR2DeletionDocID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// deleteR2Objects deletes keys from the bucket in DeleteObjects batches and returns the keys
+// that could not be deleted, mapped to the reason. A failed request fails its whole batch.
+func (ac *ApiController) deleteR2Objects(ctx context.Context, keys []string) map[string]string {
+	failed := make(map[string]string)
+	for start := 0; start < len(keys); start += r2DeleteObjectsLimit {
+		batch := keys[start:min(start+r2DeleteObjectsLimit, len(keys))]
+
+		objects := make([]types.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			objects[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		out, err := ac.r2().S3.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(ac.R2BucketName),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			for _, key := range batch {
+				failed[key] = err.Error()
+			}
+			continue
+		}
+		for _, objErr := range out.Errors {
+			failed[aws.ToString(objErr.Key)] = fmt.Sprintf("%s: %s", aws.ToString(objErr.Code), aws.ToString(objErr.Message))
+		}
+	}
+	return failed
+}
+
+// recordFailedR2Deletions queues failed deletions for retry. A key that is already queued
+// keeps its original first-failure time and has its attempt count bumped.
+func (ac *ApiController) recordFailedR2Deletions(ctx context.Context, workspaceID string, failures map[string]string) {
+	now := time.Now().UTC()
+	coll := ac.FirestoreClient.Collection(pendingR2DeletionsCollection)
+
+	for key, reason := range failures {
+		ref := coll.Doc(pendingR2DeletionDocID(key))
+		_, err := ref.Create(ctx, PendingR2Deletion{
+			R2ObjectKey:   key,
+			WorkspaceID:   workspaceID,
+			FirstFailedAt: now,
+			LastAttemptAt: now,
+			Attempts:      1,
+			LastError:     reason,
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			_, err = ref.Update(ctx, []docstore.Update{
+				{Path: "attempts", Value: docstore.Increment(1)},
+				{Path: "last_attempt_at", Value: now},
+				{Path: "last_error", Value: reason},
+			})
+		}
+		if err != nil {
+			// The object is now untracked and will leak until someone cleans it up by hand.
+			reportError(err, "Failed to queue R2 object for deletion retry", log.Fields{
+				"workspace_id":  workspaceID,
+				"r2_object_key": key,
+			})
+		}
+	}
+}
+
+// retryPendingR2Deletions retries the least recently attempted queued deletions. Successful
+// entries are removed; entries reaching R2DeletionMaxAttempts are reported once and kept
+// in the queue so they remain visible and continue to be retried.
+func (ac *ApiController) retryPendingR2Deletions(ctx context.Context) (R2DeletionRunResult, error) {
+	var result R2DeletionRunResult
+
+	docs, err := ac.FirestoreClient.Collection(pendingR2DeletionsCollection).
+		OrderBy("last_attempt_at", docstore.Asc).
+		Limit(ac.AppConfig.R2DeletionBatchSize).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return result, fmt.Errorf("failed to load pending R2 deletions: %w", err)
+	}
+	if len(docs) == 0 {
+		return result, nil
+	}
+
+	entries := make(map[string]*docstore.DocumentSnapshot, len(docs))
+	keys := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		key, err := doc.DataAt("r2_object_key")
+		if err != nil {
+			log.WithError(err).WithField("doc_id", doc.Ref.ID).Warn("Pending R2 deletion has no object key, dropping it.")
+			if _, err := doc.Ref.Delete(ctx); err != nil {
+				// It stays first in line and is dropped again next run.
+				log.WithError(err).WithField("doc_id", doc.Ref.ID).Warn("Failed to drop pending R2 deletion without an object key.")
+			}
+			continue
+		}
+		keyStr, _ := key.(string)
+		entries[keyStr] = doc
+		keys = append(keys, keyStr)
+	}
+
+	failures := ac.deleteR2Objects(ctx, keys)
+	now := time.Now().UTC()
+
+	for key, doc := range entries {
+		result.Processed++
+		logCtx := log.WithFields(log.Fields{"r2_object_key": key, "doc_id": doc.Ref.ID})
+
+		reason, failed := failures[key]
+		if !failed {
+			if _, err := doc.Ref.Delete(ctx); err != nil {
+				// The object is gone; a stale entry will simply succeed again next run.
+				logCtx.WithError(err).Warn("Deleted R2 object but failed to remove its pending entry.")
+			}
+			result.Deleted++
+			continue
+		}
+
+		var entry PendingR2Deletion
+		if err := doc.DataTo(&entry); err != nil {
+			logCtx.WithError(err).Warn("Failed to parse pending R2 deletion entry.")
+		}
+		entry.Attempts++
+
+		updates := []docstore.Update{
+			{Path: "attempts", Value: docstore.Increment(1)},
+			{Path: "last_attempt_at", Value: now},
+			{Path: "last_error", Value: reason},
+		}
+		if entry.Attempts >= ac.AppConfig.R2DeletionMaxAttempts && !entry.Escalated {
+			updates = append(updates, docstore.Update{Path: "escalated", Value: true})
+			reportError(fmt.Errorf("%s", reason), "R2 object deletion keeps failing", log.Fields{
+				"r2_object_key":   key,
+				"workspace_id":    entry.WorkspaceID,
+				"attempts":        entry.Attempts,
+				"first_failed_at": entry.FirstFailedAt,
+			})
+			result.Escalated++
+		}
+		if _, err := doc.Ref.Update(ctx, updates); err != nil {
+			logCtx.WithError(err).Warn("Failed to update pending R2 deletion entry.")
+		}
+		result.StillFailing++
+	}
+
+	ac.r2Deletions.retried.Add(int64(result.Processed))
+	ac.r2Deletions.deleted.Add(int64(result.Deleted))
+	ac.r2Deletions.escalated.Add(int64(result.Escalated))
+	return result, nil
+}
+
+// pendingR2DeletionStats reports the queue depth and the age of the oldest queued deletion.
+func (ac *ApiController) pendingR2DeletionStats(ctx context.Context) (PendingR2DeletionStats, error) {
+	stats := PendingR2DeletionStats{
+		Retried:   ac.r2Deletions.retried.Load(),
+		Deleted:   ac.r2Deletions.deleted.Load(),
+		Escalated: ac.r2Deletions.escalated.Load(),
+	}
+	coll := ac.FirestoreClient.Collection(pendingR2DeletionsCollection)
+
+	res, err := coll.NewAggregationQuery().WithCount("depth").Get(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count pending R2 deletions: %w", err)
+	}
+	if v, ok := res["depth"].(*firestorepb.Value); ok {
+		stats.QueueDepth = v.GetIntegerValue()
+	}
+	if stats.QueueDepth == 0 {
+		return stats, nil
+	}
+
+	docs, err := coll.OrderBy("first_failed_at", docstore.Asc).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return stats, fmt.Errorf("failed to load oldest pending R2 deletion: %w", err)
+	}
+	if len(docs) > 0 {
+		var oldest PendingR2Deletion
+		if err := docs[0].DataTo(&oldest); err == nil && !oldest.FirstFailedAt.IsZero() {
+			stats.OldestPendingAgeSeconds = int64(time.Since(oldest.FirstFailedAt).Seconds())
+		}
+	}
+	return stats, nil
+}
+
+// runR2DeletionMaintenance is the "r2-deletions" maintenance task: one retry pass over the
+// pending deletions queue followed by the queue's depth and age.
+func (ac *ApiController) runR2DeletionMaintenance(ctx context.Context) (interface{}, error) {
+	result, err := ac.retryPendingR2Deletions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logCtx := log.WithField("maintenance_task", "r2-deletions")
+	logCtx.WithFields(log.Fields{
+		"processed":     result.Processed,
+		"deleted":       result.Deleted,
+		"still_failing": result.StillFailing,
+		"escalated":     result.Escalated,
+	}).Info("Pending R2 deletion pass completed.")
+
+	stats, err := ac.pendingR2DeletionStats(ctx)
+	if err != nil {
+		logCtx.WithError(err).Warn("Failed to compute pending R2 deletion stats.")
+	} else {
+		logCtx.WithFields(log.Fields{
+			"queue_depth":                stats.QueueDepth,
+			"oldest_pending_age_seconds": stats.OldestPendingAgeSeconds,
+		}).Info("Pending R2 deletion queue status.")
+	}
+
+	return gin.H{"result": result, "queue": stats}, nil
+}
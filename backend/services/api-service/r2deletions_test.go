@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteR2Objects_Batches(t *testing.T) {
+	objects := newFakeObjectAPI()
+	ac := &ApiController{R2BucketName: "test-bucket"}
+	ac.objectStore.Store(&ObjectStore{S3: objects, Presign: &fakePresigner{}, Credentials: r2CredentialsPrimary})
+
+	keys := make([]string, 2*r2DeleteObjectsLimit+500)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("workspaces/ws/files/%d/f.txt", i)
+		objects.put(keys[i], []byte("x"))
+	}
+	assert.Empty(t, ac.deleteR2Objects(context.Background(), keys))
+	assert.Equal(t, []int{r2DeleteObjectsLimit, r2DeleteObjectsLimit, 500}, objects.batches)
+	assert.False(t, objects.has(keys[0]))
+	assert.False(t, objects.has(keys[len(keys)-1]))
+
+	// A failed request fails every key of its batch.
+	objects.failOn("DeleteObjects", errors.New("r2 unavailable"))
+	failures := ac.deleteR2Objects(context.Background(), keys[:3])
+	assert.Equal(t, map[string]string{keys[0]: "r2 unavailable", keys[1]: "r2 unavailable", keys[2]: "r2 unavailable"}, failures)
+}
+
+// pendingR2Deletion reads the queue entry of key; ok is false once it has been removed.
+func pendingR2Deletion(h *handlerHarness, key string) (entry PendingR2Deletion, ok bool) {
+	h.t.Helper()
+	snap, err := h.fs.Collection(pendingR2DeletionsCollection).Doc(pendingR2DeletionDocID(key)).Get(context.Background())
+	if isNotFound(err) {
+		return entry, false
+	}
+	if err != nil {
+		h.t.Fatalf("load pending deletion: %v", err)
+	}
+	assert.NoError(h.t, snap.DataTo(&entry))
+	return entry, true
+}
+
+// escalations returns the error reports logged for key.
+func escalations(hook *test.Hook, key string) []*log.Entry {
+	var reported []*log.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["@type"] == reportedErrorEventType && entry.Data["r2_object_key"] == key {
+			reported = append(reported, entry)
+		}
+	}
+	return reported
+}
+
+func TestHandlers_R2DeletionRetry(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.R2DeletionBatchSize = r2DeleteObjectsLimit
+	h.ac.AppConfig.R2DeletionMaxAttempts = 3
+	hook := test.NewGlobal()
+	t.Cleanup(hook.Reset)
+	ctx := context.Background()
+
+	workspaceID := uuid.New().String()
+	key := fmt.Sprintf("workspaces/%s/files/%s/main.py", workspaceID, uuid.New().String())
+	h.objects.put(key, []byte("print('hi')"))
+	h.ac.recordFailedR2Deletions(ctx, workspaceID, map[string]string{key: "first failure"})
+	h.ac.recordFailedR2Deletions(ctx, workspaceID, map[string]string{key: "second failure"})
+	entry, ok := pendingR2Deletion(h, key)
+	if assert.True(t, ok) {
+		assert.Equal(t, 2, entry.Attempts, "a key queued again keeps one entry")
+		assert.Equal(t, "second failure", entry.LastError)
+		assert.False(t, entry.Escalated)
+	}
+	firstFailedAt := entry.FirstFailedAt
+
+	runRetry := func() {
+		t.Helper()
+		w := h.do(http.MethodPost, "/api/admin/maintenance/r2-deletions", "admin", nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// The third failed attempt reaches the maximum and is reported once.
+	h.objects.failOn("DeleteObjects", errors.New("r2 unavailable"))
+	runRetry()
+	entry, _ = pendingR2Deletion(h, key)
+	assert.Equal(t, 3, entry.Attempts)
+	assert.Equal(t, "r2 unavailable", entry.LastError)
+	assert.True(t, entry.Escalated)
+	assert.True(t, entry.FirstFailedAt.Equal(firstFailedAt))
+	assert.Len(t, escalations(hook, key), 1)
+
+	runRetry()
+	entry, _ = pendingR2Deletion(h, key)
+	assert.Equal(t, 4, entry.Attempts, "escalated entries keep being retried")
+	assert.Len(t, escalations(hook, key), 1, "escalation is reported once")
+
+	h.objects.failOn("DeleteObjects", nil)
+	runRetry()
+	_, ok = pendingR2Deletion(h, key)
+	assert.False(t, ok, "a successful retry removes the entry")
+	assert.False(t, h.objects.has(key))
+}
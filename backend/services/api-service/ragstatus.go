@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const ragStatusCollection = "rag_status"
+
+// ragIndexingTarget names the RAG indexing queue in queueHealth.
+const ragIndexingTarget = "rag_indexing"
+
+// RAG indexing states reported by the rag status endpoint.
+const (
+	ragIndexingOK       = "ok"       // Nothing is waiting to be indexed
+	ragIndexingRetrying = "retrying" // An enqueue failed; files wait for the next maintenance pass
+	ragIndexingDegraded = "degraded" // The queue keeps failing; files are sent at a slow drip
+)
+
+// ragDrainBatch bounds how many workspaces one rag-indexing maintenance pass looks at.
+const ragDrainBatch = 25
+
+// requestRagIndexing sends files for indexing. While the indexing queue is degraded, or when
+// the enqueue fails, the files are parked on the workspace's rag status instead and sent later
+// by the rag-indexing maintenance task, so callers' commits never wait on the queue.
+func (ac *ApiController) requestRagIndexing(ctx context.Context, jobID, workspaceID, workspaceVersion string, files []WorkerFile) error {
+	now := time.Now()
+	if !ac.queues.allowAttempt(ragIndexingTarget, now) {
+		return ac.deferRagIndexing(ctx, workspaceID, files, "indexing queue is degraded")
+	}
+
+	err := ac.enqueueRagIndexing(ctx, jobID, workspaceID, workspaceVersion, files)
+	if ac.queues.record(ragIndexingTarget, err, now) {
+		ac.scheduleRagDrain()
+	}
+	if err != nil {
+		if deferErr := ac.deferRagIndexing(ctx, workspaceID, files, err.Error()); deferErr != nil {
+			return fmt.Errorf("%w (and failed to defer files: %v)", err, deferErr)
+		}
+		log.WithError(err).WithFields(log.Fields{
+			"workspace_id": workspaceID,
+			"job_id":       jobID,
+			"file_count":   len(files),
+		}).Warn("RAG indexing enqueue failed; files deferred.")
+	}
+	return nil
+}
+
+// deferRagIndexing merges files into the workspace's pending set. A later version of a
+// path replaces the earlier one, so the backlog is bounded by the workspace's file count.
+func (ac *ApiController) deferRagIndexing(ctx context.Context, workspaceID string, files []WorkerFile, reason string) error {
+	pending := make(map[string]interface{}, len(files))
+	for _, f := range files {
+		pending[f.FilePath] = f.R2ObjectKey
+	}
+	state := ragIndexingRetrying
+	if ac.queues.degraded(ragIndexingTarget) {
+		state = ragIndexingDegraded
+	}
+	_, err := ac.FirestoreClient.Collection(ragStatusCollection).Doc(workspaceID).Set(ctx, map[string]interface{}{
+		"workspace_id":  workspaceID,
+		"state":         state,
+		"pending_files": pending,
+		"last_error":    reason,
+		"updated_at":    NowISO8601(),
+	}, docstore.MergeAll)
+	if err != nil {
+		return fmt.Errorf("failed to defer RAG indexing for workspace %s: %w", workspaceID, err)
+	}
+	return nil
+}
+
+// scheduleRagDrain sends parked files right away once the queue has recovered, instead of
+// waiting for the next scheduled maintenance pass.
+func (ac *ApiController) scheduleRagDrain() {
+	err := ac.Background.Submit("rag_indexing_drain", func(ctx context.Context) error {
+		_, err := ac.runRagIndexingMaintenance(ctx)
+		return err
+	})
+	if err != nil {
+		log.WithError(err).Warn("Failed to schedule RAG indexing drain.")
+	}
+}
+
+// runRagIndexingMaintenance sends parked files for workspaces with an indexing backlog. While
+// the queue is degraded it stops after the first attempt the drip does not allow.
+func (ac *ApiController) runRagIndexingMaintenance(ctx context.Context) (interface{}, error) {
+	docs, err := ac.FirestoreClient.Collection(ragStatusCollection).
+		Where("state", "in", []string{ragIndexingRetrying, ragIndexingDegraded}).
+		Limit(ragDrainBatch).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pending RAG indexing: %w", err)
+	}
+
+	drained, failed, throttled := 0, 0, false
+	for _, doc := range docs {
+		now := time.Now()
+		if !ac.queues.allowAttempt(ragIndexingTarget, now) {
+			throttled = true
+			break
+		}
+		var st RagIndexStatus
+		if err := doc.DataTo(&st); err != nil {
+			log.WithError(err).WithField("workspace_id", doc.Ref.ID).Warn("Failed to parse RAG status.")
+			continue
+		}
+
+		files := make([]WorkerFile, 0, len(st.PendingFiles))
+		for path, key := range st.PendingFiles {
+			files = append(files, WorkerFile{FilePath: path, R2ObjectKey: key})
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].FilePath < files[j].FilePath })
+
+		// The status doc's update time stands in for a workspace version, so a drain is only
+		// deduplicated against a retry of the same backlog.
+		backlogVersion := fmt.Sprintf("pending@%d", doc.UpdateTime.UnixNano())
+		err := ac.enqueueRagIndexing(ctx, uuid.New().String(), doc.Ref.ID, backlogVersion, files)
+		ac.queues.record(ragIndexingTarget, err, now)
+		if err != nil {
+			failed++
+			state := ragIndexingRetrying
+			if ac.queues.degraded(ragIndexingTarget) {
+				state = ragIndexingDegraded
+			}
+			if _, uerr := doc.Ref.Update(ctx, []docstore.Update{
+				{Path: "state", Value: state},
+				{Path: "last_error", Value: err.Error()},
+				{Path: "updated_at", Value: NowISO8601()},
+			}); uerr != nil {
+				log.WithError(uerr).WithField("workspace_id", doc.Ref.ID).Warn("Failed to update RAG status.")
+			}
+			continue
+		}
+
+		// Files deferred while this pass ran change the update time; they stay pending.
+		_, err = doc.Ref.Update(ctx, []docstore.Update{
+			{Path: "state", Value: ragIndexingOK},
+			{Path: "pending_files", Value: docstore.Delete},
+			{Path: "last_error", Value: docstore.Delete},
+			{Path: "updated_at", Value: NowISO8601()},
+		}, docstore.LastUpdateTime(doc.UpdateTime))
+		if err != nil && status.Code(err) != codes.FailedPrecondition {
+			log.WithError(err).WithField("workspace_id", doc.Ref.ID).Warn("Failed to clear RAG status.")
+		}
+		drained++
+	}
+	return gin.H{"drained": drained, "failed": failed, "throttled": throttled}, nil
+}
+
+// GetRagStatus reports whether a workspace's files are being indexed normally.
+func (ac *ApiController) GetRagStatus(c *gin.Context) {
+	userID := c.GetString("userID")
+	workspaceID := c.Param("workspaceId")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{
+		"workspace_id": workspaceID,
+		"user_id":      userID,
+		"handler":      "GetRagStatus",
+	})
+
+	isMember, err := checkWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Workspace membership check failed for GetRagStatus.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+		return
+	}
+
+	resp := RagStatusResponse{WorkspaceID: workspaceID, State: ragIndexingOK}
+	snap, err := ac.FirestoreClient.Collection(ragStatusCollection).Doc(workspaceID).Get(ctx)
+	if err != nil && !isNotFound(err) {
+		logCtx.WithError(err).Error("Failed to load RAG status.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load RAG status"})
+		return
+	}
+	if err == nil {
+		var st RagIndexStatus
+		if err := snap.DataTo(&st); err != nil {
+			logCtx.WithError(err).Error("Failed to parse RAG status.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load RAG status"})
+			return
+		}
+		resp.State = st.State
+		resp.PendingFileCount = len(st.PendingFiles)
+		resp.LastError = st.LastError
+		resp.UpdatedAt = st.UpdatedAt
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// rateLimitsCollection holds the Firestore limiter's window shards. Cleanup queries it by
+// key and window_start, which needs a composite index on those two fields.
+const rateLimitsCollection = "rate_limits"
+
+// minRequestsPerRateLimitShard keeps sharding coarse enough that rounding each shard's
+// share up overshoots the configured limit by less than 10%.
+const minRequestsPerRateLimitShard = 10
+
+// rateLimitCleanupChance is the probability that an allowed request also deletes its
+// key's expired windows.
+const rateLimitCleanupChance = 0.02
+
+// RateLimitDecision is the outcome of one limiter check.
+type RateLimitDecision struct {
+	Allowed   bool
+	Remaining int // Best effort for distributed limiters
+	ResetAt   time.Time
+}
+
+// RateLimiter counts requests per key in fixed windows.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitDecision, error)
+}
+
+// newRateLimiter returns the limiter selected by RATE_LIMITER.
+func newRateLimiter(cfg *AppConfig, fs *docstore.Client) RateLimiter {
+	if cfg.RateLimiter == "firestore" {
+		return newFirestoreRateLimiter(fs, cfg.RateLimitShards)
+	}
+	return newMemoryRateLimiter()
+}
+
+// RateLimit allows limit requests per window for each caller of a route group. Callers are
+// keyed by user ID when authenticated and by client IP otherwise. A limit of 0 disables the
+// check, and limiter errors let the request through rather than failing it.
+func RateLimit(limiter RateLimiter, name string, limit int, window time.Duration) gin.HandlerFunc {
+	return rateLimitBy(limiter, name, limit, window, func(c *gin.Context) string {
+		if userID := c.GetString("userID"); userID != "" {
+			return "user:" + userID
+		}
+		return "ip:" + RealClientIP(c)
+	})
+}
+
+// RateLimitByParam allows limit requests per window for each value of a route parameter,
+// whoever sends them, so a capability such as a share token cannot be farmed from many IPs.
+func RateLimitByParam(limiter RateLimiter, name, param string, limit int, window time.Duration) gin.HandlerFunc {
+	return rateLimitBy(limiter, name, limit, window, func(c *gin.Context) string {
+		return param + ":" + c.Param(param)
+	})
+}
+
+// rateLimitBy applies a limit to requests grouped by the key callerKey returns.
+func rateLimitBy(limiter RateLimiter, name string, limit int, window time.Duration, callerKey func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+		key := name + ":" + callerKey(c)
+
+		decision, err := limiter.Allow(c.Request.Context(), key, limit, window)
+		if err != nil {
+			log.WithError(err).WithField("limit", name).Warn("Rate limiter unavailable; allowing request.")
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(decision.ResetAt.Unix(), 10))
+		if !decision.Allowed {
+			retryAfter := int(time.Until(decision.ResetAt).Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded; try again later"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// memoryRateLimiter keeps per-instance fixed windows. It is exact but each Cloud Run
+// instance enforces the limit separately.
+type memoryRateLimiter struct {
+	mu        sync.Mutex
+	windows   map[string]*memoryRateWindow
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryRateWindow struct {
+	start time.Time
+	end   time.Time
+	count int
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{windows: make(map[string]*memoryRateWindow), now: time.Now}
+}
+
+func (l *memoryRateLimiter) Allow(_ context.Context, key string, limit int, window time.Duration) (RateLimitDecision, error) {
+	now := l.now()
+	start := now.Truncate(window)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	w, ok := l.windows[key]
+	if !ok || !w.start.Equal(start) {
+		w = &memoryRateWindow{start: start, end: start.Add(window)}
+		l.windows[key] = w
+	}
+	if w.count >= limit {
+		return RateLimitDecision{Allowed: false, ResetAt: w.end}, nil
+	}
+	w.count++
+	return RateLimitDecision{Allowed: true, Remaining: limit - w.count, ResetAt: w.end}, nil
+}
+
+// sweep drops finished windows at most once a minute. Callers hold l.mu.
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, w := range l.windows {
+		if !now.Before(w.end) {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// firestoreRateLimiter shares fixed windows across instances. Each key's window is split
+// into shard documents with an equal share of the limit; a request increments one shard
+// transactionally, starting at a random one and moving on when it is full. This keeps
+// write contention per document low at the cost of overshooting by up to shards-1.
+type firestoreRateLimiter struct {
+	client *docstore.Client
+	shards int
+}
+
+// rateLimitShard is one shard document of a key's window.
+type rateLimitShard struct {
+	Key         string    `firestore:"key"`
+	WindowStart time.Time `firestore:"window_start"`
+	Count       int       `firestore:"count"`
+	DeleteAfter time.Time `firestore:"delete_after"`
+}
+
+func newFirestoreRateLimiter(fs *docstore.Client, shards int) *firestoreRateLimiter {
+	return &firestoreRateLimiter{client: fs, shards: shards}
+}
+
+// shardsFor caps the shard count so small limits stay exact.
+func (l *firestoreRateLimiter) shardsFor(limit int) int {
+	return max(1, min(l.shards, limit/minRequestsPerRateLimitShard))
+}
+
+func (l *firestoreRateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitDecision, error) {
+	now := time.Now().UTC()
+	start := now.Truncate(window)
+	decision := RateLimitDecision{ResetAt: start.Add(window)}
+
+	shards := l.shardsFor(limit)
+	perShard := (limit + shards - 1) / shards
+	keyHash := rateLimitKeyHash(key)
+	first := rand.IntN(shards)
+
+	for i := 0; i < shards; i++ {
+		shard := (first + i) % shards
+		ref := l.client.Collection(rateLimitsCollection).Doc(fmt.Sprintf("%s_%d_%d", keyHash, start.Unix(), shard))
+
+		var count int
+		var incremented bool
+		err := l.client.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+			count, incremented = 0, false
+			snap, err := tx.Get(ref)
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			if err == nil {
+				var existing rateLimitShard
+				if err := snap.DataTo(&existing); err != nil {
+					return err
+				}
+				count = existing.Count
+			}
+			if count >= perShard {
+				return nil
+			}
+			count++
+			incremented = true
+			return tx.Set(ref, rateLimitShard{
+				Key:         keyHash,
+				WindowStart: start,
+				Count:       count,
+				DeleteAfter: decision.ResetAt.Add(window),
+			})
+		}, docstore.MaxAttempts(10))
+		if err != nil {
+			return RateLimitDecision{}, fmt.Errorf("rate limit transaction failed: %w", err)
+		}
+		if incremented {
+			decision.Allowed = true
+			decision.Remaining = max(0, min(limit, (perShard-count)*shards))
+			if rand.Float64() < rateLimitCleanupChance {
+				l.cleanupExpiredWindows(ctx, keyHash, start)
+			}
+			return decision, nil
+		}
+	}
+	return decision, nil
+}
+
+// cleanupExpiredWindows deletes a key's shards from earlier windows in the background.
+// Firestore TTL on delete_after catches anything this misses.
+func (l *firestoreRateLimiter) cleanupExpiredWindows(ctx context.Context, keyHash string, current time.Time) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+
+		snaps, err := l.client.Collection(rateLimitsCollection).
+			Where("key", "==", keyHash).
+			Where("window_start", "<", current).
+			Limit(20).Documents(ctx).GetAll()
+		if err != nil {
+			log.WithError(err).Debug("Rate limit window cleanup query failed.")
+			return
+		}
+		for _, snap := range snaps {
+			if _, err := snap.Ref.Delete(ctx); err != nil {
+				log.WithError(err).Debug("Failed to delete expired rate limit window.")
+			}
+		}
+	}()
+}
+
+// rateLimitKeyHash turns a limiter key (which may contain IPs or user IDs) into a fixed-size
+// document ID prefix.
+func rateLimitKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// hammer calls Allow for the same key from parallel goroutines and returns how many were allowed.
+func hammer(t *testing.T, limiter RateLimiter, key string, limit, workers, callsPerWorker int) int64 {
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < callsPerWorker; j++ {
+				decision, err := limiter.Allow(context.Background(), key, limit, time.Hour)
+				assert.NoError(t, err)
+				if decision.Allowed {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return allowed.Load()
+}
+
+func TestMemoryRateLimiter_ExactUnderConcurrency(t *testing.T) {
+	limiter := newMemoryRateLimiter()
+	assert.Equal(t, int64(100), hammer(t, limiter, "execute:ip:203.0.113.7", 100, 50, 20))
+}
+
+func TestMemoryRateLimiter_NewWindowResetsCount(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 30, 0, time.UTC)
+	limiter := newMemoryRateLimiter()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		decision, _ := limiter.Allow(context.Background(), "k", 2, time.Minute)
+		assert.True(t, decision.Allowed)
+	}
+	decision, _ := limiter.Allow(context.Background(), "k", 2, time.Minute)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, time.Date(2026, 1, 1, 12, 1, 0, 0, time.UTC), decision.ResetAt)
+
+	now = now.Add(time.Minute)
+	decision, _ = limiter.Allow(context.Background(), "k", 2, time.Minute)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 1, decision.Remaining)
+	assert.Len(t, limiter.windows, 1)
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/execute", RateLimit(newMemoryRateLimiter(), "execute", 2, time.Minute), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	codes := make([]int, 3)
+	var last *httptest.ResponseRecorder
+	for i := range codes {
+		last = httptest.NewRecorder()
+		r.ServeHTTP(last, httptest.NewRequest(http.MethodPost, "/execute", nil))
+		codes[i] = last.Code
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+	assert.Equal(t, "2", last.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", last.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, last.Header().Get("Retry-After"))
+}
+
+func TestRateLimitByParam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/shared/:token", RateLimitByParam(newMemoryRateLimiter(), "shared", "token", 2, time.Minute), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	get := func(token, ip string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/shared/"+token, nil)
+		req.RemoteAddr = ip + ":1234"
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Spreading requests over many clients does not get around a token's limit.
+	assert.Equal(t, http.StatusOK, get("a", "203.0.113.1"))
+	assert.Equal(t, http.StatusOK, get("a", "203.0.113.2"))
+	assert.Equal(t, http.StatusTooManyRequests, get("a", "203.0.113.3"))
+	assert.Equal(t, http.StatusOK, get("b", "203.0.113.3"), "per token")
+}
+
+func TestFirestoreRateLimiter_ShardsFor(t *testing.T) {
+	limiter := newFirestoreRateLimiter(nil, 4)
+	assert.Equal(t, 1, limiter.shardsFor(5))
+	assert.Equal(t, 1, limiter.shardsFor(19))
+	assert.Equal(t, 3, limiter.shardsFor(30))
+	assert.Equal(t, 4, limiter.shardsFor(1000))
+}
+
+// TestFirestoreRateLimiter_WithinTenPercent runs against the Firestore emulator when
+// FIRESTORE_EMULATOR_HOST is set, and the in-memory document store otherwise.
+func TestFirestoreRateLimiter_WithinTenPercent(t *testing.T) {
+	client, _ := newTestDocStore(t, "demo-rate-limit")
+
+	const limit = 100
+	key := "execute:test:" + time.Now().Format(time.RFC3339Nano)
+	allowed := hammer(t, newFirestoreRateLimiter(client, 4), key, limit, 8, 25)
+	assert.GreaterOrEqual(t, allowed, int64(limit))
+	assert.LessOrEqual(t, allowed, int64(limit*11/10))
+}
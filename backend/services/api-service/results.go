@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// resultTokenBytes is the entropy of a shared result token (43 URL-safe characters).
+const resultTokenBytes = 32
+
+// maxResultCacheAge caps how long shared caches may keep a finished result.
+const maxResultCacheAge = time.Hour
+
+// newResultToken returns an unguessable token for a public job's shareable result link.
+func newResultToken() (string, error) {
+	b := make([]byte, resultTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate result token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// isWellFormedResultToken rejects tokens that newResultToken could not have produced,
+// so garbage never costs a Firestore query.
+func isWellFormedResultToken(token string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(decoded) == resultTokenBytes
+}
+
+// resultCacheControl lets finished results be cached until shortly before they expire;
+// results that can still change are never cached.
+func resultCacheControl(job Job) string {
+	if !isTerminalJobStatus(job.Status) {
+		return "no-store"
+	}
+	maxAge := maxResultCacheAge
+	if !job.DeleteAfter.IsZero() {
+		if untilExpiry := time.Until(job.DeleteAfter); untilExpiry < maxAge {
+			maxAge = untilExpiry
+		}
+	}
+	if maxAge < time.Second {
+		return "no-store"
+	}
+	return fmt.Sprintf("public, max-age=%d, immutable", int(maxAge.Seconds()))
+}
+
+// GetSharedResult serves a public execution's result by its share token. No auth is
+// required; the token is the capability, and neither the job ID nor user fields are returned.
+func (ac *ApiController) GetSharedResult(c *gin.Context) {
+	token := c.Param("resultToken")
+	ctx := c.Request.Context()
+
+	if !isWellFormedResultToken(token) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Result not found"})
+		return
+	}
+
+	jobID, job, err := ac.Jobs.FindByResultToken(ctx, token)
+	if err != nil && !errors.Is(err, errJobNotFound) {
+		log.WithError(err).Error("Failed to look up shared result.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load result"})
+		return
+	}
+	if err != nil || jobReadPolicies[job.ExecutionType] != jobReadPublic || job.UserID != "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Result not found"})
+		return
+	}
+	if isPastDeleteAfter(job.DeleteAfter) {
+		c.Header("Cache-Control", "public, max-age=3600")
+		c.JSON(http.StatusGone, gin.H{"error": "This result has expired"})
+		return
+	}
+
+	job, outputURL := ac.jobOutputForRead(ctx, jobID, job, log.WithField("job_id", jobID))
+	if outputURL != "" {
+		// The output URL is signed for this response and expires long before the result.
+		c.Header("Cache-Control", "no-store")
+	} else {
+		c.Header("Cache-Control", resultCacheControl(job))
+	}
+	c.JSON(http.StatusOK, publicResult(job, outputURL))
+}
+
+// publicResult is the part of a public job its result links reveal, with the download URL
+// of its full output when that was offloaded.
+func publicResult(job Job, outputURL string) PublicResultResponse {
+	return PublicResultResponse{
+		Status:          job.Status,
+		Language:        job.Language,
+		Output:          job.Output,
+		OutputTruncated: job.OutputTruncated,
+		OutputURL:       outputURL,
+		Error:           job.Error,
+		FailureType:     job.FailureType,
+		SubmittedAt:     job.SubmittedAt,
+		StartedAt:       job.StartedAt,
+		CompletedAt:     job.CompletedAt,
+		DurationMs:      job.DurationMs,
+		ExpiresAt:       job.ExpiresAt,
+	}
+}
+
+// resultClaimTokenHeader carries the result token for GET /execute/result/:jobId when it is
+// not given as the token query parameter.
+const resultClaimTokenHeader = "X-Result-Token"
+
+// resultClaimToken returns the result token given as the token query parameter or, failing
+// that, the X-Result-Token header.
+func resultClaimToken(c *gin.Context) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	return c.GetHeader(resultClaimTokenHeader)
+}
+
+// claimsResult reports whether token is the result token of a public job. The comparison
+// takes the same time however much of the token matches.
+func claimsResult(job Job, token string) bool {
+	if job.ResultToken == "" || jobReadPolicies[job.ExecutionType] != jobReadPublic || job.UserID != "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(job.ResultToken), []byte(token)) == 1
+}
+
+// GetClaimedResult serves a public execution's result to the caller that submitted it: the
+// result_token returned by POST /execute must accompany the job ID, as the token query
+// parameter or the X-Result-Token header. A missing or wrong token gets the same 404 as an
+// unknown job so job IDs cannot be probed.
+func (ac *ApiController) GetClaimedResult(c *gin.Context) {
+	jobID := c.Param("jobId")
+	ctx := c.Request.Context()
+
+	token := resultClaimToken(c)
+	if !isWellFormedResultToken(token) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Result not found"})
+		return
+	}
+
+	job, err := ac.Jobs.Get(ctx, jobID)
+	if err != nil && !errors.Is(err, errJobNotFound) {
+		log.WithError(err).WithField("job_id", jobID).Error("Failed to load claimed result.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load result"})
+		return
+	}
+	if err != nil || !claimsResult(job, token) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Result not found"})
+		return
+	}
+	if isPastDeleteAfter(job.DeleteAfter) {
+		c.Header("Cache-Control", "private, max-age=3600")
+		c.JSON(http.StatusGone, gin.H{"error": "This result has expired"})
+		return
+	}
+
+	// The token travels in the URL or a header, so shared caches must not keep the result.
+	job, outputURL := ac.jobOutputForRead(ctx, jobID, job, log.WithField("job_id", jobID))
+	c.Header("Cache-Control", "private, no-store")
+	c.JSON(http.StatusOK, publicResult(job, outputURL))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewResultToken(t *testing.T) {
+	a, err := newResultToken()
+	assert.NoError(t, err)
+	b, err := newResultToken()
+	assert.NoError(t, err)
+
+	assert.Len(t, a, 43)
+	assert.NotEqual(t, a, b)
+	assert.True(t, isWellFormedResultToken(a))
+	assert.False(t, isWellFormedResultToken(a[:42]))
+	assert.False(t, isWellFormedResultToken(strings.Repeat("!", 43)))
+	assert.False(t, isWellFormedResultToken("0b9f6a4e-6c1d-4d7e-9d0b-2f6f1a8e3c55"))
+}
+
+func TestResultCacheControl(t *testing.T) {
+	assert.Equal(t, "no-store", resultCacheControl(Job{Status: "running"}))
+	assert.Equal(t, "public, max-age=3600, immutable", resultCacheControl(Job{Status: "completed"}))
+
+	soon := Job{Status: "failed", DeleteAfter: time.Now().Add(10*time.Minute + 30500*time.Millisecond)}
+	assert.Equal(t, "public, max-age=630, immutable", resultCacheControl(soon))
+
+	expiring := Job{Status: "completed", DeleteAfter: time.Now().Add(500 * time.Millisecond)}
+	assert.Equal(t, "no-store", resultCacheControl(expiring))
+}
+
+func TestClaimsResult(t *testing.T) {
+	token, err := newResultToken()
+	assert.NoError(t, err)
+	job := Job{Status: "completed", ResultToken: token}
+
+	assert.True(t, claimsResult(job, token))
+	assert.False(t, claimsResult(job, token[:42]+"A"))
+	assert.False(t, claimsResult(job, ""))
+	assert.False(t, claimsResult(Job{Status: "completed"}, ""), "jobs without a token cannot be claimed")
+
+	// Workspace and authenticated jobs are never readable by token.
+	assert.False(t, claimsResult(Job{ResultToken: token, ExecutionType: executionTypeWorkspace}, token))
+	assert.False(t, claimsResult(Job{ResultToken: token, UserID: "user-1"}, token))
+}
+
+func TestGetClaimedResult(t *testing.T) {
+	r, ac, _ := newEmbeddedHarness(t)
+
+	var submitted map[string]string
+	w := serveJSON(r, http.MethodPost, "/api/execute", RequestBody{Code: "print(1)", Language: "python"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	jobID, token := submitted["job_id"], submitted["result_token"]
+	resultPath := "/api/execute/result/" + jobID
+
+	var result PublicResultResponse
+	w = serveJSON(r, http.MethodGet, resultPath+"?token="+token, nil, &result)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "queued", result.Status)
+	assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+
+	// The header works as well as the query parameter.
+	req, _ := http.NewRequest(http.MethodGet, resultPath, nil)
+	req.Header.Set(resultClaimTokenHeader, token)
+	hw := serveRequest(r, req)
+	assert.Equal(t, http.StatusOK, hw.Code, hw.Body.String())
+
+	// Missing and wrong tokens look exactly like an unknown job.
+	other, _ := newResultToken()
+	for _, path := range []string{resultPath, resultPath + "?token=" + other, "/api/execute/result/unknown?token=" + token} {
+		w = serveJSON(r, http.MethodGet, path, nil, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+		assert.JSONEq(t, `{"error":"Result not found"}`, w.Body.String())
+	}
+
+	_, err := ac.Jobs.Update(context.Background(), jobID, func(job *Job) (bool, error) {
+		job.DeleteAfter = time.Now().Add(-time.Minute)
+		return true, nil
+	})
+	assert.NoError(t, err)
+	w = serveJSON(r, http.MethodGet, resultPath+"?token="+token, nil, nil)
+	assert.Equal(t, http.StatusGone, w.Code)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// deleteAfterField is the Firestore field that TTL policies are configured on.
+// It must hold a Timestamp (time.Time), not an ISO 8601 string, for TTL to apply.
+const deleteAfterField = "delete_after"
+
+// errDocumentExpired is returned by getLiveDocument for documents past their delete_after.
+var errDocumentExpired = errors.New("document expired")
+
+// RetentionConfig holds how long each ephemeral collection keeps its documents
+// before Firestore TTL is allowed to reap them.
+type RetentionConfig struct {
+	Jobs             time.Duration
+	IdempotencyKeys  time.Duration
+	UsageCounters    time.Duration
+	WorkspaceChanges time.Duration
+}
+
+// retentionSetting binds an hours-valued environment variable to a RetentionConfig field.
+type retentionSetting struct {
+	env          string
+	defaultHours int
+	dst          *time.Duration
+}
+
+// loadRetentionConfig reads per-collection retention windows (in hours) from the environment.
+func loadRetentionConfig() (RetentionConfig, error) {
+	var rc RetentionConfig
+	settings := []retentionSetting{
+		{"RETENTION_JOBS_HOURS", 15 * 24, &rc.Jobs},
+		{"RETENTION_IDEMPOTENCY_KEYS_HOURS", 24, &rc.IdempotencyKeys},
+		{"RETENTION_USAGE_COUNTERS_HOURS", 400 * 24, &rc.UsageCounters},
+		{"RETENTION_WORKSPACE_CHANGES_HOURS", 7 * 24, &rc.WorkspaceChanges},
+	}
+
+	for _, s := range settings {
+		hours, err := getEnvInt(s.env, s.defaultHours)
+		if err != nil {
+			return RetentionConfig{}, err
+		}
+		if hours < 1 {
+			return RetentionConfig{}, fmt.Errorf("%s must be positive", s.env)
+		}
+		*s.dst = time.Duration(hours) * time.Hour
+	}
+	return rc, nil
+}
+
+// deleteAfter returns the delete_after timestamp for a document created now with the given retention.
+func deleteAfter(retention time.Duration) time.Time {
+	return time.Now().UTC().Add(retention).Truncate(time.Millisecond)
+}
+
+// isPastDeleteAfter reports whether a delete_after marker has elapsed. A zero value means
+// the document predates the marker and is treated as live.
+func isPastDeleteAfter(t time.Time) bool {
+	return !t.IsZero() && time.Now().UTC().After(t)
+}
+
+// snapshotPastDeleteAfter reports whether a snapshot carries an elapsed delete_after marker.
+func snapshotPastDeleteAfter(snap *docstore.DocumentSnapshot) bool {
+	if snap == nil || !snap.Exists() {
+		return false
+	}
+	v, err := snap.DataAt(deleteAfterField)
+	if err != nil {
+		return false // Field absent
+	}
+	t, ok := v.(time.Time)
+	return ok && isPastDeleteAfter(t)
+}
+
+// getLiveDocument fetches a document and treats one past its delete_after as absent,
+// so readers behave the same before and after the TTL policy actually removes it.
+// Absent and expired documents both yield an error for which isNotFound is true.
+func getLiveDocument(ctx context.Context, ref *docstore.DocumentRef) (*docstore.DocumentSnapshot, error) {
+	snap, err := ref.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if snapshotPastDeleteAfter(snap) {
+		return nil, errDocumentExpired
+	}
+	return snap, nil
+}
+
+// isNotFound reports whether err means the document does not exist or has expired.
+func isNotFound(err error) bool {
+	return errors.Is(err, errDocumentExpired) || status.Code(err) == codes.NotFound
+}
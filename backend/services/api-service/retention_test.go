@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPastDeleteAfter(t *testing.T) {
+	assert.False(t, isPastDeleteAfter(time.Time{}), "documents without a marker are live")
+	assert.True(t, isPastDeleteAfter(time.Now().Add(-time.Second)))
+	assert.False(t, isPastDeleteAfter(time.Now().Add(time.Hour)))
+}
+
+func TestDeleteAfter_UsesRetentionWindow(t *testing.T) {
+	before := time.Now().UTC()
+	got := deleteAfter(2 * time.Hour)
+
+	assert.Equal(t, time.UTC, got.Location())
+	assert.WithinDuration(t, before.Add(2*time.Hour), got, time.Second)
+	assert.False(t, isPastDeleteAfter(got))
+}
+
+func TestLoadRetentionConfig(t *testing.T) {
+	t.Setenv("RETENTION_IDEMPOTENCY_KEYS_HOURS", "6")
+
+	rc, err := loadRetentionConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 6*time.Hour, rc.IdempotencyKeys)
+	assert.Equal(t, 15*24*time.Hour, rc.Jobs, "unset values fall back to defaults")
+
+	t.Setenv("RETENTION_JOBS_HOURS", "0")
+	_, err = loadRetentionConfig()
+	assert.Error(t, err)
+
+	t.Setenv("RETENTION_JOBS_HOURS", "forever")
+	_, err = loadRetentionConfig()
+	assert.Error(t, err)
+}
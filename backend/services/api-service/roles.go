@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Workspace membership roles, from least to most privileged. Viewers read, editors also
+// change files and run code, owners also manage the workspace itself.
+const (
+	roleViewer = "viewer"
+	roleEditor = "editor"
+	roleOwner  = "owner"
+)
+
+// roleRanks orders the roles; unknown roles rank below viewer and satisfy nothing.
+var roleRanks = map[string]int{roleViewer: 1, roleEditor: 2, roleOwner: 3}
+
+// roleSatisfies reports whether a member with role may do what required allows.
+func roleSatisfies(role, required string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[required]
+}
+
+// errNotWorkspaceMember is returned by checkWorkspaceRole when the caller has no current
+// membership of the workspace.
+var errNotWorkspaceMember = errors.New("user does not have access to this workspace")
+
+// insufficientRoleError is returned by checkWorkspaceRole when the caller is a member whose
+// Role is below Required.
+type insufficientRoleError struct {
+	Role     string
+	Required string
+}
+
+func (e *insufficientRoleError) Error() string {
+	return fmt.Sprintf("role %q does not satisfy %q", e.Role, e.Required)
+}
+
+// checkWorkspaceRole returns the caller's membership when their role is at least required,
+// reading it with a single query. Otherwise it returns errNotWorkspaceMember for
+// non-members, including those whose membership has expired, an *insufficientRoleError for
+// members with too low a role, or the error of the failed read.
+func (ac *ApiController) checkWorkspaceRole(ctx context.Context, userID, workspaceID, required string) (*WorkspaceMembership, error) {
+	membership, err := getWorkspaceMembership(ctx, ac.FirestoreClient, userID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if membership == nil {
+		return nil, errNotWorkspaceMember
+	}
+	if !roleSatisfies(membership.Role, required) {
+		return membership, &insufficientRoleError{Role: membership.Role, Required: required}
+	}
+	return membership, nil
+}
+
+// insufficientRole answers a member whose role is below required with a 403 the client can
+// tell apart from a non-member's: {"error", "code": "insufficient_role", "requiredRole", "role"}.
+func insufficientRole(c *gin.Context, message, required, role string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":        message,
+		"code":         "insufficient_role",
+		"requiredRole": required,
+		"role":         role,
+	})
+}
+
+// requireWorkspaceRole runs checkWorkspaceRole and writes a 403/500 unless the caller's role
+// is at least required. It returns nil when the request has already been answered.
+func (ac *ApiController) requireWorkspaceRole(c *gin.Context, workspaceID, userID, required string, logCtx *log.Entry) *WorkspaceMembership {
+	membership, err := ac.checkWorkspaceRole(c.Request.Context(), userID, workspaceID, required)
+	var roleErr *insufficientRoleError
+	switch {
+	case err == nil:
+		return membership
+	case errors.Is(err, errNotWorkspaceMember):
+		c.JSON(http.StatusForbidden, gin.H{"error": "User does not have access to this workspace"})
+	case errors.As(err, &roleErr):
+		logCtx.WithFields(log.Fields{"role": roleErr.Role, "required_role": required}).Warn("Member's role is too low for this action.")
+		message := "Only workspace editors and owners can perform this action"
+		if required == roleOwner {
+			message = "Only workspace owners can perform this action"
+		}
+		insufficientRole(c, message, required, roleErr.Role)
+	default:
+		logCtx.WithError(err).Error("Workspace membership check failed.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify workspace membership"})
+	}
+	return nil
+}
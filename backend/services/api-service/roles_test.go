@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleSatisfies(t *testing.T) {
+	tests := []struct {
+		role, required string
+		want           bool
+	}{
+		{roleViewer, roleViewer, true},
+		{roleViewer, roleEditor, false},
+		{roleViewer, roleOwner, false},
+		{roleEditor, roleViewer, true},
+		{roleEditor, roleEditor, true},
+		{roleEditor, roleOwner, false},
+		{roleOwner, roleViewer, true},
+		{roleOwner, roleOwner, true},
+		{"", roleViewer, false},
+		{"admin", roleViewer, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, roleSatisfies(tt.role, tt.required), "%q needs %q", tt.role, tt.required)
+	}
+}
+
+func TestCheckWorkspaceRole(t *testing.T) {
+	h := newHandlerHarness(t)
+	ctx := context.Background()
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	users := map[string]string{roleOwner: owner}
+	for _, role := range []string{roleEditor, roleViewer} {
+		users[role] = role + "-" + uuid.New().String()
+		h.seedMembership(workspaceID, users[role], role, "")
+	}
+
+	roles := []string{roleViewer, roleEditor, roleOwner}
+	for _, role := range roles {
+		for _, required := range roles {
+			membership, err := h.ac.checkWorkspaceRole(ctx, users[role], workspaceID, required)
+			if roleSatisfies(role, required) {
+				assert.NoError(t, err, "%s needs %s", role, required)
+				if assert.NotNil(t, membership) {
+					assert.Equal(t, role, membership.Role)
+				}
+				continue
+			}
+			var roleErr *insufficientRoleError
+			if assert.ErrorAs(t, err, &roleErr, "%s needs %s", role, required) {
+				assert.Equal(t, insufficientRoleError{Role: role, Required: required}, *roleErr)
+			}
+			assert.NotErrorIs(t, err, errNotWorkspaceMember)
+		}
+	}
+
+	lapsed := "lapsed-" + uuid.New().String()
+	h.seedMembership(workspaceID, lapsed, roleOwner, TimeToISO8601(time.Now().Add(-time.Hour)))
+	for _, userID := range []string{"stranger-" + uuid.New().String(), lapsed} {
+		membership, err := h.ac.checkWorkspaceRole(ctx, userID, workspaceID, roleViewer)
+		assert.ErrorIs(t, err, errNotWorkspaceMember, userID)
+		assert.Nil(t, membership)
+	}
+}
+
+func TestHandlers_RolePermissionMatrix(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	users := map[string]string{roleOwner: owner}
+	for _, role := range []string{roleEditor, roleViewer} {
+		users[role] = role + "-" + uuid.New().String()
+		h.seedMembership(workspaceID, users[role], role, "")
+	}
+	ws := "/api/workspaces/" + workspaceID
+
+	// required is the lowest role the route admits. Allowed callers send no body, so they
+	// may still be turned away, just never with a 403.
+	tests := []struct {
+		method, path, required string
+	}{
+		{http.MethodGet, ws + "/manifest", roleViewer},
+		{http.MethodGet, ws + "/members", roleViewer},
+		{http.MethodGet, ws + "/jobs", roleViewer},
+		{http.MethodPost, ws + "/sync", roleEditor},
+		{http.MethodPost, ws + "/sync/confirm", roleEditor},
+		{http.MethodPost, ws + "/execute", roleEditor},
+		{http.MethodPost, ws + "/folders", roleEditor},
+		{http.MethodPost, ws + "/jobs/" + uuid.New().String() + "/retry", roleEditor},
+		{http.MethodGet, ws + "/invitations", roleOwner},
+		{http.MethodGet, ws + "/usage/export?from=2025-01-01", roleOwner},
+		{http.MethodPut, ws + "/read-only", roleOwner},
+		{http.MethodPut, ws + "/settings/destructive-sync", roleOwner},
+		{http.MethodPut, ws + "/settings/concurrency", roleOwner},
+		{http.MethodPost, ws + "/share-links", roleOwner},
+		{http.MethodDelete, ws, roleOwner},
+	}
+	for _, tt := range tests {
+		for _, role := range []string{roleViewer, roleEditor, roleOwner} {
+			t.Run(fmt.Sprintf("%s %s as %s", tt.method, tt.path, role), func(t *testing.T) {
+				allowed := roleSatisfies(role, tt.required)
+				if allowed && tt.method == http.MethodDelete {
+					t.Skip("would delete the shared workspace")
+				}
+				w := h.do(tt.method, tt.path, users[role], nil, nil)
+				if allowed {
+					assert.NotEqual(t, http.StatusForbidden, w.Code, w.Body.String())
+					return
+				}
+				assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+				var body map[string]interface{}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, "insufficient_role", body["code"])
+				assert.Equal(t, tt.required, body["requiredRole"])
+				assert.Equal(t, role, body["role"])
+			})
+		}
+	}
+
+	w := h.do(http.MethodGet, "/api/workspaces", users[roleViewer], nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code, "viewers still list their workspaces")
+	w = h.do(http.MethodPost, ws+"/sync", "stranger-"+uuid.New().String(), nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NotContains(t, w.Body.String(), "insufficient_role", "non-members are not told about roles")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// securityEventsCollection holds one document per request rejected as a likely attack or
+// client bug with security impact, and per destructive sync, rejected or confirmed.
+const securityEventsCollection = "security_events"
+
+// Security event types recorded in security_events.
+const (
+	securityInvalidObjectKey = "invalid_object_key"
+	securityDestructiveSync  = "destructive_sync"
+)
+
+// recordSecurityEvent logs a security event and stores it in security_events. Like the audit
+// log it runs after the request has been decided, so a failed write only loses the record.
+func (ac *ApiController) recordSecurityEvent(ctx context.Context, eventType, userID, workspaceID string, details map[string]interface{}) {
+	event := SecurityEvent{
+		EventID:     uuid.New().String(),
+		Type:        eventType,
+		UserID:      userID,
+		WorkspaceID: workspaceID,
+		Details:     details,
+		CreatedAt:   NowISO8601(),
+	}
+	logCtx := log.WithFields(log.Fields{
+		"security_event": eventType,
+		"user_id":        userID,
+		"workspace_id":   workspaceID,
+	})
+	logCtx.WithField("details", details).Warn("Security event.")
+	if _, err := ac.FirestoreClient.Collection(securityEventsCollection).Doc(event.EventID).Set(ctx, event); err != nil {
+		logCtx.WithError(err).Error("Failed to write security event")
+	}
+}
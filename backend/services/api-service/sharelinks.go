@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// workspaceShareLinksCollection holds workspace share links. Public reads look links up by
+// token_hash; delete_after can be used as a Firestore TTL field.
+const workspaceShareLinksCollection = "workspace_share_links"
+
+// newWorkspaceShareToken returns an unguessable token for a share link.
+func newWorkspaceShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate share token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashWorkspaceShareToken returns the form of a token that is stored and looked up.
+func hashWorkspaceShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// shareLinkActive reports whether a link may still be used at now.
+func shareLinkActive(link WorkspaceShareLink, now time.Time) bool {
+	if link.RevokedAt != "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, link.ExpiresAt)
+	return err == nil && now.Before(expiresAt)
+}
+
+// CreateWorkspaceShareLink creates a link that lets anyone read the workspace's files
+// without signing in (owners only).
+func (ac *ApiController) CreateWorkspaceShareLink(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "CreateWorkspaceShareLink"})
+
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+	var req WorkspaceShareLinkRequest
+	if c.Request.ContentLength > 0 {
+		if err := ac.bindJSON(c, &req); err != nil {
+			return
+		}
+	}
+	ttl := ac.AppConfig.WorkspaceShareLinkTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if ttl > ac.AppConfig.WorkspaceShareLinkTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresInHours must not exceed " + strconv.Itoa(int(ac.AppConfig.WorkspaceShareLinkTTL.Hours()))})
+		return
+	}
+
+	token, err := newWorkspaceShareToken()
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to generate share token.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+	now := time.Now()
+	link := WorkspaceShareLink{
+		LinkID:      uuid.New().String(),
+		WorkspaceID: workspaceID,
+		TokenHash:   hashWorkspaceShareToken(token),
+		CreatedBy:   userID,
+		CreatedAt:   TimeToISO8601(now),
+		ExpiresAt:   TimeToISO8601(now.Add(ttl)),
+		DeleteAfter: now.Add(ttl),
+	}
+	if _, err := ac.FirestoreClient.Collection(workspaceShareLinksCollection).Doc(link.LinkID).Create(ctx, link); err != nil {
+		logCtx.WithError(err).Error("Failed to store share link.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+
+	ac.recordActivity(ctx, workspaceID, userID, activityShareLinkCreated, map[string]interface{}{
+		"link_id":    link.LinkID,
+		"expires_at": link.ExpiresAt,
+	})
+	logCtx.WithField("link_id", link.LinkID).Info("Workspace share link created.")
+	c.JSON(http.StatusCreated, WorkspaceShareLinkResponse{
+		LinkID:    link.LinkID,
+		Token:     token,
+		Path:      "/api/shared/" + token + "/manifest",
+		ExpiresAt: link.ExpiresAt,
+	})
+}
+
+// RevokeWorkspaceShareLink withdraws a share link (owners only). Revoking twice succeeds
+// without changes.
+func (ac *ApiController) RevokeWorkspaceShareLink(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	linkID := c.Param("linkId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "link_id": linkID, "handler": "RevokeWorkspaceShareLink"})
+
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+	ref := ac.FirestoreClient.Collection(workspaceShareLinksCollection).Doc(linkID)
+	snap, err := ref.Get(ctx)
+	var link WorkspaceShareLink
+	if err == nil {
+		err = snap.DataTo(&link)
+	}
+	if isNotFound(err) || (err == nil && link.WorkspaceID != workspaceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load share link.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load share link"})
+		return
+	}
+
+	if link.RevokedAt == "" {
+		if _, err := ref.Update(ctx, []docstore.Update{{Path: "revoked_at", Value: NowISO8601()}}); err != nil {
+			logCtx.WithError(err).Error("Failed to revoke share link.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+			return
+		}
+		ac.recordActivity(ctx, workspaceID, userID, activityShareLinkRevoked, map[string]interface{}{"link_id": linkID})
+		logCtx.Info("Workspace share link revoked.")
+	}
+	c.JSON(http.StatusOK, gin.H{"linkId": linkID, "revoked": true})
+}
+
+// GetSharedWorkspaceManifest lists a shared workspace's files, with download URLs, to the
+// holder of a share link. No auth is required; the token is the capability, and it grants
+// nothing else: sync, execution and RAG stay member-only. Members, owners and file IDs are
+// never returned. Unknown, expired and revoked tokens, and deleted workspaces, all get the
+// same 404.
+func (ac *ApiController) GetSharedWorkspaceManifest(c *gin.Context) {
+	ctx := c.Request.Context()
+	notFound := func() { c.JSON(http.StatusNotFound, gin.H{"error": "Shared workspace not found"}) }
+	logCtx := log.WithField("handler", "GetSharedWorkspaceManifest")
+
+	docs, err := ac.FirestoreClient.Collection(workspaceShareLinksCollection).
+		Where("token_hash", "==", hashWorkspaceShareToken(c.Param("token"))).
+		Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to look up share link.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load shared workspace"})
+		return
+	}
+	if len(docs) == 0 {
+		notFound()
+		return
+	}
+	var link WorkspaceShareLink
+	if err := docs[0].DataTo(&link); err != nil || !shareLinkActive(link, time.Now()) {
+		notFound()
+		return
+	}
+	logCtx = logCtx.WithFields(log.Fields{"workspace_id": link.WorkspaceID, "link_id": link.LinkID})
+
+	workspace, err := ac.loadWorkspace(ctx, link.WorkspaceID)
+	if isNotFound(err) || (err == nil && workspace.DeletedAt != "") {
+		notFound()
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load shared workspace.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load shared workspace"})
+		return
+	}
+	files, err := ac.workspaceManifestFiles(ctx, link.WorkspaceID, logCtx)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to list shared workspace files.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file list"})
+		return
+	}
+
+	shared := make([]SharedWorkspaceFile, len(files))
+	for i, f := range files {
+		shared[i] = SharedWorkspaceFile{
+			FilePath:   f.FilePath,
+			Type:       f.Type,
+			Size:       f.Size,
+			UpdatedAt:  f.UpdatedAt,
+			ContentURL: f.ContentURL,
+		}
+	}
+	// Links can be revoked, so no cache may keep the manifest.
+	c.Header("Cache-Control", "private, no-store")
+	c.JSON(http.StatusOK, SharedWorkspaceManifest{
+		Name:             workspace.Name,
+		WorkspaceVersion: workspace.WorkspaceVersion,
+		Manifest:         shared,
+		ExpiresAt:        link.ExpiresAt,
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShareLinkActive(t *testing.T) {
+	now := time.Now()
+	link := WorkspaceShareLink{ExpiresAt: TimeToISO8601(now.Add(time.Hour))}
+	assert.True(t, shareLinkActive(link, now))
+	assert.False(t, shareLinkActive(link, now.Add(time.Hour)), "expired")
+	link.RevokedAt = TimeToISO8601(now)
+	assert.False(t, shareLinkActive(link, now), "revoked")
+	assert.False(t, shareLinkActive(WorkspaceShareLink{ExpiresAt: "soon"}, now), "unparseable expiry")
+}
+
+func TestHandlers_WorkspaceShareLinks(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, editor := "owner-"+uuid.New().String(), "editor-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, editor, roleEditor, "")
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+	linksPath := "/api/workspaces/" + workspaceID + "/share-links"
+
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, linksPath, editor, nil, nil).Code)
+	w := h.do(http.MethodPost, linksPath, owner, WorkspaceShareLinkRequest{ExpiresInHours: 100000}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var link WorkspaceShareLinkResponse
+	w = h.do(http.MethodPost, linksPath, owner, WorkspaceShareLinkRequest{ExpiresInHours: 24}, &link)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.NotEmpty(t, link.Token)
+
+	var manifest SharedWorkspaceManifest
+	w = h.do(http.MethodGet, link.Path, "", nil, &manifest)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+	if assert.Len(t, manifest.Manifest, 1) {
+		assert.Equal(t, "main.py", manifest.Manifest[0].FilePath)
+		assert.NotEmpty(t, manifest.Manifest[0].ContentURL, "downloads are presigned")
+	}
+	for _, leak := range []string{owner, editor, "fileId", "r2ObjectKey", "\"hash\""} {
+		assert.NotContains(t, w.Body.String(), leak)
+	}
+
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodGet, "/api/shared/not-a-token/manifest", "", nil, nil).Code)
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodDelete, linksPath+"/"+link.LinkID, editor, nil, nil).Code)
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodDelete, linksPath+"/missing", owner, nil, nil).Code)
+	for i := 0; i < 2; i++ {
+		w = h.do(http.MethodDelete, linksPath+"/"+link.LinkID, owner, nil, nil)
+		assert.Equal(t, http.StatusOK, w.Code, "revoking is idempotent")
+	}
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodGet, link.Path, "", nil, nil).Code, "revoked")
+}
+
+func TestHandlers_ExpiredWorkspaceShareLink(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+
+	token := "expired-" + uuid.New().String()
+	past := time.Now().Add(-time.Minute)
+	_, err := h.fs.Collection(workspaceShareLinksCollection).Doc(uuid.New().String()).Set(context.Background(), WorkspaceShareLink{
+		WorkspaceID: workspaceID,
+		TokenHash:   hashWorkspaceShareToken(token),
+		ExpiresAt:   TimeToISO8601(past),
+		DeleteAfter: past,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	w := h.do(http.MethodGet, "/api/shared/"+token+"/manifest", "", nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
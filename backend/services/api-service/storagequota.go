@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+// syncQuotaExceeded is the sync status of a plan or commit that would take a workspace
+// past its storage quota.
+const syncQuotaExceeded = "quota_exceeded"
+
+// storageReconcileConcurrency bounds the HeadObject calls ConfirmSync makes at once.
+const storageReconcileConcurrency = 8
+
+const (
+	// maintenanceCursorsCollection holds where maintenance tasks that sweep a whole
+	// collection resume from on their next run.
+	maintenanceCursorsCollection = "maintenance_cursors"
+	storageBackfillBatch         = 50
+)
+
+// storageQuotaError aborts a transaction that would take a workspace past its storage quota.
+type storageQuotaError struct {
+	quota StorageQuota
+}
+
+func (e *storageQuotaError) Error() string {
+	return fmt.Sprintf("change would grow workspace to %d bytes, over its %d byte quota", e.quota.ProjectedBytes, e.quota.LimitBytes)
+}
+
+// workspaceStorageQuota returns the bytes a workspace may hold, or 0 for no limit. Every
+// workspace shares the configured default for now.
+func (ac *ApiController) workspaceStorageQuota(ws Workspace) int64 {
+	return ac.AppConfig.WorkspaceStorageQuotaBytes
+}
+
+// exceedsStorageQuota reports whether growing a workspace of current bytes by delta takes
+// it past limit. Changes that do not grow the workspace are always allowed, so a workspace
+// already over its quota can still be cleaned up.
+func exceedsStorageQuota(current, delta, limit int64) bool {
+	return limit > 0 && delta > 0 && current+delta > limit
+}
+
+// checkStorageQuota returns a *storageQuotaError when growing ws by delta bytes would take it
+// past its quota. Transactions that change storage_bytes call it on the workspace they read,
+// so concurrent writers cannot together overshoot the quota.
+func (ac *ApiController) checkStorageQuota(ws Workspace, delta int64) error {
+	if limit := ac.workspaceStorageQuota(ws); exceedsStorageQuota(ws.StorageBytes, delta, limit) {
+		return &storageQuotaError{quota: StorageQuota{ProjectedBytes: ws.StorageBytes + delta, LimitBytes: limit}}
+	}
+	return nil
+}
+
+// reconcileUploadSizes replaces the client-reported size of each uploaded file with the size
+// of the object it uploaded, so a client cannot understate what it stored. Objects that
+// cannot be read keep the reported size.
+func (ac *ApiController) reconcileUploadSizes(ctx context.Context, actions []FileAction, logCtx *log.Entry) {
+	store := ac.r2()
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, storageReconcileConcurrency)
+	for i := range actions {
+		action := &actions[i]
+		if action.Action != "upsert" || action.Type != "file" || action.R2ObjectKey == "" {
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			out, err := store.S3.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(ac.R2BucketName),
+				Key:    aws.String(action.R2ObjectKey),
+			})
+			if err != nil {
+				logCtx.WithError(err).WithField("r2_object_key", action.R2ObjectKey).Warn("Could not read uploaded object size; keeping the reported size.")
+				return
+			}
+			if out.ContentLength != nil {
+				size := *out.ContentLength
+				action.Size = &size
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BackfillWorkspaceStorage recomputes storage_bytes for a page of workspaces from their file
+// metadata, for workspaces created before the counter existed or whose counter drifted.
+// Each workspace is corrected in its own transaction, so a concurrent sync cannot be lost.
+func (ac *ApiController) BackfillWorkspaceStorage(c *gin.Context) {
+	adminID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "BackfillWorkspaceStorage", "admin_id": adminID})
+
+	var req BackfillStorageRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = 50
+	}
+	if limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+
+	result, err := ac.backfillWorkspaceStoragePage(ctx, req.Cursor, limit, logCtx)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to query workspaces")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspaces"})
+		return
+	}
+
+	logCtx.WithFields(log.Fields{"scanned": result.Scanned, "corrected": result.Corrected, "failed": result.Failed}).Warn("Workspace storage backfilled by operator.")
+	ac.recordAudit(ctx, adminID, auditWorkspaceStorageBackfilled, "workspaces", "", map[string]interface{}{
+		"cursor":    req.Cursor,
+		"limit":     limit,
+		"scanned":   result.Scanned,
+		"corrected": result.Corrected,
+		"failed":    result.Failed,
+	})
+	c.JSON(http.StatusOK, result)
+}
+
+// backfillWorkspaceStoragePage backfills up to limit workspaces after cursor, in document ID
+// order. NextCursor is empty once the last workspace has been reached.
+func (ac *ApiController) backfillWorkspaceStoragePage(ctx context.Context, cursor string, limit int, logCtx *log.Entry) (BackfillStorageResult, error) {
+	q := ac.FirestoreClient.Collection("workspaces").OrderBy(docstore.DocumentID, docstore.Asc)
+	if cursor != "" {
+		q = q.StartAfter(cursor)
+	}
+	docs, err := q.Limit(limit).Documents(ctx).GetAll()
+	if err != nil {
+		return BackfillStorageResult{}, fmt.Errorf("failed to query workspaces: %w", err)
+	}
+
+	result := BackfillStorageResult{Scanned: len(docs)}
+	for _, doc := range docs {
+		corrected, err := ac.backfillWorkspaceStorage(ctx, doc.Ref)
+		if err != nil {
+			logCtx.WithError(err).WithField("workspace_id", doc.Ref.ID).Warn("Failed to backfill workspace storage")
+			result.Failed++
+			continue
+		}
+		if corrected {
+			result.Corrected++
+		}
+	}
+	if len(docs) == limit {
+		result.NextCursor = docs[len(docs)-1].Ref.ID
+	}
+	return result, nil
+}
+
+// runStorageBackfillMaintenance is the "storage-backfill" maintenance task:
+// it backfills storage_bytes for the next page of workspaces, so workspaces created before
+// the counter existed get one and drifted counters are corrected. The scan resumes where
+// the previous run stopped and starts over after the last workspace; concurrent runs may
+// repeat a page, which is harmless.
+func (ac *ApiController) runStorageBackfillMaintenance(ctx context.Context) (interface{}, error) {
+	logCtx := log.WithField("maintenance_task", "storage-backfill")
+	cursorRef := ac.FirestoreClient.Collection(maintenanceCursorsCollection).Doc("storage-backfill")
+
+	cursor := ""
+	snap, err := cursorRef.Get(ctx)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("failed to load backfill cursor: %w", err)
+	}
+	if err == nil {
+		cursor, _ = snap.Data()["after"].(string)
+	}
+
+	result, err := ac.backfillWorkspaceStoragePage(ctx, cursor, storageBackfillBatch, logCtx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cursorRef.Set(ctx, map[string]interface{}{
+		"after":      result.NextCursor,
+		"updated_at": NowISO8601(),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to save backfill cursor: %w", err)
+	}
+	if result.Corrected > 0 {
+		logCtx.WithFields(log.Fields{"scanned": result.Scanned, "corrected": result.Corrected, "failed": result.Failed}).Info("Workspace storage counters backfilled.")
+	}
+	return result, nil
+}
+
+// backfillWorkspaceStorage sets one workspace's storage_bytes to the sum of its file sizes
+// and reports whether the stored value was wrong.
+func (ac *ApiController) backfillWorkspaceStorage(ctx context.Context, wsRef *docstore.DocumentRef) (bool, error) {
+	corrected := false
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		corrected = false
+		wsSnap, err := tx.Get(wsRef)
+		if err != nil {
+			return fmt.Errorf("failed to read workspace: %w", err)
+		}
+		var ws Workspace
+		if err := wsSnap.DataTo(&ws); err != nil {
+			return fmt.Errorf("failed to parse workspace: %w", err)
+		}
+
+		var total int64
+		iter := tx.Documents(wsRef.Collection("files").Where("type", "==", "file"))
+		defer iter.Stop()
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read files: %w", err)
+			}
+			var meta FileMetadata
+			if err := doc.DataTo(&meta); err != nil {
+				return fmt.Errorf("failed to parse file %s: %w", doc.Ref.ID, err)
+			}
+			total += meta.Size
+		}
+
+		if _, set := wsSnap.Data()["storage_bytes"]; set && ws.StorageBytes == total {
+			return nil
+		}
+		corrected = true
+		return tx.Update(wsRef, []docstore.Update{{Path: "storage_bytes", Value: total}})
+	})
+	return corrected, err
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExceedsStorageQuota(t *testing.T) {
+	assert.False(t, exceedsStorageQuota(900, 100, 1000), "exactly at the quota")
+	assert.True(t, exceedsStorageQuota(900, 101, 1000))
+	assert.False(t, exceedsStorageQuota(5000, 10, 0), "no quota")
+	assert.False(t, exceedsStorageQuota(5000, 0, 1000), "over quota but not growing")
+	assert.False(t, exceedsStorageQuota(5000, -100, 1000), "shrinking an over-quota workspace")
+}
+
+func TestHandlers_SyncStorageQuota(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.WorkspaceStorageQuotaBytes = 100
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "data.csv", string(make([]byte, 60)))
+	path := "/api/workspaces/" + workspaceID + "/sync"
+
+	big := SyncRequest{WorkspaceVersion: "1", Files: []SyncFileClientState{
+		{FilePath: "big.bin", Type: "file", ClientHash: "h", Action: "new", Size: 50},
+	}}
+	var resp SyncResponse
+	w := h.do(http.MethodPost, path, owner, big, &resp)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	assert.Equal(t, syncQuotaExceeded, resp.Status)
+	if assert.NotNil(t, resp.StorageQuota) {
+		assert.Equal(t, StorageQuota{ProjectedBytes: 110, LimitBytes: 100}, *resp.StorageQuota)
+	}
+	assert.Empty(t, resp.Actions, "no upload URLs are issued")
+
+	resp = SyncResponse{}
+	w = h.do(http.MethodPost, path+"?dryRun=true", owner, big, &resp)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	if assert.NotNil(t, resp.Summary, "dry runs still report the plan") {
+		assert.Equal(t, 1, resp.Summary.Uploads)
+	}
+
+	// Replacing the existing file with a smaller one frees space for the new one.
+	resp = SyncResponse{}
+	w = h.do(http.MethodPost, path, owner, SyncRequest{WorkspaceVersion: "1", Files: []SyncFileClientState{
+		{FilePath: "big.bin", Type: "file", ClientHash: "h", Action: "new", Size: 50},
+		{FilePath: "data.csv", Type: "file", Action: "deleted"},
+	}}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "pending_confirmation", resp.Status)
+}
+
+func TestHandlers_ConfirmSyncReconcilesUploadSizes(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.WorkspaceStorageQuotaBytes = 100
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+
+	// The client claims a tiny file but uploads one over the quota.
+	action, version := h.syncNewFile(workspaceID, owner, "1", "model.bin")
+	h.objects.put(action.R2ObjectKey, make([]byte, 150))
+	var resp ConfirmSyncResponse
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync/confirm", owner, ConfirmSyncRequest{
+		WorkspaceVersion: version,
+		SyncActions:      []FileAction{upsertAction(action, 10)},
+	}, &resp)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	assert.Equal(t, syncQuotaExceeded, resp.Status)
+	if assert.NotNil(t, resp.StorageQuota) {
+		assert.Equal(t, int64(150), resp.StorageQuota.ProjectedBytes)
+	}
+
+	// Within the quota, the stored size is the object's, not the claimed one.
+	h.objects.put(action.R2ObjectKey, make([]byte, 40))
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync/confirm", owner, ConfirmSyncRequest{
+		WorkspaceVersion: version,
+		SyncActions:      []FileAction{upsertAction(action, 10)},
+	}, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	ws, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(40), ws.StorageBytes)
+	}
+}
+
+func TestHandlers_BackfillWorkspaceStorage(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "a.txt", "hello")
+	h.seedFile(workspaceID, "b.txt", "world!")
+	wsRef := h.fs.Collection("workspaces").Doc(workspaceID)
+	_, err := wsRef.Update(context.Background(), []docstore.Update{{Path: "storage_bytes", Value: docstore.Delete}})
+	if err != nil {
+		t.Fatalf("clear storage bytes: %v", err)
+	}
+
+	corrected, err := h.ac.backfillWorkspaceStorage(context.Background(), wsRef)
+	assert.NoError(t, err)
+	assert.True(t, corrected)
+	ws, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(11), ws.StorageBytes)
+	}
+
+	corrected, err = h.ac.backfillWorkspaceStorage(context.Background(), wsRef)
+	assert.NoError(t, err)
+	assert.False(t, corrected, "a correct counter is left alone")
+
+	var result BackfillStorageResult
+	w := h.do(http.MethodPost, "/api/admin/workspaces/backfill-storage", "admin", BackfillStorageRequest{Limit: 1}, &result)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 1, result.Scanned)
+	assert.NotEmpty(t, result.NextCursor)
+
+	w = h.do(http.MethodPost, "/api/admin/workspaces/backfill-storage", "admin", BackfillStorageRequest{Limit: 500}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandlers_StorageBackfillMaintenance(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "a.txt", "hello")
+	h.seedFile(workspaceID, "b.txt", "world!")
+	wsRef := h.fs.Collection("workspaces").Doc(workspaceID)
+	_, err := wsRef.Update(context.Background(), []docstore.Update{{Path: "storage_bytes", Value: docstore.Delete}})
+	if err != nil {
+		t.Fatalf("clear storage bytes: %v", err)
+	}
+
+	// Resume the sweep just before this workspace; a prefix of its ID sorts right before it.
+	cursorRef := h.fs.Collection(maintenanceCursorsCollection).Doc("storage-backfill")
+	if _, err := cursorRef.Set(context.Background(), map[string]interface{}{"after": workspaceID[:len(workspaceID)-1]}); err != nil {
+		t.Fatalf("seed cursor: %v", err)
+	}
+
+	var resp struct {
+		Summary BackfillStorageResult `json:"summary"`
+	}
+	w := h.do(http.MethodPost, "/api/admin/maintenance/storage-backfill", "admin", nil, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.GreaterOrEqual(t, resp.Summary.Corrected, 1)
+	ws, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(11), ws.StorageBytes)
+	}
+
+	// The next run picks up after the page just scanned, or starts over at the end.
+	snap, err := cursorRef.Get(context.Background())
+	if assert.NoError(t, err) {
+		after, _ := snap.Data()["after"].(string)
+		assert.Equal(t, resp.Summary.NextCursor, after)
+		if after != "" {
+			assert.GreaterOrEqual(t, after, workspaceID)
+		}
+	}
+}
+
+func TestCommitDraftAndImportStorageQuota(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.WorkspaceStorageQuotaBytes = 100
+	ctx := context.Background()
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	existing := h.seedFile(workspaceID, "data.csv", string(make([]byte, 60)))
+
+	draft := FileDraft{WorkspaceID: workspaceID, FilePath: "notes.txt"}
+	_, err := h.ac.commitDraft(ctx, draft, make([]byte, 50), true)
+	var quotaErr *storageQuotaError
+	if assert.ErrorAs(t, err, &quotaErr) {
+		assert.Equal(t, StorageQuota{ProjectedBytes: 110, LimitBytes: 100}, quotaErr.quota)
+	}
+	_, err = h.ac.commitImport(ctx, workspaceID, []importEntry{{Path: "a.bin", Data: make([]byte, 30)}, {Path: "b.bin", Data: make([]byte, 20)}})
+	assert.ErrorAs(t, err, &quotaErr)
+	ws, err := h.ac.loadWorkspace(ctx, workspaceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(60), ws.StorageBytes, "refused commits leave storage unchanged")
+		assert.Equal(t, "1", ws.WorkspaceVersion)
+	}
+
+	// Replacing the existing file frees its bytes.
+	commit, err := h.ac.commitImport(ctx, workspaceID, []importEntry{{Path: "data.csv", Data: make([]byte, 10)}, {Path: "a.bin", Data: make([]byte, 80)}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(90), commit.StorageBytes)
+	}
+	assert.False(t, h.objects.has(existing.R2ObjectKey))
+}
+
+func TestHandlers_CloneStorageQuota(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.WorkspaceStorageQuotaBytes = 100
+	owner := "owner-" + uuid.New().String()
+	sourceID := h.createWorkspace(owner)
+	h.seedFile(sourceID, "data.csv", string(make([]byte, 120)))
+	path := "/api/workspaces/" + sourceID + "/clone"
+
+	var refused struct {
+		Code         string       `json:"code"`
+		StorageQuota StorageQuota `json:"storageQuota"`
+	}
+	w := h.do(http.MethodPost, path, owner, nil, &refused)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	assert.Equal(t, syncQuotaExceeded, refused.Code)
+	assert.Equal(t, StorageQuota{ProjectedBytes: 120, LimitBytes: 100}, refused.StorageQuota)
+
+	// A source whose counter understates its files passes the first check; the copy still
+	// stops at the checkpoint.
+	_, err := h.fs.Collection("workspaces").Doc(sourceID).Update(context.Background(), []docstore.Update{{Path: "storage_bytes", Value: 0}})
+	if err != nil {
+		t.Fatalf("reset storage bytes: %v", err)
+	}
+	var started struct {
+		CloneJobID  string `json:"cloneJobId"`
+		WorkspaceID string `json:"workspaceId"`
+	}
+	w = h.do(http.MethodPost, path, owner, nil, &started)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	job := waitForClone(h, owner, started.WorkspaceID, started.CloneJobID)
+	assert.Equal(t, cloneStatusFailed, job.Status)
+	assert.Contains(t, job.Error, "quota")
+	assert.Zero(t, job.BytesCopied)
+}
@@ -0,0 +1,83 @@
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SSE is a Stream that speaks text/event-stream and sends a heartbeat comment every
+// HeartbeatInterval so proxies do not close idle connections.
+type SSE struct {
+	*Stream
+	heartbeats sync.WaitGroup
+}
+
+// StartSSE sends the event-stream headers and starts the heartbeat. The caller must call
+// Close, which also waits for the heartbeat goroutine to exit.
+func StartSSE(ctx context.Context, w http.ResponseWriter, cfg Config, metrics *Metrics) *SSE {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+
+	s := &SSE{Stream: Start(ctx, w, cfg, metrics)}
+	_ = s.Flush()
+
+	interval := cfg.HeartbeatInterval
+	if interval <= 0 {
+		interval = DefaultHeartbeatInterval
+	}
+	s.heartbeats.Add(1)
+	go s.heartbeat(interval)
+	return s
+}
+
+// Send writes one event with data JSON-encoded and flushes it.
+func (s *SSE) Send(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event, err)
+	}
+	var frame bytes.Buffer
+	if event != "" {
+		fmt.Fprintf(&frame, "event: %s\n", event)
+	}
+	fmt.Fprintf(&frame, "data: %s\n\n", payload)
+	if _, err := s.Write(frame.Bytes()); err != nil {
+		return err
+	}
+	return s.Flush()
+}
+
+// Close ends the stream and waits for the heartbeat goroutine.
+func (s *SSE) Close(err error) Reason {
+	reason := s.Stream.Close(err)
+	s.heartbeats.Wait()
+	return reason
+}
+
+func (s *SSE) heartbeat(interval time.Duration) {
+	defer s.heartbeats.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			if err := s.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
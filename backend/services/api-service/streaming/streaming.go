@@ -0,0 +1,220 @@
+// Package streaming is the shared plumbing for long-lived HTTP responses (SSE and
+// streamed downloads): cancellation tied to the client connection, a maximum stream
+// duration, per-write deadlines for slow clients and counters for open streams and
+// why they ended.
+package streaming
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHeartbeatInterval is how often SSE streams send a keep-alive comment.
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// ErrMaxDuration is the context cause when a stream reaches Config.MaxDuration.
+var ErrMaxDuration = errors.New("stream reached its maximum duration")
+
+// Config bounds a stream. Zero values disable the corresponding limit.
+type Config struct {
+	MaxDuration       time.Duration // Stream is cancelled once this long has passed
+	WriteTimeout      time.Duration // Each write must reach the client within this time
+	HeartbeatInterval time.Duration // SSE only; zero uses DefaultHeartbeatInterval
+}
+
+// Reason records why a stream ended.
+type Reason string
+
+const (
+	ReasonCompleted    Reason = "completed"
+	ReasonClientGone   Reason = "client_gone"
+	ReasonMaxDuration  Reason = "max_duration"
+	ReasonWriteTimeout Reason = "write_timeout"
+	ReasonError        Reason = "error"
+)
+
+// Metrics counts open streams and how finished streams ended. A nil *Metrics is valid
+// and records nothing.
+type Metrics struct {
+	open  atomic.Int64
+	mu    sync.Mutex
+	ended map[Reason]int64
+}
+
+// Stats is a snapshot of Metrics.
+type Stats struct {
+	Open  int64            `json:"open"`
+	Ended map[Reason]int64 `json:"ended"`
+}
+
+// NewMetrics returns empty stream counters.
+func NewMetrics() *Metrics {
+	return &Metrics{ended: make(map[Reason]int64)}
+}
+
+// Stats returns the current counters.
+func (m *Metrics) Stats() Stats {
+	if m == nil {
+		return Stats{Ended: map[Reason]int64{}}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ended := make(map[Reason]int64, len(m.ended))
+	for reason, n := range m.ended {
+		ended[reason] = n
+	}
+	return Stats{Open: m.open.Load(), Ended: ended}
+}
+
+func (m *Metrics) opened() {
+	if m != nil {
+		m.open.Add(1)
+	}
+}
+
+func (m *Metrics) closed(reason Reason) {
+	if m == nil {
+		return
+	}
+	m.open.Add(-1)
+	m.mu.Lock()
+	m.ended[reason]++
+	m.mu.Unlock()
+}
+
+// Stream wraps a ResponseWriter for one long-lived response. Handlers write through it
+// (it is an io.Writer), do their work under Context and must call Close when done.
+type Stream struct {
+	parent  context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	cfg     Config
+	metrics *Metrics
+
+	mu       sync.Mutex
+	writeErr error
+	closed   bool
+	reason   Reason
+}
+
+// Start begins a stream for the request whose context is ctx. The stream's context is
+// cancelled when the client disconnects, MaxDuration passes, a write fails or Close is called.
+func Start(ctx context.Context, w http.ResponseWriter, cfg Config, metrics *Metrics) *Stream {
+	s := &Stream{parent: ctx, w: w, rc: http.NewResponseController(w), cfg: cfg, metrics: metrics}
+	if cfg.MaxDuration > 0 {
+		s.ctx, s.cancel = context.WithTimeoutCause(ctx, cfg.MaxDuration, ErrMaxDuration)
+	} else {
+		s.ctx, s.cancel = context.WithCancel(ctx)
+	}
+	metrics.opened()
+	return s
+}
+
+// Context is cancelled when the stream should stop producing output.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Write sends p under a fresh write deadline. Once a write fails the stream is
+// cancelled and every later write returns the same error.
+func (s *Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	s.extendDeadline()
+	n, err := s.w.Write(p)
+	if err != nil {
+		s.fail(err)
+	}
+	return n, err
+}
+
+// Flush pushes buffered output to the client.
+func (s *Stream) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
+	s.extendDeadline()
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.fail(err)
+		return err
+	}
+	return nil
+}
+
+// Close ends the stream and records why, given the handler's final error. It is safe to
+// call more than once; later calls return the first reason.
+func (s *Stream) Close(err error) Reason {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return s.reason
+	}
+	s.closed = true
+	s.reason = s.classify(err)
+	if s.writeErr == nil && s.cfg.WriteTimeout > 0 {
+		// Clear the deadline so it does not outlive the stream on a kept-alive connection.
+		_ = s.rc.SetWriteDeadline(time.Time{})
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+	s.metrics.closed(s.reason)
+	return s.reason
+}
+
+// writable reports why the stream can no longer be written to. Callers hold s.mu.
+func (s *Stream) writable() error {
+	if s.writeErr != nil {
+		return s.writeErr
+	}
+	if s.closed {
+		return net.ErrClosed
+	}
+	return context.Cause(s.ctx)
+}
+
+// extendDeadline arms the per-write deadline. Writers that cannot set deadlines (such as
+// test recorders) are left without one. Callers hold s.mu.
+func (s *Stream) extendDeadline() {
+	if s.cfg.WriteTimeout > 0 {
+		_ = s.rc.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+	}
+}
+
+// fail records a write error and cancels the stream. Callers hold s.mu.
+func (s *Stream) fail(err error) {
+	s.writeErr = err
+	s.cancel()
+}
+
+// classify picks the reason a stream ended. Write failures win because they also
+// cancel the request context. Callers hold s.mu.
+func (s *Stream) classify(err error) Reason {
+	var netErr net.Error
+	switch {
+	case s.writeErr != nil && (errors.Is(s.writeErr, os.ErrDeadlineExceeded) || (errors.As(s.writeErr, &netErr) && netErr.Timeout())):
+		return ReasonWriteTimeout
+	case s.writeErr != nil:
+		return ReasonClientGone
+	case errors.Is(context.Cause(s.ctx), ErrMaxDuration):
+		return ReasonMaxDuration
+	case s.parent.Err() != nil:
+		return ReasonClientGone
+	case err != nil:
+		return ReasonError
+	}
+	return ReasonCompleted
+}
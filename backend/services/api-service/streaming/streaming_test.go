@@ -0,0 +1,136 @@
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serve runs handler behind a real server and returns the reason the stream ended.
+func serve(t *testing.T, handler func(w http.ResponseWriter, r *http.Request) Reason) (*httptest.Server, <-chan Reason) {
+	reasons := make(chan Reason, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reasons <- handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, reasons
+}
+
+func waitReason(t *testing.T, reasons <-chan Reason) Reason {
+	select {
+	case reason := <-reasons:
+		return reason
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end")
+		return ""
+	}
+}
+
+func TestSSE_SendsEventsAndHeartbeats(t *testing.T) {
+	metrics := NewMetrics()
+	srv, reasons := serve(t, func(w http.ResponseWriter, r *http.Request) Reason {
+		sse := StartSSE(r.Context(), w, Config{HeartbeatInterval: 10 * time.Millisecond}, metrics)
+		err := sse.Send("status", map[string]string{"status": "running"})
+		time.Sleep(35 * time.Millisecond)
+		return sse.Close(err)
+	})
+
+	resp, err := http.Get(srv.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "event: status\ndata: {\"status\":\"running\"}\n\n")
+	assert.Contains(t, string(body), ": heartbeat\n\n")
+	assert.Equal(t, ReasonCompleted, waitReason(t, reasons))
+
+	stats := metrics.Stats()
+	assert.Equal(t, int64(0), stats.Open)
+	assert.Equal(t, int64(1), stats.Ended[ReasonCompleted])
+}
+
+func TestSSE_ClientDisconnectEndsStream(t *testing.T) {
+	metrics := NewMetrics()
+	srv, reasons := serve(t, func(w http.ResponseWriter, r *http.Request) Reason {
+		sse := StartSSE(r.Context(), w, Config{HeartbeatInterval: 5 * time.Millisecond}, metrics)
+		_ = sse.Send("ready", true)
+		<-sse.Context().Done()
+		return sse.Close(sse.Context().Err())
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	first := make([]byte, len("event: ready"))
+	_, err = io.ReadFull(resp.Body, first)
+	assert.NoError(t, err)
+	cancel()
+	resp.Body.Close()
+
+	// Close returning at all means the heartbeat goroutine has exited.
+	assert.Equal(t, ReasonClientGone, waitReason(t, reasons))
+	assert.Equal(t, int64(0), metrics.Stats().Open)
+}
+
+func TestStream_MaxDuration(t *testing.T) {
+	metrics := NewMetrics()
+	srv, reasons := serve(t, func(w http.ResponseWriter, r *http.Request) Reason {
+		stream := Start(r.Context(), w, Config{MaxDuration: 20 * time.Millisecond}, metrics)
+		<-stream.Context().Done()
+		_, err := stream.Write([]byte("late"))
+		assert.ErrorIs(t, err, ErrMaxDuration)
+		return stream.Close(err)
+	})
+
+	resp, err := http.Get(srv.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, ReasonMaxDuration, waitReason(t, reasons))
+	assert.Equal(t, int64(1), metrics.Stats().Ended[ReasonMaxDuration])
+}
+
+func TestStream_SlowClientHitsWriteTimeout(t *testing.T) {
+	metrics := NewMetrics()
+	chunk := bytes.Repeat([]byte("x"), 64<<10)
+	srv, reasons := serve(t, func(w http.ResponseWriter, r *http.Request) Reason {
+		stream := Start(r.Context(), w, Config{WriteTimeout: 50 * time.Millisecond}, metrics)
+		var err error
+		// The client never reads, so the socket buffers fill and a write blocks past its deadline.
+		for written := 0; err == nil && written < 1<<30; written += len(chunk) {
+			if _, err = stream.Write(chunk); err == nil {
+				err = stream.Flush()
+			}
+		}
+		return stream.Close(err)
+	})
+
+	resp, err := http.Get(srv.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, ReasonWriteTimeout, waitReason(t, reasons))
+	assert.Equal(t, int64(0), metrics.Stats().Open)
+}
+
+func TestStream_HandlerErrorAndRepeatedClose(t *testing.T) {
+	rec := httptest.NewRecorder()
+	stream := Start(context.Background(), rec, Config{WriteTimeout: time.Second}, nil)
+	_, err := io.Copy(stream, strings.NewReader("partial"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, ReasonError, stream.Close(io.ErrUnexpectedEOF))
+	assert.Equal(t, ReasonError, stream.Close(nil))
+	assert.Error(t, stream.Context().Err())
+	_, err = stream.Write([]byte("more"))
+	assert.Error(t, err)
+	assert.Equal(t, "partial", rec.Body.String())
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// syncDestructiveConfirmationRequired is the ConfirmSync status of a sync rejected by the
+// destructive sync guard; resending it with confirmDestructive commits it.
+const syncDestructiveConfirmationRequired = "destructive_change_confirmation_required"
+
+// destructiveSyncError aborts a ConfirmSync transaction that would delete too much of the
+// workspace without confirmation.
+type destructiveSyncError struct {
+	change DestructiveChange
+}
+
+func (e *destructiveSyncError) Error() string {
+	return fmt.Sprintf("sync would delete %d of %d files", e.change.FilesDeleted, e.change.WorkspaceFiles)
+}
+
+// destructiveSyncLimits returns a workspace's destructive sync thresholds: its own settings
+// where set, otherwise the global defaults.
+func (cfg *AppConfig) destructiveSyncLimits(ws Workspace) (maxPercent, maxCount int) {
+	maxPercent, maxCount = cfg.DestructiveSyncMaxDeletePercent, cfg.DestructiveSyncMaxDeleteCount
+	if s := ws.DestructiveSync; s != nil {
+		if s.MaxDeletePercent > 0 {
+			maxPercent = s.MaxDeletePercent
+		}
+		if s.MaxDeleteCount > 0 {
+			maxCount = s.MaxDeleteCount
+		}
+	}
+	return maxPercent, maxCount
+}
+
+// isDestructiveSync reports whether deleting deleted of total files crosses both thresholds:
+// more than maxCount files and more than maxPercent of the workspace. Requiring both keeps
+// small workspaces, where a handful of deletions is a large share, from tripping the guard.
+func isDestructiveSync(deleted, total, maxPercent, maxCount int) bool {
+	return deleted > maxCount && deleted*100 > total*maxPercent
+}
+
+// checkDestructiveSync returns the destructive change a sync deleting deleted files would
+// make, or nil when it stays under the workspace's thresholds. The workspace's files are
+// only counted, inside tx, when the deletion count alone could cross them.
+func (ac *ApiController) checkDestructiveSync(ctx context.Context, tx *docstore.Transaction, workspaceID string, ws Workspace, deleted int) (*DestructiveChange, error) {
+	maxPercent, maxCount := ac.AppConfig.destructiveSyncLimits(ws)
+	if deleted <= maxCount {
+		return nil, nil
+	}
+	res, err := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID)).
+		Where("type", "==", "file").
+		NewAggregationQuery().WithCount("count").Transaction(tx).Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count workspace files: %w", err)
+	}
+	v, _ := res["count"].(*firestorepb.Value)
+	total := int(v.GetIntegerValue())
+	if !isDestructiveSync(deleted, total, maxPercent, maxCount) {
+		return nil, nil
+	}
+	return &DestructiveChange{
+		FilesDeleted:     deleted,
+		WorkspaceFiles:   total,
+		MaxDeletePercent: maxPercent,
+		MaxDeleteCount:   maxCount,
+	}, nil
+}
+
+// recordDestructiveSync records a sync that crossed the destructive sync thresholds in the
+// activity log and security events, whether it was rejected or committed with confirmation.
+func (ac *ApiController) recordDestructiveSync(c *gin.Context, workspaceID, userID, workspaceVersion string, change DestructiveChange, confirmed bool) {
+	ctx := c.Request.Context()
+	details := map[string]interface{}{
+		"workspace_version":  workspaceVersion,
+		"files_deleted":      change.FilesDeleted,
+		"workspace_files":    change.WorkspaceFiles,
+		"max_delete_percent": change.MaxDeletePercent,
+		"max_delete_count":   change.MaxDeleteCount,
+		"confirmed":          confirmed,
+	}
+	ac.recordActivity(ctx, workspaceID, userID, activityDestructiveSync, details)
+	details["client_ip"] = RealClientIP(c)
+	ac.recordSecurityEvent(ctx, securityDestructiveSync, userID, workspaceID, details)
+}
+
+// SetDestructiveSyncSettings replaces a workspace's destructive sync thresholds. Zero fields
+// fall back to the global defaults. Only owners may change them.
+func (ac *ApiController) SetDestructiveSyncSettings(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{
+		"workspace_id": workspaceID,
+		"user_id":      userID,
+		"handler":      "SetDestructiveSyncSettings",
+	})
+
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+
+	var req DestructiveSyncSettings
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+
+	wsDocRef := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+	if _, err := wsDocRef.Update(ctx, []docstore.Update{
+		{Path: "destructive_sync", Value: req},
+		{Path: "updated_at", Value: NowISO8601()},
+	}); err != nil {
+		logCtx.WithError(err).Error("Failed to update destructive sync settings.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update workspace"})
+		return
+	}
+
+	maxPercent, maxCount := ac.AppConfig.destructiveSyncLimits(Workspace{DestructiveSync: &req})
+	ac.recordActivity(ctx, workspaceID, userID, activityDestructiveSyncSettingsChanged, map[string]interface{}{
+		"max_delete_percent": maxPercent,
+		"max_delete_count":   maxCount,
+	})
+	logCtx.WithFields(log.Fields{"max_delete_percent": maxPercent, "max_delete_count": maxCount}).Info("Destructive sync settings updated.")
+	c.JSON(http.StatusOK, gin.H{
+		"workspaceId":      workspaceID,
+		"settings":         req,
+		"maxDeletePercent": maxPercent,
+		"maxDeleteCount":   maxCount,
+	})
+}
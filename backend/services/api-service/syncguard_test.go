@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDestructiveSync(t *testing.T) {
+	cases := []struct {
+		name                 string
+		deleted, total       int
+		maxPercent, maxCount int
+		want                 bool
+	}{
+		{"both crossed", 900, 1000, 50, 100, true},
+		{"count at limit", 100, 150, 50, 100, false},
+		{"count just over", 101, 150, 50, 100, true},
+		{"percent at limit", 500, 1000, 50, 100, false},
+		{"percent just over", 501, 1000, 50, 100, true},
+		{"large workspace, small share", 300, 10000, 50, 100, false},
+		// Small workspaces: the percentage alone would reject routine cleanups.
+		{"all of a tiny workspace", 3, 3, 50, 100, false},
+		{"most of a small workspace", 80, 90, 50, 100, false},
+		{"small count override", 3, 4, 50, 2, true},
+		{"small count override, half", 3, 6, 50, 2, false},
+		// Integer math: 1 of 3 is 33.3%, which must not round onto a 33% threshold.
+		{"rounding", 1, 3, 33, 0, true},
+		{"rounding below", 1, 3, 34, 0, false},
+		{"nothing deleted", 0, 0, 50, 0, false},
+		{"full percent never trips", 200, 200, 100, 100, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, isDestructiveSync(tc.deleted, tc.total, tc.maxPercent, tc.maxCount))
+		})
+	}
+}
+
+func TestDestructiveSyncLimits(t *testing.T) {
+	cfg := &AppConfig{DestructiveSyncMaxDeletePercent: 50, DestructiveSyncMaxDeleteCount: 100}
+
+	percent, count := cfg.destructiveSyncLimits(Workspace{})
+	assert.Equal(t, 50, percent)
+	assert.Equal(t, 100, count)
+
+	percent, count = cfg.destructiveSyncLimits(Workspace{DestructiveSync: &DestructiveSyncSettings{MaxDeleteCount: 10}})
+	assert.Equal(t, 50, percent)
+	assert.Equal(t, 10, count)
+
+	percent, count = cfg.destructiveSyncLimits(Workspace{DestructiveSync: &DestructiveSyncSettings{MaxDeletePercent: 80, MaxDeleteCount: 500}})
+	assert.Equal(t, 80, percent)
+	assert.Equal(t, 500, count)
+}
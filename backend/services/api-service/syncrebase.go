@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/1liale/api-service/docstore"
+)
+
+// maxAutoRebaseVersions bounds how far behind a client may be for HandleSync to rebase its
+// sync instead of reporting a conflict.
+const maxAutoRebaseVersions = 50
+
+// workspaceChangesCollection returns the per-commit change log of a workspace. Documents are
+// keyed by the workspace version the commit produced.
+func (ac *ApiController) workspaceChangesCollection(workspaceID string) *docstore.CollectionRef {
+	return ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/changes", workspaceID))
+}
+
+// recordWorkspaceChanges writes the change log entry for a commit inside its transaction.
+func (ac *ApiController) recordWorkspaceChanges(tx *docstore.Transaction, workspaceID, version string, changes []WorkspaceChange) error {
+	ref := ac.workspaceChangesCollection(workspaceID).Doc(version)
+	err := tx.Set(ref, WorkspaceChangeLog{
+		WorkspaceVersion: version,
+		Changes:          changes,
+		CommittedAt:      NowISO8601(),
+		DeleteAfter:      deleteAfter(ac.AppConfig.Retention.WorkspaceChanges),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record workspace changes: %w", err)
+	}
+	return nil
+}
+
+// workspaceChangesSince returns what the commits after fromVersion up to toVersion changed,
+// oldest first. It returns false when that history is incomplete (too many versions, an
+// unversioned workspace, or log entries that predate the change log or have expired), in
+// which case callers must not assume the paths are known.
+func (ac *ApiController) workspaceChangesSince(ctx context.Context, workspaceID, fromVersion, toVersion string) ([]WorkspaceChange, bool, error) {
+	from, err := strconv.Atoi(fromVersion)
+	if err != nil {
+		return nil, false, nil
+	}
+	to, err := strconv.Atoi(toVersion)
+	if err != nil || to <= from || to-from > maxAutoRebaseVersions {
+		return nil, false, nil
+	}
+
+	refs := make([]*docstore.DocumentRef, 0, to-from)
+	for v := from + 1; v <= to; v++ {
+		refs = append(refs, ac.workspaceChangesCollection(workspaceID).Doc(strconv.Itoa(v)))
+	}
+	snaps, err := ac.FirestoreClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load workspace changes: %w", err)
+	}
+
+	var changes []WorkspaceChange
+	for _, snap := range snaps {
+		if !snap.Exists() || snapshotPastDeleteAfter(snap) {
+			return nil, false, nil
+		}
+		var entry WorkspaceChangeLog
+		if err := snap.DataTo(&entry); err != nil {
+			return nil, false, fmt.Errorf("failed to parse workspace changes %s: %w", snap.Ref.ID, err)
+		}
+		for _, change := range entry.Changes {
+			change.Version = entry.WorkspaceVersion
+			changes = append(changes, change)
+		}
+	}
+	return changes, true, nil
+}
+
+// normalizeSyncPath puts a workspace path in the form used for overlap checks: slash
+// separated, without leading or trailing slashes.
+func normalizeSyncPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// pathAncestors returns the folders containing p, innermost first.
+func pathAncestors(p string) []string {
+	var ancestors []string
+	for {
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			return ancestors
+		}
+		p = p[:i]
+		ancestors = append(ancestors, p)
+	}
+}
+
+// rebaseConflicts returns the client paths that overlap a server change, sorted. Paths
+// overlap when they are equal or one is a folder containing the other, so deleting or
+// creating a folder conflicts with any server change inside it and vice versa.
+func rebaseConflicts(clientPaths []string, serverChanges []WorkspaceChange) []string {
+	changed := make(map[string]bool, len(serverChanges))
+	containsChange := make(map[string]bool) // Folders with a changed path somewhere inside
+	for _, change := range serverChanges {
+		p := normalizeSyncPath(change.FilePath)
+		changed[p] = true
+		for _, folder := range pathAncestors(p) {
+			containsChange[folder] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	var conflicts []string
+	for _, clientPath := range clientPaths {
+		p := normalizeSyncPath(clientPath)
+		overlaps := changed[p] || containsChange[p]
+		for _, folder := range pathAncestors(p) {
+			overlaps = overlaps || changed[folder]
+		}
+		if overlaps && !seen[clientPath] {
+			seen[clientPath] = true
+			conflicts = append(conflicts, clientPath)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// clientChangedPaths returns the paths a sync request adds, modifies or deletes.
+func clientChangedPaths(files []SyncFileClientState) []string {
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.Action != "unchanged" {
+			paths = append(paths, f.FilePath)
+		}
+	}
+	return paths
+}
+
+// latestChanges keeps the most recent change per path, in path order, so a client pulling
+// after an auto-rebase fetches each path once.
+func latestChanges(changes []WorkspaceChange) []WorkspaceChange {
+	latest := make(map[string]WorkspaceChange, len(changes))
+	for _, change := range changes {
+		latest[change.FilePath] = change // Changes are oldest first
+	}
+	out := make([]WorkspaceChange, 0, len(latest))
+	for _, change := range latest {
+		out = append(out, change)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FilePath < out[j].FilePath })
+	return out
+}
+
+// syncActionsToChanges lists the paths a confirmed sync changes, for the change log.
+func syncActionsToChanges(actions []FileAction) []WorkspaceChange {
+	changes := make([]WorkspaceChange, 0, len(actions))
+	for _, action := range actions {
+		changes = append(changes, WorkspaceChange{FilePath: action.FilePath, Type: action.Type, Action: action.Action})
+	}
+	return changes
+}
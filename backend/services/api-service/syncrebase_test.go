@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebaseConflicts_DisjointPaths(t *testing.T) {
+	server := []WorkspaceChange{
+		{FilePath: "src/server.py", Action: "upsert"},
+		{FilePath: "docs/old.md", Action: "delete"},
+	}
+	assert.Empty(t, rebaseConflicts([]string{"src/client.py", "README.md", "docs2/new.md"}, server))
+}
+
+func TestRebaseConflicts_SamePath(t *testing.T) {
+	server := []WorkspaceChange{{FilePath: "src/main.py", Action: "upsert"}}
+	assert.Equal(t, []string{"src/main.py"}, rebaseConflicts([]string{"src/main.py", "src/other.py"}, server))
+}
+
+func TestRebaseConflicts_DeleteVersusModify(t *testing.T) {
+	// The server deleted a file the client modified, and modified a file the client deleted.
+	server := []WorkspaceChange{
+		{FilePath: "a.py", Action: "delete"},
+		{FilePath: "b.py", Action: "upsert"},
+	}
+	assert.Equal(t, []string{"a.py", "b.py"}, rebaseConflicts([]string{"b.py", "a.py"}, server))
+}
+
+func TestRebaseConflicts_FolderOverlaps(t *testing.T) {
+	server := []WorkspaceChange{{FilePath: "src/pkg/util.py", Action: "upsert"}}
+	// Deleting or recreating a folder that holds a server change conflicts with it.
+	assert.Equal(t, []string{"src"}, rebaseConflicts([]string{"src"}, server))
+	assert.Equal(t, []string{"src/pkg/"}, rebaseConflicts([]string{"src/pkg/"}, server))
+
+	// A server-side folder delete conflicts with client changes inside the folder.
+	server = []WorkspaceChange{{FilePath: "lib", Type: "folder", Action: "delete"}}
+	assert.Equal(t, []string{"lib/a/b.py"}, rebaseConflicts([]string{"lib/a/b.py"}, server))
+
+	// Sibling names sharing a prefix do not overlap.
+	assert.Empty(t, rebaseConflicts([]string{"library/x.py", "lib2"}, server))
+}
+
+func TestRebaseConflicts_NormalizesPaths(t *testing.T) {
+	server := []WorkspaceChange{{FilePath: "/src//main.py", Action: "upsert"}}
+	assert.Equal(t, []string{"src/main.py"}, rebaseConflicts([]string{"src/main.py"}, server))
+	assert.Equal(t, []string{"./src/"}, rebaseConflicts([]string{"./src/"}, server))
+}
+
+func TestClientChangedPathsSkipsUnchanged(t *testing.T) {
+	files := []SyncFileClientState{
+		{FilePath: "a.py", Action: "modified"},
+		{FilePath: "b.py", Action: "unchanged"},
+		{FilePath: "c.py", Action: "deleted"},
+		{FilePath: "d", Action: "new"},
+	}
+	assert.Equal(t, []string{"a.py", "c.py", "d"}, clientChangedPaths(files))
+}
+
+func TestLatestChangesKeepsNewestPerPath(t *testing.T) {
+	changes := []WorkspaceChange{
+		{FilePath: "b.py", Action: "upsert", Version: "4"},
+		{FilePath: "a.py", Action: "upsert", Version: "4"},
+		{FilePath: "b.py", Action: "delete", Version: "5"},
+	}
+	assert.Equal(t, []WorkspaceChange{
+		{FilePath: "a.py", Action: "upsert", Version: "4"},
+		{FilePath: "b.py", Action: "delete", Version: "5"},
+	}, latestChanges(changes))
+}
@@ -0,0 +1,48 @@
+package main
+
+// upsertSize returns the size to store for an upserted file. A client that omits the size
+// keeps the previous size, so the workspace storage aggregate stays equal to the sum of
+// file metadata sizes even when clients send incomplete actions.
+func upsertSize(action FileAction, existing *FileMetadata) int64 {
+	if action.Size != nil && *action.Size >= 0 {
+		return *action.Size
+	}
+	if existing != nil {
+		return existing.Size
+	}
+	return 0
+}
+
+// computeSyncTotals totals the file and byte changes a commit will apply. existing maps file
+// paths to their current metadata and omits paths that do not exist yet. Folders carry no
+// bytes and are not counted as files.
+func computeSyncTotals(actions []FileAction, existing map[string]*FileMetadata) SyncTotals {
+	var totals SyncTotals
+	for _, action := range actions {
+		if action.Type != "file" {
+			continue
+		}
+		old := existing[action.FilePath]
+
+		switch action.Action {
+		case "upsert":
+			totals.FilesUpserted++
+			var oldSize int64
+			if old != nil {
+				oldSize = old.Size
+			}
+			if delta := upsertSize(action, old) - oldSize; delta > 0 {
+				totals.BytesAdded += delta
+			} else {
+				totals.BytesRemoved -= delta
+			}
+		case "delete":
+			if old == nil {
+				continue // Nothing to delete, nothing freed
+			}
+			totals.FilesDeleted++
+			totals.BytesRemoved += old.Size
+		}
+	}
+	return totals
+}
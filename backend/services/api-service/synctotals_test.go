@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sizePtr(n int64) *int64 { return &n }
+
+func TestComputeSyncTotals(t *testing.T) {
+	existing := map[string]*FileMetadata{
+		"grown.py":   {FilePath: "grown.py", Type: "file", Size: 100},
+		"shrunk.py":  {FilePath: "shrunk.py", Type: "file", Size: 500},
+		"nosize.py":  {FilePath: "nosize.py", Type: "file", Size: 300},
+		"removed.py": {FilePath: "removed.py", Type: "file", Size: 40},
+		"src":        {FilePath: "src", Type: "folder"},
+	}
+	actions := []FileAction{
+		{FilePath: "new.py", Type: "file", Action: "upsert", Size: sizePtr(1000)},
+		{FilePath: "grown.py", Type: "file", Action: "upsert", Size: sizePtr(250)},
+		{FilePath: "shrunk.py", Type: "file", Action: "upsert", Size: sizePtr(200)},
+		{FilePath: "nosize.py", Type: "file", Action: "upsert"},
+		{FilePath: "removed.py", Type: "file", Action: "delete"},
+		{FilePath: "missing.py", Type: "file", Action: "delete"},
+		{FilePath: "src", Type: "folder", Action: "delete"},
+	}
+
+	totals := computeSyncTotals(actions, existing)
+	assert.Equal(t, SyncTotals{
+		FilesUpserted: 4,
+		FilesDeleted:  1,
+		BytesAdded:    1000 + 150,
+		BytesRemoved:  300 + 40,
+	}, totals)
+}
+
+func TestUpsertSize(t *testing.T) {
+	old := &FileMetadata{Size: 300}
+	assert.Equal(t, int64(0), upsertSize(FileAction{Size: sizePtr(0)}, old), "explicit empty file")
+	assert.Equal(t, int64(300), upsertSize(FileAction{}, old), "missing size keeps previous")
+	assert.Equal(t, int64(0), upsertSize(FileAction{}, nil), "missing size on new file")
+}
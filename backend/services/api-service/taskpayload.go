@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Manifest delivery modes recorded on authenticated jobs.
+const (
+	manifestModeInline    = "inline"
+	manifestModeReference = "reference"
+)
+
+// manifestURLExpiry covers queueing delay and Cloud Tasks retries for by-reference
+// manifests. The objects themselves are expired by an R2 lifecycle rule on jobs/.
+const manifestURLExpiry = 24 * time.Hour
+
+// executionManifestKey is where a by-reference manifest is stored in R2.
+func executionManifestKey(jobID string) string {
+	return fmt.Sprintf("jobs/%s/manifest.json", jobID)
+}
+
+// marshalAuthTaskPayload encodes an authenticated execution payload. When the inline file
+// list would push the task past TaskPayloadMaxBytes, the list is written to R2 and the
+// worker receives a presigned manifest_url instead. The returned mode says which was used.
+func (ac *ApiController) marshalAuthTaskPayload(ctx context.Context, payload CloudTaskAuthPayload) ([]byte, string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal task payload: %w", err)
+	}
+	if len(body) <= ac.AppConfig.TaskPayloadMaxBytes {
+		return body, manifestModeInline, nil
+	}
+
+	manifest, err := json.Marshal(payload.Files)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal execution manifest: %w", err)
+	}
+	store := ac.r2()
+	key := executionManifestKey(payload.JobID)
+	if _, err := store.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(ac.R2BucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(manifest),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return nil, "", fmt.Errorf("failed to upload execution manifest: %w", err)
+	}
+	presigned, err := store.Presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ac.R2BucketName),
+		Key:    aws.String(key),
+	}, func(po *s3.PresignOptions) {
+		po.Expires = manifestURLExpiry
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to presign execution manifest: %w", err)
+	}
+
+	payload.Files = nil
+	payload.ManifestURL = presigned.URL
+	if body, err = json.Marshal(payload); err != nil {
+		return nil, "", fmt.Errorf("failed to marshal task payload: %w", err)
+	}
+	if len(body) > ac.AppConfig.TaskPayloadMaxBytes {
+		return nil, "", fmt.Errorf("task payload is %d bytes even with the manifest passed by reference", len(body))
+	}
+	return body, manifestModeReference, nil
+}
+
+// defaultDedupeWindow matches how long Cloud Tasks refuses to reuse the name of a task
+// that has run or been deleted.
+const defaultDedupeWindow = time.Hour
+
+// enqueueOptions tunes a single enqueueTask call.
+type enqueueOptions struct {
+	// DedupeKey gives the task a deterministic name so the same work enqueued twice within
+	// one DedupeWindow creates a single task. The window start is part of the name, because
+	// Cloud Tasks will not accept a recently used name even after the task has finished.
+	DedupeKey    string
+	DedupeWindow time.Duration // Zero uses defaultDedupeWindow
+	// JobID marks an execution task with its job; see executionTaskHeaders.
+	JobID string
+}
+
+// dedupeTaskName returns the full task name for opts under queuePath at now. Names are
+// hashed so they spread evenly across Cloud Tasks' key space.
+func dedupeTaskName(queuePath string, opts enqueueOptions, now time.Time) string {
+	window := opts.DedupeWindow
+	if window <= 0 {
+		window = defaultDedupeWindow
+	}
+	bucket := now.Truncate(window).Unix()
+	sum := sha256.Sum256([]byte(opts.DedupeKey + "|" + strconv.FormatInt(bucket, 10)))
+	return fmt.Sprintf("%s/tasks/%s", queuePath, hex.EncodeToString(sum[:16]))
+}
+
+// isAlreadyExists reports whether CreateTask failed because a task with that name exists.
+func isAlreadyExists(err error) bool {
+	return status.Code(err) == codes.AlreadyExists
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMarshalAuthTaskPayload_InlineWhenSmall(t *testing.T) {
+	ac := &ApiController{AppConfig: &AppConfig{TaskPayloadMaxBytes: 900 << 10}}
+	payload := CloudTaskAuthPayload{
+		JobID: "job-1",
+		Files: []WorkerFile{{R2ObjectKey: "workspaces/ws/files/f/main.py", FilePath: "main.py"}},
+	}
+
+	body, mode, err := ac.marshalAuthTaskPayload(context.Background(), payload)
+	assert.NoError(t, err)
+	assert.Equal(t, manifestModeInline, mode)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(body, &decoded))
+	assert.Len(t, decoded["files"], 1)
+	assert.NotContains(t, decoded, "manifest_url")
+}
+
+func TestExecutionManifestKey(t *testing.T) {
+	assert.Equal(t, "jobs/job-1/manifest.json", executionManifestKey("job-1"))
+}
+
+func TestDedupeTaskName(t *testing.T) {
+	queue := "projects/p/locations/l/queues/q"
+	at := time.Date(2026, 3, 1, 10, 15, 0, 0, time.UTC)
+	opts := enqueueOptions{DedupeKey: "rag-index:ws-1:7"}
+
+	name := dedupeTaskName(queue, opts, at)
+	assert.True(t, strings.HasPrefix(name, queue+"/tasks/"))
+	assert.Len(t, strings.TrimPrefix(name, queue+"/tasks/"), 32)
+
+	assert.Equal(t, name, dedupeTaskName(queue, opts, at.Add(30*time.Minute)))
+	assert.NotEqual(t, name, dedupeTaskName(queue, opts, at.Add(time.Hour)))
+	assert.NotEqual(t, name, dedupeTaskName(queue, enqueueOptions{DedupeKey: "rag-index:ws-1:8"}, at))
+
+	short := enqueueOptions{DedupeKey: "requeue:job-1", DedupeWindow: time.Minute}
+	assert.NotEqual(t, dedupeTaskName(queue, short, at), dedupeTaskName(queue, short, at.Add(time.Minute)))
+}
+
+func TestIsAlreadyExists(t *testing.T) {
+	assert.True(t, isAlreadyExists(status.Error(codes.AlreadyExists, "task exists")))
+	assert.False(t, isAlreadyExists(status.Error(codes.NotFound, "queue missing")))
+	assert.False(t, isAlreadyExists(errors.New("plain")))
+}
+
+func TestFindWorkerFile(t *testing.T) {
+	files := []WorkerFile{
+		{R2ObjectKey: "ws/main.py", FilePath: "main.py"},
+		{R2ObjectKey: "ws/data/in.txt", FilePath: "data/in.txt"},
+	}
+
+	file := findWorkerFile(files, "data/in.txt")
+	assert.NotNil(t, file)
+	assert.Equal(t, "ws/data/in.txt", file.R2ObjectKey)
+
+	file = findWorkerFile(files, "/data/./in.txt")
+	assert.NotNil(t, file)
+	assert.Equal(t, "data/in.txt", file.FilePath)
+
+	assert.Nil(t, findWorkerFile(files, "data"))
+	assert.Nil(t, findWorkerFile(files, "data/missing.txt"))
+	assert.Nil(t, findWorkerFile(nil, "main.py"))
+}
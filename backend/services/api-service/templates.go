@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// workspaceTemplatesCollection holds the templates new workspaces can start from.
+	workspaceTemplatesCollection = "workspace_templates"
+	// workspaceTemplateObjectPrefix is where template file contents live in R2. Templates may
+	// only copy from here, so a template can never point into another workspace.
+	workspaceTemplateObjectPrefix = "templates/"
+	// workspaceTemplateMaxFiles keeps a seeded workspace within one Firestore transaction.
+	workspaceTemplateMaxFiles = 200
+)
+
+var (
+	errTemplateNotFound = errors.New("workspace template not found")
+	errTemplateInvalid  = errors.New("workspace template is invalid")
+)
+
+// validateWorkspaceTemplate checks a template's manifest before anything is copied from it.
+func validateWorkspaceTemplate(tmpl WorkspaceTemplate) error {
+	if len(tmpl.Files) > workspaceTemplateMaxFiles {
+		return fmt.Errorf("%w: %d files, at most %d", errTemplateInvalid, len(tmpl.Files), workspaceTemplateMaxFiles)
+	}
+	seen := make(map[string]bool, len(tmpl.Files))
+	for _, f := range tmpl.Files {
+		if f.FilePath == "" || seen[f.FilePath] {
+			return fmt.Errorf("%w: missing or duplicate path %q", errTemplateInvalid, f.FilePath)
+		}
+		seen[f.FilePath] = true
+		switch f.Type {
+		case "folder":
+		case "file":
+			if !strings.HasPrefix(f.R2ObjectKey, workspaceTemplateObjectPrefix) {
+				return fmt.Errorf("%w: %s is not stored under %s", errTemplateInvalid, f.FilePath, workspaceTemplateObjectPrefix)
+			}
+		default:
+			return fmt.Errorf("%w: %s has unknown type %q", errTemplateInvalid, f.FilePath, f.Type)
+		}
+	}
+	return nil
+}
+
+// templateFile returns the new workspace's entry for a template file, with a fresh FileID
+// and, for files, an object key under the workspace's prefix.
+func templateFile(src WorkspaceTemplateFile, workspaceID, version, now string) FileMetadata {
+	fileID := uuid.New().String()
+	dst := FileMetadata{
+		FileID:    fileID,
+		FilePath:  src.FilePath,
+		Type:      src.Type,
+		Size:      src.Size,
+		Hash:      src.Hash,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   version,
+	}
+	if src.Type == "file" {
+		dst.R2ObjectKey = fmt.Sprintf("workspaces/%s/files/%s/%s", workspaceID, fileID, path.Base(src.FilePath))
+	}
+	return dst
+}
+
+// loadWorkspaceTemplate reads and validates a template.
+func (ac *ApiController) loadWorkspaceTemplate(ctx context.Context, templateID string) (WorkspaceTemplate, error) {
+	var tmpl WorkspaceTemplate
+	snap, err := ac.FirestoreClient.Collection(workspaceTemplatesCollection).Doc(templateID).Get(ctx)
+	if err != nil {
+		if isNotFound(err) {
+			return tmpl, errTemplateNotFound
+		}
+		return tmpl, fmt.Errorf("failed to read workspace template: %w", err)
+	}
+	if err := snap.DataTo(&tmpl); err != nil {
+		return tmpl, fmt.Errorf("failed to parse workspace template: %w", err)
+	}
+	tmpl.TemplateID = snap.Ref.ID
+	return tmpl, validateWorkspaceTemplate(tmpl)
+}
+
+// copyTemplateFiles copies a template's objects into a new workspace and returns the file
+// entries to write for them. On failure the objects already copied are removed again, so the
+// caller has nothing to undo.
+func (ac *ApiController) copyTemplateFiles(ctx context.Context, tmpl WorkspaceTemplate, workspaceID, version, now string) ([]FileMetadata, error) {
+	files := make([]FileMetadata, 0, len(tmpl.Files))
+	var copied []string
+	for _, src := range tmpl.Files {
+		dst := templateFile(src, workspaceID, version, now)
+		if dst.R2ObjectKey != "" {
+			if _, err := ac.r2().S3.CopyObject(ctx, &s3.CopyObjectInput{
+				Bucket:     aws.String(ac.R2BucketName),
+				CopySource: aws.String(ac.R2BucketName + "/" + url.PathEscape(src.R2ObjectKey)),
+				Key:        aws.String(dst.R2ObjectKey),
+			}); err != nil {
+				ac.discardTemplateObjects(ctx, workspaceID, copied)
+				return nil, fmt.Errorf("failed to copy template object %s: %w", src.R2ObjectKey, err)
+			}
+			copied = append(copied, dst.R2ObjectKey)
+		}
+		files = append(files, dst)
+	}
+	return files, nil
+}
+
+// discardTemplateObjects deletes objects copied for a workspace that was never created.
+// Deletions that fail are queued for the R2 deletion retry task.
+func (ac *ApiController) discardTemplateObjects(ctx context.Context, workspaceID string, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	if failed := ac.deleteR2Objects(ctx, keys); len(failed) > 0 {
+		ac.recordFailedR2Deletions(ctx, workspaceID, failed)
+	}
+}
+
+// ListWorkspaceTemplates lists the templates a workspace can be created from, by name.
+func (ac *ApiController) ListWorkspaceTemplates(c *gin.Context) {
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "ListWorkspaceTemplates", "user_id": c.GetString("userID")})
+
+	docs, err := ac.FirestoreClient.Collection(workspaceTemplatesCollection).OrderBy("name", docstore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to query workspace templates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace templates"})
+		return
+	}
+	templates := make([]WorkspaceTemplate, 0, len(docs))
+	for _, doc := range docs {
+		var tmpl WorkspaceTemplate
+		if err := doc.DataTo(&tmpl); err != nil {
+			logCtx.WithError(err).WithField("template_id", doc.Ref.ID).Warn("Failed to parse workspace template")
+			continue
+		}
+		tmpl.TemplateID = doc.Ref.ID
+		templates = append(templates, tmpl)
+	}
+	c.JSON(http.StatusOK, templates)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateWorkspaceTemplate(t *testing.T) {
+	valid := WorkspaceTemplate{Files: []WorkspaceTemplateFile{
+		{FilePath: "main.py", Type: "file", R2ObjectKey: "templates/python/main.py"},
+		{FilePath: "src", Type: "folder"},
+	}}
+	assert.NoError(t, validateWorkspaceTemplate(valid))
+
+	tests := map[string][]WorkspaceTemplateFile{
+		"duplicate path":   {{FilePath: "a", Type: "folder"}, {FilePath: "a", Type: "folder"}},
+		"empty path":       {{Type: "folder"}},
+		"outside prefix":   {{FilePath: "a.py", Type: "file", R2ObjectKey: "workspaces/other/files/x/a.py"}},
+		"unknown type":     {{FilePath: "a", Type: "symlink"}},
+		"file without key": {{FilePath: "a.py", Type: "file"}},
+	}
+	for name, files := range tests {
+		err := validateWorkspaceTemplate(WorkspaceTemplate{Files: files})
+		assert.ErrorIs(t, err, errTemplateInvalid, name)
+	}
+	tooMany := make([]WorkspaceTemplateFile, workspaceTemplateMaxFiles+1)
+	assert.ErrorIs(t, validateWorkspaceTemplate(WorkspaceTemplate{Files: tooMany}), errTemplateInvalid)
+}
+
+// seedTemplate stores a template whose file objects hold contents; files absent from
+// contents have no object.
+func seedTemplate(h *handlerHarness, files []WorkspaceTemplateFile, contents map[string]string) string {
+	h.t.Helper()
+	templateID := "tmpl-" + uuid.New().String()
+	for i := range files {
+		if files[i].Type != "file" {
+			continue
+		}
+		files[i].R2ObjectKey = workspaceTemplateObjectPrefix + templateID + "/" + files[i].FilePath
+		if content, ok := contents[files[i].FilePath]; ok {
+			files[i].Size = int64(len(content))
+			h.objects.put(files[i].R2ObjectKey, []byte(content))
+		}
+	}
+	_, err := h.fs.Collection(workspaceTemplatesCollection).Doc(templateID).Set(context.Background(), WorkspaceTemplate{
+		Name:     "Python starter " + templateID,
+		Language: "python",
+		Files:    files,
+	})
+	if err != nil {
+		h.t.Fatalf("seed template: %v", err)
+	}
+	return templateID
+}
+
+func TestHandlers_CreateWorkspaceFromTemplate(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	templateID := seedTemplate(h, []WorkspaceTemplateFile{
+		{FilePath: "main.py", Type: "file"},
+		{FilePath: "requirements.txt", Type: "file"},
+		{FilePath: "src", Type: "folder"},
+	}, map[string]string{"main.py": "print('hello')\n", "requirements.txt": "numpy\n"})
+
+	var templates []WorkspaceTemplate
+	w := h.do(http.MethodGet, "/api/workspace-templates", owner, nil, &templates)
+	assert.Equal(t, http.StatusOK, w.Code)
+	found := false
+	for _, tmpl := range templates {
+		if tmpl.TemplateID == templateID {
+			found = true
+			assert.Len(t, tmpl.Files, 3)
+		}
+	}
+	assert.True(t, found, "template is listed")
+	assert.NotContains(t, w.Body.String(), workspaceTemplateObjectPrefix, "object keys stay server-side")
+
+	var created CreateWorkspaceResponse
+	w = h.do(http.MethodPost, "/api/workspaces", owner, CreateWorkspaceRequest{Name: "From template", TemplateID: templateID}, &created)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "2", created.InitialVersion)
+
+	var manifest WorkspaceManifestResponse
+	w = h.do(http.MethodGet, "/api/workspaces/"+created.WorkspaceID+"/manifest", owner, nil, &manifest)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", manifest.WorkspaceVersion)
+	assert.Len(t, manifest.Manifest, 3)
+	for _, f := range manifest.Manifest {
+		if f.Type == "file" {
+			assert.True(t, strings.HasPrefix(f.R2ObjectKey, "workspaces/"+created.WorkspaceID+"/"), f.R2ObjectKey)
+			assert.True(t, h.objects.has(f.R2ObjectKey), "copied %s", f.FilePath)
+		}
+	}
+	ws, err := h.ac.loadWorkspace(context.Background(), created.WorkspaceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len("print('hello')\n")+len("numpy\n")), ws.StorageBytes)
+		assert.Equal(t, templateID, ws.TemplateID)
+	}
+}
+
+func TestHandlers_CreateWorkspaceFromBadTemplate(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+
+	w := h.do(http.MethodPost, "/api/workspaces", owner, CreateWorkspaceRequest{Name: "Nope", TemplateID: "missing-" + uuid.New().String()}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The second object is missing, so the first copy must be undone.
+	templateID := seedTemplate(h, []WorkspaceTemplateFile{
+		{FilePath: "main.py", Type: "file"},
+		{FilePath: "README.md", Type: "file"},
+	}, map[string]string{"main.py": "print(1)\n"})
+	objectsBefore := len(h.objects.objects)
+	w = h.do(http.MethodPost, "/api/workspaces", owner, CreateWorkspaceRequest{Name: "Half", TemplateID: templateID}, nil)
+	assert.Equal(t, http.StatusBadGateway, w.Code, w.Body.String())
+	assert.Equal(t, objectsBefore, len(h.objects.objects), "copied objects are removed")
+
+	h.objects.failOn("CopyObject", errors.New("r2 unavailable"))
+	w = h.do(http.MethodPost, "/api/workspaces", owner, CreateWorkspaceRequest{Name: "Down", TemplateID: templateID}, nil)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	h.objects.failOn("CopyObject", nil)
+
+	var listed []WorkspaceSummary
+	h.do(http.MethodGet, "/api/workspaces", owner, nil, &listed)
+	assert.Empty(t, listed, "no workspace is left behind")
+}
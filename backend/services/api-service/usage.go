@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	usageRollupsCollection = "usage_rollups"
+	usageChargesCollection = "usage_charges"
+
+	usageScopeUser      = "user"
+	usageScopeWorkspace = "workspace"
+)
+
+// usageMonth formats t as the YYYY-MM key used by monthly rollups.
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// usageRollupDocID returns the deterministic rollup document ID for a scope, subject and month.
+func usageRollupDocID(scope, subjectID, month string) string {
+	return fmt.Sprintf("%s_%s_%s", scope, subjectID, month)
+}
+
+// costUnitsFor converts execution time into billable cost units using the per-language weight.
+func (cfg *AppConfig) costUnitsFor(language string, executionMs int64) float64 {
+	weight, ok := cfg.LanguageCostWeights[language]
+	if !ok {
+		weight = 1.0
+	}
+	return float64(executionMs) * weight
+}
+
+// recordExecutionCost charges a finished job's execution time to the monthly rollups of
+// its user and workspace. The charge is keyed by job ID inside the same transaction as the
+// increments, so duplicate terminal callbacks for one job are counted once.
+// It returns false when the job had already been charged.
+func (ac *ApiController) recordExecutionCost(ctx context.Context, jobID string, job Job, executionMs int64, finishedAt time.Time) (bool, error) {
+	month := usageMonth(finishedAt)
+	costUnits := ac.AppConfig.costUnitsFor(job.Language, executionMs)
+	now := NowISO8601()
+	expiry := deleteAfter(ac.AppConfig.Retention.UsageCounters)
+
+	type rollupTarget struct {
+		scope     string
+		subjectID string
+	}
+	var targets []rollupTarget
+	if job.UserID != "" {
+		targets = append(targets, rollupTarget{usageScopeUser, job.UserID})
+	}
+	if job.WorkspaceID != "" {
+		targets = append(targets, rollupTarget{usageScopeWorkspace, job.WorkspaceID})
+	}
+	if len(targets) == 0 {
+		return false, nil // Anonymous public executions are not billed to anyone
+	}
+
+	chargeRef := ac.FirestoreClient.Collection(usageChargesCollection).Doc(jobID)
+	charged := false
+
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		charged = false
+		chargeSnap, err := tx.Get(chargeRef)
+		if err == nil && chargeSnap.Exists() {
+			return nil // Already charged by an earlier callback
+		}
+		if err != nil && status.Code(err) != codes.NotFound {
+			return fmt.Errorf("failed to read usage charge: %w", err)
+		}
+
+		if err := tx.Create(chargeRef, map[string]interface{}{
+			"job_id":       jobID,
+			"user_id":      job.UserID,
+			"workspace_id": job.WorkspaceID,
+			"language":     job.Language,
+			"month":        month,
+			"execution_ms": executionMs,
+			"cost_units":   costUnits,
+			"charged_at":   now,
+			"delete_after": expiry,
+		}); err != nil {
+			return fmt.Errorf("failed to create usage charge: %w", err)
+		}
+
+		for _, target := range targets {
+			rollupRef := ac.FirestoreClient.Collection(usageRollupsCollection).Doc(usageRollupDocID(target.scope, target.subjectID, month))
+			if err := tx.Set(rollupRef, map[string]interface{}{
+				"scope":        target.scope,
+				"subject_id":   target.subjectID,
+				"month":        month,
+				"execution_ms": docstore.Increment(executionMs),
+				"cost_units":   docstore.Increment(costUnits),
+				"job_count":    docstore.Increment(1),
+				"updated_at":   now,
+				"delete_after": expiry,
+			}, docstore.MergeAll); err != nil {
+				return fmt.Errorf("failed to increment %s usage rollup: %w", target.scope, err)
+			}
+		}
+		charged = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	log.WithFields(log.Fields{
+		"job_id":       jobID,
+		"month":        month,
+		"execution_ms": executionMs,
+		"cost_units":   costUnits,
+		"charged":      charged,
+	}).Info("Processed execution cost accounting.")
+	return charged, nil
+}
+
+// recordWorkspaceUsage merges fields (counter increments, the latest storage size) into the
+// workspace's rollup for the current month. Failures are logged and swallowed so usage
+// reporting never breaks the operation being counted.
+func (ac *ApiController) recordWorkspaceUsage(ctx context.Context, workspaceID string, fields map[string]interface{}) {
+	month := usageMonth(time.Now())
+	data := map[string]interface{}{
+		"scope":        usageScopeWorkspace,
+		"subject_id":   workspaceID,
+		"month":        month,
+		"updated_at":   NowISO8601(),
+		"delete_after": deleteAfter(ac.AppConfig.Retention.UsageCounters),
+	}
+	for k, v := range fields {
+		data[k] = v
+	}
+	rollupRef := ac.FirestoreClient.Collection(usageRollupsCollection).Doc(usageRollupDocID(usageScopeWorkspace, workspaceID, month))
+	if _, err := rollupRef.Set(ctx, data, docstore.MergeAll); err != nil {
+		log.WithError(err).WithFields(log.Fields{"workspace_id": workspaceID, "month": month}).Warn("Failed to record workspace usage.")
+	}
+}
+
+// getUsageRollup loads one monthly rollup, returning an empty rollup when none exists yet.
+func (ac *ApiController) getUsageRollup(ctx context.Context, scope, subjectID, month string) (UsageRollup, error) {
+	ref := ac.FirestoreClient.Collection(usageRollupsCollection).Doc(usageRollupDocID(scope, subjectID, month))
+	snap, err := getLiveDocument(ctx, ref)
+	if err != nil {
+		if isNotFound(err) {
+			return UsageRollup{Scope: scope, SubjectID: subjectID, Month: month}, nil
+		}
+		return UsageRollup{}, err
+	}
+	var rollup UsageRollup
+	if err := snap.DataTo(&rollup); err != nil {
+		return UsageRollup{}, fmt.Errorf("failed to parse usage rollup: %w", err)
+	}
+	return rollup, nil
+}
+
+// parseUsageMonth reads the month query parameter, defaulting to the current month.
+func parseUsageMonth(c *gin.Context) (string, bool) {
+	month := c.Query("month")
+	if month == "" {
+		return usageMonth(time.Now()), true
+	}
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return "", false
+	}
+	return month, true
+}
+
+// GetMyUsage returns the caller's execution cost rollup for a month (?month=YYYY-MM).
+func (ac *ApiController) GetMyUsage(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		log.Error("UserID not found in context for GetMyUsage")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User authentication required"})
+		return
+	}
+
+	month, ok := parseUsageMonth(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must be formatted as YYYY-MM"})
+		return
+	}
+
+	rollup, err := ac.getUsageRollup(c.Request.Context(), usageScopeUser, userID, month)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"user_id": userID, "month": month}).Error("Failed to load user usage rollup")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+	c.JSON(http.StatusOK, rollup)
+}
+
+// ListTopConsumers returns the highest-cost users or workspaces for a month.
+// Query params: month=YYYY-MM, scope=user|workspace (default user), limit (default 20, max 100).
+func (ac *ApiController) ListTopConsumers(c *gin.Context) {
+	month, ok := parseUsageMonth(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must be formatted as YYYY-MM"})
+		return
+	}
+
+	scope := c.DefaultQuery("scope", usageScopeUser)
+	if scope != usageScopeUser && scope != usageScopeWorkspace {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be 'user' or 'workspace'"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	logCtx := log.WithFields(log.Fields{
+		"handler": "ListTopConsumers",
+		"month":   month,
+		"scope":   scope,
+	})
+
+	iter := ac.FirestoreClient.Collection(usageRollupsCollection).
+		Where("scope", "==", scope).
+		Where("month", "==", month).
+		OrderBy("cost_units", docstore.Desc).
+		Limit(limit).
+		Documents(c.Request.Context())
+	defer iter.Stop()
+
+	consumers := make([]UsageRollup, 0, limit)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to query usage rollups")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage rollups"})
+			return
+		}
+		var rollup UsageRollup
+		if err := doc.DataTo(&rollup); err != nil {
+			logCtx.WithError(err).WithField("document_id", doc.Ref.ID).Warn("Failed to parse usage rollup")
+			continue
+		}
+		consumers = append(consumers, rollup)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"month":     month,
+		"scope":     scope,
+		"consumers": consumers,
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// seedRunningJob stores a workspace execution that started ranFor ago.
+func seedRunningJob(h *handlerHarness, userID, workspaceID string, ranFor time.Duration) string {
+	h.t.Helper()
+	jobID := uuid.New().String()
+	_, err := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(jobID).Set(context.Background(), Job{
+		Status:        "running",
+		Language:      "python",
+		SubmittedAt:   TimeToISO8601(time.Now().Add(-ranFor)),
+		StartedAt:     TimeToISO8601(time.Now().Add(-ranFor)),
+		UserID:        userID,
+		WorkspaceID:   workspaceID,
+		ExecutionType: executionTypeWorkspace,
+	})
+	if err != nil {
+		h.t.Fatalf("seed job: %v", err)
+	}
+	return jobID
+}
+
+func workspaceUsage(h *handlerHarness, workspaceID string) UsageRollup {
+	h.t.Helper()
+	rollup, err := h.ac.getUsageRollup(context.Background(), usageScopeWorkspace, workspaceID, usageMonth(time.Now()))
+	assert.NoError(h.t, err)
+	return rollup
+}
+
+func TestHandlers_JobFinishedChargesExecutionCostOnce(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	jobID := seedRunningJob(h, owner, workspaceID, time.Minute)
+
+	report := JobResultReport{Status: "completed", Output: "ok\n"}
+	w := h.do(http.MethodPost, "/internal/jobs/"+jobID+"/finished", "", report, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+	rollup := workspaceUsage(h, workspaceID)
+	assert.Equal(t, int64(1), rollup.JobCount)
+	assert.GreaterOrEqual(t, rollup.ExecutionMs, time.Minute.Milliseconds())
+	assert.Equal(t, float64(rollup.ExecutionMs), rollup.CostUnits)
+	userRollup, err := h.ac.getUsageRollup(context.Background(), usageScopeUser, owner, usageMonth(time.Now()))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), userRollup.JobCount)
+
+	// The worker retries its callback, with and without a body.
+	w = h.do(http.MethodPost, "/internal/jobs/"+jobID+"/finished", "", report, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = h.do(http.MethodPost, "/internal/jobs/"+jobID+"/finished", "", nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	assert.Equal(t, rollup.JobCount, workspaceUsage(h, workspaceID).JobCount)
+	assert.Equal(t, rollup.ExecutionMs, workspaceUsage(h, workspaceID).ExecutionMs)
+
+	var stats WorkspaceStats
+	w = h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/stats", owner, nil, &stats)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if assert.NotNil(t, stats.Usage) {
+		assert.Equal(t, int64(1), stats.Usage.JobCount)
+		assert.Equal(t, rollup.ExecutionMs, stats.Usage.ExecutionMs)
+	}
+}
+
+func TestHandlers_JobFinishedWithoutBodyChargesRunTime(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	jobID := seedRunningJob(h, owner, workspaceID, time.Minute)
+
+	// The worker finished the job in the job store; the API has not recorded its duration.
+	started := time.Now().Add(-2 * time.Minute).Truncate(time.Second)
+	_, err := h.ac.Jobs.Update(context.Background(), jobID, func(job *Job) (bool, error) {
+		job.Status, job.Output = "completed", "ok\n"
+		job.StartedAt, job.CompletedAt = TimeToISO8601(started), TimeToISO8601(started.Add(90*time.Second))
+		return true, nil
+	})
+	assert.NoError(t, err)
+
+	w := h.do(http.MethodPost, "/internal/jobs/"+jobID+"/finished", "", nil, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	rollup := workspaceUsage(h, workspaceID)
+	assert.Equal(t, int64(1), rollup.JobCount)
+	assert.Equal(t, (90 * time.Second).Milliseconds(), rollup.ExecutionMs)
+}
+
+func TestHandlers_JobFinishedRejectsUnfinishedJob(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	jobID := seedRunningJob(h, owner, workspaceID, time.Minute)
+
+	w := h.do(http.MethodPost, "/internal/jobs/"+jobID+"/finished", "", nil, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, int64(0), workspaceUsage(h, workspaceID).JobCount)
+}
+
+func TestHandlers_ListTopConsumersOrdersByCost(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	// Runs of many days outrank anything else charged this month.
+	ranFor := map[string]time.Duration{}
+	for _, days := range []int{300, 900, 600} {
+		workspaceID := h.createWorkspace(owner)
+		ranFor[workspaceID] = time.Duration(days) * 24 * time.Hour
+		jobID := seedRunningJob(h, owner, workspaceID, ranFor[workspaceID])
+		w := h.do(http.MethodPost, "/internal/jobs/"+jobID+"/finished", "", JobResultReport{Status: "completed"}, nil)
+		assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Consumers []UsageRollup `json:"consumers"`
+	}
+	w := h.do(http.MethodGet, "/api/admin/usage/top?scope=workspace&limit=3", "admin", nil, &resp)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	if !assert.Len(t, resp.Consumers, 3) {
+		return
+	}
+	for i, consumer := range resp.Consumers {
+		assert.Contains(t, ranFor, consumer.SubjectID)
+		if i > 0 {
+			assert.Greater(t, resp.Consumers[i-1].CostUnits, consumer.CostUnits)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/1liale/api-service/streaming"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+// usageExportMaxWindow bounds how much history one export scans.
+const usageExportMaxWindow = 92 * 24 * time.Hour
+
+// usageExportCSVHeader lists the export's columns. The last row is a summary whose cells
+// are label/value pairs instead.
+var usageExportCSVHeader = []string{"job_id", "user_id", "language", "submitted_at", "duration_ms", "status"}
+
+// parseUsageExportTime accepts an ISO 8601 timestamp or a YYYY-MM-DD date, taken as the
+// start of that UTC day.
+func parseUsageExportTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse(time.DateOnly, raw)
+}
+
+// parseUsageExportWindow reads the from (required) and to (default now) query params.
+func parseUsageExportWindow(c *gin.Context, now time.Time) (time.Time, time.Time, error) {
+	from, err := parseUsageExportTime(c.Query("from"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be an ISO 8601 timestamp or YYYY-MM-DD date")
+	}
+	to := now.UTC()
+	if raw := c.Query("to"); raw != "" {
+		if to, err = parseUsageExportTime(raw); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be an ISO 8601 timestamp or YYYY-MM-DD date")
+		}
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	if to.Sub(from) > usageExportMaxWindow {
+		return time.Time{}, time.Time{}, fmt.Errorf("the window must not exceed %d days", int(usageExportMaxWindow/(24*time.Hour)))
+	}
+	return from, to, nil
+}
+
+// usageExportRowFor returns a job's export row. Jobs without a recorded duration get none.
+func usageExportRowFor(jobID string, job Job) UsageExportRow {
+	row := UsageExportRow{
+		JobID:       jobID,
+		UserID:      job.UserID,
+		Language:    job.Language,
+		SubmittedAt: job.SubmittedAt,
+		Status:      job.Status,
+	}
+	if job.DurationMs > 0 {
+		duration := job.DurationMs
+		row.DurationMs = &duration
+	}
+	return row
+}
+
+// add counts a row towards the summary.
+func (s *UsageExportSummary) add(row UsageExportRow) {
+	s.Runs++
+	if row.DurationMs != nil {
+		s.ComputeSeconds += float64(*row.DurationMs) / 1000
+	}
+}
+
+// eachWorkspaceJobInWindow calls fn for every job of the workspace submitted in [from, to),
+// oldest first. Needs a composite index on workspace_id/submitted_at.
+func (ac *ApiController) eachWorkspaceJobInWindow(ctx context.Context, workspaceID string, from, to time.Time, fn func(UsageExportRow) error) error {
+	iter := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).
+		Where("workspace_id", "==", workspaceID).
+		Where("submitted_at", ">=", TimeToISO8601(from)).
+		Where("submitted_at", "<", TimeToISO8601(to)).
+		OrderBy("submitted_at", docstore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to query jobs: %w", err)
+		}
+		var job Job
+		if err := doc.DataTo(&job); err != nil {
+			log.WithError(err).WithField("job_id", doc.Ref.ID).Warn("Failed to parse job.")
+			continue
+		}
+		if err := fn(usageExportRowFor(doc.Ref.ID, job)); err != nil {
+			return err
+		}
+	}
+}
+
+// flushUsageExport pushes what has been written so far to the client when w supports it.
+func flushUsageExport(w io.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// writeUsageExportCSV writes one row per job produced by eachRow, then the summary row.
+// Rows are flushed every usageReportFlushRows so long windows stream.
+func writeUsageExportCSV(w io.Writer, eachRow func(func(UsageExportRow) error) error) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(usageExportCSVHeader); err != nil {
+		return err
+	}
+
+	var summary UsageExportSummary
+	err := eachRow(func(row UsageExportRow) error {
+		duration := ""
+		if row.DurationMs != nil {
+			duration = strconv.FormatInt(*row.DurationMs, 10)
+		}
+		if err := cw.Write([]string{
+			row.JobID,
+			csvSafeCell(row.UserID),
+			csvSafeCell(row.Language),
+			row.SubmittedAt,
+			duration,
+			csvSafeCell(row.Status),
+		}); err != nil {
+			return err
+		}
+		if summary.add(row); summary.Runs%usageReportFlushRows == 0 {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return flushUsageExport(w)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := cw.Write([]string{
+		"total_runs", strconv.FormatInt(summary.Runs, 10),
+		"total_compute_seconds", strconv.FormatFloat(summary.ComputeSeconds, 'f', 3, 64),
+		"", "",
+	}); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeUsageExportJSON writes {"workspaceId", "from", "to", "jobs": [...], "summary"} one
+// job at a time, so the jobs are never held in memory together.
+func writeUsageExportJSON(w io.Writer, workspaceID string, from, to time.Time, eachRow func(func(UsageExportRow) error) error) error {
+	head, err := json.Marshal(gin.H{"workspaceId": workspaceID, "from": TimeToISO8601(from), "to": TimeToISO8601(to)})
+	if err != nil {
+		return err
+	}
+	// Reopen the object to append the streamed fields.
+	if _, err := fmt.Fprintf(w, "%s,\"jobs\":[", head[:len(head)-1]); err != nil {
+		return err
+	}
+
+	var summary UsageExportSummary
+	err = eachRow(func(row UsageExportRow) error {
+		b, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if summary.Runs > 0 {
+			b = append([]byte{','}, b...)
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		if summary.add(row); summary.Runs%usageReportFlushRows == 0 {
+			return flushUsageExport(w)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tail, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "],\"summary\":%s}\n", tail)
+	return err
+}
+
+// ExportWorkspaceUsage streams a workspace's jobs submitted in a window, for billing.
+// Query params: from (required) and to (default now), each an ISO 8601 timestamp or a
+// YYYY-MM-DD date; the window is at most usageExportMaxWindow. The response is CSV, or
+// JSON when the Accept header prefers it. Owners only.
+func (ac *ApiController) ExportWorkspaceUsage(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "ExportWorkspaceUsage"})
+
+	from, to, err := parseUsageExportWindow(c, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+
+	asJSON := c.NegotiateFormat("text/csv", gin.MIMEJSON) == gin.MIMEJSON
+	if asJSON {
+		c.Header("Content-Type", "application/json; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+			fmt.Sprintf("usage-%s-%s-%s.csv", workspaceID, from.Format(time.DateOnly), to.Format(time.DateOnly))))
+	}
+	stream := streaming.Start(ctx, c.Writer, ac.AppConfig.Streaming, ac.streams)
+	eachRow := func(fn func(UsageExportRow) error) error {
+		return ac.eachWorkspaceJobInWindow(stream.Context(), workspaceID, from, to, fn)
+	}
+	if asJSON {
+		err = writeUsageExportJSON(stream, workspaceID, from, to, eachRow)
+	} else {
+		err = writeUsageExportCSV(stream, eachRow)
+	}
+	reason := stream.Close(err)
+	if err != nil {
+		// Headers are already sent; the client sees a truncated export.
+		logCtx.WithError(err).WithField("stream_end", reason).Error("Usage export failed mid-stream.")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func usageExportRows(rows ...UsageExportRow) func(func(UsageExportRow) error) error {
+	return func(fn func(UsageExportRow) error) error {
+		for _, row := range rows {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestParseUsageExportWindow(t *testing.T) {
+	now := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	parse := func(query string) (time.Time, time.Time, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/export?"+query, nil)
+		return parseUsageExportWindow(c, now)
+	}
+
+	from, to, err := parse("from=2025-06-01")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, now, to, "to defaults to now")
+
+	from, to, err = parse("from=2025-03-01T00:00:00Z&to=2025-06-01")
+	assert.NoError(t, err)
+	assert.Equal(t, 92*24*time.Hour, to.Sub(from), "exactly the maximum window is allowed")
+
+	_, _, err = parse("from=2025-03-01&to=2025-06-02")
+	assert.Error(t, err, "longer than the maximum window")
+	_, _, err = parse("to=2025-06-02")
+	assert.Error(t, err, "from is required")
+	_, _, err = parse("from=2025-06-02&to=2025-06-01")
+	assert.Error(t, err)
+	_, _, err = parse("from=June")
+	assert.Error(t, err)
+}
+
+func TestWriteUsageExportCSV(t *testing.T) {
+	duration := int64(1500)
+	rows := usageExportRows(
+		UsageExportRow{JobID: "job-1", UserID: "=cmd", Language: "python", SubmittedAt: "2025-06-01T10:00:00.000Z", DurationMs: &duration, Status: "completed"},
+		UsageExportRow{JobID: "job-2", UserID: "bob", Language: "rust", SubmittedAt: "2025-06-02T10:00:00.000Z", Status: "queued"},
+	)
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeUsageExportCSV(&buf, rows))
+	assert.Equal(t, "job_id,user_id,language,submitted_at,duration_ms,status\n"+
+		"job-1,'=cmd,python,2025-06-01T10:00:00.000Z,1500,completed\n"+
+		"job-2,bob,rust,2025-06-02T10:00:00.000Z,,queued\n"+
+		"total_runs,2,total_compute_seconds,1.500,,\n", buf.String())
+}
+
+func TestWriteUsageExportJSON(t *testing.T) {
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	duration := int64(250)
+	var buf bytes.Buffer
+	assert.NoError(t, writeUsageExportJSON(&buf, "ws-1", from, from.Add(24*time.Hour), usageExportRows(
+		UsageExportRow{JobID: "job-1", DurationMs: &duration},
+		UsageExportRow{JobID: "job-2"},
+	)))
+
+	var resp struct {
+		WorkspaceID string             `json:"workspaceId"`
+		From        string             `json:"from"`
+		Jobs        []UsageExportRow   `json:"jobs"`
+		Summary     UsageExportSummary `json:"summary"`
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &resp), buf.String())
+	assert.Equal(t, "ws-1", resp.WorkspaceID)
+	assert.Equal(t, "2025-06-01T00:00:00.000Z", resp.From)
+	assert.Len(t, resp.Jobs, 2)
+	assert.Nil(t, resp.Jobs[1].DurationMs)
+	assert.Equal(t, UsageExportSummary{Runs: 2, ComputeSeconds: 0.25}, resp.Summary)
+
+	buf.Reset()
+	assert.NoError(t, writeUsageExportJSON(&buf, "ws-1", from, from.Add(time.Hour), usageExportRows()))
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &resp), "an empty export is valid JSON")
+}
+
+func TestHandlers_ExportWorkspaceUsage(t *testing.T) {
+	h := newHandlerHarness(t)
+	ctx := context.Background()
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print(1)")
+
+	var first, second ExecuteAuthResponse
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, &first))
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, &second))
+	_, err := h.ac.Jobs.Update(ctx, first.JobID, func(job *Job) (bool, error) {
+		job.Status, job.DurationMs = "completed", 2000
+		return true, nil
+	})
+	assert.NoError(t, err)
+
+	from := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	path := "/api/workspaces/" + workspaceID + "/usage/export?from=" + from
+
+	w := h.do(http.MethodGet, path, "stranger-"+uuid.New().String(), nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/usage/export?from=2020-01-01", owner, nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the window is too long")
+
+	w = h.do(http.MethodGet, path, owner, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.True(t, strings.HasPrefix(lines[1], first.JobID+","+owner+",python,"))
+	assert.True(t, strings.HasSuffix(lines[1], ",2000,completed"))
+	assert.True(t, strings.HasSuffix(lines[2], ",,queued"), "a job without a duration has an empty cell")
+	assert.Equal(t, "total_runs,2,total_compute_seconds,2.000,,", lines[3])
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set(testUserHeader, owner)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	h.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Jobs    []UsageExportRow   `json:"jobs"`
+		Summary UsageExportSummary `json:"summary"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Jobs, 2)
+	assert.Equal(t, UsageExportSummary{Runs: 2, ComputeSeconds: 2}, resp.Summary)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/1liale/api-service/streaming"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+// usageReportCSVHeader lists the report's columns. Each row is one category; columns that do
+// not apply to it are left empty.
+var usageReportCSVHeader = []string{"month", "category", "user_id", "language", "count", "execution_ms", "cost_units", "bytes"}
+
+// usageReportFlushRows is how many execution rows are buffered before flushing to the client.
+const usageReportFlushRows = 100
+
+// executionUsageGrouper sums usage charges into one row per user and language. Charges
+// must arrive ordered by user, then language, so each row is complete once the key changes
+// and the report never holds more than one row in memory.
+type executionUsageGrouper struct {
+	current *UsageReportExecutionRow
+}
+
+// add folds a charge in and returns the previous row when the charge starts a new one.
+func (g *executionUsageGrouper) add(charge UsageCharge) (UsageReportExecutionRow, bool) {
+	var done UsageReportExecutionRow
+	finished := false
+	if g.current != nil && (g.current.UserID != charge.UserID || g.current.Language != charge.Language) {
+		done, finished = *g.current, true
+		g.current = nil
+	}
+	if g.current == nil {
+		g.current = &UsageReportExecutionRow{UserID: charge.UserID, Language: charge.Language}
+	}
+	g.current.JobCount++
+	g.current.ExecutionMs += charge.ExecutionMs
+	g.current.CostUnits += charge.CostUnits
+	return done, finished
+}
+
+// flush returns the last row, if any.
+func (g *executionUsageGrouper) flush() (UsageReportExecutionRow, bool) {
+	if g.current == nil {
+		return UsageReportExecutionRow{}, false
+	}
+	done := *g.current
+	g.current = nil
+	return done, true
+}
+
+// eachExecutionUsage calls fn for every user and language with executions charged to the
+// workspace in month. Needs a composite index on workspace_id/month/user_id/language.
+func (ac *ApiController) eachExecutionUsage(ctx context.Context, workspaceID, month string, fn func(UsageReportExecutionRow) error) error {
+	iter := ac.FirestoreClient.Collection(usageChargesCollection).
+		Where("workspace_id", "==", workspaceID).
+		Where("month", "==", month).
+		OrderBy("user_id", docstore.Asc).
+		OrderBy("language", docstore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	var grouper executionUsageGrouper
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to query usage charges: %w", err)
+		}
+		var charge UsageCharge
+		if err := doc.DataTo(&charge); err != nil {
+			log.WithError(err).WithField("job_id", doc.Ref.ID).Warn("Failed to parse usage charge.")
+			continue
+		}
+		if row, ok := grouper.add(charge); ok {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+	}
+	if row, ok := grouper.flush(); ok {
+		return fn(row)
+	}
+	return nil
+}
+
+// csvSafeCell stops spreadsheet apps from evaluating a text cell as a formula.
+func csvSafeCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// writeUsageReportCSV writes the report's summary rows, then one row per user and language
+// produced by eachRow. Rows are flushed as they are written so long months stream.
+func writeUsageReportCSV(w io.Writer, report WorkspaceUsageReport, eachRow func(func(UsageReportExecutionRow) error) error) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(usageReportCSVHeader); err != nil {
+		return err
+	}
+
+	storage := ""
+	if report.StorageBytes != nil {
+		storage = strconv.FormatInt(*report.StorageBytes, 10)
+	}
+	summary := [][]string{
+		{report.Month, "storage", "", "", "", "", "", storage},
+		{report.Month, "sync_commits", "", "", strconv.FormatInt(report.SyncCommits, 10), "", "", ""},
+		{report.Month, "rag_queries", "", "", strconv.FormatInt(report.RagQueries, 10), "", "", ""},
+	}
+	if err := cw.WriteAll(summary); err != nil {
+		return err
+	}
+
+	written := 0
+	err := eachRow(func(row UsageReportExecutionRow) error {
+		if err := cw.Write([]string{
+			report.Month,
+			"executions",
+			csvSafeCell(row.UserID),
+			csvSafeCell(row.Language),
+			strconv.FormatInt(row.JobCount, 10),
+			strconv.FormatInt(row.ExecutionMs, 10),
+			strconv.FormatFloat(row.CostUnits, 'f', -1, 64),
+			"",
+		}); err != nil {
+			return err
+		}
+		if written++; written%usageReportFlushRows == 0 {
+			cw.Flush()
+			return cw.Error()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// GetWorkspaceUsageReport returns one month of a workspace's usage for chargeback.
+// Query params: month=YYYY-MM (default current month; one month per call), format=csv|json
+// (default csv). Owners only. Storage is the size after the month's last commit, or the
+// current size for the current month when nothing was committed yet.
+func (ac *ApiController) GetWorkspaceUsageReport(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "GetWorkspaceUsageReport"})
+
+	month, ok := parseUsageMonth(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must be formatted as YYYY-MM"})
+		return
+	}
+	currentMonth := usageMonth(time.Now())
+	if month > currentMonth {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must not be in the future"})
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be \"csv\" or \"json\""})
+		return
+	}
+
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+
+	rollup, err := ac.getUsageRollup(ctx, usageScopeWorkspace, workspaceID, month)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load workspace usage rollup.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+	report := WorkspaceUsageReport{
+		WorkspaceID:  workspaceID,
+		Month:        month,
+		RagQueries:   rollup.RagQueryCount,
+		SyncCommits:  rollup.SyncCommitCount,
+		StorageBytes: rollup.StorageBytes,
+		GeneratedAt:  NowISO8601(),
+	}
+	if report.StorageBytes == nil && month == currentMonth {
+		workspace, err := ac.loadWorkspace(ctx, workspaceID)
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to load workspace for usage report.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+			return
+		}
+		report.StorageBytes = &workspace.StorageBytes
+	}
+
+	if format == "json" {
+		report.Executions = []UsageReportExecutionRow{}
+		err := ac.eachExecutionUsage(ctx, workspaceID, month, func(row UsageReportExecutionRow) error {
+			report.Executions = append(report.Executions, row)
+			return nil
+		})
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to build usage report.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("usage-%s-%s.csv", workspaceID, month)))
+	stream := streaming.Start(ctx, c.Writer, ac.AppConfig.Streaming, ac.streams)
+	err = writeUsageReportCSV(stream, report, func(fn func(UsageReportExecutionRow) error) error {
+		return ac.eachExecutionUsage(stream.Context(), workspaceID, month, fn)
+	})
+	reason := stream.Close(err)
+	if err != nil {
+		// Headers are already sent; the client sees a truncated file.
+		logCtx.WithError(err).WithField("stream_end", reason).Error("Usage report failed mid-stream.")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutionUsageGrouper(t *testing.T) {
+	var g executionUsageGrouper
+	var rows []UsageReportExecutionRow
+	for _, charge := range []UsageCharge{
+		{UserID: "alice", Language: "python", ExecutionMs: 100, CostUnits: 100},
+		{UserID: "alice", Language: "python", ExecutionMs: 50, CostUnits: 50},
+		{UserID: "alice", Language: "rust", ExecutionMs: 10, CostUnits: 25},
+		{UserID: "bob", Language: "rust", ExecutionMs: 20, CostUnits: 50},
+	} {
+		if row, ok := g.add(charge); ok {
+			rows = append(rows, row)
+		}
+	}
+	if row, ok := g.flush(); ok {
+		rows = append(rows, row)
+	}
+
+	assert.Equal(t, []UsageReportExecutionRow{
+		{UserID: "alice", Language: "python", JobCount: 2, ExecutionMs: 150, CostUnits: 150},
+		{UserID: "alice", Language: "rust", JobCount: 1, ExecutionMs: 10, CostUnits: 25},
+		{UserID: "bob", Language: "rust", JobCount: 1, ExecutionMs: 20, CostUnits: 50},
+	}, rows)
+
+	_, ok := g.flush()
+	assert.False(t, ok, "flush empties the grouper")
+}
+
+func TestCSVSafeCell(t *testing.T) {
+	assert.Equal(t, "alice", csvSafeCell("alice"))
+	assert.Equal(t, "'=HYPERLINK(\"x\")", csvSafeCell("=HYPERLINK(\"x\")"))
+	assert.Equal(t, "'-1", csvSafeCell("-1"))
+	assert.Equal(t, "'@sum", csvSafeCell("@sum"))
+	assert.Equal(t, "", csvSafeCell(""))
+}
+
+func TestWriteUsageReportCSV(t *testing.T) {
+	storage := int64(2048)
+	report := WorkspaceUsageReport{Month: "2025-06", RagQueries: 3, SyncCommits: 7, StorageBytes: &storage}
+	rows := func(fn func(UsageReportExecutionRow) error) error {
+		if err := fn(UsageReportExecutionRow{UserID: "a,b", Language: "python", JobCount: 2, ExecutionMs: 150, CostUnits: 1.5}); err != nil {
+			return err
+		}
+		return fn(UsageReportExecutionRow{UserID: "=cmd", Language: "rust", JobCount: 1, ExecutionMs: 10, CostUnits: 25})
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeUsageReportCSV(&buf, report, rows))
+	assert.Equal(t, "month,category,user_id,language,count,execution_ms,cost_units,bytes\n"+
+		"2025-06,storage,,,,,,2048\n"+
+		"2025-06,sync_commits,,,7,,,\n"+
+		"2025-06,rag_queries,,,3,,,\n"+
+		"2025-06,executions,\"a,b\",python,2,150,1.5,\n"+
+		"2025-06,executions,'=cmd,rust,1,10,25,\n", buf.String())
+}
+
+func TestWriteUsageReportCSVUnknownStorageAndErrors(t *testing.T) {
+	boom := errors.New("query failed")
+	var buf bytes.Buffer
+	err := writeUsageReportCSV(&buf, WorkspaceUsageReport{Month: "2025-05"}, func(func(UsageReportExecutionRow) error) error {
+		return boom
+	})
+	assert.ErrorIs(t, err, boom)
+
+	buf.Reset()
+	assert.NoError(t, writeUsageReportCSV(&buf, WorkspaceUsageReport{Month: "2025-05"}, func(func(UsageReportExecutionRow) error) error {
+		return nil
+	}))
+	assert.Contains(t, buf.String(), "2025-05,storage,,,,,,\n")
+}
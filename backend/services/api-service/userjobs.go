@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+// workspaceNames reads the names of the given workspaces in one batched read. Workspaces
+// that no longer exist are left out. Names are read at request time rather than copied onto
+// jobs, so renamed workspaces show their current name and old jobs need no backfill.
+func (ac *ApiController) workspaceNames(ctx context.Context, workspaceIDs []string) (map[string]string, error) {
+	names := make(map[string]string, len(workspaceIDs))
+	if len(workspaceIDs) == 0 {
+		return names, nil
+	}
+	refs := make([]*docstore.DocumentRef, len(workspaceIDs))
+	for i, id := range workspaceIDs {
+		refs[i] = ac.FirestoreClient.Collection("workspaces").Doc(id)
+	}
+	snaps, err := ac.FirestoreClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspaces: %w", err)
+	}
+	for i, snap := range snaps {
+		if !snap.Exists() {
+			continue
+		}
+		var ws Workspace
+		if err := snap.DataTo(&ws); err != nil {
+			return nil, fmt.Errorf("failed to parse workspace %s: %w", workspaceIDs[i], err)
+		}
+		names[workspaceIDs[i]] = ws.Name
+	}
+	return names, nil
+}
+
+// ListMyJobs lists the workspace executions the caller submitted, across all workspaces,
+// newest first. Public executions carry no user and never appear.
+// Query params: status (as for ListWorkspaceJobs), workspaceId, limit (default 25, max 100),
+// cursor (the opaque nextCursor value from the previous page).
+// Firestore needs composite indexes on user_id/execution_type/submitted_at/__name__, with
+// each combination of workspace_id and status.
+func (ac *ApiController) ListMyJobs(c *gin.Context) {
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "ListMyJobs", "user_id": userID})
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "25"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	status := c.Query("status")
+	statuses, ok := workspaceJobStatusFilters[status]
+	if status != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of queued, scheduled, running, completed, failed, cancelled"})
+		return
+	}
+	var cursor *workspaceJobsCursor
+	if token := c.Query("cursor"); token != "" {
+		decoded, err := decodeWorkspaceJobsCursor(token)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		cursor = &decoded
+	}
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	q := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).
+		Where("user_id", "==", userID).
+		Where("execution_type", "==", executionTypeWorkspace)
+	if workspaceID := c.Query("workspaceId"); workspaceID != "" {
+		q = q.Where("workspace_id", "==", workspaceID)
+	}
+	if len(statuses) == 1 {
+		q = q.Where("status", "==", statuses[0])
+	} else if len(statuses) > 1 {
+		q = q.Where("status", "in", statuses)
+	}
+	q = q.OrderBy("submitted_at", docstore.Desc).OrderBy(docstore.DocumentID, docstore.Desc)
+	if cursor != nil {
+		q = q.StartAfter(cursor.SubmittedAt, cursor.JobID)
+	}
+
+	iter := q.Limit(limit).Documents(ctx)
+	defer iter.Stop()
+
+	jobs := make([]UserJobSummary, 0, limit)
+	var workspaceIDs []string
+	seen := make(map[string]bool)
+	read := 0
+	var last workspaceJobsCursor
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			logCtx.WithError(err).Error("Failed to query jobs")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load jobs"})
+			return
+		}
+		read++
+		var job Job
+		if err := doc.DataTo(&job); err != nil {
+			logCtx.WithError(err).WithField("job_id", doc.Ref.ID).Warn("Failed to parse job")
+			continue
+		}
+		last = workspaceJobsCursor{SubmittedAt: job.SubmittedAt, JobID: doc.Ref.ID}
+		if isPastDeleteAfter(job.DeleteAfter) || job.WorkspaceID == "" {
+			continue
+		}
+		if !seen[job.WorkspaceID] {
+			seen[job.WorkspaceID] = true
+			workspaceIDs = append(workspaceIDs, job.WorkspaceID)
+		}
+		jobs = append(jobs, UserJobSummary{WorkspaceJobSummary: workspaceJobSummary(doc.Ref.ID, job), WorkspaceID: job.WorkspaceID})
+	}
+
+	names, err := ac.workspaceNames(ctx, workspaceIDs)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load workspace names")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load jobs"})
+		return
+	}
+	for i := range jobs {
+		jobs[i].WorkspaceName = names[jobs[i].WorkspaceID]
+	}
+
+	// The cursor follows the last document read, so skipped expired jobs do not end paging early.
+	response := gin.H{"jobs": jobs}
+	if read == limit && last.JobID != "" {
+		response["nextCursor"] = encodeWorkspaceJobsCursor(last)
+	}
+	c.JSON(http.StatusOK, response)
+}
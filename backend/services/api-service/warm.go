@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// WarmStats counts warm-up requests so we can tell whether pre-warming actually helps.
+type WarmStats struct {
+	Requested int64 `json:"requested"`
+	Enqueued  int64 `json:"enqueued"`
+	Throttled int64 `json:"throttled"`
+	Failed    int64 `json:"failed"`
+}
+
+// warmTracker rate-limits warm-up pings to one per interval per workspace.
+// State is per instance, which is fine: an extra ping only costs a no-op task.
+type warmTracker struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+
+	requested atomic.Int64
+	enqueued  atomic.Int64
+	throttled atomic.Int64
+	failed    atomic.Int64
+}
+
+// loadWarmInterval reads WARM_INTERVAL_SECONDS. A zero or negative interval would turn off
+// throttling entirely, so it is rejected.
+func loadWarmInterval() (time.Duration, error) {
+	seconds, err := getEnvInt("WARM_INTERVAL_SECONDS", 300)
+	if err != nil {
+		return 0, err
+	}
+	if seconds < 1 {
+		return 0, fmt.Errorf("WARM_INTERVAL_SECONDS must be positive")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func newWarmTracker(interval time.Duration) *warmTracker {
+	return &warmTracker{
+		interval: interval,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// allow reports whether a warm-up may be sent for key now, and if so records it.
+func (wt *warmTracker) allow(key string, now time.Time) bool {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	if last, ok := wt.last[key]; ok && now.Sub(last) < wt.interval {
+		return false
+	}
+	wt.last[key] = now
+
+	// Opportunistically drop stale entries so the map stays bounded by recent activity.
+	if len(wt.last) > 1000 {
+		for k, t := range wt.last {
+			if now.Sub(t) >= wt.interval {
+				delete(wt.last, k)
+			}
+		}
+	}
+	return true
+}
+
+// Stats returns the current warm-up counters.
+func (wt *warmTracker) Stats() WarmStats {
+	return WarmStats{
+		Requested: wt.requested.Load(),
+		Enqueued:  wt.enqueued.Load(),
+		Throttled: wt.throttled.Load(),
+		Failed:    wt.failed.Load(),
+	}
+}
+
+// WarmExecutionEnvironment enqueues a no-op task to the language's worker queue so Cloud Run
+// has an instance ready before the first real run. No job document is created, so warm-ups
+// never count against execution quotas.
+func (ac *ApiController) WarmExecutionEnvironment(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+
+	logCtx := log.WithFields(log.Fields{
+		"workspace_id": workspaceID,
+		"user_id":      userID,
+		"handler":      "WarmExecutionEnvironment",
+	})
+
+	ctx := c.Request.Context()
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleEditor, logCtx) == nil {
+		return
+	}
+
+	var req WarmRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+
+	worker, ok := ac.Services.WorkerFor(req.Language)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported language: %s", req.Language)})
+		return
+	}
+
+	ac.warm.requested.Add(1)
+	if !ac.warm.allow(workspaceID+"|"+req.Language, time.Now()) {
+		ac.warm.throttled.Add(1)
+		c.JSON(http.StatusOK, gin.H{"warmed": false, "reason": "recently_warmed"})
+		return
+	}
+
+	warmID := "warm-" + uuid.New().String()
+	payload := CloudTaskAuthPayload{
+		JobID:        warmID,
+		WorkspaceID:  workspaceID,
+		Language:     req.Language,
+		R2BucketName: ac.R2BucketName,
+		Files:        []WorkerFile{},
+		Warm:         true,
+	}
+
+	queuePath := ac.AppConfig.GetQueuePath(worker.QueueID)
+	if _, err := ac.enqueueTask(ctx, queuePath, fmt.Sprintf("%s/execute_auth", worker.ServiceURL), worker.ServiceAccount, payload); err != nil {
+		ac.warm.failed.Add(1)
+		logCtx.WithError(err).Error("Failed to enqueue warm-up task")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to warm execution environment"})
+		return
+	}
+
+	ac.warm.enqueued.Add(1)
+	logCtx.WithFields(log.Fields{"warm_id": warmID, "language": req.Language}).Info("Warm-up task enqueued.")
+	c.JSON(http.StatusAccepted, gin.H{"warmed": true})
+}
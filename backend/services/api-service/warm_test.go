@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadWarmInterval(t *testing.T) {
+	interval, err := loadWarmInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, interval, "unset falls back to the default")
+
+	t.Setenv("WARM_INTERVAL_SECONDS", "60")
+	interval, err = loadWarmInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, interval)
+
+	for _, value := range []string{"0", "-5", "soon"} {
+		t.Setenv("WARM_INTERVAL_SECONDS", value)
+		_, err = loadWarmInterval()
+		assert.Error(t, err, value)
+	}
+}
+
+func TestWarmTracker_ThrottlesPerKey(t *testing.T) {
+	wt := newWarmTracker(time.Minute)
+	now := time.Now()
+
+	assert.True(t, wt.allow("ws-1|python", now))
+	assert.False(t, wt.allow("ws-1|python", now.Add(30*time.Second)))
+	assert.True(t, wt.allow("ws-1|node", now), "languages are throttled separately")
+	assert.True(t, wt.allow("ws-2|python", now), "workspaces are throttled separately")
+	assert.True(t, wt.allow("ws-1|python", now.Add(time.Minute)), "the interval has passed")
+}
+
+func TestHandlers_WarmExecutionEnvironment(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	first, second := h.createWorkspace(owner), h.createWorkspace(owner)
+	warm := func(workspaceID, language string) (int, map[string]interface{}) {
+		var resp map[string]interface{}
+		w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/execute/warm", owner, WarmRequest{Language: language}, &resp)
+		return w.Code, resp
+	}
+
+	code, resp := warm(first, "python")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, true, resp["warmed"])
+	code, resp = warm(first, "python")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, resp["warmed"])
+	assert.Equal(t, "recently_warmed", resp["reason"])
+	code, _ = warm(second, "python")
+	assert.Equal(t, http.StatusAccepted, code, "another workspace is not throttled")
+	code, _ = warm(first, "cobol")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, "/api/workspaces/"+first+"/execute/warm", "stranger-"+uuid.New().String(), WarmRequest{Language: "python"}, nil).Code)
+
+	bodies := h.tasks.tasksTo("/execute_auth")
+	if assert.Len(t, bodies, 2) {
+		for _, body := range bodies {
+			var payload CloudTaskAuthPayload
+			assert.NoError(t, json.Unmarshal(body, &payload))
+			assert.True(t, payload.Warm)
+			assert.Empty(t, payload.Files)
+		}
+	}
+	assert.Equal(t, WarmStats{Requested: 3, Enqueued: 2, Throttled: 1}, h.ac.warm.Stats())
+}
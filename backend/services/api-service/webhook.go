@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers sent with every outgoing webhook. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the receiver's secret, prefixed with "sha256=".
+const (
+	webhookEventHeader     = "X-Apeiron-Event"
+	webhookTimestampHeader = "X-Apeiron-Timestamp"
+	webhookSignatureHeader = "X-Apeiron-Signature"
+)
+
+// WebhookDelivery records one delivery attempt; it is stored on the source document so
+// users can debug their receiver.
+type WebhookDelivery struct {
+	AttemptedAt string `json:"attemptedAt" firestore:"attempted_at"` // ISO 8601 string
+	Success     bool   `json:"success" firestore:"success"`
+	StatusCode  int    `json:"statusCode,omitempty" firestore:"status_code,omitempty"`
+	Error       string `json:"error,omitempty" firestore:"error,omitempty"`
+	DurationMs  int64  `json:"durationMs" firestore:"duration_ms"`
+}
+
+// webhookSender delivers signed webhook POSTs while refusing internal addresses.
+type webhookSender struct {
+	client *http.Client
+}
+
+// newWebhookSender builds a sender that reaches only addresses permitted by guard.
+func newWebhookSender(guard *outboundGuard, timeout time.Duration) *webhookSender {
+	return &webhookSender{client: guard.Client(timeout)}
+}
+
+// signWebhook returns the signature header value for body sent at timestamp.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver POSTs payload as JSON to target, signed with secret, and reports the outcome.
+// Any 2xx response counts as delivered; redirects are not followed.
+func (ws *webhookSender) Deliver(ctx context.Context, target, secret, event string, payload interface{}) (delivery WebhookDelivery) {
+	delivery.AttemptedAt = NowISO8601()
+	start := time.Now()
+	defer func() { delivery.DurationMs = time.Since(start).Milliseconds() }()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		delivery.Error = fmt.Sprintf("failed to marshal payload: %v", err)
+		return delivery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = fmt.Sprintf("invalid callback URL: %v", err)
+		return delivery
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ApeironDev-Webhooks/1.0")
+	req.Header.Set(webhookEventHeader, event)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(secret, timestamp, body))
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = fmt.Sprintf("receiver responded with HTTP %d", resp.StatusCode)
+	}
+	return delivery
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignWebhook(t *testing.T) {
+	// echo -n '1700000000.{"a":1}' | openssl dgst -sha256 -hmac secret
+	got := signWebhook("secret", "1700000000", []byte(`{"a":1}`))
+	assert.Equal(t, "sha256=49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686", got)
+	assert.NotEqual(t, got, signWebhook("other", "1700000000", []byte(`{"a":1}`)))
+}
+
+func TestJobFinishedWebhook_TruncatesOutput(t *testing.T) {
+	job := Job{Status: "completed", Output: strings.Repeat("é", maxCallbackOutputBytes)}
+	body := jobFinishedWebhook("job-1", job)
+
+	assert.True(t, body.OutputTruncated)
+	assert.LessOrEqual(t, len(body.Output), maxCallbackOutputBytes)
+	assert.True(t, strings.HasSuffix(body.Output, "é"), "truncation keeps whole runes")
+
+	full := sha256.Sum256([]byte(job.Output))
+	assert.Equal(t, hex.EncodeToString(full[:]), body.OutputSHA256, "the hash covers the full output")
+
+	body = jobFinishedWebhook("job-2", Job{Status: "failed", Output: "short"})
+	assert.False(t, body.OutputTruncated)
+	assert.Equal(t, "short", body.Output)
+}
+
+func TestRetryableDelivery(t *testing.T) {
+	assert.False(t, retryableDelivery(WebhookDelivery{Success: true, StatusCode: 200}))
+	assert.True(t, retryableDelivery(WebhookDelivery{Error: "connection refused"}))
+	assert.True(t, retryableDelivery(WebhookDelivery{StatusCode: 429}))
+	assert.True(t, retryableDelivery(WebhookDelivery{StatusCode: 503}))
+	assert.False(t, retryableDelivery(WebhookDelivery{StatusCode: 404}), "the receiver rejected the callback")
+}
+
+func TestDeliverJobCallback_Retries(t *testing.T) {
+	defer func(delays []time.Duration) { callbackRetryDelays = delays }(callbackRetryDelays)
+	callbackRetryDelays = []time.Duration{0, 0, 0}
+
+	var calls atomic.Int32
+	var failWith atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// Signed with the deployment secret, as the job brought none of its own.
+		assert.Equal(t, signWebhook("deployment-secret", r.Header.Get(webhookTimestampHeader), body), r.Header.Get(webhookSignatureHeader))
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(int(failWith.Load()))
+		}
+	}))
+	defer server.Close()
+
+	_, ac, _ := newEmbeddedHarness(t)
+	ac.AppConfig.WebhookSigningSecret = "deployment-secret"
+	ac.webhooks = &webhookSender{client: server.Client()}
+	ctx := context.Background()
+	job := Job{Status: "completed", Output: "1\n", CallbackURL: server.URL}
+
+	// Two 503s, then success.
+	failWith.Store(http.StatusServiceUnavailable)
+	assert.NoError(t, ac.Jobs.Create(ctx, "job-1", job))
+	assert.NoError(t, ac.deliverJobCallback(ctx, "job-1", job, log.NewEntry(log.StandardLogger())))
+	stored, err := ac.Jobs.Get(ctx, "job-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stored.CallbackAttempts)
+	if assert.NotNil(t, stored.CallbackDelivery) {
+		assert.True(t, stored.CallbackDelivery.Success)
+	}
+
+	// A 4xx is final.
+	calls.Store(0)
+	failWith.Store(http.StatusGone)
+	assert.NoError(t, ac.Jobs.Create(ctx, "job-2", job))
+	assert.Error(t, ac.deliverJobCallback(ctx, "job-2", job, log.NewEntry(log.StandardLogger())))
+	stored, err = ac.Jobs.Get(ctx, "job-2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stored.CallbackAttempts)
+	assert.Equal(t, http.StatusGone, stored.CallbackDelivery.StatusCode)
+}
+
+func TestValidateJobCallback_Secret(t *testing.T) {
+	ac := &ApiController{AppConfig: &AppConfig{}}
+	err := ac.validateJobCallback(context.Background(), JobCallbackOptions{CallbackURL: "https://hooks.example.com/ci"})
+	assert.ErrorContains(t, err, "callbackSecret is required")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/1liale/api-service/docstore"
+)
+
+// beginWorkspaceCommit reads the workspace at the start of a transaction that creates a new
+// workspace version, and returns it with that version. A frozen workspace is
+// errWorkspaceReadOnly. Sync, folder, draft, import and bulk-delete commits all start here;
+// since the transaction function may be retried, callers reset anything they collect in it
+// before calling.
+func beginWorkspaceCommit(tx *docstore.Transaction, wsDocRef *docstore.DocumentRef) (Workspace, string, error) {
+	var workspace Workspace
+	wsSnap, err := tx.Get(wsDocRef)
+	if err != nil {
+		return workspace, "", fmt.Errorf("failed to get workspace: %w", err)
+	}
+	if err := wsSnap.DataTo(&workspace); err != nil {
+		return workspace, "", fmt.Errorf("failed to parse workspace data: %w", err)
+	}
+	if workspace.ReadOnly {
+		return workspace, "", errWorkspaceReadOnly
+	}
+	version, err := strconv.Atoi(workspace.WorkspaceVersion)
+	if err != nil {
+		return workspace, "", fmt.Errorf("server workspace version '%s' is invalid", workspace.WorkspaceVersion)
+	}
+	return workspace, strconv.Itoa(version + 1), nil
+}
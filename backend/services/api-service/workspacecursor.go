@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// errInvalidWorkspacesCursor covers every cursor GET /workspaces must not honour: malformed,
+// tampered with, or issued to another user.
+var errInvalidWorkspacesCursor = errors.New("invalid cursor")
+
+// workspacesCursor marks where a page of GET /workspaces ended: the last membership read.
+type workspacesCursor struct {
+	UserID   string `json:"u"`
+	JoinedAt string `json:"j"`
+	Path     string `json:"p"` // workspace_memberships/{membershipId}
+}
+
+// signWorkspacesCursor returns the HMAC-SHA256 of payload under key.
+func signWorkspacesCursor(key string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// membershipID returns the ID of the membership document the cursor points at.
+func (cur workspacesCursor) membershipID() string {
+	return strings.TrimPrefix(cur.Path, "workspace_memberships/")
+}
+
+// encodeWorkspacesCursor returns base64url(JSON) + "." + base64url(HMAC-SHA256 of the JSON).
+// The user ID is signed in, so a cursor cannot be replayed by another user.
+func encodeWorkspacesCursor(key string, cur workspacesCursor) string {
+	payload, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signWorkspacesCursor(key, payload))
+}
+
+// decodeWorkspacesCursor returns the cursor in token if key signed it for userID.
+func decodeWorkspacesCursor(key, userID, token string) (workspacesCursor, error) {
+	var cur workspacesCursor
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return cur, errInvalidWorkspacesCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return cur, errInvalidWorkspacesCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, signWorkspacesCursor(key, payload)) {
+		return cur, errInvalidWorkspacesCursor
+	}
+	if err := json.Unmarshal(payload, &cur); err != nil || cur.UserID != userID ||
+		!strings.HasPrefix(cur.Path, "workspace_memberships/") || cur.membershipID() == "" {
+		return workspacesCursor{}, errInvalidWorkspacesCursor
+	}
+	return cur, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspacesCursor(t *testing.T) {
+	cur := workspacesCursor{UserID: "user-1", JoinedAt: "2025-01-01T00:00:00.000Z", Path: "workspace_memberships/m-1"}
+	token := encodeWorkspacesCursor("key", cur)
+
+	got, err := decodeWorkspacesCursor("key", "user-1", token)
+	assert.NoError(t, err)
+	assert.Equal(t, cur, got)
+	assert.Equal(t, "m-1", got.membershipID())
+
+	_, err = decodeWorkspacesCursor("key", "user-2", token)
+	assert.ErrorIs(t, err, errInvalidWorkspacesCursor, "issued to another user")
+	_, err = decodeWorkspacesCursor("other-key", "user-1", token)
+	assert.ErrorIs(t, err, errInvalidWorkspacesCursor, "signed with another key")
+
+	payload, sig, _ := strings.Cut(token, ".")
+	forged := encodeWorkspacesCursor("key", workspacesCursor{UserID: "user-1", Path: "workspace_memberships/m-2"})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	_, err = decodeWorkspacesCursor("key", "user-1", forgedPayload+"."+sig)
+	assert.ErrorIs(t, err, errInvalidWorkspacesCursor, "payload swapped under a valid signature")
+	for _, bad := range []string{"", payload, "!!!." + sig, payload + ".!!!"} {
+		_, err = decodeWorkspacesCursor("key", "user-1", bad)
+		assert.ErrorIs(t, err, errInvalidWorkspacesCursor, bad)
+	}
+	_, err = decodeWorkspacesCursor("key", "user-1", encodeWorkspacesCursor("key", workspacesCursor{UserID: "user-1", Path: "workspaces/w-1"}))
+	assert.ErrorIs(t, err, errInvalidWorkspacesCursor, "not a membership path")
+}
+
+func TestHandlers_ListWorkspacesPages(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	want := map[string]bool{}
+	for i := 0; i < 7; i++ {
+		want[h.createWorkspace(owner)] = true
+	}
+
+	seen := map[string]bool{}
+	cursor, pages := "", 0
+	for {
+		path := "/api/workspaces?limit=3"
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+		var page WorkspaceListResponse
+		w := h.do(http.MethodGet, path, owner, nil, &page)
+		if !assert.Equal(t, http.StatusOK, w.Code, w.Body.String()) {
+			return
+		}
+		pages++
+		assert.LessOrEqual(t, len(page.Workspaces), 3)
+		for _, ws := range page.Workspaces {
+			assert.False(t, seen[ws.WorkspaceID], "listed twice: %s", ws.WorkspaceID)
+			seen[ws.WorkspaceID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+		if pages > 5 {
+			t.Fatal("paging did not end")
+		}
+	}
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, want, seen)
+
+	var all []WorkspaceSummary
+	w := h.do(http.MethodGet, "/api/workspaces", owner, nil, &all)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, all, 7, "without paging parameters the bare array is kept")
+
+	for _, query := range []string{"?limit=0", "?limit=201", "?limit=x", "?cursor=bogus"} {
+		assert.Equal(t, http.StatusBadRequest, h.do(http.MethodGet, "/api/workspaces"+query, owner, nil, nil).Code, query)
+	}
+	var first WorkspaceListResponse
+	h.do(http.MethodGet, "/api/workspaces?limit=3", owner, nil, &first)
+	w = h.do(http.MethodGet, "/api/workspaces?cursor="+url.QueryEscape(first.NextCursor), "other-"+uuid.New().String(), nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "cursors are bound to their user")
+}
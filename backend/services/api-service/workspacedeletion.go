@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// workspacePurgeBatch bounds how many workspaces one workspace-purge run deletes.
+	workspacePurgeBatch = 10
+	// workspacePurgeLease is how long a started purge blocks other runs from retrying it.
+	workspacePurgeLease = time.Hour
+
+	// workspaceDeletionsCollection holds WorkspaceDeletion records. Finished ones carry
+	// delete_after for a TTL policy; the "workspace-deletion-expiry" maintenance task removes
+	// them where none is configured.
+	workspaceDeletionsCollection = "workspace_deletions"
+	workspaceDeletionRetention   = 30 * 24 * time.Hour
+	workspaceDeletionExpiryBatch = 300
+)
+
+// WorkspaceDeletion statuses. A pending deletion is still restorable; a failed purge is
+// retried by the workspace-purge maintenance task, which sets it back to running.
+const (
+	workspaceDeletionPending   = "pending"
+	workspaceDeletionRunning   = "running"
+	workspaceDeletionCompleted = "completed"
+	workspaceDeletionFailed    = "failed"
+	workspaceDeletionCancelled = "cancelled"
+)
+
+// workspaceStatusTrashed is the status of a soft-deleted workspace. Workspaces deleted
+// before the status was stored are recognised by DeletedAt alone.
+const workspaceStatusTrashed = "trashed"
+
+var (
+	errWorkspaceDeleted    = errors.New("workspace has been deleted")
+	errWorkspaceNotDeleted = errors.New("workspace is not deleted")
+	// errWorkspaceDeleting is returned from sync transactions when the workspace was deleted
+	// while the sync was in flight.
+	errWorkspaceDeleting = errors.New("workspace is being deleted")
+)
+
+// workspacePurgeDue reports whether a soft-deleted workspace's grace period is over.
+func workspacePurgeDue(ws Workspace, now time.Time) bool {
+	if ws.DeletedAt == "" {
+		return false
+	}
+	purgeAfter, err := time.Parse(time.RFC3339, ws.PurgeAfter)
+	return err != nil || !now.Before(purgeAfter)
+}
+
+// workspacePurgeClaimable reports whether a purge may start now: the workspace is due and
+// no other run started purging it within the lease.
+func workspacePurgeClaimable(ws Workspace, now time.Time) bool {
+	if !workspacePurgeDue(ws, now) {
+		return false
+	}
+	if ws.PurgeStartedAt == "" {
+		return true
+	}
+	started, err := time.Parse(time.RFC3339, ws.PurgeStartedAt)
+	return err != nil || now.Sub(started) >= workspacePurgeLease
+}
+
+// checkWorkspaceRestorable returns why a workspace cannot be restored, or nil.
+func checkWorkspaceRestorable(ws Workspace, now time.Time) error {
+	if ws.DeletedAt == "" {
+		return errWorkspaceNotDeleted
+	}
+	if ws.PurgeStartedAt != "" || workspacePurgeDue(ws, now) {
+		return errWorkspaceDeleted
+	}
+	return nil
+}
+
+// writeWorkspaceDeleted answers 410 for a soft-deleted or purged workspace.
+func writeWorkspaceDeleted(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": errWorkspaceDeleted.Error(), "code": "workspace_deleted"})
+}
+
+// RejectDeletedWorkspaces blocks requests to routes with a :workspaceId once that workspace
+// has been soft-deleted. Missing workspaces pass through so handlers report them as before.
+func (ac *ApiController) RejectDeletedWorkspaces() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workspaceID := c.Param("workspaceId")
+		if workspaceID == "" {
+			c.Next()
+			return
+		}
+		workspace, err := ac.loadWorkspace(c.Request.Context(), workspaceID)
+		if isNotFound(err) {
+			c.Next()
+			return
+		}
+		if err != nil {
+			log.WithError(err).WithField("workspace_id", workspaceID).Error("Failed to check workspace deletion state.")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace"})
+			return
+		}
+		if workspace.DeletedAt != "" {
+			writeWorkspaceDeleted(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// DeleteWorkspace soft-deletes a workspace (owners only): it disappears from listings and
+// rejects every operation with 410 until RestoreWorkspace, or until the workspace-purge
+// maintenance task deletes it for good after WorkspaceDeletionGrace. With ?permanent=true
+// the grace period is skipped and the purge starts right away.
+func (ac *ApiController) DeleteWorkspace(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "DeleteWorkspace", "workspace_id": workspaceID, "user_id": userID})
+
+	permanent := c.Query("permanent") == "true"
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+
+	now := time.Now()
+	var workspace Workspace
+	ref := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&workspace); err != nil {
+			return fmt.Errorf("failed to parse workspace: %w", err)
+		}
+		if workspace.PurgeStartedAt != "" {
+			return errWorkspaceDeleted
+		}
+
+		var updates []docstore.Update
+		if workspace.DeletedAt == "" {
+			workspace.DeletedAt, workspace.DeletedBy = TimeToISO8601(now), userID
+			workspace.PurgeAfter = TimeToISO8601(now.Add(ac.AppConfig.WorkspaceDeletionGrace))
+			workspace.Status = workspaceStatusTrashed
+			updates = append(updates,
+				docstore.Update{Path: "status", Value: workspace.Status},
+				docstore.Update{Path: "deleted_at", Value: workspace.DeletedAt},
+				docstore.Update{Path: "deleted_by", Value: workspace.DeletedBy},
+			)
+		}
+		if permanent {
+			workspace.PurgeAfter = TimeToISO8601(now)
+		}
+		if len(updates) == 0 && !permanent && workspace.DeletionID != "" {
+			return nil // Already soft-deleted
+		}
+		updates = append(updates, docstore.Update{Path: "purge_after", Value: workspace.PurgeAfter})
+
+		// Repeated deletes report the deletion already under way.
+		deletion := WorkspaceDeletion{
+			DeletionID:  workspace.DeletionID,
+			WorkspaceID: workspaceID,
+			RequestedBy: userID,
+			Status:      workspaceDeletionPending,
+			CreatedAt:   TimeToISO8601(now),
+		}
+		if deletion.DeletionID == "" {
+			deletion.DeletionID = uuid.New().String()
+			workspace.DeletionID = deletion.DeletionID
+			updates = append(updates, docstore.Update{Path: "deletion_id", Value: deletion.DeletionID})
+			deletion.UpdatedAt, deletion.PurgeAfter = deletion.CreatedAt, workspace.PurgeAfter
+			if err := tx.Set(ac.workspaceDeletionRef(deletion.DeletionID), deletion); err != nil {
+				return err
+			}
+		} else if err := tx.Update(ac.workspaceDeletionRef(deletion.DeletionID), []docstore.Update{
+			{Path: "purge_after", Value: workspace.PurgeAfter},
+			{Path: "updated_at", Value: TimeToISO8601(now)},
+		}); err != nil {
+			return err
+		}
+		return tx.Update(ref, updates)
+	})
+	if isNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return
+	}
+	if errors.Is(err, errWorkspaceDeleted) {
+		writeWorkspaceDeleted(c)
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to delete workspace.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete workspace"})
+		return
+	}
+
+	resp := DeleteWorkspaceResponse{WorkspaceID: workspaceID, Permanent: permanent, DeletedAt: workspace.DeletedAt, DeletionID: workspace.DeletionID}
+	if !permanent {
+		resp.PurgeAfter = workspace.PurgeAfter
+		ac.recordActivity(ctx, workspaceID, userID, activityWorkspaceDeleted, map[string]interface{}{
+			"purge_after": workspace.PurgeAfter,
+		})
+		logCtx.WithField("purge_after", workspace.PurgeAfter).Info("Workspace soft-deleted.")
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	// The workspace is already hidden and due; the maintenance task picks the purge up if
+	// it cannot run now or fails part-way.
+	submitErr := ac.Background.Submit("workspace_purge", func(ctx context.Context) error {
+		_, err := ac.purgeWorkspace(ctx, workspaceID)
+		return err
+	})
+	if submitErr != nil {
+		logCtx.WithError(submitErr).Warn("Failed to schedule workspace purge; maintenance will retry.")
+	}
+	logCtx.Info("Workspace permanently deleted.")
+	c.JSON(http.StatusAccepted, resp)
+}
+
+func (ac *ApiController) workspaceDeletionRef(deletionID string) *docstore.DocumentRef {
+	return ac.FirestoreClient.Collection(workspaceDeletionsCollection).Doc(deletionID)
+}
+
+// workspaceDeletionFinished returns the updates that close a deletion record with status.
+func workspaceDeletionFinished(status string, now time.Time) []docstore.Update {
+	return []docstore.Update{
+		{Path: "status", Value: status},
+		{Path: "updated_at", Value: TimeToISO8601(now)},
+		{Path: deleteAfterField, Value: now.Add(workspaceDeletionRetention)},
+	}
+}
+
+// updateWorkspaceDeletion records a purge's progress. The record is informational, so a
+// failed write is logged and the purge carries on.
+func (ac *ApiController) updateWorkspaceDeletion(ctx context.Context, deletionID string, updates []docstore.Update) {
+	if deletionID == "" {
+		return // Deleted before deletions were tracked
+	}
+	if _, err := ac.workspaceDeletionRef(deletionID).Update(ctx, updates); err != nil {
+		log.WithError(err).WithField("deletion_id", deletionID).Warn("Failed to update workspace deletion status.")
+	}
+}
+
+// GetWorkspaceDeletion reports the progress of a workspace deletion. Only the user who
+// requested it can read it, since the workspace and its memberships may already be gone;
+// anyone else gets the same 404 as an unknown ID.
+func (ac *ApiController) GetWorkspaceDeletion(c *gin.Context) {
+	deletionID := c.Param("deletionId")
+	userID := c.GetString("userID")
+	logCtx := log.WithFields(log.Fields{"handler": "GetWorkspaceDeletion", "deletion_id": deletionID, "user_id": userID})
+
+	snap, err := getLiveDocument(c.Request.Context(), ac.workspaceDeletionRef(deletionID))
+	if err != nil && !isNotFound(err) {
+		logCtx.WithError(err).Error("Failed to load workspace deletion.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load deletion"})
+		return
+	}
+	var deletion WorkspaceDeletion
+	if err != nil || snap.DataTo(&deletion) != nil || deletion.RequestedBy != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deletion not found"})
+		return
+	}
+	c.JSON(http.StatusOK, deletion)
+}
+
+// runWorkspaceDeletionExpiryMaintenance is the "workspace-deletion-expiry" maintenance task:
+// it removes one batch of deletion records finished more than workspaceDeletionRetention ago.
+func (ac *ApiController) runWorkspaceDeletionExpiryMaintenance(ctx context.Context) (interface{}, error) {
+	return ac.deleteExpiredRecords(ctx, workspaceDeletionsCollection, workspaceDeletionExpiryBatch)
+}
+
+// RestoreWorkspace undoes a soft delete (owners only) while the grace period lasts. Once the
+// purge has finished the workspace is unknown (404), as its memberships are gone too.
+func (ac *ApiController) RestoreWorkspace(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "RestoreWorkspace", "workspace_id": workspaceID, "user_id": userID})
+
+	if _, err := ac.loadWorkspace(ctx, workspaceID); isNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return
+	}
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+
+	var workspace Workspace
+	ref := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&workspace); err != nil {
+			return fmt.Errorf("failed to parse workspace: %w", err)
+		}
+		// Serializes with the purge claim on the same document.
+		if err := checkWorkspaceRestorable(workspace, time.Now()); err != nil {
+			return err
+		}
+		deletionID := workspace.DeletionID
+		workspace.Status, workspace.DeletedAt, workspace.DeletedBy, workspace.PurgeAfter, workspace.DeletionID = "", "", "", "", ""
+		workspace.UpdatedAt = NowISO8601()
+		if deletionID != "" {
+			if err := tx.Update(ac.workspaceDeletionRef(deletionID), workspaceDeletionFinished(workspaceDeletionCancelled, time.Now())); err != nil {
+				return err
+			}
+		}
+		return tx.Update(ref, []docstore.Update{
+			{Path: "status", Value: docstore.Delete},
+			{Path: "deleted_at", Value: docstore.Delete},
+			{Path: "deleted_by", Value: docstore.Delete},
+			{Path: "purge_after", Value: docstore.Delete},
+			{Path: "deletion_id", Value: docstore.Delete},
+			{Path: "updated_at", Value: workspace.UpdatedAt},
+		})
+	})
+	if isNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return
+	}
+	if errors.Is(err, errWorkspaceDeleted) {
+		writeWorkspaceDeleted(c)
+		return
+	}
+	if errors.Is(err, errWorkspaceNotDeleted) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to restore workspace.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore workspace"})
+		return
+	}
+
+	ac.recordActivity(ctx, workspaceID, userID, activityWorkspaceRestored, nil)
+	logCtx.Info("Workspace restored.")
+	c.JSON(http.StatusOK, workspace)
+}
+
+// runWorkspacePurgeMaintenance is the "workspace-purge" maintenance task: it permanently
+// deletes soft-deleted workspaces whose grace period is over, including purges that failed
+// or were interrupted.
+func (ac *ApiController) runWorkspacePurgeMaintenance(ctx context.Context) (interface{}, error) {
+	docs, err := ac.FirestoreClient.Collection("workspaces").
+		Where("purge_after", "<=", NowISO8601()).
+		OrderBy("purge_after", docstore.Asc).
+		Limit(workspacePurgeBatch).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deleted workspaces: %w", err)
+	}
+
+	purged, skipped, failed := 0, 0, 0
+	for _, doc := range docs {
+		ok, err := ac.purgeWorkspace(ctx, doc.Ref.ID)
+		switch {
+		case err != nil:
+			log.WithError(err).WithField("workspace_id", doc.Ref.ID).Warn("Failed to purge workspace.")
+			failed++
+		case ok:
+			purged++
+		default:
+			skipped++
+		}
+	}
+	return gin.H{"purged": purged, "skipped": skipped, "failed": failed}, nil
+}
+
+// purgeWorkspace permanently deletes a due workspace: its R2 objects, its subcollections,
+// memberships, invitations and jobs, and finally the workspace document itself, so a purge
+// that fails part-way is retried by the next run. It returns false when the workspace was
+// restored, is not due, or is being purged by another run.
+func (ac *ApiController) purgeWorkspace(ctx context.Context, workspaceID string) (bool, error) {
+	ref := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+	var workspace Workspace
+	claimed := false
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		claimed = false
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&workspace); err != nil {
+			return fmt.Errorf("failed to parse workspace: %w", err)
+		}
+		now := time.Now()
+		if !workspacePurgeClaimable(workspace, now) {
+			return nil
+		}
+		claimed = true
+		return tx.Update(ref, []docstore.Update{{Path: "purge_started_at", Value: TimeToISO8601(now)}})
+	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil || !claimed {
+		return false, err
+	}
+	logCtx := log.WithField("workspace_id", workspaceID)
+	ac.updateWorkspaceDeletion(ctx, workspace.DeletionID, []docstore.Update{
+		{Path: "status", Value: workspaceDeletionRunning},
+		{Path: "error", Value: docstore.Delete},
+		{Path: "updated_at", Value: NowISO8601()},
+	})
+
+	objects, documents, err := ac.purgeClaimedWorkspace(ctx, ref, workspaceID)
+	if err != nil {
+		ac.updateWorkspaceDeletion(ctx, workspace.DeletionID, []docstore.Update{
+			{Path: "status", Value: workspaceDeletionFailed},
+			{Path: "error", Value: err.Error()},
+			{Path: "updated_at", Value: NowISO8601()},
+		})
+		return false, err
+	}
+	ac.updateWorkspaceDeletion(ctx, workspace.DeletionID, append(workspaceDeletionFinished(workspaceDeletionCompleted, time.Now()),
+		docstore.Update{Path: "objects", Value: objects},
+		docstore.Update{Path: "documents", Value: documents},
+	))
+
+	ac.recordAudit(ctx, workspace.DeletedBy, auditWorkspacePurged, "workspace", workspaceID, map[string]interface{}{
+		"name":          workspace.Name,
+		"deleted_at":    workspace.DeletedAt,
+		"storage_bytes": workspace.StorageBytes,
+		"objects":       objects,
+		"documents":     documents,
+	})
+	logCtx.WithFields(log.Fields{"objects": objects, "documents": documents}).Info("Workspace purged.")
+	return true, nil
+}
+
+// purgeClaimedWorkspace deletes a claimed workspace's R2 objects, its subcollections,
+// memberships, invitations and jobs, and finally the workspace document itself. It returns
+// how many objects and documents it deleted.
+func (ac *ApiController) purgeClaimedWorkspace(ctx context.Context, ref *docstore.DocumentRef, workspaceID string) (int, int, error) {
+	objects, err := ac.purgeWorkspaceObjects(ctx, workspaceID)
+	if err != nil {
+		return objects, 0, err
+	}
+
+	workspacePath := fmt.Sprintf("workspaces/%s", workspaceID)
+	queries := []docstore.Query{
+		ac.FirestoreClient.Collection(workspacePath + "/files").Query,
+		ac.workspaceChangesCollection(workspaceID).Query,
+		ac.activityCollection(workspaceID).Query,
+		ac.FirestoreClient.Collection(workspacePath + "/meta").Query,
+		ac.FirestoreClient.Collection("workspace_memberships").Where("workspace_id", "==", workspaceID),
+		ac.FirestoreClient.Collection(invitationsCollection).Where("workspace_id", "==", workspaceID),
+		ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).Where("workspace_id", "==", workspaceID),
+		ac.FirestoreClient.Collection(draftsCollection).Where("workspace_id", "==", workspaceID),
+		ac.FirestoreClient.Collection(workspaceShareLinksCollection).Where("workspace_id", "==", workspaceID),
+	}
+	documents := 0
+	for _, q := range queries {
+		n, err := ac.deleteQueryDocuments(ctx, q)
+		documents += n
+		if err != nil {
+			return objects, documents, err
+		}
+	}
+	if _, err := ref.Delete(ctx); err != nil {
+		return objects, documents, fmt.Errorf("failed to delete workspace document: %w", err)
+	}
+	return objects, documents, nil
+}
+
+// purgeWorkspaceObjects deletes every R2 object under the workspace's prefix, which also
+// catches uploads that were never confirmed. Keys that fail are queued for the r2-deletions
+// task. It returns how many objects were found.
+func (ac *ApiController) purgeWorkspaceObjects(ctx context.Context, workspaceID string) (int, error) {
+	paginator := s3.NewListObjectsV2Paginator(ac.r2().S3, &s3.ListObjectsV2Input{
+		Bucket: aws.String(ac.R2BucketName),
+		Prefix: aws.String(fmt.Sprintf("workspaces/%s/", workspaceID)),
+	})
+	found := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return found, fmt.Errorf("failed to list workspace objects: %w", err)
+		}
+		keys := make([]string, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+		found += len(keys)
+		if failures := ac.deleteR2Objects(ctx, keys); len(failures) > 0 {
+			ac.recordFailedR2Deletions(ctx, workspaceID, failures)
+		}
+	}
+	return found, nil
+}
+
+// deleteQueryDocuments deletes every document the query matches and returns how many it
+// deleted.
+func (ac *ApiController) deleteQueryDocuments(ctx context.Context, q docstore.Query) (int, error) {
+	bw := ac.FirestoreClient.BulkWriter(ctx)
+	var jobs []*docstore.BulkWriterJob
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bw.End()
+			return 0, fmt.Errorf("failed to list documents: %w", err)
+		}
+		job, err := bw.Delete(doc.Ref)
+		if err != nil {
+			bw.End()
+			return 0, fmt.Errorf("failed to queue delete of %s: %w", doc.Ref.Path, err)
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+
+	deleted := 0
+	var firstErr error
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to delete document: %w", err)
+			}
+			continue
+		}
+		deleted++
+	}
+	return deleted, firstErr
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var deletionTestNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func softDeletedWorkspace(purgeIn time.Duration) Workspace {
+	return Workspace{
+		WorkspaceID: "ws-1",
+		DeletedAt:   TimeToISO8601(deletionTestNow.Add(-time.Hour)),
+		DeletedBy:   "alice",
+		PurgeAfter:  TimeToISO8601(deletionTestNow.Add(purgeIn)),
+	}
+}
+
+func TestWorkspacePurgeDue(t *testing.T) {
+	assert.False(t, workspacePurgeDue(Workspace{WorkspaceID: "ws-1"}, deletionTestNow))
+	assert.False(t, workspacePurgeDue(softDeletedWorkspace(time.Minute), deletionTestNow))
+	assert.True(t, workspacePurgeDue(softDeletedWorkspace(0), deletionTestNow))
+	assert.True(t, workspacePurgeDue(softDeletedWorkspace(-time.Minute), deletionTestNow))
+
+	// A deleted workspace with an unreadable purge time is purged rather than kept forever.
+	broken := softDeletedWorkspace(time.Hour)
+	broken.PurgeAfter = ""
+	assert.True(t, workspacePurgeDue(broken, deletionTestNow))
+}
+
+func TestWorkspacePurgeClaimable(t *testing.T) {
+	ws := softDeletedWorkspace(-time.Minute)
+	assert.True(t, workspacePurgeClaimable(ws, deletionTestNow))
+
+	ws.PurgeStartedAt = TimeToISO8601(deletionTestNow.Add(-time.Minute))
+	assert.False(t, workspacePurgeClaimable(ws, deletionTestNow)) // Another run holds the lease
+
+	ws.PurgeStartedAt = TimeToISO8601(deletionTestNow.Add(-workspacePurgeLease))
+	assert.True(t, workspacePurgeClaimable(ws, deletionTestNow)) // Interrupted purge is retried
+
+	assert.False(t, workspacePurgeClaimable(softDeletedWorkspace(time.Minute), deletionTestNow))
+}
+
+func TestCheckWorkspaceRestorable(t *testing.T) {
+	assert.NoError(t, checkWorkspaceRestorable(softDeletedWorkspace(time.Minute), deletionTestNow))
+	assert.ErrorIs(t, checkWorkspaceRestorable(Workspace{WorkspaceID: "ws-1"}, deletionTestNow), errWorkspaceNotDeleted)
+	assert.ErrorIs(t, checkWorkspaceRestorable(softDeletedWorkspace(-time.Minute), deletionTestNow), errWorkspaceDeleted)
+
+	// Once a purge has claimed the workspace it cannot come back, even within the window.
+	claimed := softDeletedWorkspace(time.Minute)
+	claimed.PurgeStartedAt = TimeToISO8601(deletionTestNow)
+	assert.ErrorIs(t, checkWorkspaceRestorable(claimed, deletionTestNow), errWorkspaceDeleted)
+}
+
+func TestHandlers_WorkspaceDeletionStatus(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	editor := "editor-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, editor, "editor", "")
+
+	// A sync planned before the delete cannot commit after it.
+	action, version := h.syncNewFile(workspaceID, owner, "1", "main.py")
+	h.objects.put(action.R2ObjectKey, []byte("x"))
+
+	var deleted DeleteWorkspaceResponse
+	w := h.do(http.MethodDelete, "/api/workspaces/"+workspaceID, owner, nil, &deleted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEmpty(t, deleted.DeletionID)
+	var again DeleteWorkspaceResponse
+	h.do(http.MethodDelete, "/api/workspaces/"+workspaceID, owner, nil, &again)
+	assert.Equal(t, deleted.DeletionID, again.DeletionID, "a repeated delete reports the same deletion")
+
+	var confirm ConfirmSyncResponse
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/sync/confirm", owner, ConfirmSyncRequest{
+		WorkspaceVersion: version,
+		SyncActions:      []FileAction{upsertAction(action, 1)},
+	}, &confirm)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "workspace_deleting", confirm.Status)
+
+	statusPath := "/api/workspace-deletions/" + deleted.DeletionID
+	var deletion WorkspaceDeletion
+	w = h.do(http.MethodGet, statusPath, owner, nil, &deletion)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, workspaceDeletionPending, deletion.Status)
+	assert.Equal(t, workspaceID, deletion.WorkspaceID)
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodGet, statusPath, editor, nil, nil).Code, "only the requester can poll")
+
+	w = h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/restore", owner, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	h.do(http.MethodGet, statusPath, owner, nil, &deletion)
+	assert.Equal(t, workspaceDeletionCancelled, deletion.Status)
+
+	var redeleted DeleteWorkspaceResponse
+	h.do(http.MethodDelete, "/api/workspaces/"+workspaceID, owner, nil, &redeleted)
+	assert.NotEqual(t, deleted.DeletionID, redeleted.DeletionID, "a deletion after a restore is tracked anew")
+}
+
+func TestHandlers_WorkspaceTrashAndRestore(t *testing.T) {
+	h := newHandlerHarness(t)
+	ctx := context.Background()
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	file := h.seedFile(workspaceID, "secret.py", "print('hidden')\n")
+	wsPath := "/api/workspaces/" + workspaceID
+	listed := func(query string) []WorkspaceSummary {
+		var summaries []WorkspaceSummary
+		w := h.do(http.MethodGet, "/api/workspaces"+query, owner, nil, &summaries)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return summaries
+	}
+
+	var deleted DeleteWorkspaceResponse
+	assert.Equal(t, http.StatusOK, h.do(http.MethodDelete, wsPath, owner, nil, &deleted).Code)
+	w := h.do(http.MethodGet, wsPath+"/manifest", owner, nil, nil)
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.NotContains(t, w.Body.String(), "secret.py", "a trashed workspace's files are not listed")
+	assert.Empty(t, listed(""))
+	trashed := listed("?includeTrashed=true")
+	if assert.Len(t, trashed, 1) {
+		assert.Equal(t, workspaceStatusTrashed, trashed[0].Status)
+		assert.Equal(t, deleted.PurgeAfter, trashed[0].PurgeAfter)
+	}
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPost, wsPath+"/restore", "stranger-"+uuid.New().String(), nil, nil).Code)
+
+	// Restored within the window, it comes back with its files.
+	var restored Workspace
+	w = h.do(http.MethodPost, wsPath+"/restore", owner, nil, &restored)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, restored.Status)
+	assert.Empty(t, restored.DeletedAt)
+	if summaries := listed(""); assert.Len(t, summaries, 1) {
+		assert.Empty(t, summaries[0].Status)
+	}
+	var manifest WorkspaceManifestResponse
+	assert.Equal(t, http.StatusOK, h.do(http.MethodGet, wsPath+"/manifest", owner, nil, &manifest).Code)
+	assert.Len(t, manifest.Manifest, 1)
+	assert.Equal(t, http.StatusConflict, h.do(http.MethodPost, wsPath+"/restore", owner, nil, nil).Code, "nothing to restore")
+
+	// Past the window the purge removes it, and a restore finds nothing.
+	h.do(http.MethodDelete, wsPath, owner, nil, nil)
+	_, err := h.fs.Collection("workspaces").Doc(workspaceID).Update(ctx, []docstore.Update{
+		{Path: "purge_after", Value: TimeToISO8601(time.Now().Add(-time.Minute))},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGone, h.do(http.MethodPost, wsPath+"/restore", owner, nil, nil).Code, "the window is over")
+	purged, err := h.ac.purgeWorkspace(ctx, workspaceID)
+	assert.NoError(t, err)
+	assert.True(t, purged)
+	assert.False(t, h.objects.has(file.R2ObjectKey), "the purge removes its objects")
+	assert.Equal(t, http.StatusNotFound, h.do(http.MethodPost, wsPath+"/restore", owner, nil, nil).Code)
+	assert.Empty(t, listed("?includeTrashed=true"))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+)
+
+// workspaceOwnerCountsCollection holds one document per user who has created a workspace
+// under a limit. Creates read and write it in their transaction, so two creates by the same
+// user are serialized and the second counts the first's workspace.
+const workspaceOwnerCountsCollection = "workspace_owner_counts"
+
+// workspaceLimitError is returned when a user who owns Count workspaces may own at most Limit.
+type workspaceLimitError struct {
+	Count int
+	Limit int
+}
+
+func (e *workspaceLimitError) Error() string {
+	return fmt.Sprintf("workspace limit reached: %d of %d", e.Count, e.Limit)
+}
+
+// workspaceOwnerCount is the per-user document in workspaceOwnerCountsCollection. Owned is
+// informational; the limit is always checked against a fresh count of owner memberships.
+type workspaceOwnerCount struct {
+	UserID    string `firestore:"user_id"`
+	Owned     int    `firestore:"owned"`
+	UpdatedAt string `firestore:"updated_at"`
+}
+
+// countOwnedWorkspaces counts userID's owner memberships with an aggregate query, inside tx
+// when one is given.
+func (ac *ApiController) countOwnedWorkspaces(ctx context.Context, tx *docstore.Transaction, userID string) (int, error) {
+	q := ac.FirestoreClient.Collection("workspace_memberships").
+		Where("user_id", "==", userID).
+		Where("role", "==", roleOwner).
+		NewAggregationQuery().WithCount("count")
+	if tx != nil {
+		q = q.Transaction(tx)
+	}
+	res, err := q.Get(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count owned workspaces: %w", err)
+	}
+	v, _ := res["count"].(*firestorepb.Value)
+	return int(v.GetIntegerValue()), nil
+}
+
+// checkWorkspaceLimit returns a *workspaceLimitError when userID already owns
+// MaxWorkspacesPerOwner workspaces. It reads nothing when there is no limit.
+func (ac *ApiController) checkWorkspaceLimit(ctx context.Context, userID string) error {
+	limit := ac.AppConfig.MaxWorkspacesPerOwner
+	if limit <= 0 {
+		return nil
+	}
+	count, err := ac.countOwnedWorkspaces(ctx, nil, userID)
+	if err != nil {
+		return err
+	}
+	if count >= limit {
+		return &workspaceLimitError{Count: count, Limit: limit}
+	}
+	return nil
+}
+
+// workspaceLimitTxCheck returns the commitNewWorkspace check that enforces
+// MaxWorkspacesPerOwner for userID, or nil when there is no limit. The check counts again
+// inside the transaction and rewrites the user's count document, so of two simultaneous
+// creates one retries and sees the other's workspace.
+func (ac *ApiController) workspaceLimitTxCheck(ctx context.Context, userID string) func(tx *docstore.Transaction) error {
+	limit := ac.AppConfig.MaxWorkspacesPerOwner
+	if limit <= 0 {
+		return nil
+	}
+	return func(tx *docstore.Transaction) error {
+		ref := ac.FirestoreClient.Collection(workspaceOwnerCountsCollection).Doc(userID)
+		if _, err := tx.Get(ref); err != nil && !isNotFound(err) {
+			return err
+		}
+		count, err := ac.countOwnedWorkspaces(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if count >= limit {
+			return &workspaceLimitError{Count: count, Limit: limit}
+		}
+		return tx.Set(ref, workspaceOwnerCount{UserID: userID, Owned: count + 1, UpdatedAt: NowISO8601()})
+	}
+}
+
+// writeWorkspaceLimitReached answers a create refused by checkWorkspaceLimit or
+// workspaceLimitTxCheck, reporting true when err was such a refusal.
+func writeWorkspaceLimitReached(c *gin.Context, err error) bool {
+	var limitErr *workspaceLimitError
+	if !errors.As(err, &limitErr) {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": fmt.Sprintf("You already own %d workspaces, the most allowed; delete one first", limitErr.Count),
+		"code":  "workspace_limit_reached",
+		"count": limitErr.Count,
+		"limit": limitErr.Limit,
+	})
+	return true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlers_WorkspaceLimit(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.MaxWorkspacesPerOwner = 2
+	owner := "owner-" + uuid.New().String()
+	create := func(userID string, out interface{}) int {
+		return h.do(http.MethodPost, "/api/workspaces", userID, CreateWorkspaceRequest{Name: "ws"}, out).Code
+	}
+
+	// Workspaces the user only edits do not count.
+	h.seedMembership(h.createWorkspace("other-"+uuid.New().String()), owner, roleEditor, "")
+	assert.Equal(t, http.StatusCreated, create(owner, nil))
+	assert.Equal(t, http.StatusCreated, create(owner, nil))
+	var refused map[string]interface{}
+	assert.Equal(t, http.StatusForbidden, create(owner, &refused))
+	assert.Equal(t, "workspace_limit_reached", refused["code"])
+	assert.Equal(t, float64(2), refused["count"])
+	assert.Equal(t, float64(2), refused["limit"])
+
+	// An owner already over the limit keeps every workspace but cannot add one.
+	over := "over-" + uuid.New().String()
+	for range 3 {
+		h.seedMembership(h.createWorkspace("seed-"+uuid.New().String()), over, roleOwner, "")
+	}
+	assert.Equal(t, http.StatusForbidden, create(over, &refused))
+	assert.Equal(t, float64(3), refused["count"])
+	var listed []WorkspaceSummary
+	h.do(http.MethodGet, "/api/workspaces", over, nil, &listed)
+	assert.Len(t, listed, 3)
+
+	h.ac.AppConfig.MaxWorkspacesPerOwner = 0
+	assert.Equal(t, http.StatusCreated, create(over, nil), "0 disables the limit")
+}
+
+func TestHandlers_WorkspaceLimitConcurrentCreates(t *testing.T) {
+	h := newHandlerHarness(t)
+	h.ac.AppConfig.MaxWorkspacesPerOwner = 1
+	owner := "owner-" + uuid.New().String()
+
+	codes := make([]int, 5)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = h.do(http.MethodPost, "/api/workspaces", owner, CreateWorkspaceRequest{Name: "ws"}, nil).Code
+		}(i)
+	}
+	wg.Wait()
+	created := 0
+	for _, code := range codes {
+		if code == http.StatusCreated {
+			created++
+		} else {
+			assert.Equal(t, http.StatusForbidden, code)
+		}
+	}
+	assert.Equal(t, 1, created, "%v", codes)
+}
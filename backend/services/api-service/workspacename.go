@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// workspaceSorts are the values of ListWorkspaces' sort parameter.
+var workspaceSorts = map[string]bool{"name": true, "createdAt": true, "lastActivity": true}
+
+// foldRune maps every rune of a Unicode simple case folding orbit to the same lower-case
+// rune, so that, say, Σ, σ and ς, or K, k and the Kelvin sign, all compare equal.
+func foldRune(r rune) rune {
+	least := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < least {
+			least = f
+		}
+	}
+	return unicode.ToLower(least)
+}
+
+// normalizeWorkspaceName returns the form of a workspace name stored as name_lower and
+// matched by ListWorkspaces' q parameter: trimmed and case folded.
+func normalizeWorkspaceName(name string) string {
+	return strings.Map(foldRune, strings.TrimSpace(name))
+}
+
+// workspaceNameLower returns a workspace's normalized name, computing it for workspaces
+// written before name_lower was stored.
+func workspaceNameLower(ws Workspace) string {
+	if ws.NameLower != "" {
+		return ws.NameLower
+	}
+	return normalizeWorkspaceName(ws.Name)
+}
+
+// sortWorkspaceSummaries orders summaries by name, or newest first by creation or last
+// activity. Ties keep their membership order.
+func sortWorkspaceSummaries(summaries []WorkspaceSummary, by string) {
+	var less func(a, b WorkspaceSummary) bool
+	switch by {
+	case "name":
+		less = func(a, b WorkspaceSummary) bool {
+			return normalizeWorkspaceName(a.Name) < normalizeWorkspaceName(b.Name)
+		}
+	case "createdAt":
+		less = func(a, b WorkspaceSummary) bool { return a.CreatedAt > b.CreatedAt }
+	case "lastActivity":
+		less = func(a, b WorkspaceSummary) bool { return a.LastActivityAt > b.LastActivityAt }
+	default:
+		return
+	}
+	sort.SliceStable(summaries, func(i, j int) bool { return less(summaries[i], summaries[j]) })
+}
+
+// RenameWorkspace renames a workspace (owners only), keeping name_lower in step.
+func (ac *ApiController) RenameWorkspace(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "RenameWorkspace"})
+
+	if ac.requireWorkspaceOwner(c, workspaceID, userID, logCtx) == nil {
+		return
+	}
+	var req RenameWorkspaceRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Workspace name cannot be empty"})
+		return
+	}
+
+	var previous string
+	err := ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		ref := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID)
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var ws Workspace
+		if err := snap.DataTo(&ws); err != nil {
+			return err
+		}
+		previous = ws.Name
+		return tx.Update(ref, []docstore.Update{
+			{Path: "name", Value: name},
+			{Path: "name_lower", Value: normalizeWorkspaceName(name)},
+			{Path: "updated_at", Value: NowISO8601()},
+		})
+	})
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to rename workspace.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename workspace"})
+		return
+	}
+
+	ac.recordActivity(ctx, workspaceID, userID, activityWorkspaceRenamed, map[string]interface{}{
+		"name":          name,
+		"previous_name": previous,
+	})
+	logCtx.WithField("name", name).Info("Workspace renamed.")
+	c.JSON(http.StatusOK, gin.H{"workspaceId": workspaceID, "name": name})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeWorkspaceName(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"  Data Science ", "data science"},
+		{"ÄRGER", "ärger"},
+		{"ΣΊΣΥΦΟΣ", "σίσυφος"},       // final sigma folds with Σ and σ
+		{"\u212Aelvin", "kelvin"},    // Kelvin sign
+		{"\u01C5emal", "\u01C6emal"}, // title-case digraph
+	}
+	for _, tt := range tests {
+		assert.Equal(t, normalizeWorkspaceName(tt.a), normalizeWorkspaceName(tt.b), "%q vs %q", tt.a, tt.b)
+	}
+	assert.Equal(t, "data science", normalizeWorkspaceName("  Data Science "))
+	assert.NotEqual(t, normalizeWorkspaceName("café"), normalizeWorkspaceName("cafe"), "accents are kept")
+}
+
+func TestSortWorkspaceSummaries(t *testing.T) {
+	summaries := []WorkspaceSummary{
+		{Name: "beta", CreatedAt: "2025-01-02", LastActivityAt: "2025-03-01"},
+		{Name: "Alpha", CreatedAt: "2025-01-03", LastActivityAt: "2025-02-01"},
+		{Name: "Ωmega", CreatedAt: "2025-01-01", LastActivityAt: "2025-04-01"},
+	}
+	names := func() []string {
+		out := make([]string, len(summaries))
+		for i, s := range summaries {
+			out[i] = s.Name
+		}
+		return out
+	}
+	sortWorkspaceSummaries(summaries, "name")
+	assert.Equal(t, []string{"Alpha", "beta", "Ωmega"}, names())
+	sortWorkspaceSummaries(summaries, "createdAt")
+	assert.Equal(t, []string{"Alpha", "beta", "Ωmega"}, names())
+	sortWorkspaceSummaries(summaries, "lastActivity")
+	assert.Equal(t, []string{"Ωmega", "beta", "Alpha"}, names())
+}
+
+func TestHandlers_ListWorkspacesSearch(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	ids := map[string]string{}
+	for _, name := range []string{"Ärger", "ärmel", "Zebra", "ΣΊΣΥΦΟΣ"} {
+		var created CreateWorkspaceResponse
+		w := h.do(http.MethodPost, "/api/workspaces", owner, CreateWorkspaceRequest{Name: name}, &created)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		ids[name] = created.WorkspaceID
+	}
+	list := func(query string) []string {
+		var listed []WorkspaceSummary
+		w := h.do(http.MethodGet, "/api/workspaces?"+query, owner, nil, &listed)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		names := make([]string, len(listed))
+		for i, s := range listed {
+			names[i] = s.Name
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"Ärger", "ärmel"}, list("sort=name&q="+url.QueryEscape("ÄR")))
+	assert.Equal(t, []string{"ΣΊΣΥΦΟΣ"}, list("q="+url.QueryEscape("σίσυφος")))
+	assert.Equal(t, []string{}, list("q=nothing"))
+	w := h.do(http.MethodGet, "/api/workspaces?q=nothing", owner, nil, nil)
+	assert.JSONEq(t, "[]", w.Body.String())
+	assert.Equal(t, []string{"Zebra", "Ärger", "ärmel", "ΣΊΣΥΦΟΣ"}, list("sort=name"))
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodGet, "/api/workspaces?sort=size", owner, nil, nil).Code)
+
+	editor := "editor-" + uuid.New().String()
+	h.seedMembership(ids["Zebra"], editor, roleEditor, "")
+	ws := "/api/workspaces/" + ids["Zebra"]
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPatch, ws, editor, RenameWorkspaceRequest{Name: "Mine"}, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, h.do(http.MethodPatch, ws, owner, RenameWorkspaceRequest{Name: "   "}, nil).Code)
+	w = h.do(http.MethodPatch, ws, owner, RenameWorkspaceRequest{Name: "Ärmelkanal"}, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"Ärger", "ärmel", "Ärmelkanal"}, list("sort=name&q=%C3%A4r"))
+}
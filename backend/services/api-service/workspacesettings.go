@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// workspaceSettingsRef returns the document holding a workspace's settings.
+func (ac *ApiController) workspaceSettingsRef(workspaceID string) *docstore.DocumentRef {
+	return ac.FirestoreClient.Collection("workspaces").Doc(workspaceID).Collection("meta").Doc("settings")
+}
+
+// loadWorkspaceSettings returns a workspace's settings; a workspace that never saved any has
+// the zero value.
+func (ac *ApiController) loadWorkspaceSettings(ctx context.Context, workspaceID string) (WorkspaceSettings, error) {
+	var settings WorkspaceSettings
+	snap, err := ac.workspaceSettingsRef(workspaceID).Get(ctx)
+	if isNotFound(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("failed to read workspace settings: %w", err)
+	}
+	if err := snap.DataTo(&settings); err != nil {
+		return settings, fmt.Errorf("failed to parse workspace settings: %w", err)
+	}
+	return settings, nil
+}
+
+// cleanEntrypointPath cleans an entrypoint path and reports whether it stays inside the
+// workspace.
+func cleanEntrypointPath(entrypoint string) (string, bool) {
+	cleaned := filepath.Clean(entrypoint)
+	if cleaned == "." || strings.HasPrefix(cleaned, "..") {
+		return "", false
+	}
+	return cleaned, true
+}
+
+// validateWorkspaceSettings checks a settings request and returns the settings to store.
+func (ac *ApiController) validateWorkspaceSettings(req WorkspaceSettingsRequest) (WorkspaceSettings, error) {
+	settings := WorkspaceSettings{
+		DefaultLanguage: req.DefaultLanguage,
+		AutoRunOnSync:   req.AutoRunOnSync,
+		RagEnabled:      req.RagEnabled,
+	}
+	if settings.DefaultLanguage != "" && !slices.Contains(supportedLanguages, settings.DefaultLanguage) {
+		return settings, fmt.Errorf("defaultLanguage must be one of: %s", strings.Join(supportedLanguages, ", "))
+	}
+	if req.DefaultEntrypoint == "" {
+		return settings, nil
+	}
+	entrypoint, ok := cleanEntrypointPath(req.DefaultEntrypoint)
+	if !ok {
+		return settings, fmt.Errorf("defaultEntrypoint is not a valid file path")
+	}
+	settings.DefaultEntrypoint = entrypoint
+	if settings.DefaultLanguage != "" {
+		limits, err := ac.resolveExecutionLimits(settings.DefaultLanguage, ExecutionOptions{})
+		if err != nil {
+			return settings, err
+		}
+		if !limits.allowsEntrypoint(entrypoint) {
+			return settings, fmt.Errorf("defaultEntrypoint must have one of these extensions for %s: %s", settings.DefaultLanguage, strings.Join(limits.EntrypointExtensions, ", "))
+		}
+	}
+	return settings, nil
+}
+
+// GetWorkspaceSettings returns a workspace's settings (editors and owners).
+func (ac *ApiController) GetWorkspaceSettings(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "GetWorkspaceSettings"})
+
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleEditor, logCtx) == nil {
+		return
+	}
+	settings, err := ac.loadWorkspaceSettings(c.Request.Context(), workspaceID)
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to load workspace settings.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace settings"})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateWorkspaceSettings replaces a workspace's settings (editors and owners) and bumps the
+// workspace's updated_at.
+func (ac *ApiController) UpdateWorkspaceSettings(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"workspace_id": workspaceID, "user_id": userID, "handler": "UpdateWorkspaceSettings"})
+
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleEditor, logCtx) == nil {
+		return
+	}
+	var req WorkspaceSettingsRequest
+	if err := ac.bindJSON(c, &req); err != nil {
+		return
+	}
+	settings, err := ac.validateWorkspaceSettings(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := NowISO8601()
+	settings.UpdatedAt, settings.UpdatedBy = now, userID
+	err = ac.FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *docstore.Transaction) error {
+		if err := tx.Set(ac.workspaceSettingsRef(workspaceID), settings); err != nil {
+			return err
+		}
+		return tx.Update(ac.FirestoreClient.Collection("workspaces").Doc(workspaceID), []docstore.Update{
+			{Path: "updated_at", Value: now},
+		})
+	})
+	if err != nil {
+		logCtx.WithError(err).Error("Failed to update workspace settings.")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update workspace settings"})
+		return
+	}
+
+	ac.recordActivity(ctx, workspaceID, userID, activitySettingsChanged, map[string]interface{}{
+		"default_language":   settings.DefaultLanguage,
+		"default_entrypoint": settings.DefaultEntrypoint,
+		"auto_run_on_sync":   settings.AutoRunOnSync,
+		"rag_enabled":        settings.RagEnabled,
+	})
+	logCtx.Info("Workspace settings updated.")
+	c.JSON(http.StatusOK, settings)
+}
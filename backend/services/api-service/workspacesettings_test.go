@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateWorkspaceSettings(t *testing.T) {
+	ac := limitsTestController()
+
+	settings, err := ac.validateWorkspaceSettings(WorkspaceSettingsRequest{DefaultLanguage: "python", DefaultEntrypoint: "./src/../main.py", RagEnabled: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "main.py", settings.DefaultEntrypoint, "cleaned")
+		assert.True(t, settings.RagEnabled)
+	}
+	_, err = ac.validateWorkspaceSettings(WorkspaceSettingsRequest{})
+	assert.NoError(t, err, "every default may be cleared")
+
+	invalid := map[string]WorkspaceSettingsRequest{
+		"unknown language":   {DefaultLanguage: "cobol"},
+		"escapes workspace":  {DefaultEntrypoint: "../main.py"},
+		"workspace root":     {DefaultEntrypoint: "./"},
+		"wrong extension":    {DefaultLanguage: "python", DefaultEntrypoint: "main.rb"},
+		"language mismatch":  {DefaultLanguage: "python", DefaultEntrypoint: "src/app.js"},
+		"traversal in clean": {DefaultEntrypoint: "src/../../etc/passwd"},
+	}
+	for name, req := range invalid {
+		_, err := ac.validateWorkspaceSettings(req)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestHandlers_WorkspaceSettings(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	editor, viewer := "editor-"+uuid.New().String(), "viewer-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, editor, roleEditor, "")
+	h.seedMembership(workspaceID, viewer, roleViewer, "")
+	settingsPath := "/api/workspaces/" + workspaceID + "/settings"
+
+	var settings WorkspaceSettings
+	w := h.do(http.MethodGet, settingsPath, editor, nil, &settings)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, WorkspaceSettings{}, settings, "defaults before anything is saved")
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodGet, settingsPath, viewer, nil, nil).Code)
+	assert.Equal(t, http.StatusForbidden, h.do(http.MethodPut, settingsPath, viewer, WorkspaceSettingsRequest{}, nil).Code)
+
+	for _, req := range []WorkspaceSettingsRequest{
+		{DefaultLanguage: "ruby"},
+		{DefaultEntrypoint: "../../main.py"},
+		{DefaultLanguage: "python", DefaultEntrypoint: "main.txt"},
+	} {
+		w = h.do(http.MethodPut, settingsPath, editor, req, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "%+v", req)
+	}
+	before, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	assert.NoError(t, err)
+
+	w = h.do(http.MethodPut, settingsPath, editor, WorkspaceSettingsRequest{DefaultLanguage: "python", DefaultEntrypoint: "src/app.py", AutoRunOnSync: true}, &settings)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, editor, settings.UpdatedBy)
+	after, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, settings.UpdatedAt, after.UpdatedAt)
+		assert.NotEqual(t, before.UpdatedAt, after.UpdatedAt)
+	}
+
+	var stored WorkspaceSettings
+	h.do(http.MethodGet, settingsPath, owner, nil, &stored)
+	assert.Equal(t, settings, stored)
+}
+
+func TestHandlers_ExecuteUsesWorkspaceSettings(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "src/app.py", "print('hi')")
+	executePath := "/api/workspaces/" + workspaceID + "/execute"
+
+	w := h.do(http.MethodPost, executePath, owner, ExecuteAuthRequest{}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "no defaults to fall back to")
+
+	w = h.do(http.MethodPut, "/api/workspaces/"+workspaceID+"/settings", owner, WorkspaceSettingsRequest{DefaultLanguage: "python", DefaultEntrypoint: "src/app.py"}, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var submitted ExecuteAuthResponse
+	w = h.do(http.MethodPost, executePath, owner, ExecuteAuthRequest{Input: "42"}, &submitted)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	snap, err := h.fs.Collection(h.ac.FirestoreJobsCollection).Doc(submitted.JobID).Get(context.Background())
+	if assert.NoError(t, err) {
+		var job Job
+		assert.NoError(t, snap.DataTo(&job))
+		assert.Equal(t, "python", job.Language)
+		assert.Equal(t, "src/app.py", job.EntrypointFile)
+	}
+
+	// An explicit entrypoint still wins over the default.
+	w = h.do(http.MethodPost, executePath, owner, ExecuteAuthRequest{EntrypointFile: "missing.rb"}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
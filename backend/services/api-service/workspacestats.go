@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/1liale/api-service/docstore"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// workspaceStatsJobWindow is how far back GET /workspaces/:workspaceId/stats counts jobs.
+	workspaceStatsJobWindow = 30 * 24 * time.Hour
+	// workspaceCountConcurrency is how many workspaces ListWorkspaces counts at once.
+	workspaceCountConcurrency = 8
+)
+
+// aggregateValue reads an integer-valued aggregation result. Sums over integers come back as
+// integers, but Firestore switches to doubles once a sum overflows, so both are accepted.
+func aggregateValue(res docstore.AggregationResult, alias string) int64 {
+	v, _ := res[alias].(*firestorepb.Value)
+	if d, ok := v.GetValueType().(*firestorepb.Value_DoubleValue); ok {
+		return int64(d.DoubleValue)
+	}
+	return v.GetIntegerValue()
+}
+
+// GetWorkspaceStats reports a workspace's size, members and recent jobs with aggregate
+// queries, so no file or job is read however large the workspace is. Each figure is queried
+// on its own; one that fails is named in partial instead of failing the request.
+func (ac *ApiController) GetWorkspaceStats(c *gin.Context) {
+	workspaceID := c.Param("workspaceId")
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	logCtx := log.WithFields(log.Fields{"handler": "GetWorkspaceStats", "workspace_id": workspaceID, "user_id": userID})
+
+	if ac.requireWorkspaceRole(c, workspaceID, userID, roleViewer, logCtx) == nil {
+		return
+	}
+	ws, err := ac.loadWorkspace(ctx, workspaceID)
+	if err != nil {
+		if isNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+			return
+		}
+		logCtx.WithError(err).Error("Failed to load workspace")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workspace"})
+		return
+	}
+
+	since := time.Now().UTC().Add(-workspaceStatsJobWindow)
+	stats := WorkspaceStats{
+		WorkspaceID:      workspaceID,
+		WorkspaceVersion: ws.WorkspaceVersion,
+		JobsByStatus:     make(map[string]int64, len(workspaceJobStatusFilters)),
+		JobsSince:        TimeToISO8601(since),
+	}
+	aggregate := func(field string, q *docstore.AggregationQuery, fn func(docstore.AggregationResult)) {
+		res, err := q.Get(ctx)
+		if err != nil {
+			logCtx.WithError(err).WithField("field", field).Warn("Workspace stats aggregation failed.")
+			stats.Partial = append(stats.Partial, field)
+			return
+		}
+		fn(res)
+	}
+
+	files := ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", workspaceID))
+	aggregate("files", files.Where("type", "==", "file").NewAggregationQuery().WithCount("count").WithSum("size", "bytes"),
+		func(res docstore.AggregationResult) {
+			stats.Files = aggregateValue(res, "count")
+			stats.TotalBytes = aggregateValue(res, "bytes")
+		})
+	aggregate("folders", files.Where("type", "==", "folder").NewAggregationQuery().WithCount("count"),
+		func(res docstore.AggregationResult) { stats.Folders = aggregateValue(res, "count") })
+	aggregate("members", ac.FirestoreClient.Collection("workspace_memberships").Where("workspace_id", "==", workspaceID).NewAggregationQuery().WithCount("count"),
+		func(res docstore.AggregationResult) { stats.Members = aggregateValue(res, "count") })
+
+	jobs := ac.FirestoreClient.Collection(ac.FirestoreJobsCollection).
+		Where("workspace_id", "==", workspaceID).
+		Where("execution_type", "==", executionTypeWorkspace).
+		Where("submitted_at", ">=", stats.JobsSince)
+	groups := make([]string, 0, len(workspaceJobStatusFilters))
+	for group := range workspaceJobStatusFilters {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups) // Stable partial lists
+	for _, group := range groups {
+		stats.JobsByStatus[group] = 0
+		aggregate("jobs."+group, jobs.Where("status", "in", workspaceJobStatusFilters[group]).NewAggregationQuery().WithCount("count"),
+			func(res docstore.AggregationResult) { stats.JobsByStatus[group] = aggregateValue(res, "count") })
+	}
+
+	if usage, err := ac.getUsageRollup(ctx, usageScopeWorkspace, workspaceID, usageMonth(time.Now())); err != nil {
+		logCtx.WithError(err).Warn("Failed to load workspace usage rollup.")
+		stats.Partial = append(stats.Partial, "usage")
+	} else {
+		stats.Usage = &usage
+	}
+
+	if len(stats.Partial) > 0 {
+		logCtx.WithField("partial", stats.Partial).Warn("Workspace stats are partial.")
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// countWorkspaceSummaries sets MemberCount and FileCount on each summary with aggregate count
+// queries, workspaceCountConcurrency workspaces at a time. A count that fails is logged and
+// reported as -1 for its workspace only.
+func (ac *ApiController) countWorkspaceSummaries(ctx context.Context, summaries []WorkspaceSummary, logCtx *log.Entry) {
+	count := func(workspaceID, field string, q *docstore.AggregationQuery) *int64 {
+		n := int64(-1)
+		res, err := q.Get(ctx)
+		if err != nil {
+			logCtx.WithError(err).WithFields(log.Fields{"workspace_id": workspaceID, "field": field}).Warn("Workspace count failed.")
+		} else {
+			n = aggregateValue(res, "count")
+		}
+		return &n
+	}
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workspaceCountConcurrency)
+	for i := range summaries {
+		summary := &summaries[i]
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			summary.MemberCount = count(summary.WorkspaceID, "members", ac.FirestoreClient.Collection("workspace_memberships").
+				Where("workspace_id", "==", summary.WorkspaceID).NewAggregationQuery().WithCount("count"))
+			summary.FileCount = count(summary.WorkspaceID, "files", ac.FirestoreClient.Collection(fmt.Sprintf("workspaces/%s/files", summary.WorkspaceID)).
+				Where("type", "==", "file").NewAggregationQuery().WithCount("count"))
+		}()
+	}
+	wg.Wait()
+}
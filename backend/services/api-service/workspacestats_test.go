@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateValue(t *testing.T) {
+	res := map[string]interface{}{
+		"count": &firestorepb.Value{ValueType: &firestorepb.Value_IntegerValue{IntegerValue: 7}},
+		"bytes": &firestorepb.Value{ValueType: &firestorepb.Value_DoubleValue{DoubleValue: 1e12}},
+	}
+	assert.Equal(t, int64(7), aggregateValue(res, "count"))
+	assert.Equal(t, int64(1e12), aggregateValue(res, "bytes"))
+	assert.Equal(t, int64(0), aggregateValue(res, "missing"))
+}
+
+func TestHandlers_GetWorkspaceStats(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner, viewer := "owner-"+uuid.New().String(), "viewer-"+uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedMembership(workspaceID, viewer, roleViewer, "")
+	h.seedFile(workspaceID, "main.py", "print('hi')")
+	h.seedFile(workspaceID, "src/util.py", "x = 1")
+	w := h.do(http.MethodPost, "/api/workspaces/"+workspaceID+"/folders", owner, CreateFolderRequest{Path: "src"}, nil)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	jobs := h.fs.Collection(h.ac.FirestoreJobsCollection)
+	seedJob := func(status string, submitted time.Time) {
+		_, err := jobs.Doc(uuid.New().String()).Set(context.Background(), Job{
+			Status:        status,
+			Language:      "python",
+			SubmittedAt:   TimeToISO8601(submitted),
+			UserID:        owner,
+			WorkspaceID:   workspaceID,
+			ExecutionType: executionTypeWorkspace,
+		})
+		if err != nil {
+			t.Fatalf("seed job: %v", err)
+		}
+	}
+	seedJob("completed", time.Now().Add(-time.Hour))
+	seedJob("completed", time.Now().Add(-48*time.Hour))
+	seedJob("failed", time.Now().Add(-time.Hour))
+	seedJob("completed", time.Now().Add(-40*24*time.Hour)) // Outside the window
+
+	var stats WorkspaceStats
+	w = h.do(http.MethodGet, "/api/workspaces/"+workspaceID+"/stats", viewer, nil, &stats)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, stats.Partial)
+	assert.Equal(t, workspaceID, stats.WorkspaceID)
+	assert.Equal(t, int64(2), stats.Files)
+	assert.Equal(t, int64(1), stats.Folders)
+	assert.Equal(t, int64(len("print('hi')")+len("x = 1")), stats.TotalBytes)
+	assert.Equal(t, int64(2), stats.Members)
+	assert.Equal(t, int64(2), stats.JobsByStatus["completed"])
+	assert.Equal(t, int64(1), stats.JobsByStatus["failed"])
+	assert.Equal(t, int64(0), stats.JobsByStatus["queued"])
+	assert.NotEmpty(t, stats.WorkspaceVersion)
+
+	w = h.do(http.MethodGet, fmt.Sprintf("/api/workspaces/%s/stats", workspaceID), "stranger-"+uuid.New().String(), nil, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestHandlers_ListWorkspacesCounts(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	busy := h.createWorkspace(owner)
+	empty := h.createWorkspace(owner)
+	h.seedMembership(busy, "editor-"+uuid.New().String(), roleEditor, "")
+	h.seedFile(busy, "main.py", "print(1)\n")
+	h.seedFile(busy, "lib/util.py", "x = 1\n")
+
+	var listed []WorkspaceSummary
+	w := h.do(http.MethodGet, "/api/workspaces", owner, nil, &listed)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	counts := map[string][2]int64{}
+	for _, s := range listed {
+		if assert.NotNil(t, s.MemberCount) && assert.NotNil(t, s.FileCount) {
+			counts[s.WorkspaceID] = [2]int64{*s.MemberCount, *s.FileCount}
+		}
+	}
+	assert.Equal(t, map[string][2]int64{busy: {2, 2}, empty: {1, 0}}, counts)
+
+	w = h.do(http.MethodGet, "/api/workspaces?includeCounts=false", owner, nil, nil)
+	assert.NotContains(t, w.Body.String(), "memberCount")
+	assert.NotContains(t, w.Body.String(), "fileCount")
+
+	// A failed count marks its workspace with -1 instead of failing the listing.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summaries := []WorkspaceSummary{{WorkspaceID: busy}}
+	h.ac.countWorkspaceSummaries(ctx, summaries, log.WithField("test", t.Name()))
+	assert.Equal(t, int64(-1), *summaries[0].MemberCount)
+	assert.Equal(t, int64(-1), *summaries[0].FileCount)
+}
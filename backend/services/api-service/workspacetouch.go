@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/1liale/api-service/docstore"
+	log "github.com/sirupsen/logrus"
+)
+
+// workspaceTouchInterval is the most often one instance writes a workspace's activity time.
+const workspaceTouchInterval = time.Minute
+
+// workspaceToucher debounces activity writes to one per interval per workspace. State is
+// per instance, which is fine: the time is only used to sort workspaces by recent use.
+type workspaceToucher struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newWorkspaceToucher(interval time.Duration) *workspaceToucher {
+	return &workspaceToucher{
+		interval: interval,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// allow reports whether workspaceID's activity time may be written now, and if so records it.
+func (wt *workspaceToucher) allow(workspaceID string, now time.Time) bool {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	if last, ok := wt.last[workspaceID]; ok && now.Sub(last) < wt.interval {
+		return false
+	}
+	wt.last[workspaceID] = now
+
+	// Opportunistically drop stale entries so the map stays bounded by recent activity.
+	if len(wt.last) > 1000 {
+		for k, t := range wt.last {
+			if now.Sub(t) >= wt.interval {
+				delete(wt.last, k)
+			}
+		}
+	}
+	return true
+}
+
+// forget lets the next touch of workspaceID through, after a write that failed.
+func (wt *workspaceToucher) forget(workspaceID string) {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	delete(wt.last, workspaceID)
+}
+
+// touchWorkspace records activity on a workspace by bumping updated_at and last_activity_at.
+// It never fails the caller: errors are logged, and writes within workspaceTouchInterval of
+// the last one are skipped.
+func (ac *ApiController) touchWorkspace(ctx context.Context, workspaceID string) {
+	if !ac.touches.allow(workspaceID, time.Now()) {
+		return
+	}
+	now := NowISO8601()
+	_, err := ac.FirestoreClient.Collection("workspaces").Doc(workspaceID).Update(ctx, []docstore.Update{
+		{Path: "updated_at", Value: now},
+		{Path: "last_activity_at", Value: now},
+	})
+	if err != nil {
+		ac.touches.forget(workspaceID)
+		log.WithError(err).WithField("workspace_id", workspaceID).Warn("Failed to record workspace activity time.")
+	}
+}
+
+// workspaceLastActivity returns when a workspace was last active. Workspaces untouched since
+// last_activity_at was introduced fall back to their last update, then their creation.
+func workspaceLastActivity(ws Workspace) string {
+	switch {
+	case ws.LastActivityAt != "":
+		return ws.LastActivityAt
+	case ws.UpdatedAt != "":
+		return ws.UpdatedAt
+	}
+	return ws.CreatedAt
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspaceToucher(t *testing.T) {
+	wt := newWorkspaceToucher(time.Minute)
+	now := time.Now()
+	assert.True(t, wt.allow("ws-1", now))
+	assert.False(t, wt.allow("ws-1", now.Add(30*time.Second)), "debounced")
+	assert.True(t, wt.allow("ws-2", now), "per workspace")
+	assert.True(t, wt.allow("ws-1", now.Add(time.Minute)))
+
+	wt.forget("ws-1")
+	assert.True(t, wt.allow("ws-1", now.Add(61*time.Second)), "a failed write is retried")
+}
+
+func TestWorkspaceLastActivity(t *testing.T) {
+	ws := Workspace{CreatedAt: "2025-01-01T00:00:00.000Z"}
+	assert.Equal(t, ws.CreatedAt, workspaceLastActivity(ws))
+	ws.UpdatedAt = "2025-02-01T00:00:00.000Z"
+	assert.Equal(t, ws.UpdatedAt, workspaceLastActivity(ws))
+	ws.LastActivityAt = "2025-03-01T00:00:00.000Z"
+	assert.Equal(t, ws.LastActivityAt, workspaceLastActivity(ws))
+}
+
+func TestHandlers_ExecutionTouchesWorkspace(t *testing.T) {
+	h := newHandlerHarness(t)
+	owner := "owner-" + uuid.New().String()
+	workspaceID := h.createWorkspace(owner)
+	h.seedFile(workspaceID, "main.py", "print(1)")
+
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, nil))
+	ws, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotEmpty(t, ws.LastActivityAt)
+	assert.Equal(t, ws.LastActivityAt, ws.UpdatedAt)
+
+	var listed []WorkspaceSummary
+	h.do(http.MethodGet, "/api/workspaces", owner, nil, &listed)
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, ws.LastActivityAt, listed[0].LastActivityAt)
+	}
+
+	// A second run inside the debounce window writes nothing.
+	assert.Equal(t, http.StatusOK, runMain(h, owner, workspaceID, nil))
+	again, err := h.ac.loadWorkspace(context.Background(), workspaceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ws.LastActivityAt, again.LastActivityAt)
+	}
+}
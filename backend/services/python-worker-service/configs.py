@@ -2,14 +2,13 @@ import os
 import logging
 import resource
 import boto3
-from google.cloud import firestore as google_firestore
 from botocore.client import BaseClient
 
 # Environment Variables
-GCP_PROJECT_ID = os.getenv("GCP_PROJECT_ID")
-COLLECTION_ID_JOBS = os.getenv("COLLECTION_ID_JOBS") 
 DEFAULT_EXECUTION_TIMEOUT_SEC = int(os.getenv("DEFAULT_EXECUTION_TIMEOUT_SEC", "30"))
+DEFAULT_EXECUTION_MEMORY_MB = int(os.getenv("DEFAULT_EXECUTION_MEMORY_MB", "256"))
 LOG_LEVEL = os.getenv("LOG_LEVEL")
+API_BASE_URL = os.getenv("API_BASE_URL") # Where job status changes are reported; also the ID token audience
 
 # R2/S3 Environment Variables
 R2_ACCOUNT_ID = os.getenv('R2_ACCOUNT_ID')
@@ -18,7 +17,6 @@ R2_SECRET_ACCESS_KEY = os.getenv('R2_SECRET_ACCESS_KEY')
 R2_BUCKET_NAME = os.getenv('R2_BUCKET_NAME')
 
 # Global clients - to be initialized by functions below
-firestore_client: google_firestore.Client | None = None
 s3_client: BaseClient | None = None  # Use BaseClient for the s3_client type hint
 
 # Configure logging
@@ -34,26 +32,11 @@ def set_execution_limits(cpu_time_sec: int = 10, memory_mb: int = 256, max_proce
     except Exception as e:
         logger.warning(f"Failed to set some resource limits (expected on some platforms): {e}")
 
-def get_firestore_client() -> google_firestore.Client | None:
-    return firestore_client
-
 def get_s3_client() -> BaseClient | None:  # Use BaseClient for the return type hint
     return s3_client
 
 def init_clients():
-    global firestore_client, s3_client
-
-    # Initialize Firestore
-    if GCP_PROJECT_ID:
-        try:
-            firestore_client = google_firestore.Client(project=GCP_PROJECT_ID)
-            logger.info("Firestore client initialized.")
-        except Exception as e:
-            logger.critical(f"Failed to initialize Firestore client: {e}", exc_info=True)
-            firestore_client = None # Ensure it's None on failure
-    else:
-        logger.critical("GCP_PROJECT_ID environment variable not set. Firestore client NOT initialized.")
-        firestore_client = None
+    global s3_client
 
     # Initialize S3 client for R2
     if R2_ACCOUNT_ID and R2_ACCESS_KEY_ID and R2_SECRET_ACCESS_KEY:
@@ -80,7 +63,7 @@ def init_clients():
         s3_client = None
 
 # Perform initial checks on import
-if not GCP_PROJECT_ID:
-    logger.warning("GCP_PROJECT_ID not set at import time.") # Log warning early
+if not API_BASE_URL:
+    logger.warning("API_BASE_URL not set at import time; jobs cannot be run.") # Log warning early
 if not all([R2_ACCOUNT_ID, R2_ACCESS_KEY_ID, R2_SECRET_ACCESS_KEY]):
     logger.warning("R2 client env vars not fully set at import time.") # Log warning early 
\ No newline at end of file
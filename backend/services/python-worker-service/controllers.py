@@ -1,33 +1,70 @@
+import json
 import subprocess
-from time_utils import now_iso8601  # Standardized ISO 8601 formatting
+import threading
+import time
+import urllib.error
+import urllib.request
+from functools import partial
 from pathlib import Path
+from time_utils import now_iso8601  # Standardized ISO 8601 formatting
 import tempfile # Added for TemporaryDirectory
 
 from fastapi import APIRouter, HTTPException # Using APIRouter for modularity
-from google.cloud import firestore as google_firestore # For type hinting
+from google.auth.transport.requests import Request as GoogleAuthRequest
+from google.oauth2 import id_token
 
-from models import CloudTaskPayload, CloudTaskAuthPayload
+from models import CloudTaskPayload, CloudTaskAuthPayload, WorkerFile
 from configs import (
     logger, 
-    get_firestore_client, 
     get_s3_client, 
     set_execution_limits,
-    COLLECTION_ID_JOBS, 
-    DEFAULT_EXECUTION_TIMEOUT_SEC
+    API_BASE_URL,
+    DEFAULT_EXECUTION_TIMEOUT_SEC,
+    DEFAULT_EXECUTION_MEMORY_MB
 )
 
 router = APIRouter()
 
-def _execute_python_code_direct(job_id: str, code: str, input_data: str | None) -> tuple[str | None, str | None, int]:
+HEARTBEAT_INTERVAL_SEC = 30
+STATUS_REPORT_ATTEMPTS = 3
+
+CANCEL_POLL_SEC = 1
+
+class _ExecutionStopped(Exception):
+    """Raised when a running execution was killed because its job was cancelled or lost."""
+
+def _run_process(args: list[str], input_data: str | None, timeout_sec: int, memory_mb: int, stop: threading.Event, cwd: str | None = None) -> subprocess.CompletedProcess:
+    """Runs args like subprocess.run with capture_output and timeout, checking stop every
+    CANCEL_POLL_SEC; the process is killed and _ExecutionStopped raised once it is set."""
+    deadline = time.monotonic() + timeout_sec
+    with subprocess.Popen(
+        args,
+        stdin=subprocess.PIPE if input_data is not None else subprocess.DEVNULL,
+        stdout=subprocess.PIPE,
+        stderr=subprocess.PIPE,
+        text=True,
+        cwd=cwd,
+        preexec_fn=partial(set_execution_limits, memory_mb=memory_mb)
+    ) as process:
+        pending_input = input_data
+        while True:
+            try:
+                stdout, stderr = process.communicate(pending_input, timeout=max(0, min(CANCEL_POLL_SEC, deadline - time.monotonic())))
+                return subprocess.CompletedProcess(args, process.returncode, stdout, stderr)
+            except subprocess.TimeoutExpired:
+                # communicate keeps the output read so far; the input is only sent once.
+                pending_input = None
+                if not stop.is_set() and time.monotonic() < deadline:
+                    continue
+                process.kill()
+                process.communicate()
+                if stop.is_set():
+                    raise _ExecutionStopped()
+                raise subprocess.TimeoutExpired(args, timeout_sec)
+
+def _execute_python_code_direct(job_id: str, code: str, input_data: str | None, timeout_sec: int, memory_mb: int, stop: threading.Event) -> tuple[str | None, str | None, int]:
     try:
-        process = subprocess.run(
-            ['python3', '-c', code],
-            input=input_data, 
-            text=True,
-            timeout=DEFAULT_EXECUTION_TIMEOUT_SEC,
-            capture_output=True,
-            preexec_fn=set_execution_limits
-        )
+        process = _run_process(['python3', '-c', code], input_data, timeout_sec, memory_mb, stop)
         if process.returncode == 0:
             return process.stdout, None, 0 
         else:
@@ -36,23 +73,18 @@ def _execute_python_code_direct(job_id: str, code: str, input_data: str | None)
             return process.stdout, error_output, 1 
     except subprocess.TimeoutExpired:
         logger.warning(f"Job {job_id} (direct): Code execution timed out.")
-        return None, f"Execution timed out after {DEFAULT_EXECUTION_TIMEOUT_SEC} seconds.", 2
+        return None, f"Execution timed out after {timeout_sec} seconds.", 2
+    except _ExecutionStopped:
+        logger.warning(f"Job {job_id} (direct): Code execution stopped.")
+        return None, "The job was cancelled while running.", 4
     except Exception as e:
         logger.error(f"Job {job_id} (direct): Internal error: {e}", exc_info=True)
         return None, f"Internal worker error: {str(e)}", 3
 
-def _execute_python_script_in_dir(job_id: str, script_path: Path, exec_dir: Path, input_data: str | None) -> tuple[str | None, str | None, int]:
+def _execute_python_script_in_dir(job_id: str, script_path: Path, exec_dir: Path, input_data: str | None, timeout_sec: int, memory_mb: int, stop: threading.Event) -> tuple[str | None, str | None, int]:
     try:
         logger.info(f"Job {job_id}: Executing 'python3 {str(script_path)}' in '{exec_dir}'")
-        process = subprocess.run(
-            ['python3', str(script_path)],
-            text=True, 
-            timeout=DEFAULT_EXECUTION_TIMEOUT_SEC, 
-            capture_output=True,
-            cwd=str(exec_dir),
-            input=input_data,
-            preexec_fn=set_execution_limits 
-        )
+        process = _run_process(['python3', str(script_path)], input_data, timeout_sec, memory_mb, stop, cwd=str(exec_dir))
         if process.returncode == 0:
             return process.stdout, None, 0
         else:
@@ -61,119 +93,246 @@ def _execute_python_script_in_dir(job_id: str, script_path: Path, exec_dir: Path
             return process.stdout, error_output, 1
     except subprocess.TimeoutExpired:
         logger.warning(f"Job {job_id} (workspace): Code execution timed out.")
-        return None, f"Execution timed out after {DEFAULT_EXECUTION_TIMEOUT_SEC} seconds.", 2
+        return None, f"Execution timed out after {timeout_sec} seconds.", 2
+    except _ExecutionStopped:
+        logger.warning(f"Job {job_id} (workspace): Code execution stopped.")
+        return None, "The job was cancelled while running.", 4
     except Exception as e:
         logger.error(f"Job {job_id} (workspace): Internal error: {e}", exc_info=True)
         return None, f"Internal worker error: {str(e)}", 3
 
-@google_firestore.transactional
-def _update_job_in_transaction(transaction: google_firestore.Transaction, job_ref: google_firestore.DocumentReference, update_data: dict):
-    transaction.update(job_ref, update_data)
+def _post_to_api(path: str, body: dict, timeout: int) -> dict:
+    """POSTs body to the API with the worker's ID token and returns the decoded JSON answer."""
+    token = id_token.fetch_id_token(GoogleAuthRequest(), API_BASE_URL)
+    req = urllib.request.Request(
+        f"{API_BASE_URL}{path}",
+        data=json.dumps(body).encode(),
+        headers={"Authorization": f"Bearer {token}", "Content-Type": "application/json"},
+        method="POST",
+    )
+    with urllib.request.urlopen(req, timeout=timeout) as resp:
+        return json.loads(resp.read() or b"{}")
 
-def _update_firestore_job_status(job_id: str, job_doc_ref: google_firestore.DocumentReference, data_to_update: dict, stage_description: str):
-    firestore_client = get_firestore_client()
-    if not firestore_client:
-        logger.error(f"Job {job_id}: Firestore client N/A for '{stage_description}'.")
-        raise RuntimeError("Firestore client not available.")
+def _error_body(e: urllib.error.HTTPError) -> dict:
     try:
-        transaction = firestore_client.transaction()
-        _update_job_in_transaction(transaction, job_doc_ref, data_to_update)
-        logger.info(f"Job {job_id}: Firestore updated for '{stage_description}'. Status: {data_to_update.get('status')}")
-    except Exception as e:
-        logger.error(f"Job {job_id}: Firestore update FAILED for '{stage_description}': {e}", exc_info=True)
-        raise RuntimeError(f"Firestore update failed for job {job_id}") from e
-
-def _build_final_update_data(exec_status_code: int, output: str | None, error_details: str | None, current_status: str) -> dict:
-    # Generate standardized ISO 8601 timestamp matching JavaScript toISOString()
-    completed_at_time = now_iso8601()  # Exact JavaScript toISOString() format
-    data = {"updated_at": completed_at_time}
-    
-    if current_status.startswith("processing"):
-         data["processing_started_at"] = completed_at_time 
-
-    if exec_status_code == 0: 
-        data["status"] = "completed"
-        data["output"] = output or ""
-        data["error"] = None
-    else: 
-        data["status"] = "failed"
-        data["error"] = error_details or "Unknown error"
-        data["output"] = output or ""
-    
-    if exec_status_code == 2: data["failure_type"] = "timeout"
-    elif exec_status_code == 1: data["failure_type"] = "user_code_error"
-    elif exec_status_code == 3: data["failure_type"] = "worker_internal_error"
-    
-    data["completed_at"] = completed_at_time
-    return data
+        return json.loads(e.read() or b"{}")
+    except ValueError:
+        return {}
+
+def _report_job_status(job_id: str, report: dict) -> tuple[int, dict]:
+    """Reports a job status change to POST /internal/jobs/{id}/status, where the API records it and
+    runs the side effects of a job finishing. Returns the HTTP status and the decoded answer; a 409
+    carries the job's current status. Network errors and 5xx answers are retried, and RuntimeError
+    is raised once every attempt failed."""
+    last_error = None
+    for attempt in range(STATUS_REPORT_ATTEMPTS):
+        if attempt:
+            time.sleep(2 ** attempt)
+        try:
+            return 200, _post_to_api(f"/internal/jobs/{job_id}/status", report, timeout=30)
+        except urllib.error.HTTPError as e:
+            if e.code < 500:
+                return e.code, _error_body(e)
+            last_error = e
+        except Exception as e:
+            last_error = e
+        logger.warning(f"Job {job_id}: Reporting status '{report['status']}' failed (attempt {attempt + 1}): {last_error}")
+    raise RuntimeError(f"Failed to report status '{report['status']}' for job {job_id}") from last_error
+
+def _start_job(job_id: str, started_at: str) -> str:
+    """Reports a queued job as running since started_at and returns "started". Returns "expired"
+    instead when the API failed the job because its deadline passed while it waited in the queue,
+    or "finished" when the API already finished it; the job must not run in either case."""
+    code, body = _report_job_status(job_id, {"status": "running", "started_at": started_at})
+    if code == 409:
+        outcome = "expired" if body.get("failureType") == "expired_before_execution" else "finished"
+    elif code == 200:
+        outcome = "started"
+    else:
+        raise RuntimeError(f"API rejected the start of job {job_id} (HTTP {code}): {body.get('error')}")
+    logger.info(f"Job {job_id}: Start outcome '{outcome}'.")
+    return outcome
+
+def _skip_unstarted_job(job_id: str, outcome: str) -> dict:
+    if outcome == "expired":
+        logger.warning(f"Job {job_id}: Deadline passed before execution; not running it.")
+        return {"job_id": job_id, "message": "Job deadline passed before execution.", "final_status": "expired"}
+    logger.warning(f"Job {job_id}: Not running; the job was already finished by the API.")
+    return {"job_id": job_id, "message": "Job already finished by the API.", "final_status": "discarded"}
+
+def _build_final_report(exec_status_code: int, output: str | None, error_details: str | None, started_at: str) -> dict:
+    """Builds the final status report of a job started at started_at and finishing now; the API
+    charges the job's run time from the two."""
+    report = {"started_at": started_at, "finished_at": now_iso8601(), "output": output or ""}
+    if exec_status_code == 0:
+        return {**report, "status": "completed", "error": ""}
+    if exec_status_code == 4:
+        return {**report, "status": "cancelled", "error": error_details}
+    report.update(status="failed", error=error_details or "Unknown error")
+    if exec_status_code == 2: report["failure_type"] = "timeout"
+    elif exec_status_code == 1: report["failure_type"] = "user_code_error"
+    elif exec_status_code == 3: report["failure_type"] = "worker_internal_error"
+    return report
+
+def _finish_job(job_id: str, report: dict):
+    """Reports a job's final status and result. The API keeps a job it already finished (worker
+    lost, cancelled) as it is and answers 409; the result is discarded then."""
+    try:
+        code, body = _report_job_status(job_id, report)
+    except RuntimeError:
+        logger.critical(f"Job {job_id}: CRITICAL - FAILED TO REPORT FINAL RESULTS after execution.", exc_info=True)
+        return
+    if code == 409:
+        logger.warning(f"Job {job_id}: Result discarded; the API already finished the job as '{body.get('status')}'.")
+    elif code != 200:
+        logger.critical(f"Job {job_id}: CRITICAL - API rejected the final results (HTTP {code}): {body.get('error')}")
+    else:
+        logger.info(f"Job {job_id}: Final status '{report['status']}' reported.")
+
+class _JobHeartbeat:
+    """Reports a running job to the API every HEARTBEAT_INTERVAL_SEC so a long computation can be
+    told apart from a dead worker. `lost` is set when the API answers 409: the job already
+    finished (usually failed as worker_lost) and its result must not be reported again.
+    `cancelled` is set when the API answers cancelRequested because the user cancelled the job.
+    `stop_execution` is set in both cases; the job's execution is killed once it is."""
+
+    def __init__(self, job_id: str):
+        self.job_id = job_id
+        self.lost = threading.Event()
+        self.cancelled = threading.Event()
+        self.stop_execution = threading.Event()
+        self._stop = threading.Event()
+        self._thread = threading.Thread(target=self._run, name=f"heartbeat-{job_id}", daemon=True)
+
+    def __enter__(self):
+        if API_BASE_URL:
+            self._thread.start()
+        return self
+
+    def __exit__(self, *exc):
+        self._stop.set()
+        if self._thread.is_alive():
+            self._thread.join(timeout=5)
+        return False
+
+    def _run(self):
+        while True:
+            self._beat()
+            if self.stop_execution.is_set() or self._stop.wait(HEARTBEAT_INTERVAL_SEC):
+                return
+
+    def _beat(self):
+        try:
+            answer = _post_to_api(f"/internal/jobs/{self.job_id}/heartbeat", {}, timeout=10)
+            if answer.get("cancelRequested"):
+                logger.warning(f"Job {self.job_id}: Cancellation requested; stopping the execution.")
+                self.cancelled.set()
+                self.stop_execution.set()
+        except urllib.error.HTTPError as e:
+            if e.code == 409:
+                logger.warning(f"Job {self.job_id}: API reports the job already finished; its result will be discarded.")
+                self.lost.set()
+                self.stop_execution.set()
+            else:
+                logger.warning(f"Job {self.job_id}: Heartbeat rejected (HTTP {e.code}).")
+        except Exception as e:
+            logger.warning(f"Job {self.job_id}: Heartbeat failed: {e}")
+
+def _discard_lost_job(job_id: str) -> dict:
+    logger.warning(f"Job {job_id}: Not saving results; the job was finished by the API while it ran.")
+    return {"job_id": job_id, "message": "Job already finished by the API; result discarded.", "final_status": "discarded"}
+
+def _load_manifest(job_id: str, payload: CloudTaskAuthPayload) -> list[WorkerFile]:
+    """Returns the job's file list, fetching it from R2 when the API passed it by reference."""
+    if not payload.manifest_url:
+        return payload.files
+    with urllib.request.urlopen(payload.manifest_url, timeout=60) as resp:
+        files = [WorkerFile(**f) for f in json.load(resp)]
+    logger.info(f"Job {job_id}: Loaded manifest of {len(files)} files by reference.")
+    return files
+
+def _read_input_file(exec_dir: Path, file_path: str) -> str | None:
+    """Returns the contents of a downloaded workspace file to use as stdin, or None when it is
+    missing or resolves outside the execution directory."""
+    local_file = (exec_dir / file_path.lstrip('/')).resolve()
+    if not local_file.is_relative_to(exec_dir.resolve()) or not local_file.is_file():
+        return None
+    return local_file.read_text(errors="replace")
 
 @router.post("/execute")
 async def execute_direct_task(payload: CloudTaskPayload):
     job_id = payload.job_id
     logger.info(f"Job {job_id}: /execute. Lang: {payload.language}, Input: {len(payload.input or '')} chars.")
-    firestore_client = get_firestore_client()
-    if not firestore_client:
-        raise HTTPException(status_code=503, detail="Cannot connect to Firestore.")
+    if not API_BASE_URL:
+        raise HTTPException(status_code=503, detail="API_BASE_URL is not set; job status cannot be reported.")
 
-    job_doc_ref = firestore_client.collection(COLLECTION_ID_JOBS).document(job_id)
-    initial_status = "processing_direct"
+    started_at = now_iso8601()
     try:
-        _update_firestore_job_status(job_id, job_doc_ref, {"status": initial_status, "updated_at": now_iso8601()}, "initial status")
+        outcome = _start_job(job_id, started_at)
     except RuntimeError:
-        raise HTTPException(status_code=500, detail=f"Failed to set initial status for job {job_id}.")
-
-    output, error_details, exec_status_code = _execute_python_code_direct(job_id, payload.code, payload.input)
-    final_job_data = _build_final_update_data(exec_status_code, output, error_details, initial_status)
+        raise HTTPException(status_code=500, detail=f"Failed to report the start of job {job_id}.")
+    if outcome != "started":
+        return _skip_unstarted_job(job_id, outcome)
 
-    try:
-        _update_firestore_job_status(job_id, job_doc_ref, final_job_data, "final results")
-    except RuntimeError:
-        logger.critical(f"Job {job_id}: CRITICAL - FAILED TO SAVE FINAL RESULTS after execution.")
-        pass 
+    with _JobHeartbeat(job_id) as heartbeat:
+        output, error_details, exec_status_code = _execute_python_code_direct(
+            job_id, payload.code, payload.input,
+            payload.timeout_seconds or DEFAULT_EXECUTION_TIMEOUT_SEC,
+            payload.memory_mb or DEFAULT_EXECUTION_MEMORY_MB,
+            heartbeat.stop_execution,
+        )
+    if heartbeat.lost.is_set():
+        return _discard_lost_job(job_id)
+    final_report = _build_final_report(exec_status_code, output, error_details, started_at)
+    _finish_job(job_id, final_report)
 
-    logger.info(f"Job {job_id}: Direct exec completed. Status: {final_job_data.get('status')}.")
+    logger.info(f"Job {job_id}: Direct exec completed. Status: {final_report['status']}.")
     return {"job_id": job_id, "message": "Direct execution task processed."}
 
 @router.post("/execute_auth")
 async def execute_auth_task(payload: CloudTaskAuthPayload):
     job_id = payload.job_id
+    if payload.warm:
+        # Cold-start mitigation: reaching this handler means the instance is already up.
+        logger.info(f"Warm-up {job_id}: instance ready for WS {payload.workspace_id}.")
+        return {"job_id": job_id, "message": "Worker warm."}
     logger.info(f"Job {job_id}: /execute_auth. WS: {payload.workspace_id}, Entry: {payload.entrypoint_file}")
-    firestore_client = get_firestore_client()
+    if payload.dependency_manifests:
+        logger.info(f"Job {job_id}: Dependency manifests: {[f.file_path for f in payload.dependency_manifests]}")
     s3_client = get_s3_client()
 
     # Ensure essential clients are available
-    if not firestore_client or not s3_client:
+    if not API_BASE_URL or not s3_client:
         detail_msg = []
-        if not firestore_client: detail_msg.append("Firestore unavailable")
+        if not API_BASE_URL: detail_msg.append("API_BASE_URL not set")
         if not s3_client: detail_msg.append("R2 unavailable")
         raise HTTPException(status_code=503, detail=f"Service temporarily unavailable ({', '.join(detail_msg)}).")
 
-    job_doc_ref = firestore_client.collection(COLLECTION_ID_JOBS).document(job_id)
-    initial_status = "processing_auth_workspace"
+    started_at = now_iso8601()
     try:
-        # Set initial job status in Firestore
-        _update_firestore_job_status(job_id, job_doc_ref, {"status": initial_status, "updated_at": now_iso8601()}, "initial status")
+        outcome = _start_job(job_id, started_at)
     except RuntimeError:
-        raise HTTPException(status_code=500, detail=f"Failed to set initial status for job {job_id}.")
+        raise HTTPException(status_code=500, detail=f"Failed to report the start of job {job_id}.")
+    if outcome != "started":
+        return _skip_unstarted_job(job_id, outcome)
 
     try:
         # Create a temporary directory for workspace files, ensuring cleanup
-        with tempfile.TemporaryDirectory(prefix=f"job_{job_id}_") as temp_dir_name: 
+        with _JobHeartbeat(job_id) as heartbeat, tempfile.TemporaryDirectory(prefix=f"job_{job_id}_") as temp_dir_name:
             workspace_exec_dir = Path(temp_dir_name)
             logger.info(f"Job {job_id}: Created temporary execution directory: {workspace_exec_dir}")
-            _update_firestore_job_status(job_id, job_doc_ref, {"status": "fetching_from_r2", "updated_at": now_iso8601()}, "fetching code")
 
-            if not payload.files:
+            files = _load_manifest(job_id, payload)
+            if not files:
                 msg = "No files found in job payload manifest to download."
                 logger.error(f"Job {job_id}: {msg}")
-                final_job_data = _build_final_update_data(3, None, msg, initial_status)
-                _update_firestore_job_status(job_id, job_doc_ref, final_job_data, "final results - no files")
+                _finish_job(job_id, _build_final_report(3, None, msg, started_at))
                 return {"job_id": job_id, "message": msg, "final_status": "failed"}
             
-            logger.info(f"Job {job_id}: Found {len(payload.files)} files in manifest. Starting download from R2.")
+            logger.info(f"Job {job_id}: Found {len(files)} files in manifest. Starting download from R2.")
 
             # Download each file from the manifest provided in the payload
-            for file_to_download in payload.files:
+            for file_to_download in files:
                 s3_key = file_to_download.r2_object_key
                 relative_path = file_to_download.file_path
                 
@@ -193,33 +352,38 @@ async def execute_auth_task(payload: CloudTaskAuthPayload):
             if not entrypoint_script_local_path.is_file():
                 msg = f"Entrypoint '{payload.entrypoint_file}' not found in downloaded workspace. Checked path: {entrypoint_script_local_path}"
                 logger.error(f"Job {job_id}: {msg}")
-                final_job_data = _build_final_update_data(3, None, msg, initial_status)
-                _update_firestore_job_status(job_id, job_doc_ref, final_job_data, "final results - entrypoint missing")
+                _finish_job(job_id, _build_final_report(3, None, msg, started_at))
                 return {"job_id": job_id, "message": msg, "final_status": "failed"}
 
-            # Update Firestore status before running the code
-            _update_firestore_job_status(job_id, job_doc_ref, {"status": "running_auth_workspace", "updated_at": now_iso8601()}, "running code")
-            
+            input_data = payload.input
+            if payload.input_file:
+                input_data = _read_input_file(workspace_exec_dir, payload.input_file.file_path)
+                if input_data is None:
+                    msg = f"Input file '{payload.input_file.file_path}' not found in downloaded workspace."
+                    logger.error(f"Job {job_id}: {msg}")
+                    _finish_job(job_id, _build_final_report(3, None, msg, started_at))
+                    return {"job_id": job_id, "message": msg, "final_status": "failed"}
+
             # Execute the Python script from the temporary directory
             output, error_details, exec_status_code = _execute_python_script_in_dir(
-                job_id, Path(payload.entrypoint_file), workspace_exec_dir, payload.input 
+                job_id, Path(payload.entrypoint_file), workspace_exec_dir, input_data,
+                payload.timeout_seconds or DEFAULT_EXECUTION_TIMEOUT_SEC,
+                payload.memory_mb or DEFAULT_EXECUTION_MEMORY_MB,
+                heartbeat.stop_execution,
             )
-            # Update Firestore with final execution results
-            final_job_data = _build_final_update_data(exec_status_code, output, error_details, initial_status)
-            _update_firestore_job_status(job_id, job_doc_ref, final_job_data, "final results")
+            if heartbeat.lost.is_set():
+                return _discard_lost_job(job_id)
+            # Report the final execution results to the API
+            final_report = _build_final_report(exec_status_code, output, error_details, started_at)
+            _finish_job(job_id, final_report)
             
-            logger.info(f"Job {job_id}: Auth Workspace execution completed. Status: {final_job_data.get('status')}.")
+            logger.info(f"Job {job_id}: Auth Workspace execution completed. Status: {final_report['status']}.")
             return {"job_id": job_id, "message": "Auth workspace execution task processed."}
 
     except Exception as e: # Catch-all for outer try, including TemporaryDirectory issues or R2 download
         logger.error(f"Job {job_id}: Unhandled exception in /execute_auth: {e}", exc_info=True)
-        try:
-            # Attempt to update Firestore with an error status on unhandled exceptions
-            final_job_data = _build_final_update_data(3, None, f"Unhandled worker exception: {str(e)}", initial_status)
-            _update_firestore_job_status(job_id, job_doc_ref, final_job_data, "final results - unhandled exception")
-        except Exception as firestore_e:
-            # Log critical failure if Firestore update fails after an unhandled exception
-            logger.critical(f"Job {job_id}: CRITICAL - FAILED TO UPDATE Firestore after unhandled exception: {firestore_e}")
+        # Report the job as failed so it does not stay running until it is presumed lost
+        _finish_job(job_id, _build_final_report(3, None, f"Unhandled worker exception: {str(e)}", started_at))
         raise HTTPException(status_code=500, detail=f"Internal error processing job {job_id}.")
 
 @router.get("/")
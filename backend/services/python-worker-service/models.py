@@ -6,6 +6,10 @@ class CloudTaskPayload(BaseModel):
     code: str
     language: str # Language field, though python-worker only handles python
     input: Optional[str] = None
+    timeout_seconds: Optional[int] = None # Resolved by the API from per-language limits
+    memory_mb: Optional[int] = None
+    notify_completion: bool = False # Unused: every status change is reported to the API
+    deadline: Optional[str] = None # ISO 8601; the job is not run if picked up after it
 
 class WorkerFile(BaseModel):
     r2_object_key: str = Field(..., alias="r2_object_key")
@@ -17,14 +21,16 @@ class CloudTaskAuthPayload(BaseModel):
     entrypoint_file: str
     language: str
     input: Optional[str] = None
+    input_file: Optional[WorkerFile] = None # Workspace file fed to stdin instead of input; also in the manifest
     r2_bucket_name: str
-    files: List[WorkerFile]
-
-# Optional: A common model for updating Firestore job status
-class JobStatusUpdate(BaseModel):
-    status: str
-    output: Optional[str] = None
-    error: Optional[str] = None
+    files: List[WorkerFile] = []
+    manifest_url: Optional[str] = None # Presigned GET for the file list when it was too large to inline
+    warm: bool = False # Warm-up ping: no job document exists, nothing is executed
+    timeout_seconds: Optional[int] = None # Resolved by the API from per-language limits
+    memory_mb: Optional[int] = None
+    notify_completion: bool = False # Unused: every status change is reported to the API
+    deadline: Optional[str] = None # ISO 8601; the job is not run if picked up after it
+    dependency_manifests: List[WorkerFile] = [] # requirements.txt etc. found in the manifest; always inline
 
 class CodeExecutionResult(BaseModel):
     output: Optional[str] = None
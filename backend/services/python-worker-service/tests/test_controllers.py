@@ -0,0 +1,114 @@
+import io
+import json
+import threading
+import time
+import urllib.error
+from unittest.mock import patch
+
+import pytest
+
+import controllers
+
+
+def _http_error(code: int, body: dict) -> urllib.error.HTTPError:
+    return urllib.error.HTTPError("http://api.test", code, "error", {}, io.BytesIO(json.dumps(body).encode()))
+
+
+@pytest.fixture(autouse=True)
+def no_backoff():
+    with patch.object(controllers.time, "sleep"):
+        yield
+
+
+# --- Job status reports ---
+
+@patch.object(controllers, "_post_to_api")
+def test_start_job_reports_running(mock_post):
+    mock_post.return_value = {"jobId": "job-1", "status": "running"}
+
+    assert controllers._start_job("job-1", "2026-01-02T03:04:05.000Z") == "started"
+    path, body = mock_post.call_args.args[:2]
+    assert path == "/internal/jobs/job-1/status"
+    assert body == {"status": "running", "started_at": "2026-01-02T03:04:05.000Z"}
+
+
+@pytest.mark.parametrize("answer, outcome", [
+    ({"status": "failed", "failureType": "expired_before_execution"}, "expired"),
+    ({"status": "cancelled"}, "finished"),
+])
+@patch.object(controllers, "_post_to_api")
+def test_start_job_conflict(mock_post, answer, outcome):
+    mock_post.side_effect = _http_error(409, answer)
+
+    assert controllers._start_job("job-1", "2026-01-02T03:04:05.000Z") == outcome
+    assert mock_post.call_count == 1
+
+
+@patch.object(controllers, "_post_to_api")
+def test_report_job_status_retries_server_errors(mock_post):
+    mock_post.side_effect = [_http_error(503, {}), ConnectionError("reset"), {"status": "completed"}]
+
+    assert controllers._report_job_status("job-1", {"status": "completed"}) == (200, {"status": "completed"})
+    assert mock_post.call_count == 3
+
+
+@patch.object(controllers, "_post_to_api")
+def test_report_job_status_gives_up(mock_post):
+    mock_post.side_effect = _http_error(500, {})
+
+    with pytest.raises(RuntimeError):
+        controllers._report_job_status("job-1", {"status": "completed"})
+    assert mock_post.call_count == controllers.STATUS_REPORT_ATTEMPTS
+
+
+@patch.object(controllers, "now_iso8601", return_value="2026-01-02T03:04:07.500Z")
+def test_build_final_report(_):
+    started_at = "2026-01-02T03:04:05.000Z"
+    assert controllers._build_final_report(0, "hi\n", None, started_at) == {
+        "status": "completed", "output": "hi\n", "error": "",
+        "started_at": started_at, "finished_at": "2026-01-02T03:04:07.500Z",
+    }
+    report = controllers._build_final_report(2, None, "Execution timed out after 5 seconds.", started_at)
+    assert report["status"] == "failed"
+    assert report["failure_type"] == "timeout"
+    assert report["started_at"] == started_at
+
+
+# --- Cancellation ---
+
+@pytest.mark.parametrize("answer, cancelled", [
+    ({"status": "running", "cancelRequested": True}, True),
+    ({"status": "running", "cancelRequested": False}, False),
+])
+@patch.object(controllers, "_post_to_api")
+def test_heartbeat_observes_cancel_requested(mock_post, answer, cancelled):
+    mock_post.return_value = answer
+    heartbeat = controllers._JobHeartbeat("job-1")
+
+    heartbeat._beat()
+    assert mock_post.call_args.args[0] == "/internal/jobs/job-1/heartbeat"
+    assert heartbeat.cancelled.is_set() == cancelled
+    assert heartbeat.stop_execution.is_set() == cancelled
+    assert not heartbeat.lost.is_set()
+
+
+def test_execution_runs_to_completion():
+    output, error, code = controllers._execute_python_code_direct("job-1", "print(input())", "hi", 10, 256, threading.Event())
+    assert (output, error, code) == ("hi\n", None, 0)
+
+
+def test_execution_stopped_when_cancelled():
+    stop = threading.Event()
+    threading.Timer(0.2, stop.set).start()
+    started = time.monotonic()
+
+    output, error, code = controllers._execute_python_code_direct("job-1", "import time; time.sleep(30)", None, 60, 256, stop)
+    assert code == 4
+    assert time.monotonic() - started < 5, "the process must be killed, not waited for"
+    assert controllers._build_final_report(code, output, error, "2026-01-02T03:04:05.000Z")["status"] == "cancelled"
+
+
+def test_execution_times_out():
+    output, error, code = controllers._execute_python_code_direct("job-1", "import time; time.sleep(30)", None, 1, 256, threading.Event())
+    assert code == 2
+    assert error == "Execution timed out after 1 seconds."
@@ -14,7 +14,7 @@ app = FastAPI(
 @app.on_event("startup")
 async def startup_event():
     logger.info("Starting up Python Worker Service...")
-    init_clients() # Initialize Firestore and S3 clients from configs.py
+    init_clients() # Initialize the S3 client from configs.py
     logger.info("Clients initialized (or initialization attempted).")
 
 # Include the API routes